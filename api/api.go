@@ -0,0 +1,10 @@
+// Package api holds the OpenAPI description of the JSON API. The typed Go
+// client in api/client is generated from it.
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3.1 document served at /api/openapi.json.
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/rjnemo/auth/internal/service/oauth"
+)
+
+func runClients(ctx context.Context, clients *oauth.Registry, args []string, out io.Writer) error {
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return listClients(ctx, clients, out)
+	case "show":
+		if len(args) != 2 {
+			return errUsage
+		}
+		client, err := clients.Find(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return showClient(client, time.Now(), out)
+	case "create":
+		return createClient(ctx, clients, args[1:], out)
+	case "update":
+		return updateClient(ctx, clients, args[1:], out)
+	case "rotate-secret":
+		return rotateClientSecret(ctx, clients, args[1:], out)
+	case "delete":
+		if len(args) != 2 {
+			return errUsage
+		}
+		if err := clients.Delete(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Deleted client %s and the tokens issued to it.\n", args[1])
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+func listClients(ctx context.Context, clients *oauth.Registry, out io.Writer) error {
+	registered, err := clients.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tAUTH METHOD\tCREATED")
+	for _, client := range registered {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			client.ID,
+			client.Name,
+			client.Type,
+			client.AuthMethod,
+			client.CreatedAt.UTC().Format(time.RFC3339),
+		)
+	}
+	return w.Flush()
+}
+
+func showClient(client oauth.Client, now time.Time, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", client.ID)
+	fmt.Fprintf(w, "Name:\t%s\n", client.Name)
+	fmt.Fprintf(w, "Type:\t%s\n", client.Type)
+	fmt.Fprintf(w, "Auth method:\t%s\n", client.AuthMethod)
+	fmt.Fprintf(w, "Redirect URIs:\t%s\n", strings.Join(client.RedirectURIs, " "))
+	fmt.Fprintf(w, "Grant types:\t%s\n", strings.Join(client.GrantTypes, " "))
+	fmt.Fprintf(w, "Scopes:\t%s\n", strings.Join(client.Scopes, " "))
+	if len(client.Audiences) > 0 {
+		fmt.Fprintf(w, "Audiences:\t%s\n", strings.Join(client.Audiences, " "))
+	}
+	if client.JWKS != nil {
+		fmt.Fprintf(w, "JWKS keys:\t%d\n", len(client.JWKS.Keys))
+	}
+	for _, secret := range client.Secrets {
+		expires := "never"
+		if !secret.ExpiresAt.IsZero() {
+			expires = secret.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		state := "valid"
+		if !secret.Valid(now) {
+			state = "expired"
+		}
+		fmt.Fprintf(w, "Secret:\t%s created %s, expires %s (%s)\n",
+			secret.ID,
+			secret.CreatedAt.UTC().Format(time.RFC3339),
+			expires,
+			state,
+		)
+	}
+	return w.Flush()
+}
+
+// clientFlags registers the metadata flags shared by create and update.
+type clientFlags struct {
+	name         string
+	clientType   string
+	authMethod   string
+	redirectURIs listFlag
+	grantTypes   listFlag
+	scopes       listFlag
+	audiences    listFlag
+	jwksFile     string
+}
+
+func newClientFlagSet(name string, f *clientFlags) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&f.name, "name", "", "display name")
+	flags.StringVar(&f.clientType, "type", "", "confidential or public")
+	flags.StringVar(&f.authMethod, "auth-method", "", "token endpoint authentication method")
+	flags.Var(&f.redirectURIs, "redirect-uri", "allowed redirect URI")
+	flags.Var(&f.grantTypes, "grant-type", "allowed grant type")
+	flags.Var(&f.scopes, "scope", "allowed scope")
+	flags.Var(&f.audiences, "audience", "client ID service tokens may be addressed to")
+	flags.StringVar(&f.jwksFile, "jwks", "", "JSON Web Key Set file")
+	return flags
+}
+
+// apply overwrites the fields of reg whose flags were given on the command
+// line.
+func (f *clientFlags) apply(flags *flag.FlagSet, reg *oauth.ClientRegistration) error {
+	var err error
+	flags.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "name":
+			reg.Name = f.name
+		case "type":
+			reg.Type = oauth.ClientType(f.clientType)
+		case "auth-method":
+			reg.AuthMethod = f.authMethod
+		case "redirect-uri":
+			reg.RedirectURIs = f.redirectURIs
+		case "grant-type":
+			reg.GrantTypes = f.grantTypes
+		case "scope":
+			reg.Scopes = f.scopes
+		case "audience":
+			reg.Audiences = f.audiences
+		case "jwks":
+			reg.JWKS, err = readJWKS(f.jwksFile)
+		}
+	})
+	return err
+}
+
+func createClient(ctx context.Context, clients *oauth.Registry, args []string, out io.Writer) error {
+	var f clientFlags
+	flags := newClientFlagSet("clients create", &f)
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	var reg oauth.ClientRegistration
+	if err := f.apply(flags, &reg); err != nil {
+		return err
+	}
+	client, secret, err := clients.Register(ctx, reg)
+	if err != nil {
+		return err
+	}
+
+	if err := showClient(client, time.Now(), out); err != nil {
+		return err
+	}
+	if secret != "" {
+		fmt.Fprintf(out, "\nClient secret: %s\nStore it now; it cannot be shown again.\n", secret)
+	}
+	return nil
+}
+
+func updateClient(ctx context.Context, clients *oauth.Registry, args []string, out io.Writer) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	id := args[0]
+	var f clientFlags
+	flags := newClientFlagSet("clients update", &f)
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	client, err := clients.Find(ctx, id)
+	if err != nil {
+		return err
+	}
+	reg := oauth.ClientRegistration{
+		Name:         client.Name,
+		Type:         client.Type,
+		AuthMethod:   client.AuthMethod,
+		RedirectURIs: client.RedirectURIs,
+		GrantTypes:   client.GrantTypes,
+		Scopes:       client.Scopes,
+		JWKS:         client.JWKS,
+		Audiences:    client.Audiences,
+	}
+	if err := f.apply(flags, &reg); err != nil {
+		return err
+	}
+	// Only private_key_jwt clients keep a key set.
+	if reg.AuthMethod != oauth.AuthMethodPrivateKeyJWT {
+		reg.JWKS = nil
+	}
+
+	client, err = clients.Update(ctx, id, reg)
+	if err != nil {
+		return err
+	}
+	return showClient(client, time.Now(), out)
+}
+
+func rotateClientSecret(ctx context.Context, clients *oauth.Registry, args []string, out io.Writer) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	id := args[0]
+	flags := flag.NewFlagSet("clients rotate-secret", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	overlap := flags.Duration("overlap", oauth.DefaultSecretOverlap, "how long existing secrets stay valid")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	secret, err := clients.RotateSecret(ctx, id, *overlap)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Client secret: %s\nStore it now; it cannot be shown again.\n", secret)
+	if *overlap > 0 {
+		fmt.Fprintf(out, "Previous secrets stay valid until %s.\n", time.Now().Add(*overlap).UTC().Format(time.RFC3339))
+	} else {
+		fmt.Fprintln(out, "Previous secrets were revoked.")
+	}
+	return nil
+}
+
+func readJWKS(path string) (*jose.JSONWebKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks: %w", err)
+	}
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+	return &jwks, nil
+}
+
+// listFlag collects the values of a repeatable flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,171 @@
+// Command authctl performs administrative tasks against the auth database,
+// such as emergency signing key rotation and OAuth client management. It
+// reads the same environment as the server.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rjnemo/auth/internal/config"
+	"github.com/rjnemo/auth/internal/service/oauth"
+	"github.com/rjnemo/auth/internal/service/signing"
+)
+
+const usage = `Usage: authctl <command> [flags]
+
+Commands:
+  keys list            List published signing keys and their schedule.
+  keys rotate          Activate a new signing key now. Existing keys retire
+                       but stay published until the tokens they signed expire.
+  keys rotate -revoke  Also withdraw existing keys from the key set at once,
+                       invalidating every token they signed. Use when a key
+                       may be compromised.
+
+  clients list                   List registered OAuth clients.
+  clients show <id>              Show a client's metadata and secrets.
+  clients create -name <name> -redirect-uri <uri> [flags]
+                                 Register a client and print its secret.
+  clients update <id> [flags]    Change the given fields of a client.
+  clients rotate-secret <id> [-overlap 24h]
+                                 Issue a new secret. Existing secrets stay
+                                 valid for the overlap; 0 revokes them now.
+  clients delete <id>            Delete a client and everything issued to it.
+
+Client flags:
+  -name <name>                   Display name shown on the consent screen.
+  -type confidential|public      Client type; fixed once registered.
+  -auth-method <method>          client_secret_basic, client_secret_post,
+                                 private_key_jwt or none.
+  -redirect-uri <uri>            Allowed redirect URI; repeatable.
+  -grant-type <type>             Allowed grant type; repeatable.
+  -scope <scope>                 Allowed scope; repeatable.
+  -audience <client-id>          Client service tokens may be addressed to;
+                                 repeatable.
+  -jwks <file>                   JSON Web Key Set for private_key_jwt.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "authctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) < 2 || (args[0] != "keys" && args[0] != "clients") {
+		return errUsage
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		return fmt.Errorf("configuration: %w", err)
+	}
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer pool.Close()
+
+	if args[0] == "clients" {
+		return runClients(ctx, oauth.NewRegistry(oauth.NewSQLStore(pool)), args[1:], out)
+	}
+
+	keys, err := newSigningKeys(cfg.SigningKeys, signing.NewSQLStore(pool))
+	if err != nil {
+		return err
+	}
+
+	switch args[1] {
+	case "list":
+		return listKeys(ctx, keys, out)
+	case "rotate":
+		return rotateKeys(ctx, keys, args[2:], out)
+	default:
+		return errUsage
+	}
+}
+
+func newSigningKeys(cfg config.SigningKeysConfig, store signing.Store) (*signing.Manager, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("signing keys are not configured: set AUTH_SIGNING_KEY_ENCRYPTION_KEY")
+	}
+	algorithm, err := signing.ParseAlgorithm(cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return signing.NewManager(store, cfg.EncryptionKey, signing.Schedule{
+		Algorithm:      algorithm,
+		RotationPeriod: cfg.RotationPeriod,
+		PublishAhead:   signing.DefaultPublishAhead,
+		TokenLifetime:  oauth.IDTokenLifetime,
+	})
+}
+
+func listKeys(ctx context.Context, keys *signing.Manager, out io.Writer) error {
+	published, err := keys.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATE\tACTIVATES\tRETIRES\tUNPUBLISHED")
+	for _, key := range published {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			key.ID,
+			key.Algorithm,
+			keyState(key, now),
+			key.ActivatesAt.UTC().Format(time.RFC3339),
+			key.RetiresAt.UTC().Format(time.RFC3339),
+			key.ExpiresAt.UTC().Format(time.RFC3339),
+		)
+	}
+	return w.Flush()
+}
+
+func keyState(key signing.Key, now time.Time) string {
+	switch {
+	case key.Active(now):
+		return "active"
+	case now.Before(key.ActivatesAt):
+		return "pending"
+	default:
+		return "retired"
+	}
+}
+
+func rotateKeys(ctx context.Context, keys *signing.Manager, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	revoke := flags.Bool("revoke", false, "withdraw existing keys from the key set immediately")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if *revoke {
+		if err := keys.Revoke(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Revoked existing signing keys and activated a new key. Running servers pick it up within a minute.")
+	} else {
+		if err := keys.RotateNow(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Activated a new signing key. Running servers pick it up within a minute.")
+	}
+	return listKeys(ctx, keys, out)
+}
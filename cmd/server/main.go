@@ -6,12 +6,17 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rjnemo/auth/internal/config"
 	"github.com/rjnemo/auth/internal/driver/logging"
+	"github.com/rjnemo/auth/internal/driver/saml"
 	"github.com/rjnemo/auth/internal/server"
 	"github.com/rjnemo/auth/internal/service/auth"
+	"github.com/rjnemo/auth/internal/service/oauth"
+	"github.com/rjnemo/auth/internal/service/scim"
+	"github.com/rjnemo/auth/internal/service/signing"
 )
 
 func main() {
@@ -47,6 +52,27 @@ func run(cfg *config.Config, logger *slog.Logger) error {
 	if err != nil {
 		return fmt.Errorf("initialise server: %w", err)
 	}
+	srv.UseSAMLStore(saml.NewSQLStore(pool))
+
+	if cfg.AuthorizationServer.Enabled() {
+		keys, err := newSigningKeys(cfg.SigningKeys, signing.NewSQLStore(pool))
+		if err != nil {
+			return fmt.Errorf("initialise signing keys: %w", err)
+		}
+		if err := keys.Rotate(ctx); err != nil {
+			return fmt.Errorf("load signing keys: %w", err)
+		}
+		go rotateSigningKeys(ctx, keys, logger)
+
+		srv.EnableAuthorizationServer(oauth.NewService(oauth.NewSQLStore(pool), service, keys, cfg.AuthorizationServer.Issuer))
+	}
+	if len(cfg.SCIMTenants) > 0 {
+		tenants := make([]scim.Tenant, 0, len(cfg.SCIMTenants))
+		for _, tenant := range cfg.SCIMTenants {
+			tenants = append(tenants, scim.Tenant{ID: tenant.ID, Name: tenant.Name, TokenHash: tenant.TokenHash})
+		}
+		srv.EnableSCIM(scim.NewService(scim.NewSQLStore(pool), service, tenants))
+	}
 
 	logger.Info("starting server", slog.String("addr", fmt.Sprintf("http://localhost%s", cfg.ListenAddr)))
 	if err := http.ListenAndServe(cfg.ListenAddr, srv.Router()); err != nil {
@@ -55,3 +81,35 @@ func run(cfg *config.Config, logger *slog.Logger) error {
 
 	return nil
 }
+
+func newSigningKeys(cfg config.SigningKeysConfig, store signing.Store) (*signing.Manager, error) {
+	algorithm, err := signing.ParseAlgorithm(cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return signing.NewManager(store, cfg.EncryptionKey, signing.Schedule{
+		Algorithm:      algorithm,
+		RotationPeriod: cfg.RotationPeriod,
+		PublishAhead:   signing.DefaultPublishAhead,
+		TokenLifetime:  oauth.IDTokenLifetime,
+	})
+}
+
+// rotateSigningKeys keeps the key schedule current and picks up keys created
+// by other instances or by an emergency rotation.
+func rotateSigningKeys(ctx context.Context, keys *signing.Manager, logger *slog.Logger) {
+	logger = logger.With(slog.String("component", "signing"))
+	ticker := time.NewTicker(signing.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := keys.Rotate(ctx); err != nil {
+				logger.Error("signing key rotation failed", slog.Any("error", err))
+			}
+		}
+	}
+}
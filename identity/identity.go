@@ -0,0 +1,452 @@
+// Package identity lets other Go services trust sign-ins issued by the auth
+// service. A Verifier accepts the auth_session cookie, when the service shares
+// the cookie domain, by asking the auth service about it, JWT bearer tokens
+// signed by the auth service's published keys, and, given a client secret,
+// opaque bearer tokens checked by introspection. Middleware puts the
+// caller's Identity on the request context, where handlers read it with
+// FromContext.
+package identity
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	// JWKSPath is where the auth service publishes its signing keys.
+	JWKSPath = "/jwks.json"
+	// ForwardAuthPath is where the auth service checks session cookies.
+	ForwardAuthPath = "/auth/verify"
+	// IntrospectionPath is where the auth service describes opaque tokens
+	// (RFC 7662).
+	IntrospectionPath = "/oauth2/introspect"
+
+	sessionSecretMinLength = 32
+	defaultFetchTimeout    = 10 * time.Second
+	maxIntrospectionBytes  = 1 << 16
+
+	// userHeader and emailHeader carry a checked session's account.
+	userHeader  = "X-Auth-User"
+	emailHeader = "X-Auth-Email"
+)
+
+// Source tells how a caller authenticated.
+type Source string
+
+const (
+	// SourceSession marks an identity read from the session cookie.
+	SourceSession Source = "session"
+	// SourceToken marks an identity read from a bearer token.
+	SourceToken Source = "token"
+)
+
+var (
+	// ErrNoCredentials signals the request carries neither a session cookie
+	// nor a bearer token.
+	ErrNoCredentials = errors.New("identity: no credentials")
+	// ErrInvalidCredentials signals the cookie or token failed verification.
+	ErrInvalidCredentials = errors.New("identity: invalid credentials")
+)
+
+// signingAlgorithms are the algorithms the auth service signs tokens with.
+var signingAlgorithms = []jose.SignatureAlgorithm{jose.ES256, jose.ES384, jose.RS256, jose.PS256, jose.EdDSA}
+
+// accessTokenTypes are the JOSE typ values of JWT access tokens (RFC 9068 §4).
+// ID tokens are signed with the same keys but typed JWT.
+var accessTokenTypes = []string{"at+jwt", "application/at+jwt"}
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	// Subject is the caller's stable user ID, or the client ID of a service
+	// calling with a client_credentials token.
+	Subject string
+	Email   string
+	Source  Source
+	// ClientID names the client a bearer token was issued to. It equals
+	// Subject when a service calls on its own behalf.
+	ClientID string
+	// Scopes lists the scopes granted to a bearer token.
+	Scopes []string
+	// ExpiresAt is when the bearer token or session stops being valid.
+	ExpiresAt time.Time
+}
+
+// IsService reports whether the caller is a service using a token issued to
+// itself rather than a user.
+func (i Identity) IsService() bool {
+	return i.ClientID != "" && i.ClientID == i.Subject
+}
+
+// HasScope reports whether the identity was granted scope.
+func (i Identity) HasScope(scope string) bool {
+	return slices.Contains(i.Scopes, scope)
+}
+
+// Config configures a Verifier. At least one of SessionSecret and Issuer must
+// be set.
+type Config struct {
+	// SessionSecret is the auth service's AUTH_SESSION_SECRET, which lets the
+	// Verifier check cookies itself. It also signs them, so whoever holds it
+	// can forge a session for any user, the auth service's own included:
+	// leave it empty outside the auth service's own deployment, and cookies
+	// are checked at SessionURL instead.
+	SessionSecret []byte
+	// CookieName defaults to DefaultCookieName.
+	CookieName string
+	// Issuer is the auth service's AUTH_OAUTH_ISSUER. Without it, bearer
+	// tokens are ignored.
+	Issuer string
+	// Audience is the aud a token must carry, normally this service's client
+	// ID. It is required with Issuer.
+	Audience string
+	// JWKSURL defaults to Issuer + JWKSPath.
+	JWKSURL string
+	// SessionURL defaults to Issuer + ForwardAuthPath. Without a
+	// SessionSecret, session cookies are sent there to be checked.
+	SessionURL string
+	// ClientSecret is the secret of the confidential client named by
+	// Audience, registered with client_secret_basic. With it, opaque bearer
+	// tokens, which the auth service issues for users, are checked at
+	// IntrospectionURL; without it only JWT access tokens are accepted.
+	ClientSecret string
+	// IntrospectionURL defaults to Issuer + IntrospectionPath.
+	IntrospectionURL string
+	// HTTPClient fetches the key set, checks sessions and introspects
+	// tokens; it defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	// Logger records rejected credentials; it defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Verifier checks the credentials on incoming requests.
+type Verifier struct {
+	secret     []byte
+	cookieName string
+	issuer     string
+	audience   string
+	keys       *KeySet
+	sessionURL string
+	// clientSecret authenticates the audience client at introspectionURL.
+	clientSecret     string
+	introspectionURL string
+	client           *http.Client
+	logger           *slog.Logger
+}
+
+// New returns a Verifier for cfg.
+func New(cfg Config) (*Verifier, error) {
+	if len(cfg.SessionSecret) == 0 && cfg.Issuer == "" {
+		return nil, errors.New("identity: set a session secret, an issuer or both")
+	}
+	if len(cfg.SessionSecret) > 0 && len(cfg.SessionSecret) < sessionSecretMinLength {
+		return nil, fmt.Errorf("identity: session secret must be at least %d bytes", sessionSecretMinLength)
+	}
+
+	v := &Verifier{
+		secret:     append([]byte(nil), cfg.SessionSecret...),
+		cookieName: cmp.Or(cfg.CookieName, DefaultCookieName),
+		logger:     cmp.Or(cfg.Logger, slog.Default()),
+	}
+	if cfg.Issuer != "" {
+		if cfg.Audience == "" {
+			return nil, errors.New("identity: audience is required to verify tokens")
+		}
+		v.issuer = strings.TrimSuffix(cfg.Issuer, "/")
+		v.audience = cfg.Audience
+		v.client = cfg.HTTPClient
+		if v.client == nil {
+			v.client = &http.Client{Timeout: defaultFetchTimeout}
+		}
+		jwksURL := cmp.Or(cfg.JWKSURL, v.issuer+JWKSPath)
+		v.keys = NewKeySet(v.client, func(context.Context) (string, error) { return jwksURL, nil })
+		if len(v.secret) == 0 {
+			v.sessionURL = cmp.Or(cfg.SessionURL, v.issuer+ForwardAuthPath)
+		}
+		if cfg.ClientSecret != "" {
+			v.clientSecret = cfg.ClientSecret
+			v.introspectionURL = cmp.Or(cfg.IntrospectionURL, v.issuer+IntrospectionPath)
+		}
+	}
+	return v, nil
+}
+
+// Verify returns the identity of the caller making r. A bearer token takes
+// precedence over the session cookie; an invalid one is not retried as a
+// cookie. It returns ErrNoCredentials or ErrInvalidCredentials on failure.
+func (v *Verifier) Verify(r *http.Request) (Identity, error) {
+	if token, ok := bearerToken(r); ok {
+		return v.VerifyToken(r.Context(), token)
+	}
+	cookie, err := r.Cookie(v.cookieName)
+	if err != nil {
+		return Identity{}, ErrNoCredentials
+	}
+	if len(v.secret) == 0 {
+		return v.VerifySessionOnline(r.Context(), cookie.Value)
+	}
+	return v.VerifySession(cookie.Value)
+}
+
+// VerifySession checks a session cookie value, including its iat and exp, so
+// a copied cookie stops working when the session expires.
+func (v *Verifier) VerifySession(value string) (Identity, error) {
+	if len(v.secret) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+	payload, err := OpenSession(value, v.secret)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	var session struct {
+		Authenticated bool   `json:"authenticated"`
+		Email         string `json:"email"`
+		UserID        string `json:"user_id"`
+		IssuedAt      int64  `json:"iat"`
+		ExpiresAt     int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	// Anonymous visitors carry a signed cookie too, holding only a CSRF token.
+	if !session.Authenticated {
+		return Identity{}, ErrNoCredentials
+	}
+	if err := CheckSessionLifetime(session.IssuedAt, session.ExpiresAt, time.Now()); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if session.UserID == "" {
+		return Identity{}, fmt.Errorf("%w: session has no user id", ErrInvalidCredentials)
+	}
+	return Identity{Subject: session.UserID, Email: session.Email, Source: SourceSession, ExpiresAt: time.Unix(session.ExpiresAt, 0)}, nil
+}
+
+// VerifySessionOnline asks the auth service whether a session cookie value is
+// signed in, as reverse proxies do, so the service needs no session secret.
+// The auth service applies the session's expiry and refuses sessions of
+// deactivated accounts. It does not say why it refuses a cookie, so a refused
+// cookie counts as no credentials.
+func (v *Verifier) VerifySessionOnline(ctx context.Context, value string) (Identity, error) {
+	if v.sessionURL == "" {
+		return Identity{}, ErrNoCredentials
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.sessionURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: value})
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: check session: %v", ErrInvalidCredentials, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return Identity{}, ErrNoCredentials
+	default:
+		return Identity{}, fmt.Errorf("%w: check session: status %d", ErrInvalidCredentials, resp.StatusCode)
+	}
+	subject := resp.Header.Get(userHeader)
+	if subject == "" {
+		return Identity{}, fmt.Errorf("%w: check session: no %s header", ErrInvalidCredentials, userHeader)
+	}
+	return Identity{Subject: subject, Email: resp.Header.Get(emailHeader), Source: SourceSession}, nil
+}
+
+// VerifyToken checks a bearer token. A JWT must be an access token: its typ
+// header, its signature against the issuer's keys and its iss, aud and exp
+// claims are checked. Any other token is opaque and is introspected.
+func (v *Verifier) VerifyToken(ctx context.Context, raw string) (Identity, error) {
+	if v.keys == nil {
+		return Identity{}, ErrNoCredentials
+	}
+	if strings.Count(raw, ".") != 2 {
+		return v.IntrospectToken(ctx, raw)
+	}
+	token, err := jwt.ParseSigned(raw, signingAlgorithms)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if len(token.Headers) == 0 {
+		return Identity{}, fmt.Errorf("%w: token has no header", ErrInvalidCredentials)
+	}
+	typ, _ := token.Headers[0].ExtraHeaders[jose.HeaderType].(string)
+	if !slices.ContainsFunc(accessTokenTypes, func(t string) bool { return strings.EqualFold(t, typ) }) {
+		return Identity{}, fmt.Errorf("%w: token type %q is not an access token", ErrInvalidCredentials, typ)
+	}
+	kid := token.Headers[0].KeyID
+	now := time.Now()
+	key, err := v.keys.Key(ctx, kid, now)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	var claims jwt.Claims
+	var extra struct {
+		Email    string `json:"email"`
+		Scope    string `json:"scope"`
+		ClientID string `json:"client_id"`
+	}
+	if err := token.Claims(key, &claims, &extra); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Expiry == nil {
+		return Identity{}, fmt.Errorf("%w: token has no expiry", ErrInvalidCredentials)
+	}
+	if err := claims.Validate(jwt.Expected{
+		Issuer:      v.issuer,
+		AnyAudience: jwt.Audience{v.audience},
+		Time:        now,
+	}); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: token has no subject", ErrInvalidCredentials)
+	}
+
+	return Identity{
+		Subject:   claims.Subject,
+		Email:     extra.Email,
+		Source:    SourceToken,
+		ClientID:  extra.ClientID,
+		Scopes:    strings.Fields(extra.Scope),
+		ExpiresAt: claims.Expiry.Time(),
+	}, nil
+}
+
+// IntrospectToken asks the auth service whether an opaque access token is
+// active (RFC 7662), authenticating as the audience client. The auth service
+// does not describe a token's email, so the identity has none.
+func (v *Verifier) IntrospectToken(ctx context.Context, raw string) (Identity, error) {
+	if v.introspectionURL == "" {
+		return Identity{}, fmt.Errorf("%w: opaque token and no client secret to introspect it", ErrInvalidCredentials)
+	}
+	form := url.Values{"token": {raw}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.introspectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(v.audience, v.clientSecret)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: introspect token: %v", ErrInvalidCredentials, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("%w: introspect token: status %d", ErrInvalidCredentials, resp.StatusCode)
+	}
+
+	var token struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope"`
+		ClientID  string `json:"client_id"`
+		Subject   string `json:"sub"`
+		TokenType string `json:"token_type"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxIntrospectionBytes)).Decode(&token); err != nil {
+		return Identity{}, fmt.Errorf("%w: introspect token: %v", ErrInvalidCredentials, err)
+	}
+	switch {
+	case !token.Active:
+		return Identity{}, fmt.Errorf("%w: token is not active", ErrInvalidCredentials)
+	case !strings.EqualFold(token.TokenType, "Bearer"):
+		return Identity{}, fmt.Errorf("%w: token type %q is not an access token", ErrInvalidCredentials, token.TokenType)
+	case token.Issuer != v.issuer:
+		return Identity{}, fmt.Errorf("%w: token issued by %q", ErrInvalidCredentials, token.Issuer)
+	case token.Subject == "":
+		return Identity{}, fmt.Errorf("%w: token has no subject", ErrInvalidCredentials)
+	case !time.Now().Before(time.Unix(token.ExpiresAt, 0)):
+		return Identity{}, fmt.Errorf("%w: token has expired", ErrInvalidCredentials)
+	}
+	return Identity{
+		Subject:   token.Subject,
+		Source:    SourceToken,
+		ClientID:  token.ClientID,
+		Scopes:    strings.Fields(token.Scope),
+		ExpiresAt: time.Unix(token.ExpiresAt, 0),
+	}, nil
+}
+
+// Require is middleware that answers 401 unless the request carries a valid
+// session cookie or bearer token, and otherwise passes the caller's identity
+// to next on the request context.
+func (v *Verifier) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := v.Verify(r)
+		if err != nil {
+			v.reject(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// Optional is middleware that attaches the caller's identity when the request
+// carries valid credentials and lets anonymous requests through. Invalid
+// credentials are still refused so a bad token is not mistaken for no token.
+func (v *Verifier) Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := v.Verify(r)
+		switch {
+		case err == nil:
+			r = r.WithContext(NewContext(r.Context(), id))
+		case !errors.Is(err, ErrNoCredentials):
+			v.reject(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *Verifier) reject(w http.ResponseWriter, r *http.Request, err error) {
+	challenge := "Bearer"
+	if errors.Is(err, ErrInvalidCredentials) {
+		v.logger.Warn("identity rejected", slog.String("path", r.URL.Path), slog.Any("error", err))
+		if _, ok := bearerToken(r); ok {
+			challenge = `Bearer error="invalid_token"`
+		}
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity Require or Optional attached to ctx.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
@@ -0,0 +1,265 @@
+package identity_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rjnemo/auth/identity"
+	"github.com/rjnemo/auth/identity/identitytest"
+)
+
+func TestNewRequiresCredentialSource(t *testing.T) {
+	t.Parallel()
+
+	for name, cfg := range map[string]identity.Config{
+		"empty":        {},
+		"short secret": {SessionSecret: []byte("short")},
+		"issuer only":  {Issuer: "https://auth.example.com"},
+	} {
+		if _, err := identity.New(cfg); err == nil {
+			t.Fatalf("%s: expected configuration to be refused", name)
+		}
+	}
+}
+
+func TestVerifierSessionCookie(t *testing.T) {
+	t.Parallel()
+
+	issuer := identitytest.NewIssuer(t)
+	verifier, err := identity.New(identity.Config{SessionSecret: issuer.Secret})
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	want := identity.Identity{Subject: "user-1", Email: "user@example.com"}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(issuer.SessionCookie(t, want))
+	got, err := verifier.Verify(req)
+	if err != nil {
+		t.Fatalf("verify session: %v", err)
+	}
+	if got.Subject != want.Subject || got.Email != want.Email || got.Source != identity.SourceSession {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+
+	forged := issuer.SessionCookie(t, want)
+	forged.Value = identity.SignSession([]byte(`{"authenticated":true,"user_id":"admin"}`), []byte("a-different-secret-of-32-bytes!!"))
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(forged)
+	if _, err := verifier.Verify(req); !errors.Is(err, identity.ErrInvalidCredentials) {
+		t.Fatalf("expected forged cookie to be refused, got %v", err)
+	}
+
+	for name, cookie := range map[string]*http.Cookie{
+		"expired":    issuer.SessionCookie(t, identity.Identity{Subject: "user-1", ExpiresAt: time.Now().Add(-time.Minute)}),
+		"no expiry":  {Name: identity.DefaultCookieName, Value: identity.SignSession([]byte(`{"authenticated":true,"user_id":"user-1"}`), issuer.Secret)},
+		"no user id": issuer.SessionCookie(t, identity.Identity{Email: "user@example.com"}),
+	} {
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		if _, err := verifier.Verify(req); !errors.Is(err, identity.ErrInvalidCredentials) {
+			t.Fatalf("%s: expected the cookie to be refused, got %v", name, err)
+		}
+	}
+
+	anonymous := &http.Cookie{Name: identity.DefaultCookieName, Value: identity.SignSession([]byte(`{"csrf_token":"x"}`), issuer.Secret)}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(anonymous)
+	if _, err := verifier.Verify(req); !errors.Is(err, identity.ErrNoCredentials) {
+		t.Fatalf("expected anonymous session to carry no identity, got %v", err)
+	}
+}
+
+func TestVerifierSessionCookieOnline(t *testing.T) {
+	t.Parallel()
+
+	issuer := identitytest.NewIssuer(t)
+	verifier := issuer.Verifier(t)
+	want := identity.Identity{Subject: "user-1", Email: "user@example.com"}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(issuer.SessionCookie(t, want))
+	got, err := verifier.Verify(req)
+	if err != nil {
+		t.Fatalf("verify session: %v", err)
+	}
+	if got.Subject != want.Subject || got.Email != want.Email || got.Source != identity.SourceSession {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+
+	for name, cookie := range map[string]*http.Cookie{
+		"forged":  {Name: identity.DefaultCookieName, Value: identity.SignSession([]byte(`{"authenticated":true,"user_id":"admin"}`), []byte("a-different-secret-of-32-bytes!!"))},
+		"expired": issuer.SessionCookie(t, identity.Identity{Subject: "user-1", ExpiresAt: time.Now().Add(-time.Minute)}),
+	} {
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		if _, err := verifier.Verify(req); !errors.Is(err, identity.ErrNoCredentials) {
+			t.Fatalf("%s: expected the auth service to refuse the cookie, got %v", name, err)
+		}
+	}
+
+	unreachable, err := identity.New(identity.Config{Issuer: issuer.URL(), Audience: identitytest.DefaultAudience, SessionURL: issuer.URL() + "/missing", HTTPClient: http.DefaultClient})
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(issuer.SessionCookie(t, want))
+	if _, err := unreachable.Verify(req); !errors.Is(err, identity.ErrInvalidCredentials) {
+		t.Fatalf("expected a failed session check to be an error, got %v", err)
+	}
+}
+
+func TestVerifierBearerToken(t *testing.T) {
+	t.Parallel()
+
+	issuer := identitytest.NewIssuer(t)
+	verifier := issuer.Verifier(t)
+	want := identity.Identity{Subject: "user-1", Email: "user@example.com", Scopes: []string{"orders:read"}}
+
+	got, err := verifier.VerifyToken(t.Context(), issuer.Token(t, want))
+	if err != nil {
+		t.Fatalf("verify token: %v", err)
+	}
+	if got.Subject != want.Subject || got.Email != want.Email || got.Source != identity.SourceToken ||
+		!got.HasScope("orders:read") || got.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+	if got.IsService() {
+		t.Fatal("expected a user token not to be a service")
+	}
+
+	service := identity.Identity{Subject: "billing", ClientID: "billing", Scopes: []string{"orders:read"}}
+	if got, err := verifier.VerifyToken(t.Context(), issuer.Token(t, service)); err != nil || !got.IsService() || got.ClientID != "billing" {
+		t.Fatalf("expected a service identity, got %+v (%v)", got, err)
+	}
+
+	expired := want
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	for name, token := range map[string]string{
+		"expired":        issuer.Token(t, expired),
+		"wrong audience": issuer.TokenFor(t, want, "another-service"),
+		"other issuer":   identitytest.NewIssuer(t).Token(t, want),
+		"id token":       issuer.IDToken(t, want),
+		"malformed":      "not.a.jwt",
+		"unknown opaque": "not-a-jwt",
+	} {
+		if _, err := verifier.VerifyToken(t.Context(), token); !errors.Is(err, identity.ErrInvalidCredentials) {
+			t.Fatalf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifierOpaqueToken(t *testing.T) {
+	t.Parallel()
+
+	issuer := identitytest.NewIssuer(t)
+	verifier := issuer.Verifier(t)
+	want := identity.Identity{Subject: "user-1", ClientID: "web-app", Scopes: []string{"orders:read"}}
+
+	got, err := verifier.VerifyToken(t.Context(), issuer.OpaqueToken(t, want))
+	if err != nil {
+		t.Fatalf("verify token: %v", err)
+	}
+	if got.Subject != want.Subject || got.ClientID != want.ClientID || got.Source != identity.SourceToken ||
+		!got.HasScope("orders:read") || got.ExpiresAt.IsZero() || got.IsService() {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+
+	expired := want
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	if _, err := verifier.VerifyToken(t.Context(), issuer.OpaqueToken(t, expired)); !errors.Is(err, identity.ErrInvalidCredentials) {
+		t.Fatalf("expected an expired token to be refused, got %v", err)
+	}
+
+	config := issuer.Config()
+	config.ClientSecret = ""
+	withoutSecret, err := identity.New(config)
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	if _, err := withoutSecret.VerifyToken(t.Context(), issuer.OpaqueToken(t, want)); !errors.Is(err, identity.ErrInvalidCredentials) {
+		t.Fatalf("expected an opaque token to be refused without a client secret, got %v", err)
+	}
+
+	config.ClientSecret = "wrong"
+	wrongSecret, err := identity.New(config)
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	if _, err := wrongSecret.VerifyToken(t.Context(), issuer.OpaqueToken(t, want)); !errors.Is(err, identity.ErrInvalidCredentials) {
+		t.Fatalf("expected a refused introspection to be an error, got %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	issuer := identitytest.NewIssuer(t)
+	verifier := issuer.Verifier(t)
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identity.FromContext(r.Context())
+		if !ok {
+			_, _ = io.WriteString(w, "anonymous")
+			return
+		}
+		_, _ = io.WriteString(w, id.Subject)
+	})
+	user := identity.Identity{Subject: "user-1", Email: "user@example.com"}
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		prepare    func(*http.Request)
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "require token", handler: verifier.Require(echo), prepare: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+issuer.Token(t, user))
+		}, wantStatus: http.StatusOK, wantBody: "user-1"},
+		{name: "require cookie", handler: verifier.Require(echo), prepare: func(r *http.Request) {
+			r.AddCookie(issuer.SessionCookie(t, user))
+		}, wantStatus: http.StatusOK, wantBody: "user-1"},
+		{name: "require anonymous", handler: verifier.Require(echo), wantStatus: http.StatusUnauthorized},
+		{name: "require invalid token", handler: verifier.Require(echo), prepare: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer not-a-jwt")
+			r.AddCookie(issuer.SessionCookie(t, user))
+		}, wantStatus: http.StatusUnauthorized},
+		{name: "optional anonymous", handler: verifier.Optional(echo), wantStatus: http.StatusOK, wantBody: "anonymous"},
+		{name: "optional invalid token", handler: verifier.Optional(echo), prepare: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer not-a-jwt")
+		}, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.prepare != nil {
+				tt.prepare(req)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("expected a WWW-Authenticate challenge")
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Fatalf("expected body %q, got %q", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithIdentity(t *testing.T) {
+	t.Parallel()
+
+	want := identity.Identity{Subject: "user-1", Source: identity.SourceToken}
+	req := identitytest.WithIdentity(httptest.NewRequest(http.MethodGet, "/", nil), want)
+	if got, ok := identity.FromContext(req.Context()); !ok || got.Subject != want.Subject {
+		t.Fatalf("expected identity on context, got %+v %v", got, ok)
+	}
+}
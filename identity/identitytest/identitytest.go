@@ -0,0 +1,276 @@
+// Package identitytest mints identities that an identity.Verifier accepts, so
+// services using the identity package can test their handlers without running
+// the auth service.
+package identitytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/rjnemo/auth/identity"
+)
+
+const (
+	keyID = "test-key"
+	// DefaultAudience is the audience Config and Token use unless told otherwise.
+	DefaultAudience = "test-service"
+	// ClientSecret is the secret DefaultAudience introspects tokens with.
+	ClientSecret    = "test-service-secret"
+	tokenLifetime   = 5 * time.Minute
+	sessionLifetime = 12 * time.Hour
+)
+
+// WithIdentity returns a copy of r carrying id, as if it had passed through
+// identity middleware. It suits handler tests that do not exercise the
+// middleware itself.
+func WithIdentity(r *http.Request, id identity.Identity) *http.Request {
+	return r.WithContext(identity.NewContext(r.Context(), id))
+}
+
+// Issuer stands in for the auth service: it publishes a JWKS, checks session
+// cookies at identity.ForwardAuthPath, introspects opaque tokens at
+// identity.IntrospectionPath, and mints bearer tokens and session cookies
+// that a Verifier built from Config accepts.
+type Issuer struct {
+	// Secret is the session secret cookies are signed with.
+	Secret []byte
+	// sessions checks cookies with Secret, as the auth service does.
+	sessions *identity.Verifier
+	server   *httptest.Server
+	signer   jose.Signer
+	// idSigner types tokens JWT, as the auth service does ID tokens.
+	idSigner jose.Signer
+	key      *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// opaque maps the opaque tokens OpaqueToken minted to their identities.
+	opaque map[string]identity.Identity
+}
+
+// NewIssuer starts a fake issuer. The server is shut down when the test
+// completes.
+func NewIssuer(t testing.TB) *Issuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate issuer key: %v", err)
+	}
+	signingKey := jose.SigningKey{Algorithm: jose.ES256, Key: key}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("at+jwt").WithHeader(jose.HeaderKey("kid"), keyID))
+	if err != nil {
+		t.Fatalf("create signer: %v", err)
+	}
+	idSigner, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), keyID))
+	if err != nil {
+		t.Fatalf("create signer: %v", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatalf("generate session secret: %v", err)
+	}
+
+	sessions, err := identity.New(identity.Config{SessionSecret: secret})
+	if err != nil {
+		t.Fatalf("create session verifier: %v", err)
+	}
+
+	iss := &Issuer{Secret: secret, sessions: sessions, signer: signer, idSigner: idSigner, key: key, opaque: map[string]identity.Identity{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+identity.JWKSPath, iss.handleJWKS)
+	mux.HandleFunc("GET "+identity.ForwardAuthPath, iss.handleForwardAuth)
+	mux.HandleFunc("POST "+identity.IntrospectionPath, iss.handleIntrospection)
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+
+	return iss
+}
+
+// URL returns the issuer identifier.
+func (i *Issuer) URL() string {
+	return i.server.URL
+}
+
+// Config returns a verifier configuration trusting this issuer's cookies and
+// tokens for DefaultAudience. Like a downstream service, it holds no session
+// secret and has cookies and opaque tokens checked by the issuer.
+func (i *Issuer) Config() identity.Config {
+	return identity.Config{
+		Issuer:       i.server.URL,
+		Audience:     DefaultAudience,
+		ClientSecret: ClientSecret,
+		HTTPClient:   i.server.Client(),
+	}
+}
+
+// Verifier returns a verifier built from Config.
+func (i *Issuer) Verifier(t testing.TB) *identity.Verifier {
+	t.Helper()
+	v, err := identity.New(i.Config())
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	return v
+}
+
+// Token mints a bearer token for id addressed to DefaultAudience. It expires
+// at id.ExpiresAt, or in five minutes when that is zero.
+func (i *Issuer) Token(t testing.TB, id identity.Identity) string {
+	t.Helper()
+	return i.TokenFor(t, id, DefaultAudience)
+}
+
+// TokenFor is Token for another audience.
+func (i *Issuer) TokenFor(t testing.TB, id identity.Identity, audience string) string {
+	t.Helper()
+	return i.sign(t, i.signer, id, audience)
+}
+
+// IDToken mints an ID token for id addressed to DefaultAudience. Verifiers
+// refuse it, as they must not take ID tokens for access tokens.
+func (i *Issuer) IDToken(t testing.TB, id identity.Identity) string {
+	t.Helper()
+	return i.sign(t, i.idSigner, id, DefaultAudience)
+}
+
+func (i *Issuer) sign(t testing.TB, signer jose.Signer, id identity.Identity, audience string) string {
+	t.Helper()
+
+	now := time.Now()
+	expiresAt := id.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(tokenLifetime)
+	}
+	claims := jwt.Claims{
+		Issuer:   i.server.URL,
+		Subject:  id.Subject,
+		Audience: jwt.Audience{audience},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(expiresAt),
+	}
+	extra := map[string]any{}
+	if id.Email != "" {
+		extra["email"] = id.Email
+	}
+	if id.ClientID != "" {
+		extra["client_id"] = id.ClientID
+	}
+	if len(id.Scopes) > 0 {
+		extra["scope"] = strings.Join(id.Scopes, " ")
+	}
+	raw, err := jwt.Signed(signer).Claims(claims).Claims(extra).Serialize()
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return raw
+}
+
+// OpaqueToken mints an opaque access token for id, as the auth service issues
+// for users, which the issuer describes at identity.IntrospectionPath. It
+// expires at id.ExpiresAt, or in five minutes when that is zero.
+func (i *Issuer) OpaqueToken(t testing.TB, id identity.Identity) string {
+	t.Helper()
+
+	if id.ExpiresAt.IsZero() {
+		id.ExpiresAt = time.Now().Add(tokenLifetime)
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	i.mu.Lock()
+	i.opaque[token] = id
+	i.mu.Unlock()
+	return token
+}
+
+// SessionCookie mints a signed-in session cookie for id. It expires at
+// id.ExpiresAt, or in twelve hours, as sessions do, when that is zero.
+func (i *Issuer) SessionCookie(t testing.TB, id identity.Identity) *http.Cookie {
+	t.Helper()
+
+	now := time.Now()
+	expiresAt := id.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(sessionLifetime)
+	}
+	payload, err := json.Marshal(map[string]any{
+		"authenticated": true,
+		"email":         id.Email,
+		"user_id":       id.Subject,
+		"iat":           now.Unix(),
+		"exp":           expiresAt.Unix(),
+	})
+	if err != nil {
+		t.Fatalf("encode session: %v", err)
+	}
+	return &http.Cookie{Name: identity.DefaultCookieName, Value: identity.SignSession(payload, i.Secret)}
+}
+
+// handleForwardAuth answers as the auth service's forward authentication
+// endpoint: 200 naming the account for a signed-in cookie, 401 otherwise.
+func (i *Issuer) handleForwardAuth(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(identity.DefaultCookieName)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := i.sessions.VerifySession(cookie.Value)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("X-Auth-User", id.Subject)
+	w.Header().Set("X-Auth-Email", id.Email)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleIntrospection answers as the auth service's introspection endpoint
+// for DefaultAudience, describing tokens OpaqueToken minted.
+func (i *Issuer) handleIntrospection(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != DefaultAudience || secret != ClientSecret {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	i.mu.Lock()
+	id, ok := i.opaque[r.PostFormValue("token")]
+	i.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || !time.Now().Before(id.ExpiresAt) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"active": false})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"active":     true,
+		"scope":      strings.Join(id.Scopes, " "),
+		"client_id":  id.ClientID,
+		"sub":        id.Subject,
+		"token_type": "Bearer",
+		"exp":        id.ExpiresAt.Unix(),
+		"iss":        i.server.URL,
+	})
+}
+
+func (i *Issuer) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       i.key.Public(),
+		KeyID:     keyID,
+		Algorithm: string(jose.ES256),
+		Use:       "sig",
+	}}})
+}
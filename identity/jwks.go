@@ -0,0 +1,114 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	jwksLifetime        = time.Hour
+	jwksMinRefreshDelay = time.Minute
+	maxJWKSBytes        = 1 << 20
+)
+
+// KeySet caches a JWKS, the auth service's or an OpenID provider's. Keys are
+// refreshed after an hour, or early when a token references an unknown key ID
+// (rate limited so forged kids cannot force a fetch per request).
+type KeySet struct {
+	client *http.Client
+	locate func(context.Context) (string, error)
+
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+}
+
+// NewKeySet returns a KeySet fetching with client from the URL locate
+// returns, which is asked on every fetch so it may come from discovery.
+func NewKeySet(client *http.Client, locate func(context.Context) (string, error)) *KeySet {
+	return &KeySet{client: client, locate: locate}
+}
+
+// Key returns the signing key named kid. A token without a kid is only
+// matched when the set holds a single key.
+func (k *KeySet) Key(ctx context.Context, kid string, now time.Time) (any, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	stale := k.fetched.IsZero() || now.Sub(k.fetched) >= jwksLifetime
+	if !stale {
+		if key, ok := k.lookup(kid); ok {
+			return key, nil
+		}
+		if now.Sub(k.fetched) < jwksMinRefreshDelay {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	if err := k.refresh(ctx, now); err != nil {
+		return nil, err
+	}
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k *KeySet) lookup(kid string) (any, bool) {
+	if kid != "" {
+		for _, key := range k.keys.Key(kid) {
+			if key.Use == "" || key.Use == "sig" {
+				return key.Key, true
+			}
+		}
+		return nil, false
+	}
+	// Tokens without a kid are only accepted when the set is unambiguous.
+	if len(k.keys.Keys) == 1 {
+		return k.keys.Keys[0].Key, true
+	}
+	return nil, false
+}
+
+func (k *KeySet) refresh(ctx context.Context, now time.Time) error {
+	uri, err := k.locate(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body := io.LimitReader(resp.Body, maxJWKSBytes)
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(body, 512))
+		return fmt.Errorf("fetch jwks: status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(body).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+	if len(set.Keys) == 0 {
+		return errors.New("jwks contains no keys")
+	}
+	k.keys = set
+	k.fetched = now
+	return nil
+}
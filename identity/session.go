@@ -0,0 +1,69 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultCookieName is the session cookie the auth service sets.
+	DefaultCookieName = "auth_session"
+
+	// sessionClockSkew tolerates issuers whose clock runs slightly ahead.
+	sessionClockSkew = time.Minute
+)
+
+var (
+	errSessionTooSmall    = errors.New("session payload too small")
+	errSessionNoExpiry    = errors.New("session has no expiry")
+	errSessionExpired     = errors.New("session expired")
+	errSessionNotYetValid = errors.New("session issued in the future")
+)
+
+// SignSession encodes a session cookie value: payload followed by its
+// HMAC-SHA256 under secret, base64url encoded without padding.
+func SignSession(payload, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	combined := mac.Sum(append([]byte(nil), payload...))
+	return base64.RawURLEncoding.EncodeToString(combined)
+}
+
+// OpenSession verifies a value produced by SignSession and returns its payload.
+func OpenSession(value string, secret []byte) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) <= sha256.Size {
+		return nil, errSessionTooSmall
+	}
+
+	payload := decoded[:len(decoded)-sha256.Size]
+	providedSig := decoded[len(decoded)-sha256.Size:]
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(providedSig, mac.Sum(nil)) {
+		return nil, errors.New("session signature mismatch")
+	}
+	return payload, nil
+}
+
+// CheckSessionLifetime reports whether a session issued at iat and expiring at
+// exp, both in Unix seconds, is valid at now. Sessions without an expiry are
+// refused.
+func CheckSessionLifetime(iat, exp int64, now time.Time) error {
+	switch {
+	case exp == 0:
+		return errSessionNoExpiry
+	case now.Unix() >= exp:
+		return errSessionExpired
+	case iat > now.Add(sessionClockSkew).Unix():
+		return errSessionNotYetValid
+	}
+	return nil
+}
@@ -4,8 +4,13 @@ import (
 	"cmp"
 	"encoding/base64"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/rjnemo/auth/internal/driver/logging"
 )
@@ -19,26 +24,123 @@ const (
 	envGoogleClientID     = "AUTH_GOOGLE_CLIENT_ID"
 	envGoogleClientSecret = "AUTH_GOOGLE_CLIENT_SECRET"
 	envGoogleRedirectURL  = "AUTH_GOOGLE_REDIRECT_URL"
+	envGoogleOffline      = "AUTH_GOOGLE_OFFLINE_ACCESS"
+	envGoogleScopes       = "AUTH_GOOGLE_SCOPES"
+	envGitHubClientID     = "AUTH_GITHUB_CLIENT_ID"
+	envGitHubClientSecret = "AUTH_GITHUB_CLIENT_SECRET"
+	envGitHubRedirectURL  = "AUTH_GITHUB_REDIRECT_URL"
+	envOIDCName           = "AUTH_OIDC_NAME"
+	envOIDCIssuer         = "AUTH_OIDC_ISSUER"
+	envOIDCClientID       = "AUTH_OIDC_CLIENT_ID"
+	envOIDCClientSecret   = "AUTH_OIDC_CLIENT_SECRET"
+	envOIDCRedirectURL    = "AUTH_OIDC_REDIRECT_URL"
+	envTrustedOrigins     = "AUTH_TRUSTED_ORIGINS"
+	envCORSOrigins        = "AUTH_CORS_ALLOWED_ORIGINS"
+	envTokenKey           = "AUTH_TOKEN_ENCRYPTION_KEY"
+	envSAMLConnections    = "AUTH_SAML_CONNECTIONS_FILE"
+	envSCIMTenants        = "AUTH_SCIM_TENANTS_FILE"
+	envLDAPConnections    = "AUTH_LDAP_CONNECTIONS_FILE"
+	envOAuthIssuer        = "AUTH_OAUTH_ISSUER"
+	envOAuthAdminToken    = "AUTH_OAUTH_ADMIN_TOKEN"
+	envSigningKey         = "AUTH_SIGNING_KEY_ENCRYPTION_KEY"
+	envSigningAlgorithm   = "AUTH_SIGNING_ALGORITHM"
+	envSigningRotation    = "AUTH_SIGNING_KEY_ROTATION"
+	envCookieDomain       = "AUTH_COOKIE_DOMAIN"
+	envPublicURL          = "AUTH_PUBLIC_URL"
 
 	defaultListenAddr  = ":8000"
 	defaultEnvironment = "development"
+	defaultOIDCName    = "Single sign-on"
+	googleIssuer       = "https://accounts.google.com"
+	tokenKeyLength     = 32
+	minAdminTokenLen   = 32
+
+	defaultSigningAlgorithm = "ES256"
+	defaultSigningRotation  = 30 * 24 * time.Hour
+	// minSigningRotation leaves room for a successor key to be published a
+	// day before it activates.
+	minSigningRotation = 48 * time.Hour
 )
 
+// signingAlgorithms lists the algorithms signing keys can be generated for.
+var signingAlgorithms = []string{"EdDSA", "ES256", "RS256"}
+
 // Config holds application configuration derived from environment variables.
 type Config struct {
-	ListenAddr    string
-	LogMode       logging.Mode
-	Environment   string
-	SessionSecret []byte
-	DatabaseURL   string
-	GoogleOAuth   GoogleOAuthConfig
+	ListenAddr     string
+	LogMode        logging.Mode
+	Environment    string
+	SessionSecret  []byte
+	DatabaseURL    string
+	GoogleOAuth    GoogleOAuthConfig
+	GitHubOAuth    GitHubOAuthConfig
+	OIDC           OIDCConfig
+	TrustedOrigins []string
+	// CORSAllowedOrigins lists the origins allowed to call the JSON API from
+	// a browser with credentials.
+	CORSAllowedOrigins []string
+	// TokenEncryptionKey is the AES-256 key sealing stored provider tokens.
+	TokenEncryptionKey []byte
+	SAML               []SAMLConnectionConfig
+	// SCIMTenants lists the directories allowed to provision over SCIM,
+	// which is disabled when empty.
+	SCIMTenants []SCIMTenantConfig
+	// LDAP lists the directories checking passwords for their email domains.
+	LDAP []LDAPConnectionConfig
+	// SigningKeys configures the managed keys signing issued tokens.
+	SigningKeys SigningKeysConfig
+	// AuthorizationServer configures the built-in OAuth 2.0 / OpenID provider.
+	AuthorizationServer AuthorizationServerConfig
+	// CookieDomain scopes the session cookie to a parent domain such as
+	// example.com so every subdomain receives it. Empty keeps it host-only.
+	CookieDomain string
+	// PublicURL is the externally visible origin of this service, used when
+	// other hosts send users here to sign in. Empty means the same host.
+	PublicURL string
+}
+
+// SigningKeysConfig holds configuration for the managed, rotating keys that
+// sign issued tokens.
+type SigningKeysConfig struct {
+	// EncryptionKey is the AES-256 key sealing private signing keys at rest.
+	EncryptionKey []byte
+	// Algorithm is the JWS algorithm of new keys: EdDSA, ES256 or RS256.
+	Algorithm string
+	// RotationPeriod is how long each key signs before its successor takes over.
+	RotationPeriod time.Duration
+}
+
+// Enabled reports whether signing keys can be stored.
+func (s SigningKeysConfig) Enabled() bool {
+	return s.EncryptionKey != nil
+}
+
+// AuthorizationServerConfig holds configuration for the built-in OAuth 2.0
+// authorization server and OpenID provider.
+type AuthorizationServerConfig struct {
+	// Issuer is the externally visible base URL, e.g. https://auth.example.com.
+	Issuer string
+	// AdminToken is the bearer token guarding the client management API,
+	// which is disabled when empty.
+	AdminToken string
+}
+
+// Enabled reports whether the authorization server is configured.
+func (a AuthorizationServerConfig) Enabled() bool {
+	return a.Issuer != ""
 }
 
 // GoogleOAuthConfig holds configuration for Google OAuth2 login.
 type GoogleOAuthConfig struct {
+	Issuer       string
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+	// OfflineAccess requests refresh tokens so the app can call Google APIs
+	// while the user is away.
+	OfflineAccess bool
+	// Scopes are requested in addition to openid, email and profile.
+	Scopes []string
 }
 
 // Enabled reports whether Google OAuth2 is fully configured.
@@ -46,6 +148,32 @@ func (g GoogleOAuthConfig) Enabled() bool {
 	return g.ClientID != "" && g.ClientSecret != "" && g.RedirectURL != ""
 }
 
+// GitHubOAuthConfig holds configuration for GitHub OAuth2 login.
+type GitHubOAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Enabled reports whether GitHub OAuth2 is fully configured.
+func (g GitHubOAuthConfig) Enabled() bool {
+	return g.ClientID != "" && g.ClientSecret != "" && g.RedirectURL != ""
+}
+
+// OIDCConfig holds configuration for a generic OpenID Connect provider.
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Enabled reports whether the generic OpenID Connect provider is fully configured.
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != "" && o.ClientID != "" && o.ClientSecret != "" && o.RedirectURL != ""
+}
+
 // New loads configuration from environment variables, applying defaults and validation.
 func New() (*Config, error) {
 	listenAddr := cmp.Or(strings.TrimSpace(os.Getenv(envListenAddr)), defaultListenAddr)
@@ -71,34 +199,247 @@ func New() (*Config, error) {
 	}
 
 	googleOAuth := GoogleOAuthConfig{
+		Issuer:       googleIssuer,
 		ClientID:     strings.TrimSpace(os.Getenv(envGoogleClientID)),
 		ClientSecret: strings.TrimSpace(os.Getenv(envGoogleClientSecret)),
 		RedirectURL:  strings.TrimSpace(os.Getenv(envGoogleRedirectURL)),
 	}
 
-	if partiallyConfigured(googleOAuth) {
+	if partiallyConfigured(googleOAuth.ClientID, googleOAuth.ClientSecret, googleOAuth.RedirectURL) {
 		return nil, fmt.Errorf("incomplete google oauth configuration: set %s, %s, and %s", envGoogleClientID, envGoogleClientSecret, envGoogleRedirectURL)
 	}
 
+	if raw := strings.TrimSpace(os.Getenv(envGoogleOffline)); raw != "" {
+		offline, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envGoogleOffline, err)
+		}
+		googleOAuth.OfflineAccess = offline
+	}
+	googleOAuth.Scopes = strings.FieldsFunc(os.Getenv(envGoogleScopes), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	tokenKey, err := parseTokenKey(os.Getenv(envTokenKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envTokenKey, err)
+	}
+	if googleOAuth.OfflineAccess && tokenKey == nil {
+		return nil, fmt.Errorf("google offline access requires %s to store refresh tokens", envTokenKey)
+	}
+
+	githubOAuth := GitHubOAuthConfig{
+		ClientID:     strings.TrimSpace(os.Getenv(envGitHubClientID)),
+		ClientSecret: strings.TrimSpace(os.Getenv(envGitHubClientSecret)),
+		RedirectURL:  strings.TrimSpace(os.Getenv(envGitHubRedirectURL)),
+	}
+
+	if partiallyConfigured(githubOAuth.ClientID, githubOAuth.ClientSecret, githubOAuth.RedirectURL) {
+		return nil, fmt.Errorf("incomplete github oauth configuration: set %s, %s, and %s", envGitHubClientID, envGitHubClientSecret, envGitHubRedirectURL)
+	}
+
+	oidcConfig := OIDCConfig{
+		Name:         cmp.Or(strings.TrimSpace(os.Getenv(envOIDCName)), defaultOIDCName),
+		Issuer:       strings.TrimSpace(os.Getenv(envOIDCIssuer)),
+		ClientID:     strings.TrimSpace(os.Getenv(envOIDCClientID)),
+		ClientSecret: strings.TrimSpace(os.Getenv(envOIDCClientSecret)),
+		RedirectURL:  strings.TrimSpace(os.Getenv(envOIDCRedirectURL)),
+	}
+
+	if partiallyConfigured(oidcConfig.Issuer, oidcConfig.ClientID, oidcConfig.ClientSecret, oidcConfig.RedirectURL) {
+		return nil, fmt.Errorf("incomplete oidc configuration: set %s, %s, %s, and %s", envOIDCIssuer, envOIDCClientID, envOIDCClientSecret, envOIDCRedirectURL)
+	}
+
+	trustedOrigins, err := parseOrigins(os.Getenv(envTrustedOrigins))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envTrustedOrigins, err)
+	}
+
+	corsOrigins, err := parseOrigins(os.Getenv(envCORSOrigins))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envCORSOrigins, err)
+	}
+
+	samlConnections, err := loadSAMLConnections(os.Getenv(envSAMLConnections))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envSAMLConnections, err)
+	}
+
+	scimTenants, err := loadSCIMTenants(os.Getenv(envSCIMTenants))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envSCIMTenants, err)
+	}
+
+	ldapConnections, err := loadLDAPConnections(os.Getenv(envLDAPConnections))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envLDAPConnections, err)
+	}
+
+	signingKeys, err := loadSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	authorizationServer := AuthorizationServerConfig{
+		Issuer:     strings.TrimSuffix(strings.TrimSpace(os.Getenv(envOAuthIssuer)), "/"),
+		AdminToken: strings.TrimSpace(os.Getenv(envOAuthAdminToken)),
+	}
+
+	if authorizationServer.Issuer != "" {
+		if err := validateIssuer(authorizationServer.Issuer); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envOAuthIssuer, err)
+		}
+		if !signingKeys.Enabled() {
+			return nil, fmt.Errorf("authorization server requires %s to store signing keys", envSigningKey)
+		}
+	}
+	if authorizationServer.AdminToken != "" {
+		if !authorizationServer.Enabled() {
+			return nil, fmt.Errorf("%s requires %s", envOAuthAdminToken, envOAuthIssuer)
+		}
+		if len(authorizationServer.AdminToken) < minAdminTokenLen {
+			return nil, fmt.Errorf("invalid %s: must be at least %d characters", envOAuthAdminToken, minAdminTokenLen)
+		}
+	}
+
+	cookieDomain, err := parseCookieDomain(os.Getenv(envCookieDomain))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envCookieDomain, err)
+	}
+
+	publicURL := strings.TrimSuffix(strings.TrimSpace(os.Getenv(envPublicURL)), "/")
+	if publicURL != "" {
+		if err := validateIssuer(publicURL); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envPublicURL, err)
+		}
+	}
+
 	cfg := &Config{
-		ListenAddr:    listenAddr,
-		LogMode:       logMode,
-		Environment:   environment,
-		SessionSecret: secret,
-		DatabaseURL:   databaseURL,
-		GoogleOAuth:   googleOAuth,
+		ListenAddr:          listenAddr,
+		LogMode:             logMode,
+		Environment:         environment,
+		SessionSecret:       secret,
+		DatabaseURL:         databaseURL,
+		GoogleOAuth:         googleOAuth,
+		GitHubOAuth:         githubOAuth,
+		OIDC:                oidcConfig,
+		TrustedOrigins:      trustedOrigins,
+		CORSAllowedOrigins:  corsOrigins,
+		TokenEncryptionKey:  tokenKey,
+		SAML:                samlConnections,
+		SCIMTenants:         scimTenants,
+		LDAP:                ldapConnections,
+		SigningKeys:         signingKeys,
+		AuthorizationServer: authorizationServer,
+		CookieDomain:        cookieDomain,
+		PublicURL:           publicURL,
 	}
 
 	return cfg, nil
 }
 
-func partiallyConfigured(cfg GoogleOAuthConfig) bool {
-	switch {
-	case cfg.ClientID == "" && cfg.ClientSecret == "" && cfg.RedirectURL == "":
-		return false
-	case cfg.ClientID == "" || cfg.ClientSecret == "" || cfg.RedirectURL == "":
-		return true
-	default:
-		return false
+// partiallyConfigured reports whether some, but not all, of the values are set.
+func partiallyConfigured(values ...string) bool {
+	set := 0
+	for _, v := range values {
+		if v != "" {
+			set++
+		}
+	}
+	return set != 0 && set != len(values)
+}
+
+// parseTokenKey decodes the optional base64 token encryption key.
+func parseTokenKey(raw string) ([]byte, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != tokenKeyLength {
+		return nil, fmt.Errorf("key must decode to %d bytes, got %d", tokenKeyLength, len(key))
+	}
+	return key, nil
+}
+
+// loadSigningKeys reads the signing key settings, applying defaults.
+func loadSigningKeys() (SigningKeysConfig, error) {
+	key, err := parseTokenKey(os.Getenv(envSigningKey))
+	if err != nil {
+		return SigningKeysConfig{}, fmt.Errorf("invalid %s: %w", envSigningKey, err)
+	}
+
+	algorithm := cmp.Or(strings.TrimSpace(os.Getenv(envSigningAlgorithm)), defaultSigningAlgorithm)
+	if !slices.Contains(signingAlgorithms, algorithm) {
+		return SigningKeysConfig{}, fmt.Errorf("invalid %s: %q is not one of %s", envSigningAlgorithm, algorithm, strings.Join(signingAlgorithms, ", "))
+	}
+
+	rotation := defaultSigningRotation
+	if raw := strings.TrimSpace(os.Getenv(envSigningRotation)); raw != "" {
+		rotation, err = time.ParseDuration(raw)
+		if err != nil {
+			return SigningKeysConfig{}, fmt.Errorf("invalid %s: %w", envSigningRotation, err)
+		}
+		if rotation < minSigningRotation {
+			return SigningKeysConfig{}, fmt.Errorf("invalid %s: must be at least %s", envSigningRotation, minSigningRotation)
+		}
+	}
+
+	return SigningKeysConfig{EncryptionKey: key, Algorithm: algorithm, RotationPeriod: rotation}, nil
+}
+
+// parseCookieDomain canonicalises an optional cookie domain. Browsers refuse
+// cookies for single-label domains, so at least two labels are required.
+func parseCookieDomain(raw string) (string, error) {
+	domain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(raw)), ".")
+	if domain == "" {
+		return "", nil
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("domain %q must have at least two labels", raw)
+	}
+	for _, label := range labels {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") ||
+			strings.ContainsFunc(label, func(r rune) bool { return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') }) {
+			return "", fmt.Errorf("domain %q must be a host name such as example.com", raw)
+		}
+	}
+	return domain, nil
+}
+
+// validateIssuer requires a bare http(s) origin: the discovery document is
+// served from the root, so an issuer with a path could not be resolved.
+func validateIssuer(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("issuer %q must be of the form scheme://host[:port]", raw)
+	}
+	return nil
+}
+
+// parseOrigins splits a comma-separated origin list and canonicalises each entry.
+func parseOrigins(raw string) ([]string, error) {
+	var origins []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u, err := url.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("parse origin %q: %w", part, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return nil, fmt.Errorf("origin %q must be of the form scheme://host[:port]", part)
+		}
+		origins = append(origins, strings.ToLower(u.Scheme+"://"+u.Host))
 	}
+	return origins, nil
 }
@@ -2,7 +2,12 @@ package config
 
 import (
 	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rjnemo/auth/internal/driver/logging"
 )
@@ -114,3 +119,356 @@ func bytesOfLength(n int) []byte {
 	}
 	return b
 }
+
+func TestNewTrustedOrigins(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_TRUSTED_ORIGINS", " https://App.example.com , http://localhost:3000")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"https://app.example.com", "http://localhost:3000"}
+	if len(cfg.TrustedOrigins) != len(want) {
+		t.Fatalf("expected %d origins, got %v", len(want), cfg.TrustedOrigins)
+	}
+	for i, origin := range want {
+		if cfg.TrustedOrigins[i] != origin {
+			t.Fatalf("expected origin %q, got %q", origin, cfg.TrustedOrigins[i])
+		}
+	}
+
+	t.Setenv("AUTH_TRUSTED_ORIGINS", "https://app.example.com/path")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for origin with path")
+	}
+}
+
+func TestNewCookieDomainAndPublicURL(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_COOKIE_DOMAIN", " .Example.com ")
+	t.Setenv("AUTH_PUBLIC_URL", "https://auth.example.com/")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.CookieDomain != "example.com" || cfg.PublicURL != "https://auth.example.com" {
+		t.Fatalf("unexpected cookie domain %q and public url %q", cfg.CookieDomain, cfg.PublicURL)
+	}
+
+	for _, domain := range []string{"localhost", "https://example.com", "example.com:443", "exa_mple.com", "example..com"} {
+		t.Setenv("AUTH_COOKIE_DOMAIN", domain)
+		if _, err := New(); err == nil {
+			t.Fatalf("expected error for cookie domain %q", domain)
+		}
+	}
+
+	t.Setenv("AUTH_COOKIE_DOMAIN", "")
+	t.Setenv("AUTH_PUBLIC_URL", "https://auth.example.com/login")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for public url with path")
+	}
+}
+
+func TestNewCORSAllowedOrigins(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_CORS_ALLOWED_ORIGINS", "https://App.example.com, http://localhost:5173")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"https://app.example.com", "http://localhost:5173"}; !slices.Equal(cfg.CORSAllowedOrigins, want) {
+		t.Fatalf("expected cors origins %v, got %v", want, cfg.CORSAllowedOrigins)
+	}
+
+	t.Setenv("AUTH_CORS_ALLOWED_ORIGINS", "https://app.example.com/spa")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for cors origin with path")
+	}
+}
+
+func TestNewOIDCConfiguration(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_OIDC_ISSUER", "https://login.example.com")
+	t.Setenv("AUTH_OIDC_CLIENT_ID", "client")
+
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for partial oidc config")
+	}
+
+	t.Setenv("AUTH_OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("AUTH_OIDC_REDIRECT_URL", "http://localhost:8000/login/oidc/callback")
+	t.Setenv("AUTH_OIDC_NAME", "Okta")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.OIDC.Enabled() || cfg.OIDC.Name != "Okta" || cfg.OIDC.Issuer != "https://login.example.com" {
+		t.Fatalf("unexpected oidc config %+v", cfg.OIDC)
+	}
+	if cfg.GoogleOAuth.Issuer != "https://accounts.google.com" {
+		t.Fatalf("expected google issuer default, got %q", cfg.GoogleOAuth.Issuer)
+	}
+}
+
+func TestNewGitHubOAuthPartialConfiguration(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_GITHUB_CLIENT_ID", "client")
+	if _, err := New(); err == nil {
+		t.Fatalf("expected error for partial github oauth config")
+	}
+
+	t.Setenv("AUTH_GITHUB_CLIENT_SECRET", "secret")
+	t.Setenv("AUTH_GITHUB_REDIRECT_URL", "http://localhost:8000/login/github/callback")
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.GitHubOAuth.Enabled() {
+		t.Fatal("expected github oauth to be enabled")
+	}
+}
+
+func TestNewGoogleOfflineAccess(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_GOOGLE_OFFLINE_ACCESS", "true")
+	t.Setenv("AUTH_GOOGLE_SCOPES", "https://www.googleapis.com/auth/calendar.readonly, https://www.googleapis.com/auth/drive.file")
+
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for offline access without a token key")
+	}
+
+	t.Setenv("AUTH_TOKEN_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytesOfLength(16)))
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for short token key")
+	}
+
+	t.Setenv("AUTH_TOKEN_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.GoogleOAuth.OfflineAccess || len(cfg.TokenEncryptionKey) != 32 {
+		t.Fatalf("expected offline access with token key, got %+v", cfg.GoogleOAuth)
+	}
+	if len(cfg.GoogleOAuth.Scopes) != 2 || cfg.GoogleOAuth.Scopes[1] != "https://www.googleapis.com/auth/drive.file" {
+		t.Fatalf("unexpected scopes %v", cfg.GoogleOAuth.Scopes)
+	}
+
+	t.Setenv("AUTH_GOOGLE_OFFLINE_ACCESS", "sometimes")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for invalid offline access flag")
+	}
+}
+
+func TestNewSAMLConnections(t *testing.T) {
+	const valid = `{"id":"acme","entity_id":"https://auth.example.com/saml/acme/metadata","acs_url":"https://auth.example.com/saml/acme/acs","idp_metadata_path":"idp.xml","certificate_path":"sp.crt","key_path":"sp.key","domains":["Acme.test"]}`
+
+	tests := map[string]struct {
+		body    string
+		wantErr bool
+	}{
+		"valid":          {body: "[" + valid + "]"},
+		"invalid id":     {body: `[{"id":"Acme Corp","entity_id":"e","acs_url":"a","idp_metadata_path":"m","certificate_path":"c","key_path":"k"}]`, wantErr: true},
+		"duplicate id":   {body: "[" + valid + "," + valid + "]", wantErr: true},
+		"missing key":    {body: `[{"id":"acme","entity_id":"e","acs_url":"a","idp_metadata_path":"m","certificate_path":"c"}]`, wantErr: true},
+		"missing domain": {body: `[{"id":"acme","entity_id":"e","acs_url":"a","idp_metadata_path":"m","certificate_path":"c","key_path":"k"}]`, wantErr: true},
+		"invalid domain": {body: `[{"id":"acme","entity_id":"e","acs_url":"a","idp_metadata_path":"m","certificate_path":"c","key_path":"k","domains":["@acme.test"]}]`, wantErr: true},
+		"unknown field":  {body: `[{"id":"acme","entityID":"e"}]`, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "saml.json")
+			if err := os.WriteFile(path, []byte(tc.body), 0o600); err != nil {
+				t.Fatalf("write connections: %v", err)
+			}
+			t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+			t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+			t.Setenv("AUTH_SAML_CONNECTIONS_FILE", path)
+
+			cfg, err := New()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", cfg.SAML)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(cfg.SAML) != 1 || cfg.SAML[0].ID != "acme" || cfg.SAML[0].Name != "acme" || cfg.SAML[0].Domains[0] != "acme.test" {
+				t.Fatalf("expected acme connection named after its id with a normalized domain, got %+v", cfg.SAML)
+			}
+		})
+	}
+}
+
+func TestNewSCIMTenants(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	valid := `{"id":"acme","token_sha256":"` + hash + `","domains":["Acme.test"],"connection":"acme"}`
+
+	tests := map[string]struct {
+		body    string
+		wantErr bool
+	}{
+		"valid":           {body: "[" + valid + "]"},
+		"invalid id":      {body: `[{"id":"Acme Corp","token_sha256":"` + hash + `"}]`, wantErr: true},
+		"duplicate id":    {body: "[" + valid + "," + valid + "]", wantErr: true},
+		"shared token":    {body: "[" + valid + `,{"id":"globex","token_sha256":"` + hash + `","domains":["globex.test"]}]`, wantErr: true},
+		"missing domains": {body: `[{"id":"acme","token_sha256":"` + hash + `"}]`, wantErr: true},
+		"invalid domain":  {body: `[{"id":"acme","token_sha256":"` + hash + `","domains":["acme"]}]`, wantErr: true},
+		"malformed token": {body: `[{"id":"acme","token_sha256":"not-a-digest"}]`, wantErr: true},
+		"unknown field":   {body: `[{"id":"acme","token":"secret"}]`, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scim.json")
+			if err := os.WriteFile(path, []byte(tc.body), 0o600); err != nil {
+				t.Fatalf("write tenants: %v", err)
+			}
+			t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+			t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+			t.Setenv("AUTH_SCIM_TENANTS_FILE", path)
+
+			cfg, err := New()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", cfg.SCIMTenants)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(cfg.SCIMTenants) != 1 || cfg.SCIMTenants[0].Name != "acme" || len(cfg.SCIMTenants[0].TokenHash) != 32 || cfg.SCIMTenants[0].Domains[0] != "acme.test" {
+				t.Fatalf("expected acme tenant with a decoded token hash and normalized domain, got %+v", cfg.SCIMTenants)
+			}
+		})
+	}
+}
+
+func TestNewLDAPConnections(t *testing.T) {
+	valid := `{"id":"corp","domains":["Corp.Example.com"],"url":"ldaps://dc.corp.example.com","user_base_dn":"dc=corp,dc=example,dc=com"}`
+
+	tests := map[string]struct {
+		body    string
+		wantErr bool
+	}{
+		"valid":               {body: "[" + valid + "]"},
+		"start tls":           {body: `[{"id":"corp","domains":["corp.example.com"],"url":"ldap://dc.corp.example.com","start_tls":true,"user_base_dn":"dc=corp"}]`},
+		"plain ldap":          {body: `[{"id":"corp","domains":["corp.example.com"],"url":"ldap://dc.corp.example.com","user_base_dn":"dc=corp"}]`, wantErr: true},
+		"ldaps with tls":      {body: `[{"id":"corp","domains":["corp.example.com"],"url":"ldaps://dc.corp.example.com","start_tls":true,"user_base_dn":"dc=corp"}]`, wantErr: true},
+		"missing base dn":     {body: `[{"id":"corp","domains":["corp.example.com"],"url":"ldaps://dc.corp.example.com"}]`, wantErr: true},
+		"missing domains":     {body: `[{"id":"corp","url":"ldaps://dc.corp.example.com","user_base_dn":"dc=corp"}]`, wantErr: true},
+		"invalid domain":      {body: `[{"id":"corp","domains":["@corp.example.com"],"url":"ldaps://dc.corp.example.com","user_base_dn":"dc=corp"}]`, wantErr: true},
+		"duplicate id":        {body: "[" + valid + "," + valid + "]", wantErr: true},
+		"shared domain":       {body: "[" + valid + `,{"id":"other","domains":["corp.example.com"],"url":"ldaps://dc2.example.com","user_base_dn":"dc=corp"}]`, wantErr: true},
+		"password without dn": {body: `[{"id":"corp","domains":["corp.example.com"],"url":"ldaps://dc.corp.example.com","bind_password_path":"/run/secrets/ldap","user_base_dn":"dc=corp"}]`, wantErr: true},
+		"unknown field":       {body: `[{"id":"corp","bind_password":"secret"}]`, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ldap.json")
+			if err := os.WriteFile(path, []byte(tc.body), 0o600); err != nil {
+				t.Fatalf("write connections: %v", err)
+			}
+			t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+			t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+			t.Setenv("AUTH_LDAP_CONNECTIONS_FILE", path)
+
+			cfg, err := New()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", cfg.LDAP)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(cfg.LDAP) != 1 || cfg.LDAP[0].Name != "corp" || cfg.LDAP[0].Domains[0] != "corp.example.com" {
+				t.Fatalf("expected corp connection with a normalized domain, got %+v", cfg.LDAP)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizationServer(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_OAUTH_ISSUER", "https://auth.example.com/")
+
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for issuer without signing key encryption key")
+	}
+
+	t.Setenv("AUTH_SIGNING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.AuthorizationServer.Enabled() || cfg.AuthorizationServer.Issuer != "https://auth.example.com" {
+		t.Fatalf("unexpected authorization server config %+v", cfg.AuthorizationServer)
+	}
+	if cfg.SigningKeys.Algorithm != "ES256" || cfg.SigningKeys.RotationPeriod != 30*24*time.Hour {
+		t.Fatalf("expected signing key defaults, got %+v", cfg.SigningKeys)
+	}
+
+	t.Setenv("AUTH_OAUTH_ADMIN_TOKEN", "too-short")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for short admin token")
+	}
+	t.Setenv("AUTH_OAUTH_ADMIN_TOKEN", strings.Repeat("a", 32))
+	if cfg, err := New(); err != nil || cfg.AuthorizationServer.AdminToken == "" {
+		t.Fatalf("expected admin token to load, got %v", err)
+	}
+
+	t.Setenv("AUTH_OAUTH_ISSUER", "https://auth.example.com/tenant")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for issuer with path")
+	}
+
+	t.Setenv("AUTH_OAUTH_ISSUER", "")
+	if _, err := New(); err == nil {
+		t.Fatal("expected error for admin token without issuer")
+	}
+}
+
+func TestNewSigningKeys(t *testing.T) {
+	t.Setenv("AUTH_SESSION_SECRET", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_DATABASE_URL", "postgres://localhost/auth_test?sslmode=disable")
+	t.Setenv("AUTH_SIGNING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytesOfLength(32)))
+	t.Setenv("AUTH_SIGNING_ALGORITHM", "EdDSA")
+	t.Setenv("AUTH_SIGNING_KEY_ROTATION", "168h")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SigningKeys.Algorithm != "EdDSA" || cfg.SigningKeys.RotationPeriod != 7*24*time.Hour {
+		t.Fatalf("unexpected signing key config %+v", cfg.SigningKeys)
+	}
+
+	for name, env := range map[string][2]string{
+		"symmetric algorithm": {"AUTH_SIGNING_ALGORITHM", "HS256"},
+		"short rotation":      {"AUTH_SIGNING_KEY_ROTATION", "1h"},
+		"bad rotation":        {"AUTH_SIGNING_KEY_ROTATION", "monthly"},
+		"short key":           {"AUTH_SIGNING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytesOfLength(16))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if _, err := New(); err == nil {
+				t.Fatalf("expected error for %s=%s", env[0], env[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// LDAPConnectionConfig describes one LDAP or Active Directory server that
+// checks passwords for the email domains it lists, ahead of the local password
+// table.
+type LDAPConnectionConfig struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Domains []string `json:"domains"`
+	// URL is ldaps://host[:port], or ldap://host[:port] with StartTLS.
+	URL      string `json:"url"`
+	StartTLS bool   `json:"start_tls"`
+	// CACertPath is a PEM bundle trusted instead of the system roots.
+	CACertPath string `json:"ca_cert_path"`
+	// BindDN and BindPasswordPath identify the service account that searches
+	// for users. The password is kept in its own file, like SAML keys.
+	BindDN           string              `json:"bind_dn"`
+	BindPasswordPath string              `json:"bind_password_path"`
+	UserBaseDN       string              `json:"user_base_dn"`
+	UserFilter       string              `json:"user_filter"`
+	GroupBaseDN      string              `json:"group_base_dn"`
+	GroupFilter      string              `json:"group_filter"`
+	RequiredGroups   []string            `json:"required_groups"`
+	Attributes       LDAPAttributeConfig `json:"attributes"`
+}
+
+// LDAPAttributeConfig names the directory attributes mapped onto the profile.
+// An empty Subject keys accounts by the entry DN; objectGUID or entryUUID
+// survive renames.
+type LDAPAttributeConfig struct {
+	Subject    string `json:"subject"`
+	Name       string `json:"name"`
+	GivenName  string `json:"given_name"`
+	FamilyName string `json:"family_name"`
+	MemberOf   string `json:"member_of"`
+}
+
+// loadLDAPConnections reads the JSON connection list at path.
+func loadLDAPConnections(path string) ([]LDAPConnectionConfig, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var connections []LDAPConnectionConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&connections); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(connections))
+	domains := make(map[string]string)
+	for i, conn := range connections {
+		if !connectionIDPattern.MatchString(conn.ID) {
+			return nil, fmt.Errorf("connection %d: id %q must be lowercase letters, digits or dashes", i, conn.ID)
+		}
+		if seen[conn.ID] {
+			return nil, fmt.Errorf("connection %q: duplicate id", conn.ID)
+		}
+		seen[conn.ID] = true
+
+		if conn.URL == "" || conn.UserBaseDN == "" {
+			return nil, fmt.Errorf("connection %q: url and user_base_dn are required", conn.ID)
+		}
+		u, err := url.Parse(conn.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("connection %q: invalid url", conn.ID)
+		}
+		switch {
+		case u.Scheme == "ldaps" && !conn.StartTLS:
+		case u.Scheme == "ldap" && conn.StartTLS:
+		default:
+			return nil, fmt.Errorf("connection %q: url must be ldaps://, or ldap:// with start_tls", conn.ID)
+		}
+		if conn.BindDN == "" && conn.BindPasswordPath != "" {
+			return nil, fmt.Errorf("connection %q: bind_password_path requires bind_dn", conn.ID)
+		}
+
+		if len(conn.Domains) == 0 {
+			return nil, fmt.Errorf("connection %q: at least one domain is required", conn.ID)
+		}
+		for j, raw := range conn.Domains {
+			domain, ok := normalizeEmailDomain(raw)
+			if !ok {
+				return nil, fmt.Errorf("connection %q: invalid domain %q", conn.ID, raw)
+			}
+			if other, ok := domains[domain]; ok {
+				return nil, fmt.Errorf("connection %q: domain %s is also routed to connection %q", conn.ID, domain, other)
+			}
+			domains[domain] = conn.ID
+			connections[i].Domains[j] = domain
+		}
+		connections[i].Name = cmp.Or(conn.Name, conn.ID)
+	}
+	return connections, nil
+}
+
+// normalizeEmailDomain lowercases the domain part of an email address such as
+// corp.example.com, reporting whether it looks like one.
+func normalizeEmailDomain(raw string) (string, bool) {
+	domain := strings.ToLower(strings.TrimSpace(raw))
+	if domain == "" || strings.ContainsAny(domain, "@ /") || !strings.Contains(domain, ".") {
+		return "", false
+	}
+	return domain, true
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// connectionIDPattern keeps SAML connection IDs safe to use in routes.
+var connectionIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// SAMLConnectionConfig describes one enterprise SAML 2.0 connection. The
+// service-provider certificate and key are per connection so a compromised or
+// rotated key only affects a single customer.
+type SAMLConnectionConfig struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	EntityID        string `json:"entity_id"`
+	ACSURL          string `json:"acs_url"`
+	IDPMetadataPath string `json:"idp_metadata_path"`
+	CertificatePath string `json:"certificate_path"`
+	KeyPath         string `json:"key_path"`
+	// Domains lists the email domains the IdP may assert, at least one.
+	// Their emails are treated as verified and others are refused.
+	Domains []string `json:"domains"`
+	// AllowIDPInitiated accepts unsolicited responses started at the IdP.
+	AllowIDPInitiated bool                 `json:"allow_idp_initiated"`
+	Attributes        SAMLAttributeMapping `json:"attributes"`
+}
+
+// SAMLAttributeMapping names the assertion attributes mapped onto the profile.
+// Email falls back to the NameID when it uses the emailAddress format.
+type SAMLAttributeMapping struct {
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	GivenName  string `json:"given_name"`
+	FamilyName string `json:"family_name"`
+}
+
+// loadSAMLConnections reads the JSON connection list at path.
+func loadSAMLConnections(path string) ([]SAMLConnectionConfig, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var connections []SAMLConnectionConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&connections); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(connections))
+	for i, conn := range connections {
+		if !connectionIDPattern.MatchString(conn.ID) {
+			return nil, fmt.Errorf("connection %d: id %q must be lowercase letters, digits or dashes", i, conn.ID)
+		}
+		if seen[conn.ID] {
+			return nil, fmt.Errorf("connection %q: duplicate id", conn.ID)
+		}
+		seen[conn.ID] = true
+		if conn.EntityID == "" || conn.ACSURL == "" || conn.IDPMetadataPath == "" || conn.CertificatePath == "" || conn.KeyPath == "" {
+			return nil, fmt.Errorf("connection %q: entity_id, acs_url, idp_metadata_path, certificate_path and key_path are required", conn.ID)
+		}
+		if len(conn.Domains) == 0 {
+			return nil, fmt.Errorf("connection %q: at least one domain is required", conn.ID)
+		}
+		for j, domain := range conn.Domains {
+			normalized, ok := normalizeEmailDomain(domain)
+			if !ok {
+				return nil, fmt.Errorf("connection %q: invalid domain %q", conn.ID, domain)
+			}
+			connections[i].Domains[j] = normalized
+		}
+		connections[i].Name = cmp.Or(conn.Name, conn.ID)
+	}
+	return connections, nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SCIMTenantConfig describes one customer directory allowed to provision
+// users and groups over SCIM. Only the SHA-256 of its bearer token is
+// configured, so the file does not hold a usable credential.
+type SCIMTenantConfig struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	TokenSHA256 string `json:"token_sha256"`
+	// Domains lists the email domains the tenant may provision accounts in.
+	Domains []string `json:"domains"`
+	// Connection is the login provider, usually the tenant's SAML connection,
+	// whose verified logins may claim the accounts the tenant provisioned.
+	Connection string `json:"connection"`
+	// TokenHash is TokenSHA256 decoded.
+	TokenHash []byte `json:"-"`
+}
+
+// loadSCIMTenants reads the JSON tenant list at path.
+func loadSCIMTenants(path string) ([]SCIMTenantConfig, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []SCIMTenantConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&tenants); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(tenants))
+	tokens := make(map[string]string, len(tenants))
+	for i, tenant := range tenants {
+		if !connectionIDPattern.MatchString(tenant.ID) {
+			return nil, fmt.Errorf("tenant %d: id %q must be lowercase letters, digits or dashes", i, tenant.ID)
+		}
+		if seen[tenant.ID] {
+			return nil, fmt.Errorf("tenant %q: duplicate id", tenant.ID)
+		}
+		seen[tenant.ID] = true
+
+		hash, err := hex.DecodeString(tenant.TokenSHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("tenant %q: token_sha256 must be a hex-encoded SHA-256 digest", tenant.ID)
+		}
+		key := string(hash)
+		if other, ok := tokens[key]; ok {
+			return nil, fmt.Errorf("tenant %q: token_sha256 is also used by tenant %q", tenant.ID, other)
+		}
+		tokens[key] = tenant.ID
+
+		if len(tenant.Domains) == 0 {
+			return nil, fmt.Errorf("tenant %q: at least one domain is required", tenant.ID)
+		}
+		for j, raw := range tenant.Domains {
+			domain, ok := normalizeEmailDomain(raw)
+			if !ok {
+				return nil, fmt.Errorf("tenant %q: invalid domain %q", tenant.ID, raw)
+			}
+			tenants[i].Domains[j] = domain
+		}
+
+		tenants[i].Name = cmp.Or(tenant.Name, tenant.ID)
+		tenants[i].TokenHash = hash
+	}
+	return tenants, nil
+}
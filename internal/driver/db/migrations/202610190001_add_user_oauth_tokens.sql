@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE user_oauth_tokens (
+    oauth_account_id UUID PRIMARY KEY REFERENCES user_oauth_accounts(id) ON DELETE CASCADE,
+    access_token BYTEA NOT NULL,
+    refresh_token BYTEA,
+    token_type TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_oauth_tokens;
@@ -0,0 +1,58 @@
+-- +goose Up
+ALTER TABLE oauth_clients
+    ADD COLUMN client_type TEXT NOT NULL DEFAULT 'confidential',
+    ADD COLUMN token_endpoint_auth_method TEXT NOT NULL DEFAULT 'client_secret_basic',
+    ADD COLUMN grant_types TEXT[] NOT NULL DEFAULT '{authorization_code}',
+    ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{openid,profile,email}',
+    ADD COLUMN jwks JSONB;
+
+UPDATE oauth_clients
+SET client_type = 'public', token_endpoint_auth_method = 'none'
+WHERE secret_hash IS NULL;
+
+CREATE TABLE oauth_client_secrets (
+    id UUID PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    secret_hash BYTEA NOT NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX oauth_client_secrets_client_id_idx ON oauth_client_secrets (client_id);
+
+INSERT INTO oauth_client_secrets (id, client_id, secret_hash, created_at)
+SELECT gen_random_uuid(), id, secret_hash, created_at
+FROM oauth_clients
+WHERE secret_hash IS NOT NULL;
+
+ALTER TABLE oauth_clients DROP COLUMN secret_hash;
+
+CREATE TABLE oauth_client_assertions (
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_client_assertions;
+
+ALTER TABLE oauth_clients ADD COLUMN secret_hash BYTEA;
+
+UPDATE oauth_clients c
+SET secret_hash = (
+    SELECT s.secret_hash
+    FROM oauth_client_secrets s
+    WHERE s.client_id = c.id
+    ORDER BY s.created_at DESC
+    LIMIT 1
+);
+
+DROP TABLE IF EXISTS oauth_client_secrets;
+
+ALTER TABLE oauth_clients
+    DROP COLUMN jwks,
+    DROP COLUMN scopes,
+    DROP COLUMN grant_types,
+    DROP COLUMN token_endpoint_auth_method,
+    DROP COLUMN client_type;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE oauth_device_authorizations (
+    device_code_hash BYTEA PRIMARY KEY,
+    user_code_hash BYTEA NOT NULL UNIQUE,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'pending',
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    poll_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX oauth_device_authorizations_expires_at_idx ON oauth_device_authorizations (expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_device_authorizations;
@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE TABLE oauth_refresh_tokens (
+    id UUID PRIMARY KEY,
+    token_hash BYTEA NOT NULL UNIQUE,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    rotated_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX oauth_refresh_tokens_expires_at_idx ON oauth_refresh_tokens (expires_at);
+
+-- Access tokens die with the refresh token they were derived from.
+ALTER TABLE oauth_access_tokens
+    ADD COLUMN refresh_token_id UUID REFERENCES oauth_refresh_tokens(id) ON DELETE CASCADE;
+
+CREATE INDEX oauth_access_tokens_refresh_token_id_idx ON oauth_access_tokens (refresh_token_id);
+
+-- Revocations are kept for audit after the client or user is gone, so they
+-- carry no foreign keys.
+CREATE TABLE oauth_token_revocations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_type TEXT NOT NULL,
+    token_hash BYTEA NOT NULL,
+    client_id TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    access_tokens INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX oauth_token_revocations_client_id_idx ON oauth_token_revocations (client_id, revoked_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_token_revocations;
+DROP INDEX IF EXISTS oauth_access_tokens_refresh_token_id_idx;
+ALTER TABLE oauth_access_tokens DROP COLUMN IF EXISTS refresh_token_id;
+DROP TABLE IF EXISTS oauth_refresh_tokens;
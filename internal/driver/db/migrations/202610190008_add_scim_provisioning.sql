@@ -0,0 +1,37 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN directory TEXT,
+    ADD COLUMN external_id TEXT,
+    ADD COLUMN deactivated_at TIMESTAMPTZ;
+
+CREATE INDEX users_directory_idx ON users (directory, created_at) WHERE directory IS NOT NULL;
+
+CREATE TABLE scim_groups (
+    id UUID PRIMARY KEY,
+    directory TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    external_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (directory, display_name)
+);
+
+CREATE TABLE scim_group_members (
+    group_id UUID NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX scim_group_members_user_id_idx ON scim_group_members (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS scim_group_members;
+DROP TABLE IF EXISTS scim_groups;
+
+DROP INDEX IF EXISTS users_directory_idx;
+
+ALTER TABLE users
+    DROP COLUMN deactivated_at,
+    DROP COLUMN external_id,
+    DROP COLUMN directory;
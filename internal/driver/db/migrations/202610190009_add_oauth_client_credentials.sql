@@ -0,0 +1,26 @@
+-- +goose Up
+-- Tokens from the client_credentials grant are issued to the client itself,
+-- so they have no user.
+ALTER TABLE oauth_access_tokens ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE oauth_token_revocations ALTER COLUMN user_id DROP NOT NULL;
+
+-- Client token issuances are kept for audit after the client is gone, so they
+-- carry no foreign keys. The id is the token's jti.
+CREATE TABLE oauth_client_token_issuances (
+    id UUID PRIMARY KEY,
+    token_hash BYTEA NOT NULL,
+    client_id TEXT NOT NULL,
+    audience TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX oauth_client_token_issuances_client_id_idx ON oauth_client_token_issuances (client_id, issued_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_client_token_issuances;
+DELETE FROM oauth_token_revocations WHERE user_id IS NULL;
+ALTER TABLE oauth_token_revocations ALTER COLUMN user_id SET NOT NULL;
+DELETE FROM oauth_access_tokens WHERE user_id IS NULL;
+ALTER TABLE oauth_access_tokens ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Audiences lists the client IDs a client's client_credentials tokens may be
+-- addressed to; without any, tokens are only addressed to the issuer.
+ALTER TABLE oauth_clients ADD COLUMN audiences TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE oauth_clients DROP COLUMN audiences;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE saml_requests (
+    entity_id TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (entity_id, request_id)
+);
+
+CREATE INDEX saml_requests_expires_at_idx ON saml_requests (expires_at);
+
+CREATE TABLE saml_assertions (
+    entity_id TEXT NOT NULL,
+    assertion_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (entity_id, assertion_id)
+);
+
+CREATE INDEX saml_assertions_expires_at_idx ON saml_assertions (expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS saml_assertions;
+DROP TABLE IF EXISTS saml_requests;
@@ -21,14 +21,169 @@ type LoginEvent struct {
 	CreatedAt pgtype.Timestamptz `json:"created_at"`
 }
 
-type User struct {
+type OauthAccessToken struct {
+	TokenHash      []byte             `json:"token_hash"`
+	ClientID       string             `json:"client_id"`
+	UserID         pgtype.UUID        `json:"user_id"`
+	Scopes         []string           `json:"scopes"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	RefreshTokenID pgtype.UUID        `json:"refresh_token_id"`
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      []byte             `json:"code_hash"`
+	ClientID      string             `json:"client_id"`
+	UserID        uuid.UUID          `json:"user_id"`
+	RedirectUri   string             `json:"redirect_uri"`
+	Scopes        []string           `json:"scopes"`
+	Nonce         pgtype.Text        `json:"nonce"`
+	CodeChallenge string             `json:"code_challenge"`
+	ExpiresAt     pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+type OauthClient struct {
+	ID                      string             `json:"id"`
+	Name                    string             `json:"name"`
+	RedirectUris            []string           `json:"redirect_uris"`
+	CreatedAt               pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt               pgtype.Timestamptz `json:"updated_at"`
+	ClientType              string             `json:"client_type"`
+	TokenEndpointAuthMethod string             `json:"token_endpoint_auth_method"`
+	GrantTypes              []string           `json:"grant_types"`
+	Scopes                  []string           `json:"scopes"`
+	Jwks                    []byte             `json:"jwks"`
+	Audiences               []string           `json:"audiences"`
+}
+
+type OauthClientAssertion struct {
+	ClientID  string             `json:"client_id"`
+	Jti       string             `json:"jti"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+type OauthClientSecret struct {
+	ID         uuid.UUID          `json:"id"`
+	ClientID   string             `json:"client_id"`
+	SecretHash []byte             `json:"secret_hash"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type OauthClientTokenIssuance struct {
+	ID        uuid.UUID          `json:"id"`
+	TokenHash []byte             `json:"token_hash"`
+	ClientID  string             `json:"client_id"`
+	Audience  string             `json:"audience"`
+	Scopes    []string           `json:"scopes"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	IssuedAt  pgtype.Timestamptz `json:"issued_at"`
+}
+
+type OauthDeviceAuthorization struct {
+	DeviceCodeHash []byte             `json:"device_code_hash"`
+	UserCodeHash   []byte             `json:"user_code_hash"`
+	ClientID       string             `json:"client_id"`
+	Scopes         []string           `json:"scopes"`
+	Status         string             `json:"status"`
+	UserID         pgtype.UUID        `json:"user_id"`
+	PollInterval   int32              `json:"poll_interval"`
+	LastPolledAt   pgtype.Timestamptz `json:"last_polled_at"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+type OauthConsent struct {
+	UserID    uuid.UUID          `json:"user_id"`
+	ClientID  string             `json:"client_id"`
+	Scopes    []string           `json:"scopes"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+}
+
+type OauthRefreshToken struct {
+	ID        uuid.UUID          `json:"id"`
+	TokenHash []byte             `json:"token_hash"`
+	ClientID  string             `json:"client_id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	Scopes    []string           `json:"scopes"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	RotatedAt pgtype.Timestamptz `json:"rotated_at"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type OauthTokenRevocation struct {
+	ID           uuid.UUID          `json:"id"`
+	TokenType    string             `json:"token_type"`
+	TokenHash    []byte             `json:"token_hash"`
+	ClientID     string             `json:"client_id"`
+	UserID       pgtype.UUID        `json:"user_id"`
+	AccessTokens int32              `json:"access_tokens"`
+	RevokedAt    pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID          `json:"id"`
+	UserID     uuid.UUID          `json:"user_id"`
+	Name       string             `json:"name"`
+	TokenHash  []byte             `json:"token_hash"`
+	TokenHint  string             `json:"token_hint"`
+	Scopes     []string           `json:"scopes"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	LastUsedIp *netip.Addr        `json:"last_used_ip"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type SamlAssertion struct {
+	EntityID    string             `json:"entity_id"`
+	AssertionID string             `json:"assertion_id"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+}
+
+type SamlRequest struct {
+	EntityID  string             `json:"entity_id"`
+	RequestID string             `json:"request_id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+type ScimGroup struct {
 	ID          uuid.UUID          `json:"id"`
-	Email       string             `json:"email"`
-	DisplayName pgtype.Text        `json:"display_name"`
+	Directory   string             `json:"directory"`
+	DisplayName string             `json:"display_name"`
+	ExternalID  pgtype.Text        `json:"external_id"`
 	CreatedAt   pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt   pgtype.Timestamptz `json:"updated_at"`
 }
 
+type ScimGroupMember struct {
+	GroupID   uuid.UUID          `json:"group_id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type SigningKey struct {
+	ID          string             `json:"id"`
+	Algorithm   string             `json:"algorithm"`
+	PrivateKey  []byte             `json:"private_key"`
+	ActivatesAt pgtype.Timestamptz `json:"activates_at"`
+	RetiresAt   pgtype.Timestamptz `json:"retires_at"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+}
+
+type User struct {
+	ID            uuid.UUID          `json:"id"`
+	Email         string             `json:"email"`
+	DisplayName   pgtype.Text        `json:"display_name"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+	Directory     pgtype.Text        `json:"directory"`
+	ExternalID    pgtype.Text        `json:"external_id"`
+	DeactivatedAt pgtype.Timestamptz `json:"deactivated_at"`
+}
+
 type UserOauthAccount struct {
 	ID            uuid.UUID          `json:"id"`
 	UserID        uuid.UUID          `json:"user_id"`
@@ -41,6 +196,17 @@ type UserOauthAccount struct {
 	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
 }
 
+type UserOauthToken struct {
+	OauthAccountID uuid.UUID          `json:"oauth_account_id"`
+	AccessToken    []byte             `json:"access_token"`
+	RefreshToken   []byte             `json:"refresh_token"`
+	TokenType      string             `json:"token_type"`
+	Scopes         []string           `json:"scopes"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt      pgtype.Timestamptz `json:"updated_at"`
+}
+
 type UserPassword struct {
 	UserID       uuid.UUID          `json:"user_id"`
 	PasswordHash []byte             `json:"password_hash"`
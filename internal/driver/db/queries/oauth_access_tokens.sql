@@ -0,0 +1,21 @@
+-- name: CreateOAuthAccessToken :exec
+INSERT INTO oauth_access_tokens (token_hash, client_id, user_id, scopes, expires_at, refresh_token_id)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetOAuthAccessToken :one
+SELECT token_hash, client_id, user_id, scopes, expires_at, created_at, refresh_token_id
+FROM oauth_access_tokens
+WHERE token_hash = $1;
+
+-- name: DeleteOAuthAccessToken :execrows
+DELETE FROM oauth_access_tokens
+WHERE token_hash = $1;
+
+-- name: DeleteOAuthAccessTokensByRefreshToken :execrows
+DELETE FROM oauth_access_tokens
+WHERE refresh_token_id = $1;
+
+-- name: DeleteOAuthAccessTokensByUserClient :many
+DELETE FROM oauth_access_tokens
+WHERE user_id = $1 AND client_id = $2
+RETURNING token_hash, refresh_token_id;
@@ -0,0 +1,12 @@
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ConsumeOAuthAuthorizationCode :one
+DELETE FROM oauth_authorization_codes
+WHERE code_hash = $1
+RETURNING code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at, created_at;
+
+-- name: DeleteOAuthAuthorizationCodesByUserClient :exec
+DELETE FROM oauth_authorization_codes
+WHERE user_id = $1 AND client_id = $2;
@@ -0,0 +1,9 @@
+-- name: UseOAuthClientAssertion :execrows
+WITH purged AS (
+    DELETE FROM oauth_client_assertions
+    WHERE client_id = sqlc.arg(client_id)
+      AND expires_at <= now()
+)
+INSERT INTO oauth_client_assertions (client_id, jti, expires_at)
+VALUES (sqlc.arg(client_id), sqlc.arg(jti), sqlc.arg(expires_at))
+ON CONFLICT (client_id, jti) DO NOTHING;
@@ -0,0 +1,23 @@
+-- name: CreateOAuthClientSecret :exec
+INSERT INTO oauth_client_secrets (id, client_id, secret_hash, expires_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: ListOAuthClientSecrets :many
+SELECT id, client_id, secret_hash, expires_at, created_at
+FROM oauth_client_secrets
+WHERE client_id = $1
+ORDER BY created_at, id;
+
+-- name: RotateOAuthClientSecret :exec
+WITH retired AS (
+    UPDATE oauth_client_secrets
+    SET expires_at = sqlc.arg(retire_at)::timestamptz
+    WHERE client_id = sqlc.arg(client_id)
+      AND (expires_at IS NULL OR expires_at > sqlc.arg(retire_at)::timestamptz)
+), purged AS (
+    DELETE FROM oauth_client_secrets
+    WHERE client_id = sqlc.arg(client_id)
+      AND expires_at <= now()
+)
+INSERT INTO oauth_client_secrets (id, client_id, secret_hash)
+VALUES (sqlc.arg(id), sqlc.arg(client_id), sqlc.arg(secret_hash));
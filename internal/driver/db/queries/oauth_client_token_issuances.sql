@@ -0,0 +1,10 @@
+-- name: CreateOAuthClientTokenIssuance :exec
+INSERT INTO oauth_client_token_issuances (id, token_hash, client_id, audience, scopes, expires_at, issued_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListOAuthClientTokenIssuances :many
+SELECT id, token_hash, client_id, audience, scopes, expires_at, issued_at
+FROM oauth_client_token_issuances
+WHERE client_id = $1
+ORDER BY issued_at DESC, id
+LIMIT $2;
@@ -0,0 +1,29 @@
+-- name: CreateOAuthClient :exec
+INSERT INTO oauth_clients (id, name, client_type, token_endpoint_auth_method, redirect_uris, grant_types, scopes, jwks, audiences)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1;
+
+-- name: GetOAuthClient :one
+SELECT id, name, redirect_uris, created_at, updated_at, client_type, token_endpoint_auth_method, grant_types, scopes, jwks, audiences
+FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT id, name, redirect_uris, created_at, updated_at, client_type, token_endpoint_auth_method, grant_types, scopes, jwks, audiences
+FROM oauth_clients
+ORDER BY created_at, id;
+
+-- name: UpdateOAuthClient :execrows
+UPDATE oauth_clients
+SET name = $2,
+    token_endpoint_auth_method = $3,
+    redirect_uris = $4,
+    grant_types = $5,
+    scopes = $6,
+    jwks = $7,
+    audiences = $8,
+    updated_at = now()
+WHERE id = $1;
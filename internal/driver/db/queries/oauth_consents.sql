@@ -0,0 +1,22 @@
+-- name: UpsertOAuthConsent :exec
+INSERT INTO oauth_consents (user_id, client_id, scopes)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, client_id) DO UPDATE
+SET scopes = ARRAY(SELECT DISTINCT scope FROM unnest(oauth_consents.scopes || EXCLUDED.scopes) AS scope ORDER BY scope),
+    updated_at = now();
+
+-- name: GetOAuthConsentScopes :one
+SELECT scopes
+FROM oauth_consents
+WHERE user_id = $1 AND client_id = $2;
+
+-- name: ListOAuthConsentsByUser :many
+SELECT c.client_id, oc.name AS client_name, c.scopes
+FROM oauth_consents c
+JOIN oauth_clients oc ON oc.id = c.client_id
+WHERE c.user_id = $1
+ORDER BY oc.name, c.client_id;
+
+-- name: DeleteOAuthConsent :execrows
+DELETE FROM oauth_consents
+WHERE user_id = $1 AND client_id = $2;
@@ -0,0 +1,42 @@
+-- name: CreateOAuthDeviceAuthorization :exec
+WITH purged AS (
+    DELETE FROM oauth_device_authorizations
+    WHERE expires_at <= now()
+)
+INSERT INTO oauth_device_authorizations (device_code_hash, user_code_hash, client_id, scopes, poll_interval, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetOAuthDeviceAuthorizationByUserCode :one
+SELECT device_code_hash, user_code_hash, client_id, scopes, status, user_id, poll_interval, last_polled_at, expires_at, created_at
+FROM oauth_device_authorizations
+WHERE user_code_hash = $1;
+
+-- name: DecideOAuthDeviceAuthorization :execrows
+UPDATE oauth_device_authorizations
+SET status = $2,
+    user_id = $3
+WHERE user_code_hash = $1
+  AND status = 'pending';
+
+-- name: PollOAuthDeviceAuthorization :one
+WITH previous AS (
+    SELECT device_code_hash, user_code_hash, client_id, scopes, status, user_id, poll_interval, last_polled_at, expires_at, created_at
+    FROM oauth_device_authorizations
+    WHERE device_code_hash = $1
+    FOR UPDATE
+)
+UPDATE oauth_device_authorizations AS d
+SET last_polled_at = $2
+FROM previous
+WHERE d.device_code_hash = previous.device_code_hash
+RETURNING previous.device_code_hash, previous.user_code_hash, previous.client_id, previous.scopes, previous.status, previous.user_id, previous.poll_interval, previous.last_polled_at, previous.expires_at, previous.created_at;
+
+-- name: SlowDownOAuthDeviceAuthorization :exec
+UPDATE oauth_device_authorizations
+SET poll_interval = $2
+WHERE device_code_hash = $1;
+
+-- name: ConsumeOAuthDeviceAuthorization :one
+DELETE FROM oauth_device_authorizations
+WHERE device_code_hash = $1
+RETURNING device_code_hash, user_code_hash, client_id, scopes, status, user_id, poll_interval, last_polled_at, expires_at, created_at;
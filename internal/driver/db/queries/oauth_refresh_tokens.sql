@@ -0,0 +1,28 @@
+-- name: CreateOAuthRefreshToken :exec
+WITH purged AS (
+    DELETE FROM oauth_refresh_tokens
+    WHERE expires_at <= now()
+)
+INSERT INTO oauth_refresh_tokens (id, token_hash, client_id, user_id, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetOAuthRefreshToken :one
+SELECT id, token_hash, client_id, user_id, scopes, expires_at, rotated_at, created_at
+FROM oauth_refresh_tokens
+WHERE token_hash = $1;
+
+-- name: RotateOAuthRefreshToken :execrows
+UPDATE oauth_refresh_tokens
+SET token_hash = sqlc.arg(new_token_hash),
+    rotated_at = now()
+WHERE token_hash = sqlc.arg(token_hash);
+
+-- name: DeleteOAuthRefreshToken :one
+DELETE FROM oauth_refresh_tokens
+WHERE token_hash = $1
+RETURNING id;
+
+-- name: DeleteOAuthRefreshTokensByUserClient :many
+DELETE FROM oauth_refresh_tokens
+WHERE user_id = $1 AND client_id = $2
+RETURNING id, token_hash;
@@ -0,0 +1,11 @@
+-- name: CreateOAuthTokenRevocation :one
+INSERT INTO oauth_token_revocations (token_type, token_hash, client_id, user_id, access_tokens)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, token_type, token_hash, client_id, user_id, access_tokens, revoked_at;
+
+-- name: ListOAuthTokenRevocations :many
+SELECT id, token_type, token_hash, client_id, user_id, access_tokens, revoked_at
+FROM oauth_token_revocations
+WHERE client_id = $1
+ORDER BY revoked_at DESC, id
+LIMIT $2;
@@ -0,0 +1,24 @@
+-- name: CreatePersonalAccessToken :exec
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, token_hint, scopes, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListPersonalAccessTokensForUser :many
+SELECT id, user_id, name, token_hash, token_hint, scopes, expires_at, last_used_at, last_used_ip, created_at
+FROM personal_access_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC, id;
+
+-- name: GetPersonalAccessTokenByHash :one
+SELECT id, user_id, name, token_hash, token_hint, scopes, expires_at, last_used_at, last_used_ip, created_at
+FROM personal_access_tokens
+WHERE token_hash = $1;
+
+-- name: TouchPersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET last_used_at = $2,
+    last_used_ip = $3
+WHERE id = $1;
+
+-- name: DeletePersonalAccessToken :execrows
+DELETE FROM personal_access_tokens
+WHERE id = $1 AND user_id = $2;
@@ -0,0 +1,12 @@
+-- name: UseSAMLAssertion :execrows
+WITH purged AS (
+    DELETE FROM saml_assertions
+    WHERE entity_id = sqlc.arg(entity_id)
+      AND expires_at <= sqlc.arg(now)::timestamptz
+      AND assertion_id <> sqlc.arg(assertion_id)
+)
+INSERT INTO saml_assertions (entity_id, assertion_id, expires_at)
+VALUES (sqlc.arg(entity_id), sqlc.arg(assertion_id), sqlc.arg(expires_at))
+ON CONFLICT (entity_id, assertion_id) DO UPDATE
+SET expires_at = EXCLUDED.expires_at
+WHERE saml_assertions.expires_at <= sqlc.arg(now)::timestamptz;
@@ -0,0 +1,22 @@
+-- name: CreateSAMLRequest :execrows
+WITH purged AS (
+    DELETE FROM saml_requests
+    WHERE entity_id = sqlc.arg(entity_id)
+      AND expires_at <= sqlc.arg(now)::timestamptz
+)
+INSERT INTO saml_requests (entity_id, request_id, expires_at)
+SELECT sqlc.arg(entity_id), sqlc.arg(request_id)::text, sqlc.arg(expires_at)::timestamptz
+WHERE (
+    SELECT count(*)
+    FROM saml_requests
+    WHERE entity_id = sqlc.arg(entity_id)
+      AND expires_at > sqlc.arg(now)::timestamptz
+) < sqlc.arg(max_pending)::bigint
+ON CONFLICT (entity_id, request_id) DO UPDATE
+SET expires_at = EXCLUDED.expires_at;
+
+-- name: TakeSAMLRequest :execrows
+DELETE FROM saml_requests
+WHERE entity_id = sqlc.arg(entity_id)
+  AND request_id = sqlc.arg(request_id)
+  AND expires_at > sqlc.arg(now)::timestamptz;
@@ -0,0 +1,22 @@
+-- name: AddScimGroupMember :exec
+INSERT INTO scim_group_members (group_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT (group_id, user_id) DO NOTHING;
+
+-- name: ListScimGroupMembers :many
+SELECT user_id
+FROM scim_group_members
+WHERE group_id = $1
+ORDER BY created_at, user_id;
+
+-- name: DeleteScimGroupMember :exec
+DELETE FROM scim_group_members
+WHERE group_id = $1 AND user_id = $2;
+
+-- name: DeleteScimGroupMembers :exec
+DELETE FROM scim_group_members
+WHERE group_id = $1;
+
+-- name: DeleteScimGroupMembershipsForUser :exec
+DELETE FROM scim_group_members
+WHERE user_id = $1;
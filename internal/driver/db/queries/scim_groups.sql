@@ -0,0 +1,36 @@
+-- name: CreateScimGroup :exec
+INSERT INTO scim_groups (id, directory, display_name, external_id, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetScimGroup :one
+SELECT id, directory, display_name, external_id, created_at, updated_at
+FROM scim_groups
+WHERE id = $1 AND directory = $2;
+
+-- name: GetScimGroupByDisplayName :one
+SELECT id, directory, display_name, external_id, created_at, updated_at
+FROM scim_groups
+WHERE directory = $1 AND display_name = $2;
+
+-- name: ListScimGroups :many
+SELECT id, directory, display_name, external_id, created_at, updated_at
+FROM scim_groups
+WHERE directory = $1
+ORDER BY created_at, id
+LIMIT $2 OFFSET $3;
+
+-- name: CountScimGroups :one
+SELECT count(*)
+FROM scim_groups
+WHERE directory = $1;
+
+-- name: UpdateScimGroup :execrows
+UPDATE scim_groups
+SET display_name = $3,
+    external_id = $4,
+    updated_at = $5
+WHERE id = $1 AND directory = $2;
+
+-- name: DeleteScimGroup :execrows
+DELETE FROM scim_groups
+WHERE id = $1 AND directory = $2;
@@ -0,0 +1,15 @@
+-- name: CreateSigningKey :exec
+INSERT INTO signing_keys (id, algorithm, private_key, activates_at, retires_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListPublishedSigningKeys :many
+SELECT id, algorithm, private_key, activates_at, retires_at, expires_at, created_at
+FROM signing_keys
+WHERE expires_at > $1
+ORDER BY activates_at, id;
+
+-- name: RetireSigningKeys :exec
+UPDATE signing_keys
+SET retires_at = LEAST(retires_at, sqlc.arg(retire_at)::timestamptz),
+    expires_at = LEAST(expires_at, sqlc.arg(expire_at)::timestamptz)
+WHERE expires_at > sqlc.arg(retire_at)::timestamptz;
@@ -13,3 +13,21 @@ SELECT id, user_id, provider, subject, email, email_verified, profile, created_a
 FROM user_oauth_accounts
 WHERE user_id = $1
 ORDER BY created_at DESC;
+
+-- name: DeleteUserOAuthAccount :execrows
+DELETE FROM user_oauth_accounts
+WHERE user_id = $1
+  AND provider = $2
+  AND subject = $3
+  AND (
+    EXISTS (SELECT 1 FROM user_passwords WHERE user_passwords.user_id = $1)
+    OR (SELECT count(*) FROM user_oauth_accounts AS other WHERE other.user_id = $1) > 1
+  );
+
+-- name: UpdateUserOAuthAccount :execrows
+UPDATE user_oauth_accounts
+SET email = $3,
+    email_verified = $4,
+    profile = $5,
+    updated_at = now()
+WHERE provider = $1 AND subject = $2;
@@ -0,0 +1,20 @@
+-- name: UpsertUserOAuthToken :execrows
+INSERT INTO user_oauth_tokens (oauth_account_id, access_token, refresh_token, token_type, scopes, expires_at)
+SELECT id, $3, $4, $5, $6, $7
+FROM user_oauth_accounts
+WHERE provider = $1 AND subject = $2
+ON CONFLICT (oauth_account_id) DO UPDATE
+SET access_token = EXCLUDED.access_token,
+    refresh_token = COALESCE(EXCLUDED.refresh_token, user_oauth_tokens.refresh_token),
+    token_type = EXCLUDED.token_type,
+    scopes = ARRAY(SELECT DISTINCT scope FROM unnest(user_oauth_tokens.scopes || EXCLUDED.scopes) AS scope ORDER BY scope),
+    expires_at = EXCLUDED.expires_at,
+    updated_at = now();
+
+-- name: GetUserOAuthTokenByProvider :one
+SELECT a.provider, a.subject, t.access_token, t.refresh_token, t.token_type, t.scopes, t.expires_at, t.updated_at
+FROM user_oauth_tokens AS t
+JOIN user_oauth_accounts AS a ON a.id = t.oauth_account_id
+WHERE a.user_id = $1 AND a.provider = $2
+ORDER BY t.updated_at DESC
+LIMIT 1;
@@ -1,14 +1,47 @@
 -- name: CreateUser :one
-INSERT INTO users (id, email)
-VALUES ($1, $2)
-RETURNING id, email, created_at;
+INSERT INTO users (id, email, display_name, directory, external_id, deactivated_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, email, display_name, created_at;
 
 -- name: GetUserByID :one
-SELECT id, email, created_at
+SELECT id, email, display_name, created_at, updated_at, directory, external_id, deactivated_at
 FROM users
 WHERE id = $1;
 
 -- name: GetUserByEmail :one
-SELECT id, email, created_at
+SELECT id, email, display_name, created_at, updated_at, directory, external_id, deactivated_at
 FROM users
 WHERE email = $1;
+
+-- name: ListUsersByDirectory :many
+SELECT id, email, display_name, created_at, updated_at, directory, external_id, deactivated_at
+FROM users
+WHERE directory = $1
+ORDER BY created_at, id
+LIMIT $2 OFFSET $3;
+
+-- name: CountUsersByDirectory :one
+SELECT count(*)
+FROM users
+WHERE directory = $1;
+
+-- name: UpdateUserDisplayName :exec
+UPDATE users
+SET display_name = $2,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: UpdateUser :execrows
+UPDATE users
+SET email = $2,
+    display_name = $3,
+    directory = $4,
+    external_id = $5,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: SetUserDeactivatedAt :execrows
+UPDATE users
+SET deactivated_at = $2,
+    updated_at = now()
+WHERE id = $1;
@@ -51,6 +51,31 @@ func (q *Queries) CreateUserOAuthAccount(ctx context.Context, arg CreateUserOAut
 	return i, err
 }
 
+const deleteUserOAuthAccount = `-- name: DeleteUserOAuthAccount :execrows
+DELETE FROM user_oauth_accounts
+WHERE user_id = $1
+  AND provider = $2
+  AND subject = $3
+  AND (
+    EXISTS (SELECT 1 FROM user_passwords WHERE user_passwords.user_id = $1)
+    OR (SELECT count(*) FROM user_oauth_accounts AS other WHERE other.user_id = $1) > 1
+  )
+`
+
+type DeleteUserOAuthAccountParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+}
+
+func (q *Queries) DeleteUserOAuthAccount(ctx context.Context, arg DeleteUserOAuthAccountParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserOAuthAccount, arg.UserID, arg.Provider, arg.Subject)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getUserOAuthAccountByProviderSubject = `-- name: GetUserOAuthAccountByProviderSubject :one
 SELECT id, user_id, provider, subject, email, email_verified, profile, created_at, updated_at
 FROM user_oauth_accounts
@@ -115,3 +140,34 @@ func (q *Queries) ListUserOAuthAccountsByUserID(ctx context.Context, userID uuid
 	}
 	return items, nil
 }
+
+const updateUserOAuthAccount = `-- name: UpdateUserOAuthAccount :execrows
+UPDATE user_oauth_accounts
+SET email = $3,
+    email_verified = $4,
+    profile = $5,
+    updated_at = now()
+WHERE provider = $1 AND subject = $2
+`
+
+type UpdateUserOAuthAccountParams struct {
+	Provider      string      `json:"provider"`
+	Subject       string      `json:"subject"`
+	Email         pgtype.Text `json:"email"`
+	EmailVerified bool        `json:"email_verified"`
+	Profile       []byte      `json:"profile"`
+}
+
+func (q *Queries) UpdateUserOAuthAccount(ctx context.Context, arg UpdateUserOAuthAccountParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserOAuthAccount,
+		arg.Provider,
+		arg.Subject,
+		arg.Email,
+		arg.EmailVerified,
+		arg.Profile,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
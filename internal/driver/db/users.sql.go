@@ -12,64 +12,212 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countUsersByDirectory = `-- name: CountUsersByDirectory :one
+SELECT count(*)
+FROM users
+WHERE directory = $1
+`
+
+func (q *Queries) CountUsersByDirectory(ctx context.Context, directory pgtype.Text) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsersByDirectory, directory)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, email)
-VALUES ($1, $2)
-RETURNING id, email, created_at
+INSERT INTO users (id, email, display_name, directory, external_id, deactivated_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, email, display_name, created_at
 `
 
 type CreateUserParams struct {
-	ID    uuid.UUID `json:"id"`
-	Email string    `json:"email"`
+	ID            uuid.UUID          `json:"id"`
+	Email         string             `json:"email"`
+	DisplayName   pgtype.Text        `json:"display_name"`
+	Directory     pgtype.Text        `json:"directory"`
+	ExternalID    pgtype.Text        `json:"external_id"`
+	DeactivatedAt pgtype.Timestamptz `json:"deactivated_at"`
 }
 
 type CreateUserRow struct {
-	ID        uuid.UUID          `json:"id"`
-	Email     string             `json:"email"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	ID          uuid.UUID          `json:"id"`
+	Email       string             `json:"email"`
+	DisplayName pgtype.Text        `json:"display_name"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
-	row := q.db.QueryRow(ctx, createUser, arg.ID, arg.Email)
+	row := q.db.QueryRow(ctx, createUser,
+		arg.ID,
+		arg.Email,
+		arg.DisplayName,
+		arg.Directory,
+		arg.ExternalID,
+		arg.DeactivatedAt,
+	)
 	var i CreateUserRow
-	err := row.Scan(&i.ID, &i.Email, &i.CreatedAt)
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.DisplayName,
+		&i.CreatedAt,
+	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, created_at
+SELECT id, email, display_name, created_at, updated_at, directory, external_id, deactivated_at
 FROM users
 WHERE email = $1
 `
 
-type GetUserByEmailRow struct {
-	ID        uuid.UUID          `json:"id"`
-	Email     string             `json:"email"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
-}
-
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error) {
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
 	row := q.db.QueryRow(ctx, getUserByEmail, email)
-	var i GetUserByEmailRow
-	err := row.Scan(&i.ID, &i.Email, &i.CreatedAt)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.DisplayName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Directory,
+		&i.ExternalID,
+		&i.DeactivatedAt,
+	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, email, created_at
+SELECT id, email, display_name, created_at, updated_at, directory, external_id, deactivated_at
 FROM users
 WHERE id = $1
 `
 
-type GetUserByIDRow struct {
-	ID        uuid.UUID          `json:"id"`
-	Email     string             `json:"email"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
-}
-
-func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 	row := q.db.QueryRow(ctx, getUserByID, id)
-	var i GetUserByIDRow
-	err := row.Scan(&i.ID, &i.Email, &i.CreatedAt)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.DisplayName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Directory,
+		&i.ExternalID,
+		&i.DeactivatedAt,
+	)
 	return i, err
 }
+
+const listUsersByDirectory = `-- name: ListUsersByDirectory :many
+SELECT id, email, display_name, created_at, updated_at, directory, external_id, deactivated_at
+FROM users
+WHERE directory = $1
+ORDER BY created_at, id
+LIMIT $2 OFFSET $3
+`
+
+type ListUsersByDirectoryParams struct {
+	Directory pgtype.Text `json:"directory"`
+	Limit     int32       `json:"limit"`
+	Offset    int32       `json:"offset"`
+}
+
+func (q *Queries) ListUsersByDirectory(ctx context.Context, arg ListUsersByDirectoryParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsersByDirectory, arg.Directory, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.DisplayName,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Directory,
+			&i.ExternalID,
+			&i.DeactivatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setUserDeactivatedAt = `-- name: SetUserDeactivatedAt :execrows
+UPDATE users
+SET deactivated_at = $2,
+    updated_at = now()
+WHERE id = $1
+`
+
+type SetUserDeactivatedAtParams struct {
+	ID            uuid.UUID          `json:"id"`
+	DeactivatedAt pgtype.Timestamptz `json:"deactivated_at"`
+}
+
+func (q *Queries) SetUserDeactivatedAt(ctx context.Context, arg SetUserDeactivatedAtParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setUserDeactivatedAt, arg.ID, arg.DeactivatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUser = `-- name: UpdateUser :execrows
+UPDATE users
+SET email = $2,
+    display_name = $3,
+    directory = $4,
+    external_id = $5,
+    updated_at = now()
+WHERE id = $1
+`
+
+type UpdateUserParams struct {
+	ID          uuid.UUID   `json:"id"`
+	Email       string      `json:"email"`
+	DisplayName pgtype.Text `json:"display_name"`
+	Directory   pgtype.Text `json:"directory"`
+	ExternalID  pgtype.Text `json:"external_id"`
+}
+
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUser,
+		arg.ID,
+		arg.Email,
+		arg.DisplayName,
+		arg.Directory,
+		arg.ExternalID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUserDisplayName = `-- name: UpdateUserDisplayName :exec
+UPDATE users
+SET display_name = $2,
+    updated_at = now()
+WHERE id = $1
+`
+
+type UpdateUserDisplayNameParams struct {
+	ID          uuid.UUID   `json:"id"`
+	DisplayName pgtype.Text `json:"display_name"`
+}
+
+func (q *Queries) UpdateUserDisplayName(ctx context.Context, arg UpdateUserDisplayNameParams) error {
+	_, err := q.db.Exec(ctx, updateUserDisplayName, arg.ID, arg.DisplayName)
+	return err
+}
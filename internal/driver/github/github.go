@@ -0,0 +1,180 @@
+// Package github adapts GitHub's OAuth2 web flow and REST API into a verified
+// external identity. GitHub is not an OpenID provider, so identity is read from
+// the user and emails APIs instead of an ID token.
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const (
+	defaultAPIBaseURL = "https://api.github.com"
+	apiVersion        = "2022-11-28"
+	maxResponseBytes  = 1 << 20
+)
+
+// ErrNoVerifiedEmail indicates the GitHub account has no primary verified email.
+var ErrNoVerifiedEmail = errors.New("github: no primary verified email")
+
+var defaultScopes = []string{"read:user", "user:email"}
+
+// Config describes an OAuth app registration with GitHub.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes defaults to read:user and user:email when empty.
+	Scopes []string
+	// Endpoint defaults to github.com; override for GitHub Enterprise or tests.
+	Endpoint oauth2.Endpoint
+	// APIBaseURL defaults to https://api.github.com.
+	APIBaseURL string
+	// HTTPClient is used for token and API requests; defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Identity is the subset of a GitHub account used to sign users in.
+type Identity struct {
+	// Subject is the immutable numeric user ID; logins can be renamed.
+	Subject       string
+	Login         string
+	Name          string
+	AvatarURL     string
+	Email         string
+	EmailVerified bool
+}
+
+// Client performs the GitHub authorization-code flow and identity lookups.
+type Client struct {
+	oauth   *oauth2.Config
+	apiBase string
+	client  *http.Client
+}
+
+// New validates cfg and returns a Client.
+func New(cfg Config) (*Client, error) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("github: client id and secret required")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = defaultScopes
+	}
+	if cfg.Endpoint.AuthURL == "" {
+		cfg.Endpoint = endpoints.GitHub
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	apiBase := strings.TrimSuffix(cfg.APIBaseURL, "/")
+	if apiBase == "" {
+		apiBase = defaultAPIBaseURL
+	}
+
+	return &Client{
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+			Endpoint:     cfg.Endpoint,
+		},
+		apiBase: apiBase,
+		client:  client,
+	}, nil
+}
+
+// AuthCodeURL returns the GitHub authorization URL for state.
+func (c *Client) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return c.oauth.AuthCodeURL(state, opts...)
+}
+
+// Exchange redeems an authorization code for an access token.
+func (c *Client) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.client)
+	token, err := c.oauth.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("github: exchange code: %w", err)
+	}
+	return token, nil
+}
+
+type apiUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type apiEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// FetchIdentity loads the user profile and selects the primary verified email.
+func (c *Client) FetchIdentity(ctx context.Context, token *oauth2.Token) (Identity, error) {
+	var user apiUser
+	if err := c.get(ctx, token, "/user", &user); err != nil {
+		return Identity{}, err
+	}
+	if user.ID == 0 {
+		return Identity{}, errors.New("github: user response missing id")
+	}
+
+	var emails []apiEmail
+	if err := c.get(ctx, token, "/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Login:     user.Login,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified && e.Email != "" {
+			identity.Email = e.Email
+			identity.EmailVerified = true
+			return identity, nil
+		}
+	}
+	return Identity{}, ErrNoVerifiedEmail
+}
+
+func (c *Client) get(ctx context.Context, token *oauth2.Token, path string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiBase+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", apiVersion)
+	token.SetAuthHeader(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("github: request %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body := io.LimitReader(resp.Body, maxResponseBytes)
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(body, 512))
+		return fmt.Errorf("github: %s response %d: %s", path, resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	if err := json.NewDecoder(body).Decode(dest); err != nil {
+		return fmt.Errorf("github: decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package github_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rjnemo/auth/internal/driver/github"
+	"github.com/rjnemo/auth/internal/driver/github/githubtest"
+)
+
+func TestClientFetchIdentity(t *testing.T) {
+	t.Parallel()
+
+	fake := githubtest.NewServer(t)
+	client, err := github.New(github.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/login/github/callback",
+		Endpoint:     fake.Endpoint(),
+		APIBaseURL:   fake.URL(),
+	})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	tests := map[string]struct {
+		user    githubtest.User
+		want    github.Identity
+		wantErr error
+	}{
+		"primary verified email": {
+			user: githubtest.User{ID: 42, Login: "octocat", Name: "The Octocat", Emails: []githubtest.Email{
+				{Email: "secondary@example.com", Verified: true},
+				{Email: "octocat@example.com", Primary: true, Verified: true},
+			}},
+			want: github.Identity{Subject: "42", Login: "octocat", Name: "The Octocat", Email: "octocat@example.com", EmailVerified: true},
+		},
+		"primary unverified": {
+			user: githubtest.User{ID: 7, Login: "ghost", Emails: []githubtest.Email{
+				{Email: "ghost@example.com", Primary: true},
+				{Email: "verified@example.com", Verified: true},
+			}},
+			wantErr: github.ErrNoVerifiedEmail,
+		},
+		"no emails": {
+			user:    githubtest.User{ID: 8, Login: "private"},
+			wantErr: github.ErrNoVerifiedEmail,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			token, err := client.Exchange(ctx, fake.Authorize(tc.user))
+			if err != nil {
+				t.Fatalf("exchange: %v", err)
+			}
+
+			identity, err := client.FetchIdentity(ctx, token)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetch identity: %v", err)
+			}
+			if identity != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, identity)
+			}
+		})
+	}
+}
+
+func TestClientExchangeRejectsUnknownCode(t *testing.T) {
+	t.Parallel()
+
+	fake := githubtest.NewServer(t)
+	client, err := github.New(github.Config{ClientID: "client", ClientSecret: "secret", Endpoint: fake.Endpoint(), APIBaseURL: fake.URL()})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := client.Exchange(context.Background(), "unknown"); err == nil {
+		t.Fatal("expected exchange of unknown code to fail")
+	}
+}
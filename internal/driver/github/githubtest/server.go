@@ -0,0 +1,148 @@
+// Package githubtest provides an in-process fake of GitHub's OAuth and REST APIs.
+package githubtest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// Email mirrors an entry returned by GET /user/emails.
+type Email struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// User describes the account the fake signs in.
+type User struct {
+	ID        int64
+	Login     string
+	Name      string
+	AvatarURL string
+	Emails    []Email
+}
+
+// Server fakes the subset of GitHub used by the login adapter.
+type Server struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	codes  map[string]User
+	tokens map[string]User
+}
+
+// NewServer starts the fake; it is closed when the test completes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{codes: make(map[string]User), tokens: make(map[string]User)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", s.handleToken)
+	mux.HandleFunc("GET /user", s.handleUser)
+	mux.HandleFunc("GET /user/emails", s.handleEmails)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// URL returns the base URL serving both OAuth and API routes.
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Endpoint returns OAuth endpoints pointing at the fake.
+func (s *Server) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  s.server.URL + "/login/oauth/authorize",
+		TokenURL: s.server.URL + "/login/oauth/access_token",
+	}
+}
+
+// Authorize returns an authorization code that redeems to user.
+func (s *Server) Authorize(user User) string {
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = user
+	s.mu.Unlock()
+	return code
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad_verification_code"})
+		return
+	}
+
+	s.mu.Lock()
+	user, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	token := randomString()
+	if ok {
+		s.tokens[token] = user
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]string{"error": "bad_verification_code"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": token, "token_type": "bearer", "scope": "read:user,user:email"})
+}
+
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":         user.ID,
+		"login":      user.Login,
+		"name":       user.Name,
+		"avatar_url": user.AvatarURL,
+	})
+}
+
+func (s *Server) handleEmails(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+	emails := user.Emails
+	if emails == nil {
+		emails = []Email{}
+	}
+	writeJSON(w, http.StatusOK, emails)
+}
+
+func (s *Server) authenticate(r *http.Request) (User, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return User{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.tokens[token]
+	return user, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,311 @@
+// Package ldap authenticates passwords against an LDAP directory or Active
+// Directory using search-then-bind: a service account locates the user entry,
+// the user's own credentials are verified by binding as that entry, and group
+// membership is read back for the caller.
+package ldap
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// UsernamePlaceholder is replaced with the escaped username in UserFilter.
+	UsernamePlaceholder = "{username}"
+	// DNPlaceholder is replaced with the escaped user DN in GroupFilter.
+	DNPlaceholder = "{dn}"
+
+	// DefaultUserFilter matches the login name against the mail attribute.
+	DefaultUserFilter = "(mail=" + UsernamePlaceholder + ")"
+	// DefaultGroupFilter matches groupOfNames entries listing the user.
+	DefaultGroupFilter = "(member=" + DNPlaceholder + ")"
+
+	defaultTimeout = 10 * time.Second
+	maxGroups      = 1000
+)
+
+var (
+	// ErrInvalidCredentials indicates the user is unknown, the password is
+	// wrong or the user lacks a required group.
+	ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+	// ErrAmbiguousUser indicates the user filter matched more than one entry.
+	ErrAmbiguousUser = errors.New("ldap: user filter matched several entries")
+)
+
+// Attributes names the directory attributes read from the user entry. An
+// empty Subject uses the entry DN.
+type Attributes struct {
+	Subject    string
+	Name       string
+	GivenName  string
+	FamilyName string
+	MemberOf   string
+}
+
+// Config describes one directory connection.
+type Config struct {
+	// URL is an ldaps:// or ldap:// URL; plain ldap requires StartTLS.
+	URL      string
+	StartTLS bool
+	// TLSConfig verifies the server; nil uses the system roots.
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the service account used for searches. An
+	// empty BindDN searches anonymously.
+	BindDN       string
+	BindPassword string
+	UserBaseDN   string
+	UserFilter   string
+	// GroupBaseDN enables a group search with GroupFilter; otherwise groups are
+	// read from the MemberOf attribute of the user entry.
+	GroupBaseDN    string
+	GroupFilter    string
+	Attributes     Attributes
+	RequiredGroups []string
+	Timeout        time.Duration
+}
+
+// Entry is the authenticated directory user.
+type Entry struct {
+	DN         string
+	Subject    string
+	Name       string
+	GivenName  string
+	FamilyName string
+	Groups     []string
+}
+
+// Authenticator verifies passwords against a single directory.
+type Authenticator struct {
+	cfg  Config
+	host string
+}
+
+// New validates cfg and returns an authenticator.
+func New(cfg Config) (*Authenticator, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("ldap: invalid url %q", cfg.URL)
+	}
+	switch u.Scheme {
+	case "ldaps":
+		if cfg.StartTLS {
+			return nil, errors.New("ldap: start_tls cannot be combined with ldaps")
+		}
+	case "ldap":
+		if !cfg.StartTLS {
+			return nil, errors.New("ldap: plain ldap urls require start_tls")
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+	if cfg.UserBaseDN == "" {
+		return nil, errors.New("ldap: user base dn required")
+	}
+	if cfg.BindDN == "" && cfg.BindPassword != "" {
+		return nil, errors.New("ldap: bind password given without bind dn")
+	}
+	cfg.UserFilter = cmp.Or(cfg.UserFilter, DefaultUserFilter)
+	if !strings.Contains(cfg.UserFilter, UsernamePlaceholder) {
+		return nil, fmt.Errorf("ldap: user filter must contain %s", UsernamePlaceholder)
+	}
+	if _, err := goldap.CompileFilter(strings.ReplaceAll(cfg.UserFilter, UsernamePlaceholder, "x")); err != nil {
+		return nil, fmt.Errorf("ldap: user filter: %w", err)
+	}
+	if cfg.GroupBaseDN != "" {
+		cfg.GroupFilter = cmp.Or(cfg.GroupFilter, DefaultGroupFilter)
+		if _, err := goldap.CompileFilter(strings.ReplaceAll(cfg.GroupFilter, DNPlaceholder, "x")); err != nil {
+			return nil, fmt.Errorf("ldap: group filter: %w", err)
+		}
+	}
+	cfg.Attributes.Name = cmp.Or(cfg.Attributes.Name, "displayName")
+	cfg.Attributes.GivenName = cmp.Or(cfg.Attributes.GivenName, "givenName")
+	cfg.Attributes.FamilyName = cmp.Or(cfg.Attributes.FamilyName, "sn")
+	cfg.Attributes.MemberOf = cmp.Or(cfg.Attributes.MemberOf, "memberOf")
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSConfig != nil {
+		tlsConfig = cfg.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	cfg.TLSConfig = tlsConfig
+	return &Authenticator{cfg: cfg, host: u.Host}, nil
+}
+
+// Authenticate locates username with the service account, binds as the entry
+// found to verify password and returns the entry with its groups. Unknown
+// users, wrong passwords and missing required groups all return
+// ErrInvalidCredentials.
+func (a *Authenticator) Authenticate(ctx context.Context, username, password string) (Entry, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// directories accept for any DN.
+	if username == "" || password == "" {
+		return Entry{}, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := a.bindService(conn); err != nil {
+		return Entry{}, err
+	}
+
+	filter := strings.ReplaceAll(a.cfg.UserFilter, UsernamePlaceholder, goldap.EscapeFilter(username))
+	attrs := []string{a.cfg.Attributes.Name, a.cfg.Attributes.GivenName, a.cfg.Attributes.FamilyName}
+	if a.cfg.Attributes.Subject != "" {
+		attrs = append(attrs, a.cfg.Attributes.Subject)
+	}
+	if a.cfg.GroupBaseDN == "" {
+		attrs = append(attrs, a.cfg.Attributes.MemberOf)
+	}
+	res, err := conn.Search(goldap.NewSearchRequest(
+		a.cfg.UserBaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		2, int(a.cfg.Timeout.Seconds()), false, filter, attrs, nil,
+	))
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return Entry{}, a.wrap(ctx, "search user", err)
+	}
+	switch {
+	case res == nil || len(res.Entries) == 0:
+		return Entry{}, ErrInvalidCredentials
+	case len(res.Entries) > 1:
+		return Entry{}, ErrAmbiguousUser
+	}
+	found := res.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return Entry{}, ErrInvalidCredentials
+		}
+		return Entry{}, a.wrap(ctx, "bind user", err)
+	}
+
+	entry := Entry{
+		DN:         found.DN,
+		Subject:    found.DN,
+		Name:       found.GetEqualFoldAttributeValue(a.cfg.Attributes.Name),
+		GivenName:  found.GetEqualFoldAttributeValue(a.cfg.Attributes.GivenName),
+		FamilyName: found.GetEqualFoldAttributeValue(a.cfg.Attributes.FamilyName),
+	}
+	if a.cfg.Attributes.Subject != "" {
+		// Binary identifiers such as Active Directory's objectGUID are
+		// hex-encoded to fit the text subject column.
+		raw := found.GetEqualFoldRawAttributeValue(a.cfg.Attributes.Subject)
+		if utf8.Valid(raw) {
+			entry.Subject = string(raw)
+		} else {
+			entry.Subject = hex.EncodeToString(raw)
+		}
+		if entry.Subject == "" {
+			return Entry{}, fmt.Errorf("ldap: entry %q has no %s attribute", found.DN, a.cfg.Attributes.Subject)
+		}
+	}
+
+	if a.cfg.GroupBaseDN == "" {
+		entry.Groups = found.GetEqualFoldAttributeValues(a.cfg.Attributes.MemberOf)
+	} else {
+		// The group search runs as the service account again: users often
+		// cannot read group entries themselves.
+		if err := a.bindService(conn); err != nil {
+			return Entry{}, err
+		}
+		if entry.Groups, err = a.searchGroups(conn, found.DN); err != nil {
+			return Entry{}, a.wrap(ctx, "search groups", err)
+		}
+	}
+
+	if !a.hasRequiredGroup(entry.Groups) {
+		return Entry{}, fmt.Errorf("%w: %s is not in a required group", ErrInvalidCredentials, entry.DN)
+	}
+	return entry, nil
+}
+
+func (a *Authenticator) dial(ctx context.Context) (*goldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: a.cfg.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := goldap.DialURL(a.cfg.URL,
+		goldap.DialWithDialer(dialer),
+		goldap.DialWithTLSConfig(a.cfg.TLSConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: dial %s: %w", a.host, err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(a.cfg.TLSConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: start tls with %s: %w", a.host, err)
+		}
+	}
+	return conn, nil
+}
+
+func (a *Authenticator) bindService(conn *goldap.Conn) error {
+	if a.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+		return fmt.Errorf("ldap: bind service account: %w", err)
+	}
+	return nil
+}
+
+func (a *Authenticator) searchGroups(conn *goldap.Conn, dn string) ([]string, error) {
+	filter := strings.ReplaceAll(a.cfg.GroupFilter, DNPlaceholder, goldap.EscapeFilter(dn))
+	res, err := conn.Search(goldap.NewSearchRequest(
+		a.cfg.GroupBaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		maxGroups, int(a.cfg.Timeout.Seconds()), false, filter, []string{"1.1"}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		groups = append(groups, e.DN)
+	}
+	return groups, nil
+}
+
+func (a *Authenticator) hasRequiredGroup(groups []string) bool {
+	if len(a.cfg.RequiredGroups) == 0 {
+		return true
+	}
+	for _, required := range a.cfg.RequiredGroups {
+		for _, g := range groups {
+			if strings.EqualFold(required, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wrap prefers the context error when cancellation closed the connection.
+func (a *Authenticator) wrap(ctx context.Context, op string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("ldap: %s: %w", op, ctxErr)
+	}
+	return fmt.Errorf("ldap: %s: %w", op, err)
+}
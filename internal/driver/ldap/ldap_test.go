@@ -0,0 +1,217 @@
+package ldap_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rjnemo/auth/internal/driver/ldap"
+	"github.com/rjnemo/auth/internal/driver/ldap/ldaptest"
+)
+
+const (
+	serviceDN       = "cn=svc-auth,ou=services,dc=example,dc=test"
+	servicePassword = "service-secret"
+	adaDN           = "uid=ada,ou=people,dc=example,dc=test"
+	engineeringDN   = "cn=engineering,ou=groups,dc=example,dc=test"
+	financeDN       = "cn=finance,ou=groups,dc=example,dc=test"
+)
+
+func newDirectory(t *testing.T, mode ldaptest.Mode) *ldaptest.Server {
+	t.Helper()
+	dir := ldaptest.NewServer(t, mode)
+	dir.Add(ldaptest.Entry{DN: serviceDN, Password: servicePassword})
+	dir.Add(ldaptest.Entry{
+		DN:       adaDN,
+		Password: "correct horse",
+		Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"ada"},
+			"mail":        {"ada@example.test"},
+			"displayName": {"Ada Lovelace"},
+			"givenName":   {"Ada"},
+			"sn":          {"Lovelace"},
+			"entryUUID":   {"3f1b0c3e-0000-4000-8000-000000000001"},
+			"objectGUID":  {"\x8a\xff\x10\x00"},
+			"memberOf":    {engineeringDN},
+		},
+	})
+	dir.Add(ldaptest.Entry{
+		DN:         engineeringDN,
+		Attributes: map[string][]string{"objectClass": {"groupOfNames"}, "member": {adaDN}},
+	})
+	dir.Add(ldaptest.Entry{
+		DN:         financeDN,
+		Attributes: map[string][]string{"objectClass": {"groupOfNames"}, "member": {"uid=grace,ou=people,dc=example,dc=test"}},
+	})
+	return dir
+}
+
+func newAuthenticator(t *testing.T, dir *ldaptest.Server, mutate func(*ldap.Config)) *ldap.Authenticator {
+	t.Helper()
+	cfg := ldap.Config{
+		URL:          dir.URL(),
+		StartTLS:     strings.HasPrefix(dir.URL(), "ldap://"),
+		TLSConfig:    dir.ClientTLSConfig(),
+		BindDN:       serviceDN,
+		BindPassword: servicePassword,
+		UserBaseDN:   "ou=people,dc=example,dc=test",
+	}
+	if mutate != nil {
+		mutate(&cfg)
+	}
+	a, err := ldap.New(cfg)
+	if err != nil {
+		t.Fatalf("new authenticator: %v", err)
+	}
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	for name, mode := range map[string]ldaptest.Mode{"ldaps": ldaptest.LDAPS, "start tls": ldaptest.StartTLS} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := newDirectory(t, mode)
+			a := newAuthenticator(t, dir, nil)
+
+			entry, err := a.Authenticate(context.Background(), "Ada@Example.test", "correct horse")
+			if err != nil {
+				t.Fatalf("authenticate: %v", err)
+			}
+			if entry.DN != adaDN || entry.Subject != adaDN {
+				t.Fatalf("unexpected dn/subject %q/%q", entry.DN, entry.Subject)
+			}
+			if entry.Name != "Ada Lovelace" || entry.GivenName != "Ada" || entry.FamilyName != "Lovelace" {
+				t.Fatalf("unexpected profile %+v", entry)
+			}
+			if !slices.Equal(entry.Groups, []string{engineeringDN}) {
+				t.Fatalf("expected memberOf groups, got %v", entry.Groups)
+			}
+			if binds := dir.Binds(); !slices.Equal(binds, []string{serviceDN, adaDN}) {
+				t.Fatalf("expected service then user bind, got %v", binds)
+			}
+		})
+	}
+}
+
+func TestAuthenticateGroupSearch(t *testing.T) {
+	t.Parallel()
+
+	dir := newDirectory(t, ldaptest.LDAPS)
+	a := newAuthenticator(t, dir, func(cfg *ldap.Config) {
+		cfg.GroupBaseDN = "ou=groups,dc=example,dc=test"
+		cfg.Attributes.Subject = "entryUUID"
+	})
+
+	entry, err := a.Authenticate(context.Background(), "ada@example.test", "correct horse")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if entry.Subject != "3f1b0c3e-0000-4000-8000-000000000001" {
+		t.Fatalf("expected entryUUID subject, got %q", entry.Subject)
+	}
+	if !slices.Equal(entry.Groups, []string{engineeringDN}) {
+		t.Fatalf("expected group search result, got %v", entry.Groups)
+	}
+	// The group search runs after rebinding as the service account.
+	if binds := dir.Binds(); !slices.Equal(binds, []string{serviceDN, adaDN, serviceDN}) {
+		t.Fatalf("expected rebind before group search, got %v", binds)
+	}
+
+	binary := newAuthenticator(t, dir, func(cfg *ldap.Config) { cfg.Attributes.Subject = "objectGUID" })
+	if entry, err := binary.Authenticate(context.Background(), "ada@example.test", "correct horse"); err != nil || entry.Subject != "8aff1000" {
+		t.Fatalf("expected hex-encoded binary subject, got %q (%v)", entry.Subject, err)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	t.Parallel()
+
+	dir := newDirectory(t, ldaptest.LDAPS)
+	dir.Add(ldaptest.Entry{
+		DN:         "uid=ada2,ou=people,dc=example,dc=test",
+		Password:   "other",
+		Attributes: map[string][]string{"mail": {"shared@example.test"}},
+	})
+	dir.Add(ldaptest.Entry{
+		DN:         "uid=ada3,ou=people,dc=example,dc=test",
+		Password:   "other",
+		Attributes: map[string][]string{"mail": {"shared@example.test"}},
+	})
+
+	tests := map[string]struct {
+		username, password string
+		mutate             func(*ldap.Config)
+		want               error
+	}{
+		"wrong password":   {username: "ada@example.test", password: "wrong", want: ldap.ErrInvalidCredentials},
+		"empty password":   {username: "ada@example.test", password: "", want: ldap.ErrInvalidCredentials},
+		"unknown user":     {username: "nobody@example.test", password: "correct horse", want: ldap.ErrInvalidCredentials},
+		"filter injection": {username: "*", password: "correct horse", want: ldap.ErrInvalidCredentials},
+		"ambiguous user":   {username: "shared@example.test", password: "other", want: ldap.ErrAmbiguousUser},
+		"missing required group": {
+			username: "ada@example.test", password: "correct horse",
+			mutate: func(cfg *ldap.Config) { cfg.RequiredGroups = []string{financeDN} },
+			want:   ldap.ErrInvalidCredentials,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := newAuthenticator(t, dir, tt.mutate)
+			_, err := a.Authenticate(context.Background(), tt.username, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("required group held", func(t *testing.T) {
+		a := newAuthenticator(t, dir, func(cfg *ldap.Config) {
+			cfg.RequiredGroups = []string{financeDN, strings.ToUpper(engineeringDN)}
+		})
+		if _, err := a.Authenticate(context.Background(), "ada@example.test", "correct horse"); err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+	})
+
+	t.Run("wrong service password", func(t *testing.T) {
+		a := newAuthenticator(t, dir, func(cfg *ldap.Config) { cfg.BindPassword = "nope" })
+		_, err := a.Authenticate(context.Background(), "ada@example.test", "correct horse")
+		if err == nil || errors.Is(err, ldap.ErrInvalidCredentials) {
+			t.Fatalf("expected a configuration error, got %v", err)
+		}
+	})
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		a := newAuthenticator(t, dir, func(cfg *ldap.Config) { cfg.TLSConfig = nil })
+		_, err := a.Authenticate(context.Background(), "ada@example.test", "correct horse")
+		if err == nil || errors.Is(err, ldap.ErrInvalidCredentials) {
+			t.Fatalf("expected a tls error, got %v", err)
+		}
+	})
+}
+
+func TestNewRequiresTLS(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]ldap.Config{
+		"plain ldap":          {URL: "ldap://dc.example.test", UserBaseDN: "dc=example,dc=test"},
+		"ldaps with starttls": {URL: "ldaps://dc.example.test", StartTLS: true, UserBaseDN: "dc=example,dc=test"},
+		"unknown scheme":      {URL: "https://dc.example.test", UserBaseDN: "dc=example,dc=test"},
+		"missing base dn":     {URL: "ldaps://dc.example.test"},
+		"filter placeholder":  {URL: "ldaps://dc.example.test", UserBaseDN: "dc=example,dc=test", UserFilter: "(uid=ada)"},
+		"malformed filter":    {URL: "ldaps://dc.example.test", UserBaseDN: "dc=example,dc=test", UserFilter: "(uid={username}"},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ldap.New(cfg); err == nil {
+				t.Fatal("expected config error")
+			}
+		})
+	}
+}
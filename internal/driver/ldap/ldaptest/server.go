@@ -0,0 +1,501 @@
+// Package ldaptest provides an in-process LDAP directory speaking the subset of
+// the protocol used by search-then-bind authentication: simple binds, searches
+// with equality, presence and boolean filters, and StartTLS.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// Mode selects how clients secure the connection.
+type Mode int
+
+const (
+	// LDAPS serves TLS from the first byte (ldaps://).
+	LDAPS Mode = iota
+	// StartTLS serves plain LDAP that must be upgraded before binding (ldap://).
+	StartTLS
+)
+
+const (
+	appBindRequest      ber.Tag = 0
+	appBindResponse     ber.Tag = 1
+	appUnbindRequest    ber.Tag = 2
+	appSearchRequest    ber.Tag = 3
+	appSearchEntry      ber.Tag = 4
+	appSearchDone       ber.Tag = 5
+	appExtendedRequest  ber.Tag = 23
+	appExtendedResponse ber.Tag = 24
+
+	filterAnd      ber.Tag = 0
+	filterOr       ber.Tag = 1
+	filterNot      ber.Tag = 2
+	filterEquality ber.Tag = 3
+	filterPresent  ber.Tag = 7
+
+	scopeBase = 0
+
+	resultSuccess             = 0
+	resultProtocolError       = 2
+	resultSizeLimitExceeded   = 4
+	resultConfidentiality     = 13
+	resultNoSuchObject        = 32
+	resultInvalidCredentials  = 49
+	resultInsufficientAccess  = 50
+	resultUnwillingToPerform  = 53
+	startTLSOID               = "1.3.6.1.4.1.1466.20037"
+	noAttributesSelector      = "1.1"
+	allUserAttributesSelector = "*"
+)
+
+// Entry is a directory object. Password, when set, is accepted for simple
+// binds as DN and is never returned by searches.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is an in-memory directory listening on 127.0.0.1.
+type Server struct {
+	mode     Mode
+	listener net.Listener
+	tls      *tls.Config
+	certPEM  []byte
+	// AllowAnonymous permits searches before any bind.
+	AllowAnonymous bool
+
+	mu      sync.Mutex
+	entries []Entry
+	binds   []string
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a directory secured according to mode; it is closed when
+// the test completes.
+func NewServer(t testing.TB, mode Mode) *Server {
+	t.Helper()
+
+	cert, certPEM := selfSigned(t)
+	s := &Server{
+		mode:    mode,
+		tls:     &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		certPEM: certPEM,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ldaptest: listen: %v", err)
+	}
+	if mode == LDAPS {
+		listener = tls.NewListener(listener, s.tls)
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+// URL returns the ldaps:// or ldap:// URL of the server.
+func (s *Server) URL() string {
+	if s.mode == LDAPS {
+		return "ldaps://" + s.listener.Addr().String()
+	}
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// CACertPEM returns the PEM certificate clients must trust.
+func (s *Server) CACertPEM() []byte {
+	return s.certPEM
+}
+
+// ClientTLSConfig returns a client configuration trusting the server.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(s.certPEM)
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+}
+
+// Add stores entry, replacing any entry with the same DN.
+func (s *Server) Add(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if strings.EqualFold(e.DN, entry.DN) {
+			s.entries[i] = entry
+			return
+		}
+	}
+	s.entries = append(s.entries, entry)
+}
+
+// Binds returns the DNs of every successful bind, in order.
+func (s *Server) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+func (s *Server) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// session is the per-connection state.
+type session struct {
+	conn    net.Conn
+	secure  bool
+	bound   bool
+	boundDN string
+}
+
+func (s *Server) handle(conn net.Conn) {
+	sess := &session{conn: conn, secure: s.mode == LDAPS}
+	defer func() {
+		sess.conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		packet, err := ber.ReadPacket(sess.conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id, ok := packet.Children[0].Value.(int64)
+		if !ok {
+			return
+		}
+		op := packet.Children[1]
+		if op.ClassType != ber.ClassApplication {
+			return
+		}
+		switch op.Tag {
+		case appBindRequest:
+			s.bind(sess, id, op)
+		case appSearchRequest:
+			s.search(sess, id, op)
+		case appExtendedRequest:
+			if !s.extended(sess, id, op) {
+				return
+			}
+		case appUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) bind(sess *session, id int64, op *ber.Packet) {
+	if len(op.Children) < 3 || op.Children[2].ClassType != ber.ClassContext || op.Children[2].Tag != 0 {
+		s.respond(sess, id, appBindResponse, resultProtocolError, "only simple binds are supported")
+		return
+	}
+	if !sess.secure {
+		s.respond(sess, id, appBindResponse, resultConfidentiality, "bind requires TLS")
+		return
+	}
+	dn, password := text(op.Children[1]), text(op.Children[2])
+	sess.bound, sess.boundDN = false, ""
+	if password == "" {
+		// RFC 4513 unauthenticated bind: succeeds without authenticating.
+		s.respond(sess, id, appBindResponse, resultSuccess, "")
+		return
+	}
+	entry, ok := s.lookup(dn)
+	if !ok || entry.Password == "" || entry.Password != password {
+		s.respond(sess, id, appBindResponse, resultInvalidCredentials, "invalid credentials")
+		return
+	}
+	sess.bound, sess.boundDN = true, entry.DN
+	s.mu.Lock()
+	s.binds = append(s.binds, entry.DN)
+	s.mu.Unlock()
+	s.respond(sess, id, appBindResponse, resultSuccess, "")
+}
+
+func (s *Server) search(sess *session, id int64, op *ber.Packet) {
+	if len(op.Children) < 8 {
+		s.respond(sess, id, appSearchDone, resultProtocolError, "malformed search")
+		return
+	}
+	if !sess.bound && !s.AllowAnonymous {
+		s.respond(sess, id, appSearchDone, resultInsufficientAccess, "bind required")
+		return
+	}
+	base := text(op.Children[0])
+	scope, _ := op.Children[1].Value.(int64)
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var selected []string
+	for _, a := range op.Children[7].Children {
+		selected = append(selected, text(a))
+	}
+
+	if _, ok := s.lookup(base); !ok && !s.hasDescendants(base) {
+		s.respond(sess, id, appSearchDone, resultNoSuchObject, "no such object")
+		return
+	}
+
+	s.mu.Lock()
+	entries := append([]Entry(nil), s.entries...)
+	s.mu.Unlock()
+
+	sent := int64(0)
+	for _, e := range entries {
+		if !inScope(e.DN, base, scope) {
+			continue
+		}
+		match, err := matches(e, filter)
+		if err != nil {
+			s.respond(sess, id, appSearchDone, resultUnwillingToPerform, err.Error())
+			return
+		}
+		if !match {
+			continue
+		}
+		if sizeLimit > 0 && sent == sizeLimit {
+			s.respond(sess, id, appSearchDone, resultSizeLimitExceeded, "size limit exceeded")
+			return
+		}
+		s.write(sess, envelope(id, searchEntry(e, selected)))
+		sent++
+	}
+	s.respond(sess, id, appSearchDone, resultSuccess, "")
+}
+
+// extended handles StartTLS and reports whether the connection stays open.
+func (s *Server) extended(sess *session, id int64, op *ber.Packet) bool {
+	if len(op.Children) == 0 || text(op.Children[0]) != startTLSOID {
+		s.respond(sess, id, appExtendedResponse, resultProtocolError, "unsupported extended operation")
+		return true
+	}
+	if sess.secure {
+		s.respond(sess, id, appExtendedResponse, resultProtocolError, "already secured")
+		return true
+	}
+	s.respond(sess, id, appExtendedResponse, resultSuccess, "")
+	tlsConn := tls.Server(sess.conn, s.tls)
+	if err := tlsConn.Handshake(); err != nil {
+		return false
+	}
+	sess.conn, sess.secure = tlsConn, true
+	return true
+}
+
+func (s *Server) lookup(dn string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+func (s *Server) hasDescendants(dn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if inScope(e.DN, dn, 2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) respond(sess *session, id int64, tag ber.Tag, code int64, message string) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	s.write(sess, envelope(id, op))
+}
+
+func (s *Server) write(sess *session, packet *ber.Packet) {
+	sess.conn.Write(packet.Bytes())
+}
+
+func envelope(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.NewSequence("LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func searchEntry(e Entry, selected []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appSearchEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	attrs := ber.NewSequence("Attributes")
+	for name, values := range e.Attributes {
+		if !isSelected(name, selected) {
+			continue
+		}
+		attr := ber.NewSequence("Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return op
+}
+
+func isSelected(name string, selected []string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, s := range selected {
+		if s == noAttributesSelector {
+			return false
+		}
+		if s == allUserAttributesSelector || strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func inScope(dn, base string, scope int64) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	if scope == scopeBase {
+		return dn == base
+	}
+	return dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+func matches(e Entry, filter *ber.Packet) (bool, error) {
+	if filter.ClassType != ber.ClassContext {
+		return false, errors.New("malformed filter")
+	}
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if ok, err := matches(e, child); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case filterOr:
+		for _, child := range filter.Children {
+			if ok, err := matches(e, child); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case filterNot:
+		if len(filter.Children) != 1 {
+			return false, errors.New("malformed not filter")
+		}
+		ok, err := matches(e, filter.Children[0])
+		return !ok, err
+	case filterEquality:
+		if len(filter.Children) != 2 {
+			return false, errors.New("malformed equality filter")
+		}
+		name, want := text(filter.Children[0]), text(filter.Children[1])
+		for _, v := range attribute(e, name) {
+			if strings.EqualFold(v, want) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case filterPresent:
+		name := text(filter)
+		return strings.EqualFold(name, "objectClass") || len(attribute(e, name)) > 0, nil
+	default:
+		return false, errors.New("unsupported filter")
+	}
+}
+
+func attribute(e Entry, name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// text returns the raw content of a primitive packet.
+func text(p *ber.Packet) string {
+	if p.Data == nil {
+		return ""
+	}
+	return p.Data.String()
+}
+
+func selfSigned(t testing.TB) (tls.Certificate, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ldaptest: generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("ldaptest: create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
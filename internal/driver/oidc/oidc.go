@@ -0,0 +1,340 @@
+// Package oidc implements an OpenID Connect relying party: provider discovery
+// and ID-token validation, with keys cached by identity.KeySet, on top of
+// golang.org/x/oauth2.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/oauth2"
+
+	"github.com/rjnemo/auth/identity"
+)
+
+const (
+	// GoogleIssuer is the issuer identifier published by Google's OpenID provider.
+	GoogleIssuer = "https://accounts.google.com"
+
+	discoveryPath    = "/.well-known/openid-configuration"
+	metadataLifetime = 24 * time.Hour
+	clockLeeway      = time.Minute
+	maxResponseBytes = 1 << 20
+)
+
+var (
+	// ErrInvalidIDToken indicates the ID token failed signature or claim validation.
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+	// ErrMissingIDToken indicates the token response did not include an id_token.
+	ErrMissingIDToken = errors.New("oidc: token response missing id_token")
+
+	defaultScopes = []string{"openid", "email", "profile"}
+
+	supportedAlgorithms = []jose.SignatureAlgorithm{
+		jose.RS256, jose.RS384, jose.RS512,
+		jose.PS256, jose.PS384, jose.PS512,
+		jose.ES256, jose.ES384, jose.ES512,
+		jose.EdDSA,
+	}
+)
+
+// Config describes a relying-party registration with an OpenID provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes defaults to openid, email and profile when empty.
+	Scopes []string
+	// HTTPClient is used for discovery, JWKS and token requests; defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Metadata is the subset of the provider discovery document the relying party relies on.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Claims holds the validated ID-token claims consumed by the application.
+type Claims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      jwt.Audience `json:"aud"`
+	AuthorizedBy  string       `json:"azp"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified bool         `json:"email_verified"`
+	Name          string       `json:"name"`
+	GivenName     string       `json:"given_name"`
+	FamilyName    string       `json:"family_name"`
+	Picture       string       `json:"picture"`
+	Locale        string       `json:"locale"`
+	HostedDomain  string       `json:"hd"`
+}
+
+// Provider is a relying party bound to a single OpenID provider. Discovery and
+// key material are fetched lazily and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	keys   *identity.KeySet
+
+	mu         sync.Mutex
+	metadata   *Metadata
+	discovered time.Time
+	now        func() time.Time
+}
+
+// New validates cfg and returns a relying party. No network calls are made.
+func New(cfg Config) (*Provider, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || issuer.Scheme == "" || issuer.Host == "" {
+		return nil, fmt.Errorf("oidc: invalid issuer %q", cfg.Issuer)
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("oidc: client id required")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = defaultScopes
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	p := &Provider{cfg: cfg, client: client, now: time.Now}
+	p.keys = identity.NewKeySet(client, p.jwksURI)
+	return p, nil
+}
+
+// Issuer returns the configured issuer identifier.
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// Discover returns the provider metadata, fetching the discovery document when
+// the cached copy is missing or stale.
+func (p *Provider) Discover(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil && p.now().Sub(p.discovered) < metadataLifetime {
+		return *p.metadata, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + discoveryPath
+	var md Metadata
+	if err := getJSON(ctx, p.client, endpoint, &md); err != nil {
+		return Metadata{}, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != strings.TrimSuffix(p.cfg.Issuer, "/") {
+		return Metadata{}, fmt.Errorf("oidc: discovery issuer %q does not match %q", md.Issuer, p.cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return Metadata{}, errors.New("oidc: discovery document missing required endpoints")
+	}
+
+	p.metadata = &md
+	p.discovered = p.now()
+	return md, nil
+}
+
+// OAuth2Config builds the oauth2 client configuration from discovered endpoints.
+func (p *Provider) OAuth2Config(ctx context.Context) (*oauth2.Config, error) {
+	md, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  md.AuthorizationEndpoint,
+			TokenURL: md.TokenEndpoint,
+		},
+	}, nil
+}
+
+// AuthCodeURL returns the authorization endpoint URL carrying state and nonce.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce string, opts ...oauth2.AuthCodeOption) (string, error) {
+	conf, err := p.OAuth2Config(ctx)
+	if err != nil {
+		return "", err
+	}
+	opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	return conf.AuthCodeURL(state, opts...), nil
+}
+
+// Exchange redeems an authorization code and validates the returned ID token
+// against the expected nonce.
+func (p *Provider) Exchange(ctx context.Context, code, nonce string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, Claims, error) {
+	conf, err := p.OAuth2Config(ctx)
+	if err != nil {
+		return nil, Claims{}, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := conf.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, Claims{}, fmt.Errorf("oidc: exchange code: %w", err)
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, Claims{}, ErrMissingIDToken
+	}
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return nil, Claims{}, err
+	}
+	return token, claims, nil
+}
+
+// Refresh redeems refreshToken for a new access token. Providers may omit the
+// ID token and the rotated refresh token on refresh, so neither is required.
+func (p *Provider) Refresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	conf, err := p.OAuth2Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := conf.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("oidc: refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// VerifyIDToken checks the token signature against the provider JWKS and
+// validates iss, aud, azp, exp and nonce.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	tok, err := jwt.ParseSigned(raw, supportedAlgorithms)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if len(tok.Headers) != 1 {
+		return Claims{}, fmt.Errorf("%w: expected a single signature", ErrInvalidIDToken)
+	}
+
+	key, err := p.keys.Key(ctx, tok.Headers[0].KeyID, p.now())
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var (
+		std    jwt.Claims
+		claims Claims
+	)
+	if err := tok.Claims(key, &std, &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if std.Expiry == nil {
+		return Claims{}, fmt.Errorf("%w: missing exp", ErrInvalidIDToken)
+	}
+	expected := jwt.Expected{
+		Issuer:      p.cfg.Issuer,
+		AnyAudience: jwt.Audience{p.cfg.ClientID},
+		Time:        p.now(),
+	}
+	if err := std.ValidateWithLeeway(expected, clockLeeway); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.cfg.ClientID {
+		return Claims{}, fmt.Errorf("%w: azp %q does not match client", ErrInvalidIDToken, claims.AuthorizedBy)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if strings.TrimSpace(claims.Subject) == "" {
+		return Claims{}, fmt.Errorf("%w: missing sub", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) jwksURI(ctx context.Context) (string, error) {
+	md, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return md.JWKSURI, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, endpoint string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := io.LimitReader(resp.Body, maxResponseBytes)
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(body, 512))
+		return fmt.Errorf("GET %s: status %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	if err := json.NewDecoder(body).Decode(dest); err != nil {
+		return fmt.Errorf("decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes claims, tolerating string-encoded email_verified values.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	aux := struct {
+		*plain
+		EmailVerified flexibleBool `json:"email_verified"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.EmailVerified = bool(aux.EmailVerified)
+	return nil
+}
+
+// flexibleBool accepts both JSON booleans and the "true"/"false" strings some
+// providers emit for email_verified.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case bool:
+		*b = flexibleBool(value)
+	case string:
+		*b = flexibleBool(strings.EqualFold(value, "true"))
+	case nil:
+		*b = false
+	default:
+		return fmt.Errorf("oidc: unexpected boolean value %s", string(data))
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rjnemo/auth/internal/driver/oidc"
+	"github.com/rjnemo/auth/internal/driver/oidc/oidctest"
+)
+
+func newProvider(t *testing.T, iss *oidctest.Issuer) *oidc.Provider {
+	t.Helper()
+
+	p, err := oidc.New(oidc.Config{
+		Issuer:       iss.URL(),
+		ClientID:     iss.ClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/callback",
+		HTTPClient:   iss.Client(),
+	})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	return p
+}
+
+func TestProviderAuthCodeURL(t *testing.T) {
+	t.Parallel()
+
+	iss := oidctest.NewIssuer(t, "client")
+	p := newProvider(t, iss)
+
+	raw, err := p.AuthCodeURL(context.Background(), "state-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("auth code url: %v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != iss.URL()+"/authorize" {
+		t.Fatalf("expected discovered authorization endpoint, got %q", got)
+	}
+	q := u.Query()
+	if q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("client_id") != "client" {
+		t.Fatalf("unexpected query %v", q)
+	}
+	if q.Get("scope") != "openid email profile" {
+		t.Fatalf("expected default scopes, got %q", q.Get("scope"))
+	}
+}
+
+func TestProviderExchange(t *testing.T) {
+	t.Parallel()
+
+	iss := oidctest.NewIssuer(t, "client")
+	p := newProvider(t, iss)
+
+	identity := oidctest.Identity{Subject: "sub-123", Email: "person@example.com", EmailVerified: true, Name: "Person"}
+	code := iss.Authorize(identity, "nonce-1")
+
+	token, claims, err := p.Exchange(context.Background(), code, "nonce-1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if token.AccessToken == "" {
+		t.Fatal("expected access token")
+	}
+	if claims.Subject != "sub-123" || claims.Email != "person@example.com" || !claims.EmailVerified || claims.Name != "Person" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, _, err := p.Exchange(context.Background(), iss.Authorize(identity, "nonce-2"), "nonce-1"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("expected nonce mismatch to fail, got %v", err)
+	}
+}
+
+func TestProviderRefresh(t *testing.T) {
+	t.Parallel()
+
+	iss := oidctest.NewIssuer(t, "client")
+	p := newProvider(t, iss)
+
+	identity := oidctest.Identity{Subject: "sub-123", Email: "person@example.com", EmailVerified: true}
+	token, _, err := p.Exchange(context.Background(), iss.Authorize(identity, "nonce-1"), "nonce-1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if token.RefreshToken == "" {
+		t.Fatal("expected refresh token")
+	}
+
+	refreshed, err := p.Refresh(context.Background(), token.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if refreshed.AccessToken == "" || refreshed.AccessToken == token.AccessToken {
+		t.Fatalf("expected a new access token, got %q", refreshed.AccessToken)
+	}
+	if iss.Refreshes() != 1 {
+		t.Fatalf("expected one refresh grant, got %d", iss.Refreshes())
+	}
+
+	if _, err := p.Refresh(context.Background(), "unknown"); err == nil {
+		t.Fatal("expected unknown refresh token to fail")
+	}
+}
+
+func TestProviderVerifyIDToken(t *testing.T) {
+	t.Parallel()
+
+	iss := oidctest.NewIssuer(t, "client")
+	other := oidctest.NewIssuer(t, "client")
+	p := newProvider(t, iss)
+	identity := oidctest.Identity{Subject: "sub", Email: "person@example.com", EmailVerified: true}
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := map[string]struct {
+		raw     string
+		wantErr bool
+	}{
+		"valid":              {raw: iss.SignIDToken(identity, "n", nil)},
+		"string verified":    {raw: iss.SignIDToken(identity, "n", map[string]any{"email_verified": "true"})},
+		"multi aud with azp": {raw: iss.SignIDToken(identity, "n", map[string]any{"aud": []string{"client", "other"}, "azp": "client"})},
+		"wrong issuer":       {raw: iss.SignIDToken(identity, "n", map[string]any{"iss": "https://evil.example.com"}), wantErr: true},
+		"wrong audience":     {raw: iss.SignIDToken(identity, "n", map[string]any{"aud": "someone-else"}), wantErr: true},
+		"multi aud no azp":   {raw: iss.SignIDToken(identity, "n", map[string]any{"aud": []string{"client", "other"}}), wantErr: true},
+		"expired":            {raw: iss.SignIDToken(identity, "n", map[string]any{"exp": past}), wantErr: true},
+		"missing exp":        {raw: iss.SignIDToken(identity, "n", map[string]any{"exp": nil}), wantErr: true},
+		"wrong nonce":        {raw: iss.SignIDToken(identity, "other", nil), wantErr: true},
+		"foreign signature":  {raw: other.SignIDToken(identity, "n", map[string]any{"iss": iss.URL()}), wantErr: true},
+		"garbage":            {raw: "not.a.jwt", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			claims, err := p.VerifyIDToken(context.Background(), tc.raw, "n")
+			if tc.wantErr {
+				if !errors.Is(err, oidc.ErrInvalidIDToken) {
+					t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.Subject != "sub" || !claims.EmailVerified {
+				t.Fatalf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestProviderCachesJWKS(t *testing.T) {
+	t.Parallel()
+
+	iss := oidctest.NewIssuer(t, "client")
+	p := newProvider(t, iss)
+	identity := oidctest.Identity{Subject: "sub"}
+
+	for range 3 {
+		if _, err := p.VerifyIDToken(context.Background(), iss.SignIDToken(identity, "n", nil), "n"); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if got := iss.JWKSFetches(); got != 1 {
+		t.Fatalf("expected a single JWKS fetch, got %d", got)
+	}
+}
+
+func TestProviderDiscoveryIssuerMismatch(t *testing.T) {
+	t.Parallel()
+
+	iss := oidctest.NewIssuer(t, "client")
+	p, err := oidc.New(oidc.Config{Issuer: iss.URL() + "/tenant", ClientID: "client", HTTPClient: iss.Client()})
+	if err != nil {
+		t.Fatalf("new provider: %v", err)
+	}
+	if _, err := p.Discover(context.Background()); err == nil {
+		t.Fatal("expected discovery to fail for unknown issuer path")
+	}
+}
@@ -0,0 +1,267 @@
+// Package oidctest provides an in-process OpenID provider for tests.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const keyID = "test-key"
+
+// Identity describes the end user the fake issuer authenticates.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	Locale        string
+	HostedDomain  string
+}
+
+// Issuer is a minimal OpenID provider serving discovery, JWKS and token endpoints.
+type Issuer struct {
+	ClientID string
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	signer   jose.Signer
+
+	mu        sync.Mutex
+	codes     map[string]grant
+	refreshes map[string][]string
+	jwksHits  int
+	refreshed int
+}
+
+type grant struct {
+	identity Identity
+	nonce    string
+	scopes   []string
+	form     map[string]string
+}
+
+// NewIssuer starts a fake issuer that accepts clientID as audience. The server
+// is shut down when the test completes.
+func NewIssuer(t testing.TB, clientID string) *Issuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate issuer key: %v", err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), keyID),
+	)
+	if err != nil {
+		t.Fatalf("create signer: %v", err)
+	}
+
+	iss := &Issuer{ClientID: clientID, key: key, signer: signer, codes: make(map[string]grant), refreshes: make(map[string][]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", iss.handleDiscovery)
+	mux.HandleFunc("GET /jwks", iss.handleJWKS)
+	mux.HandleFunc("POST /token", iss.handleToken)
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+
+	return iss
+}
+
+// URL returns the issuer identifier.
+func (i *Issuer) URL() string {
+	return i.server.URL
+}
+
+// Client returns an HTTP client that trusts the fake issuer.
+func (i *Issuer) Client() *http.Client {
+	return i.server.Client()
+}
+
+// Authorize simulates the user approving the request and returns an
+// authorization code bound to the supplied nonce.
+func (i *Issuer) Authorize(identity Identity, nonce string) string {
+	return i.AuthorizeScopes(identity, nonce, []string{"openid", "email", "profile"})
+}
+
+// AuthorizeScopes is Authorize for a consent covering exactly scopes.
+func (i *Issuer) AuthorizeScopes(identity Identity, nonce string, scopes []string) string {
+	code := randomString()
+	i.mu.Lock()
+	i.codes[code] = grant{identity: identity, nonce: nonce, scopes: scopes}
+	i.mu.Unlock()
+	return code
+}
+
+// LastTokenRequest returns the form values of the most recent code redemption.
+func (i *Issuer) LastTokenRequest(code string) map[string]string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.codes[code].form
+}
+
+// Refreshes reports how many refresh_token grants were redeemed.
+func (i *Issuer) Refreshes() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.refreshed
+}
+
+// JWKSFetches reports how often the JWKS endpoint was requested.
+func (i *Issuer) JWKSFetches() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.jwksHits
+}
+
+// SignIDToken mints an ID token for identity; overrides replace standard claims.
+func (i *Issuer) SignIDToken(identity Identity, nonce string, overrides map[string]any) string {
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            i.URL(),
+		"sub":            identity.Subject,
+		"aud":            i.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(10 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+	}
+	if identity.Name != "" {
+		claims["name"] = identity.Name
+	}
+	if identity.Picture != "" {
+		claims["picture"] = identity.Picture
+	}
+	if identity.Locale != "" {
+		claims["locale"] = identity.Locale
+	}
+	if identity.HostedDomain != "" {
+		claims["hd"] = identity.HostedDomain
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+
+	raw, err := jwt.Signed(i.signer).Claims(claims).Serialize()
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func (i *Issuer) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                i.URL(),
+		"authorization_endpoint":                i.URL() + "/authorize",
+		"token_endpoint":                        i.URL() + "/token",
+		"jwks_uri":                              i.URL() + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *Issuer) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	i.mu.Lock()
+	i.jwksHits++
+	i.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       i.key.Public(),
+		KeyID:     keyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+func (i *Issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") == "refresh_token" {
+		i.handleRefresh(w, r.PostForm.Get("refresh_token"))
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	i.mu.Lock()
+	g, ok := i.codes[code]
+	if ok {
+		g.form = make(map[string]string, len(r.PostForm))
+		for k := range r.PostForm {
+			g.form[k] = r.PostForm.Get(k)
+		}
+		i.codes[code] = g
+	}
+	i.mu.Unlock()
+
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	refreshToken := randomString()
+	i.mu.Lock()
+	i.refreshes[refreshToken] = g.scopes
+	i.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  randomString(),
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(g.scopes, " "),
+		"id_token":      i.SignIDToken(g.identity, g.nonce, nil),
+	})
+}
+
+// handleRefresh issues a new access token for a known refresh token without
+// rotating it, as Google does.
+func (i *Issuer) handleRefresh(w http.ResponseWriter, refreshToken string) {
+	i.mu.Lock()
+	scopes, ok := i.refreshes[refreshToken]
+	if ok {
+		i.refreshed++
+	}
+	i.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
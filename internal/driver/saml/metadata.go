@@ -0,0 +1,137 @@
+package saml
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// IDPMetadata is the subset of identity provider metadata the service
+// provider relies on.
+type IDPMetadata struct {
+	EntityID string
+	// SSOURL is the single sign-on endpoint for the HTTP-Redirect binding.
+	SSOURL string
+	// Certificates are the IdP signing certificates; any of them may sign.
+	Certificates []*x509.Certificate
+}
+
+// ParseIDPMetadata reads an EntityDescriptor published by an identity provider.
+func ParseIDPMetadata(raw []byte) (IDPMetadata, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return IDPMetadata{}, fmt.Errorf("saml: parse idp metadata: %w", err)
+	}
+	entity := doc.Root()
+	if isElement(entity, NamespaceMetadata, "EntitiesDescriptor") {
+		entities := childElements(entity, NamespaceMetadata, "EntityDescriptor")
+		if len(entities) != 1 {
+			return IDPMetadata{}, errors.New("saml: idp metadata must describe exactly one entity")
+		}
+		entity = entities[0]
+	}
+	if !isElement(entity, NamespaceMetadata, "EntityDescriptor") {
+		return IDPMetadata{}, errors.New("saml: idp metadata root is not an EntityDescriptor")
+	}
+	descriptor := childElement(entity, NamespaceMetadata, "IDPSSODescriptor")
+	if descriptor == nil {
+		return IDPMetadata{}, errors.New("saml: idp metadata has no IDPSSODescriptor")
+	}
+
+	md := IDPMetadata{EntityID: entity.SelectAttrValue("entityID", "")}
+	for _, sso := range childElements(descriptor, NamespaceMetadata, "SingleSignOnService") {
+		if sso.SelectAttrValue("Binding", "") == BindingHTTPRedirect {
+			md.SSOURL = sso.SelectAttrValue("Location", "")
+			break
+		}
+	}
+	for _, kd := range childElements(descriptor, NamespaceMetadata, "KeyDescriptor") {
+		if use := kd.SelectAttrValue("use", ""); use != "" && use != "signing" {
+			continue
+		}
+		for _, data := range kd.FindElements("./KeyInfo/X509Data/X509Certificate") {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data.Text()), ""))
+			if err != nil {
+				return IDPMetadata{}, fmt.Errorf("saml: decode idp certificate: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return IDPMetadata{}, fmt.Errorf("saml: parse idp certificate: %w", err)
+			}
+			md.Certificates = append(md.Certificates, cert)
+		}
+	}
+
+	switch {
+	case md.EntityID == "":
+		return IDPMetadata{}, errors.New("saml: idp metadata has no entityID")
+	case md.SSOURL == "":
+		return IDPMetadata{}, errors.New("saml: idp metadata has no HTTP-Redirect SingleSignOnService")
+	case len(md.Certificates) == 0:
+		return IDPMetadata{}, errors.New("saml: idp metadata has no signing certificate")
+	}
+	return md, nil
+}
+
+// Metadata returns the service provider EntityDescriptor to hand to the IdP.
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", NamespaceMetadata)
+	entity.CreateAttr("xmlns:ds", "http://www.w3.org/2000/09/xmldsig#")
+	entity.CreateAttr("entityID", sp.cfg.EntityID)
+
+	descriptor := entity.CreateElement("md:SPSSODescriptor")
+	descriptor.CreateAttr("AuthnRequestsSigned", "true")
+	descriptor.CreateAttr("WantAssertionsSigned", "true")
+	descriptor.CreateAttr("protocolSupportEnumeration", NamespaceProtocol)
+
+	kd := descriptor.CreateElement("md:KeyDescriptor")
+	kd.CreateAttr("use", "signing")
+	kd.CreateElement("ds:KeyInfo").
+		CreateElement("ds:X509Data").
+		CreateElement("ds:X509Certificate").
+		SetText(base64.StdEncoding.EncodeToString(sp.cfg.Certificate.Raw))
+
+	for _, format := range []string{NameIDFormatPersistent, NameIDFormatEmail} {
+		descriptor.CreateElement("md:NameIDFormat").SetText(format)
+	}
+
+	acs := descriptor.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", BindingHTTPPost)
+	acs.CreateAttr("Location", sp.cfg.ACSURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	doc.Indent(2)
+	out, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("saml: encode metadata: %w", err)
+	}
+	return out, nil
+}
+
+// ParseKeyPair decodes the PEM certificate and RSA private key a connection
+// signs AuthnRequests with.
+func ParseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("saml: load key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("saml: key pair must use an RSA key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("saml: parse certificate: %w", err)
+	}
+	return cert, key, nil
+}
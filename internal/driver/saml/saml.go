@@ -0,0 +1,565 @@
+// Package saml implements a SAML 2.0 service provider: AuthnRequests over the
+// HTTP-Redirect binding, assertion validation over the HTTP-POST binding and
+// metadata import and export.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	// NamespaceProtocol is the SAML 2.0 protocol namespace (samlp).
+	NamespaceProtocol = "urn:oasis:names:tc:SAML:2.0:protocol"
+	// NamespaceAssertion is the SAML 2.0 assertion namespace (saml).
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	// NamespaceMetadata is the SAML 2.0 metadata namespace (md).
+	NamespaceMetadata = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	// BindingHTTPRedirect identifies the HTTP-Redirect binding.
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	// BindingHTTPPost identifies the HTTP-POST binding.
+	BindingHTTPPost = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	// NameIDFormatEmail identifies email-address NameIDs.
+	NameIDFormatEmail = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	// NameIDFormatPersistent identifies stable, opaque NameIDs.
+	NameIDFormatPersistent = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	// NameIDFormatTransient identifies per-session NameIDs, which cannot key an account.
+	NameIDFormatTransient = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+
+	statusSuccess        = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer   = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	clockLeeway          = time.Minute
+	requestLifetime      = 10 * time.Minute
+	maxPendingRequests   = 10000
+	maxResponseBytes     = 1 << 20
+	defaultIDAttribute   = "ID"
+	maxAssertionLifetime = 24 * time.Hour
+)
+
+// requestIDPattern accepts xs:ID values, which must not start with a digit.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+var (
+	// ErrInvalidResponse indicates the SAML response failed validation.
+	ErrInvalidResponse = errors.New("saml: invalid response")
+	// ErrUnknownRequest indicates the response answers no pending AuthnRequest.
+	ErrUnknownRequest = errors.New("saml: response does not match a pending request")
+	// ErrUnsolicited indicates an IdP-initiated response on a connection that refuses them.
+	ErrUnsolicited = errors.New("saml: unsolicited response not allowed")
+	// ErrReplayed indicates the assertion was already consumed.
+	ErrReplayed = errors.New("saml: assertion already used")
+)
+
+// Config describes the service provider side of one SAML connection.
+type Config struct {
+	// EntityID identifies the service provider to the IdP.
+	EntityID string
+	// ACSURL is the assertion consumer service URL receiving HTTP-POST responses.
+	ACSURL string
+	// Certificate and Key sign AuthnRequests and are published in metadata.
+	Certificate *x509.Certificate
+	Key         *rsa.PrivateKey
+	IDP         IDPMetadata
+	// AllowIDPInitiated accepts responses that answer no AuthnRequest.
+	AllowIDPInitiated bool
+}
+
+// Assertion holds the validated subject and attributes of a SAML assertion.
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	// InResponseTo is the AuthnRequest ID the assertion answers, empty for
+	// IdP-initiated sign-ins.
+	InResponseTo string
+	Attributes   map[string][]string
+}
+
+// Attribute returns the first value of the named attribute.
+func (a Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ServiceProvider validates responses from a single identity provider. Pending
+// AuthnRequest IDs and consumed assertion IDs are tracked in a Store because
+// the IdP posts cross-site, where the session cookie is not sent.
+type ServiceProvider struct {
+	cfg   Config
+	now   func() time.Time
+	sign  *dsig.SigningContext
+	store Store
+}
+
+// New validates cfg and returns a service provider.
+func New(cfg Config) (*ServiceProvider, error) {
+	if cfg.EntityID == "" || cfg.ACSURL == "" {
+		return nil, errors.New("saml: entity id and acs url required")
+	}
+	if cfg.Certificate == nil || cfg.Key == nil {
+		return nil, errors.New("saml: service provider certificate and key required")
+	}
+	if !cfg.Key.PublicKey.Equal(cfg.Certificate.PublicKey) {
+		return nil, errors.New("saml: service provider key does not match certificate")
+	}
+	if cfg.IDP.EntityID == "" || cfg.IDP.SSOURL == "" || len(cfg.IDP.Certificates) == 0 {
+		return nil, errors.New("saml: idp metadata requires entity id, sso url and signing certificate")
+	}
+	sign, err := dsig.NewSigningContext(cfg.Key, [][]byte{cfg.Certificate.Raw})
+	if err != nil {
+		return nil, fmt.Errorf("saml: signing context: %w", err)
+	}
+	return &ServiceProvider{
+		cfg:   cfg,
+		now:   time.Now,
+		sign:  sign,
+		store: NewMemoryStore(),
+	}, nil
+}
+
+// UseStore replaces the in-memory store, which only protects a single
+// instance, with one shared by every instance. Call it before the service
+// provider handles any request.
+func (sp *ServiceProvider) UseStore(store Store) {
+	sp.store = store
+}
+
+// EntityID returns the service provider entity ID.
+func (sp *ServiceProvider) EntityID() string {
+	return sp.cfg.EntityID
+}
+
+// AuthnRequestURL returns the IdP SSO URL carrying a signed, deflated
+// AuthnRequest with the given ID. The caller picks the ID so it can bind the
+// response to the browser that started the sign-in; it must be an unguessable
+// xs:ID and is remembered until a response answers it.
+func (sp *ServiceProvider) AuthnRequestURL(ctx context.Context, id, relayState string) (string, error) {
+	if !requestIDPattern.MatchString(id) {
+		return "", fmt.Errorf("saml: invalid request id %q", id)
+	}
+	now := sp.now().UTC()
+
+	doc := etree.NewDocument()
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	req.CreateAttr("xmlns:saml", NamespaceAssertion)
+	req.CreateAttr("ID", id)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	req.CreateAttr("Destination", sp.cfg.IDP.SSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", sp.cfg.ACSURL)
+	req.CreateAttr("ProtocolBinding", BindingHTTPPost)
+	req.CreateElement("saml:Issuer").SetText(sp.cfg.EntityID)
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("AllowCreate", "true")
+
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", fmt.Errorf("saml: encode authn request: %w", err)
+	}
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	// The redirect binding signs the exact query string, in this order.
+	query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(sp.sign.GetSignatureMethodIdentifier())
+	signature, err := sp.sign.SignString(query)
+	if err != nil {
+		return "", fmt.Errorf("saml: sign authn request: %w", err)
+	}
+	query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+
+	if err := sp.store.AddRequest(ctx, sp.cfg.EntityID, id, now.Add(requestLifetime), now); err != nil {
+		return "", err
+	}
+
+	sep := "?"
+	if strings.Contains(sp.cfg.IDP.SSOURL, "?") {
+		sep = "&"
+	}
+	return sp.cfg.IDP.SSOURL + sep + query, nil
+}
+
+// ParseResponse decodes a base64 HTTP-POST SAMLResponse and returns its
+// assertion once the signature, issuer, audience, recipient, time conditions
+// and request correlation have been verified. Only the signature-verified copy
+// of the XML is read, which defeats signature-wrapping attacks.
+func (sp *ServiceProvider) ParseResponse(ctx context.Context, encoded string) (Assertion, error) {
+	if len(encoded) > maxResponseBytes {
+		return Assertion{}, fmt.Errorf("%w: response too large", ErrInvalidResponse)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: decode: %v", ErrInvalidResponse, err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return Assertion{}, fmt.Errorf("%w: parse: %v", ErrInvalidResponse, err)
+	}
+	for _, tok := range doc.Child {
+		if _, ok := tok.(*etree.Directive); ok {
+			return Assertion{}, fmt.Errorf("%w: DTDs are not allowed", ErrInvalidResponse)
+		}
+	}
+
+	response := doc.Root()
+	if !isElement(response, NamespaceProtocol, "Response") {
+		return Assertion{}, fmt.Errorf("%w: root is not a samlp:Response", ErrInvalidResponse)
+	}
+	if err := checkStructure(response); err != nil {
+		return Assertion{}, err
+	}
+
+	now := sp.now()
+	envelope, assertionEl, err := sp.verify(response, now)
+	if err != nil {
+		return Assertion{}, err
+	}
+	// An unsigned envelope is only trusted for fields the signed assertion
+	// also binds; Destination and Status are checked as a sanity measure.
+	if dest := envelope.SelectAttrValue("Destination", ""); dest != "" && dest != sp.cfg.ACSURL {
+		return Assertion{}, fmt.Errorf("%w: destination %q", ErrInvalidResponse, dest)
+	}
+	if code := statusCode(envelope); code != statusSuccess {
+		return Assertion{}, fmt.Errorf("%w: status %q", ErrInvalidResponse, code)
+	}
+
+	return sp.consumeAssertion(ctx, assertionEl, envelope.SelectAttrValue("InResponseTo", ""), now)
+}
+
+// verify returns the response envelope and the signature-verified assertion.
+// A signed Response covers its assertion and both are returned from the
+// verified copy; otherwise the assertion must carry its own signature.
+func (sp *ServiceProvider) verify(response *etree.Element, now time.Time) (*etree.Element, *etree.Element, error) {
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: sp.cfg.IDP.Certificates})
+	validator.IdAttribute = defaultIDAttribute
+	validator.Clock = dsig.NewFakeClockAt(now)
+
+	if hasEnvelopedSignature(response) {
+		verified, err := validator.Validate(response)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: response signature: %v", ErrInvalidResponse, err)
+		}
+		assertions := childElements(verified, NamespaceAssertion, "Assertion")
+		if len(assertions) != 1 {
+			return nil, nil, fmt.Errorf("%w: expected one assertion", ErrInvalidResponse)
+		}
+		return verified, assertions[0], nil
+	}
+
+	assertion := childElements(response, NamespaceAssertion, "Assertion")[0]
+	if !hasEnvelopedSignature(assertion) {
+		return nil, nil, fmt.Errorf("%w: neither response nor assertion is signed", ErrInvalidResponse)
+	}
+	// Carry namespaces declared on the Response into the detached assertion.
+	nsCtx, err := etreeutils.NSBuildParentContext(assertion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: namespaces: %v", ErrInvalidResponse, err)
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, assertion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: namespaces: %v", ErrInvalidResponse, err)
+	}
+	verified, err := validator.Validate(detached)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: assertion signature: %v", ErrInvalidResponse, err)
+	}
+	return response, verified, nil
+}
+
+// consumeAssertion checks the verified assertion's conditions and records it
+// as used.
+func (sp *ServiceProvider) consumeAssertion(ctx context.Context, el *etree.Element, envelopeInResponseTo string, now time.Time) (Assertion, error) {
+	issuer := childText(el, NamespaceAssertion, "Issuer")
+	if issuer != sp.cfg.IDP.EntityID {
+		return Assertion{}, fmt.Errorf("%w: issuer %q", ErrInvalidResponse, issuer)
+	}
+	id := el.SelectAttrValue(defaultIDAttribute, "")
+
+	conditions := childElement(el, NamespaceAssertion, "Conditions")
+	if conditions == nil {
+		return Assertion{}, fmt.Errorf("%w: missing conditions", ErrInvalidResponse)
+	}
+	expires, err := checkWindow(conditions, now)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if !hasAudience(conditions, sp.cfg.EntityID) {
+		return Assertion{}, fmt.Errorf("%w: audience does not include %q", ErrInvalidResponse, sp.cfg.EntityID)
+	}
+
+	subject := childElement(el, NamespaceAssertion, "Subject")
+	if subject == nil {
+		return Assertion{}, fmt.Errorf("%w: missing subject", ErrInvalidResponse)
+	}
+	confirmation, err := sp.bearerConfirmation(subject, now)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if confirmation.notOnOrAfter.Before(expires) {
+		expires = confirmation.notOnOrAfter
+	}
+	if envelopeInResponseTo != "" && envelopeInResponseTo != confirmation.inResponseTo {
+		return Assertion{}, fmt.Errorf("%w: InResponseTo mismatch", ErrInvalidResponse)
+	}
+
+	nameID := childElement(subject, NamespaceAssertion, "NameID")
+	if nameID == nil || strings.TrimSpace(nameID.Text()) == "" {
+		return Assertion{}, fmt.Errorf("%w: missing NameID", ErrInvalidResponse)
+	}
+	format := nameID.SelectAttrValue("Format", "")
+	if format == NameIDFormatTransient {
+		return Assertion{}, fmt.Errorf("%w: transient NameIDs cannot identify an account", ErrInvalidResponse)
+	}
+
+	assertion := Assertion{
+		ID:           id,
+		Issuer:       issuer,
+		NameID:       strings.TrimSpace(nameID.Text()),
+		NameIDFormat: format,
+		InResponseTo: confirmation.inResponseTo,
+		Attributes:   attributes(el),
+	}
+	if authn := childElement(el, NamespaceAssertion, "AuthnStatement"); authn != nil {
+		assertion.SessionIndex = authn.SelectAttrValue("SessionIndex", "")
+	}
+
+	if confirmation.inResponseTo == "" && !sp.cfg.AllowIDPInitiated {
+		return Assertion{}, ErrUnsolicited
+	}
+	// The assertion is spent before its request is checked, so a response
+	// posted to two instances at once completes on at most one of them.
+	fresh, err := sp.store.UseAssertion(ctx, sp.cfg.EntityID, id, expires.Add(clockLeeway), now)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if !fresh {
+		return Assertion{}, ErrReplayed
+	}
+	if confirmation.inResponseTo != "" {
+		pending, err := sp.store.TakeRequest(ctx, sp.cfg.EntityID, confirmation.inResponseTo, now)
+		if err != nil {
+			return Assertion{}, err
+		}
+		if !pending {
+			return Assertion{}, ErrUnknownRequest
+		}
+	}
+	return assertion, nil
+}
+
+type bearerConfirmation struct {
+	inResponseTo string
+	notOnOrAfter time.Time
+}
+
+// bearerConfirmation returns the bearer SubjectConfirmationData addressed to
+// this ACS that is valid at now.
+func (sp *ServiceProvider) bearerConfirmation(subject *etree.Element, now time.Time) (bearerConfirmation, error) {
+	for _, sc := range childElements(subject, NamespaceAssertion, "SubjectConfirmation") {
+		if sc.SelectAttrValue("Method", "") != confirmationBearer {
+			continue
+		}
+		data := childElement(sc, NamespaceAssertion, "SubjectConfirmationData")
+		if data == nil || data.SelectAttrValue("Recipient", "") != sp.cfg.ACSURL {
+			continue
+		}
+		if data.SelectAttr("NotBefore") != nil {
+			// Bearer confirmations must not carry NotBefore (profiles 4.1.4.2).
+			continue
+		}
+		notOnOrAfter, err := parseInstant(data.SelectAttrValue("NotOnOrAfter", ""))
+		if err != nil || !now.Before(notOnOrAfter.Add(clockLeeway)) {
+			continue
+		}
+		return bearerConfirmation{
+			inResponseTo: data.SelectAttrValue("InResponseTo", ""),
+			notOnOrAfter: notOnOrAfter,
+		}, nil
+	}
+	return bearerConfirmation{}, fmt.Errorf("%w: no valid bearer subject confirmation for %q", ErrInvalidResponse, sp.cfg.ACSURL)
+}
+
+// checkStructure rejects the document shapes signature-wrapping attacks rely
+// on: duplicate IDs, extra or nested assertions and encrypted assertions the
+// service provider cannot verify.
+func checkStructure(response *etree.Element) error {
+	ids := make(map[string]bool)
+	assertions := 0
+	var walk func(el *etree.Element) error
+	walk = func(el *etree.Element) error {
+		if id := el.SelectAttrValue(defaultIDAttribute, ""); id != "" {
+			if ids[id] {
+				return fmt.Errorf("%w: duplicate ID %q", ErrInvalidResponse, id)
+			}
+			ids[id] = true
+		}
+		if el.Tag == "Assertion" || el.Tag == "EncryptedAssertion" {
+			assertions++
+		}
+		for _, child := range el.ChildElements() {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(response); err != nil {
+		return err
+	}
+	if assertions != 1 || len(childElements(response, NamespaceAssertion, "Assertion")) != 1 {
+		return fmt.Errorf("%w: expected exactly one unencrypted assertion", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// hasEnvelopedSignature reports whether el carries a ds:Signature child
+// referencing el by its ID.
+func hasEnvelopedSignature(el *etree.Element) bool {
+	id := el.SelectAttrValue(defaultIDAttribute, "")
+	if id == "" {
+		return false
+	}
+	for _, sig := range childElements(el, dsig.Namespace, "Signature") {
+		for _, ref := range sig.FindElements("./SignedInfo/Reference") {
+			if ref.SelectAttrValue("URI", "") == "#"+id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkWindow(conditions *etree.Element, now time.Time) (time.Time, error) {
+	if raw := conditions.SelectAttrValue("NotBefore", ""); raw != "" {
+		notBefore, err := parseInstant(raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: NotBefore: %v", ErrInvalidResponse, err)
+		}
+		if now.Add(clockLeeway).Before(notBefore) {
+			return time.Time{}, fmt.Errorf("%w: assertion not yet valid", ErrInvalidResponse)
+		}
+	}
+	expires := now.Add(maxAssertionLifetime)
+	if raw := conditions.SelectAttrValue("NotOnOrAfter", ""); raw != "" {
+		notOnOrAfter, err := parseInstant(raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: NotOnOrAfter: %v", ErrInvalidResponse, err)
+		}
+		if !now.Before(notOnOrAfter.Add(clockLeeway)) {
+			return time.Time{}, fmt.Errorf("%w: assertion expired", ErrInvalidResponse)
+		}
+		expires = notOnOrAfter
+	}
+	return expires, nil
+}
+
+func hasAudience(conditions *etree.Element, entityID string) bool {
+	restrictions := childElements(conditions, NamespaceAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return false
+	}
+	// Every AudienceRestriction must name this service provider.
+	for _, restriction := range restrictions {
+		found := false
+		for _, audience := range childElements(restriction, NamespaceAssertion, "Audience") {
+			if strings.TrimSpace(audience.Text()) == entityID {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func attributes(assertion *etree.Element) map[string][]string {
+	attrs := make(map[string][]string)
+	for _, statement := range childElements(assertion, NamespaceAssertion, "AttributeStatement") {
+		for _, attr := range childElements(statement, NamespaceAssertion, "Attribute") {
+			name := attr.SelectAttrValue("Name", "")
+			for _, value := range childElements(attr, NamespaceAssertion, "AttributeValue") {
+				attrs[name] = append(attrs[name], strings.TrimSpace(value.Text()))
+			}
+		}
+	}
+	return attrs
+}
+
+func statusCode(response *etree.Element) string {
+	status := childElement(response, NamespaceProtocol, "Status")
+	if status == nil {
+		return ""
+	}
+	code := childElement(status, NamespaceProtocol, "StatusCode")
+	if code == nil {
+		return ""
+	}
+	return code.SelectAttrValue("Value", "")
+}
+
+func parseInstant(raw string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(raw))
+}
+
+func isElement(el *etree.Element, namespace, tag string) bool {
+	return el != nil && el.Tag == tag && el.NamespaceURI() == namespace
+}
+
+func childElements(parent *etree.Element, namespace, tag string) []*etree.Element {
+	var out []*etree.Element
+	for _, child := range parent.ChildElements() {
+		if isElement(child, namespace, tag) {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+func childElement(parent *etree.Element, namespace, tag string) *etree.Element {
+	if children := childElements(parent, namespace, tag); len(children) == 1 {
+		return children[0]
+	}
+	return nil
+}
+
+func childText(parent *etree.Element, namespace, tag string) string {
+	if el := childElement(parent, namespace, tag); el != nil {
+		return strings.TrimSpace(el.Text())
+	}
+	return ""
+}
@@ -0,0 +1,307 @@
+package saml_test
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+
+	"github.com/rjnemo/auth/internal/driver/saml"
+	"github.com/rjnemo/auth/internal/driver/saml/samltest"
+)
+
+const (
+	spEntityID = "https://auth.example.test/saml/acme/metadata"
+	acsURL     = "https://auth.example.test/saml/acme/acs"
+)
+
+func newServiceProvider(t *testing.T, idp *samltest.IdP, allowIDPInitiated bool) (*saml.ServiceProvider, *x509.Certificate) {
+	t.Helper()
+	cert, key := samltest.KeyPair(t)
+	sp, err := saml.New(saml.Config{
+		EntityID:          spEntityID,
+		ACSURL:            acsURL,
+		Certificate:       cert,
+		Key:               key,
+		IDP:               idp.IDPMetadata(),
+		AllowIDPInitiated: allowIDPInitiated,
+	})
+	if err != nil {
+		t.Fatalf("new service provider: %v", err)
+	}
+	return sp, cert
+}
+
+func TestServiceProviderInitiatedLogin(t *testing.T) {
+	t.Parallel()
+
+	idp := samltest.New(t, spEntityID, acsURL)
+	sp, spCert := newServiceProvider(t, idp, false)
+
+	if _, err := sp.AuthnRequestURL(t.Context(), "1-starts-with-digit", ""); err == nil {
+		t.Fatal("expected a request id that is not an xs:ID to be rejected")
+	}
+	redirect, err := sp.AuthnRequestURL(t.Context(), "_req-0", "relay-1")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	if !strings.HasPrefix(redirect, samltest.SSOURL+"?") {
+		t.Fatalf("expected redirect to idp sso url, got %s", redirect)
+	}
+	req := samltest.ReadAuthnRequest(t, redirect, spCert)
+	if req.ID != "_req-0" || req.Issuer != spEntityID || req.ACSURL != acsURL || req.RelayState != "relay-1" {
+		t.Fatalf("unexpected authn request %+v", req)
+	}
+
+	for name, signResponse := range map[string]bool{"signed assertion": false, "signed response": true} {
+		t.Run(name, func(t *testing.T) {
+			redirect, err := sp.AuthnRequestURL(t.Context(), "_req-"+strings.ReplaceAll(name, " ", "-"), "")
+			if err != nil {
+				t.Fatalf("authn request url: %v", err)
+			}
+			req := samltest.ReadAuthnRequest(t, redirect, spCert)
+
+			response := idp.Response(t, samltest.Assertion{
+				InResponseTo: req.ID,
+				NameID:       "00u1abcd",
+				Attributes:   map[string][]string{"email": {"jane@acme.test"}, "displayName": {"Jane Doe"}},
+				SignResponse: signResponse,
+			})
+			assertion, err := sp.ParseResponse(t.Context(), response)
+			if err != nil {
+				t.Fatalf("parse response: %v", err)
+			}
+			if assertion.NameID != "00u1abcd" || assertion.Issuer != samltest.EntityID || assertion.InResponseTo != req.ID {
+				t.Fatalf("unexpected assertion %+v", assertion)
+			}
+			if assertion.Attribute("email") != "jane@acme.test" || assertion.Attribute("displayName") != "Jane Doe" {
+				t.Fatalf("unexpected attributes %v", assertion.Attributes)
+			}
+
+			if _, err := sp.ParseResponse(t.Context(), response); !errors.Is(err, saml.ErrReplayed) {
+				t.Fatalf("expected replay to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestIdentityProviderInitiatedLogin(t *testing.T) {
+	t.Parallel()
+
+	idp := samltest.New(t, spEntityID, acsURL)
+
+	strict, _ := newServiceProvider(t, idp, false)
+	if _, err := strict.ParseResponse(t.Context(), idp.Response(t, samltest.Assertion{})); !errors.Is(err, saml.ErrUnsolicited) {
+		t.Fatalf("expected ErrUnsolicited, got %v", err)
+	}
+
+	lenient, _ := newServiceProvider(t, idp, true)
+	assertion, err := lenient.ParseResponse(t.Context(), idp.Response(t, samltest.Assertion{NameID: "idp-initiated"}))
+	if err != nil {
+		t.Fatalf("parse response: %v", err)
+	}
+	if assertion.NameID != "idp-initiated" {
+		t.Fatalf("unexpected NameID %q", assertion.NameID)
+	}
+	if _, err := lenient.ParseResponse(t.Context(), idp.Response(t, samltest.Assertion{InResponseTo: "id-never-sent"})); !errors.Is(err, saml.ErrUnknownRequest) {
+		t.Fatalf("expected ErrUnknownRequest, got %v", err)
+	}
+}
+
+func TestServiceProvidersShareStore(t *testing.T) {
+	t.Parallel()
+
+	idp := samltest.New(t, spEntityID, acsURL)
+	store := saml.NewMemoryStore()
+	first, spCert := newServiceProvider(t, idp, false)
+	first.UseStore(store)
+	second, _ := newServiceProvider(t, idp, false)
+	second.UseStore(store)
+
+	redirect, err := first.AuthnRequestURL(t.Context(), "_req-shared", "")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	req := samltest.ReadAuthnRequest(t, redirect, spCert)
+	response := idp.Response(t, samltest.Assertion{InResponseTo: req.ID, NameID: "00u1abcd"})
+
+	if _, err := second.ParseResponse(t.Context(), response); err != nil {
+		t.Fatalf("expected another instance to accept the response, got %v", err)
+	}
+	if _, err := first.ParseResponse(t.Context(), response); !errors.Is(err, saml.ErrReplayed) {
+		t.Fatalf("expected the replay to be rejected by the first instance, got %v", err)
+	}
+
+	alone, _ := newServiceProvider(t, idp, false)
+	redirect, err = first.AuthnRequestURL(t.Context(), "_req-unshared", "")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	req = samltest.ReadAuthnRequest(t, redirect, spCert)
+	if _, err := alone.ParseResponse(t.Context(), idp.Response(t, samltest.Assertion{InResponseTo: req.ID, NameID: "00u1abcd"})); !errors.Is(err, saml.ErrUnknownRequest) {
+		t.Fatalf("expected an instance with its own store not to know the request, got %v", err)
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	t.Parallel()
+
+	idp := samltest.New(t, spEntityID, acsURL)
+	sp, _ := newServiceProvider(t, idp, true)
+
+	// evil is a correctly shaped assertion the attacker controls but cannot sign.
+	evil := func(t *testing.T) *etree.Element {
+		doc := idp.Document(t, samltest.Assertion{NameID: "admin", SkipAssertionSignature: true})
+		return doc.Root().SelectElement("Assertion").Copy()
+	}
+
+	tests := map[string]func(t *testing.T) string{
+		"wrong audience": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{Audience: "https://other.example.test"})
+		},
+		"wrong recipient": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{Recipient: "https://other.example.test/acs"})
+		},
+		"wrong destination": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{Destination: "https://other.example.test/acs"})
+		},
+		"wrong issuer": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{Issuer: "https://evil.example.test"})
+		},
+		"expired": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{NotBefore: time.Now().Add(-time.Hour), NotOnOrAfter: time.Now().Add(-10 * time.Minute)})
+		},
+		"not yet valid": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{NotBefore: time.Now().Add(10 * time.Minute), NotOnOrAfter: time.Now().Add(time.Hour)})
+		},
+		"transient name id": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{NameIDFormat: saml.NameIDFormatTransient})
+		},
+		"unsigned": func(t *testing.T) string {
+			return idp.Response(t, samltest.Assertion{SkipAssertionSignature: true})
+		},
+		"tampered name id": func(t *testing.T) string {
+			doc := idp.Document(t, samltest.Assertion{NameID: "user"})
+			doc.Root().FindElement("./Assertion/Subject/NameID").SetText("admin")
+			return samltest.Encode(t, doc)
+		},
+		"tampered assertion in signed response": func(t *testing.T) string {
+			doc := idp.Document(t, samltest.Assertion{NameID: "user", SignResponse: true, SkipAssertionSignature: true})
+			doc.Root().FindElement("./Assertion/Subject/NameID").SetText("admin")
+			return samltest.Encode(t, doc)
+		},
+		"evil assertion before signed one": func(t *testing.T) string {
+			doc := idp.Document(t, samltest.Assertion{NameID: "user"})
+			root := doc.Root()
+			root.InsertChildAt(root.SelectElement("Assertion").Index(), evil(t))
+			return samltest.Encode(t, doc)
+		},
+		"signed assertion wrapped in extensions": func(t *testing.T) string {
+			doc := idp.Document(t, samltest.Assertion{NameID: "user"})
+			root := doc.Root()
+			signed := root.SelectElement("Assertion")
+			root.RemoveChild(signed)
+			root.CreateElement("samlp:Extensions").AddChild(signed)
+			root.AddChild(evil(t))
+			return samltest.Encode(t, doc)
+		},
+		"evil assertion reusing signed id": func(t *testing.T) string {
+			doc := idp.Document(t, samltest.Assertion{NameID: "user"})
+			root := doc.Root()
+			signed := root.SelectElement("Assertion")
+			forged := evil(t)
+			forged.CreateAttr("ID", signed.SelectAttrValue("ID", ""))
+			forged.InsertChildAt(forged.SelectElement("Issuer").Index()+1, signed.SelectElement("Signature").Copy())
+			root.RemoveChild(signed)
+			root.AddChild(forged)
+			return samltest.Encode(t, doc)
+		},
+		"signed response with evil assertion swapped in": func(t *testing.T) string {
+			doc := idp.Document(t, samltest.Assertion{NameID: "user", SignResponse: true})
+			root := doc.Root()
+			root.RemoveChild(root.SelectElement("Assertion"))
+			root.AddChild(evil(t))
+			return samltest.Encode(t, doc)
+		},
+		"doctype": func(t *testing.T) string {
+			raw, err := idp.Document(t, samltest.Assertion{}).WriteToString()
+			if err != nil {
+				t.Fatalf("write document: %v", err)
+			}
+			return base64.StdEncoding.EncodeToString([]byte(`<!DOCTYPE r [<!ENTITY x "y">]>` + raw))
+		},
+		"not base64": func(t *testing.T) string {
+			return "%%%"
+		},
+	}
+	for name, build := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assertion, err := sp.ParseResponse(t.Context(), build(t))
+			if err == nil {
+				t.Fatalf("expected rejection, got %+v", assertion)
+			}
+			if !errors.Is(err, saml.ErrInvalidResponse) {
+				t.Fatalf("expected ErrInvalidResponse, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseResponseUntrustedIdPKey(t *testing.T) {
+	t.Parallel()
+
+	idp := samltest.New(t, spEntityID, acsURL)
+	cert, key := samltest.KeyPair(t)
+	other, _ := samltest.KeyPair(t)
+	md := idp.IDPMetadata()
+	md.Certificates = []*x509.Certificate{other}
+	sp, err := saml.New(saml.Config{EntityID: spEntityID, ACSURL: acsURL, Certificate: cert, Key: key, IDP: md, AllowIDPInitiated: true})
+	if err != nil {
+		t.Fatalf("new service provider: %v", err)
+	}
+	if _, err := sp.ParseResponse(t.Context(), idp.Response(t, samltest.Assertion{})); !errors.Is(err, saml.ErrInvalidResponse) {
+		t.Fatalf("expected ErrInvalidResponse, got %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	idp := samltest.New(t, spEntityID, acsURL)
+	md, err := saml.ParseIDPMetadata(idp.Metadata())
+	if err != nil {
+		t.Fatalf("parse idp metadata: %v", err)
+	}
+	if md.EntityID != samltest.EntityID || md.SSOURL != samltest.SSOURL || len(md.Certificates) != 1 || !md.Certificates[0].Equal(idp.Certificate()) {
+		t.Fatalf("unexpected idp metadata %+v", md)
+	}
+	if _, err := saml.ParseIDPMetadata([]byte(`<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="x"/>`)); err == nil {
+		t.Fatal("expected metadata without IDPSSODescriptor to be rejected")
+	}
+
+	sp, spCert := newServiceProvider(t, idp, false)
+	raw, err := sp.Metadata()
+	if err != nil {
+		t.Fatalf("sp metadata: %v", err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		t.Fatalf("parse sp metadata: %v", err)
+	}
+	if got := doc.Root().SelectAttrValue("entityID", ""); got != spEntityID {
+		t.Fatalf("expected entityID %q, got %q", spEntityID, got)
+	}
+	acs := doc.FindElement("//AssertionConsumerService")
+	if acs == nil || acs.SelectAttrValue("Location", "") != acsURL || acs.SelectAttrValue("Binding", "") != saml.BindingHTTPPost {
+		t.Fatalf("unexpected acs in metadata:\n%s", raw)
+	}
+	certText := doc.FindElement("//KeyDescriptor/KeyInfo/X509Data/X509Certificate")
+	if certText == nil || certText.Text() != base64.StdEncoding.EncodeToString(spCert.Raw) {
+		t.Fatalf("expected sp certificate in metadata:\n%s", raw)
+	}
+}
@@ -0,0 +1,367 @@
+// Package samltest provides an identity provider that signs SAML responses
+// with a key committed to the repository, for tests.
+package samltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	"github.com/rjnemo/auth/internal/driver/saml"
+)
+
+const (
+	// EntityID identifies the test identity provider.
+	EntityID = "https://idp.example.test/metadata"
+	// SSOURL is the test identity provider's HTTP-Redirect endpoint.
+	SSOURL = "https://idp.example.test/sso"
+)
+
+var (
+	//go:embed testdata/idp.crt
+	idpCertPEM []byte
+	//go:embed testdata/idp.key
+	idpKeyPEM []byte
+)
+
+// Assertion describes the response the IdP issues. Zero values are filled
+// with a valid response for the service provider the IdP was created for.
+type Assertion struct {
+	ID           string
+	InResponseTo string
+	NameID       string
+	NameIDFormat string
+	Attributes   map[string][]string
+	Issuer       string
+	Audience     string
+	Recipient    string
+	Destination  string
+	NotBefore    time.Time
+	NotOnOrAfter time.Time
+	// SignResponse signs the Response envelope; SkipAssertionSignature leaves
+	// the assertion itself unsigned.
+	SignResponse           bool
+	SkipAssertionSignature bool
+}
+
+// IdP is a test identity provider issuing responses to a single service provider.
+type IdP struct {
+	SPEntityID string
+	ACSURL     string
+	cert       *x509.Certificate
+	signer     *dsig.SigningContext
+}
+
+// New returns an IdP issuing responses to spEntityID at acsURL.
+func New(t testing.TB, spEntityID, acsURL string) *IdP {
+	t.Helper()
+
+	cert, key, err := saml.ParseKeyPair(idpCertPEM, idpKeyPEM)
+	if err != nil {
+		t.Fatalf("load test idp key pair: %v", err)
+	}
+	return &IdP{SPEntityID: spEntityID, ACSURL: acsURL, cert: cert, signer: signingContext(t, cert, key)}
+}
+
+// Certificate returns the IdP signing certificate.
+func (i *IdP) Certificate() *x509.Certificate {
+	return i.cert
+}
+
+// IDPMetadata returns the parsed metadata the service provider trusts.
+func (i *IdP) IDPMetadata() saml.IDPMetadata {
+	return saml.IDPMetadata{EntityID: EntityID, SSOURL: SSOURL, Certificates: []*x509.Certificate{i.cert}}
+}
+
+// Metadata returns the IdP EntityDescriptor XML.
+func (i *IdP) Metadata() []byte {
+	doc := etree.NewDocument()
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", saml.NamespaceMetadata)
+	entity.CreateAttr("xmlns:ds", dsig.Namespace)
+	entity.CreateAttr("entityID", EntityID)
+	descriptor := entity.CreateElement("md:IDPSSODescriptor")
+	descriptor.CreateAttr("protocolSupportEnumeration", saml.NamespaceProtocol)
+	kd := descriptor.CreateElement("md:KeyDescriptor")
+	kd.CreateAttr("use", "signing")
+	kd.CreateElement("ds:KeyInfo").
+		CreateElement("ds:X509Data").
+		CreateElement("ds:X509Certificate").
+		SetText(base64.StdEncoding.EncodeToString(i.cert.Raw))
+	for _, binding := range []string{saml.BindingHTTPPost, saml.BindingHTTPRedirect} {
+		sso := descriptor.CreateElement("md:SingleSignOnService")
+		sso.CreateAttr("Binding", binding)
+		sso.CreateAttr("Location", SSOURL)
+	}
+	out, err := doc.WriteToBytes()
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// Document builds the signed Response document for a, so tests can tamper
+// with it before encoding.
+func (i *IdP) Document(t testing.TB, a Assertion) *etree.Document {
+	t.Helper()
+
+	now := time.Now().UTC()
+	if a.ID == "" {
+		a.ID = randomID()
+	}
+	if a.NameID == "" {
+		a.NameID = "user-1"
+	}
+	if a.NameIDFormat == "" {
+		a.NameIDFormat = saml.NameIDFormatPersistent
+	}
+	if a.Issuer == "" {
+		a.Issuer = EntityID
+	}
+	if a.Audience == "" {
+		a.Audience = i.SPEntityID
+	}
+	if a.Recipient == "" {
+		a.Recipient = i.ACSURL
+	}
+	if a.Destination == "" {
+		a.Destination = i.ACSURL
+	}
+	if a.NotBefore.IsZero() {
+		a.NotBefore = now.Add(-time.Minute)
+	}
+	if a.NotOnOrAfter.IsZero() {
+		a.NotOnOrAfter = now.Add(5 * time.Minute)
+	}
+
+	doc := etree.NewDocument()
+	response := doc.CreateElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", saml.NamespaceProtocol)
+	response.CreateAttr("xmlns:saml", saml.NamespaceAssertion)
+	response.CreateAttr("ID", randomID())
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	response.CreateAttr("Destination", a.Destination)
+	if a.InResponseTo != "" {
+		response.CreateAttr("InResponseTo", a.InResponseTo)
+	}
+	response.CreateElement("saml:Issuer").SetText(a.Issuer)
+	response.CreateElement("samlp:Status").
+		CreateElement("samlp:StatusCode").
+		CreateAttr("Value", "urn:oasis:names:tc:SAML:2.0:status:Success")
+
+	assertion := buildAssertion(a, now)
+	if !a.SkipAssertionSignature {
+		i.signInPlace(t, assertion)
+	}
+	response.AddChild(assertion)
+
+	if a.SignResponse {
+		i.signInPlace(t, response)
+	}
+	return doc
+}
+
+// Response returns the base64 SAMLResponse form value for a.
+func (i *IdP) Response(t testing.TB, a Assertion) string {
+	t.Helper()
+	return Encode(t, i.Document(t, a))
+}
+
+// Encode serialises doc as a base64 SAMLResponse form value.
+func Encode(t testing.TB, doc *etree.Document) string {
+	t.Helper()
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("encode response: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// AuthnRequest is the decoded content of an HTTP-Redirect AuthnRequest.
+type AuthnRequest struct {
+	ID         string
+	Issuer     string
+	ACSURL     string
+	RelayState string
+}
+
+// ReadAuthnRequest decodes the AuthnRequest in redirectURL after verifying
+// its query signature against spCert.
+func ReadAuthnRequest(t testing.TB, redirectURL string, spCert *x509.Certificate) AuthnRequest {
+	t.Helper()
+
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	signed, signature, ok := splitSignature(u.RawQuery)
+	if !ok {
+		t.Fatalf("redirect is not signed: %s", redirectURL)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	if err := spCert.CheckSignature(x509.SHA256WithRSA, []byte(signed), sig); err != nil {
+		t.Fatalf("verify authn request signature: %v", err)
+	}
+
+	q := u.Query()
+	deflated, err := base64.StdEncoding.DecodeString(q.Get("SAMLRequest"))
+	if err != nil {
+		t.Fatalf("decode SAMLRequest: %v", err)
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("inflate SAMLRequest: %v", err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		t.Fatalf("parse AuthnRequest: %v", err)
+	}
+	root := doc.Root()
+	return AuthnRequest{
+		ID:         root.SelectAttrValue("ID", ""),
+		Issuer:     root.FindElement("./Issuer").Text(),
+		ACSURL:     root.SelectAttrValue("AssertionConsumerServiceURL", ""),
+		RelayState: q.Get("RelayState"),
+	}
+}
+
+// KeyPairPEM generates a throwaway service provider certificate and key.
+func KeyPairPEM(t testing.TB) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate sp key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "samltest SP"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create sp certificate: %v", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM
+}
+
+// KeyPair is KeyPairPEM decoded.
+func KeyPair(t testing.TB) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	cert, key, err := saml.ParseKeyPair(KeyPairPEM(t))
+	if err != nil {
+		t.Fatalf("parse sp key pair: %v", err)
+	}
+	return cert, key
+}
+
+func buildAssertion(a Assertion, now time.Time) *etree.Element {
+	assertion := etree.NewElement("saml:Assertion")
+	assertion.CreateAttr("xmlns:saml", saml.NamespaceAssertion)
+	assertion.CreateAttr("ID", a.ID)
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	assertion.CreateElement("saml:Issuer").SetText(a.Issuer)
+
+	subject := assertion.CreateElement("saml:Subject")
+	nameID := subject.CreateElement("saml:NameID")
+	nameID.CreateAttr("Format", a.NameIDFormat)
+	nameID.SetText(a.NameID)
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer")
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	if a.InResponseTo != "" {
+		data.CreateAttr("InResponseTo", a.InResponseTo)
+	}
+	data.CreateAttr("NotOnOrAfter", a.NotOnOrAfter.Format(time.RFC3339))
+	data.CreateAttr("Recipient", a.Recipient)
+
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", a.NotBefore.Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", a.NotOnOrAfter.Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").
+		CreateElement("saml:Audience").
+		SetText(a.Audience)
+
+	authn := assertion.CreateElement("saml:AuthnStatement")
+	authn.CreateAttr("AuthnInstant", now.Format(time.RFC3339))
+	authn.CreateAttr("SessionIndex", "session-"+a.ID)
+
+	if len(a.Attributes) > 0 {
+		statement := assertion.CreateElement("saml:AttributeStatement")
+		for name, values := range a.Attributes {
+			attr := statement.CreateElement("saml:Attribute")
+			attr.CreateAttr("Name", name)
+			for _, v := range values {
+				attr.CreateElement("saml:AttributeValue").SetText(v)
+			}
+		}
+	}
+	return assertion
+}
+
+// signInPlace inserts an enveloped signature after el's Issuer, where the
+// SAML schema requires it.
+func (i *IdP) signInPlace(t testing.TB, el *etree.Element) {
+	t.Helper()
+	// Canonicalisation rewrites its input, so sign a copy.
+	sig, err := i.signer.ConstructSignature(el.Copy(), true)
+	if err != nil {
+		t.Fatalf("sign %s: %v", el.Tag, err)
+	}
+	el.InsertChildAt(el.SelectElement("Issuer").Index()+1, sig)
+}
+
+func signingContext(t testing.TB, cert *x509.Certificate, key *rsa.PrivateKey) *dsig.SigningContext {
+	t.Helper()
+	ctx, err := dsig.NewSigningContext(key, [][]byte{cert.Raw})
+	if err != nil {
+		t.Fatalf("signing context: %v", err)
+	}
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return ctx
+}
+
+// splitSignature separates the signed portion of a redirect query from its
+// Signature parameter, preserving the original encoding.
+func splitSignature(rawQuery string) (signed, signature string, ok bool) {
+	const param = "&Signature="
+	idx := bytes.LastIndex([]byte(rawQuery), []byte(param))
+	if idx < 0 {
+		return "", "", false
+	}
+	sig, err := url.QueryUnescape(rawQuery[idx+len(param):])
+	if err != nil {
+		return "", "", false
+	}
+	return rawQuery[:idx], sig, true
+}
+
+func randomID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return "_" + hex.EncodeToString(buf)
+}
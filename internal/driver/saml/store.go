@@ -0,0 +1,108 @@
+package saml
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyRequests indicates the store already holds as many pending
+// requests for the service provider as it allows.
+var ErrTooManyRequests = errors.New("saml: too many pending requests")
+
+// Store remembers pending AuthnRequest IDs and consumed assertion IDs. The IdP
+// posts cross-site, where the session cookie is not sent, so they cannot live
+// in the session; every instance serving the ACS must share one Store, or a
+// response may reach an instance that never saw its request or miss a replay.
+// Entries are namespaced by the service provider's entity ID.
+type Store interface {
+	// AddRequest records a pending request until expiresAt. It returns
+	// ErrTooManyRequests when the service provider has too many pending.
+	AddRequest(ctx context.Context, entityID, id string, expiresAt, now time.Time) error
+	// TakeRequest forgets a pending request, reporting whether it was still
+	// pending at now.
+	TakeRequest(ctx context.Context, entityID, id string, now time.Time) (bool, error)
+	// UseAssertion records an assertion as consumed until expiresAt,
+	// reporting false when it had already been consumed.
+	UseAssertion(ctx context.Context, entityID, id string, expiresAt, now time.Time) (bool, error)
+}
+
+// MemoryStore keeps pending requests and consumed assertions in memory, which
+// only protects a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	pending map[entry]time.Time
+	used    map[entry]time.Time
+}
+
+type entry struct {
+	entityID string
+	id       string
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pending: make(map[entry]time.Time),
+		used:    make(map[entry]time.Time),
+	}
+}
+
+// AddRequest records a pending request.
+func (s *MemoryStore) AddRequest(_ context.Context, entityID, id string, expiresAt, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	pending := 0
+	for key := range s.pending {
+		if key.entityID == entityID {
+			pending++
+		}
+	}
+	if pending >= maxPendingRequests {
+		return ErrTooManyRequests
+	}
+	s.pending[entry{entityID, id}] = expiresAt
+	return nil
+}
+
+// TakeRequest forgets a pending request.
+func (s *MemoryStore) TakeRequest(_ context.Context, entityID, id string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	key := entry{entityID, id}
+	if _, ok := s.pending[key]; !ok {
+		return false, nil
+	}
+	delete(s.pending, key)
+	return true, nil
+}
+
+// UseAssertion records a consumed assertion.
+func (s *MemoryStore) UseAssertion(_ context.Context, entityID, id string, expiresAt, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	key := entry{entityID, id}
+	if _, seen := s.used[key]; seen {
+		return false, nil
+	}
+	s.used[key] = expiresAt
+	return true, nil
+}
+
+// prune drops expired pending requests and used assertion IDs. Callers must hold s.mu.
+func (s *MemoryStore) prune(now time.Time) {
+	for key, expires := range s.pending {
+		if !now.Before(expires) {
+			delete(s.pending, key)
+		}
+	}
+	for key, expires := range s.used {
+		if !now.Before(expires) {
+			delete(s.used, key)
+		}
+	}
+}
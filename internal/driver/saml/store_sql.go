@@ -0,0 +1,74 @@
+package saml
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rjnemo/auth/internal/driver/db"
+)
+
+// SQLStore keeps pending requests and consumed assertions in PostgreSQL, so
+// every instance sharing the database sees them.
+type SQLStore struct {
+	queries *db.Queries
+}
+
+// NewSQLStore builds a SQL-backed store.
+func NewSQLStore(pool *pgxpool.Pool) *SQLStore {
+	return &SQLStore{queries: db.New(pool)}
+}
+
+// AddRequest records a pending request, purging the service provider's
+// expired ones.
+func (s *SQLStore) AddRequest(ctx context.Context, entityID, id string, expiresAt, now time.Time) error {
+	rows, err := s.queries.CreateSAMLRequest(ctx, db.CreateSAMLRequestParams{
+		EntityID:   entityID,
+		Now:        timestamptz(now),
+		RequestID:  id,
+		ExpiresAt:  timestamptz(expiresAt),
+		MaxPending: maxPendingRequests,
+	})
+	if err != nil {
+		return fmt.Errorf("record saml request: %w", err)
+	}
+	if rows == 0 {
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+// TakeRequest deletes a pending request.
+func (s *SQLStore) TakeRequest(ctx context.Context, entityID, id string, now time.Time) (bool, error) {
+	rows, err := s.queries.TakeSAMLRequest(ctx, db.TakeSAMLRequestParams{
+		EntityID:  entityID,
+		RequestID: id,
+		Now:       timestamptz(now),
+	})
+	if err != nil {
+		return false, fmt.Errorf("take saml request: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// UseAssertion records a consumed assertion, purging the service provider's
+// expired ones.
+func (s *SQLStore) UseAssertion(ctx context.Context, entityID, id string, expiresAt, now time.Time) (bool, error) {
+	rows, err := s.queries.UseSAMLAssertion(ctx, db.UseSAMLAssertionParams{
+		EntityID:    entityID,
+		Now:         timestamptz(now),
+		AssertionID: id,
+		ExpiresAt:   timestamptz(expiresAt),
+	})
+	if err != nil {
+		return false, fmt.Errorf("record saml assertion: %w", err)
+	}
+	return rows > 0, nil
+}
+
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
@@ -0,0 +1,94 @@
+package saml
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	schemaUpSQL = `
+CREATE TABLE saml_requests (
+    entity_id TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (entity_id, request_id)
+);
+
+CREATE TABLE saml_assertions (
+    entity_id TEXT NOT NULL,
+    assertion_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (entity_id, assertion_id)
+);
+`
+
+	schemaDownSQL = `
+DROP TABLE IF EXISTS saml_assertions;
+DROP TABLE IF EXISTS saml_requests;
+`
+)
+
+func TestSQLStoreIntegration(t *testing.T) {
+	dsn := os.Getenv("AUTH_DATABASE_URL")
+	if strings.TrimSpace(dsn) == "" {
+		t.Skip("AUTH_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	for _, stmt := range []string{schemaDownSQL, schemaUpSQL} {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			t.Fatalf("exec statement %q: %v", stmt, err)
+		}
+	}
+
+	store := NewSQLStore(pool)
+	now := time.Now()
+
+	if err := store.AddRequest(ctx, "sp-1", "_req-1", now.Add(requestLifetime), now); err != nil {
+		t.Fatalf("add request: %v", err)
+	}
+	if pending, err := store.TakeRequest(ctx, "sp-2", "_req-1", now); err != nil || pending {
+		t.Fatalf("expected requests to be scoped to their service provider, got %v %v", pending, err)
+	}
+	if pending, err := store.TakeRequest(ctx, "sp-1", "_req-1", now); err != nil || !pending {
+		t.Fatalf("expected the request to be pending, got %v %v", pending, err)
+	}
+	if pending, err := store.TakeRequest(ctx, "sp-1", "_req-1", now); err != nil || pending {
+		t.Fatalf("expected the request to be taken once, got %v %v", pending, err)
+	}
+	if err := store.AddRequest(ctx, "sp-1", "_req-2", now.Add(time.Minute), now); err != nil {
+		t.Fatalf("add request: %v", err)
+	}
+	if pending, err := store.TakeRequest(ctx, "sp-1", "_req-2", now.Add(2*time.Minute)); err != nil || pending {
+		t.Fatalf("expected an expired request not to be pending, got %v %v", pending, err)
+	}
+
+	if fresh, err := store.UseAssertion(ctx, "sp-1", "_a-1", now.Add(time.Minute), now); err != nil || !fresh {
+		t.Fatalf("expected the first use to succeed, got %v %v", fresh, err)
+	}
+	if fresh, err := store.UseAssertion(ctx, "sp-1", "_a-1", now.Add(time.Minute), now); err != nil || fresh {
+		t.Fatalf("expected a replay to be refused, got %v %v", fresh, err)
+	}
+	if fresh, err := store.UseAssertion(ctx, "sp-1", "_a-1", now.Add(3*time.Minute), now.Add(2*time.Minute)); err != nil || !fresh {
+		t.Fatalf("expected an expired record not to block the id, got %v %v", fresh, err)
+	}
+
+	if _, err := pool.Exec(ctx, "INSERT INTO saml_requests (entity_id, request_id, expires_at) SELECT 'sp-3', 'r' || i, $1 FROM generate_series(1, $2::int) AS i", now.Add(time.Minute), maxPendingRequests); err != nil {
+		t.Fatalf("fill pending requests: %v", err)
+	}
+	if err := store.AddRequest(ctx, "sp-3", "_req-overflow", now.Add(time.Minute), now); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/rjnemo/auth/internal/service/oauth"
+)
+
+const (
+	// adminClientsPath is the root of the client management API.
+	adminClientsPath = "/admin/api/clients"
+
+	adminBodyMaxBytes = 256 << 10
+)
+
+// clientMetadata is the client representation accepted by the management
+// API, named after the dynamic registration fields (RFC 7591 §2).
+type clientMetadata struct {
+	Name         string              `json:"client_name"`
+	Type         oauth.ClientType    `json:"client_type,omitempty"`
+	AuthMethod   string              `json:"token_endpoint_auth_method,omitempty"`
+	RedirectURIs []string            `json:"redirect_uris,omitempty"`
+	GrantTypes   []string            `json:"grant_types,omitempty"`
+	Scope        string              `json:"scope,omitempty"`
+	JWKS         *jose.JSONWebKeySet `json:"jwks,omitempty"`
+	Audiences    []string            `json:"audiences,omitempty"`
+}
+
+// clientResource is a registered client as returned by the management API.
+// Secrets are described but never returned after they are issued.
+type clientResource struct {
+	ID string `json:"client_id"`
+	clientMetadata
+	Secrets      []clientSecretResource `json:"client_secrets,omitempty"`
+	ClientSecret string                 `json:"client_secret,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+type clientSecretResource struct {
+	ID        string     `json:"id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func newClientResource(client oauth.Client) clientResource {
+	resource := clientResource{
+		ID: client.ID,
+		clientMetadata: clientMetadata{
+			Name:         client.Name,
+			Type:         client.Type,
+			AuthMethod:   client.AuthMethod,
+			RedirectURIs: client.RedirectURIs,
+			GrantTypes:   client.GrantTypes,
+			Scope:        strings.Join(client.Scopes, " "),
+			JWKS:         client.JWKS,
+			Audiences:    client.Audiences,
+		},
+		CreatedAt: client.CreatedAt,
+		UpdatedAt: client.UpdatedAt,
+	}
+	for _, secret := range client.Secrets {
+		entry := clientSecretResource{ID: secret.ID, CreatedAt: secret.CreatedAt}
+		if !secret.ExpiresAt.IsZero() {
+			entry.ExpiresAt = &secret.ExpiresAt
+		}
+		resource.Secrets = append(resource.Secrets, entry)
+	}
+	return resource
+}
+
+func (m clientMetadata) registration() oauth.ClientRegistration {
+	return oauth.ClientRegistration{
+		Name:         m.Name,
+		Type:         m.Type,
+		AuthMethod:   m.AuthMethod,
+		RedirectURIs: m.RedirectURIs,
+		GrantTypes:   m.GrantTypes,
+		Scopes:       strings.Fields(m.Scope),
+		JWKS:         m.JWKS,
+		Audiences:    m.Audiences,
+	}
+}
+
+// requireAdminToken admits requests bearing the configured admin token.
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(s.configuration.AuthorizationServer.AdminToken))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		got := sha256.Sum256([]byte(token))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAdminError(w, http.StatusUnauthorized, "invalid_token", "a valid admin token is required")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listClientsHandler returns every registered client.
+func (s *Server) listClientsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients, err := s.authorizationServer.Clients().List(r.Context())
+		if err != nil {
+			s.adminFailure(w, "list clients failed", err)
+			return
+		}
+		resources := make([]clientResource, 0, len(clients))
+		for _, client := range clients {
+			resources = append(resources, newClientResource(client))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"clients": resources})
+	}
+}
+
+// createClientHandler registers a client and returns its secret, which is
+// never shown again.
+func (s *Server) createClientHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metadata clientMetadata
+		if !decodeAdminBody(w, r, &metadata) {
+			return
+		}
+		client, secret, err := s.authorizationServer.Clients().Register(r.Context(), metadata.registration())
+		if err != nil {
+			s.adminFailure(w, "register client failed", err)
+			return
+		}
+		s.adminLogger().Info("oauth client registered", slog.String("client_id", client.ID), slog.String("client_type", string(client.Type)))
+		resource := newClientResource(client)
+		resource.ClientSecret = secret
+		w.Header().Set("Location", adminClientsPath+"/"+client.ID)
+		writeJSON(w, http.StatusCreated, resource)
+	}
+}
+
+// getClientHandler returns one client with its secrets' metadata.
+func (s *Server) getClientHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := s.authorizationServer.Clients().Find(r.Context(), chi.URLParam(r, "clientID"))
+		if err != nil {
+			s.adminFailure(w, "lookup client failed", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newClientResource(client))
+	}
+}
+
+// updateClientHandler replaces a client's metadata. Omitted fields take their
+// defaults, as on registration.
+func (s *Server) updateClientHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metadata clientMetadata
+		if !decodeAdminBody(w, r, &metadata) {
+			return
+		}
+		client, err := s.authorizationServer.Clients().Update(r.Context(), chi.URLParam(r, "clientID"), metadata.registration())
+		if err != nil {
+			s.adminFailure(w, "update client failed", err)
+			return
+		}
+		s.adminLogger().Info("oauth client updated", slog.String("client_id", client.ID))
+		writeJSON(w, http.StatusOK, newClientResource(client))
+	}
+}
+
+// deleteClientHandler removes a client and revokes everything issued to it.
+func (s *Server) deleteClientHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "clientID")
+		if err := s.authorizationServer.Clients().Delete(r.Context(), id); err != nil {
+			s.adminFailure(w, "delete client failed", err)
+			return
+		}
+		s.adminLogger().Info("oauth client deleted", slog.String("client_id", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// rotateClientSecretHandler issues a new client secret. The body may set
+// overlap, a duration for which existing secrets stay valid.
+func (s *Server) rotateClientSecretHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Overlap string `json:"overlap"`
+		}
+		if r.ContentLength != 0 && !decodeAdminBody(w, r, &body) {
+			return
+		}
+		overlap := oauth.DefaultSecretOverlap
+		if body.Overlap != "" {
+			var err error
+			if overlap, err = time.ParseDuration(body.Overlap); err != nil {
+				writeAdminError(w, http.StatusBadRequest, "invalid_request", "overlap must be a duration such as 24h")
+				return
+			}
+		}
+
+		id := chi.URLParam(r, "clientID")
+		secret, err := s.authorizationServer.Clients().RotateSecret(r.Context(), id, overlap)
+		if err != nil {
+			s.adminFailure(w, "rotate client secret failed", err)
+			return
+		}
+		s.adminLogger().Info("oauth client secret rotated", slog.String("client_id", id), slog.Duration("overlap", overlap))
+		writeJSON(w, http.StatusCreated, map[string]string{"client_id": id, "client_secret": secret})
+	}
+}
+
+// tokenRevocationResource is a token revocation as returned by the
+// management API.
+type tokenRevocationResource struct {
+	ID           string    `json:"id"`
+	TokenType    string    `json:"token_type"`
+	UserID       string    `json:"user_id"`
+	AccessTokens int       `json:"access_tokens_revoked"`
+	RevokedAt    time.Time `json:"revoked_at"`
+}
+
+// listRevocationsHandler returns the audit log of the client's revoked
+// tokens, newest first. The limit query parameter caps how many are listed.
+// The log outlives the client, so unknown clients list none.
+func (s *Server) listRevocationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			var err error
+			if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+				writeAdminError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive integer")
+				return
+			}
+		}
+
+		id := chi.URLParam(r, "clientID")
+		revocations, err := s.authorizationServer.Clients().Revocations(r.Context(), id, limit)
+		if err != nil {
+			s.adminFailure(w, "list token revocations failed", err)
+			return
+		}
+		resources := make([]tokenRevocationResource, 0, len(revocations))
+		for _, revocation := range revocations {
+			resources = append(resources, tokenRevocationResource{
+				ID:           revocation.ID,
+				TokenType:    revocation.TokenType,
+				UserID:       revocation.UserID,
+				AccessTokens: revocation.AccessTokens,
+				RevokedAt:    revocation.RevokedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"client_id": id, "revocations": resources})
+	}
+}
+
+// clientTokenIssuanceResource is a client_credentials token issuance as
+// returned by the management API.
+type clientTokenIssuanceResource struct {
+	ID        string    `json:"jti"`
+	Audience  string    `json:"audience"`
+	Scope     string    `json:"scope,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IssuedAt  time.Time `json:"issued_at"`
+}
+
+// listIssuancesHandler returns the audit log of tokens the client_credentials
+// grant issued to the client, newest first. The limit query parameter caps
+// how many are listed. The log outlives the client, so unknown clients list
+// none.
+func (s *Server) listIssuancesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			var err error
+			if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+				writeAdminError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive integer")
+				return
+			}
+		}
+
+		id := chi.URLParam(r, "clientID")
+		issuances, err := s.authorizationServer.Clients().Issuances(r.Context(), id, limit)
+		if err != nil {
+			s.adminFailure(w, "list client token issuances failed", err)
+			return
+		}
+		resources := make([]clientTokenIssuanceResource, 0, len(issuances))
+		for _, issuance := range issuances {
+			resources = append(resources, clientTokenIssuanceResource{
+				ID:        issuance.ID,
+				Audience:  issuance.Audience,
+				Scope:     strings.Join(issuance.Scopes, " "),
+				ExpiresAt: issuance.ExpiresAt,
+				IssuedAt:  issuance.IssuedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"client_id": id, "issuances": resources})
+	}
+}
+
+func (s *Server) adminLogger() *slog.Logger {
+	return s.logger.With(slog.String("component", "admin"))
+}
+
+// adminFailure maps registry errors to API responses.
+func (s *Server) adminFailure(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, oauth.ErrClientNotFound):
+		writeAdminError(w, http.StatusNotFound, "not_found", "no client is registered under that id")
+	case errors.Is(err, oauth.ErrInvalidClientMetadata):
+		writeAdminError(w, http.StatusBadRequest, "invalid_client_metadata", err.Error())
+	case errors.Is(err, oauth.ErrClientHasNoSecret):
+		writeAdminError(w, http.StatusConflict, "invalid_request", err.Error())
+	default:
+		s.adminLogger().Error(msg, slog.Any("error", err))
+		writeAdminError(w, http.StatusInternalServerError, "server_error", "unexpected error")
+	}
+}
+
+// decodeAdminBody decodes a JSON request body into dst, rejecting unknown
+// fields so typos do not silently fall back to defaults.
+func decodeAdminBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminBodyMaxBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid_request", "request body must be a JSON object with known fields")
+		return false
+	}
+	return true
+}
+
+func writeAdminError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
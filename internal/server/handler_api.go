@@ -0,0 +1,331 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rjnemo/auth/api"
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	// apiPrefix is the root of the versioned JSON API.
+	apiPrefix = "/api/v1"
+	// openAPIPath serves the OpenAPI document describing the API.
+	openAPIPath = "/api/openapi.json"
+
+	apiBodyMaxBytes    = 64 << 10
+	problemContentType = "application/problem+json"
+	corsMaxAgeSeconds  = "600"
+)
+
+// Problem codes are part of the API contract: clients branch on them, so
+// existing codes must never change meaning.
+const (
+	problemInvalidRequest       = "invalid_request"
+	problemUnsupportedMediaType = "unsupported_media_type"
+	problemUnauthenticated      = "unauthenticated"
+	problemOriginNotAllowed     = "origin_not_allowed"
+	problemNotFound             = "not_found"
+	problemMethodNotAllowed     = "method_not_allowed"
+	problemInternal             = "internal_error"
+)
+
+// apiErrors maps auth service errors to API problems.
+var apiErrors = []struct {
+	err    error
+	status int
+	code   string
+	detail string
+}{
+	{auth.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials", invalidCredentialsMsg},
+	{auth.ErrWeakPassword, http.StatusBadRequest, "weak_password", weakPasswordMsg},
+	{auth.ErrEmailRequired, http.StatusBadRequest, "email_required", credentialRequiredMsg},
+	{auth.ErrInvalidInput, http.StatusBadRequest, "invalid_input", credentialRequiredMsg},
+	{auth.ErrEmailExists, http.StatusConflict, "email_exists", duplicateEmailMsg},
+	{auth.ErrDirectoryDomain, http.StatusConflict, "directory_domain", directoryDomainMsg},
+	{auth.ErrUserNotFound, http.StatusNotFound, "user_not_found", "No account matches the request."},
+	{auth.ErrEmailUnverified, http.StatusForbidden, "email_unverified", "The provider has not verified this email address."},
+	{auth.ErrUserDeactivated, http.StatusForbidden, "account_deactivated", accountDeactivatedMsg},
+	{auth.ErrLinkRequired, http.StatusConflict, "link_required", "Sign in to the existing account to link this identity."},
+	{auth.ErrIdentityLinked, http.StatusConflict, "identity_linked", "This identity already belongs to an account."},
+	{auth.ErrIdentityNotFound, http.StatusNotFound, "identity_not_found", "This identity is not linked to the account."},
+	{auth.ErrLastLoginMethod, http.StatusConflict, "last_login_method", "The last login method cannot be removed."},
+	{auth.ErrProviderRequired, http.StatusBadRequest, "provider_required", "A provider is required."},
+	{auth.ErrSubjectRequired, http.StatusBadRequest, "subject_required", "A provider subject is required."},
+	{auth.ErrPersonalTokenNotFound, http.StatusNotFound, "personal_token_not_found", personalTokenNotFoundMsg},
+}
+
+// problem is an RFC 7807 error body. Code is the stable identifier clients
+// match on; Title and Detail are meant for people.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// apiCredentials is the body of the login and signup endpoints.
+type apiCredentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// apiUser is an account as returned by the API.
+type apiUser struct {
+	ID          string        `json:"id"`
+	Email       string        `json:"email"`
+	DisplayName string        `json:"display_name,omitempty"`
+	AvatarURL   string        `json:"avatar_url,omitempty"`
+	Identities  []apiIdentity `json:"identities"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+type apiIdentity struct {
+	Provider string    `json:"provider"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at,omitzero"`
+}
+
+func newAPIUser(account *auth.User) apiUser {
+	user := apiUser{
+		ID:          account.ID,
+		Email:       account.Email.String(),
+		DisplayName: account.DisplayName,
+		AvatarURL:   account.AvatarURL,
+		Identities:  []apiIdentity{},
+		CreatedAt:   account.CreatedAt,
+	}
+	for _, identity := range account.Identities {
+		user.Identities = append(user.Identities, apiIdentity{
+			Provider: identity.Provider,
+			Email:    identity.Email,
+			LinkedAt: identity.LinkedAt,
+		})
+	}
+	return user
+}
+
+func (s *Server) registerAPIRoutes(r chi.Router) {
+	r.Use(s.apiCORS)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, problemMethodNotAllowed, "")
+	})
+	r.Post("/login", s.apiLoginHandler())
+	r.Post("/signup", s.apiSignupHandler())
+	r.Post("/logout", s.apiLogoutHandler())
+	r.Get("/me", s.apiMeHandler())
+}
+
+// openAPIHandler serves the API description that the Go client in
+// api/client is generated from.
+func (s *Server) openAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(api.OpenAPI)
+	}
+}
+
+// apiLoginHandler is loginHandler for JSON clients: it signs the session in
+// and returns the account instead of redirecting.
+func (s *Server) apiLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "api_login"))
+		state := sessionFromContext(r.Context())
+
+		var body apiCredentials
+		if !decodeAPIRequest(w, r, &body) {
+			return
+		}
+		email, err := auth.NewUserEmail(body.Email)
+		if err != nil {
+			s.writeServiceProblem(w, r, err, logger)
+			return
+		}
+
+		account, err := s.authService.Authenticate(r.Context(), email, body.Password)
+		if err != nil {
+			s.writeServiceProblem(w, r, err, logger)
+			return
+		}
+		s.completePendingLink(r, &state, account, logger)
+		if !s.signInAPI(w, r, state, account, logger) {
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIUser(account))
+	}
+}
+
+// apiSignupHandler is signupHandler for JSON clients.
+func (s *Server) apiSignupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "api_signup"))
+		state := sessionFromContext(r.Context())
+
+		var body apiCredentials
+		if !decodeAPIRequest(w, r, &body) {
+			return
+		}
+		email, err := auth.NewUserEmail(body.Email)
+		if err != nil {
+			s.writeServiceProblem(w, r, err, logger)
+			return
+		}
+
+		account, err := s.authService.Register(r.Context(), email, body.Password)
+		if err != nil {
+			s.writeServiceProblem(w, r, err, logger)
+			return
+		}
+		if !s.signInAPI(w, r, state, account, logger) {
+			return
+		}
+		writeJSON(w, http.StatusCreated, newAPIUser(account))
+	}
+}
+
+func (s *Server) apiLogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.sessions.Clear(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// apiMeHandler returns the account signed in by the session cookie or a
+// personal access token.
+func (s *Server) apiMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "api_me"))
+		state := sessionFromContext(r.Context())
+		if !state.Authenticated {
+			writeProblem(w, r, http.StatusUnauthorized, problemUnauthenticated, "Sign in to continue.")
+			return
+		}
+
+		account, err := s.forwardAuthAccount(r, state)
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			logger.Warn("session account not found")
+			writeProblem(w, r, http.StatusUnauthorized, problemUnauthenticated, "Sign in to continue.")
+		case err != nil:
+			s.writeServiceProblem(w, r, err, logger)
+		default:
+			writeJSON(w, http.StatusOK, newAPIUser(account))
+		}
+	}
+}
+
+// signInAPI replaces the session with a fresh one signed in as account,
+// answering with a problem when it cannot. The post-login destination is left
+// in place for the HTML flow that stored it.
+func (s *Server) signInAPI(w http.ResponseWriter, r *http.Request, state SessionState, account *auth.User, logger *slog.Logger) bool {
+	state, err := signedIn(state, account)
+	if err != nil {
+		s.writeServiceProblem(w, r, err, logger)
+		return false
+	}
+	if err := s.sessions.Save(w, state); err != nil {
+		logger.Warn("session save failed", slog.Any("error", err))
+	}
+	return true
+}
+
+// apiCORS answers preflight requests from the origins on
+// AUTH_CORS_ALLOWED_ORIGINS and lets them read responses with credentials.
+// The session cookie rides along on cross-origin requests, so state-changing
+// calls from any other foreign origin are refused; JSON bodies already force a
+// preflight, but logout has no body.
+func (s *Server) apiCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && slices.Contains(s.configuration.CORSAllowedOrigins, strings.ToLower(origin))
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				writeProblem(w, r, http.StatusForbidden, problemOriginNotAllowed, "")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAgeSeconds)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if origin != "" && !allowed && !s.trustedOrigin(r, origin) {
+				s.logger.With(slog.String("component", "api")).Warn("cross-origin request rejected",
+					slog.String("origin", origin),
+					slog.String("path", r.URL.Path),
+				)
+				writeProblem(w, r, http.StatusForbidden, problemOriginNotAllowed, "")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeAPIRequest reads a JSON request body into dst, answering with a
+// problem and returning false when it cannot.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, problemUnsupportedMediaType, "Send the request body as application/json.")
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiBodyMaxBytes)).Decode(dst); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, "The request body is not valid JSON.")
+		return false
+	}
+	return true
+}
+
+// writeServiceProblem answers with the problem mapped from an auth service
+// error, logging errors that have no mapping.
+func (s *Server) writeServiceProblem(w http.ResponseWriter, r *http.Request, err error, logger *slog.Logger) {
+	for _, mapped := range apiErrors {
+		if errors.Is(err, mapped.err) {
+			writeProblem(w, r, mapped.status, mapped.code, mapped.detail)
+			return
+		}
+	}
+	logger.Error("api request failed", slog.Any("error", err))
+	writeProblem(w, r, http.StatusInternalServerError, problemInternal, "")
+}
+
+// writeProblem writes an RFC 7807 body. The type is left as about:blank, so
+// the title is the status text (RFC 7807 §4.2) and code carries the specifics.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
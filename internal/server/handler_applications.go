@@ -0,0 +1,47 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rjnemo/auth/internal/service/oauth"
+)
+
+const (
+	// applicationsPath is the root of the actions on the clients a user has
+	// authorized, which the dashboard lists.
+	applicationsPath = "/account/applications"
+
+	applicationNotAuthorizedMsg = "That application does not have access to your account."
+	revokeApplicationFailedMsg  = "Unable to revoke that application's access. Please try again."
+)
+
+// revokeApplicationHandler withdraws the signed-in user's consent for a
+// client and revokes every token the client holds for them.
+func (s *Server) revokeApplicationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := chi.URLParam(r, "clientID")
+		logger := s.logger.With(slog.String("component", "applications"), slog.String("client_id", clientID))
+
+		state := sessionFromContext(r.Context())
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+
+		revocations, err := s.authorizationServer.RevokeConsent(r.Context(), account.ID, clientID)
+		switch {
+		case err == nil:
+			logger.Info("application access revoked", slog.String("user_id", account.ID), slog.Int("tokens", len(revocations)))
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		case errors.Is(err, oauth.ErrConsentNotFound):
+			s.renderDashboardError(w, r, state, account, http.StatusNotFound, applicationNotAuthorizedMsg)
+		default:
+			logger.Error("revoke application access failed", slog.Any("error", err))
+			s.renderDashboardError(w, r, state, account, http.StatusInternalServerError, revokeApplicationFailedMsg)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/rjnemo/auth/internal/service/auth"
@@ -15,29 +18,118 @@ func (s *Server) dashboardPageHandler() http.HandlerFunc {
 		logger := s.logger.With(slog.String("component", "dashboard"))
 		state := sessionFromContext(r.Context())
 
-		if !state.Authenticated {
-			w.WriteHeader(http.StatusUnauthorized)
-			s.render(w, "unauthorized.html", newUnauthorizedData("Sign in to continue.", state.CSRFToken))
+		account, ok := s.requireAccount(w, r, state, logger)
+		if !ok {
 			return
 		}
 
-		email, err := auth.NewUserEmail(state.Email)
-		if err != nil {
-			logger.Warn("invalid session email", slog.Any("error", err))
-			http.Error(w, "session invalid", http.StatusUnauthorized)
-			return
+		s.render(w, "dashboard.html", s.newDashboardPage(r.Context(), state, account, ""))
+	}
+}
+
+// requireAccount loads the signed-in account by the session's user ID, as
+// the email can change, writing an error response and returning false when
+// the session is anonymous or stale, or its account has been deleted or
+// deactivated since it signed in.
+func (s *Server) requireAccount(w http.ResponseWriter, r *http.Request, state SessionState, logger *slog.Logger) (*auth.User, bool) {
+	stale := state.Authenticated && state.UserID == ""
+	if stale {
+		logger.Warn("session without user id refused")
+		state = SessionState{CSRFToken: state.CSRFToken, ReturnTo: state.ReturnTo}
+	}
+	if !state.Authenticated {
+		if r.Method == http.MethodGet || stale {
+			state.ReturnTo = s.safeReturnTo(r.URL.RequestURI())
+			if err := s.sessions.Save(w, state); err != nil {
+				logger.Warn("session save failed", slog.Any("error", err))
+			}
 		}
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, "unauthorized.html", newUnauthorizedData("Sign in to continue.", state.MaskedCSRFToken()))
+		return nil, false
+	}
 
-		account, err := s.authService.LookupByEmail(r.Context(), email)
-		if err != nil {
-			logger.Error("lookup failed", slog.Any("error", err))
-			http.Error(w, "unable to load account", http.StatusInternalServerError)
-			return
+	account, err := s.authService.LookupByID(r.Context(), state.UserID)
+	if errors.Is(err, auth.ErrUserDeactivated) {
+		logger.Warn("session account deactivated")
+		s.sessions.Clear(w)
+		w.WriteHeader(http.StatusForbidden)
+		s.render(w, "unauthorized.html", newUnauthorizedData(accountDeactivatedMsg, ""))
+		return nil, false
+	}
+	if errors.Is(err, auth.ErrUserNotFound) {
+		logger.Warn("session account not found")
+		s.sessions.Clear(w)
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, "unauthorized.html", newUnauthorizedData("Sign in to continue.", ""))
+		return nil, false
+	}
+	if err != nil {
+		logger.Error("lookup failed", slog.Any("error", err))
+		http.Error(w, "unable to load account", http.StatusInternalServerError)
+		return nil, false
+	}
+	return account, true
+}
+
+// newDashboardPage builds the dashboard view, listing the account's login
+// methods, the providers that can still be linked and the applications the
+// user has authorized.
+func (s *Server) newDashboardPage(ctx context.Context, state SessionState, account *auth.User, errMsg string) PageData {
+	data := newDashboardData(
+		account.Email.String(),
+		state.MaskedCSRFToken(),
+		account.CreatedAt.Format(dashboardTimeDisplayLayout),
+		account.CreatedAt.Format(time.RFC3339),
+	)
+	data.Error = errMsg
+	data.DisplayName = account.DisplayName
+	data.AvatarURL = account.AvatarURL
+
+	canUnlink := len(account.Identities) > 1
+	linked := make(map[string]bool, len(account.Identities))
+	for _, identity := range account.Identities {
+		linked[identity.Provider] = true
+		name := identity.Provider
+		if identity.Provider == auth.ProviderPassword {
+			name = "Password"
+		} else if provider, ok := s.providers.Lookup(identity.Provider); ok {
+			name = provider.DisplayName()
+		} else if directory, ok := s.directoryNames[identity.Provider]; ok {
+			name = directory
 		}
+		data.Identities = append(data.Identities, IdentityOption{
+			Provider:     identity.Provider,
+			ProviderName: name,
+			Subject:      identity.Subject,
+			Email:        identity.Email,
+			LinkedAt:     identity.LinkedAt.Format(dashboardTimeDisplayLayout),
+			LinkedAtISO:  identity.LinkedAt.Format(time.RFC3339),
+			CanUnlink:    canUnlink && identity.Provider != auth.ProviderPassword,
+		})
+	}
 
-		createdAtISO := account.CreatedAt.Format(time.RFC3339)
-		createdAtDisplay := account.CreatedAt.Format(dashboardTimeDisplayLayout)
+	data = s.applyOAuthOptions(data)
+	data.Providers = slices.DeleteFunc(data.Providers, func(p ProviderOption) bool {
+		provider, _ := s.providers.Lookup(p.ID)
+		return linked[p.ID] || isSAMLProvider(provider)
+	})
+	for i := range data.Providers {
+		data.Providers[i].LoginURL = "/account/identities/" + data.Providers[i].ID
+	}
 
-		s.render(w, "dashboard.html", newDashboardData(state.Email, state.CSRFToken, createdAtDisplay, createdAtISO))
+	if s.authorizationServer != nil {
+		consents, err := s.authorizationServer.Consents(ctx, account.ID)
+		if err != nil {
+			s.logger.Warn("list authorized applications failed", slog.String("user_id", account.ID), slog.Any("error", err))
+		}
+		for _, consent := range consents {
+			data.Applications = append(data.Applications, ApplicationOption{
+				ClientID: consent.ClientID,
+				Name:     consent.ClientName,
+				Scopes:   consent.Scopes,
+			})
+		}
 	}
+	return data
 }
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rjnemo/auth/internal/service/oauth"
+)
+
+const invalidUserCodeMsg = "That code is invalid or has expired. Check your device and try again."
+
+// deviceAuthorizationHandler starts a device authorization for clients that
+// cannot open a browser themselves (RFC 8628 §3.1). Clients authenticate as at
+// the token endpoint.
+func (s *Server) deviceAuthorizationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		creds, ok := readClientRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp, err := s.authorizationServer.AuthorizeDevice(r.Context(), oauth.DeviceAuthorizationRequest{
+			Scope:            r.PostForm.Get("scope"),
+			ClientID:         creds.ClientID,
+			ClientAuthMethod: creds.ClientAuthMethod,
+			ClientSecret:     creds.ClientSecret,
+			ClientAssertion:  creds.ClientAssertion,
+		})
+		if err != nil {
+			writeClientRequestError(w, r, creds, err, logger)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// deviceVerificationHandler shows the page where a signed-in user enters the
+// code displayed by their device. A user_code in the query, as sent by
+// verification_uri_complete, goes straight to confirmation.
+func (s *Server) deviceVerificationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		state := sessionFromContext(r.Context())
+		if !state.Authenticated {
+			http.Redirect(w, r, "/?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		userCode := r.URL.Query().Get("user_code")
+		if userCode == "" {
+			s.render(w, "device.html", newDeviceData(state, &DeviceView{Step: "enter"}, ""))
+			return
+		}
+		s.confirmDevice(w, r, state, userCode, logger)
+	}
+}
+
+// deviceDecisionHandler receives the code entry form and, once the user
+// confirmed the request, their decision.
+func (s *Server) deviceDecisionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		state := sessionFromContext(r.Context())
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form submission", http.StatusBadRequest)
+			return
+		}
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+
+		userCode := r.PostForm.Get("user_code")
+		decision := r.PostForm.Get("decision")
+		if decision == "" {
+			s.confirmDevice(w, r, state, userCode, logger)
+			return
+		}
+
+		approved := decision == "allow"
+		client, err := s.authorizationServer.DecideDeviceAuthorization(r.Context(), account.ID, userCode, approved)
+		if err != nil {
+			s.renderDeviceError(w, state, userCode, err, logger)
+			return
+		}
+		logger.Info("device authorization decided",
+			slog.String("client_id", client.ID),
+			slog.String("user_id", account.ID),
+			slog.Bool("approved", approved),
+		)
+		s.render(w, "device.html", newDeviceData(state, &DeviceView{Step: "done", ClientName: client.Name, Approved: approved}, ""))
+	}
+}
+
+// confirmDevice shows the request a user code refers to for approval.
+func (s *Server) confirmDevice(w http.ResponseWriter, r *http.Request, state SessionState, userCode string, logger *slog.Logger) {
+	device, client, err := s.authorizationServer.PendingDeviceAuthorization(r.Context(), userCode)
+	if err != nil {
+		s.renderDeviceError(w, state, userCode, err, logger)
+		return
+	}
+
+	view := &DeviceView{
+		Step:       "confirm",
+		UserCode:   strings.ToUpper(strings.TrimSpace(userCode)),
+		ClientName: client.Name,
+	}
+	for _, scope := range device.Scopes {
+		view.Scopes = append(view.Scopes, scopeDescriptions[scope])
+	}
+	// Like the consent screen, the confirmation must not be framed.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	s.render(w, "device.html", newDeviceData(state, view, ""))
+}
+
+// renderDeviceError shows the code entry form again after an unusable code.
+func (s *Server) renderDeviceError(w http.ResponseWriter, state SessionState, userCode string, err error, logger *slog.Logger) {
+	if !errors.Is(err, oauth.ErrDeviceAuthorizationNotFound) {
+		logger.Error("device verification failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("device user code rejected")
+	w.WriteHeader(http.StatusBadRequest)
+	s.render(w, "device.html", newDeviceData(state, &DeviceView{Step: "enter", UserCode: userCode}, invalidUserCodeMsg))
+}
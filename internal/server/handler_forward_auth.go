@@ -0,0 +1,99 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	// forwardAuthPath is where reverse proxies check whether a request is
+	// signed in.
+	forwardAuthPath = "/auth/verify"
+
+	forwardAuthUserHeader  = "X-Auth-User"
+	forwardAuthEmailHeader = "X-Auth-Email"
+)
+
+// forwardAuthHandler lets reverse proxies delegate authentication: nginx
+// auth_request and Traefik ForwardAuth send each request's headers here and
+// only pass the request upstream on a 2xx answer. Callers signed in by the
+// auth_session cookie get 200 with their user ID and current email, read from
+// the account rather than the cookie, in X-Auth-User and X-Auth-Email;
+// everyone else, including personal access token bearers, gets
+// 401. With ?mode=redirect, anonymous callers are sent to the login page
+// instead, which brings them back to the original URL once they sign in.
+func (s *Server) forwardAuthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "forward_auth"))
+		w.Header().Set("Cache-Control", "no-store")
+
+		// A token would let whoever holds it past every protected app as its
+		// owner, so only the browser session counts.
+		_, viaToken := personalTokenFromContext(r.Context())
+		state := sessionFromContext(r.Context())
+		if state.Authenticated && !viaToken {
+			account, err := s.forwardAuthAccount(r, state)
+			switch {
+			case err == nil:
+				w.Header().Set(forwardAuthUserHeader, account.ID)
+				w.Header().Set(forwardAuthEmailHeader, account.Email.String())
+				w.WriteHeader(http.StatusOK)
+				return
+			case !errors.Is(err, auth.ErrUserNotFound):
+				logger.Error("lookup failed", slog.Any("error", err))
+				http.Error(w, "unexpected error", http.StatusInternalServerError)
+				return
+			}
+			logger.Warn("session account not found")
+		}
+
+		if r.URL.Query().Get("mode") != "redirect" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, s.loginURL(s.safeReturnTo(forwardedURL(r))), http.StatusFound)
+	}
+}
+
+// forwardAuthAccount loads the account the session is signed in as, by user
+// ID since the email can change. A session without one, or whose account was
+// deleted or deactivated, yields auth.ErrUserNotFound.
+func (s *Server) forwardAuthAccount(r *http.Request, state SessionState) (*auth.User, error) {
+	if state.UserID == "" {
+		return nil, auth.ErrUserNotFound
+	}
+	account, err := s.authService.LookupByID(r.Context(), state.UserID)
+	if errors.Is(err, auth.ErrUserDeactivated) {
+		return nil, auth.ErrUserNotFound
+	}
+	return account, err
+}
+
+// forwardedURL reconstructs the URL the proxy is checking, from X-Original-URL
+// (set by the nginx configuration) or Traefik's X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Uri. It returns "" when neither is present.
+func forwardedURL(r *http.Request) string {
+	if original := r.Header.Get("X-Original-URL"); original != "" {
+		return original
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return ""
+	}
+	return cmp.Or(r.Header.Get("X-Forwarded-Proto"), "https") + "://" + host + r.Header.Get("X-Forwarded-Uri")
+}
+
+// loginURL returns the login page on the public URL, carrying returnTo when
+// set. Without a public URL the page is addressed on the current host.
+func (s *Server) loginURL(returnTo string) string {
+	target := s.configuration.PublicURL + "/"
+	if returnTo != "" {
+		target += "?" + url.Values{"return_to": {returnTo}}.Encode()
+	}
+	return target
+}
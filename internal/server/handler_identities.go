@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	linkFailedMsg        = "Unable to link your %s account. Please try again."
+	identityInUseMsg     = "That %s account is already linked to another user."
+	lastLoginMethodMsg   = "You cannot remove your only way to sign in."
+	identityNotLinkedMsg = "That login method is not linked to your account."
+	unlinkFailedMsg      = "Unable to unlink that login method. Please try again."
+)
+
+func (s *Server) linkIdentityHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.redirectProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "identities"), slog.String("provider", provider.ID()))
+
+		state := sessionFromContext(r.Context())
+		if _, ok := s.requireSessionAccount(w, r, state, logger); !ok {
+			return
+		}
+
+		s.beginExternalFlow(w, r, state, provider, true, nil, logger)
+	}
+}
+
+// requestScopesHandler starts incremental consent for the scopes posted in the
+// form, asking the provider only for those the user has not granted yet.
+func (s *Server) requestScopesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.redirectProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "identities"), slog.String("provider", provider.ID()))
+
+		state := sessionFromContext(r.Context())
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form submission", http.StatusBadRequest)
+			return
+		}
+
+		missing, err := s.authService.MissingScopes(r.Context(), account.ID, provider.ID(), r.PostForm["scope"])
+		switch {
+		case errors.Is(err, auth.ErrTokenVaultDisabled):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			logger.Error("lookup granted scopes failed", slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return
+		case len(missing) == 0:
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			return
+		}
+
+		logger.Info("requesting additional scopes", slog.Any("scopes", missing))
+		s.beginExternalFlow(w, r, state, provider, true, missing, logger)
+	}
+}
+
+func (s *Server) unlinkIdentityHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providerID := routeProvider(r)
+		logger := s.logger.With(slog.String("component", "identities"), slog.String("provider", providerID))
+
+		state := sessionFromContext(r.Context())
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+
+		err := s.authService.UnlinkExternalIdentity(r.Context(), account, providerID, r.PostFormValue("subject"))
+		switch {
+		case err == nil:
+			logger.Info("external identity unlinked")
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		case errors.Is(err, auth.ErrLastLoginMethod):
+			s.renderDashboardError(w, r, state, account, http.StatusConflict, lastLoginMethodMsg)
+		case errors.Is(err, auth.ErrIdentityNotFound), errors.Is(err, auth.ErrInvalidInput):
+			s.renderDashboardError(w, r, state, account, http.StatusNotFound, identityNotLinkedMsg)
+		default:
+			logger.Error("unlink external identity failed", slog.Any("error", err))
+			s.renderDashboardError(w, r, state, account, http.StatusInternalServerError, unlinkFailedMsg)
+		}
+	}
+}
+
+// finishIdentityLink attaches identity to the signed-in account at the end of
+// a link flow started from the dashboard.
+func (s *Server) finishIdentityLink(w http.ResponseWriter, r *http.Request, state SessionState, provider auth.IdentityProvider, identity auth.ExternalIdentity, logger *slog.Logger) {
+	if err := s.sessions.Save(w, state); err != nil {
+		logger.Error("session save failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+	account, ok := s.requireSessionAccount(w, r, state, logger)
+	if !ok {
+		return
+	}
+
+	err := s.authService.LinkExternalIdentity(r.Context(), account, identity)
+	switch {
+	case err == nil:
+		logger.Info("external identity linked")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	case errors.Is(err, auth.ErrIdentityLinked):
+		logger.Warn("external identity belongs to another account")
+		s.renderDashboardError(w, r, state, account, http.StatusConflict, fmt.Sprintf(identityInUseMsg, provider.DisplayName()))
+	default:
+		logger.Error("link external identity failed", slog.Any("error", err))
+		s.renderDashboardError(w, r, state, account, http.StatusInternalServerError, fmt.Sprintf(linkFailedMsg, provider.DisplayName()))
+	}
+}
+
+// respondWithDashboard reports a failed link flow on the dashboard.
+func (s *Server) respondWithDashboard(w http.ResponseWriter, r *http.Request, state SessionState, status int, message string, logger *slog.Logger) {
+	account, ok := s.requireSessionAccount(w, r, state, logger)
+	if !ok {
+		return
+	}
+	s.renderDashboardError(w, r, state, account, status, message)
+}
+
+func (s *Server) renderDashboardError(w http.ResponseWriter, r *http.Request, state SessionState, account *auth.User, status int, message string) {
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	s.render(w, "dashboard.html", s.newDashboardPage(r.Context(), state, account, message))
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cmp"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -12,10 +13,15 @@ func (s *Server) loginPageHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		state := sessionFromContext(r.Context())
 		if state.Authenticated {
-			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			http.Redirect(w, r, cmp.Or(s.requestedReturnTo(r), defaultReturnTo), http.StatusSeeOther)
 			return
 		}
-		s.render(w, "login.html", s.applyOAuthOptions(newLoginData(state.Email, "", state.CSRFToken)))
+		if s.rememberReturnTo(r, &state) {
+			if err := s.sessions.Save(w, state); err != nil {
+				s.logger.Warn("session save failed", slog.String("component", "login"), slog.Any("error", err))
+			}
+		}
+		s.render(w, "login.html", s.newLoginPage(state, ""))
 	}
 }
 
@@ -35,28 +41,37 @@ func (s *Server) loginHandler() http.HandlerFunc {
 		email, err := auth.NewUserEmail(emailInput)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			s.render(w, "login.html", s.applyOAuthOptions(newLoginData("", credentialRequiredMsg, state.CSRFToken)))
+			s.renderForm(w, r, "login.html", "login_form", s.applyOAuthOptions(newLoginData("", credentialRequiredMsg, state.MaskedCSRFToken())))
 			return
 		}
 
 		account, err := s.authService.Authenticate(r.Context(), email, password)
 		switch {
 		case err == nil:
-			state.Authenticated = true
-			state.Email = account.Email.String()
+			s.completePendingLink(r, &state, account, logger)
+			state, err = signedIn(state, account)
+			if err != nil {
+				logger.Error("session renewal failed", slog.Any("error", err))
+				http.Error(w, "session error", http.StatusInternalServerError)
+				return
+			}
+			target := s.takeReturnTo(&state)
 			if err := s.sessions.Save(w, state); err != nil {
 				logger.Warn("session save failed", slog.Any("error", err))
 			}
-			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
-
+			redirectAfterAuth(w, r, target)
 		case errors.Is(err, auth.ErrWeakPassword):
 			w.WriteHeader(http.StatusBadRequest)
-			s.render(w, "login.html", s.applyOAuthOptions(newLoginData(email.String(), weakPasswordMsg, state.CSRFToken)))
+			s.renderForm(w, r, "login.html", "login_form", s.applyOAuthOptions(newLoginData(email.String(), weakPasswordMsg, state.MaskedCSRFToken())))
 		case errors.Is(err, auth.ErrInvalidInput):
 			w.WriteHeader(http.StatusBadRequest)
-			s.render(w, "login.html", s.applyOAuthOptions(newLoginData(email.String(), credentialRequiredMsg, state.CSRFToken)))
+			s.renderForm(w, r, "login.html", "login_form", s.applyOAuthOptions(newLoginData(email.String(), credentialRequiredMsg, state.MaskedCSRFToken())))
 		case errors.Is(err, auth.ErrInvalidCredentials):
-			s.renderLoginFailure(w, email, state.CSRFToken)
+			s.renderLoginFailure(w, r, email, state.MaskedCSRFToken())
+		case errors.Is(err, auth.ErrUserDeactivated):
+			logger.Warn("deactivated account refused")
+			w.WriteHeader(http.StatusForbidden)
+			s.renderForm(w, r, "login.html", "login_form", s.applyOAuthOptions(newLoginData(email.String(), accountDeactivatedMsg, state.MaskedCSRFToken())))
 		default:
 			logger.Error("authenticate failed", slog.Any("error", err))
 			http.Error(w, "unexpected error", http.StatusInternalServerError)
@@ -64,7 +79,7 @@ func (s *Server) loginHandler() http.HandlerFunc {
 	}
 }
 
-func (s *Server) renderLoginFailure(w http.ResponseWriter, email auth.UserEmail, token string) {
+func (s *Server) renderLoginFailure(w http.ResponseWriter, r *http.Request, email auth.UserEmail, token string) {
 	w.WriteHeader(http.StatusUnauthorized)
-	s.render(w, "login.html", s.applyOAuthOptions(newLoginData(email.String(), invalidCredentialsMsg, token)))
+	s.renderForm(w, r, "login.html", "login_form", s.applyOAuthOptions(newLoginData(email.String(), invalidCredentialsMsg, token)))
 }
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/oauth2"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	externalAuthFailedMsg   = "Unable to sign in with %s. Please try again."
+	externalAuthCanceledMsg = "%s sign-in was cancelled."
+	linkRequiredMsg         = "An account for %s already exists. Sign in to it to link your %s account."
+	accountExistsMsg        = "An account for %s already exists. Sign in to it another way."
+)
+
+// identityProvider resolves the {provider} route parameter against the registry.
+func (s *Server) identityProvider(r *http.Request) (auth.IdentityProvider, bool) {
+	return s.providers.Lookup(routeProvider(r))
+}
+
+// redirectProvider is identityProvider limited to providers that answer on the
+// authorization-code callback. SAML responses are posted cross-site without the
+// session cookie, so they can neither complete a callback nor a link flow.
+func (s *Server) redirectProvider(r *http.Request) (auth.IdentityProvider, bool) {
+	provider, ok := s.identityProvider(r)
+	if !ok || isSAMLProvider(provider) {
+		return nil, false
+	}
+	return provider, true
+}
+
+func routeProvider(r *http.Request) string {
+	return chi.URLParam(r, "provider")
+}
+
+func (s *Server) providerLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.identityProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "external_login"), slog.String("provider", provider.ID()))
+
+		state := sessionFromContext(r.Context())
+		if state.Authenticated {
+			http.Redirect(w, r, cmp.Or(s.requestedReturnTo(r), defaultReturnTo), http.StatusSeeOther)
+			return
+		}
+		s.rememberReturnTo(r, &state)
+
+		s.beginExternalFlow(w, r, state, provider, false, nil, logger)
+	}
+}
+
+// beginExternalFlow records a pending authorization request in the session and
+// redirects the browser to provider. Link flows attach the resulting identity
+// to the signed-in account instead of signing in with it; scopes, when set,
+// ask the provider for consent to those scopes only.
+func (s *Server) beginExternalFlow(w http.ResponseWriter, r *http.Request, state SessionState, provider auth.IdentityProvider, link bool, scopes []string, logger *slog.Logger) {
+	token, err := generateOAuthState()
+	if err != nil {
+		logger.Error("generate oauth state failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := generateOAuthState()
+	if err != nil {
+		logger.Error("generate oidc nonce failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+
+	req := auth.AuthorizationRequest{State: token, Nonce: nonce, Verifier: oauth2.GenerateVerifier(), Scopes: scopes}
+	if !link {
+		req.ReturnTo = state.ReturnTo
+	}
+	redirectURL, err := provider.Begin(r.Context(), req)
+	if err != nil {
+		logger.Error("build authorization url failed", slog.Any("error", err))
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	now := time.Now()
+	state.addOAuthFlow(OAuthFlow{
+		State:     req.State,
+		Nonce:     req.Nonce,
+		Verifier:  req.Verifier,
+		Provider:  provider.ID(),
+		ExpiresAt: now.Add(oauthFlowLifetime),
+		Link:      link,
+		Scopes:    req.Scopes,
+		ReturnTo:  req.ReturnTo,
+	}, now)
+	if err := s.sessions.Save(w, state); err != nil {
+		logger.Error("persist oauth state failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusFound
+	if r.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	http.Redirect(w, r, redirectURL, status)
+}
+
+func (s *Server) providerCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.redirectProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "external_login"), slog.String("provider", provider.ID()))
+
+		state := sessionFromContext(r.Context())
+		flow, found := state.takeOAuthFlow(r.URL.Query().Get("state"), time.Now())
+
+		saveState := func() bool {
+			if err := s.sessions.Save(w, state); err != nil {
+				logger.Error("session save failed", slog.Any("error", err))
+				http.Error(w, "unexpected error", http.StatusInternalServerError)
+				return false
+			}
+			return true
+		}
+
+		respondWithLogin := func(status int, message string) {
+			if flow.Link {
+				s.respondWithDashboard(w, r, state, status, fmt.Sprintf(linkFailedMsg, provider.DisplayName()), logger)
+				return
+			}
+			if status != 0 {
+				w.WriteHeader(status)
+			}
+			s.render(w, "login.html", s.applyOAuthOptions(newLoginData(state.Email, fmt.Sprintf(message, provider.DisplayName()), state.MaskedCSRFToken())))
+		}
+
+		if !found || flow.Provider != provider.ID() {
+			if !saveState() {
+				return
+			}
+			http.Error(w, "invalid oauth state", http.StatusBadRequest)
+			return
+		}
+
+		if errParam := r.URL.Query().Get("error"); errParam != "" {
+			logger.Info("provider returned error", slog.String("oauth_error", errParam))
+			if !saveState() {
+				return
+			}
+			respondWithLogin(http.StatusBadRequest, externalAuthCanceledMsg)
+			return
+		}
+
+		authCode := r.URL.Query().Get("code")
+		if authCode == "" {
+			if !saveState() {
+				return
+			}
+			http.Error(w, "missing authorization code", http.StatusBadRequest)
+			return
+		}
+
+		identity, err := provider.Complete(r.Context(), authCode, auth.AuthorizationRequest{
+			State:    flow.State,
+			Nonce:    flow.Nonce,
+			Verifier: flow.Verifier,
+			Scopes:   flow.Scopes,
+		})
+		if err != nil {
+			if errors.Is(err, auth.ErrEmailUnverified) {
+				logger.Warn("provider returned unverified email")
+			} else {
+				logger.Error("complete external login failed", slog.Any("error", err))
+			}
+			if !saveState() {
+				return
+			}
+			respondWithLogin(http.StatusUnauthorized, externalAuthFailedMsg)
+			return
+		}
+
+		if flow.Link {
+			s.finishIdentityLink(w, r, state, provider, identity, logger)
+			return
+		}
+
+		if flow.ReturnTo != "" {
+			state.ReturnTo = flow.ReturnTo
+		}
+		s.finishExternalLogin(w, r, state, identity, true, logger)
+	}
+}
+
+// finishExternalLogin signs in with identity. When its email belongs to an
+// existing account, offerLink parks it for explicit linking; otherwise the
+// sign-in is refused. Only offerLink sign-ins complete a parked link.
+func (s *Server) finishExternalLogin(w http.ResponseWriter, r *http.Request, state SessionState, identity auth.ExternalIdentity, offerLink bool, logger *slog.Logger) {
+	saveState := func() bool {
+		if err := s.sessions.Save(w, state); err != nil {
+			logger.Error("session save failed", slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return false
+		}
+		return true
+	}
+
+	account, err := s.authService.EnsureExternalUser(r.Context(), identity)
+	if errors.Is(err, auth.ErrLinkRequired) && !offerLink {
+		logger.Warn("external identity matches an existing account; link refused")
+		if !saveState() {
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		s.render(w, "login.html", s.newLoginPage(state, fmt.Sprintf(accountExistsMsg, identity.Email)))
+		return
+	}
+	if errors.Is(err, auth.ErrLinkRequired) {
+		logger.Info("external identity matches an existing account; awaiting link confirmation")
+		state.PendingLink = &PendingLink{
+			Provider:      identity.Provider,
+			Subject:       identity.Subject,
+			Email:         identity.Email.String(),
+			EmailVerified: identity.EmailVerified,
+			ExpiresAt:     time.Now().Add(pendingLinkLifetime),
+		}
+		if !saveState() {
+			return
+		}
+		s.render(w, "login.html", s.newLoginPage(state, ""))
+		return
+	}
+	if errors.Is(err, auth.ErrUserDeactivated) {
+		logger.Warn("deactivated account refused")
+		if !saveState() {
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		s.render(w, "login.html", s.newLoginPage(state, accountDeactivatedMsg))
+		return
+	}
+	if err != nil {
+		logger.Error("ensure external user failed", slog.Any("error", err))
+		if !saveState() {
+			return
+		}
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+
+	if offerLink {
+		s.completePendingLink(r, &state, account, logger)
+	}
+	state, err = signedIn(state, account)
+	if err != nil {
+		logger.Error("session renewal failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+	target := s.takeReturnTo(&state)
+	if !saveState() {
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// newLoginPage builds the login view, prompting the user to sign in to the
+// existing account when an external identity is waiting to be linked.
+func (s *Server) newLoginPage(state SessionState, errMsg string) PageData {
+	data := newLoginData(state.Email, errMsg, state.MaskedCSRFToken())
+	if link, ok := state.activePendingLink(time.Now()); ok {
+		data.Email = link.Email
+		name := link.Provider
+		if provider, found := s.providers.Lookup(link.Provider); found {
+			name = provider.DisplayName()
+		}
+		data.Info = fmt.Sprintf(linkRequiredMsg, link.Email, name)
+	}
+	return s.applyOAuthOptions(data)
+}
+
+// completePendingLink attaches a parked external identity once the user has
+// authenticated as the account owning its email. A link parked for another
+// email is discarded.
+func (s *Server) completePendingLink(r *http.Request, state *SessionState, account *auth.User, logger *slog.Logger) {
+	identity, ok := state.takePendingLink(account.Email.String(), time.Now())
+	if !ok {
+		return
+	}
+	if err := s.authService.LinkExternalIdentity(r.Context(), account, identity); err != nil {
+		logger.Warn("link external identity failed", slog.String("linked_provider", identity.Provider), slog.Any("error", err))
+		return
+	}
+	logger.Info("external identity linked", slog.String("linked_provider", identity.Provider))
+}
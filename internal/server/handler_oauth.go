@@ -0,0 +1,421 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+	"github.com/rjnemo/auth/internal/service/oauth"
+)
+
+const (
+	tokenFormMaxBytes = 64 << 10
+
+	invalidAuthorizationMsg = "The application's sign-in request is invalid. Return to it and try again."
+)
+
+// scopeDescriptions explains each scope on the consent screen.
+var scopeDescriptions = map[string]string{
+	oauth.ScopeOpenID:  "Confirm your identity",
+	oauth.ScopeProfile: "See your name and profile picture",
+	oauth.ScopeEmail:   "See your email address",
+}
+
+// EnableAuthorizationServer serves the OAuth 2.0 authorization server and
+// OpenID provider endpoints backed by provider. It must be called before Router.
+func (s *Server) EnableAuthorizationServer(provider *oauth.Service) {
+	s.authorizationServer = provider
+}
+
+// authorizationRequest reads authorization parameters from the query string
+// or, when the consent form is submitted, the form body.
+func authorizationRequest(values url.Values) oauth.AuthorizationRequest {
+	return oauth.AuthorizationRequest{
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		ResponseType:        values.Get("response_type"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+// authorizeHandler starts an authorization request: anonymous users are sent
+// to sign in first, and the consent screen is skipped once the user approved
+// every requested scope for the client.
+func (s *Server) authorizeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		state := sessionFromContext(r.Context())
+
+		req := authorizationRequest(r.URL.Query())
+		client, ok := s.validateAuthorization(w, r, req, logger)
+		if !ok {
+			return
+		}
+
+		if !state.Authenticated {
+			http.Redirect(w, r, "/?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+
+		required, err := s.authorizationServer.ConsentRequired(r.Context(), account.ID, req)
+		if err != nil {
+			logger.Error("load consent failed", slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return
+		}
+		if !required {
+			s.issueAuthorizationCode(w, r, account, req, logger)
+			return
+		}
+
+		// The consent screen must not be framed, or a hostile page could
+		// trick the user into clicking Allow.
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+		s.render(w, "consent.html", newConsentData(state, client, req))
+	}
+}
+
+// authorizeDecisionHandler receives the consent form.
+func (s *Server) authorizeDecisionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		state := sessionFromContext(r.Context())
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form submission", http.StatusBadRequest)
+			return
+		}
+		req := authorizationRequest(r.PostForm)
+		if _, ok := s.validateAuthorization(w, r, req, logger); !ok {
+			return
+		}
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+
+		if r.PostForm.Get("decision") != "allow" {
+			logger.Info("authorization denied", slog.String("client_id", req.ClientID))
+			s.redirectAuthorizationError(w, r, req, &oauth.Error{Code: oauth.ErrorAccessDenied, Description: "the user denied the request"})
+			return
+		}
+		s.issueAuthorizationCode(w, r, account, req, logger)
+	}
+}
+
+// validateAuthorization checks req, responding and returning false when it is
+// unusable. Requests naming an unknown client or redirect URI are answered
+// here; other errors are returned to the client's redirect URI.
+func (s *Server) validateAuthorization(w http.ResponseWriter, r *http.Request, req oauth.AuthorizationRequest, logger *slog.Logger) (oauth.Client, bool) {
+	client, err := s.authorizationServer.ValidateAuthorization(r.Context(), req)
+	if err == nil {
+		return client, true
+	}
+
+	var oauthErr *oauth.Error
+	switch {
+	case errors.Is(err, oauth.ErrClientNotFound), errors.Is(err, oauth.ErrInvalidRedirectURI):
+		logger.Warn("authorization request rejected", slog.String("client_id", req.ClientID), slog.Any("error", err))
+		state := sessionFromContext(r.Context())
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, "unauthorized.html", newUnauthorizedData(invalidAuthorizationMsg, state.MaskedCSRFToken()))
+	case errors.As(err, &oauthErr):
+		logger.Info("authorization request invalid", slog.String("client_id", req.ClientID), slog.Any("error", err))
+		s.redirectAuthorizationError(w, r, req, oauthErr)
+	default:
+		logger.Error("validate authorization failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+	}
+	return oauth.Client{}, false
+}
+
+// issueAuthorizationCode records consent and returns the code to the client.
+func (s *Server) issueAuthorizationCode(w http.ResponseWriter, r *http.Request, account *auth.User, req oauth.AuthorizationRequest, logger *slog.Logger) {
+	code, err := s.authorizationServer.Authorize(r.Context(), account.ID, req)
+	if err != nil {
+		logger.Error("issue authorization code failed", slog.Any("error", err))
+		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("authorization granted", slog.String("client_id", req.ClientID), slog.String("user_id", account.ID))
+	s.redirectAuthorizationResponse(w, r, req, url.Values{"code": {code}})
+}
+
+func (s *Server) redirectAuthorizationError(w http.ResponseWriter, r *http.Request, req oauth.AuthorizationRequest, oauthErr *oauth.Error) {
+	s.redirectAuthorizationResponse(w, r, req, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+	})
+}
+
+// redirectAuthorizationResponse sends params to the validated redirect URI,
+// echoing state and naming the issuer so clients can detect mix-up attacks
+// (RFC 9207).
+func (s *Server) redirectAuthorizationResponse(w http.ResponseWriter, r *http.Request, req oauth.AuthorizationRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	query.Set("iss", s.authorizationServer.Issuer())
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+// tokenHandler redeems codes and refresh tokens, and issues confidential
+// clients tokens of their own. Public clients send only client_id.
+func (s *Server) tokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		req, ok := readClientRequest(w, r)
+		if !ok {
+			return
+		}
+		req.GrantType = r.PostForm.Get("grant_type")
+		req.Code = r.PostForm.Get("code")
+		req.RedirectURI = r.PostForm.Get("redirect_uri")
+		req.CodeVerifier = r.PostForm.Get("code_verifier")
+		req.DeviceCode = r.PostForm.Get("device_code")
+		req.RefreshToken = r.PostForm.Get("refresh_token")
+		req.Scope = r.PostForm.Get("scope")
+		req.Audience = r.PostForm.Get("audience")
+
+		resp, err := s.authorizationServer.Exchange(r.Context(), req)
+		if err != nil {
+			writeClientRequestError(w, r, req, err, logger.With(slog.String("grant_type", req.GrantType)))
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// introspectionHandler tells resource servers whether a token is active and
+// what it grants (RFC 7662).
+func (s *Server) introspectionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		creds, ok := readClientRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp, err := s.authorizationServer.Introspect(r.Context(), oauth.IntrospectionRequest{
+			Token:            r.PostForm.Get("token"),
+			TokenTypeHint:    r.PostForm.Get("token_type_hint"),
+			ClientID:         creds.ClientID,
+			ClientAuthMethod: creds.ClientAuthMethod,
+			ClientSecret:     creds.ClientSecret,
+			ClientAssertion:  creds.ClientAssertion,
+		})
+		if err != nil {
+			writeClientRequestError(w, r, creds, err, logger)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// revocationHandler revokes a token at its client's request (RFC 7009).
+// Unknown tokens are answered like revoked ones, as the RFC requires.
+func (s *Server) revocationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "oauth"))
+		creds, ok := readClientRequest(w, r)
+		if !ok {
+			return
+		}
+
+		revocation, err := s.authorizationServer.Revoke(r.Context(), oauth.RevocationRequest{
+			Token:            r.PostForm.Get("token"),
+			TokenTypeHint:    r.PostForm.Get("token_type_hint"),
+			ClientID:         creds.ClientID,
+			ClientAuthMethod: creds.ClientAuthMethod,
+			ClientSecret:     creds.ClientSecret,
+			ClientAssertion:  creds.ClientAssertion,
+		})
+		switch {
+		case err == nil:
+			logger.Info("token revoked",
+				slog.String("client_id", revocation.ClientID),
+				slog.String("user_id", revocation.UserID),
+				slog.String("token_type", revocation.TokenType),
+				slog.Int("access_tokens", revocation.AccessTokens),
+			)
+		case errors.Is(err, oauth.ErrTokenNotFound):
+			logger.Info("token revocation ignored", slog.String("client_id", creds.ClientID))
+		default:
+			writeClientRequestError(w, r, creds, err, logger)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// readClientRequest parses the form a client posted to the token, device
+// authorization, introspection or revocation endpoint and reads the client's
+// credentials. It answers malformed requests itself and returns false.
+func readClientRequest(w http.ResponseWriter, r *http.Request) (oauth.TokenRequest, bool) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	r.Body = http.MaxBytesReader(w, r.Body, tokenFormMaxBytes)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "malformed form body"})
+		return oauth.TokenRequest{}, false
+	}
+
+	req := oauth.TokenRequest{ClientID: r.PostForm.Get("client_id")}
+	if oauthErr := readClientCredentials(r, &req); oauthErr != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthErr)
+		return oauth.TokenRequest{}, false
+	}
+	return req, true
+}
+
+// writeClientRequestError answers a failed client request. Failed client
+// authentication is a 401, challenging for Basic when the client used it.
+func writeClientRequestError(w http.ResponseWriter, r *http.Request, req oauth.TokenRequest, err error, logger *slog.Logger) {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		logger.Error("client request failed", slog.Any("error", err))
+		writeOAuthError(w, http.StatusInternalServerError, &oauth.Error{Code: oauth.ErrorServerError, Description: "unexpected error"})
+		return
+	}
+	// Polling device clients hear authorization_pending every few seconds.
+	level := slog.LevelInfo
+	if oauthErr.Code == oauth.ErrorAuthorizationPending || oauthErr.Code == oauth.ErrorSlowDown {
+		level = slog.LevelDebug
+	}
+	logger.Log(r.Context(), level, "client request rejected", slog.String("client_id", req.ClientID), slog.Any("error", err))
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == oauth.ErrorInvalidClient {
+		status = http.StatusUnauthorized
+		if req.ClientAuthMethod == oauth.AuthMethodClientSecretBasic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+	}
+	writeOAuthError(w, status, oauthErr)
+}
+
+// readClientCredentials sets the client's credentials on req and records
+// which method presented them. Presenting more than one is an error.
+func readClientCredentials(r *http.Request, req *oauth.TokenRequest) *oauth.Error {
+	secret := r.PostForm.Get("client_secret")
+	assertionType := r.PostForm.Get("client_assertion_type")
+	assertion := r.PostForm.Get("client_assertion")
+	id, basicSecret, basic := r.BasicAuth()
+
+	methods := 0
+	for _, presented := range []bool{basic, secret != "", assertionType != "" || assertion != ""} {
+		if presented {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "use only one client authentication method"}
+	}
+
+	switch {
+	case basic:
+		// Basic credentials are form-encoded before base64 (RFC 6749 §2.3.1).
+		clientID, idErr := url.QueryUnescape(id)
+		clientSecret, secretErr := url.QueryUnescape(basicSecret)
+		if idErr != nil || secretErr != nil || (req.ClientID != "" && req.ClientID != clientID) {
+			return &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "malformed client credentials"}
+		}
+		req.ClientID, req.ClientSecret = clientID, clientSecret
+		req.ClientAuthMethod = oauth.AuthMethodClientSecretBasic
+	case secret != "":
+		req.ClientSecret = secret
+		req.ClientAuthMethod = oauth.AuthMethodClientSecretPost
+	case assertionType != "" || assertion != "":
+		if assertionType != oauth.ClientAssertionTypeJWTBearer || assertion == "" {
+			return &oauth.Error{Code: oauth.ErrorInvalidRequest, Description: "client_assertion_type must be " + oauth.ClientAssertionTypeJWTBearer}
+		}
+		req.ClientAssertion = assertion
+		req.ClientAuthMethod = oauth.AuthMethodPrivateKeyJWT
+	default:
+		req.ClientAuthMethod = oauth.AuthMethodNone
+	}
+	return nil
+}
+
+// userInfoHandler returns the claims released by a bearer access token.
+func (s *Server) userInfoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := s.authorizationServer.UserInfo(r.Context(), token)
+		switch {
+		case err == nil:
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, claims)
+		case errors.Is(err, oauth.ErrInvalidToken):
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid access token", http.StatusUnauthorized)
+		case errors.Is(err, oauth.ErrInsufficientScope):
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			http.Error(w, "insufficient scope", http.StatusForbidden)
+		default:
+			s.logger.Error("userinfo failed", slog.String("component", "oauth"), slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// discoveryHandler serves the OpenID provider metadata.
+func (s *Server) discoveryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, s.authorizationServer.Discovery())
+	}
+}
+
+// jwksHandler publishes the keys that verify issued ID tokens.
+func (s *Server) jwksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := s.authorizationServer.JWKS(r.Context())
+		if err != nil {
+			s.logger.Error("load jwks failed", slog.String("component", "oauth"), slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, keys)
+	}
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, oauthErr *oauth.Error) {
+	writeJSON(w, status, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	// personalTokensPath is where users manage their personal access tokens.
+	personalTokensPath = "/account/tokens"
+
+	defaultPersonalTokenLifetimeDays = 30
+
+	personalTokenInvalidMsg   = "Give the token a name, at least one scope and an expiry."
+	personalTokenFailedMsg    = "Unable to create the token. Please try again."
+	personalTokenNotFoundMsg  = "That token does not exist or was already revoked."
+	personalTokenRevokeErrMsg = "Unable to revoke the token. Please try again."
+)
+
+// personalTokenLifetimes are the expiries, in days, offered for new tokens.
+var personalTokenLifetimes = []int{7, 30, 90, 365}
+
+// personalTokenScopeDescriptions explains each personal access token scope.
+var personalTokenScopeDescriptions = map[string]string{
+	auth.PersonalTokenScopeRead:  "Read your account",
+	auth.PersonalTokenScopeWrite: "Change your account and login methods",
+}
+
+func (s *Server) personalTokensPageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "personal_tokens"))
+		state := sessionFromContext(r.Context())
+
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+		s.renderPersonalTokens(w, r, state, account, 0, PersonalTokensView{LifetimeDays: defaultPersonalTokenLifetimeDays}, "", logger)
+	}
+}
+
+// createPersonalTokenHandler issues a token and shows its secret on the
+// response page, the only time it is ever displayed.
+func (s *Server) createPersonalTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "personal_tokens"))
+		state := sessionFromContext(r.Context())
+
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form submission", http.StatusBadRequest)
+			return
+		}
+
+		days, _ := strconv.Atoi(r.PostFormValue("expires_in_days"))
+		view := PersonalTokensView{Name: r.PostFormValue("name"), LifetimeDays: days, Scopes: r.PostForm["scope"]}
+		token, secret, err := s.authService.CreatePersonalToken(r.Context(), account.ID, auth.PersonalTokenRequest{
+			Name:     view.Name,
+			Scopes:   view.Scopes,
+			Lifetime: time.Duration(days) * 24 * time.Hour,
+		})
+		switch {
+		case errors.Is(err, auth.ErrInvalidInput):
+			s.renderPersonalTokens(w, r, state, account, http.StatusBadRequest, view, personalTokenInvalidMsg, logger)
+			return
+		case err != nil:
+			logger.Error("create personal access token failed", slog.Any("error", err))
+			s.renderPersonalTokens(w, r, state, account, http.StatusInternalServerError, view, personalTokenFailedMsg, logger)
+			return
+		}
+
+		logger.Info("personal access token created",
+			slog.String("user_id", account.ID),
+			slog.String("token_id", token.ID),
+			slog.Any("scopes", token.Scopes),
+			slog.Time("expires_at", token.ExpiresAt),
+		)
+		view = PersonalTokensView{LifetimeDays: defaultPersonalTokenLifetimeDays, NewToken: secret, NewTokenName: token.Name}
+		s.renderPersonalTokens(w, r, state, account, 0, view, "", logger)
+	}
+}
+
+func (s *Server) revokePersonalTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.With(slog.String("component", "personal_tokens"))
+		state := sessionFromContext(r.Context())
+
+		account, ok := s.requireSessionAccount(w, r, state, logger)
+		if !ok {
+			return
+		}
+
+		id := chi.URLParam(r, "tokenID")
+		view := PersonalTokensView{LifetimeDays: defaultPersonalTokenLifetimeDays}
+		err := s.authService.RevokePersonalToken(r.Context(), account.ID, id)
+		switch {
+		case err == nil:
+			logger.Info("personal access token revoked", slog.String("user_id", account.ID), slog.String("token_id", id))
+			http.Redirect(w, r, personalTokensPath, http.StatusSeeOther)
+		case errors.Is(err, auth.ErrPersonalTokenNotFound):
+			s.renderPersonalTokens(w, r, state, account, http.StatusNotFound, view, personalTokenNotFoundMsg, logger)
+		default:
+			logger.Error("revoke personal access token failed", slog.Any("error", err))
+			s.renderPersonalTokens(w, r, state, account, http.StatusInternalServerError, view, personalTokenRevokeErrMsg, logger)
+		}
+	}
+}
+
+// requireSessionAccount is requireAccount for pages that act on the user's
+// behalf, such as token management, consent and identity linking. They refuse
+// requests authenticated by a personal access token, so a leaked token cannot
+// mint others, approve clients or change how the account signs in.
+func (s *Server) requireSessionAccount(w http.ResponseWriter, r *http.Request, state SessionState, logger *slog.Logger) (*auth.User, bool) {
+	if _, ok := personalTokenFromContext(r.Context()); ok {
+		http.Error(w, "personal access tokens cannot be used here", http.StatusForbidden)
+		return nil, false
+	}
+	return s.requireAccount(w, r, state, logger)
+}
+
+// renderPersonalTokens renders the token management page with the account's
+// current tokens listed alongside view.
+func (s *Server) renderPersonalTokens(w http.ResponseWriter, r *http.Request, state SessionState, account *auth.User, status int, view PersonalTokensView, errMsg string, logger *slog.Logger) {
+	tokens, err := s.authService.PersonalTokens(r.Context(), account.ID)
+	if err != nil {
+		logger.Error("list personal access tokens failed", slog.Any("error", err))
+		http.Error(w, "unable to load tokens", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	for _, token := range tokens {
+		option := PersonalTokenOption{
+			ID:           token.ID,
+			Name:         token.Name,
+			Hint:         token.Hint,
+			Scopes:       token.Scopes,
+			Expired:      token.Expired(now),
+			ExpiresAt:    token.ExpiresAt.Format(dashboardTimeDisplayLayout),
+			ExpiresAtISO: token.ExpiresAt.Format(time.RFC3339),
+			CreatedAt:    token.CreatedAt.Format(dashboardTimeDisplayLayout),
+			CreatedAtISO: token.CreatedAt.Format(time.RFC3339),
+			LastUsedIP:   token.LastUsedIP,
+		}
+		if !token.LastUsedAt.IsZero() {
+			option.LastUsedAt = token.LastUsedAt.Format(dashboardTimeDisplayLayout)
+			option.LastUsedAtISO = token.LastUsedAt.Format(time.RFC3339)
+		}
+		view.Tokens = append(view.Tokens, option)
+	}
+	for _, scope := range auth.PersonalTokenScopes {
+		view.ScopeOptions = append(view.ScopeOptions, ScopeOption{
+			Value:       scope,
+			Description: personalTokenScopeDescriptions[scope],
+			Checked:     slices.Contains(view.Scopes, scope),
+		})
+	}
+	view.Lifetimes = personalTokenLifetimes
+
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	s.render(w, "tokens.html", newPersonalTokensData(state, &view, errMsg))
+}
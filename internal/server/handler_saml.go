@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/rjnemo/auth/internal/driver/saml"
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	samlFormMaxBytes    = 2 << 20
+	samlLoginCookieName = "auth_saml_login"
+	samlLoginLifetime   = time.Minute
+)
+
+// UseSAMLStore keeps every SAML connection's pending requests and consumed
+// assertions in store instead of in memory, so instances behind a load
+// balancer share them. Call it before serving.
+func (s *Server) UseSAMLStore(store saml.Store) {
+	for _, provider := range s.providers.All() {
+		if connection, ok := provider.(*samlIdentityProvider); ok {
+			connection.sp.UseStore(store)
+		}
+	}
+}
+
+// samlProvider resolves the {provider} route parameter to a SAML connection.
+func (s *Server) samlProvider(r *http.Request) (*samlIdentityProvider, bool) {
+	provider, ok := s.identityProvider(r)
+	if !ok {
+		return nil, false
+	}
+	connection, ok := provider.(*samlIdentityProvider)
+	return connection, ok
+}
+
+// samlMetadataHandler serves the service provider metadata an administrator
+// registers with the customer's IdP.
+func (s *Server) samlMetadataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.samlProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		metadata, err := provider.Metadata()
+		if err != nil {
+			s.logger.Error("build saml metadata failed", slog.String("component", "saml"), slog.String("provider", provider.ID()), slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		_, _ = w.Write(metadata)
+	}
+}
+
+// samlLogin carries a validated assertion from the ACS, which the IdP posts
+// cross-site without the session cookie, to the same-site redirect that can
+// check it against the session.
+type samlLogin struct {
+	Identity auth.ExternalIdentity `json:"identity"`
+	// State is the flow the response answers, empty for IdP-initiated sign-ins.
+	State      string    `json:"state,omitempty"`
+	RelayState string    `json:"relay_state,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func samlACSPath(provider string) string {
+	return "/saml/" + provider + "/acs"
+}
+
+// samlACSHandler is the assertion consumer service receiving HTTP-POST
+// responses for both SP- and IdP-initiated sign-ins. It seals the validated
+// identity into a short-lived cookie and redirects to samlLoginHandler.
+func (s *Server) samlACSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.samlProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "external_login"), slog.String("provider", provider.ID()))
+
+		r.Body = http.MaxBytesReader(w, r.Body, samlFormMaxBytes)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form submission", http.StatusBadRequest)
+			return
+		}
+
+		identity, flowState, err := provider.ConsumeAssertion(r.Context(), r.PostForm.Get("SAMLResponse"))
+		if err != nil {
+			if errors.Is(err, auth.ErrEmailUnverified) {
+				logger.Warn("saml assertion carries no usable email", slog.Any("error", err))
+			} else {
+				logger.Warn("saml response rejected", slog.Any("error", err))
+			}
+			s.respondSAMLFailure(w, r, provider)
+			return
+		}
+
+		sealed, err := encodeSAMLLogin(samlLogin{
+			Identity:   identity,
+			State:      flowState,
+			RelayState: r.PostForm.Get("RelayState"),
+			ExpiresAt:  time.Now().Add(samlLoginLifetime),
+		}, s.sessions.secret)
+		if err != nil {
+			logger.Error("seal saml login failed", slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     samlLoginCookieName,
+			Value:    sealed,
+			Path:     samlACSPath(provider.ID()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   int(samlLoginLifetime / time.Second),
+		})
+		http.Redirect(w, r, samlACSPath(provider.ID()), http.StatusSeeOther)
+	}
+}
+
+// samlLoginHandler finishes a SAML sign-in once the browser follows the ACS
+// redirect with its session cookie. An SP-initiated response must answer a
+// flow started in this session. IdP-initiated responses, when the connection
+// allows them, answer none and cannot be bound to the browser.
+func (s *Server) samlLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.samlProvider(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "external_login"), slog.String("provider", provider.ID()))
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     samlLoginCookieName,
+			Path:     samlACSPath(provider.ID()),
+			MaxAge:   -1,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		var login samlLogin
+		cookie, err := r.Cookie(samlLoginCookieName)
+		if err == nil {
+			login, err = decodeSAMLLogin(cookie.Value, s.sessions.secret)
+		}
+		if err != nil || time.Now().After(login.ExpiresAt) || login.Identity.Provider != provider.ID() {
+			logger.Warn("saml login missing or expired")
+			s.respondSAMLFailure(w, r, provider)
+			return
+		}
+
+		state := sessionFromContext(r.Context())
+		if login.State == "" {
+			// RelayState is attacker-controllable, so it passes the same allow-list.
+			state.ReturnTo = s.safeReturnTo(login.RelayState)
+		} else {
+			flow, found := state.takeOAuthFlow(login.State, time.Now())
+			if !found || flow.Provider != provider.ID() || flow.Link {
+				logger.Warn("saml response answers no sign-in started in this browser")
+				if err := s.sessions.Save(w, state); err != nil {
+					logger.Error("session save failed", slog.Any("error", err))
+					http.Error(w, "unexpected error", http.StatusInternalServerError)
+					return
+				}
+				s.respondSAMLFailure(w, r, provider)
+				return
+			}
+			if flow.ReturnTo != "" {
+				state.ReturnTo = flow.ReturnTo
+			}
+		}
+		s.finishExternalLogin(w, r, state, login.Identity, false, logger)
+	}
+}
+
+func (s *Server) respondSAMLFailure(w http.ResponseWriter, r *http.Request, provider auth.IdentityProvider) {
+	state := sessionFromContext(r.Context())
+	w.WriteHeader(http.StatusUnauthorized)
+	s.render(w, "login.html", s.applyOAuthOptions(newLoginData(state.Email, fmt.Sprintf(externalAuthFailedMsg, provider.DisplayName()), state.MaskedCSRFToken())))
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rjnemo/auth/internal/service/scim"
+)
+
+const (
+	// scimPrefix is the root of the SCIM provisioning endpoint.
+	scimPrefix = "/scim/v2"
+
+	scimContentType  = "application/scim+json"
+	scimBodyMaxBytes = 1 << 20
+)
+
+type scimTenantContextKey struct{}
+
+// EnableSCIM serves SCIM provisioning for the directory's tenants.
+func (s *Server) EnableSCIM(directory *scim.Service) {
+	s.scim = directory
+}
+
+func (s *Server) registerSCIMRoutes(r chi.Router) {
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeSCIMError(w, &scim.Error{Status: http.StatusNotFound, Detail: "no such resource"})
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeSCIMError(w, &scim.Error{Status: http.StatusMethodNotAllowed, Detail: "method not allowed"})
+	})
+
+	r.Use(s.requireSCIMTenant)
+	r.Get("/ServiceProviderConfig", s.scimProviderConfigHandler())
+	r.Get("/Users", s.scimListUsersHandler())
+	r.Post("/Users", s.scimCreateUserHandler())
+	r.Get("/Users/{id}", s.scimGetUserHandler())
+	r.Put("/Users/{id}", s.scimReplaceUserHandler())
+	r.Patch("/Users/{id}", s.scimPatchUserHandler())
+	r.Delete("/Users/{id}", s.scimDeleteUserHandler())
+	r.Get("/Groups", s.scimListGroupsHandler())
+	r.Post("/Groups", s.scimCreateGroupHandler())
+	r.Get("/Groups/{id}", s.scimGetGroupHandler())
+	r.Put("/Groups/{id}", s.scimReplaceGroupHandler())
+	r.Patch("/Groups/{id}", s.scimPatchGroupHandler())
+	r.Delete("/Groups/{id}", s.scimDeleteGroupHandler())
+}
+
+// requireSCIMTenant admits requests bearing a tenant's provisioning token and
+// records the tenant in the request context.
+func (s *Server) requireSCIMTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := bearerToken(r)
+		tenant, ok := s.scim.Authenticate(token)
+		if !ok {
+			s.logger.With(slog.String("component", "scim")).Warn("scim token rejected", slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, &scim.Error{Status: http.StatusUnauthorized, Detail: "a valid provisioning token is required"})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scimTenantContextKey{}, tenant)))
+	})
+}
+
+func scimTenant(r *http.Request) scim.Tenant {
+	tenant, _ := r.Context().Value(scimTenantContextKey{}).(scim.Tenant)
+	return tenant
+}
+
+func (s *Server) scimLogger(r *http.Request) *slog.Logger {
+	return s.logger.With(slog.String("component", "scim"), slog.String("tenant", scimTenant(r).ID))
+}
+
+// scimProviderConfigHandler advertises the supported SCIM features.
+func (s *Server) scimProviderConfigHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeSCIM(w, http.StatusOK, scim.ProviderConfig())
+	}
+}
+
+// scimListUsersHandler returns a page of the tenant's users.
+func (s *Server) scimListUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := scim.ParseListParams(r.URL.Query())
+		if err != nil {
+			s.scimFailure(w, r, "list users failed", err)
+			return
+		}
+		page, err := s.scim.ListUsers(r.Context(), scimTenant(r), params)
+		if err != nil {
+			s.scimFailure(w, r, "list users failed", err)
+			return
+		}
+		for i := range page.Resources {
+			s.locateUser(&page.Resources[i])
+		}
+		writeSCIM(w, http.StatusOK, page)
+	}
+}
+
+// scimCreateUserHandler provisions an account.
+func (s *Server) scimCreateUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resource scim.UserResource
+		if !decodeSCIMBody(w, r, &resource) {
+			return
+		}
+		user, err := s.scim.CreateUser(r.Context(), scimTenant(r), resource)
+		if err != nil {
+			s.scimFailure(w, r, "create user failed", err)
+			return
+		}
+		s.scimLogger(r).Info("user provisioned", slog.String("user_id", user.ID), slog.Bool("active", *user.Active))
+		s.locateUser(&user)
+		w.Header().Set("Location", user.Meta.Location)
+		writeSCIM(w, http.StatusCreated, user)
+	}
+}
+
+// scimGetUserHandler returns one of the tenant's users.
+func (s *Server) scimGetUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.scim.GetUser(r.Context(), scimTenant(r), chi.URLParam(r, "id"))
+		if err != nil {
+			s.scimFailure(w, r, "lookup user failed", err)
+			return
+		}
+		s.locateUser(&user)
+		writeSCIM(w, http.StatusOK, user)
+	}
+}
+
+// scimReplaceUserHandler replaces one of the tenant's users.
+func (s *Server) scimReplaceUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resource scim.UserResource
+		if !decodeSCIMBody(w, r, &resource) {
+			return
+		}
+		user, err := s.scim.ReplaceUser(r.Context(), scimTenant(r), chi.URLParam(r, "id"), resource)
+		if err != nil {
+			s.scimFailure(w, r, "replace user failed", err)
+			return
+		}
+		s.scimLogger(r).Info("user updated", slog.String("user_id", user.ID), slog.Bool("active", *user.Active))
+		s.locateUser(&user)
+		writeSCIM(w, http.StatusOK, user)
+	}
+}
+
+// scimPatchUserHandler applies a PATCH to one of the tenant's users; this is
+// how most directories deactivate accounts.
+func (s *Server) scimPatchUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch scim.PatchRequest
+		if !decodeSCIMBody(w, r, &patch) {
+			return
+		}
+		user, err := s.scim.PatchUser(r.Context(), scimTenant(r), chi.URLParam(r, "id"), patch)
+		if err != nil {
+			s.scimFailure(w, r, "patch user failed", err)
+			return
+		}
+		s.scimLogger(r).Info("user updated", slog.String("user_id", user.ID), slog.Bool("active", *user.Active))
+		s.locateUser(&user)
+		writeSCIM(w, http.StatusOK, user)
+	}
+}
+
+// scimDeleteUserHandler releases one of the tenant's users. The account is
+// deactivated, not erased.
+func (s *Server) scimDeleteUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if err := s.scim.DeleteUser(r.Context(), scimTenant(r), id); err != nil {
+			s.scimFailure(w, r, "delete user failed", err)
+			return
+		}
+		s.scimLogger(r).Info("user deprovisioned", slog.String("user_id", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// scimListGroupsHandler returns a page of the tenant's groups.
+func (s *Server) scimListGroupsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := scim.ParseListParams(r.URL.Query())
+		if err != nil {
+			s.scimFailure(w, r, "list groups failed", err)
+			return
+		}
+		page, err := s.scim.ListGroups(r.Context(), scimTenant(r), params)
+		if err != nil {
+			s.scimFailure(w, r, "list groups failed", err)
+			return
+		}
+		for i := range page.Resources {
+			s.locateGroup(&page.Resources[i])
+		}
+		writeSCIM(w, http.StatusOK, page)
+	}
+}
+
+// scimCreateGroupHandler creates a group of the tenant's users.
+func (s *Server) scimCreateGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resource scim.GroupResource
+		if !decodeSCIMBody(w, r, &resource) {
+			return
+		}
+		group, err := s.scim.CreateGroup(r.Context(), scimTenant(r), resource)
+		if err != nil {
+			s.scimFailure(w, r, "create group failed", err)
+			return
+		}
+		s.scimLogger(r).Info("group provisioned", slog.String("group_id", group.ID), slog.Int("members", len(group.Members)))
+		s.locateGroup(&group)
+		w.Header().Set("Location", group.Meta.Location)
+		writeSCIM(w, http.StatusCreated, group)
+	}
+}
+
+// scimGetGroupHandler returns one of the tenant's groups.
+func (s *Server) scimGetGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group, err := s.scim.GetGroup(r.Context(), scimTenant(r), chi.URLParam(r, "id"))
+		if err != nil {
+			s.scimFailure(w, r, "lookup group failed", err)
+			return
+		}
+		s.locateGroup(&group)
+		writeSCIM(w, http.StatusOK, group)
+	}
+}
+
+// scimReplaceGroupHandler replaces one of the tenant's groups.
+func (s *Server) scimReplaceGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resource scim.GroupResource
+		if !decodeSCIMBody(w, r, &resource) {
+			return
+		}
+		group, err := s.scim.ReplaceGroup(r.Context(), scimTenant(r), chi.URLParam(r, "id"), resource)
+		if err != nil {
+			s.scimFailure(w, r, "replace group failed", err)
+			return
+		}
+		s.scimLogger(r).Info("group updated", slog.String("group_id", group.ID), slog.Int("members", len(group.Members)))
+		s.locateGroup(&group)
+		writeSCIM(w, http.StatusOK, group)
+	}
+}
+
+// scimPatchGroupHandler applies a PATCH to one of the tenant's groups.
+func (s *Server) scimPatchGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch scim.PatchRequest
+		if !decodeSCIMBody(w, r, &patch) {
+			return
+		}
+		group, err := s.scim.PatchGroup(r.Context(), scimTenant(r), chi.URLParam(r, "id"), patch)
+		if err != nil {
+			s.scimFailure(w, r, "patch group failed", err)
+			return
+		}
+		s.scimLogger(r).Info("group updated", slog.String("group_id", group.ID), slog.Int("members", len(group.Members)))
+		s.locateGroup(&group)
+		writeSCIM(w, http.StatusOK, group)
+	}
+}
+
+// scimDeleteGroupHandler deletes one of the tenant's groups.
+func (s *Server) scimDeleteGroupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if err := s.scim.DeleteGroup(r.Context(), scimTenant(r), id); err != nil {
+			s.scimFailure(w, r, "delete group failed", err)
+			return
+		}
+		s.scimLogger(r).Info("group deleted", slog.String("group_id", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// locateUser sets the user's meta.location on the public URL, or on the
+// current host without one.
+func (s *Server) locateUser(user *scim.UserResource) {
+	if user.Meta != nil {
+		user.Meta.Location = s.configuration.PublicURL + scimPrefix + "/Users/" + user.ID
+	}
+}
+
+func (s *Server) locateGroup(group *scim.GroupResource) {
+	if group.Meta != nil {
+		group.Meta.Location = s.configuration.PublicURL + scimPrefix + "/Groups/" + group.ID
+	}
+}
+
+// scimFailure writes the SCIM error the service returned, or logs anything
+// else as an internal error.
+func (s *Server) scimFailure(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	var scimErr *scim.Error
+	if errors.As(err, &scimErr) {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	s.scimLogger(r).Error(msg, slog.Any("error", err))
+	writeSCIMError(w, &scim.Error{Status: http.StatusInternalServerError, Detail: "unexpected error"})
+}
+
+// decodeSCIMBody decodes a SCIM JSON request body into dst. Unknown fields
+// are ignored: directories send schema extensions this service does not keep.
+func decodeSCIMBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if raw := r.Header.Get("Content-Type"); raw != "" {
+		mediaType, _, err := mime.ParseMediaType(raw)
+		if err != nil || (mediaType != scimContentType && mediaType != "application/json") {
+			writeSCIMError(w, &scim.Error{Status: http.StatusUnsupportedMediaType, Detail: "request body must be " + scimContentType})
+			return false
+		}
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scimBodyMaxBytes)).Decode(dst); err != nil {
+		writeSCIMError(w, &scim.Error{Status: http.StatusBadRequest, Type: scim.ErrorInvalidSyntax, Detail: "request body must be a JSON object"})
+		return false
+	}
+	return true
+}
+
+func writeSCIM(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeSCIMError writes err as a SCIM error response (RFC 7644 §3.12), whose
+// status member is a string.
+func writeSCIMError(w http.ResponseWriter, err *scim.Error) {
+	body := struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail"`
+	}{
+		Schemas:  []string{scim.SchemaError},
+		Status:   strconv.Itoa(err.Status),
+		ScimType: err.Type,
+		Detail:   err.Detail,
+	}
+	writeSCIM(w, err.Status, body)
+}
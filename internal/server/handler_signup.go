@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cmp"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -13,6 +14,8 @@ const (
 	invalidCredentialsMsg = "Invalid credentials."
 	duplicateEmailMsg     = "An account with that email already exists."
 	weakPasswordMsg       = "Password must be at least 8 characters, include an uppercase letter, and contain a number."
+	accountDeactivatedMsg = "This account has been deactivated. Contact your administrator."
+	directoryDomainMsg    = "Accounts for this email domain come from your organization's directory. Sign in with your directory password instead."
 )
 
 func (s *Server) signupPageHandler() http.HandlerFunc {
@@ -20,11 +23,16 @@ func (s *Server) signupPageHandler() http.HandlerFunc {
 		state := sessionFromContext(r.Context())
 
 		if state.Authenticated {
-			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			http.Redirect(w, r, cmp.Or(s.requestedReturnTo(r), defaultReturnTo), http.StatusSeeOther)
 			return
 		}
+		if s.rememberReturnTo(r, &state) {
+			if err := s.sessions.Save(w, state); err != nil {
+				s.logger.Warn("session save failed", slog.String("component", "signup"), slog.Any("error", err))
+			}
+		}
 
-		s.render(w, "signup.html", s.applyOAuthOptions(newSignupData(state.Email, "", state.CSRFToken)))
+		s.render(w, "signup.html", s.applyOAuthOptions(newSignupData(state.Email, "", state.MaskedCSRFToken())))
 	}
 }
 
@@ -44,28 +52,36 @@ func (s *Server) signupHandler() http.HandlerFunc {
 		email, err := auth.NewUserEmail(emailValue)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			s.render(w, "signup.html", s.applyOAuthOptions(newSignupData("", credentialRequiredMsg, state.CSRFToken)))
+			s.renderForm(w, r, "signup.html", "signup_form", s.applyOAuthOptions(newSignupData("", credentialRequiredMsg, state.MaskedCSRFToken())))
 			return
 		}
 
 		account, err := s.authService.Register(r.Context(), email, password)
 		switch {
 		case err == nil:
-			state.Authenticated = true
-			state.Email = account.Email.String()
+			state, err = signedIn(state, account)
+			if err != nil {
+				logger.Error("session renewal failed", slog.Any("error", err))
+				http.Error(w, "session error", http.StatusInternalServerError)
+				return
+			}
+			target := s.takeReturnTo(&state)
 			if err := s.sessions.Save(w, state); err != nil {
 				logger.Warn("session save failed", slog.Any("error", err))
 			}
-			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			redirectAfterAuth(w, r, target)
 		case errors.Is(err, auth.ErrWeakPassword):
 			w.WriteHeader(http.StatusBadRequest)
-			s.render(w, "signup.html", s.applyOAuthOptions(newSignupData(email.String(), weakPasswordMsg, state.CSRFToken)))
+			s.renderForm(w, r, "signup.html", "signup_form", s.applyOAuthOptions(newSignupData(email.String(), weakPasswordMsg, state.MaskedCSRFToken())))
 		case errors.Is(err, auth.ErrInvalidInput):
 			w.WriteHeader(http.StatusBadRequest)
-			s.render(w, "signup.html", s.applyOAuthOptions(newSignupData(email.String(), credentialRequiredMsg, state.CSRFToken)))
+			s.renderForm(w, r, "signup.html", "signup_form", s.applyOAuthOptions(newSignupData(email.String(), credentialRequiredMsg, state.MaskedCSRFToken())))
 		case errors.Is(err, auth.ErrEmailExists):
 			w.WriteHeader(http.StatusConflict)
-			s.render(w, "signup.html", s.applyOAuthOptions(newSignupData(email.String(), duplicateEmailMsg, state.CSRFToken)))
+			s.renderForm(w, r, "signup.html", "signup_form", s.applyOAuthOptions(newSignupData(email.String(), duplicateEmailMsg, state.MaskedCSRFToken())))
+		case errors.Is(err, auth.ErrDirectoryDomain):
+			w.WriteHeader(http.StatusConflict)
+			s.renderForm(w, r, "signup.html", "signup_form", s.applyOAuthOptions(newSignupData(email.String(), directoryDomainMsg, state.MaskedCSRFToken())))
 		default:
 			logger.Error("register failed", slog.Any("error", err))
 			http.Error(w, "unexpected error", http.StatusInternalServerError)
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/rjnemo/auth/internal/config"
+	"github.com/rjnemo/auth/internal/driver/github"
+	"github.com/rjnemo/auth/internal/driver/oidc"
+	"github.com/rjnemo/auth/internal/driver/saml"
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+// newProviderRegistry builds the identity providers enabled in cfg, in the
+// order their buttons appear on the login page.
+func newProviderRegistry(cfg config.Config) (*auth.ProviderRegistry, error) {
+	var providers []auth.IdentityProvider
+
+	if cfg.GoogleOAuth.Enabled() {
+		var scopes []string
+		if len(cfg.GoogleOAuth.Scopes) > 0 {
+			scopes = slices.Concat(loginScopes, cfg.GoogleOAuth.Scopes)
+		}
+		google, err := newOIDCIdentityProvider(auth.ProviderGoogle, "Google", oidc.Config{
+			Issuer:       cmp.Or(cfg.GoogleOAuth.Issuer, oidc.GoogleIssuer),
+			ClientID:     cfg.GoogleOAuth.ClientID,
+			ClientSecret: cfg.GoogleOAuth.ClientSecret,
+			RedirectURL:  cfg.GoogleOAuth.RedirectURL,
+			Scopes:       scopes,
+		}, cfg.GoogleOAuth.OfflineAccess)
+		if err != nil {
+			return nil, fmt.Errorf("google provider: %w", err)
+		}
+		providers = append(providers, google)
+	}
+
+	if cfg.GitHubOAuth.Enabled() {
+		client, err := github.New(github.Config{
+			ClientID:     cfg.GitHubOAuth.ClientID,
+			ClientSecret: cfg.GitHubOAuth.ClientSecret,
+			RedirectURL:  cfg.GitHubOAuth.RedirectURL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("github provider: %w", err)
+		}
+		providers = append(providers, newGitHubIdentityProvider(client))
+	}
+
+	if cfg.OIDC.Enabled() {
+		generic, err := newOIDCIdentityProvider(auth.ProviderOIDC, cfg.OIDC.Name, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+		}, false)
+		if err != nil {
+			return nil, fmt.Errorf("oidc provider: %w", err)
+		}
+		providers = append(providers, generic)
+	}
+
+	for _, conn := range cfg.SAML {
+		connection, err := newSAMLIdentityProvider(conn)
+		if err != nil {
+			return nil, fmt.Errorf("saml connection %q: %w", conn.ID, err)
+		}
+		providers = append(providers, connection)
+	}
+
+	return auth.NewProviderRegistry(providers...)
+}
+
+// loginScopes are the scopes every OpenID Connect login requests.
+var loginScopes = []string{"openid", "email", "profile"}
+
+// oidcIdentityProvider adapts an OpenID Connect relying party to the login flow.
+type oidcIdentityProvider struct {
+	id          string
+	displayName string
+	rp          *oidc.Provider
+	// offline requests refresh tokens and hands the issued tokens to the vault.
+	offline bool
+}
+
+func newOIDCIdentityProvider(id, displayName string, cfg oidc.Config, offline bool) (*oidcIdentityProvider, error) {
+	rp, err := oidc.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcIdentityProvider{id: id, displayName: displayName, rp: rp, offline: offline}, nil
+}
+
+func (p *oidcIdentityProvider) ID() string          { return p.id }
+func (p *oidcIdentityProvider) DisplayName() string { return p.displayName }
+
+// Begin builds the authorization URL. Incremental consent adds the missing
+// scopes to the login scopes, so the grant and the ID token keep the email and
+// profile, and relies on include_granted_scopes to keep earlier grants.
+func (p *oidcIdentityProvider) Begin(ctx context.Context, req auth.AuthorizationRequest) (string, error) {
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(req.Verifier)}
+	if p.offline {
+		opts = append(opts, oauth2.AccessTypeOffline)
+	}
+	if len(req.Scopes) > 0 {
+		opts = append(opts,
+			oauth2.SetAuthURLParam("scope", strings.Join(auth.MergeScopes(loginScopes, req.Scopes), " ")),
+			oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+			oauth2.ApprovalForce,
+		)
+	}
+	return p.rp.AuthCodeURL(ctx, req.State, req.Nonce, opts...)
+}
+
+func (p *oidcIdentityProvider) Complete(ctx context.Context, code string, req auth.AuthorizationRequest) (auth.ExternalIdentity, error) {
+	token, claims, err := p.rp.Exchange(ctx, code, req.Nonce, oauth2.VerifierOption(req.Verifier))
+	if err != nil {
+		return auth.ExternalIdentity{}, err
+	}
+	if !claims.EmailVerified || claims.Email == "" {
+		return auth.ExternalIdentity{}, auth.ErrEmailUnverified
+	}
+	email, err := auth.NewUserEmail(claims.Email)
+	if err != nil {
+		return auth.ExternalIdentity{}, fmt.Errorf("normalize email: %w", err)
+	}
+	identity := auth.ExternalIdentity{
+		Provider:      p.id,
+		Subject:       claims.Subject,
+		Email:         email,
+		EmailVerified: claims.EmailVerified,
+		Profile: auth.Profile{
+			Name:         claims.Name,
+			GivenName:    claims.GivenName,
+			FamilyName:   claims.FamilyName,
+			Picture:      claims.Picture,
+			Locale:       claims.Locale,
+			HostedDomain: claims.HostedDomain,
+		},
+	}
+	if p.offline {
+		vaulted := providerToken(token)
+		identity.Token = &vaulted
+	}
+	return identity, nil
+}
+
+// RefreshToken redeems a stored refresh token for a new access token.
+func (p *oidcIdentityProvider) RefreshToken(ctx context.Context, refreshToken string) (auth.ProviderToken, error) {
+	token, err := p.rp.Refresh(ctx, refreshToken)
+	if err != nil {
+		return auth.ProviderToken{}, err
+	}
+	return providerToken(token), nil
+}
+
+// providerToken converts an oauth2 token, reading the granted scopes from the
+// space-delimited scope field of the token response.
+func providerToken(token *oauth2.Token) auth.ProviderToken {
+	scope, _ := token.Extra("scope").(string)
+	return auth.ProviderToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.Type(),
+		Expiry:       token.Expiry,
+		Scopes:       strings.Fields(scope),
+	}
+}
+
+// githubIdentityProvider adapts the GitHub OAuth client to the login flow.
+// GitHub does not issue ID tokens, so the request nonce is unused.
+type githubIdentityProvider struct {
+	client *github.Client
+}
+
+func newGitHubIdentityProvider(client *github.Client) *githubIdentityProvider {
+	return &githubIdentityProvider{client: client}
+}
+
+func (p *githubIdentityProvider) ID() string          { return auth.ProviderGitHub }
+func (p *githubIdentityProvider) DisplayName() string { return "GitHub" }
+
+func (p *githubIdentityProvider) Begin(_ context.Context, req auth.AuthorizationRequest) (string, error) {
+	return p.client.AuthCodeURL(req.State, oauth2.S256ChallengeOption(req.Verifier)), nil
+}
+
+func (p *githubIdentityProvider) Complete(ctx context.Context, code string, req auth.AuthorizationRequest) (auth.ExternalIdentity, error) {
+	token, err := p.client.Exchange(ctx, code, oauth2.VerifierOption(req.Verifier))
+	if err != nil {
+		return auth.ExternalIdentity{}, err
+	}
+	identity, err := p.client.FetchIdentity(ctx, token)
+	if err != nil {
+		if errors.Is(err, github.ErrNoVerifiedEmail) {
+			return auth.ExternalIdentity{}, fmt.Errorf("%w: %v", auth.ErrEmailUnverified, err)
+		}
+		return auth.ExternalIdentity{}, err
+	}
+	email, err := auth.NewUserEmail(identity.Email)
+	if err != nil {
+		return auth.ExternalIdentity{}, fmt.Errorf("normalize email: %w", err)
+	}
+	return auth.ExternalIdentity{
+		Provider:      auth.ProviderGitHub,
+		Subject:       identity.Subject,
+		Email:         email,
+		EmailVerified: identity.EmailVerified,
+		Profile: auth.Profile{
+			Name:     identity.Name,
+			Nickname: identity.Login,
+			Picture:  identity.AvatarURL,
+		},
+	}, nil
+}
+
+// errAssertionRequired is returned when a SAML connection is driven through the
+// authorization-code callback instead of the assertion consumer service.
+var errAssertionRequired = errors.New("saml connections complete at the assertion consumer service")
+
+// samlIdentityProvider adapts a SAML service provider to the login flow. The
+// IdP answers by posting to the assertion consumer service, so sign-in
+// finishes in ConsumeAssertion rather than Complete.
+type samlIdentityProvider struct {
+	id          string
+	displayName string
+	sp          *saml.ServiceProvider
+	attributes  config.SAMLAttributeMapping
+	// domains lists the email domains the IdP is trusted to assert.
+	domains []string
+}
+
+// samlRequestIDPrefix turns a flow state, which may start with a digit, into
+// the xs:ID of the AuthnRequest. The ACS strips it to find the flow again.
+const samlRequestIDPrefix = "_"
+
+func newSAMLIdentityProvider(conn config.SAMLConnectionConfig) (*samlIdentityProvider, error) {
+	rawMetadata, err := os.ReadFile(conn.IDPMetadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("read idp metadata: %w", err)
+	}
+	idp, err := saml.ParseIDPMetadata(rawMetadata)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := os.ReadFile(conn.CertificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(conn.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	cert, key, err := saml.ParseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := saml.New(saml.Config{
+		EntityID:          conn.EntityID,
+		ACSURL:            conn.ACSURL,
+		Certificate:       cert,
+		Key:               key,
+		IDP:               idp,
+		AllowIDPInitiated: conn.AllowIDPInitiated,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &samlIdentityProvider{id: conn.ID, displayName: conn.Name, sp: sp, attributes: conn.Attributes, domains: conn.Domains}, nil
+}
+
+func (p *samlIdentityProvider) ID() string          { return p.id }
+func (p *samlIdentityProvider) DisplayName() string { return p.displayName }
+
+// Begin returns the IdP URL carrying a signed AuthnRequest whose ID is the
+// flow state, so only the browser holding that flow can complete the sign-in.
+// The return-to destination stays in the flow.
+func (p *samlIdentityProvider) Begin(ctx context.Context, req auth.AuthorizationRequest) (string, error) {
+	return p.sp.AuthnRequestURL(ctx, samlRequestIDPrefix+req.State, "")
+}
+
+func (p *samlIdentityProvider) Complete(context.Context, string, auth.AuthorizationRequest) (auth.ExternalIdentity, error) {
+	return auth.ExternalIdentity{}, errAssertionRequired
+}
+
+// ConsumeAssertion validates a posted SAMLResponse and maps its NameID and
+// attributes onto an external identity. It also returns the state of the flow
+// the response answers, empty for IdP-initiated sign-ins.
+func (p *samlIdentityProvider) ConsumeAssertion(ctx context.Context, samlResponse string) (auth.ExternalIdentity, string, error) {
+	assertion, err := p.sp.ParseResponse(ctx, samlResponse)
+	if err != nil {
+		return auth.ExternalIdentity{}, "", err
+	}
+
+	rawEmail := p.attribute(assertion, p.attributes.Email)
+	if rawEmail == "" && assertion.NameIDFormat == saml.NameIDFormatEmail {
+		rawEmail = assertion.NameID
+	}
+	if rawEmail == "" {
+		return auth.ExternalIdentity{}, "", fmt.Errorf("%w: assertion carries no email", auth.ErrEmailUnverified)
+	}
+	email, err := auth.NewUserEmail(rawEmail)
+	if err != nil {
+		return auth.ExternalIdentity{}, "", fmt.Errorf("normalize email: %w", err)
+	}
+	// The IdP does not flag verification itself. The administrator vouches
+	// for the connection's domains only, and any IdP can assert any email, so
+	// emails outside them are refused rather than left unverified.
+	if !slices.Contains(p.domains, email.Domain()) {
+		return auth.ExternalIdentity{}, "", fmt.Errorf("%w: domain %s is not allowed for this connection", auth.ErrEmailUnverified, email.Domain())
+	}
+
+	identity := auth.ExternalIdentity{
+		Provider:      p.id,
+		Subject:       assertion.NameID,
+		Email:         email,
+		EmailVerified: true,
+		Profile: auth.Profile{
+			Name:       p.attribute(assertion, p.attributes.Name),
+			GivenName:  p.attribute(assertion, p.attributes.GivenName),
+			FamilyName: p.attribute(assertion, p.attributes.FamilyName),
+		},
+	}
+	return identity, strings.TrimPrefix(assertion.InResponseTo, samlRequestIDPrefix), nil
+}
+
+// Metadata returns the service provider metadata to register with the IdP.
+func (p *samlIdentityProvider) Metadata() ([]byte, error) {
+	return p.sp.Metadata()
+}
+
+func (p *samlIdentityProvider) attribute(assertion saml.Assertion, name string) string {
+	if name == "" {
+		return ""
+	}
+	return assertion.Attribute(name)
+}
+
+// isSAMLProvider reports whether provider completes sign-in through the
+// assertion consumer service.
+func isSAMLProvider(provider auth.IdentityProvider) bool {
+	_, ok := provider.(*samlIdentityProvider)
+	return ok
+}
@@ -3,14 +3,32 @@ package server
 import (
 	"context"
 	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/rjnemo/auth/internal/service/auth"
 )
 
-type sessionContextKey struct{}
+type (
+	sessionContextKey       struct{}
+	personalTokenContextKey struct{}
+)
 
 func (s *Server) sessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests authenticated by a personal access token carry no cookie
+		// session and must not be handed one.
+		if _, ok := personalTokenFromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
 		logger := s.logger.With(slog.String("component", "session"))
 
 		state := s.sessions.Load(r)
@@ -31,6 +49,95 @@ func (s *Server) sessionMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// personalTokenRoutes lists the paths a personal access token may call.
+// Consent, device approval, identity and token management need the user at
+// the keyboard, so they only accept the cookie session.
+var personalTokenRoutes = []string{
+	"/dashboard",
+	apiPrefix + "/me",
+}
+
+// personalTokenMiddleware authenticates requests bearing a personal access
+// token as an alternative to the cookie session: the request proceeds with an
+// authenticated session for the token's owner that is never saved. Only
+// personalTokenRoutes accept tokens. Safe methods need the read scope and
+// everything else the write scope. Other bearer tokens, such as OAuth access
+// tokens, pass through untouched.
+func (s *Server) personalTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := bearerToken(r)
+		if !ok || !auth.IsPersonalToken(raw) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := s.logger.With(slog.String("component", "personal_tokens"))
+
+		if !slices.Contains(personalTokenRoutes, r.URL.Path) {
+			logger.Warn("personal access token used outside its routes", slog.String("path", r.URL.Path))
+			http.Error(w, "personal access tokens are not accepted here", http.StatusForbidden)
+			return
+		}
+
+		account, token, err := s.authService.AuthenticatePersonalToken(r.Context(), raw, clientIP(r))
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			logger.Warn("personal access token rejected", slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid personal access token", http.StatusUnauthorized)
+			return
+		case err != nil:
+			logger.Error("personal access token lookup failed", slog.Any("error", err))
+			http.Error(w, "unexpected error", http.StatusInternalServerError)
+			return
+		}
+
+		scope := auth.PersonalTokenScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = auth.PersonalTokenScopeRead
+		}
+		if !token.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(w, "personal access token lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		state := SessionState{Authenticated: true, Email: account.Email.String(), UserID: account.ID}
+		ctx := context.WithValue(withSession(r.Context(), state), personalTokenContextKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// personalTokenFromContext returns the personal access token that
+// authenticated the request, if any.
+func personalTokenFromContext(ctx context.Context) (auth.PersonalToken, bool) {
+	token, ok := ctx.Value(personalTokenContextKey{}).(auth.PersonalToken)
+	return token, ok
+}
+
+// clientIP returns the address the request came from, after RealIP has
+// applied any proxy headers.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// csrfRejectReason is logged whenever a mutating request fails CSRF checks.
+type csrfRejectReason string
+
+const (
+	csrfReasonCrossSiteFetch  csrfRejectReason = "fetch_site_cross_site"
+	csrfReasonUntrustedOrigin csrfRejectReason = "origin_untrusted"
+	csrfReasonUntrustedRefer  csrfRejectReason = "referer_untrusted"
+	csrfReasonSessionMissing  csrfRejectReason = "session_token_missing"
+	csrfReasonTokenMissing    csrfRejectReason = "request_token_missing"
+	csrfReasonTokenInvalid    csrfRejectReason = "request_token_invalid"
+
+	csrfFormMaxBytes = 1 << 20
+)
+
 func (s *Server) csrfMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -39,21 +146,61 @@ func (s *Server) csrfMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Browsers never attach Authorization headers on their own, so a
+		// request the token middleware authenticated cannot be forged
+		// cross-site. Any other bearer header proves nothing about the cookie
+		// session the request also carries.
+		if _, ok := personalTokenFromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reject := func(reason csrfRejectReason, message string) {
+			s.logger.With(slog.String("component", "csrf")).Warn("csrf rejected",
+				slog.String("reason", string(reason)),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("origin", r.Header.Get("Origin")),
+				slog.String("sec_fetch_site", r.Header.Get("Sec-Fetch-Site")),
+			)
+			http.Error(w, message, http.StatusForbidden)
+		}
+
+		if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+			reject(csrfReasonCrossSiteFetch, "cross-site request rejected")
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !s.trustedOrigin(r, origin) {
+				reject(csrfReasonUntrustedOrigin, "untrusted origin")
+				return
+			}
+		} else if referer := r.Header.Get("Referer"); referer != "" {
+			if !s.trustedOrigin(r, referer) {
+				reject(csrfReasonUntrustedRefer, "untrusted referer")
+				return
+			}
+		}
+
 		state := sessionFromContext(r.Context())
 		if state.CSRFToken == "" {
-			http.Error(w, "missing csrf token", http.StatusForbidden)
+			reject(csrfReasonSessionMissing, "missing csrf token")
 			return
 		}
 
 		token := r.Header.Get("X-CSRF-Token")
+		if token == "" && isFormSubmission(r) {
+			r.Body = http.MaxBytesReader(w, r.Body, csrfFormMaxBytes)
+			token = r.PostFormValue("_csrf")
+		}
 		if token == "" {
-			if err := r.ParseForm(); err == nil {
-				token = r.Form.Get("_csrf")
-			}
+			reject(csrfReasonTokenMissing, "missing csrf token")
+			return
 		}
 
 		if !validCSRFToken(token, state.CSRFToken) {
-			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			reject(csrfReasonTokenInvalid, "invalid csrf token")
 			return
 		}
 
@@ -61,6 +208,35 @@ func (s *Server) csrfMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// trustedOrigin reports whether the origin of rawURL is the server itself or
+// one of the configured trusted origins.
+func (s *Server) trustedOrigin(r *http.Request, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	return slices.Contains(s.configuration.TrustedOrigins, origin)
+}
+
+// bearerToken extracts the token from an Authorization: Bearer header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	return token, ok && strings.EqualFold(scheme, "Bearer") && token != ""
+}
+
+func isFormSubmission(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
 func withSession(ctx context.Context, state SessionState) context.Context {
 	return context.WithValue(ctx, sessionContextKey{}, state)
 }
@@ -79,8 +255,13 @@ func validCSRFToken(provided, expected string) bool {
 	if provided == "" || expected == "" {
 		return false
 	}
-	if len(provided) != len(expected) {
+	want, err := base64.RawURLEncoding.DecodeString(expected)
+	if err != nil {
+		return false
+	}
+	got, ok := unmaskCSRFToken(provided)
+	if !ok || len(got) != len(want) {
 		return false
 	}
-	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+	return subtle.ConstantTimeCompare(got, want) == 1
 }
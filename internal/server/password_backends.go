@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rjnemo/auth/internal/config"
+	"github.com/rjnemo/auth/internal/driver/ldap"
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+// ldapPasswordBackend adapts a directory connection to password logins for
+// the email domains it is routed.
+type ldapPasswordBackend struct {
+	id            string
+	displayName   string
+	authenticator *ldap.Authenticator
+}
+
+func newLDAPPasswordBackend(conn config.LDAPConnectionConfig) (*ldapPasswordBackend, error) {
+	var tlsConfig *tls.Config
+	if conn.CACertPath != "" {
+		caPEM, err := os.ReadFile(conn.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read ca certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("ca certificate %s holds no PEM certificates", conn.CACertPath)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	var bindPassword string
+	if conn.BindPasswordPath != "" {
+		raw, err := os.ReadFile(conn.BindPasswordPath)
+		if err != nil {
+			return nil, fmt.Errorf("read bind password: %w", err)
+		}
+		bindPassword = strings.TrimRight(string(raw), "\r\n")
+	}
+
+	authenticator, err := ldap.New(ldap.Config{
+		URL:            conn.URL,
+		StartTLS:       conn.StartTLS,
+		TLSConfig:      tlsConfig,
+		BindDN:         conn.BindDN,
+		BindPassword:   bindPassword,
+		UserBaseDN:     conn.UserBaseDN,
+		UserFilter:     conn.UserFilter,
+		GroupBaseDN:    conn.GroupBaseDN,
+		GroupFilter:    conn.GroupFilter,
+		RequiredGroups: conn.RequiredGroups,
+		Attributes: ldap.Attributes{
+			Subject:    conn.Attributes.Subject,
+			Name:       conn.Attributes.Name,
+			GivenName:  conn.Attributes.GivenName,
+			FamilyName: conn.Attributes.FamilyName,
+			MemberOf:   conn.Attributes.MemberOf,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ldapPasswordBackend{id: conn.ID, displayName: conn.Name, authenticator: authenticator}, nil
+}
+
+func (b *ldapPasswordBackend) ID() string { return b.id }
+
+// AuthenticatePassword searches the directory for email and binds as the entry
+// found. The entry, not the email, keys the identity so renames in the
+// directory keep the account.
+func (b *ldapPasswordBackend) AuthenticatePassword(ctx context.Context, email auth.UserEmail, password string) (auth.ExternalIdentity, error) {
+	entry, err := b.authenticator.Authenticate(ctx, email.String(), password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return auth.ExternalIdentity{}, auth.ErrInvalidCredentials
+		}
+		return auth.ExternalIdentity{}, err
+	}
+	return auth.ExternalIdentity{
+		Provider:      b.id,
+		Subject:       entry.Subject,
+		Email:         email,
+		EmailVerified: true,
+		Profile: auth.Profile{
+			Name:       entry.Name,
+			GivenName:  entry.GivenName,
+			FamilyName: entry.FamilyName,
+			Groups:     entry.Groups,
+		},
+	}, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultReturnTo   = "/dashboard"
+	maxReturnToLength = 2048
+)
+
+// safeReturnTo validates raw as a post-login destination and returns it in
+// normalised form, or "" when it could lead off-site. Local absolute paths are
+// allowed, as are absolute http(s) URLs on a trusted origin.
+func (s *Server) safeReturnTo(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxReturnToLength {
+		return ""
+	}
+	// Browsers treat a backslash as a slash and drop tabs and newlines, so
+	// "/\evil.example" and "/\t/evil.example" are protocol-relative in practice.
+	if strings.ContainsFunc(raw, func(r rune) bool { return r == '\\' || r < 0x20 || r == 0x7f }) {
+		return ""
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.User != nil || u.Opaque != "" {
+		return ""
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") {
+			return ""
+		}
+		return u.String()
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return ""
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	if !slices.Contains(s.configuration.TrustedOrigins, origin) {
+		return ""
+	}
+	return u.String()
+}
+
+// requestedReturnTo reads the return_to (or legacy next) query parameter.
+func (s *Server) requestedReturnTo(r *http.Request) string {
+	q := r.URL.Query()
+	return s.safeReturnTo(cmp.Or(q.Get("return_to"), q.Get("next")))
+}
+
+// rememberReturnTo records a requested destination on the session so it
+// survives the login form and provider round trips. It reports whether the
+// session changed.
+func (s *Server) rememberReturnTo(r *http.Request, state *SessionState) bool {
+	target := s.requestedReturnTo(r)
+	if target == "" || target == state.ReturnTo {
+		return false
+	}
+	state.ReturnTo = target
+	return true
+}
+
+// takeReturnTo clears the remembered destination and returns it, falling back
+// to the dashboard. The value is re-validated in case the allow-list changed
+// since it was stored.
+func (s *Server) takeReturnTo(state *SessionState) string {
+	target := s.safeReturnTo(state.ReturnTo)
+	state.ReturnTo = ""
+	return cmp.Or(target, defaultReturnTo)
+}
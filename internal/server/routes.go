@@ -5,17 +5,37 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/rjnemo/auth/internal/service/oauth"
 )
 
 func (s *Server) registerRoutes(r chi.Router) {
 	r.Get("/", s.loginPageHandler())
 	r.Post("/login", s.loginHandler())
-	r.Get("/login/google", s.googleLoginHandler())
-	r.Get("/login/google/callback", s.googleCallbackHandler())
+	r.Get("/login/{provider}", s.providerLoginHandler())
+	r.Get("/login/{provider}/callback", s.providerCallbackHandler())
 	r.Post("/logout", s.logoutHandler())
 	r.Get("/signup", s.signupPageHandler())
 	r.Post("/signup", s.signupHandler())
 	r.Get("/dashboard", s.dashboardPageHandler())
+	r.Post("/account/identities/{provider}", s.linkIdentityHandler())
+	r.Post("/account/identities/{provider}/unlink", s.unlinkIdentityHandler())
+	r.Post("/account/identities/{provider}/scopes", s.requestScopesHandler())
+	r.Get(personalTokensPath, s.personalTokensPageHandler())
+	r.Post(personalTokensPath, s.createPersonalTokenHandler())
+	r.Post(personalTokensPath+"/{tokenID}/revoke", s.revokePersonalTokenHandler())
+	r.Get("/saml/{provider}/metadata", s.samlMetadataHandler())
+	r.Get("/saml/{provider}/acs", s.samlLoginHandler())
+
+	if s.authorizationServer != nil {
+		r.Get(oauth.AuthorizePath, s.authorizeHandler())
+		r.Post(oauth.AuthorizePath, s.authorizeDecisionHandler())
+		r.Get(oauth.DeviceVerificationPath, s.deviceVerificationHandler())
+		r.Post(oauth.DeviceVerificationPath, s.deviceDecisionHandler())
+		r.Post(applicationsPath+"/{clientID}/revoke", s.revokeApplicationHandler())
+		r.Get(oauth.DiscoveryPath, s.discoveryHandler())
+		r.Get(oauth.JWKSPath, s.jwksHandler())
+	}
 }
 
 // Router returns the configured HTTP router.
@@ -27,11 +47,58 @@ func (s *Server) Router() http.Handler {
 		middleware.RealIP,
 		middleware.Logger,
 		middleware.Recoverer,
+		s.personalTokenMiddleware,
 		s.sessionMiddleware,
-		s.csrfMiddleware,
 	)
 
-	s.registerRoutes(r)
+	r.Group(func(r chi.Router) {
+		r.Use(s.csrfMiddleware)
+		s.registerRoutes(r)
+	})
+	// The IdP posts assertions cross-site without a CSRF token; the signed
+	// assertion, bound to this service provider, stands in for one. The
+	// sign-in itself completes on the same-site redirect that follows.
+	r.Post("/saml/{provider}/acs", s.samlACSHandler())
+	// Proxies replay the method of the request they are checking. The check
+	// changes nothing, so it answers every method without a CSRF token.
+	r.HandleFunc(forwardAuthPath, s.forwardAuthHandler())
+	// The JSON API replaces CSRF tokens with CORS: cross-origin writes are
+	// only accepted from allow-listed origins.
+	r.Route(apiPrefix, s.registerAPIRoutes)
+	r.With(s.apiCORS).Get(openAPIPath, s.openAPIHandler())
+	// Directories provision from their back ends with a tenant's bearer
+	// token, which every SCIM request must carry.
+	if s.scim != nil {
+		r.Route(scimPrefix, s.registerSCIMRoutes)
+	}
+	// Userinfo and the client management API authenticate by bearer token
+	// alone, which browsers never attach on their own, so CSRF cannot apply.
+	if s.authorizationServer != nil {
+		r.Get(oauth.UserInfoPath, s.userInfoHandler())
+		r.Post(oauth.UserInfoPath, s.userInfoHandler())
+	}
+	if s.authorizationServer != nil && s.configuration.AuthorizationServer.AdminToken != "" {
+		r.Route(adminClientsPath, func(r chi.Router) {
+			r.Use(s.requireAdminToken)
+			r.Get("/", s.listClientsHandler())
+			r.Post("/", s.createClientHandler())
+			r.Get("/{clientID}", s.getClientHandler())
+			r.Put("/{clientID}", s.updateClientHandler())
+			r.Delete("/{clientID}", s.deleteClientHandler())
+			r.Post("/{clientID}/secrets", s.rotateClientSecretHandler())
+			r.Get("/{clientID}/revocations", s.listRevocationsHandler())
+			r.Get("/{clientID}/issuances", s.listIssuancesHandler())
+		})
+	}
+	// Token, device authorization, introspection and revocation requests come
+	// from client back ends, devices and resource servers, which authenticate
+	// with their own credentials instead of a session.
+	if s.authorizationServer != nil {
+		r.Post(oauth.TokenPath, s.tokenHandler())
+		r.Post(oauth.DeviceAuthorizationPath, s.deviceAuthorizationHandler())
+		r.Post(oauth.IntrospectionPath, s.introspectionHandler())
+		r.Post(oauth.RevocationPath, s.revocationHandler())
+	}
 
 	return r
 }
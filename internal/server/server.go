@@ -11,9 +11,9 @@ import (
 	"github.com/rjnemo/auth/internal/config"
 	"github.com/rjnemo/auth/internal/driver/logging"
 	"github.com/rjnemo/auth/internal/service/auth"
+	"github.com/rjnemo/auth/internal/service/oauth"
+	"github.com/rjnemo/auth/internal/service/scim"
 	"github.com/rjnemo/auth/web"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 const (
@@ -28,7 +28,14 @@ type Server struct {
 	sessions      *SessionStore
 	logger        *slog.Logger
 	configuration config.Config
-	googleOAuth   *oauth2.Config
+	providers     *auth.ProviderRegistry
+	// authorizationServer is nil unless EnableAuthorizationServer was called.
+	authorizationServer *oauth.Service
+	// scim is nil unless EnableSCIM was called.
+	scim *scim.Service
+	// directoryNames labels the password backends, which are not in the
+	// provider registry, by provider ID.
+	directoryNames map[string]string
 }
 
 // New constructs a Server with parsed templates and default state using the provided service.
@@ -47,13 +54,17 @@ func New(cfg config.Config, authService *auth.Service, logger *slog.Logger) (*Se
 		"templates/login.html",
 		"templates/dashboard.html",
 		"templates/signup.html",
+		"templates/providers.html",
 		"templates/unauthorized.html",
+		"templates/consent.html",
+		"templates/device.html",
+		"templates/tokens.html",
 	)
 	if err != nil {
 		return nil, fmt.Errorf("parse templates: %w", err)
 	}
 
-	sessionStore, err := NewSessionStore(cfg.SessionSecret)
+	sessionStore, err := NewSessionStore(cfg.SessionSecret, cfg.CookieDomain)
 	if err != nil {
 		return nil, fmt.Errorf("session store: %w", err)
 	}
@@ -63,27 +74,57 @@ func New(cfg config.Config, authService *auth.Service, logger *slog.Logger) (*Se
 	}
 	logger = logger.With(slog.String("service", "http"))
 
-	var googleOAuthConfig *oauth2.Config
-	if cfg.GoogleOAuth.Enabled() {
-		googleOAuthConfig = &oauth2.Config{
-			ClientID:     cfg.GoogleOAuth.ClientID,
-			ClientSecret: cfg.GoogleOAuth.ClientSecret,
-			RedirectURL:  cfg.GoogleOAuth.RedirectURL,
-			Scopes: []string{
-				"https://www.googleapis.com/auth/userinfo.email",
-				"https://www.googleapis.com/auth/userinfo.profile",
-			},
-			Endpoint: google.Endpoint,
+	providers, err := newProviderRegistry(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TokenEncryptionKey != nil {
+		cipher, err := auth.NewTokenCipher(cfg.TokenEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("token cipher: %w", err)
+		}
+		authService.EnableTokenVault(cipher, providers)
+	}
+
+	// Tenants provision only in their domains, and logins through a
+	// tenant's connection may claim the accounts it provisioned.
+	for _, tenant := range cfg.SCIMTenants {
+		authService.ManageDirectoryDomains(tenant.ID, tenant.Domains)
+		if tenant.Connection == "" {
+			continue
+		}
+		if _, ok := providers.Lookup(tenant.Connection); !ok {
+			return nil, fmt.Errorf("scim tenant %q: unknown connection %q", tenant.ID, tenant.Connection)
+		}
+		authService.TrustDirectory(tenant.ID, tenant.Connection)
+	}
+
+	// Directories check passwords for their email domains ahead of the local
+	// password table.
+	directoryNames := make(map[string]string, len(cfg.LDAP))
+	for _, conn := range cfg.LDAP {
+		if _, ok := providers.Lookup(conn.ID); ok || conn.ID == auth.ProviderPassword {
+			return nil, fmt.Errorf("ldap connection %q: id is already used by a login provider", conn.ID)
+		}
+		backend, err := newLDAPPasswordBackend(conn)
+		if err != nil {
+			return nil, fmt.Errorf("ldap connection %q: %w", conn.ID, err)
+		}
+		for _, domain := range conn.Domains {
+			authService.RoutePasswordDomain(domain, backend)
 		}
+		directoryNames[conn.ID] = conn.Name
 	}
 
 	return &Server{
-		templates:     tmpl,
-		authService:   authService,
-		sessions:      sessionStore,
-		logger:        logger,
-		configuration: cfg,
-		googleOAuth:   googleOAuthConfig,
+		templates:      tmpl,
+		authService:    authService,
+		sessions:       sessionStore,
+		logger:         logger,
+		configuration:  cfg,
+		providers:      providers,
+		directoryNames: directoryNames,
 	}, nil
 }
 
@@ -2,16 +2,52 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/oauth2"
+
+	"github.com/rjnemo/auth/api"
+	"github.com/rjnemo/auth/api/client"
+	"github.com/rjnemo/auth/identity"
 	"github.com/rjnemo/auth/internal/config"
+	"github.com/rjnemo/auth/internal/driver/github"
+	"github.com/rjnemo/auth/internal/driver/github/githubtest"
+	"github.com/rjnemo/auth/internal/driver/ldap/ldaptest"
 	"github.com/rjnemo/auth/internal/driver/logging"
+	"github.com/rjnemo/auth/internal/driver/oidc"
+	"github.com/rjnemo/auth/internal/driver/oidc/oidctest"
+	"github.com/rjnemo/auth/internal/driver/saml"
+	"github.com/rjnemo/auth/internal/driver/saml/samltest"
 	"github.com/rjnemo/auth/internal/service/auth"
+	"github.com/rjnemo/auth/internal/service/oauth"
+	"github.com/rjnemo/auth/internal/service/scim"
+	"github.com/rjnemo/auth/internal/service/signing"
 )
 
 func newTestServer(t *testing.T) *Server {
@@ -36,21 +72,26 @@ func newTestServer(t *testing.T) *Server {
 	return srv
 }
 
-func newGoogleTestServer(t *testing.T) *Server {
+func newGoogleTestServer(t *testing.T, configure ...func(*config.Config)) (*Server, *oidctest.Issuer) {
 	t.Helper()
 
+	issuer := oidctest.NewIssuer(t, "client")
 	cfg := config.Config{
 		ListenAddr:    ":0",
 		LogMode:       logging.ModeText,
 		Environment:   "test",
 		SessionSecret: bytes.Repeat([]byte("g"), 32),
 		GoogleOAuth: config.GoogleOAuthConfig{
+			Issuer:       issuer.URL(),
 			ClientID:     "client",
 			ClientSecret: "secret",
 			RedirectURL:  "http://localhost/login/google/callback",
 		},
 		DatabaseURL: "postgres://localhost/auth_test?sslmode=disable",
 	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
 
 	logger := logging.New(io.Discard, logging.ModeText, nil)
 
@@ -60,13 +101,52 @@ func newGoogleTestServer(t *testing.T) *Server {
 	if err != nil {
 		t.Fatalf("new google server: %v", err)
 	}
-	return srv
+	return srv, issuer
+}
+
+func newGitHubTestServer(t *testing.T) (*Server, *githubtest.Server) {
+	t.Helper()
+
+	fake := githubtest.NewServer(t)
+	srv := newTestServer(t)
+	client, err := github.New(github.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/login/github/callback",
+		Endpoint:     fake.Endpoint(),
+		APIBaseURL:   fake.URL(),
+	})
+	if err != nil {
+		t.Fatalf("new github client: %v", err)
+	}
+	srv.providers, err = auth.NewProviderRegistry(newGitHubIdentityProvider(client))
+	if err != nil {
+		t.Fatalf("new provider registry: %v", err)
+	}
+	return srv, fake
 }
 
 func attachSession(req *http.Request, state SessionState) *http.Request {
 	return req.WithContext(withSession(req.Context(), state))
 }
 
+// signedInAs returns a session signed in as the account registered for email.
+func signedInAs(t *testing.T, srv *Server, email string) SessionState {
+	t.Helper()
+	account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail(email))
+	if err != nil {
+		t.Fatalf("lookup %s: %v", email, err)
+	}
+	return SessionState{Authenticated: true, Email: email, UserID: account.ID, CSRFToken: "csrf"}
+}
+
+// withProvider sets the {provider} route parameter for handlers called directly.
+func withProvider(req *http.Request, provider string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("provider", provider)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
 func TestLoginPageHandler(t *testing.T) {
 	t.Parallel()
 
@@ -89,7 +169,7 @@ func TestLoginPageHandler(t *testing.T) {
 func TestLoginPageHandlerIncludesGoogleLinkWhenConfigured(t *testing.T) {
 	t.Parallel()
 
-	srv := newGoogleTestServer(t)
+	srv, _ := newGoogleTestServer(t)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req = attachSession(req, SessionState{CSRFToken: "token"})
@@ -106,6 +186,38 @@ func TestLoginPageHandlerIncludesGoogleLinkWhenConfigured(t *testing.T) {
 	}
 }
 
+func TestLoginPageListsRegisteredProviders(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newGitHubTestServer(t)
+
+	req := attachSession(httptest.NewRequest(http.MethodGet, "/", nil), SessionState{CSRFToken: "token"})
+	rr := httptest.NewRecorder()
+	srv.loginPageHandler()(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `action="/login/github"`) || !strings.Contains(body, "Continue with GitHub") {
+		t.Fatalf("expected github login button, got %q", body)
+	}
+	if strings.Contains(body, "google_login_form") {
+		t.Fatal("expected unregistered providers to be omitted")
+	}
+}
+
+func TestProviderRoutesUnknownProvider(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+
+	for _, path := range []string{"/login/unknown", "/login/unknown/callback?state=x&code=y"} {
+		rr := httptest.NewRecorder()
+		srv.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+}
+
 func TestLoginHandlerSuccess(t *testing.T) {
 	t.Parallel()
 
@@ -139,6 +251,9 @@ func TestLoginHandlerSuccess(t *testing.T) {
 	if !foundSession {
 		t.Fatal("expected session cookie to be set")
 	}
+	if state := sessionFromResponse(t, srv, res); !state.Authenticated || state.CSRFToken == "" || state.CSRFToken == "csrf-token" {
+		t.Fatalf("expected a fresh CSRF token on sign-in, got %+v", state)
+	}
 }
 
 func TestLoginHandlerInvalidCredentials(t *testing.T) {
@@ -174,7 +289,7 @@ func TestGoogleLoginHandlerDisabled(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/login/google", nil)
 	rr := httptest.NewRecorder()
 
-	srv.googleLoginHandler()(rr, req)
+	srv.providerLoginHandler()(rr, withProvider(req, auth.ProviderGoogle))
 
 	if rr.Code != http.StatusNotFound {
 		t.Fatalf("expected 404 when google oauth disabled, got %d", rr.Code)
@@ -184,13 +299,13 @@ func TestGoogleLoginHandlerDisabled(t *testing.T) {
 func TestGoogleLoginHandlerRedirects(t *testing.T) {
 	t.Parallel()
 
-	srv := newGoogleTestServer(t)
+	srv, issuer := newGoogleTestServer(t)
 
 	req := httptest.NewRequest(http.MethodGet, "/login/google", nil)
 	req = attachSession(req, SessionState{CSRFToken: "csrf"})
 	rr := httptest.NewRecorder()
 
-	srv.googleLoginHandler()(rr, req)
+	srv.providerLoginHandler()(rr, withProvider(req, auth.ProviderGoogle))
 
 	res := rr.Result()
 	if res.StatusCode != http.StatusFound {
@@ -200,8 +315,8 @@ func TestGoogleLoginHandlerRedirects(t *testing.T) {
 	if location == "" {
 		t.Fatal("expected redirect location header")
 	}
-	if !strings.Contains(location, "accounts.google.com") {
-		t.Fatalf("expected google authorization URL, got %q", location)
+	if !strings.HasPrefix(location, issuer.URL()+"/authorize?") {
+		t.Fatalf("expected discovered authorization URL, got %q", location)
 	}
 
 	parsed, err := url.Parse(location)
@@ -228,24 +343,91 @@ func TestGoogleLoginHandlerRedirects(t *testing.T) {
 	if err != nil {
 		t.Fatalf("decode session: %v", err)
 	}
-	if savedState.OAuthState == "" {
-		t.Fatal("expected oauth state stored in session")
+	if len(savedState.OAuthFlows) != 1 {
+		t.Fatalf("expected one pending oauth flow, got %d", len(savedState.OAuthFlows))
+	}
+	flow := savedState.OAuthFlows[0]
+	if flow.State != stateParam {
+		t.Fatalf("expected oauth state %q to match redirect param %q", flow.State, stateParam)
+	}
+	if nonce := parsed.Query().Get("nonce"); nonce == "" || flow.Nonce != nonce {
+		t.Fatalf("expected oidc nonce %q to be stored in session, got %q", nonce, flow.Nonce)
+	}
+	if flow.Provider != auth.ProviderGoogle {
+		t.Fatalf("expected flow bound to google, got %q", flow.Provider)
+	}
+	if parsed.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 code challenge, got %q", parsed.Query().Get("code_challenge_method"))
+	}
+	if got, want := parsed.Query().Get("code_challenge"), oauth2.S256ChallengeFromVerifier(flow.Verifier); got != want {
+		t.Fatalf("expected code challenge %q derived from stored verifier, got %q", want, got)
+	}
+	if remaining := time.Until(flow.ExpiresAt); remaining <= 0 || remaining > oauthFlowLifetime {
+		t.Fatalf("expected flow to expire within %s, got %s", oauthFlowLifetime, remaining)
+	}
+}
+
+func TestGoogleLoginHandlerConcurrentFlows(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newGoogleTestServer(t)
+
+	state := SessionState{CSRFToken: "csrf"}
+	for range maxPendingOAuthFlows + 2 {
+		req := attachSession(httptest.NewRequest(http.MethodGet, "/login/google", nil), state)
+		rr := httptest.NewRecorder()
+		srv.providerLoginHandler()(rr, withProvider(req, auth.ProviderGoogle))
+		state = sessionFromResponse(t, srv, rr.Result())
+	}
+
+	if len(state.OAuthFlows) != maxPendingOAuthFlows {
+		t.Fatalf("expected %d pending flows, got %d", maxPendingOAuthFlows, len(state.OAuthFlows))
+	}
+	seen := make(map[string]bool)
+	for _, flow := range state.OAuthFlows {
+		if seen[flow.State] {
+			t.Fatalf("expected distinct state per flow, got duplicate %q", flow.State)
+		}
+		seen[flow.State] = true
+	}
+}
+
+func TestSessionOAuthFlows(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	var state SessionState
+	state.addOAuthFlow(OAuthFlow{State: "stale", ExpiresAt: now.Add(-time.Second)}, now.Add(-time.Minute))
+	state.addOAuthFlow(OAuthFlow{State: "tab-1", ExpiresAt: now.Add(time.Minute)}, now)
+	state.addOAuthFlow(OAuthFlow{State: "tab-2", ExpiresAt: now.Add(time.Minute)}, now)
+
+	if _, ok := state.takeOAuthFlow("stale", now); ok {
+		t.Fatal("expected expired flow to be rejected")
+	}
+	if flow, ok := state.takeOAuthFlow("tab-1", now); !ok || flow.State != "tab-1" {
+		t.Fatal("expected tab-1 flow to be returned")
+	}
+	if _, ok := state.takeOAuthFlow("tab-1", now); ok {
+		t.Fatal("expected flow to be single use")
 	}
-	if savedState.OAuthState != stateParam {
-		t.Fatalf("expected oauth state %q to match redirect param %q", savedState.OAuthState, stateParam)
+	if _, ok := state.takeOAuthFlow("tab-2", now); !ok {
+		t.Fatal("expected tab-2 flow to survive tab-1 completing")
+	}
+	if len(state.OAuthFlows) != 0 {
+		t.Fatalf("expected no pending flows, got %v", state.OAuthFlows)
 	}
 }
 
 func TestGoogleCallbackHandlerStateMismatch(t *testing.T) {
 	t.Parallel()
 
-	srv := newGoogleTestServer(t)
+	srv, _ := newGoogleTestServer(t)
 
 	req := httptest.NewRequest(http.MethodGet, "/login/google/callback?state=other&code=ignored", nil)
-	req = attachSession(req, SessionState{OAuthState: "expected", CSRFToken: "csrf"})
+	req = attachSession(req, SessionState{OAuthFlows: []OAuthFlow{pendingFlow("expected")}, CSRFToken: "csrf"})
 	rr := httptest.NewRecorder()
 
-	srv.googleCallbackHandler()(rr, req)
+	srv.providerCallbackHandler()(rr, withProvider(req, auth.ProviderGoogle))
 
 	if rr.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for state mismatch, got %d", rr.Code)
@@ -267,9 +449,111 @@ func TestGoogleCallbackHandlerStateMismatch(t *testing.T) {
 	if err != nil {
 		t.Fatalf("decode session: %v", err)
 	}
-	if savedState.OAuthState != "" {
-		t.Fatal("expected oauth state to be cleared after mismatch")
+	if len(savedState.OAuthFlows) != 1 || savedState.OAuthFlows[0].State != "expected" {
+		t.Fatal("expected unrelated pending flow to survive a mismatched callback")
+	}
+}
+
+func pendingFlow(state string) OAuthFlow {
+	return OAuthFlow{
+		State:     state,
+		Nonce:     "nonce",
+		Verifier:  "verifier-" + state,
+		Provider:  auth.ProviderGoogle,
+		ExpiresAt: time.Now().Add(oauthFlowLifetime),
+	}
+}
+
+func TestGoogleCallbackHandler(t *testing.T) {
+	t.Parallel()
+
+	srv, issuer := newGoogleTestServer(t)
+
+	callbackWith := func(flow OAuthFlow, identity oidctest.Identity, issuedNonce string) (*http.Response, string) {
+		code := issuer.Authorize(identity, issuedNonce)
+		req := httptest.NewRequest(http.MethodGet, "/login/google/callback?state="+flow.State+"&code="+url.QueryEscape(code), nil)
+		req = attachSession(req, SessionState{OAuthFlows: []OAuthFlow{flow}, CSRFToken: "csrf"})
+		rr := httptest.NewRecorder()
+		srv.providerCallbackHandler()(rr, withProvider(req, auth.ProviderGoogle))
+		return rr.Result(), code
+	}
+	callback := func(identity oidctest.Identity, issuedNonce string) *http.Response {
+		res, _ := callbackWith(pendingFlow("expected"), identity, issuedNonce)
+		return res
+	}
+
+	t.Run("success", func(t *testing.T) {
+		res, code := callbackWith(pendingFlow("expected"), oidctest.Identity{Subject: "google-sub", Email: "Google-User@example.com", EmailVerified: true}, "nonce")
+		if res.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected 303, got %d", res.StatusCode)
+		}
+		if loc := res.Header.Get("Location"); loc != "/dashboard" {
+			t.Fatalf("expected redirect to /dashboard, got %q", loc)
+		}
+		saved := sessionFromResponse(t, srv, res)
+		if !saved.Authenticated || saved.Email != "google-user@example.com" {
+			t.Fatalf("expected authenticated session for google user, got %+v", saved)
+		}
+		if len(saved.OAuthFlows) != 0 {
+			t.Fatal("expected completed oauth flow to be removed")
+		}
+		if got := issuer.LastTokenRequest(code)["code_verifier"]; got != "verifier-expected" {
+			t.Fatalf("expected PKCE verifier in token request, got %q", got)
+		}
+	})
+
+	t.Run("expired flow", func(t *testing.T) {
+		flow := pendingFlow("expired")
+		flow.ExpiresAt = time.Now().Add(-time.Second)
+		res, _ := callbackWith(flow, oidctest.Identity{Subject: "google-sub", Email: "google-user@example.com", EmailVerified: true}, "nonce")
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("flow for another provider", func(t *testing.T) {
+		flow := pendingFlow("other")
+		flow.Provider = auth.ProviderOIDC
+		res, _ := callbackWith(flow, oidctest.Identity{Subject: "google-sub", Email: "google-user@example.com", EmailVerified: true}, "nonce")
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		res := callback(oidctest.Identity{Subject: "google-sub", Email: "google-user@example.com", EmailVerified: true}, "replayed")
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+		if saved := sessionFromResponse(t, srv, res); saved.Authenticated {
+			t.Fatal("expected session to remain unauthenticated")
+		}
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		res := callback(oidctest.Identity{Subject: "other-sub", Email: "unverified@example.com"}, "nonce")
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+	})
+}
+
+func sessionFromResponse(t *testing.T, srv *Server, res *http.Response) SessionState {
+	t.Helper()
+
+	// Browsers keep the last cookie set, so read the handler's over the middleware's.
+	cookies := res.Cookies()
+	for i := len(cookies) - 1; i >= 0; i-- {
+		if c := cookies[i]; c.Name == sessionCookieName {
+			state, err := decodeSession(c.Value, srv.configuration.SessionSecret)
+			if err != nil {
+				t.Fatalf("decode session: %v", err)
+			}
+			return state
+		}
 	}
+	t.Fatal("expected session cookie to be set")
+	return SessionState{}
 }
 
 func TestSignupHandlerSuccess(t *testing.T) {
@@ -344,7 +628,7 @@ func TestDashboardPageHandler(t *testing.T) {
 
 	t.Run("authenticated", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
-		req = attachSession(req, SessionState{Authenticated: true, Email: "user@example.com", CSRFToken: "csrf"})
+		req = attachSession(req, signedInAs(t, srv, seedEmail))
 		rr := httptest.NewRecorder()
 		srv.dashboardPageHandler()(rr, req)
 
@@ -357,3 +641,2714 @@ func TestDashboardPageHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestCSRFMiddleware(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	srv.configuration.TrustedOrigins = []string{"https://app.example.com"}
+
+	session, err := ensureCSRFToken(SessionState{})
+	if err != nil {
+		t.Fatalf("generate csrf token: %v", err)
+	}
+
+	handler := srv.csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := map[string]struct {
+		token       string
+		headers     map[string]string
+		contentType string
+		// personalToken marks the request as authenticated by a personal
+		// access token, as personalTokenMiddleware does.
+		personalToken bool
+		want          int
+	}{
+		"masked token":          {token: session.MaskedCSRFToken(), want: http.StatusNoContent},
+		"masked token header":   {headers: map[string]string{"X-CSRF-Token": session.MaskedCSRFToken()}, want: http.StatusNoContent},
+		"unmasked token":        {token: session.CSRFToken, want: http.StatusForbidden},
+		"missing token":         {want: http.StatusForbidden},
+		"same origin":           {token: session.MaskedCSRFToken(), headers: map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, want: http.StatusNoContent},
+		"trusted origin":        {token: session.MaskedCSRFToken(), headers: map[string]string{"Origin": "https://app.example.com", "Sec-Fetch-Site": "same-site"}, want: http.StatusNoContent},
+		"untrusted origin":      {token: session.MaskedCSRFToken(), headers: map[string]string{"Origin": "https://evil.example.net"}, want: http.StatusForbidden},
+		"null origin":           {token: session.MaskedCSRFToken(), headers: map[string]string{"Origin": "null"}, want: http.StatusForbidden},
+		"untrusted referer":     {token: session.MaskedCSRFToken(), headers: map[string]string{"Referer": "https://evil.example.net/form"}, want: http.StatusForbidden},
+		"cross-site fetch":      {token: session.MaskedCSRFToken(), headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusForbidden},
+		"unverified bearer":     {headers: map[string]string{"Authorization": "Bearer api-token"}, want: http.StatusForbidden},
+		"personal token exempt": {headers: map[string]string{"Authorization": "Bearer " + auth.PersonalTokenPrefix + "x"}, personalToken: true, want: http.StatusNoContent},
+		"json body not parsed":  {token: session.MaskedCSRFToken(), contentType: "application/json", want: http.StatusForbidden},
+		"forged mask of secret": {token: "AAAA" + session.CSRFToken, want: http.StatusForbidden},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			form := url.Values{}
+			if tc.token != "" {
+				form.Set("_csrf", tc.token)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			req = attachSession(req, session)
+			if tc.personalToken {
+				req = req.WithContext(context.WithValue(req.Context(), personalTokenContextKey{}, auth.PersonalToken{}))
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d (%s)", tc.want, rr.Code, strings.TrimSpace(rr.Body.String()))
+			}
+		})
+	}
+}
+
+func TestMaskedCSRFTokenVaries(t *testing.T) {
+	t.Parallel()
+
+	session, err := ensureCSRFToken(SessionState{})
+	if err != nil {
+		t.Fatalf("generate csrf token: %v", err)
+	}
+
+	first, second := session.MaskedCSRFToken(), session.MaskedCSRFToken()
+	if first == second {
+		t.Fatal("expected masked tokens to differ between calls")
+	}
+	if strings.Contains(first, session.CSRFToken) {
+		t.Fatal("expected masked token not to embed the session secret")
+	}
+	if !validCSRFToken(first, session.CSRFToken) || !validCSRFToken(second, session.CSRFToken) {
+		t.Fatal("expected both masked tokens to validate")
+	}
+}
+
+func TestLoginHandlerHTMX(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+
+	post := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("email", "user@example.com")
+		form.Set("password", password)
+
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("HX-Request", "true")
+		req = attachSession(req, SessionState{CSRFToken: "csrf-token"})
+
+		rr := httptest.NewRecorder()
+		srv.loginHandler()(rr, req)
+		return rr
+	}
+
+	t.Run("invalid credentials render fragment", func(t *testing.T) {
+		rr := post("Password999")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rr.Code)
+		}
+		body := rr.Body.String()
+		if strings.Contains(body, "<html") {
+			t.Fatalf("expected fragment without page layout, got %q", body)
+		}
+		if !strings.Contains(body, `id="login_form"`) || !strings.Contains(body, "Unable to sign in") {
+			t.Fatalf("expected login form fragment with error, got %q", body)
+		}
+	})
+
+	t.Run("success signals HX-Redirect", func(t *testing.T) {
+		rr := post("Password123")
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", rr.Code)
+		}
+		if got := rr.Header().Get("HX-Redirect"); got != "/dashboard" {
+			t.Fatalf("expected HX-Redirect to /dashboard, got %q", got)
+		}
+		if loc := rr.Header().Get("Location"); loc != "" {
+			t.Fatalf("expected no Location header, got %q", loc)
+		}
+	})
+}
+
+func TestSignupHandlerHTMXDuplicate(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+
+	form := url.Values{}
+	form.Set("email", "user@example.com")
+	form.Set("password", "Password123")
+
+	req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req = attachSession(req, SessionState{CSRFToken: "csrf-token"})
+
+	rr := httptest.NewRecorder()
+	srv.signupHandler()(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if strings.Contains(body, "<html") || !strings.Contains(body, `id="signup_form"`) {
+		t.Fatalf("expected signup form fragment, got %q", body)
+	}
+}
+
+func TestLoginPageInjectsHTMXCSRFHeader(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+
+	session, err := ensureCSRFToken(SessionState{})
+	if err != nil {
+		t.Fatalf("generate csrf token: %v", err)
+	}
+
+	req := attachSession(httptest.NewRequest(http.MethodGet, "/", nil), session)
+	rr := httptest.NewRecorder()
+	srv.loginPageHandler()(rr, req)
+
+	body := rr.Body.String()
+	start := strings.Index(body, `hx-headers='{"X-CSRF-Token": "`)
+	if start < 0 {
+		t.Fatalf("expected hx-headers attribute carrying the csrf token, got %q", body)
+	}
+	token := body[start+len(`hx-headers='{"X-CSRF-Token": "`):]
+	token = token[:strings.Index(token, `"`)]
+	if !validCSRFToken(token, session.CSRFToken) {
+		t.Fatalf("expected hx-headers token %q to validate", token)
+	}
+}
+
+func TestGitHubLoginFlow(t *testing.T) {
+	t.Parallel()
+
+	srv, fake := newGitHubTestServer(t)
+
+	req := attachSession(httptest.NewRequest(http.MethodGet, "/login/github", nil), SessionState{CSRFToken: "csrf"})
+	rr := httptest.NewRecorder()
+	srv.providerLoginHandler()(rr, withProvider(req, auth.ProviderGitHub))
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302, got %d", res.StatusCode)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != fake.Endpoint().AuthURL {
+		t.Fatalf("expected github authorize url, got %q", got)
+	}
+	session := sessionFromResponse(t, srv, res)
+
+	callback := func(user githubtest.User) *http.Response {
+		code := fake.Authorize(user)
+		req := httptest.NewRequest(http.MethodGet, "/login/github/callback?state="+location.Query().Get("state")+"&code="+code, nil)
+		req = attachSession(req, session)
+		rr := httptest.NewRecorder()
+		srv.providerCallbackHandler()(rr, withProvider(req, auth.ProviderGitHub))
+		return rr.Result()
+	}
+
+	t.Run("no verified email", func(t *testing.T) {
+		res := callback(githubtest.User{ID: 1, Login: "ghost", Emails: []githubtest.Email{{Email: "ghost@example.com", Primary: true}}})
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		res := callback(githubtest.User{ID: 583231, Login: "octocat", Emails: []githubtest.Email{{Email: "Octocat@example.com", Primary: true, Verified: true}}})
+		if res.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected 303, got %d", res.StatusCode)
+		}
+		saved := sessionFromResponse(t, srv, res)
+		if !saved.Authenticated || saved.Email != "octocat@example.com" {
+			t.Fatalf("expected authenticated github session, got %+v", saved)
+		}
+
+		account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail("octocat@example.com"))
+		if err != nil {
+			t.Fatalf("lookup github user: %v", err)
+		}
+		if account.Provider != auth.ProviderGitHub || account.OAuthSubject != "583231" {
+			t.Fatalf("expected github account keyed by numeric id, got %+v", account)
+		}
+	})
+}
+
+func TestExternalLoginRequiresExplicitLink(t *testing.T) {
+	t.Parallel()
+
+	srv, fake := newGitHubTestServer(t)
+	// The seeded password account owns user@example.com.
+	user := githubtest.User{ID: 7, Login: "lookalike", Emails: []githubtest.Email{{Email: seedEmail, Primary: true, Verified: true}}}
+
+	signInWithGitHub := func(session SessionState) (*http.Response, string) {
+		rr := httptest.NewRecorder()
+		srv.providerLoginHandler()(rr, withProvider(attachSession(httptest.NewRequest(http.MethodGet, "/login/github", nil), session), auth.ProviderGitHub))
+		location, err := url.Parse(rr.Result().Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("parse location: %v", err)
+		}
+		session = sessionFromResponse(t, srv, rr.Result())
+
+		req := httptest.NewRequest(http.MethodGet, "/login/github/callback?state="+location.Query().Get("state")+"&code="+fake.Authorize(user), nil)
+		rr = httptest.NewRecorder()
+		srv.providerCallbackHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGitHub))
+		return rr.Result(), rr.Body.String()
+	}
+
+	res, body := signInWithGitHub(SessionState{CSRFToken: "csrf"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected login prompt, got %d", res.StatusCode)
+	}
+	session := sessionFromResponse(t, srv, res)
+	if session.Authenticated {
+		t.Fatal("expected matching email not to sign the user in")
+	}
+	if session.PendingLink == nil || session.PendingLink.Subject != "7" {
+		t.Fatalf("expected pending github link, got %+v", session.PendingLink)
+	}
+	if !strings.Contains(body, "Sign in to it to link your GitHub account") {
+		t.Fatalf("expected link prompt in body, got %q", body)
+	}
+
+	form := url.Values{"email": {seedEmail}, "password": {seedPassword}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	srv.loginHandler()(rr, attachSession(req, session))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected password login to succeed, got %d", rr.Code)
+	}
+	if linked := sessionFromResponse(t, srv, rr.Result()); linked.PendingLink != nil {
+		t.Fatal("expected pending link to be consumed")
+	}
+
+	res, _ = signInWithGitHub(SessionState{CSRFToken: "csrf"})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected linked github identity to sign in, got %d", res.StatusCode)
+	}
+	if saved := sessionFromResponse(t, srv, res); !saved.Authenticated || saved.Email != seedEmail {
+		t.Fatalf("expected session for seeded account, got %+v", saved)
+	}
+}
+
+func TestDashboardLinkAndUnlinkIdentity(t *testing.T) {
+	t.Parallel()
+
+	srv, fake := newGitHubTestServer(t)
+	session := signedInAs(t, srv, seedEmail)
+
+	rr := httptest.NewRecorder()
+	srv.dashboardPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), session))
+	if body := rr.Body.String(); !strings.Contains(body, `action="/account/identities/github"`) || strings.Contains(body, "/unlink") {
+		t.Fatalf("expected link button and no unlink for a password-only account, got %q", body)
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/account/identities/github", nil)
+	srv.linkIdentityHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGitHub))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect to github, got %d", rr.Code)
+	}
+	location, err := url.Parse(rr.Result().Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	session = sessionFromResponse(t, srv, rr.Result())
+
+	// A different email on the provider side is fine: the user is already signed in.
+	user := githubtest.User{ID: 99, Login: "octo", Emails: []githubtest.Email{{Email: "octo@example.com", Primary: true, Verified: true}}}
+	req = httptest.NewRequest(http.MethodGet, "/login/github/callback?state="+location.Query().Get("state")+"&code="+fake.Authorize(user), nil)
+	rr = httptest.NewRecorder()
+	srv.providerCallbackHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGitHub))
+	if rr.Code != http.StatusSeeOther || rr.Result().Header.Get("Location") != "/dashboard" {
+		t.Fatalf("expected redirect to dashboard after linking, got %d", rr.Code)
+	}
+	session = sessionFromResponse(t, srv, rr.Result())
+	if session.Email != seedEmail {
+		t.Fatalf("expected session to stay on %s, got %q", seedEmail, session.Email)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.dashboardPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), session))
+	body := rr.Body.String()
+	if !strings.Contains(body, "octo@example.com") || !strings.Contains(body, `action="/account/identities/github/unlink"`) {
+		t.Fatalf("expected linked github identity on dashboard, got %q", body)
+	}
+
+	unlink := func(session SessionState, provider, subject string) *httptest.ResponseRecorder {
+		form := url.Values{"subject": {subject}}
+		req := httptest.NewRequest(http.MethodPost, "/account/identities/"+provider+"/unlink", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.unlinkIdentityHandler()(rr, withProvider(attachSession(req, session), provider))
+		return rr
+	}
+
+	if rr := unlink(session, auth.ProviderGitHub, "99"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected unlink to succeed, got %d", rr.Code)
+	}
+	if _, err := srv.authService.EnsureExternalUser(context.Background(), auth.ExternalIdentity{
+		Provider: auth.ProviderGitHub, Subject: "99", Email: auth.MustUserEmail("octo@example.com"), EmailVerified: true,
+	}); err != nil {
+		t.Fatalf("provision github-only account: %v", err)
+	}
+	githubOnly := signedInAs(t, srv, "octo@example.com")
+	if rr := unlink(githubOnly, auth.ProviderGitHub, "99"); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "only way to sign in") {
+		t.Fatalf("expected last login method to be kept, got %d", rr.Code)
+	}
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	router := srv.Router()
+	session := signedInAs(t, srv, seedEmail)
+
+	create := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, personalTokensPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.createPersonalTokenHandler()(rr, attachSession(req, session))
+		return rr
+	}
+	if rr := create(url.Values{"name": {"CI"}, "expires_in_days": {"30"}}); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "at least one scope") {
+		t.Fatalf("expected a token without scopes to be refused, got %d", rr.Code)
+	}
+	rr := create(url.Values{"name": {"CI"}, "scope": {auth.PersonalTokenScopeRead}, "expires_in_days": {"30"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected token to be created, got %d", rr.Code)
+	}
+	secret := regexp.MustCompile(auth.PersonalTokenPrefix + `[A-Za-z0-9_-]{43}`).FindString(rr.Body.String())
+	if secret == "" {
+		t.Fatalf("expected the new token to be shown once, got %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.personalTokensPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, personalTokensPath, nil), session))
+	if body := rr.Body.String(); strings.Contains(body, secret) || !strings.Contains(body, "CI") || !strings.Contains(body, "never used") {
+		t.Fatalf("expected the token to be listed without its secret, got %q", body)
+	}
+
+	bearer := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "198.51.100.4:4000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = bearer(http.MethodGet, "/dashboard", secret)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), seedEmail) {
+		t.Fatalf("expected the token to authenticate the dashboard, got %d", rr.Code)
+	}
+	if cookies := rr.Result().Cookies(); len(cookies) != 0 {
+		t.Fatalf("expected no session cookie for token requests, got %v", cookies)
+	}
+	if rr := bearer(http.MethodGet, apiPrefix+"/me", secret); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), seedEmail) {
+		t.Fatalf("expected the token to authenticate the API, got %d", rr.Code)
+	}
+	for _, path := range []string{personalTokensPath, "/account/identities/github/unlink", oauth.AuthorizePath, oauth.DeviceVerificationPath, forwardAuthPath} {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			if rr := bearer(method, path, secret); rr.Code != http.StatusForbidden {
+				t.Fatalf("%s %s: expected tokens to be refused outside the API routes, got %d", method, path, rr.Code)
+			}
+		}
+	}
+	// Handlers acting for the user refuse tokens even if routed to them.
+	req := httptest.NewRequest(http.MethodPost, "/account/identities/github/unlink", nil)
+	req = req.WithContext(context.WithValue(withSession(req.Context(), session), personalTokenContextKey{}, auth.PersonalToken{}))
+	rr = httptest.NewRecorder()
+	srv.unlinkIdentityHandler()(rr, withProvider(req, auth.ProviderGitHub))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected unlink to require the cookie session, got %d", rr.Code)
+	}
+	if rr := bearer(http.MethodGet, "/dashboard", auth.PersonalTokenPrefix+"unknown"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an unknown token to be refused, got %d", rr.Code)
+	}
+
+	account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail(seedEmail))
+	if err != nil {
+		t.Fatalf("lookup account: %v", err)
+	}
+	tokens, err := srv.authService.PersonalTokens(context.Background(), account.ID)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("expected one token, got %v %v", tokens, err)
+	}
+	if tokens[0].LastUsedIP != "198.51.100.4" || tokens[0].LastUsedAt.IsZero() {
+		t.Fatalf("expected last use to be recorded, got %+v", tokens[0])
+	}
+
+	revoke := func(id string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("tokenID", id)
+		req := httptest.NewRequest(http.MethodPost, personalTokensPath+"/"+id+"/revoke", nil)
+		req = attachSession(req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)), session)
+		rr := httptest.NewRecorder()
+		srv.revokePersonalTokenHandler()(rr, req)
+		return rr
+	}
+	if rr := revoke(tokens[0].ID); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected revoke to redirect, got %d", rr.Code)
+	}
+	if rr := revoke(tokens[0].ID); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a revoked token to be gone, got %d", rr.Code)
+	}
+	if rr := bearer(http.MethodGet, "/dashboard", secret); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked token to be refused, got %d", rr.Code)
+	}
+}
+
+func TestForwardAuth(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	srv.configuration.PublicURL = "https://auth.example.com"
+	srv.configuration.TrustedOrigins = []string{"https://tool.example.com"}
+	router := srv.Router()
+
+	saved := httptest.NewRecorder()
+	if err := srv.sessions.Save(saved, signedInAs(t, srv, seedEmail)); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	cookie := saved.Result().Cookies()[0]
+
+	verify := func(method, target string, headers map[string]string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail(seedEmail))
+	if err != nil {
+		t.Fatalf("lookup account: %v", err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rr := verify(method, forwardAuthPath, nil, cookie)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected signed-in session to pass, got %d", method, rr.Code)
+		}
+		if rr.Header().Get("X-Auth-User") != account.ID || rr.Header().Get("X-Auth-Email") != seedEmail {
+			t.Fatalf("%s: unexpected identity headers %v", method, rr.Header())
+		}
+	}
+
+	rr := verify(http.MethodGet, forwardAuthPath, nil)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("X-Auth-User") != "" {
+		t.Fatalf("expected anonymous request to be refused, got %d", rr.Code)
+	}
+	_, secret, err := srv.authService.CreatePersonalToken(context.Background(), account.ID, auth.PersonalTokenRequest{Name: "proxy", Scopes: []string{auth.PersonalTokenScopeRead}, Lifetime: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("create personal token: %v", err)
+	}
+	if rr := verify(http.MethodGet, forwardAuthPath, map[string]string{"Authorization": "Bearer " + secret}); rr.Code == http.StatusOK || rr.Header().Get("X-Auth-User") != "" {
+		t.Fatalf("expected a personal access token not to pass forward auth, got %d", rr.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, forwardAuthPath, nil)
+	req = req.WithContext(context.WithValue(withSession(req.Context(), signedInAs(t, srv, seedEmail)), personalTokenContextKey{}, auth.PersonalToken{}))
+	rr = httptest.NewRecorder()
+	srv.forwardAuthHandler()(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected forward auth to ignore token-authenticated sessions, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	srv.forwardAuthHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, forwardAuthPath, nil), SessionState{Authenticated: true, Email: seedEmail}))
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("X-Auth-User") != "" {
+		t.Fatalf("expected a session without a user id to be refused, got %d", rr.Code)
+	}
+	forged := &http.Cookie{Name: sessionCookieName, Value: cookie.Value + "x"}
+	if rr := verify(http.MethodGet, forwardAuthPath, nil, forged); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected tampered cookie to be refused, got %d", rr.Code)
+	}
+
+	traefik := map[string]string{
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "tool.example.com",
+		"X-Forwarded-Uri":   "/reports?week=42",
+	}
+	rr = verify(http.MethodGet, forwardAuthPath+"?mode=redirect", traefik)
+	want := "https://auth.example.com/?return_to=" + url.QueryEscape("https://tool.example.com/reports?week=42")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != want {
+		t.Fatalf("expected redirect to %s, got %d %q", want, rr.Code, rr.Header().Get("Location"))
+	}
+	rr = verify(http.MethodGet, forwardAuthPath+"?mode=redirect", map[string]string{"X-Original-URL": "https://evil.example.net/"})
+	if rr.Header().Get("Location") != "https://auth.example.com/" {
+		t.Fatalf("expected untrusted original url to be dropped, got %q", rr.Header().Get("Location"))
+	}
+	if rr := verify(http.MethodGet, forwardAuthPath+"?mode=redirect", traefik, cookie); rr.Code != http.StatusOK {
+		t.Fatalf("expected signed-in session to pass in redirect mode, got %d", rr.Code)
+	}
+}
+
+func TestSessionCookieDomain(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewSessionStore(bytes.Repeat([]byte("s"), 32), "example.com")
+	if err != nil {
+		t.Fatalf("new session store: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	if err := store.Save(rr, SessionState{Authenticated: true, Email: seedEmail}); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	store.Clear(rr)
+	for _, line := range rr.Result().Header.Values("Set-Cookie") {
+		if !strings.Contains(line, "Domain=example.com") {
+			t.Fatalf("expected cookie scoped to example.com, got %q", line)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	t.Parallel()
+
+	secret := bytes.Repeat([]byte("s"), 32)
+	store, err := NewSessionStore(secret, "")
+	if err != nil {
+		t.Fatalf("new session store: %v", err)
+	}
+	load := func(value string) SessionState {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: value})
+		return store.Load(req)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := store.Save(rr, SessionState{Authenticated: true, Email: seedEmail, UserID: "user-1"}); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	cookie := rr.Result().Cookies()[0]
+	state := load(cookie.Value)
+	if !state.Authenticated || state.ExpiresAt-state.IssuedAt != int64(sessionLifetime/time.Second) || cookie.Expires.Unix() != state.ExpiresAt {
+		t.Fatalf("expected a session bounded by its lifetime, got %+v expiring %v", state, cookie.Expires)
+	}
+
+	rr = httptest.NewRecorder()
+	if err := store.Save(rr, state); err != nil {
+		t.Fatalf("save session again: %v", err)
+	}
+	if resaved := load(rr.Result().Cookies()[0].Value); resaved.ExpiresAt != state.ExpiresAt {
+		t.Fatalf("expected saving to keep the expiry, got %d for %d", resaved.ExpiresAt, state.ExpiresAt)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	for name, state := range map[string]SessionState{
+		"expired":   {Authenticated: true, UserID: "user-1", IssuedAt: past.Add(-sessionLifetime).Unix(), ExpiresAt: past.Unix()},
+		"no expiry": {Authenticated: true, UserID: "user-1"},
+	} {
+		value, err := encodeSession(state, secret)
+		if err != nil {
+			t.Fatalf("%s: encode session: %v", name, err)
+		}
+		if loaded := load(value); loaded.Authenticated {
+			t.Fatalf("%s: expected the session to be refused, got %+v", name, loaded)
+		}
+	}
+}
+
+func TestGoogleLoginPopulatesProfile(t *testing.T) {
+	t.Parallel()
+
+	srv, issuer := newGoogleTestServer(t)
+
+	flow := pendingFlow("profile")
+	code := issuer.Authorize(oidctest.Identity{
+		Subject:       "profile-sub",
+		Email:         "grace@example.com",
+		EmailVerified: true,
+		Name:          "Grace Hopper",
+		Picture:       "https://lh3.example.com/grace.png",
+	}, flow.Nonce)
+	req := httptest.NewRequest(http.MethodGet, "/login/google/callback?state="+flow.State+"&code="+url.QueryEscape(code), nil)
+	rr := httptest.NewRecorder()
+	srv.providerCallbackHandler()(rr, withProvider(attachSession(req, SessionState{OAuthFlows: []OAuthFlow{flow}, CSRFToken: "csrf"}), auth.ProviderGoogle))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", rr.Code)
+	}
+	session := sessionFromResponse(t, srv, rr.Result())
+
+	rr = httptest.NewRecorder()
+	srv.dashboardPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), session))
+	body := rr.Body.String()
+	if !strings.Contains(body, "Welcome back, Grace Hopper") {
+		t.Fatalf("expected display name on dashboard, got %q", body)
+	}
+	if !strings.Contains(body, `src="https://lh3.example.com/grace.png"`) {
+		t.Fatalf("expected avatar on dashboard, got %q", body)
+	}
+}
+
+func TestGoogleOfflineAccessAndIncrementalScopes(t *testing.T) {
+	t.Parallel()
+
+	srv, issuer := newGoogleTestServer(t, func(cfg *config.Config) {
+		cfg.GoogleOAuth.OfflineAccess = true
+		cfg.TokenEncryptionKey = bytes.Repeat([]byte("k"), 32)
+	})
+	ctx := context.Background()
+	identity := oidctest.Identity{Subject: "offline-sub", Email: "ada@example.com", EmailVerified: true}
+
+	rr := httptest.NewRecorder()
+	srv.providerLoginHandler()(rr, withProvider(attachSession(httptest.NewRequest(http.MethodGet, "/login/google", nil), SessionState{CSRFToken: "csrf"}), auth.ProviderGoogle))
+	location, err := url.Parse(rr.Result().Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	if location.Query().Get("access_type") != "offline" {
+		t.Fatalf("expected offline access request, got %q", location.RawQuery)
+	}
+	session := sessionFromResponse(t, srv, rr.Result())
+
+	code := issuer.Authorize(identity, session.OAuthFlows[0].Nonce)
+	req := httptest.NewRequest(http.MethodGet, "/login/google/callback?state="+location.Query().Get("state")+"&code="+url.QueryEscape(code), nil)
+	rr = httptest.NewRecorder()
+	srv.providerCallbackHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGoogle))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", rr.Code)
+	}
+	session = sessionFromResponse(t, srv, rr.Result())
+
+	account, err := srv.authService.LookupByEmail(ctx, auth.MustUserEmail("ada@example.com"))
+	if err != nil {
+		t.Fatalf("lookup account: %v", err)
+	}
+	token, err := srv.authService.ProviderToken(ctx, account.ID, auth.ProviderGoogle)
+	if err != nil {
+		t.Fatalf("provider token: %v", err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		t.Fatalf("expected stored access and refresh tokens, got %+v", token)
+	}
+
+	provider, _ := srv.providers.Lookup(auth.ProviderGoogle)
+	refresher, ok := provider.(auth.TokenRefresher)
+	if !ok {
+		t.Fatal("expected google provider to refresh tokens")
+	}
+	if _, err := refresher.RefreshToken(ctx, token.RefreshToken); err != nil || issuer.Refreshes() != 1 {
+		t.Fatalf("expected refresh through the issuer, got %v after %d refreshes", err, issuer.Refreshes())
+	}
+
+	requestScopes := func(session SessionState, scopes ...string) *httptest.ResponseRecorder {
+		form := url.Values{"scope": scopes}
+		req := httptest.NewRequest(http.MethodPost, "/account/identities/google/scopes", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.requestScopesHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGoogle))
+		return rr
+	}
+
+	rr = requestScopes(session, "email")
+	if rr.Code != http.StatusSeeOther || rr.Result().Header.Get("Location") != "/dashboard" {
+		t.Fatalf("expected granted scopes to skip consent, got %d %q", rr.Code, rr.Result().Header.Get("Location"))
+	}
+
+	const calendar = "https://www.googleapis.com/auth/calendar.readonly"
+	rr = requestScopes(session, "email", calendar)
+	location, err = url.Parse(rr.Result().Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	query := location.Query()
+	if query.Get("scope") != "openid email profile "+calendar || query.Get("include_granted_scopes") != "true" || query.Get("prompt") != "consent" {
+		t.Fatalf("expected consent for the missing scope with the login scopes, got %q", location.RawQuery)
+	}
+	session = sessionFromResponse(t, srv, rr.Result())
+	flow := session.OAuthFlows[len(session.OAuthFlows)-1]
+	if !flow.Link || !slices.Equal(flow.Scopes, []string{calendar}) {
+		t.Fatalf("expected link flow carrying requested scopes, got %+v", flow)
+	}
+
+	code = issuer.AuthorizeScopes(identity, flow.Nonce, strings.Fields(query.Get("scope")))
+	req = httptest.NewRequest(http.MethodGet, "/login/google/callback?state="+flow.State+"&code="+url.QueryEscape(code), nil)
+	rr = httptest.NewRecorder()
+	srv.providerCallbackHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGoogle))
+	if rr.Code != http.StatusSeeOther || rr.Result().Header.Get("Location") != "/dashboard" {
+		t.Fatalf("expected redirect to dashboard after consent, got %d", rr.Code)
+	}
+
+	missing, err := srv.authService.MissingScopes(ctx, account.ID, auth.ProviderGoogle, []string{"email", "profile", calendar})
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected all scopes granted, got %v (%v)", missing, err)
+	}
+}
+
+func newSAMLTestServer(t *testing.T, allowIDPInitiated bool) (*Server, *samltest.IdP, *x509.Certificate) {
+	t.Helper()
+
+	const (
+		entityID = "http://localhost/saml/acme/metadata"
+		acsURL   = "http://localhost/saml/acme/acs"
+	)
+	idp := samltest.New(t, entityID, acsURL)
+	certPEM, keyPEM := samltest.KeyPairPEM(t)
+	spCert, _, err := saml.ParseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("parse sp key pair: %v", err)
+	}
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	cfg := config.Config{
+		ListenAddr:    ":0",
+		LogMode:       logging.ModeText,
+		Environment:   "test",
+		SessionSecret: bytes.Repeat([]byte("m"), 32),
+		DatabaseURL:   "postgres://localhost/auth_test?sslmode=disable",
+		SAML: []config.SAMLConnectionConfig{{
+			ID:                "acme",
+			Name:              "Acme SSO",
+			EntityID:          entityID,
+			ACSURL:            acsURL,
+			IDPMetadataPath:   write("idp.xml", idp.Metadata()),
+			CertificatePath:   write("sp.crt", certPEM),
+			KeyPath:           write("sp.key", keyPEM),
+			Domains:           []string{"acme.test"},
+			AllowIDPInitiated: allowIDPInitiated,
+			Attributes:        config.SAMLAttributeMapping{Email: "mail", GivenName: "givenName", FamilyName: "sn"},
+		}},
+	}
+
+	logger := logging.New(io.Discard, logging.ModeText, nil)
+	srv, err := New(cfg, auth.NewService(auth.NewMemoryStore()), logger)
+	if err != nil {
+		t.Fatalf("new saml server: %v", err)
+	}
+	return srv, idp, spCert
+}
+
+// postSAMLResponse posts samlResponse to the ACS and, once it is accepted,
+// follows the redirect as the browser would, sending the sealed login and
+// the given cookies.
+func postSAMLResponse(srv *Server, samlResponse, relayState string, cookies ...*http.Cookie) *http.Response {
+	form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {relayState}}
+	req := httptest.NewRequest(http.MethodPost, "/saml/acme/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rr := httptest.NewRecorder()
+	srv.Router().ServeHTTP(rr, req)
+	res := rr.Result()
+	if res.StatusCode != http.StatusSeeOther {
+		return res
+	}
+
+	req = httptest.NewRequest(http.MethodGet, res.Header.Get("Location"), nil)
+	for _, c := range res.Cookies() {
+		if c.Name == samlLoginCookieName {
+			req.AddCookie(c)
+		}
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	srv.Router().ServeHTTP(rr, req)
+	return rr.Result()
+}
+
+// beginSAMLLogin starts an SP-initiated sign-in and returns the AuthnRequest
+// sent to the IdP with the session cookie holding its flow.
+func beginSAMLLogin(t *testing.T, srv *Server, spCert *x509.Certificate) (samltest.AuthnRequest, *http.Cookie) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	srv.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login/acme", nil))
+	res := rr.Result()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302, got %d", res.StatusCode)
+	}
+	cookies := res.Cookies()
+	for i := len(cookies) - 1; i >= 0; i-- {
+		if cookies[i].Name == sessionCookieName {
+			return samltest.ReadAuthnRequest(t, res.Header.Get("Location"), spCert), cookies[i]
+		}
+	}
+	t.Fatal("expected session cookie to be set")
+	return samltest.AuthnRequest{}, nil
+}
+
+func TestSAMLServiceProviderInitiatedLogin(t *testing.T) {
+	t.Parallel()
+
+	srv, idp, spCert := newSAMLTestServer(t, false)
+
+	// A response answering another browser's request must not sign this one in.
+	authn, _ := beginSAMLLogin(t, srv, spCert)
+	stolen := idp.Response(t, samltest.Assertion{InResponseTo: authn.ID, NameIDFormat: saml.NameIDFormatEmail, NameID: "jane@acme.test"})
+	if res := postSAMLResponse(srv, stolen, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a response without its session to be rejected, got %d", res.StatusCode)
+	}
+
+	authn, session := beginSAMLLogin(t, srv, spCert)
+	samlResponse := idp.Response(t, samltest.Assertion{
+		InResponseTo: authn.ID,
+		NameID:       "00u-jane",
+		Attributes:   map[string][]string{"mail": {"Jane@Acme.test"}, "givenName": {"Jane"}, "sn": {"Doe"}},
+	})
+	res := postSAMLResponse(srv, samlResponse, "", session)
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/dashboard" {
+		t.Fatalf("expected redirect to dashboard, got %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	saved := sessionFromResponse(t, srv, res)
+	if !saved.Authenticated || saved.Email != "jane@acme.test" {
+		t.Fatalf("expected authenticated saml session, got %+v", saved)
+	}
+
+	account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail("jane@acme.test"))
+	if err != nil {
+		t.Fatalf("lookup saml user: %v", err)
+	}
+	if account.Provider != "acme" || account.OAuthSubject != "00u-jane" || account.DisplayName != "Jane Doe" {
+		t.Fatalf("expected account keyed by NameID with mapped profile, got %+v", account)
+	}
+
+	if res := postSAMLResponse(srv, samlResponse, "", session); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected replayed response to be rejected, got %d", res.StatusCode)
+	}
+	if res := postSAMLResponse(srv, idp.Response(t, samltest.Assertion{NameIDFormat: saml.NameIDFormatEmail, NameID: "unsolicited@acme.test"}), ""); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unsolicited response to be rejected, got %d", res.StatusCode)
+	}
+}
+
+func TestSAMLIdentityProviderInitiatedLogin(t *testing.T) {
+	t.Parallel()
+
+	srv, idp, _ := newSAMLTestServer(t, true)
+
+	res := postSAMLResponse(srv, idp.Response(t, samltest.Assertion{NameIDFormat: saml.NameIDFormatEmail, NameID: "ops@acme.test"}), "")
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", res.StatusCode)
+	}
+	if saved := sessionFromResponse(t, srv, res); !saved.Authenticated || saved.Email != "ops@acme.test" {
+		t.Fatalf("expected email NameID to sign in, got %+v", saved)
+	}
+
+	if res := postSAMLResponse(srv, idp.Response(t, samltest.Assertion{NameID: "no-email"}), ""); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected assertion without email to be rejected, got %d", res.StatusCode)
+	}
+	if res := postSAMLResponse(srv, idp.Response(t, samltest.Assertion{NameIDFormat: saml.NameIDFormatEmail, NameID: "ceo@other.test"}), ""); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an email outside the connection's domains to be rejected, got %d", res.StatusCode)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/saml/acme/acs", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the login redirect without a sealed response to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestSAMLLoginNeverLinksExistingAccount(t *testing.T) {
+	t.Parallel()
+
+	srv, idp, _ := newSAMLTestServer(t, true)
+	if _, err := srv.authService.Register(context.Background(), auth.MustUserEmail("owner@acme.test"), "Owner-Password-1"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	res := postSAMLResponse(srv, idp.Response(t, samltest.Assertion{NameIDFormat: saml.NameIDFormatEmail, NameID: "owner@acme.test"}), "")
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for an email owned by another account, got %d", res.StatusCode)
+	}
+	if saved := sessionFromResponse(t, srv, res); saved.Authenticated || saved.PendingLink != nil {
+		t.Fatalf("expected no sign-in and no pending link, got %+v", saved)
+	}
+}
+
+func TestSAMLConnectionRoutes(t *testing.T) {
+	t.Parallel()
+
+	srv, _, spCert := newSAMLTestServer(t, false)
+
+	rr := httptest.NewRecorder()
+	srv.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/saml/acme/metadata", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/samlmetadata+xml" {
+		t.Fatalf("expected metadata, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `entityID="http://localhost/saml/acme/metadata"`) || !strings.Contains(body, base64.StdEncoding.EncodeToString(spCert.Raw)) {
+		t.Fatalf("expected connection entity id and certificate in metadata:\n%s", body)
+	}
+
+	for _, path := range []string{"/saml/unknown/metadata", "/login/acme/callback?state=x&code=y"} {
+		rr := httptest.NewRecorder()
+		srv.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+
+	req := attachSession(httptest.NewRequest(http.MethodPost, "/account/identities/acme", nil), signedInAs(t, srv, seedEmail))
+	rr = httptest.NewRecorder()
+	srv.linkIdentityHandler()(rr, withProvider(req, "acme"))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected saml connections to be unlinkable from the dashboard, got %d", rr.Code)
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	srv.configuration.TrustedOrigins = []string{"https://app.example.com"}
+
+	tests := map[string]struct {
+		raw  string
+		want string
+	}{
+		"local path":              {raw: "/dashboard?tab=security#keys", want: "/dashboard?tab=security#keys"},
+		"trusted origin":          {raw: "https://app.example.com/projects/1", want: "https://app.example.com/projects/1"},
+		"trusted origin any case": {raw: "HTTPS://APP.example.com/x", want: "https://APP.example.com/x"},
+		"empty":                   {raw: "", want: ""},
+		"relative path":           {raw: "dashboard", want: ""},
+		"protocol relative":       {raw: "//evil.example.com/", want: ""},
+		"backslash":               {raw: "/\\evil.example.com", want: ""},
+		"tab smuggling":           {raw: "/\t/evil.example.com", want: ""},
+		"untrusted origin":        {raw: "https://evil.example.com/", want: ""},
+		"trusted host other port": {raw: "https://app.example.com:8443/", want: ""},
+		"userinfo":                {raw: "https://app.example.com@evil.example.com/", want: ""},
+		"javascript":              {raw: "javascript:alert(1)", want: ""},
+		"scheme without host":     {raw: "https:/evil.example.com", want: ""},
+		"too long":                {raw: "/" + strings.Repeat("a", maxReturnToLength), want: ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := srv.safeReturnTo(tc.raw); got != tc.want {
+				t.Fatalf("safeReturnTo(%q) = %q, want %q", tc.raw, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoginReturnsToRequestedPage(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+
+	req := attachSession(httptest.NewRequest(http.MethodGet, "/dashboard?tab=identities", nil), SessionState{CSRFToken: "csrf"})
+	rr := httptest.NewRecorder()
+	srv.dashboardPageHandler()(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	session := sessionFromResponse(t, srv, rr.Result())
+	if session.ReturnTo != "/dashboard?tab=identities" {
+		t.Fatalf("expected protected page to be remembered, got %q", session.ReturnTo)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.loginPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/?next=//evil.example.com", nil), session))
+	if cookies := rr.Result().Cookies(); len(cookies) != 0 {
+		t.Fatal("expected an unsafe next parameter to leave the session untouched")
+	}
+
+	form := url.Values{"email": {seedEmail}, "password": {seedPassword}}
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	srv.loginHandler()(rr, attachSession(req, session))
+	res := rr.Result()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/dashboard?tab=identities" {
+		t.Fatalf("expected redirect back to the protected page, got %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	if saved := sessionFromResponse(t, srv, res); saved.ReturnTo != "" {
+		t.Fatalf("expected return_to to be consumed, got %q", saved.ReturnTo)
+	}
+}
+
+func TestExternalLoginCarriesReturnTo(t *testing.T) {
+	t.Parallel()
+
+	srv, issuer := newGoogleTestServer(t)
+
+	req := attachSession(httptest.NewRequest(http.MethodGet, "/login/google?return_to=%2Fdashboard%3Ffrom%3Dgoogle", nil), SessionState{CSRFToken: "csrf"})
+	rr := httptest.NewRecorder()
+	srv.providerLoginHandler()(rr, withProvider(req, auth.ProviderGoogle))
+	res := rr.Result()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	session := sessionFromResponse(t, srv, res)
+	if len(session.OAuthFlows) != 1 || session.OAuthFlows[0].ReturnTo != "/dashboard?from=google" {
+		t.Fatalf("expected return_to on the pending flow, got %+v", session.OAuthFlows)
+	}
+	session.ReturnTo = ""
+
+	code := issuer.Authorize(oidctest.Identity{Subject: "deep-link", Email: "deep-link@example.com", EmailVerified: true}, session.OAuthFlows[0].Nonce)
+	req = httptest.NewRequest(http.MethodGet, "/login/google/callback?state="+location.Query().Get("state")+"&code="+url.QueryEscape(code), nil)
+	rr = httptest.NewRecorder()
+	srv.providerCallbackHandler()(rr, withProvider(attachSession(req, session), auth.ProviderGoogle))
+	if loc := rr.Result().Header.Get("Location"); loc != "/dashboard?from=google" {
+		t.Fatalf("expected redirect to the captured destination, got %q", loc)
+	}
+}
+
+func TestSAMLRelayStateReturnTo(t *testing.T) {
+	t.Parallel()
+
+	srv, idp, _ := newSAMLTestServer(t, true)
+
+	tests := map[string]struct {
+		relayState string
+		want       string
+	}{
+		"local path":    {relayState: "/dashboard?from=idp", want: "/dashboard?from=idp"},
+		"off-site":      {relayState: "https://evil.example.com/", want: "/dashboard"},
+		"no relaystate": {relayState: "", want: "/dashboard"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			res := postSAMLResponse(srv, idp.Response(t, samltest.Assertion{NameIDFormat: saml.NameIDFormatEmail, NameID: "relay@acme.test"}), tc.relayState)
+			if loc := res.Header.Get("Location"); res.StatusCode != http.StatusSeeOther || loc != tc.want {
+				t.Fatalf("expected redirect to %q, got %d %q", tc.want, res.StatusCode, loc)
+			}
+		})
+	}
+}
+
+const (
+	oauthTestRedirectURI = "https://app.example.test/callback"
+	oauthTestAdminToken  = "admin-token-0123456789abcdef0123456789"
+)
+
+// newAuthorizationServerTestServer serves the router over HTTP so relying
+// parties can discover the provider, and registers a confidential client.
+func newAuthorizationServerTestServer(t *testing.T) (*Server, *httptest.Server, oauth.Client, string) {
+	t.Helper()
+
+	var handler http.Handler
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+	signer, err := signing.NewKeySigner(key)
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+
+	srv := newTestServer(t)
+	srv.configuration.AuthorizationServer.AdminToken = oauthTestAdminToken
+	provider := oauth.NewService(oauth.NewMemoryStore(), srv.authService, signer, ts.URL)
+	client, secret, err := provider.Clients().Register(context.Background(), oauth.ClientRegistration{
+		Name:         "Example App",
+		RedirectURIs: []string{oauthTestRedirectURI},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	srv.EnableAuthorizationServer(provider)
+	handler = srv.Router()
+	return srv, ts, client, secret
+}
+
+// authorizeAs runs the authorization endpoint for the seeded user and returns
+// the response, approving the consent screen when approve is set.
+func authorizeAs(t *testing.T, srv *Server, authURL string, approve bool) *http.Response {
+	t.Helper()
+
+	session := signedInAs(t, srv, seedEmail)
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	srv.authorizeHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, parsed.RequestURI(), nil), session))
+	if !approve || rr.Code != http.StatusOK {
+		return rr.Result()
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "Example App") || !strings.Contains(body, `value="allow"`) {
+		t.Fatalf("expected consent screen, got %s", body)
+	}
+	if rr.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatal("expected consent screen to forbid framing")
+	}
+
+	form := parsed.Query()
+	form.Set("decision", "allow")
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	srv.authorizeDecisionHandler()(rr, attachSession(req, session))
+	return rr.Result()
+}
+
+func TestAuthorizationServerFlow(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, client, secret := newAuthorizationServerTestServer(t)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, ts.Client())
+
+	rp, err := oidc.New(oidc.Config{
+		Issuer:       ts.URL,
+		ClientID:     client.ID,
+		ClientSecret: secret,
+		RedirectURL:  oauthTestRedirectURI,
+		HTTPClient:   ts.Client(),
+	})
+	if err != nil {
+		t.Fatalf("new relying party: %v", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := rp.AuthCodeURL(ctx, "rp-state", "rp-nonce", oauth2.S256ChallengeOption(verifier))
+	if err != nil {
+		t.Fatalf("auth code url: %v", err)
+	}
+
+	res := authorizeAs(t, srv, authURL, true)
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected redirect to client, got %d", res.StatusCode)
+	}
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse callback: %v", err)
+	}
+	if !strings.HasPrefix(callback.String(), oauthTestRedirectURI+"?") || callback.Query().Get("state") != "rp-state" || callback.Query().Get("iss") != ts.URL {
+		t.Fatalf("unexpected callback %s", callback)
+	}
+	code := callback.Query().Get("code")
+
+	token, claims, err := rp.Exchange(ctx, code, "rp-nonce", oauth2.VerifierOption(verifier))
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if claims.Email != seedEmail || claims.Subject == "" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/userinfo", nil)
+	if err != nil {
+		t.Fatalf("new userinfo request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	info, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("userinfo: %v", err)
+	}
+	defer info.Body.Close()
+	body, _ := io.ReadAll(info.Body)
+	if info.StatusCode != http.StatusOK || !strings.Contains(string(body), claims.Subject) {
+		t.Fatalf("unexpected userinfo response %d %s", info.StatusCode, body)
+	}
+
+	if _, _, err := rp.Exchange(ctx, code, "rp-nonce", oauth2.VerifierOption(verifier)); err == nil {
+		t.Fatal("expected a reused code to be rejected")
+	}
+
+	again := authorizeAs(t, srv, authURL, false)
+	if again.StatusCode != http.StatusSeeOther || !strings.HasPrefix(again.Header.Get("Location"), oauthTestRedirectURI) {
+		t.Fatalf("expected remembered consent to redirect immediately, got %d", again.StatusCode)
+	}
+}
+
+func TestAuthorizationServerRejections(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, client, secret := newAuthorizationServerTestServer(t)
+	query := url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {oauthTestRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"state":                 {"s"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(oauth2.GenerateVerifier())},
+		"code_challenge_method": {"S256"},
+	}
+
+	t.Run("anonymous user signs in first", func(t *testing.T) {
+		t.Parallel()
+		rr := httptest.NewRecorder()
+		srv.authorizeHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+query.Encode(), nil), SessionState{CSRFToken: "csrf"}))
+		if loc := rr.Header().Get("Location"); rr.Code != http.StatusSeeOther || !strings.HasPrefix(loc, "/?return_to=%2Foauth2%2Fauthorize") {
+			t.Fatalf("expected redirect to sign in, got %d %q", rr.Code, loc)
+		}
+	})
+
+	t.Run("unregistered redirect uri is not followed", func(t *testing.T) {
+		t.Parallel()
+		bad := maps.Clone(query)
+		bad.Set("redirect_uri", "https://evil.example.test/callback")
+		res := authorizeAs(t, srv, "/oauth2/authorize?"+bad.Encode(), false)
+		if res.StatusCode != http.StatusBadRequest || res.Header.Get("Location") != "" {
+			t.Fatalf("expected 400 without redirect, got %d %q", res.StatusCode, res.Header.Get("Location"))
+		}
+	})
+
+	t.Run("invalid scope is returned to the client", func(t *testing.T) {
+		t.Parallel()
+		bad := maps.Clone(query)
+		bad.Set("scope", "openid admin")
+		res := authorizeAs(t, srv, "/oauth2/authorize?"+bad.Encode(), false)
+		location, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("parse location: %v", err)
+		}
+		if location.Query().Get("error") != "invalid_scope" || location.Query().Get("state") != "s" {
+			t.Fatalf("expected invalid_scope error redirect, got %s", location)
+		}
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		t.Parallel()
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {"x"}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauth2.GenerateVerifier()}}
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/oauth2/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new token request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, "wrong")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("token request: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") == "" {
+			t.Fatalf("expected 401 with challenge, got %d", res.StatusCode)
+		}
+	})
+
+	postToken := func(t *testing.T, form url.Values, basicSecret string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/oauth2/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new token request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basicSecret != "" {
+			req.SetBasicAuth(client.ID, basicSecret)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("token request: %v", err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	t.Run("secret sent with an unregistered method", func(t *testing.T) {
+		t.Parallel()
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {"x"}, "client_id": {client.ID}, "client_secret": {secret}}
+		if res := postToken(t, form, ""); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected client_secret_post to be refused for a basic client, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("more than one authentication method", func(t *testing.T) {
+		t.Parallel()
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {"x"}, "client_secret": {secret}}
+		if res := postToken(t, form, secret); res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("userinfo requires a token", func(t *testing.T) {
+		t.Parallel()
+		res, err := ts.Client().Get(ts.URL + "/userinfo")
+		if err != nil {
+			t.Fatalf("userinfo: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(res.Header.Get("WWW-Authenticate"), "Bearer") {
+			t.Fatalf("expected bearer challenge, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("discovery and keys are published", func(t *testing.T) {
+		t.Parallel()
+		for _, path := range []string{"/.well-known/openid-configuration", "/jwks.json"} {
+			res, err := ts.Client().Get(ts.URL + path)
+			if err != nil {
+				t.Fatalf("get %s: %v", path, err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("expected JSON from %s, got %d %q", path, res.StatusCode, res.Header.Get("Content-Type"))
+			}
+		}
+	})
+}
+
+func TestAuthorizationServerPrivateKeyJWT(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, _, _ := newAuthorizationServerTestServer(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	client, _, err := srv.authorizationServer.Clients().Register(context.Background(), oauth.ClientRegistration{
+		Name:         "Example App",
+		AuthMethod:   oauth.AuthMethodPrivateKeyJWT,
+		RedirectURIs: []string{oauthTestRedirectURI},
+		JWKS:         &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "k1", Algorithm: string(jose.ES256)}}},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	query := url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {oauthTestRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	res := authorizeAs(t, srv, "/oauth2/authorize?"+query.Encode(), true)
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("expected code in callback, got %q", res.Header.Get("Location"))
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "k1"))
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	assertion, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   client.ID,
+		Subject:  client.ID,
+		Audience: jwt.Audience{ts.URL + "/oauth2/token"},
+		ID:       "assertion-1",
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).Serialize()
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+
+	form := url.Values{
+		"grant_type":            {"authorization_code"},
+		"code":                  {callback.Query().Get("code")},
+		"redirect_uri":          {oauthTestRedirectURI},
+		"code_verifier":         {verifier},
+		"client_assertion_type": {oauth.ClientAssertionTypeJWTBearer},
+		"client_assertion":      {assertion},
+	}
+	token, err := ts.Client().PostForm(ts.URL+"/oauth2/token", form)
+	if err != nil {
+		t.Fatalf("token request: %v", err)
+	}
+	defer token.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.NewDecoder(token.Body).Decode(&body); err != nil || token.StatusCode != http.StatusOK || body.AccessToken == "" || body.IDToken == "" {
+		t.Fatalf("expected tokens, got %d %+v %v", token.StatusCode, body, err)
+	}
+
+	form.Set("client_assertion_type", "urn:example:other")
+	rejected, err := ts.Client().PostForm(ts.URL+"/oauth2/token", form)
+	if err != nil {
+		t.Fatalf("token request: %v", err)
+	}
+	rejected.Body.Close()
+	if rejected.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected unknown assertion type to be rejected, got %d", rejected.StatusCode)
+	}
+}
+
+func TestTokenIntrospectionAndRevocation(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, resourceServer, resourceSecret := newAuthorizationServerTestServer(t)
+	client, secret, err := srv.authorizationServer.Clients().Register(context.Background(), oauth.ClientRegistration{
+		Name:         "Example App Reports",
+		RedirectURIs: []string{oauthTestRedirectURI},
+		GrantTypes:   []string{oauth.GrantTypeAuthorizationCode, oauth.GrantTypeRefreshToken},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	post := func(t *testing.T, path string, form url.Values, clientID, clientSecret string, out any) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if clientSecret != "" {
+			req.SetBasicAuth(clientID, clientSecret)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		defer res.Body.Close()
+		if res.Header.Get("Cache-Control") != "no-store" {
+			t.Fatalf("expected %s responses not to be cached", path)
+		}
+		if out != nil {
+			if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+				t.Fatalf("decode %s: %v", path, err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	authURL := ts.URL + "/oauth2/authorize?" + url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {oauthTestRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(verifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	res := authorizeAs(t, srv, authURL, true)
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("expected an authorization code, got %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	var tokens oauth.TokenResponse
+	status := post(t, "/oauth2/token", url.Values{
+		"grant_type":    {oauth.GrantTypeAuthorizationCode},
+		"code":          {callback.Query().Get("code")},
+		"redirect_uri":  {oauthTestRedirectURI},
+		"code_verifier": {verifier},
+	}, client.ID, secret, &tokens)
+	if status != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("unexpected token response %d %+v", status, tokens)
+	}
+
+	var introspection oauth.IntrospectionResponse
+	if status := post(t, oauth.IntrospectionPath, url.Values{"token": {tokens.AccessToken}}, resourceServer.ID, resourceSecret, &introspection); status != http.StatusOK {
+		t.Fatalf("introspect: %d", status)
+	}
+	if !introspection.Active || introspection.ClientID != client.ID || introspection.Scope != "openid email" || introspection.Subject == "" {
+		t.Fatalf("unexpected introspection %+v", introspection)
+	}
+	downstream, err := identity.New(identity.Config{Issuer: ts.URL, Audience: resourceServer.ID, ClientSecret: resourceSecret, HTTPClient: ts.Client()})
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	caller, err := downstream.VerifyToken(context.Background(), tokens.AccessToken)
+	if err != nil || caller.Subject != introspection.Subject || caller.ClientID != client.ID || !caller.HasScope("email") {
+		t.Fatalf("expected the identity package to accept the opaque token, got %+v (%v)", caller, err)
+	}
+	if status := post(t, oauth.IntrospectionPath, url.Values{"token": {tokens.AccessToken}}, resourceServer.ID, "wrong", nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected bad credentials to be refused, got %d", status)
+	}
+	if status := post(t, oauth.IntrospectionPath, url.Values{"token": {tokens.AccessToken}, "client_id": {client.ID}}, "", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected a confidential client without credentials to be refused, got %d", status)
+	}
+
+	if status := post(t, oauth.RevocationPath, url.Values{"token": {"unknown"}}, client.ID, secret, nil); status != http.StatusOK {
+		t.Fatalf("expected unknown tokens to be acknowledged, got %d", status)
+	}
+	if status := post(t, oauth.RevocationPath, url.Values{"token": {tokens.RefreshToken}, "token_type_hint": {"refresh_token"}}, client.ID, secret, nil); status != http.StatusOK {
+		t.Fatalf("revoke: %d", status)
+	}
+	introspection = oauth.IntrospectionResponse{}
+	post(t, oauth.IntrospectionPath, url.Values{"token": {tokens.AccessToken}}, resourceServer.ID, resourceSecret, &introspection)
+	if introspection.Active {
+		t.Fatal("expected the access token to be revoked with its refresh token")
+	}
+	if _, err := downstream.VerifyToken(context.Background(), tokens.AccessToken); !errors.Is(err, identity.ErrInvalidCredentials) {
+		t.Fatalf("expected the identity package to refuse the revoked token, got %v", err)
+	}
+	var refreshErr map[string]string
+	if status := post(t, "/oauth2/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, client.ID, secret, &refreshErr); status != http.StatusBadRequest || refreshErr["error"] != oauth.ErrorInvalidGrant {
+		t.Fatalf("expected the revoked refresh token to be rejected, got %d %v", status, refreshErr)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/admin/api/clients/"+client.ID+"/revocations", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+oauthTestAdminToken)
+	res, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("list revocations: %v", err)
+	}
+	defer res.Body.Close()
+	var audit struct {
+		Revocations []struct {
+			TokenType    string `json:"token_type"`
+			UserID       string `json:"user_id"`
+			AccessTokens int    `json:"access_tokens_revoked"`
+		} `json:"revocations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&audit); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("decode revocations: %d %v", res.StatusCode, err)
+	}
+	if len(audit.Revocations) != 1 || audit.Revocations[0].TokenType != "refresh_token" || audit.Revocations[0].AccessTokens != 1 || audit.Revocations[0].UserID != introspection.Subject && audit.Revocations[0].UserID == "" {
+		t.Fatalf("unexpected revocation log %+v", audit)
+	}
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, resourceServer, _ := newAuthorizationServerTestServer(t)
+	client, secret, err := srv.authorizationServer.Clients().Register(context.Background(), oauth.ClientRegistration{
+		Name:       "Billing",
+		GrantTypes: []string{oauth.GrantTypeClientCredentials},
+		Scopes:     []string{"reports:read", "reports:write"},
+		Audiences:  []string{resourceServer.ID},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	requestToken := func(t *testing.T, form url.Values, out any) int {
+		t.Helper()
+		form.Set("grant_type", oauth.GrantTypeClientCredentials)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+oauth.TokenPath, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, secret)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request token: %v", err)
+		}
+		defer res.Body.Close()
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("decode token response: %v", err)
+		}
+		return res.StatusCode
+	}
+
+	var tokens oauth.TokenResponse
+	if status := requestToken(t, url.Values{"scope": {"reports:read"}, "audience": {resourceServer.ID}}, &tokens); status != http.StatusOK {
+		t.Fatalf("expected a token, got %d", status)
+	}
+	if tokens.Scope != "reports:read" || tokens.RefreshToken != "" || tokens.IDToken != "" {
+		t.Fatalf("unexpected token response %+v", tokens)
+	}
+
+	// The resource server verifies the token offline with the published keys.
+	verifier, err := identity.New(identity.Config{Issuer: ts.URL, Audience: resourceServer.ID, HTTPClient: ts.Client()})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	caller, err := verifier.VerifyToken(context.Background(), tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("verify token: %v", err)
+	}
+	if !caller.IsService() || caller.Subject != client.ID || !caller.HasScope("reports:read") || caller.HasScope("reports:write") {
+		t.Fatalf("unexpected caller %+v", caller)
+	}
+
+	var refused map[string]string
+	if status := requestToken(t, url.Values{"scope": {"openid"}}, &refused); status != http.StatusBadRequest || refused["error"] != oauth.ErrorInvalidScope {
+		t.Fatalf("expected user scopes to be refused, got %d %v", status, refused)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/admin/api/clients/"+client.ID+"/issuances", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+oauthTestAdminToken)
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("list issuances: %v", err)
+	}
+	defer res.Body.Close()
+	var audit struct {
+		Issuances []struct {
+			ID       string `json:"jti"`
+			Audience string `json:"audience"`
+			Scope    string `json:"scope"`
+		} `json:"issuances"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&audit); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("decode issuances: %d %v", res.StatusCode, err)
+	}
+	if len(audit.Issuances) != 1 || audit.Issuances[0].ID == "" || audit.Issuances[0].Audience != resourceServer.ID || audit.Issuances[0].Scope != "reports:read" {
+		t.Fatalf("unexpected issuance log %+v", audit)
+	}
+}
+
+func TestAdminClientsAPI(t *testing.T) {
+	t.Parallel()
+
+	_, ts, _, _ := newAuthorizationServerTestServer(t)
+	call := func(t *testing.T, method, path, token, body string, out any) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer res.Body.Close()
+		if out != nil {
+			if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+				t.Fatalf("decode %s %s: %v", method, path, err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	if status := call(t, http.MethodGet, "/admin/api/clients", "", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", status)
+	}
+	if status := call(t, http.MethodGet, "/admin/api/clients", "wrong", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", status)
+	}
+
+	var created clientResource
+	status := call(t, http.MethodPost, "/admin/api/clients", oauthTestAdminToken,
+		`{"client_name":"Reports","redirect_uris":["https://reports.example.test/callback"],"token_endpoint_auth_method":"client_secret_post","scope":"openid email"}`, &created)
+	if status != http.StatusCreated || created.ID == "" || created.ClientSecret == "" || created.AuthMethod != "client_secret_post" || created.Scope != "openid email" {
+		t.Fatalf("unexpected create response %d %+v", status, created)
+	}
+	path := "/admin/api/clients/" + created.ID
+
+	var fetched clientResource
+	if status := call(t, http.MethodGet, path, oauthTestAdminToken, "", &fetched); status != http.StatusOK || fetched.ClientSecret != "" || len(fetched.Secrets) != 1 {
+		t.Fatalf("expected client without its secret, got %d %+v", status, fetched)
+	}
+
+	var list struct {
+		Clients []clientResource `json:"clients"`
+	}
+	if status := call(t, http.MethodGet, "/admin/api/clients", oauthTestAdminToken, "", &list); status != http.StatusOK || len(list.Clients) != 2 {
+		t.Fatalf("expected both clients, got %d %+v", status, list)
+	}
+
+	var updated clientResource
+	status = call(t, http.MethodPut, path, oauthTestAdminToken, `{"client_name":"Reporting","redirect_uris":["https://reports.example.test/cb"]}`, &updated)
+	if status != http.StatusOK || updated.Name != "Reporting" || updated.AuthMethod != "client_secret_basic" || !slices.Equal(updated.RedirectURIs, []string{"https://reports.example.test/cb"}) {
+		t.Fatalf("unexpected update response %d %+v", status, updated)
+	}
+
+	var rotated map[string]string
+	if status := call(t, http.MethodPost, path+"/secrets", oauthTestAdminToken, `{"overlap":"1h"}`, &rotated); status != http.StatusCreated || rotated["client_secret"] == "" {
+		t.Fatalf("unexpected rotate response %d %v", status, rotated)
+	}
+	if status := call(t, http.MethodGet, path, oauthTestAdminToken, "", &fetched); status != http.StatusOK || len(fetched.Secrets) != 2 || fetched.Secrets[0].ExpiresAt == nil {
+		t.Fatalf("expected the old secret to expire after the overlap, got %+v", fetched.Secrets)
+	}
+
+	var problem map[string]string
+	if status := call(t, http.MethodPost, "/admin/api/clients", oauthTestAdminToken, `{"client_name":"Bad","redirect_uris":["http://evil.example.test/cb"]}`, &problem); status != http.StatusBadRequest || problem["error"] != "invalid_client_metadata" {
+		t.Fatalf("expected invalid_client_metadata, got %d %v", status, problem)
+	}
+	if status := call(t, http.MethodPost, "/admin/api/clients", oauthTestAdminToken, `{"client_name":"Bad","redirect_uri":"typo"}`, nil); status != http.StatusBadRequest {
+		t.Fatalf("expected unknown fields to be rejected, got %d", status)
+	}
+
+	if status := call(t, http.MethodDelete, path, oauthTestAdminToken, "", nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d", status)
+	}
+	if status := call(t, http.MethodGet, path, oauthTestAdminToken, "", nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", status)
+	}
+}
+
+func TestRevokeApplicationAccess(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, client, secret := newAuthorizationServerTestServer(t)
+	session := signedInAs(t, srv, seedEmail)
+
+	verifier := oauth2.GenerateVerifier()
+	res := authorizeAs(t, srv, ts.URL+"/oauth2/authorize?"+url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {oauthTestRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(verifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode(), true)
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("expected an authorization code, got %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	tokens, err := srv.authorizationServer.Exchange(context.Background(), oauth.TokenRequest{
+		GrantType:        oauth.GrantTypeAuthorizationCode,
+		Code:             callback.Query().Get("code"),
+		RedirectURI:      oauthTestRedirectURI,
+		CodeVerifier:     verifier,
+		ClientID:         client.ID,
+		ClientAuthMethod: oauth.AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+
+	action := applicationsPath + "/" + client.ID + "/revoke"
+	rr := httptest.NewRecorder()
+	srv.dashboardPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), session))
+	if body := rr.Body.String(); !strings.Contains(body, "Example App") || !strings.Contains(body, `action="`+action+`"`) {
+		t.Fatalf("expected the dashboard to list the authorized application, got %s", body)
+	}
+
+	revoke := func(r *http.Request) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("clientID", client.ID)
+		rr := httptest.NewRecorder()
+		srv.revokeApplicationHandler()(rr, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx)))
+		return rr
+	}
+	// A personal access token cannot stand in for the session here.
+	withToken := attachSession(httptest.NewRequest(http.MethodPost, action, nil), session)
+	withToken = withToken.WithContext(context.WithValue(withToken.Context(), personalTokenContextKey{}, auth.PersonalToken{}))
+	if rr := revoke(withToken); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a personal access token to be refused, got %d", rr.Code)
+	}
+
+	if rr := revoke(attachSession(httptest.NewRequest(http.MethodPost, action, nil), session)); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected revoke to redirect, got %d", rr.Code)
+	}
+	if _, err := srv.authorizationServer.UserInfo(context.Background(), tokens.AccessToken); !errors.Is(err, oauth.ErrInvalidToken) {
+		t.Fatalf("expected the access token to be revoked, got %v", err)
+	}
+	if log, _ := srv.authorizationServer.Clients().Revocations(context.Background(), client.ID, 0); len(log) != 1 {
+		t.Fatalf("expected the revocation to be recorded, got %+v", log)
+	}
+	if rr := revoke(attachSession(httptest.NewRequest(http.MethodPost, action, nil), session)); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a revoked application to be gone, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.dashboardPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), session))
+	if strings.Contains(rr.Body.String(), action) {
+		t.Fatal("expected the revoked application to leave the dashboard")
+	}
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	t.Parallel()
+
+	srv, ts, _, _ := newAuthorizationServerTestServer(t)
+	cli, _, err := srv.authorizationServer.Clients().Register(context.Background(), oauth.ClientRegistration{
+		Name:       "Deploy CLI",
+		Type:       oauth.ClientPublic,
+		GrantTypes: []string{oauth.GrantTypeDeviceCode},
+	})
+	if err != nil {
+		t.Fatalf("register device client: %v", err)
+	}
+
+	start := func(t *testing.T) oauth.DeviceAuthorizationResponse {
+		t.Helper()
+		res, err := ts.Client().PostForm(ts.URL+oauth.DeviceAuthorizationPath, url.Values{"client_id": {cli.ID}, "scope": {"openid email"}})
+		if err != nil {
+			t.Fatalf("device authorization request: %v", err)
+		}
+		defer res.Body.Close()
+		var body oauth.DeviceAuthorizationResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.StatusCode != http.StatusOK || body.DeviceCode == "" {
+			t.Fatalf("unexpected device authorization response %d %+v %v", res.StatusCode, body, err)
+		}
+		if body.VerificationURI != ts.URL+"/device" || res.Header.Get("Cache-Control") != "no-store" {
+			t.Fatalf("unexpected verification uri %q", body.VerificationURI)
+		}
+		return body
+	}
+	poll := func(t *testing.T, deviceCode string) (int, map[string]any) {
+		t.Helper()
+		res, err := ts.Client().PostForm(ts.URL+"/oauth2/token", url.Values{
+			"grant_type":  {oauth.GrantTypeDeviceCode},
+			"device_code": {deviceCode},
+			"client_id":   {cli.ID},
+		})
+		if err != nil {
+			t.Fatalf("token request: %v", err)
+		}
+		defer res.Body.Close()
+		var body map[string]any
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("decode token response: %v", err)
+		}
+		return res.StatusCode, body
+	}
+	session := signedInAs(t, srv, seedEmail)
+	submit := func(t *testing.T, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.deviceDecisionHandler()(rr, attachSession(req, session))
+		return rr
+	}
+
+	t.Run("polling before approval", func(t *testing.T) {
+		t.Parallel()
+		device := start(t)
+		if status, body := poll(t, device.DeviceCode); status != http.StatusBadRequest || body["error"] != oauth.ErrorAuthorizationPending {
+			t.Fatalf("expected authorization_pending, got %d %v", status, body)
+		}
+		if status, body := poll(t, device.DeviceCode); status != http.StatusBadRequest || body["error"] != oauth.ErrorSlowDown {
+			t.Fatalf("expected slow_down when polling again at once, got %d %v", status, body)
+		}
+	})
+
+	t.Run("verification requires sign in", func(t *testing.T) {
+		t.Parallel()
+		device := start(t)
+		rr := httptest.NewRecorder()
+		srv.deviceVerificationHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/device?user_code="+device.UserCode, nil), SessionState{CSRFToken: "csrf"}))
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/?return_to="+url.QueryEscape("/device?user_code="+device.UserCode) {
+			t.Fatalf("expected redirect to sign in, got %d %q", rr.Code, rr.Header().Get("Location"))
+		}
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		t.Parallel()
+		rr := submit(t, url.Values{"user_code": {"BCDF-GHJK"}})
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid or has expired") {
+			t.Fatalf("expected the code to be rejected, got %d", rr.Code)
+		}
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		t.Parallel()
+		device := start(t)
+		rr := httptest.NewRecorder()
+		srv.deviceVerificationHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/device?user_code="+strings.ToLower(device.UserCode), nil), session))
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Deploy CLI") || !strings.Contains(rr.Body.String(), device.UserCode) {
+			t.Fatalf("expected confirmation page, got %d %s", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("X-Frame-Options") != "DENY" {
+			t.Fatal("expected confirmation page to forbid framing")
+		}
+
+		rr = submit(t, url.Values{"user_code": {device.UserCode}, "decision": {"allow"}})
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Device connected") {
+			t.Fatalf("expected approval, got %d %s", rr.Code, rr.Body.String())
+		}
+		status, body := poll(t, device.DeviceCode)
+		if status != http.StatusOK || body["access_token"] == nil || body["id_token"] == nil || body["scope"] != "openid email" {
+			t.Fatalf("expected tokens after approval, got %d %v", status, body)
+		}
+		if rr := submit(t, url.Values{"user_code": {device.UserCode}, "decision": {"allow"}}); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected a used code to be rejected, got %d", rr.Code)
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		t.Parallel()
+		device := start(t)
+		rr := submit(t, url.Values{"user_code": {device.UserCode}, "decision": {"deny"}})
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Request denied") {
+			t.Fatalf("expected denial, got %d %s", rr.Code, rr.Body.String())
+		}
+		if status, body := poll(t, device.DeviceCode); status != http.StatusBadRequest || body["error"] != oauth.ErrorAccessDenied {
+			t.Fatalf("expected access_denied, got %d %v", status, body)
+		}
+	})
+
+	t.Run("client without the grant", func(t *testing.T) {
+		t.Parallel()
+		res, err := ts.Client().PostForm(ts.URL+oauth.DeviceAuthorizationPath, url.Values{"client_id": {"unknown"}})
+		if err != nil {
+			t.Fatalf("device authorization request: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for an unknown client, got %d", res.StatusCode)
+		}
+	})
+}
+
+func TestLoginSessionVerifiesWithIdentityPackage(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	form := url.Values{"email": {seedEmail}, "password": {seedPassword}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	srv.loginHandler()(rr, attachSession(req, SessionState{CSRFToken: "csrf"}))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected login to succeed, got %d", rr.Code)
+	}
+
+	verifier, err := identity.New(identity.Config{SessionSecret: srv.configuration.SessionSecret})
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	downstream := httptest.NewRequest(http.MethodGet, "/", nil)
+	cookies := rr.Result().Cookies()
+	downstream.AddCookie(cookies[len(cookies)-1])
+	got, err := verifier.Verify(downstream)
+	if err != nil {
+		t.Fatalf("verify session cookie: %v", err)
+	}
+	account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail(seedEmail))
+	if err != nil {
+		t.Fatalf("lookup account: %v", err)
+	}
+	if got.Subject != account.ID || got.Email != seedEmail || got.Source != identity.SourceSession {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+
+	ts := httptest.NewServer(srv.Router())
+	t.Cleanup(ts.Close)
+	online, err := identity.New(identity.Config{Issuer: ts.URL, Audience: "orders-service", HTTPClient: ts.Client()})
+	if err != nil {
+		t.Fatalf("create online verifier: %v", err)
+	}
+	got, err = online.Verify(downstream)
+	if err != nil {
+		t.Fatalf("verify session cookie online: %v", err)
+	}
+	if got.Subject != account.ID || got.Email != seedEmail || got.Source != identity.SourceSession {
+		t.Fatalf("unexpected online identity %+v", got)
+	}
+}
+
+func TestJSONAPI(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	srv.configuration.CORSAllowedOrigins = []string{"https://app.example.com"}
+	router := srv.Router()
+
+	call := func(method, path, body string, headers map[string]string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expectProblem := func(rr *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("expected status %d, got %d: %s", status, rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != problemContentType {
+			t.Fatalf("expected problem content type, got %q", ct)
+		}
+		var body problem
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("decode problem: %v", err)
+		}
+		if body.Code != code || body.Status != status || body.Type != "about:blank" || body.Title == "" {
+			t.Fatalf("expected problem %q, got %+v", code, body)
+		}
+	}
+	sessionCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		t.Helper()
+		cookies := rr.Result().Cookies()
+		for i := len(cookies) - 1; i >= 0; i-- {
+			if cookies[i].Name == sessionCookieName {
+				return cookies[i]
+			}
+		}
+		t.Fatal("expected session cookie")
+		return nil
+	}
+
+	rr := call(http.MethodPost, apiPrefix+"/signup", `{"email":"api@example.com","password":"Password123"}`, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected signup to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created apiUser
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if created.ID == "" || created.Email != "api@example.com" {
+		t.Fatalf("unexpected user %+v", created)
+	}
+	if state := sessionFromResponse(t, srv, rr.Result()); !state.Authenticated || state.UserID != created.ID {
+		t.Fatalf("expected signup to sign the session in, got %+v", state)
+	}
+
+	expectProblem(call(http.MethodPost, apiPrefix+"/signup", `{"email":"api@example.com","password":"Password123"}`, nil), http.StatusConflict, "email_exists")
+	expectProblem(call(http.MethodPost, apiPrefix+"/signup", `{"email":"weak@example.com","password":"short"}`, nil), http.StatusBadRequest, "weak_password")
+	expectProblem(call(http.MethodPost, apiPrefix+"/signup", `{"password":"Password123"}`, nil), http.StatusBadRequest, "email_required")
+	expectProblem(call(http.MethodPost, apiPrefix+"/login", `{"email":"`+seedEmail+`","password":"Wrong12345"}`, nil), http.StatusUnauthorized, "invalid_credentials")
+	expectProblem(call(http.MethodPost, apiPrefix+"/login", `{"email":`, nil), http.StatusBadRequest, problemInvalidRequest)
+	expectProblem(call(http.MethodPost, apiPrefix+"/login", "", nil), http.StatusUnsupportedMediaType, problemUnsupportedMediaType)
+	expectProblem(call(http.MethodGet, apiPrefix+"/me", "", nil), http.StatusUnauthorized, problemUnauthenticated)
+	expectProblem(call(http.MethodGet, apiPrefix+"/missing", "", nil), http.StatusNotFound, problemNotFound)
+
+	anonymous := sessionCookie(call(http.MethodGet, apiPrefix+"/me", "", nil))
+	planted, err := decodeSession(anonymous.Value, srv.configuration.SessionSecret)
+	if err != nil {
+		t.Fatalf("decode anonymous session: %v", err)
+	}
+	rr = call(http.MethodPost, apiPrefix+"/login", `{"email":"`+seedEmail+`","password":"`+seedPassword+`"}`, nil, anonymous)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	cookie := sessionCookie(rr)
+	if state := sessionFromResponse(t, srv, rr.Result()); state.CSRFToken == "" || state.CSRFToken == planted.CSRFToken {
+		t.Fatalf("expected login to issue a fresh CSRF token, got %+v", state)
+	}
+
+	rr = call(http.MethodGet, apiPrefix+"/me", "", nil, cookie)
+	var me apiUser
+	if rr.Code != http.StatusOK || json.NewDecoder(rr.Body).Decode(&me) != nil || me.Email != seedEmail {
+		t.Fatalf("expected current user, got %d %+v", rr.Code, me)
+	}
+
+	rr = call(http.MethodPost, apiPrefix+"/logout", "", nil, cookie)
+	if rr.Code != http.StatusNoContent || sessionCookie(rr).MaxAge >= 0 {
+		t.Fatalf("expected logout to clear the session, got %d", rr.Code)
+	}
+
+	t.Run("cors", func(t *testing.T) {
+		preflight := map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": http.MethodPost}
+		rr := call(http.MethodOptions, apiPrefix+"/login", "", preflight)
+		if rr.Code != http.StatusNoContent ||
+			rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+			rr.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+			!strings.Contains(rr.Header().Get("Access-Control-Allow-Headers"), "Content-Type") {
+			t.Fatalf("unexpected preflight response %d %v", rr.Code, rr.Header())
+		}
+
+		preflight["Origin"] = "https://evil.example.net"
+		expectProblem(call(http.MethodOptions, apiPrefix+"/login", "", preflight), http.StatusForbidden, problemOriginNotAllowed)
+		expectProblem(call(http.MethodPost, apiPrefix+"/logout", "", map[string]string{"Origin": "https://evil.example.net"}, cookie), http.StatusForbidden, problemOriginNotAllowed)
+
+		rr = call(http.MethodPost, apiPrefix+"/login", `{"email":"`+seedEmail+`","password":"`+seedPassword+`"}`, map[string]string{"Origin": "https://app.example.com"})
+		if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Fatalf("expected allowed origin to sign in, got %d %v", rr.Code, rr.Header())
+		}
+		rr = call(http.MethodGet, apiPrefix+"/me", "", map[string]string{"Origin": "https://evil.example.net"}, cookie)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatal("expected no CORS grant for an unlisted origin")
+		}
+	})
+}
+
+// openAPIDocument is the part of the OpenAPI document the contract test reads.
+type openAPIDocument struct {
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+		Responses   map[string]struct {
+			Content map[string]struct {
+				Schema map[string]any `json:"schema"`
+			} `json:"content"`
+		} `json:"responses"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]map[string]any `json:"schemas"`
+	} `json:"components"`
+}
+
+// contractDoer checks every response the generated client receives against
+// the OpenAPI document and records which documented responses were seen.
+type contractDoer struct {
+	t      *testing.T
+	doc    openAPIDocument
+	client *http.Client
+	seen   map[string]bool
+}
+
+func (d *contractDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	method := strings.ToLower(req.Method)
+	operation, ok := d.doc.Paths[req.URL.Path][method]
+	if !ok {
+		d.t.Errorf("%s %s is not in the OpenAPI document", req.Method, req.URL.Path)
+		return resp, nil
+	}
+	status := strconv.Itoa(resp.StatusCode)
+	documented, ok := operation.Responses[status]
+	if !ok {
+		d.t.Errorf("%s answered %s, which is not documented: %s", operation.OperationID, status, body)
+		return resp, nil
+	}
+	d.seen[operation.OperationID+" "+status] = true
+
+	if len(documented.Content) == 0 {
+		if len(body) != 0 {
+			d.t.Errorf("%s %s: expected no body, got %s", operation.OperationID, status, body)
+		}
+		return resp, nil
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	content, ok := documented.Content[mediaType]
+	if !ok {
+		d.t.Errorf("%s %s: content type %q is not documented", operation.OperationID, status, mediaType)
+		return resp, nil
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		d.t.Errorf("%s %s: decode body: %v", operation.OperationID, status, err)
+		return resp, nil
+	}
+	if err := d.validate(content.Schema, value, "body"); err != nil {
+		d.t.Errorf("%s %s: %v", operation.OperationID, status, err)
+	}
+	return resp, nil
+}
+
+// validate checks value against the subset of JSON Schema the document uses.
+func (d *contractDoer) validate(schema map[string]any, value any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		target, ok := d.doc.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+		return d.validate(target, value, path)
+	}
+	if constant, ok := schema["const"]; ok && value != constant {
+		return fmt.Errorf("%s: expected %v, got %v", path, constant, value)
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%s: %v is not one of the documented values", path, value)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, value)
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, field := range object {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: undocumented property %q", path, name)
+				}
+				continue
+			}
+			if err := d.validate(property, field, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, value)
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			if err := d.validate(itemSchema, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", path, text)
+			}
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected integer, got %v", path, value)
+		}
+	}
+	return nil
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	t.Parallel()
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatalf("decode OpenAPI document: %v", err)
+	}
+
+	srv := newTestServer(t)
+	srv.configuration.CORSAllowedOrigins = []string{"https://app.example.com"}
+	router := srv.Router()
+
+	// Every API route is documented and every documented operation is routed.
+	routed := map[string]bool{}
+	if err := chi.Walk(router.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, apiPrefix+"/") {
+			routed[strings.ToLower(method)+" "+route] = true
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[method+" "+path] = true
+		}
+	}
+	if !maps.Equal(routed, documented) {
+		t.Fatalf("routes %v do not match documented operations %v", slices.Sorted(maps.Keys(routed)), slices.Sorted(maps.Keys(documented)))
+	}
+
+	// Every problem code the handlers emit is in the ProblemCode enum, and
+	// the enum lists nothing else.
+	codes := []any{problemInvalidRequest, problemUnsupportedMediaType, problemUnauthenticated, problemOriginNotAllowed, problemNotFound, problemMethodNotAllowed, problemInternal}
+	for _, mapped := range apiErrors {
+		codes = append(codes, mapped.code)
+	}
+	enum, _ := doc.Components.Schemas["ProblemCode"]["enum"].([]any)
+	if !slices.Equal(slices.SortedFunc(slices.Values(codes), compareAny), slices.SortedFunc(slices.Values(enum), compareAny)) {
+		t.Fatalf("problem codes %v do not match the ProblemCode enum %v", codes, enum)
+	}
+
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+	doer := &contractDoer{t: t, doc: doc, seen: map[string]bool{}}
+	newClient := func() *client.ClientWithResponses {
+		t.Helper()
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("create cookie jar: %v", err)
+		}
+		doer := *doer
+		doer.client = &http.Client{Jar: jar}
+		c, err := client.NewClientWithResponses(httpServer.URL, client.WithHTTPClient(&doer))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		return c
+	}
+	foreignOrigin := func(_ context.Context, req *http.Request) error {
+		req.Header.Set("Origin", "https://evil.example.net")
+		return nil
+	}
+
+	ctx := t.Context()
+	session := newClient()
+	credentials := client.Credentials{Email: "contract@example.com", Password: "Password123"}
+
+	signup, err := session.SignupWithResponse(ctx, credentials)
+	if err != nil || signup.JSON201 == nil || signup.JSON201.Email != credentials.Email {
+		t.Fatalf("expected signup to succeed, got %v %s", err, signup.Body)
+	}
+	if me, err := session.GetCurrentUserWithResponse(ctx); err != nil || me.JSON200 == nil || me.JSON200.Id != signup.JSON201.Id {
+		t.Fatalf("expected the new account, got %v %s", err, me.Body)
+	}
+	if logout, err := session.LogoutWithResponse(ctx); err != nil || logout.StatusCode() != http.StatusNoContent {
+		t.Fatalf("expected logout to succeed, got %v", err)
+	}
+	if me, err := session.GetCurrentUserWithResponse(ctx); err != nil || me.ApplicationproblemJSON401 == nil || me.ApplicationproblemJSON401.Code != client.Unauthenticated {
+		t.Fatalf("expected signed-out session to be refused, got %v %s", err, me.Body)
+	}
+	if login, err := session.LoginWithResponse(ctx, credentials); err != nil || login.JSON200 == nil {
+		t.Fatalf("expected login to succeed, got %v", err)
+	}
+
+	expectCode := func(name string, problem *client.Problem, body []byte, err error, want client.ProblemCode) {
+		t.Helper()
+		if err != nil || problem == nil || problem.Code != want {
+			t.Fatalf("%s: expected %s, got %v %s", name, want, err, body)
+		}
+	}
+	anonymous := newClient()
+	wrong := client.Credentials{Email: credentials.Email, Password: "Wrong12345"}
+	login, err := anonymous.LoginWithResponse(ctx, wrong)
+	expectCode("wrong password", login.ApplicationproblemJSON401, login.Body, err, client.InvalidCredentials)
+	login, err = anonymous.LoginWithResponse(ctx, client.Credentials{Password: "Password123"})
+	expectCode("missing email", login.ApplicationproblemJSON400, login.Body, err, client.EmailRequired)
+	login, err = anonymous.LoginWithBodyWithResponse(ctx, "text/plain", strings.NewReader("{}"))
+	expectCode("login media type", login.ApplicationproblemJSON415, login.Body, err, client.UnsupportedMediaType)
+	login, err = anonymous.LoginWithResponse(ctx, credentials, foreignOrigin)
+	expectCode("login origin", login.ApplicationproblemJSON403, login.Body, err, client.OriginNotAllowed)
+
+	signup, err = anonymous.SignupWithResponse(ctx, credentials)
+	expectCode("duplicate email", signup.ApplicationproblemJSON409, signup.Body, err, client.EmailExists)
+	signup, err = anonymous.SignupWithResponse(ctx, client.Credentials{Email: "weak@example.com", Password: "short"})
+	expectCode("weak password", signup.ApplicationproblemJSON400, signup.Body, err, client.WeakPassword)
+	signup, err = anonymous.SignupWithBodyWithResponse(ctx, "text/plain", strings.NewReader("{}"))
+	expectCode("signup media type", signup.ApplicationproblemJSON415, signup.Body, err, client.UnsupportedMediaType)
+	signup, err = anonymous.SignupWithResponse(ctx, credentials, foreignOrigin)
+	expectCode("signup origin", signup.ApplicationproblemJSON403, signup.Body, err, client.OriginNotAllowed)
+
+	logout, err := session.LogoutWithResponse(ctx, foreignOrigin)
+	expectCode("logout origin", logout.ApplicationproblemJSON403, logout.Body, err, client.OriginNotAllowed)
+
+	// Every documented response except unexpected failures was produced.
+	for path, operations := range doc.Paths {
+		for _, operation := range operations {
+			for status := range operation.Responses {
+				if status != "500" && !doer.seen[operation.OperationID+" "+status] {
+					t.Errorf("%s %s: documented response %s was never produced", path, operation.OperationID, status)
+				}
+			}
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), api.OpenAPI) {
+		t.Fatalf("expected the OpenAPI document at %s, got %d", openAPIPath, rr.Code)
+	}
+}
+
+func compareAny(a, b any) int {
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func TestSCIM(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	sum := sha256.Sum256([]byte("acme-scim-token"))
+	srv.authService.ManageDirectoryDomains("acme", []string{"acme.test"})
+	srv.EnableSCIM(scim.NewService(scim.NewMemoryStore(), srv.authService, []scim.Tenant{{ID: "acme", Name: "Acme", TokenHash: sum[:]}}))
+	srv.configuration.PublicURL = "https://auth.example.com"
+	router := srv.Router()
+
+	call := func(t *testing.T, method, path, token, body string, out any) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/scim+json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if out != nil {
+			if err := json.NewDecoder(rr.Body).Decode(out); err != nil {
+				t.Fatalf("decode %s %s: %v", method, path, err)
+			}
+		}
+		return rr
+	}
+	const token = "acme-scim-token"
+
+	var failure map[string]any
+	rr := call(t, http.MethodPost, "/scim/v2/Users", "", `{"userName":"ada@acme.test"}`, &failure)
+	if rr.Code != http.StatusUnauthorized || failure["status"] != "401" || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 SCIM error without a token, got %d %v", rr.Code, failure)
+	}
+
+	var created scim.UserResource
+	rr = call(t, http.MethodPost, "/scim/v2/Users", token, `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "ada@acme.test",
+		"name": {"givenName": "Ada", "familyName": "Lovelace"},
+		"externalId": "00u1",
+		"active": true,
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "Math"}
+	}`, &created)
+	if rr.Code != http.StatusCreated || created.ID == "" || created.DisplayName != "Ada Lovelace" || rr.Header().Get("Content-Type") != "application/scim+json" {
+		t.Fatalf("unexpected create response %d %+v", rr.Code, created)
+	}
+	location := "https://auth.example.com/scim/v2/Users/" + created.ID
+	if rr.Header().Get("Location") != location || created.Meta.Location != location {
+		t.Fatalf("expected location %q, got %q and %+v", location, rr.Header().Get("Location"), created.Meta)
+	}
+	rr = call(t, http.MethodPost, "/scim/v2/Users", token, `{"userName":"ADA@acme.test"}`, &failure)
+	if rr.Code != http.StatusConflict || failure["scimType"] != "uniqueness" {
+		t.Fatalf("expected uniqueness conflict, got %d %v", rr.Code, failure)
+	}
+	rr = call(t, http.MethodPost, "/scim/v2/Users", token, `{"userName":"admin@example.com"}`, &failure)
+	if rr.Code != http.StatusBadRequest || failure["scimType"] != "invalidValue" {
+		t.Fatalf("expected a userName outside the tenant's domains to be rejected, got %d %v", rr.Code, failure)
+	}
+	if rr := call(t, http.MethodPost, "/scim/v2/Users", token, `{"userName":"grace@acme.test"}`, nil); rr.Code != http.StatusCreated {
+		t.Fatalf("create second user: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var list scim.ListResponse[scim.UserResource]
+	rr = call(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "ada@acme.test"`), token, "", &list)
+	if rr.Code != http.StatusOK || list.TotalResults != 1 || list.Resources[0].ID != created.ID {
+		t.Fatalf("expected filter to find ada, got %d %+v", rr.Code, list)
+	}
+	rr = call(t, http.MethodGet, "/scim/v2/Users?startIndex=2&count=1", token, "", &list)
+	if rr.Code != http.StatusOK || list.TotalResults != 2 || list.StartIndex != 2 || list.ItemsPerPage != 1 || list.Resources[0].UserName != "grace@acme.test" {
+		t.Fatalf("expected second page, got %d %+v", rr.Code, list)
+	}
+	rr = call(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName sw "ada"`), token, "", &failure)
+	if rr.Code != http.StatusBadRequest || failure["scimType"] != "invalidFilter" {
+		t.Fatalf("expected invalidFilter, got %d %v", rr.Code, failure)
+	}
+
+	dashboard := func() int {
+		req := attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), SessionState{Authenticated: true, Email: "ada@acme.test", UserID: created.ID})
+		rr := httptest.NewRecorder()
+		srv.dashboardPageHandler().ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if status := dashboard(); status != http.StatusOK {
+		t.Fatalf("expected provisioned account to load, got %d", status)
+	}
+
+	var patched scim.UserResource
+	rr = call(t, http.MethodPatch, "/scim/v2/Users/"+created.ID, token, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]
+	}`, &patched)
+	if rr.Code != http.StatusOK || patched.Active == nil || *patched.Active {
+		t.Fatalf("expected deactivated user, got %d %+v", rr.Code, patched)
+	}
+	if status := dashboard(); status != http.StatusForbidden {
+		t.Fatalf("expected the deactivated account's session to be refused, got %d", status)
+	}
+
+	var group scim.GroupResource
+	rr = call(t, http.MethodPost, "/scim/v2/Groups", token, `{"displayName":"Engineering","members":[{"value":"`+created.ID+`"}]}`, &group)
+	if rr.Code != http.StatusCreated || len(group.Members) != 1 {
+		t.Fatalf("unexpected group create response %d %+v", rr.Code, group)
+	}
+	var emptied scim.GroupResource
+	rr = call(t, http.MethodPatch, "/scim/v2/Groups/"+group.ID, token, `{"Operations":[{"op":"remove","path":"members[value eq \"`+created.ID+`\"]"}]}`, &emptied)
+	if rr.Code != http.StatusOK || emptied.DisplayName != "Engineering" || len(emptied.Members) != 0 {
+		t.Fatalf("expected member removed, got %d %+v", rr.Code, emptied)
+	}
+	rr = call(t, http.MethodPatch, "/scim/v2/Groups/"+group.ID, token, `{"Operations":[{"op":"add","path":"members","value":[{"value":"not-a-user"}]}]}`, &failure)
+	if rr.Code != http.StatusBadRequest || failure["scimType"] != "invalidValue" {
+		t.Fatalf("expected unknown member to be rejected, got %d %v", rr.Code, failure)
+	}
+
+	if rr := call(t, http.MethodPut, "/scim/v2/Users/"+created.ID, token, `not json`, nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected malformed body to be rejected, got %d", rr.Code)
+	}
+	if rr := call(t, http.MethodDelete, "/scim/v2/Users/"+created.ID, token, "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on delete, got %d", rr.Code)
+	}
+	if rr := call(t, http.MethodGet, "/scim/v2/Users/"+created.ID, token, "", &failure); rr.Code != http.StatusNotFound || failure["status"] != "404" {
+		t.Fatalf("expected 404 after delete, got %d %v", rr.Code, failure)
+	}
+	if status := dashboard(); status != http.StatusForbidden {
+		t.Fatalf("expected the released account to stay deactivated, got %d", status)
+	}
+}
+
+func TestSessionFollowsAccountAcrossEmailChange(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t)
+	sum := sha256.Sum256([]byte("acme-scim-token"))
+	srv.authService.ManageDirectoryDomains("acme", []string{"acme.test"})
+	srv.EnableSCIM(scim.NewService(scim.NewMemoryStore(), srv.authService, []scim.Tenant{{ID: "acme", Name: "Acme", TokenHash: sum[:]}}))
+	router := srv.Router()
+
+	provision := func(method, path, body string) scim.UserResource {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/scim+json")
+		req.Header.Set("Authorization", "Bearer acme-scim-token")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var user scim.UserResource
+		if rr.Code >= http.StatusBadRequest || json.NewDecoder(rr.Body).Decode(&user) != nil {
+			t.Fatalf("%s %s: unexpected response %d %s", method, path, rr.Code, rr.Body.String())
+		}
+		return user
+	}
+	dashboard := func(session SessionState) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.dashboardPageHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, "/dashboard", nil), session))
+		return rr
+	}
+
+	original := provision(http.MethodPost, "/scim/v2/Users", `{"userName":"ada@acme.test"}`)
+	session := SessionState{Authenticated: true, Email: "ada@acme.test", UserID: original.ID, CSRFToken: "csrf"}
+
+	provision(http.MethodPut, "/scim/v2/Users/"+original.ID, `{"userName":"ada.lovelace@acme.test"}`)
+	successor := provision(http.MethodPost, "/scim/v2/Users", `{"userName":"ada@acme.test"}`)
+	if successor.ID == original.ID {
+		t.Fatal("expected the old address to provision a new account")
+	}
+
+	rr := dashboard(session)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "ada.lovelace@acme.test") {
+		t.Fatalf("expected the session to stay with the renamed account, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.forwardAuthHandler()(rr, attachSession(httptest.NewRequest(http.MethodGet, forwardAuthPath, nil), session))
+	if rr.Code != http.StatusOK || rr.Header().Get(forwardAuthUserHeader) != original.ID || rr.Header().Get(forwardAuthEmailHeader) != "ada.lovelace@acme.test" {
+		t.Fatalf("expected forward auth to name the renamed account, got %d %v", rr.Code, rr.Header())
+	}
+
+	rr = dashboard(SessionState{Authenticated: true, Email: "ada@acme.test", CSRFToken: "csrf"})
+	if rr.Code != http.StatusUnauthorized || strings.Contains(rr.Body.String(), "ada@acme.test") {
+		t.Fatalf("expected a session without a user id to be refused, got %d", rr.Code)
+	}
+}
+
+func TestSCIMTenantConnectionMustExist(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{
+		SessionSecret: bytes.Repeat([]byte("s"), 32),
+		SCIMTenants:   []config.SCIMTenantConfig{{ID: "acme", Connection: "acme-saml"}},
+	}
+	if _, err := New(cfg, auth.NewService(auth.NewMemoryStore()), nil); err == nil {
+		t.Fatal("expected an unknown tenant connection to be rejected")
+	}
+}
+
+func newLDAPTestServer(t *testing.T) (*Server, *ldaptest.Server) {
+	t.Helper()
+
+	const serviceDN = "cn=svc-auth,dc=corp,dc=test"
+	directory := ldaptest.NewServer(t, ldaptest.StartTLS)
+	directory.Add(ldaptest.Entry{DN: serviceDN, Password: "service-secret"})
+	directory.Add(ldaptest.Entry{
+		DN:       "uid=ada,ou=people,dc=corp,dc=test",
+		Password: "directory pw",
+		Attributes: map[string][]string{
+			"mail":        {"ada@corp.test"},
+			"displayName": {"Ada Lovelace"},
+			"memberOf":    {"cn=engineering,ou=groups,dc=corp,dc=test"},
+		},
+	})
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	cfg := config.Config{
+		ListenAddr:    ":0",
+		LogMode:       logging.ModeText,
+		Environment:   "test",
+		SessionSecret: bytes.Repeat([]byte("l"), 32),
+		DatabaseURL:   "postgres://localhost/auth_test?sslmode=disable",
+		LDAP: []config.LDAPConnectionConfig{{
+			ID:               "corp",
+			Name:             "Corp Directory",
+			Domains:          []string{"corp.test"},
+			URL:              directory.URL(),
+			StartTLS:         true,
+			CACertPath:       write("ca.pem", directory.CACertPEM()),
+			BindDN:           serviceDN,
+			BindPasswordPath: write("bind-password", []byte("service-secret\n")),
+			UserBaseDN:       "ou=people,dc=corp,dc=test",
+		}},
+	}
+	srv, err := New(cfg, auth.NewService(auth.NewMemoryStore()), nil)
+	if err != nil {
+		t.Fatalf("new ldap server: %v", err)
+	}
+	return srv, directory
+}
+
+func TestLDAPPasswordLogin(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := newLDAPTestServer(t)
+
+	post := func(handler http.HandlerFunc, path, email, password string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("email", email)
+		form.Set("password", password)
+		form.Set("_csrf", "csrf-token")
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = attachSession(req, SessionState{CSRFToken: "csrf-token"})
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	if rr := post(srv.loginHandler(), "/login", "ada@corp.test", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong directory password, got %d", rr.Code)
+	}
+	if rr := post(srv.loginHandler(), "/login", "ada@corp.test", "directory pw"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	account, err := srv.authService.LookupByEmail(context.Background(), auth.MustUserEmail("ada@corp.test"))
+	if err != nil {
+		t.Fatalf("lookup provisioned account: %v", err)
+	}
+	identity, ok := account.Identity("corp", "uid=ada,ou=people,dc=corp,dc=test")
+	if !ok || account.DisplayName != "Ada Lovelace" || !slices.Equal(identity.Profile.Groups, []string{"cn=engineering,ou=groups,dc=corp,dc=test"}) {
+		t.Fatalf("expected provisioned directory account, got %+v", account)
+	}
+	page := srv.newDashboardPage(context.Background(), SessionState{Email: account.Email.String()}, account, "")
+	if len(page.Identities) != 1 || page.Identities[0].ProviderName != "Corp Directory" {
+		t.Fatalf("expected the directory name on the dashboard, got %+v", page.Identities)
+	}
+
+	rr := post(srv.signupHandler(), "/signup", "grace@corp.test", "Password123")
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "directory") {
+		t.Fatalf("expected signup in a directory domain to be refused, got %d", rr.Code)
+	}
+	if rr := post(srv.loginHandler(), "/login", "user@example.com", "Password123"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected local accounts to keep signing in, got %d", rr.Code)
+	}
+}
+
+func TestLDAPConnectionIDMustBeUnique(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{
+		SessionSecret: bytes.Repeat([]byte("s"), 32),
+		LDAP: []config.LDAPConnectionConfig{{
+			ID:         auth.ProviderPassword,
+			Domains:    []string{"corp.test"},
+			URL:        "ldaps://dc.corp.test",
+			UserBaseDN: "dc=corp,dc=test",
+		}},
+	}
+	if _, err := New(cfg, auth.NewService(auth.NewMemoryStore()), nil); err == nil {
+		t.Fatal("expected a reserved connection id to be rejected")
+	}
+}
@@ -2,10 +2,14 @@ package server
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
+
+	"github.com/rjnemo/auth/internal/service/auth"
 )
 
 const (
@@ -14,30 +18,140 @@ const (
 	sessionSecretMinLength     = 32
 	csrfTokenByteLength    int = 32
 	oauthStateByteLength   int = 32
+	oauthFlowLifetime          = 5 * time.Minute
+	maxPendingOAuthFlows       = 5
+	pendingLinkLifetime        = 10 * time.Minute
 )
 
 // SessionStore persists session data using secure HTTP cookies.
 type SessionStore struct {
 	secret []byte
+	// domain scopes the cookie to a parent domain; empty keeps it host-only.
+	domain string
 }
 
-// NewSessionStore creates a cookie-backed session store.
-func NewSessionStore(secret []byte) (*SessionStore, error) {
+// NewSessionStore creates a cookie-backed session store. A non-empty domain
+// shares the cookie with every subdomain of it.
+func NewSessionStore(secret []byte, domain string) (*SessionStore, error) {
 	if len(secret) < sessionSecretMinLength {
 		return nil, fmt.Errorf("session secret must be at least %d bytes", sessionSecretMinLength)
 	}
 	// copy secret to avoid external mutation
 	buf := make([]byte, len(secret))
 	copy(buf, secret)
-	return &SessionStore{secret: buf}, nil
+	return &SessionStore{secret: buf, domain: domain}, nil
 }
 
 // SessionState holds per-request session data after loading.
 type SessionState struct {
 	Authenticated bool   `json:"authenticated"`
 	Email         string `json:"email"`
-	CSRFToken     string `json:"csrf_token"`
-	OAuthState    string `json:"oauth_state"`
+	// UserID is the signed-in account's ID, read by downstream services
+	// through the identity package.
+	UserID      string       `json:"user_id,omitempty"`
+	CSRFToken   string       `json:"csrf_token"`
+	OAuthFlows  []OAuthFlow  `json:"oauth_flows,omitempty"`
+	PendingLink *PendingLink `json:"pending_link,omitempty"`
+	// ReturnTo is the validated destination to open once the user signs in.
+	ReturnTo string `json:"return_to,omitempty"`
+	// IssuedAt and ExpiresAt bound the session, in Unix seconds. They are set
+	// when the session is first saved and checked on every load, so a copied
+	// cookie stops working after sessionLifetime whatever the browser does.
+	IssuedAt  int64 `json:"iat,omitempty"`
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// withLifetime starts the session's lifetime at now unless it has begun.
+func (s SessionState) withLifetime(now time.Time) SessionState {
+	if s.ExpiresAt == 0 {
+		s.IssuedAt = now.Unix()
+		s.ExpiresAt = now.Add(sessionLifetime).Unix()
+	}
+	return s
+}
+
+// PendingLink parks an external identity whose email belongs to an existing
+// account until the user proves ownership of that account by signing in to it.
+type PendingLink struct {
+	Provider      string    `json:"provider"`
+	Subject       string    `json:"subject"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// OAuthFlow tracks a single in-flight authorization request. Several may be
+// pending at once so sign-ins started from different tabs do not clobber
+// each other.
+type OAuthFlow struct {
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	Provider  string    `json:"provider"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Link marks a flow started from the dashboard to attach the identity to
+	// the signed-in account rather than sign in with it.
+	Link bool `json:"link,omitempty"`
+	// Scopes lists the additional scopes an incremental consent asked for.
+	Scopes []string `json:"scopes,omitempty"`
+	// ReturnTo is the post-login destination captured when the flow began, so
+	// concurrent sign-ins from different tabs each land where they started.
+	ReturnTo string `json:"return_to,omitempty"`
+}
+
+// addOAuthFlow records flow, discarding expired entries and the oldest
+// pending flows beyond maxPendingOAuthFlows.
+func (s *SessionState) addOAuthFlow(flow OAuthFlow, now time.Time) {
+	s.pruneOAuthFlows(now)
+	s.OAuthFlows = append(s.OAuthFlows, flow)
+	if excess := len(s.OAuthFlows) - maxPendingOAuthFlows; excess > 0 {
+		s.OAuthFlows = s.OAuthFlows[excess:]
+	}
+}
+
+// takeOAuthFlow removes and returns the unexpired flow matching state.
+func (s *SessionState) takeOAuthFlow(state string, now time.Time) (OAuthFlow, bool) {
+	s.pruneOAuthFlows(now)
+	if state == "" {
+		return OAuthFlow{}, false
+	}
+	for i, flow := range s.OAuthFlows {
+		if subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) == 1 {
+			s.OAuthFlows = append(s.OAuthFlows[:i:i], s.OAuthFlows[i+1:]...)
+			return flow, true
+		}
+	}
+	return OAuthFlow{}, false
+}
+
+func (s *SessionState) pruneOAuthFlows(now time.Time) {
+	s.OAuthFlows = slices.DeleteFunc(s.OAuthFlows, func(flow OAuthFlow) bool {
+		return !now.Before(flow.ExpiresAt)
+	})
+}
+
+// activePendingLink returns the pending link unless it has expired.
+func (s SessionState) activePendingLink(now time.Time) (*PendingLink, bool) {
+	if s.PendingLink == nil || !now.Before(s.PendingLink.ExpiresAt) {
+		return nil, false
+	}
+	return s.PendingLink, true
+}
+
+// takePendingLink clears the pending link and returns its identity when it is
+// still valid and was parked for email.
+func (s *SessionState) takePendingLink(email string, now time.Time) (auth.ExternalIdentity, bool) {
+	link, ok := s.activePendingLink(now)
+	s.PendingLink = nil
+	if !ok || link.Email != email {
+		return auth.ExternalIdentity{}, false
+	}
+	return auth.ExternalIdentity{
+		Provider:      link.Provider,
+		Subject:       link.Subject,
+		Email:         auth.UserEmail(link.Email),
+		EmailVerified: link.EmailVerified,
+	}, true
 }
 
 // Load extracts session data from the request cookies.
@@ -55,8 +169,10 @@ func (s *SessionStore) Load(r *http.Request) SessionState {
 	return payload
 }
 
-// Save persists the session state onto the response cookies.
+// Save persists the session state onto the response cookies. The cookie
+// expires with the session, which a fresh state starts now.
 func (s *SessionStore) Save(w http.ResponseWriter, state SessionState) error {
+	state = state.withLifetime(time.Now())
 	serialized, err := encodeSession(state, s.secret)
 	if err != nil {
 		return err
@@ -66,10 +182,11 @@ func (s *SessionStore) Save(w http.ResponseWriter, state SessionState) error {
 		Name:     sessionCookieName,
 		Value:    serialized,
 		Path:     "/",
+		Domain:   s.domain,
 		HttpOnly: true,
 		Secure:   false, // TODO: in production, set to true
 		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Now().Add(sessionLifetime),
+		Expires:  time.Unix(state.ExpiresAt, 0),
 	})
 
 	return nil
@@ -81,6 +198,7 @@ func (s *SessionStore) Clear(w http.ResponseWriter) {
 		Name:     sessionCookieName,
 		Value:    "",
 		Path:     "/",
+		Domain:   s.domain,
 		Expires:  time.Unix(0, 0),
 		MaxAge:   -1,
 		HttpOnly: true,
@@ -102,6 +220,55 @@ func ensureCSRFToken(state SessionState) (SessionState, error) {
 	return state, nil
 }
 
+// signedIn returns a fresh session signed in as account. Only the post-login
+// destination is carried over from previous; the CSRF token and everything
+// else are issued anew, so nothing planted in the anonymous session is valid
+// once the user signs in.
+func signedIn(previous SessionState, account *auth.User) (SessionState, error) {
+	return ensureCSRFToken(SessionState{
+		Authenticated: true,
+		Email:         account.Email.String(),
+		UserID:        account.ID,
+		ReturnTo:      previous.ReturnTo,
+	})
+}
+
+// MaskedCSRFToken returns a freshly masked copy of the session CSRF token suitable
+// for embedding in a response. Each call yields a different value so the secret
+// never appears verbatim in compressed response bodies (BREACH).
+func (s SessionState) MaskedCSRFToken() string {
+	return maskCSRFToken(s.CSRFToken)
+}
+
+func maskCSRFToken(token string) string {
+	secret, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(secret) == 0 {
+		return ""
+	}
+	masked := make([]byte, 2*len(secret))
+	pad := masked[:len(secret)]
+	if _, err := rand.Read(pad); err != nil {
+		return ""
+	}
+	for i := range secret {
+		masked[len(secret)+i] = pad[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func unmaskCSRFToken(masked string) ([]byte, bool) {
+	decoded, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(decoded) == 0 || len(decoded)%2 != 0 {
+		return nil, false
+	}
+	half := len(decoded) / 2
+	secret := make([]byte, half)
+	for i := range secret {
+		secret[i] = decoded[i] ^ decoded[half+i]
+	}
+	return secret, true
+}
+
 func generateOAuthState() (string, error) {
 	buf := make([]byte, oauthStateByteLength)
 	if _, err := rand.Read(buf); err != nil {
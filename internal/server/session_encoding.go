@@ -3,9 +3,10 @@ package server
 import (
 	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
-	"errors"
+	"time"
+
+	"github.com/rjnemo/auth/identity"
 )
 
 func encodeSession(state SessionState, secret []byte) (string, error) {
@@ -13,41 +14,54 @@ func encodeSession(state SessionState, secret []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	mac := hmac.New(sha256.New, secret)
-	mac.Write(payload)
-	sig := mac.Sum(nil)
-
-	combined := append(payload, sig...)
-	return base64.RawURLEncoding.EncodeToString(combined), nil
+	return identity.SignSession(payload, secret), nil
 }
 
+// decodeSession opens a session cookie, refusing one that has expired or
+// carries no expiry.
 func decodeSession(raw string, secret []byte) (SessionState, error) {
 	var state SessionState
 
-	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	payload, err := identity.OpenSession(raw, secret)
 	if err != nil {
 		return state, err
 	}
-
-	if len(decoded) <= sha256.Size {
-		return state, errors.New("session payload too small")
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return state, err
+	}
+	if err := identity.CheckSessionLifetime(state.IssuedAt, state.ExpiresAt, time.Now()); err != nil {
+		return SessionState{}, err
 	}
 
-	payload := decoded[:len(decoded)-sha256.Size]
-	providedSig := decoded[len(decoded)-sha256.Size:]
+	return state, nil
+}
 
+// samlLoginKey derives the key sealing SAML login cookies from the session
+// secret, so neither cookie can be passed off as the other.
+func samlLoginKey(secret []byte) []byte {
 	mac := hmac.New(sha256.New, secret)
-	mac.Write(payload)
-	expectedSig := mac.Sum(nil)
+	mac.Write([]byte("saml login"))
+	return mac.Sum(nil)
+}
 
-	if !hmac.Equal(providedSig, expectedSig) {
-		return state, errors.New("session signature mismatch")
+func encodeSAMLLogin(login samlLogin, secret []byte) (string, error) {
+	payload, err := json.Marshal(login)
+	if err != nil {
+		return "", err
 	}
+	return identity.SignSession(payload, samlLoginKey(secret)), nil
+}
 
-	if err := json.Unmarshal(payload, &state); err != nil {
-		return state, err
+func decodeSAMLLogin(raw string, secret []byte) (samlLogin, error) {
+	var login samlLogin
+
+	payload, err := identity.OpenSession(raw, samlLoginKey(secret))
+	if err != nil {
+		return login, err
+	}
+	if err := json.Unmarshal(payload, &login); err != nil {
+		return login, err
 	}
 
-	return state, nil
+	return login, nil
 }
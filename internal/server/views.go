@@ -1,8 +1,14 @@
 package server
 
 import (
+	"cmp"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/rjnemo/auth/internal/service/oauth"
 )
 
 func (s *Server) render(w http.ResponseWriter, name string, data any) {
@@ -15,18 +21,139 @@ func (s *Server) render(w http.ResponseWriter, name string, data any) {
 	}
 }
 
+// renderForm renders only the form fragment for htmx requests so the client can
+// swap validation errors in place, and the full page otherwise.
+func (s *Server) renderForm(w http.ResponseWriter, r *http.Request, page, fragment string, data PageData) {
+	if isHTMXRequest(r) {
+		s.render(w, fragment, data)
+		return
+	}
+	s.render(w, page, data)
+}
+
+// redirectAfterAuth sends the client to target, using HX-Redirect for htmx
+// requests because XHR follows 3xx responses transparently.
+func redirectAfterAuth(w http.ResponseWriter, r *http.Request, target string) {
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Redirect", target)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func isHTMXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
 // PageData contains fields shared by the templates for now.
 type PageData struct {
-	Title              string
-	View               string
-	Email              string
-	Error              string
-	Info               string
-	CSRFToken          string
-	CreatedAt          string
-	CreatedAtISO       string
-	GoogleLoginURL     string
-	GoogleLoginEnabled bool
+	Title        string
+	View         string
+	Email        string
+	DisplayName  string
+	AvatarURL    string
+	Error        string
+	Info         string
+	CSRFToken    string
+	CreatedAt    string
+	CreatedAtISO string
+	Providers    []ProviderOption
+	Identities   []IdentityOption
+	Applications []ApplicationOption
+	Consent      *ConsentView
+	Device       *DeviceView
+	Tokens       *PersonalTokensView
+}
+
+// ConsentView describes an authorization request awaiting the user's approval.
+type ConsentView struct {
+	ClientName string
+	// RedirectHost tells the user where they will be sent afterwards.
+	RedirectHost string
+	Scopes       []string
+	// Params carries the authorization request through the consent form.
+	Params []FormParam
+}
+
+// DeviceView drives the device verification page through its steps: entering
+// the user code, confirming the request, and the outcome.
+type DeviceView struct {
+	Step       string
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	Approved   bool
+}
+
+// PersonalTokensView lists the user's personal access tokens next to the form
+// creating one.
+type PersonalTokensView struct {
+	Tokens       []PersonalTokenOption
+	ScopeOptions []ScopeOption
+	Lifetimes    []int
+	// Name, Scopes and LifetimeDays carry the form values back after a
+	// failed submission.
+	Name         string
+	Scopes       []string
+	LifetimeDays int
+	// NewToken is the secret of the token just created. It is never shown again.
+	NewToken     string
+	NewTokenName string
+}
+
+// PersonalTokenOption describes one of the user's personal access tokens.
+type PersonalTokenOption struct {
+	ID            string
+	Name          string
+	Hint          string
+	Scopes        []string
+	Expired       bool
+	ExpiresAt     string
+	ExpiresAtISO  string
+	CreatedAt     string
+	CreatedAtISO  string
+	LastUsedAt    string
+	LastUsedAtISO string
+	LastUsedIP    string
+}
+
+// ScopeOption is a checkbox granting a scope.
+type ScopeOption struct {
+	Value       string
+	Description string
+	Checked     bool
+}
+
+// FormParam is a hidden form field.
+type FormParam struct {
+	Name  string
+	Value string
+}
+
+// IdentityOption describes a login method attached to the signed-in account.
+type IdentityOption struct {
+	Provider     string
+	ProviderName string
+	Subject      string
+	Email        string
+	LinkedAt     string
+	LinkedAtISO  string
+	CanUnlink    bool
+}
+
+// ApplicationOption describes a client the signed-in user has authorized.
+type ApplicationOption struct {
+	ClientID string
+	Name     string
+	Scopes   []string
+}
+
+// ProviderOption describes an external login button.
+type ProviderOption struct {
+	ID       string
+	Name     string
+	LoginURL string
 }
 
 func newLoginData(email, errMsg, token string) PageData {
@@ -34,9 +161,12 @@ func newLoginData(email, errMsg, token string) PageData {
 }
 
 func (s *Server) applyOAuthOptions(data PageData) PageData {
-	if s.googleOAuth != nil {
-		data.GoogleLoginEnabled = true
-		data.GoogleLoginURL = "/login/google"
+	for _, p := range s.providers.All() {
+		data.Providers = append(data.Providers, ProviderOption{
+			ID:       p.ID(),
+			Name:     p.DisplayName(),
+			LoginURL: "/login/" + p.ID(),
+		})
 	}
 	return data
 }
@@ -61,6 +191,61 @@ func newDashboardData(email, token, createdAt, createdAtISO string) PageData {
 	}
 }
 
+func newConsentData(state SessionState, client oauth.Client, req oauth.AuthorizationRequest) PageData {
+	consent := &ConsentView{ClientName: client.Name}
+	if u, err := url.Parse(req.RedirectURI); err == nil {
+		consent.RedirectHost = cmp.Or(u.Host, u.Scheme)
+	}
+	for _, scope := range req.Scopes() {
+		consent.Scopes = append(consent.Scopes, scopeDescriptions[scope])
+	}
+	for name, value := range map[string]string{
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"response_type":         req.ResponseType,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	} {
+		if value != "" {
+			consent.Params = append(consent.Params, FormParam{Name: name, Value: value})
+		}
+	}
+	slices.SortFunc(consent.Params, func(a, b FormParam) int { return strings.Compare(a.Name, b.Name) })
+
+	return PageData{
+		Title:     "Authorize " + client.Name + " · Auth Demo",
+		View:      "consent",
+		Email:     state.Email,
+		CSRFToken: state.MaskedCSRFToken(),
+		Consent:   consent,
+	}
+}
+
+func newDeviceData(state SessionState, device *DeviceView, errMsg string) PageData {
+	return PageData{
+		Title:     "Connect a device · Auth Demo",
+		View:      "device",
+		Email:     state.Email,
+		Error:     errMsg,
+		CSRFToken: state.MaskedCSRFToken(),
+		Device:    device,
+	}
+}
+
+func newPersonalTokensData(state SessionState, tokens *PersonalTokensView, errMsg string) PageData {
+	return PageData{
+		Title:     "Personal access tokens · Auth Demo",
+		View:      "tokens",
+		Email:     state.Email,
+		Error:     errMsg,
+		CSRFToken: state.MaskedCSRFToken(),
+		Tokens:    tokens,
+	}
+}
+
 func newSignupData(email, errMsg, token string) PageData {
 	return PageData{Title: "Create account · Auth Demo", View: "signup", Email: email, Error: errMsg, CSRFToken: token}
 }
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrDomainNotManaged indicates the email's domain is not one the directory
+// may provision accounts in.
+var ErrDomainNotManaged = errors.New("auth: email domain is not managed by the directory")
+
+// DirectoryProfile is the part of an account an external directory, such as
+// an enterprise IdP provisioning over SCIM, manages.
+type DirectoryProfile struct {
+	Email       UserEmail
+	DisplayName string
+	ExternalID  string
+	Active      bool
+}
+
+// TrustDirectory lets verified logins through provider claim the accounts
+// directory provisioned, which have no login method of their own. Without it
+// such logins yield ErrLinkRequired like any other email collision.
+func (s *Service) TrustDirectory(directory, provider string) {
+	if s.directories == nil {
+		s.directories = make(map[string]string)
+	}
+	s.directories[directory] = provider
+}
+
+// ManageDirectoryDomains lets directory provision accounts, and rename them,
+// only in the given email domains. A directory without domains may provision
+// none, so one tenant cannot take over another's or the public domains.
+func (s *Service) ManageDirectoryDomains(directory string, domains []string) {
+	if s.directoryDomains == nil {
+		s.directoryDomains = make(map[string][]string)
+	}
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		normalized = append(normalized, strings.ToLower(domain))
+	}
+	s.directoryDomains[directory] = normalized
+}
+
+// managesEmail reports whether directory may provision an account for email.
+func (s *Service) managesEmail(directory string, email UserEmail) bool {
+	return slices.Contains(s.directoryDomains[directory], email.Domain())
+}
+
+// claimsDirectoryAccount reports whether identity may sign in to account
+// without an explicit link because the account's directory trusts its provider
+// or because the provider checks passwords for the account's email domain.
+func (s *Service) claimsDirectoryAccount(account *User, identity ExternalIdentity) bool {
+	if !identity.EmailVerified {
+		return false
+	}
+	if backend, ok := s.passwordBackend(account.Email); ok && backend.ID() == identity.Provider {
+		return true
+	}
+	if account.Directory == "" {
+		return false
+	}
+	provider, ok := s.directories[account.Directory]
+	return ok && provider == identity.Provider
+}
+
+// ProvisionUser creates an account managed by directory. The account has no
+// login method until a login through a provider the directory trusts claims
+// it. An email already in use yields ErrEmailExists, and one outside the
+// directory's domains ErrDomainNotManaged.
+func (s *Service) ProvisionUser(ctx context.Context, directory string, profile DirectoryProfile) (*User, error) {
+	if strings.TrimSpace(directory) == "" {
+		return nil, ErrInvalidInput
+	}
+	if profile.Email.IsZero() {
+		return nil, ErrEmailRequired
+	}
+	if !s.managesEmail(directory, profile.Email) {
+		return nil, ErrDomainNotManaged
+	}
+	if _, err := s.store.FindByEmail(ctx, profile.Email); err == nil {
+		return nil, ErrEmailExists
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	id, err := generateUserID()
+	if err != nil {
+		return nil, fmt.Errorf("generate user id: %w", err)
+	}
+
+	now := time.Now().UTC()
+	user := User{
+		ID:          id,
+		Email:       profile.Email,
+		DisplayName: strings.TrimSpace(profile.DisplayName),
+		Directory:   directory,
+		ExternalID:  profile.ExternalID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if !profile.Active {
+		user.DeactivatedAt = now
+	}
+	if err := s.store.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return s.store.FindByID(ctx, id)
+}
+
+// DirectoryUser returns the account with id if directory manages it, whether
+// or not it is active. Other accounts yield ErrUserNotFound.
+func (s *Service) DirectoryUser(ctx context.Context, directory, id string) (*User, error) {
+	if strings.TrimSpace(directory) == "" {
+		return nil, ErrUserNotFound
+	}
+	account, err := s.store.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Directory != directory {
+		return nil, ErrUserNotFound
+	}
+	return account, nil
+}
+
+// DirectoryUsers returns the page of accounts query selects and their total.
+func (s *Service) DirectoryUsers(ctx context.Context, query UserQuery) ([]User, int, error) {
+	if strings.TrimSpace(query.Directory) == "" {
+		return nil, 0, ErrInvalidInput
+	}
+	return s.store.ListUsers(ctx, query)
+}
+
+// UpdateDirectoryUser replaces the directory-managed part of the account with
+// id. Deactivating keeps the account and its login methods but refuses every
+// sign-in and credential until the directory reactivates it. The email must
+// stay within the directory's domains.
+func (s *Service) UpdateDirectoryUser(ctx context.Context, directory, id string, profile DirectoryProfile) (*User, error) {
+	if profile.Email.IsZero() {
+		return nil, ErrEmailRequired
+	}
+	if !s.managesEmail(directory, profile.Email) {
+		return nil, ErrDomainNotManaged
+	}
+	profile.DisplayName = strings.TrimSpace(profile.DisplayName)
+	account, err := s.DirectoryUser(ctx, directory, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Email != profile.Email || account.DisplayName != profile.DisplayName || account.ExternalID != profile.ExternalID {
+		updated := *account
+		updated.Email = profile.Email
+		updated.DisplayName = profile.DisplayName
+		updated.ExternalID = profile.ExternalID
+		if err := s.store.UpdateUser(ctx, updated); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case profile.Active && !account.Active():
+		err = s.store.ReactivateUser(ctx, account.ID)
+	case !profile.Active && account.Active():
+		err = s.store.DeactivateUser(ctx, account.ID, time.Now().UTC())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.store.FindByID(ctx, account.ID)
+}
+
+// ReleaseDirectoryUser handles the directory deleting the account: it is
+// deactivated and detached from directory, which no longer sees it. The
+// account and its history are kept rather than erased.
+func (s *Service) ReleaseDirectoryUser(ctx context.Context, directory, id string) error {
+	account, err := s.DirectoryUser(ctx, directory, id)
+	if err != nil {
+		return err
+	}
+	if account.Active() {
+		if err := s.store.DeactivateUser(ctx, account.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+	released := *account
+	released.Directory = ""
+	return s.store.UpdateUser(ctx, released)
+}
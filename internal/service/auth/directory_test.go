@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestServiceDirectoryUsers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service := NewService(NewMemoryStore())
+	service.TrustDirectory("acme", "acme-saml")
+	service.ManageDirectoryDomains("acme", []string{"Acme.test"})
+
+	if _, err := service.Register(ctx, MustUserEmail("taken@acme.test"), "Password123"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := service.ProvisionUser(ctx, "acme", DirectoryProfile{Email: MustUserEmail("taken@acme.test"), Active: true}); !errors.Is(err, ErrEmailExists) {
+		t.Fatalf("expected ErrEmailExists, got %v", err)
+	}
+	if _, err := service.ProvisionUser(ctx, "acme", DirectoryProfile{Email: MustUserEmail("ceo@example.com"), Active: true}); !errors.Is(err, ErrDomainNotManaged) {
+		t.Fatalf("expected ErrDomainNotManaged, got %v", err)
+	}
+	if _, err := service.ProvisionUser(ctx, "globex", DirectoryProfile{Email: MustUserEmail("new@acme.test"), Active: true}); !errors.Is(err, ErrDomainNotManaged) {
+		t.Fatalf("expected a directory without domains to provision nothing, got %v", err)
+	}
+
+	account, err := service.ProvisionUser(ctx, "acme", DirectoryProfile{Email: MustUserEmail("ada@acme.test"), DisplayName: "Ada", ExternalID: "00u1", Active: true})
+	if err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	if account.Directory != "acme" || account.ExternalID != "00u1" || !account.Active() || len(account.Identities) != 0 {
+		t.Fatalf("unexpected provisioned account %+v", account)
+	}
+	if _, err := service.DirectoryUser(ctx, "globex", account.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected other directories not to see the account, got %v", err)
+	}
+
+	users, total, err := service.DirectoryUsers(ctx, UserQuery{Directory: "acme", Email: MustUserEmail("ada@acme.test"), Limit: 10})
+	if err != nil || total != 1 || len(users) != 1 || users[0].ID != account.ID {
+		t.Fatalf("expected email filter to find the account, got %+v %d %v", users, total, err)
+	}
+	if users, total, _ := service.DirectoryUsers(ctx, UserQuery{Directory: "acme"}); total != 1 || len(users) != 0 {
+		t.Fatalf("expected a zero limit to return only the total, got %+v %d", users, total)
+	}
+
+	untrusted := ExternalIdentity{Provider: ProviderGoogle, Subject: "g-1", Email: account.Email, EmailVerified: true}
+	if _, err := service.EnsureExternalUser(ctx, untrusted); !errors.Is(err, ErrLinkRequired) {
+		t.Fatalf("expected untrusted provider to need a link, got %v", err)
+	}
+	sso := ExternalIdentity{Provider: "acme-saml", Subject: "ada", Email: account.Email, EmailVerified: true, Profile: Profile{Name: "Ada L."}}
+	claimed, err := service.EnsureExternalUser(ctx, sso)
+	if err != nil {
+		t.Fatalf("claim provisioned account: %v", err)
+	}
+	if claimed.ID != account.ID || claimed.DisplayName != "Ada" {
+		t.Fatalf("expected trusted login to claim the account and keep its directory name, got %+v", claimed)
+	}
+
+	if _, err := service.UpdateDirectoryUser(ctx, "acme", account.ID, DirectoryProfile{Email: MustUserEmail("taken@acme.test"), Active: true}); !errors.Is(err, ErrEmailExists) {
+		t.Fatalf("expected ErrEmailExists, got %v", err)
+	}
+	if _, err := service.UpdateDirectoryUser(ctx, "acme", account.ID, DirectoryProfile{Email: MustUserEmail("ceo@example.com"), Active: true}); !errors.Is(err, ErrDomainNotManaged) {
+		t.Fatalf("expected a rename outside the domains to be refused, got %v", err)
+	}
+	updated, err := service.UpdateDirectoryUser(ctx, "acme", account.ID, DirectoryProfile{Email: MustUserEmail("ada.l@acme.test"), DisplayName: "Ada Lovelace", ExternalID: "00u1"})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if updated.Email != "ada.l@acme.test" || updated.Active() || len(updated.Identities) != 1 {
+		t.Fatalf("expected renamed, deactivated account keeping its identity, got %+v", updated)
+	}
+	if _, err := service.EnsureExternalUser(ctx, sso); !errors.Is(err, ErrUserDeactivated) {
+		t.Fatalf("expected deactivated login to be refused, got %v", err)
+	}
+	if _, err := service.LookupByID(ctx, account.ID); !errors.Is(err, ErrUserDeactivated) {
+		t.Fatalf("expected lookup of a deactivated account to be refused, got %v", err)
+	}
+
+	if _, err := service.UpdateDirectoryUser(ctx, "acme", account.ID, DirectoryProfile{Email: updated.Email, Active: true}); err != nil {
+		t.Fatalf("reactivate: %v", err)
+	}
+	if _, err := service.LookupByID(ctx, account.ID); err != nil {
+		t.Fatalf("expected reactivated account, got %v", err)
+	}
+
+	if err := service.ReleaseDirectoryUser(ctx, "acme", account.ID); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, err := service.DirectoryUser(ctx, "acme", account.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected released account to leave the directory, got %v", err)
+	}
+	if _, err := service.LookupByID(ctx, account.ID); !errors.Is(err, ErrUserDeactivated) {
+		t.Fatalf("expected released account to stay deactivated, got %v", err)
+	}
+}
+
+func TestServiceAuthenticateDeactivated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service := NewService(store)
+
+	account, err := service.Register(ctx, MustUserEmail("gone@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := store.DeactivateUser(ctx, account.ID, account.CreatedAt); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+
+	if _, err := service.Authenticate(ctx, account.Email, "Password999"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected a wrong password to stay ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := service.Authenticate(ctx, account.Email, "Password123"); !errors.Is(err, ErrUserDeactivated) {
+		t.Fatalf("expected ErrUserDeactivated, got %v", err)
+	}
+}
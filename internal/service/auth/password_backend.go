@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrDirectoryDomain indicates the email's domain signs in through a directory,
+// so no local password account may be registered for it.
+var ErrDirectoryDomain = errors.New("auth: email domain signs in through a directory")
+
+// PasswordBackend verifies passwords against an external directory, such as
+// LDAP or Active Directory, instead of the local password table.
+type PasswordBackend interface {
+	// ID is the provider recorded on identities the backend asserts.
+	ID() string
+	// AuthenticatePassword checks password for email and returns the
+	// directory's identity for it. Unknown users and wrong passwords yield
+	// ErrInvalidCredentials.
+	AuthenticatePassword(ctx context.Context, email UserEmail, password string) (ExternalIdentity, error)
+}
+
+// RoutePasswordDomain sends password logins for emails in domain to backend
+// ahead of the local password check. The backend is authoritative for the
+// domain: its logins claim existing accounts with a matching email, and local
+// accounts can no longer be registered there.
+func (s *Service) RoutePasswordDomain(domain string, backend PasswordBackend) {
+	if s.passwordDomains == nil {
+		s.passwordDomains = make(map[string]PasswordBackend)
+	}
+	s.passwordDomains[strings.ToLower(domain)] = backend
+}
+
+// passwordBackend returns the backend routed for the domain of email.
+func (s *Service) passwordBackend(email UserEmail) (PasswordBackend, bool) {
+	backend, ok := s.passwordDomains[email.Domain()]
+	return backend, ok
+}
+
+// authenticateWithBackend checks the password with backend and returns the
+// local account for the directory identity, provisioning it on first login.
+func (s *Service) authenticateWithBackend(ctx context.Context, backend PasswordBackend, email UserEmail, password string) (*User, error) {
+	identity, err := backend.AuthenticatePassword(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+	// The directory answered for the address that was typed, so that address,
+	// not whatever mail attribute the entry carries, keys the account.
+	identity.Provider = backend.ID()
+	identity.Email = email
+	identity.EmailVerified = true
+	return s.EnsureExternalUser(ctx, identity)
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakePasswordBackend accepts a single password for every email.
+type fakePasswordBackend struct {
+	id       string
+	password string
+	calls    int
+}
+
+func (b *fakePasswordBackend) ID() string { return b.id }
+
+func (b *fakePasswordBackend) AuthenticatePassword(_ context.Context, email UserEmail, password string) (ExternalIdentity, error) {
+	b.calls++
+	if password != b.password {
+		return ExternalIdentity{}, ErrInvalidCredentials
+	}
+	return ExternalIdentity{
+		Subject: "uid=" + email.String(),
+		// The entry's mail attribute differs from the typed address.
+		Email:   MustUserEmail("other@elsewhere.test"),
+		Profile: Profile{Name: "Ada Lovelace", Groups: []string{"cn=engineering"}},
+	}, nil
+}
+
+func TestServiceAuthenticateRoutedDomain(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service := NewService(NewMemoryStore())
+
+	// Registered before the domain was routed to the directory.
+	existing, err := service.Register(ctx, MustUserEmail("grace@corp.test"), "Password123")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	backend := &fakePasswordBackend{id: "corp-ldap", password: "short"}
+	service.RoutePasswordDomain("Corp.Test", backend)
+
+	if _, err := service.Register(ctx, MustUserEmail("new@corp.test"), "Password123"); !errors.Is(err, ErrDirectoryDomain) {
+		t.Fatalf("expected ErrDirectoryDomain, got %v", err)
+	}
+	if _, err := service.Authenticate(ctx, MustUserEmail("ada@corp.test"), "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// The directory's password policy applies, not ValidatePassword.
+	account, err := service.Authenticate(ctx, MustUserEmail("ada@corp.test"), "short")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if account.Email != "ada@corp.test" || account.Provider != "corp-ldap" || account.DisplayName != "Ada Lovelace" {
+		t.Fatalf("unexpected provisioned account %+v", account)
+	}
+	identity, ok := account.Identity("corp-ldap", "uid=ada@corp.test")
+	if !ok || !identity.EmailVerified || len(identity.Profile.Groups) != 1 {
+		t.Fatalf("expected verified identity with groups, got %+v", account.Identities)
+	}
+
+	again, err := service.Authenticate(ctx, MustUserEmail("ada@corp.test"), "short")
+	if err != nil || again.ID != account.ID {
+		t.Fatalf("expected second login to reuse the account, got %+v %v", again, err)
+	}
+
+	// The local password no longer works; the directory claims the account.
+	if _, err := service.Authenticate(ctx, existing.Email, "Password123"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected local password to be refused, got %v", err)
+	}
+	claimed, err := service.Authenticate(ctx, existing.Email, "short")
+	if err != nil || claimed.ID != existing.ID {
+		t.Fatalf("expected directory login to claim the existing account, got %+v %v", claimed, err)
+	}
+
+	// Other domains keep using the local password table.
+	calls := backend.calls
+	if _, err := service.Register(ctx, MustUserEmail("ada@example.test"), "Password123"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := service.Authenticate(ctx, MustUserEmail("ada@example.test"), "Password123"); err != nil {
+		t.Fatalf("authenticate local account: %v", err)
+	}
+	if backend.calls != calls {
+		t.Fatal("expected unrouted domains not to reach the backend")
+	}
+}
+
+func TestServiceAuthenticateRoutedDomainDeactivated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service := NewService(store)
+	service.RoutePasswordDomain("corp.test", &fakePasswordBackend{id: "corp-ldap", password: "secret"})
+
+	account, err := service.Authenticate(ctx, MustUserEmail("ada@corp.test"), "secret")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if err := store.DeactivateUser(ctx, account.ID, account.CreatedAt); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+	if _, err := service.Authenticate(ctx, account.Email, "secret"); !errors.Is(err, ErrUserDeactivated) {
+		t.Fatalf("expected ErrUserDeactivated, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// PersonalTokenPrefix starts every personal access token so secret
+	// scanners can recognise leaked ones.
+	PersonalTokenPrefix = "authpat_"
+
+	// PersonalTokenScopeRead allows reading the account.
+	PersonalTokenScopeRead = "account:read"
+	// PersonalTokenScopeWrite allows changing the account.
+	PersonalTokenScopeWrite = "account:write"
+
+	// MaxPersonalTokenLifetime bounds how long a personal access token lives.
+	MaxPersonalTokenLifetime = 365 * 24 * time.Hour
+
+	personalTokenByteLength = 32
+	personalTokenNameMaxLen = 100
+	// personalTokenHintLength is how many characters of the secret, after the
+	// prefix, are kept in the clear to tell tokens apart.
+	personalTokenHintLength = 4
+	// personalTokenTouchInterval limits how often repeated use from the same
+	// address is written back to the store.
+	personalTokenTouchInterval = time.Minute
+)
+
+// ErrPersonalTokenNotFound indicates no such personal access token exists for
+// the user.
+var ErrPersonalTokenNotFound = errors.New("auth: personal access token not found")
+
+// PersonalTokenScopes lists the scopes a personal access token may carry.
+var PersonalTokenScopes = []string{PersonalTokenScopeRead, PersonalTokenScopeWrite}
+
+// PersonalToken is a long-lived credential a user issues to scripts. Only the
+// SHA-256 hash of the secret is stored.
+type PersonalToken struct {
+	ID        string
+	UserID    string
+	Name      string
+	TokenHash []byte
+	// Hint is the prefix and first characters of the secret.
+	Hint       string
+	Scopes     []string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	LastUsedIP string
+	CreatedAt  time.Time
+}
+
+// Expired reports whether the token is unusable at now.
+func (t PersonalToken) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// HasScope reports whether the token was granted scope.
+func (t PersonalToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// PersonalTokenRequest describes a personal access token to create.
+type PersonalTokenRequest struct {
+	Name     string
+	Scopes   []string
+	Lifetime time.Duration
+}
+
+// IsPersonalToken reports whether token looks like a personal access token, so
+// callers can tell it apart from other bearer credentials.
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// CreatePersonalToken issues a personal access token to the user and returns
+// it with its secret, which is not stored and cannot be shown again.
+func (s *Service) CreatePersonalToken(ctx context.Context, userID string, req PersonalTokenRequest) (PersonalToken, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if strings.TrimSpace(userID) == "" || name == "" || len(name) > personalTokenNameMaxLen {
+		return PersonalToken{}, "", ErrInvalidInput
+	}
+	if req.Lifetime <= 0 || req.Lifetime > MaxPersonalTokenLifetime {
+		return PersonalToken{}, "", ErrInvalidInput
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !slices.Contains(PersonalTokenScopes, scope) {
+			return PersonalToken{}, "", ErrInvalidInput
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return PersonalToken{}, "", ErrInvalidInput
+	}
+
+	secret, err := generatePersonalToken()
+	if err != nil {
+		return PersonalToken{}, "", fmt.Errorf("generate personal access token: %w", err)
+	}
+	now := time.Now().UTC()
+	token := PersonalToken{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashPersonalToken(secret),
+		Hint:      secret[:len(PersonalTokenPrefix)+personalTokenHintLength],
+		Scopes:    scopes,
+		ExpiresAt: now.Add(req.Lifetime),
+		CreatedAt: now,
+	}
+	if err := s.store.CreatePersonalToken(ctx, token); err != nil {
+		return PersonalToken{}, "", err
+	}
+	return token, secret, nil
+}
+
+// PersonalTokens lists the user's personal access tokens, newest first,
+// including expired ones until they are revoked.
+func (s *Service) PersonalTokens(ctx context.Context, userID string) ([]PersonalToken, error) {
+	if strings.TrimSpace(userID) == "" {
+		return nil, ErrInvalidInput
+	}
+	return s.store.ListPersonalTokens(ctx, userID)
+}
+
+// RevokePersonalToken deletes one of the user's personal access tokens.
+func (s *Service) RevokePersonalToken(ctx context.Context, userID, id string) error {
+	if strings.TrimSpace(userID) == "" || strings.TrimSpace(id) == "" {
+		return ErrPersonalTokenNotFound
+	}
+	return s.store.DeletePersonalToken(ctx, userID, id)
+}
+
+// AuthenticatePersonalToken returns the account owning token and records the
+// use from ip. Unknown and expired tokens, and tokens of deactivated accounts,
+// yield ErrInvalidCredentials.
+func (s *Service) AuthenticatePersonalToken(ctx context.Context, token, ip string) (*User, PersonalToken, error) {
+	if !IsPersonalToken(token) {
+		return nil, PersonalToken{}, ErrInvalidCredentials
+	}
+	found, err := s.store.FindPersonalToken(ctx, hashPersonalToken(token))
+	if err != nil {
+		if errors.Is(err, ErrPersonalTokenNotFound) {
+			return nil, PersonalToken{}, ErrInvalidCredentials
+		}
+		return nil, PersonalToken{}, err
+	}
+	now := time.Now().UTC()
+	if found.Expired(now) {
+		return nil, PersonalToken{}, ErrInvalidCredentials
+	}
+
+	account, err := s.store.FindByID(ctx, found.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, PersonalToken{}, ErrInvalidCredentials
+		}
+		return nil, PersonalToken{}, err
+	}
+	if !account.Active() {
+		return nil, PersonalToken{}, ErrInvalidCredentials
+	}
+
+	if found.LastUsedIP != ip || now.Sub(found.LastUsedAt) >= personalTokenTouchInterval {
+		if err := s.store.TouchPersonalToken(ctx, found.ID, now, ip); err != nil {
+			return nil, PersonalToken{}, err
+		}
+		found.LastUsedAt, found.LastUsedIP = now, ip
+	}
+	return account, found, nil
+}
+
+// generatePersonalToken returns a random secret carrying PersonalTokenPrefix.
+func generatePersonalToken() (string, error) {
+	buf := make([]byte, personalTokenByteLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashPersonalToken returns the digest under which a token is stored. Tokens
+// are high-entropy, so an unsalted hash suffices.
+func hashPersonalToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServicePersonalTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service := NewService(store)
+	account, err := service.Register(ctx, MustUserEmail("scripts@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	for name, req := range map[string]PersonalTokenRequest{
+		"missing name":     {Scopes: []string{PersonalTokenScopeRead}, Lifetime: time.Hour},
+		"missing scope":    {Name: "CI", Lifetime: time.Hour},
+		"unknown scope":    {Name: "CI", Scopes: []string{"admin"}, Lifetime: time.Hour},
+		"missing lifetime": {Name: "CI", Scopes: []string{PersonalTokenScopeRead}},
+		"lifetime too long": {
+			Name: "CI", Scopes: []string{PersonalTokenScopeRead}, Lifetime: MaxPersonalTokenLifetime + time.Hour,
+		},
+	} {
+		if _, _, err := service.CreatePersonalToken(ctx, account.ID, req); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+
+	token, secret, err := service.CreatePersonalToken(ctx, account.ID, PersonalTokenRequest{
+		Name:     "  Deploy script ",
+		Scopes:   []string{PersonalTokenScopeRead, PersonalTokenScopeRead},
+		Lifetime: 30 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("create personal token: %v", err)
+	}
+	if !strings.HasPrefix(secret, PersonalTokenPrefix) || !IsPersonalToken(secret) {
+		t.Fatalf("expected secret to carry the %q prefix, got %q", PersonalTokenPrefix, secret)
+	}
+	if token.Name != "Deploy script" || !slices.Equal(token.Scopes, []string{PersonalTokenScopeRead}) {
+		t.Fatalf("unexpected token %+v", token)
+	}
+	if !strings.HasPrefix(secret, token.Hint) || len(token.Hint) != len(PersonalTokenPrefix)+personalTokenHintLength {
+		t.Fatalf("expected hint to be the start of the secret, got %q", token.Hint)
+	}
+	if bytes.Contains(token.TokenHash, []byte(secret)) || len(token.TokenHash) == 0 {
+		t.Fatal("expected only a hash of the secret to be kept")
+	}
+
+	authenticated, used, err := service.AuthenticatePersonalToken(ctx, secret, "203.0.113.7")
+	if err != nil {
+		t.Fatalf("authenticate personal token: %v", err)
+	}
+	if authenticated.ID != account.ID || used.ID != token.ID || !used.HasScope(PersonalTokenScopeRead) || used.HasScope(PersonalTokenScopeWrite) {
+		t.Fatalf("unexpected authentication %+v %+v", authenticated, used)
+	}
+	listed, err := service.PersonalTokens(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("list personal tokens: %v", err)
+	}
+	if len(listed) != 1 || listed[0].LastUsedIP != "203.0.113.7" || listed[0].LastUsedAt.IsZero() {
+		t.Fatalf("expected last use to be recorded, got %+v", listed)
+	}
+
+	if _, _, err := service.AuthenticatePersonalToken(ctx, PersonalTokenPrefix+"unknown", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected unknown token to be refused, got %v", err)
+	}
+	if _, _, err := service.AuthenticatePersonalToken(ctx, strings.TrimPrefix(secret, PersonalTokenPrefix), ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected unprefixed token to be refused, got %v", err)
+	}
+
+	if err := service.RevokePersonalToken(ctx, "someone-else", token.ID); !errors.Is(err, ErrPersonalTokenNotFound) {
+		t.Fatalf("expected other users' tokens to be hidden, got %v", err)
+	}
+	if err := service.RevokePersonalToken(ctx, account.ID, token.ID); err != nil {
+		t.Fatalf("revoke personal token: %v", err)
+	}
+	if _, _, err := service.AuthenticatePersonalToken(ctx, secret, ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected revoked token to be refused, got %v", err)
+	}
+	if err := service.RevokePersonalToken(ctx, account.ID, token.ID); !errors.Is(err, ErrPersonalTokenNotFound) {
+		t.Fatalf("expected ErrPersonalTokenNotFound, got %v", err)
+	}
+}
+
+func TestServicePersonalTokenExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service := NewService(store)
+	account, err := service.Register(ctx, MustUserEmail("expired@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	secret := PersonalTokenPrefix + "expired"
+	if err := store.CreatePersonalToken(ctx, PersonalToken{
+		ID:        "expired",
+		UserID:    account.ID,
+		Name:      "Old",
+		TokenHash: hashPersonalToken(secret),
+		Scopes:    []string{PersonalTokenScopeRead},
+		ExpiresAt: time.Now().Add(-time.Minute),
+		CreatedAt: time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("store personal token: %v", err)
+	}
+	if _, _, err := service.AuthenticatePersonalToken(ctx, secret, ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected expired token to be refused, got %v", err)
+	}
+	listed, err := service.PersonalTokens(ctx, account.ID)
+	if err != nil || len(listed) != 1 || !listed[0].Expired(time.Now()) {
+		t.Fatalf("expected expired token to stay listed until revoked, got %+v %v", listed, err)
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// Profile holds the descriptive claims an external provider asserted about the
+// user. It is stored verbatim on the linked identity and refreshed on each login.
+type Profile struct {
+	Name         string `json:"name,omitempty"`
+	GivenName    string `json:"given_name,omitempty"`
+	FamilyName   string `json:"family_name,omitempty"`
+	Nickname     string `json:"nickname,omitempty"`
+	Picture      string `json:"picture,omitempty"`
+	Locale       string `json:"locale,omitempty"`
+	HostedDomain string `json:"hd,omitempty"`
+	// Groups lists the directory groups the user belonged to at login.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// IsZero reports whether the provider asserted no profile claims.
+func (p Profile) IsZero() bool {
+	return p.Name == "" && p.GivenName == "" && p.FamilyName == "" && p.Nickname == "" &&
+		p.Picture == "" && p.Locale == "" && p.HostedDomain == "" && len(p.Groups) == 0
+}
+
+// FullName returns the asserted real name: the name claim, otherwise the given
+// and family names joined.
+func (p Profile) FullName() string {
+	if name := strings.TrimSpace(p.Name); name != "" {
+		return name
+	}
+	return strings.TrimSpace(strings.TrimSpace(p.GivenName) + " " + strings.TrimSpace(p.FamilyName))
+}
+
+// nextDisplayName applies the display-name precedence rule for a login with
+// profile. A real name replaces the stored value so users see the name their
+// provider currently asserts; a provider handle only fills an empty value.
+// It reports false when the stored value should be kept.
+func nextDisplayName(current string, profile Profile) (string, bool) {
+	if name := profile.FullName(); name != "" {
+		return name, name != current
+	}
+	if current == "" {
+		if handle := strings.TrimSpace(profile.Nickname); handle != "" {
+			return handle, true
+		}
+	}
+	return current, false
+}
+
+// latestPicture returns the picture from the most recently refreshed identity.
+func latestPicture(identities []Identity) string {
+	var (
+		picture string
+		seen    time.Time
+	)
+	for _, identity := range identities {
+		if identity.Profile.Picture == "" {
+			continue
+		}
+		if picture == "" || identity.UpdatedAt.After(seen) {
+			picture = identity.Profile.Picture
+			seen = identity.UpdatedAt
+		}
+	}
+	return picture
+}
@@ -0,0 +1,35 @@
+package auth
+
+import "testing"
+
+func TestNextDisplayName(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		current     string
+		profile     Profile
+		want        string
+		wantChanged bool
+	}{
+		"name claim":           {profile: Profile{Name: "Ada Lovelace", GivenName: "Ada"}, want: "Ada Lovelace", wantChanged: true},
+		"given and family":     {profile: Profile{GivenName: "Ada", FamilyName: "Lovelace"}, want: "Ada Lovelace", wantChanged: true},
+		"real name replaces":   {current: "ada", profile: Profile{Name: "Ada King"}, want: "Ada King", wantChanged: true},
+		"same name":            {current: "Ada King", profile: Profile{Name: "Ada King"}, want: "Ada King"},
+		"handle fills empty":   {profile: Profile{Nickname: "ada"}, want: "ada", wantChanged: true},
+		"handle keeps current": {current: "Ada King", profile: Profile{Nickname: "ada"}, want: "Ada King"},
+		"no claims":            {current: "Ada King", want: "Ada King"},
+		"blank name ignored":   {profile: Profile{Name: "  ", Nickname: "ada"}, want: "ada", wantChanged: true},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, changed := nextDisplayName(tc.current, tc.profile)
+			if got != tc.want || changed != tc.wantChanged {
+				t.Fatalf("expected (%q, %v), got (%q, %v)", tc.want, tc.wantChanged, got, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEmailUnverified indicates the provider did not assert a verified email.
+var ErrEmailUnverified = errors.New("auth: external email not verified")
+
+// ExternalIdentity is an identity asserted by an external provider after a
+// successful login.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         UserEmail
+	EmailVerified bool
+	Profile       Profile
+	// Token is set when the provider issued tokens the application keeps.
+	Token *ProviderToken
+}
+
+// AuthorizationRequest carries the per-attempt secrets bound to a redirect login.
+type AuthorizationRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+	// Scopes requests consent for these scopes on top of those already granted.
+	Scopes []string
+	// ReturnTo is the validated post-login destination, for providers that
+	// carry it through the round trip themselves.
+	ReturnTo string
+}
+
+// IdentityProvider performs a redirect-based login against an external provider.
+type IdentityProvider interface {
+	// ID is the stable identifier recorded on linked accounts and used in routes.
+	ID() string
+	// DisplayName labels the provider on login buttons and messages.
+	DisplayName() string
+	// Begin returns the URL the browser should be sent to.
+	Begin(ctx context.Context, req AuthorizationRequest) (string, error)
+	// Complete redeems the callback code and returns the asserted identity.
+	Complete(ctx context.Context, code string, req AuthorizationRequest) (ExternalIdentity, error)
+}
+
+// ProviderRegistry holds the enabled identity providers in display order.
+type ProviderRegistry struct {
+	ordered []IdentityProvider
+	byID    map[string]IdentityProvider
+}
+
+// NewProviderRegistry indexes providers by ID, rejecting duplicates.
+func NewProviderRegistry(providers ...IdentityProvider) (*ProviderRegistry, error) {
+	reg := &ProviderRegistry{byID: make(map[string]IdentityProvider, len(providers))}
+	for _, p := range providers {
+		id := p.ID()
+		if strings.TrimSpace(id) == "" {
+			return nil, ErrProviderRequired
+		}
+		if id == ProviderPassword {
+			return nil, fmt.Errorf("auth: provider id %q is reserved", id)
+		}
+		if _, exists := reg.byID[id]; exists {
+			return nil, fmt.Errorf("auth: duplicate identity provider %q", id)
+		}
+		reg.byID[id] = p
+		reg.ordered = append(reg.ordered, p)
+	}
+	return reg, nil
+}
+
+// Lookup returns the provider registered under id.
+func (r *ProviderRegistry) Lookup(id string) (IdentityProvider, bool) {
+	if r == nil {
+		return nil, false
+	}
+	p, ok := r.byID[id]
+	return p, ok
+}
+
+// All returns the registered providers in registration order.
+func (r *ProviderRegistry) All() []IdentityProvider {
+	if r == nil {
+		return nil
+	}
+	return r.ordered
+}
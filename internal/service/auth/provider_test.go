@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+type stubProvider struct{ id string }
+
+func (p stubProvider) ID() string          { return p.id }
+func (p stubProvider) DisplayName() string { return p.id }
+
+func (p stubProvider) Begin(context.Context, AuthorizationRequest) (string, error) {
+	return "https://idp.example.com/authorize", nil
+}
+
+func (p stubProvider) Complete(context.Context, string, AuthorizationRequest) (ExternalIdentity, error) {
+	return ExternalIdentity{Provider: p.id}, nil
+}
+
+func TestNewProviderRegistry(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		ids     []string
+		wantErr bool
+	}{
+		"ordered":   {ids: []string{"google", "github", "oidc"}},
+		"empty":     {ids: nil},
+		"duplicate": {ids: []string{"google", "google"}, wantErr: true},
+		"reserved":  {ids: []string{ProviderPassword}, wantErr: true},
+		"blank":     {ids: []string{" "}, wantErr: true},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			providers := make([]IdentityProvider, 0, len(tc.ids))
+			for _, id := range tc.ids {
+				providers = append(providers, stubProvider{id: id})
+			}
+			reg, err := NewProviderRegistry(providers...)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			all := reg.All()
+			if len(all) != len(tc.ids) {
+				t.Fatalf("expected %d providers, got %d", len(tc.ids), len(all))
+			}
+			for i, id := range tc.ids {
+				if all[i].ID() != id {
+					t.Fatalf("expected provider %d to be %q, got %q", i, id, all[i].ID())
+				}
+				if _, ok := reg.Lookup(id); !ok {
+					t.Fatalf("expected lookup of %q to succeed", id)
+				}
+			}
+			if _, ok := reg.Lookup("missing"); ok {
+				t.Fatal("expected lookup of unknown provider to fail")
+			}
+		})
+	}
+}
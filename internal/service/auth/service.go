@@ -17,6 +17,13 @@ var (
 	ErrEmailExists = errors.New("auth: email already registered")
 	// ErrProviderRequired indicates the external provider identifier was missing.
 	ErrProviderRequired = errors.New("auth: provider required")
+	// ErrLinkRequired indicates the external identity's email belongs to an
+	// existing account that must be signed in to before the identity is linked.
+	ErrLinkRequired = errors.New("auth: existing account must be linked explicitly")
+	// ErrLastLoginMethod indicates removing the identity would lock the user out.
+	ErrLastLoginMethod = errors.New("auth: cannot remove the last login method")
+	// ErrUserDeactivated indicates the account exists but has been deactivated.
+	ErrUserDeactivated = errors.New("auth: account deactivated")
 )
 
 const (
@@ -24,11 +31,25 @@ const (
 	ProviderPassword = "password"
 	// ProviderGoogle identifies accounts authenticated via Google OAuth2.
 	ProviderGoogle = "google"
+	// ProviderGitHub identifies accounts authenticated via GitHub OAuth2.
+	ProviderGitHub = "github"
+	// ProviderOIDC identifies accounts authenticated via the generic OpenID Connect provider.
+	ProviderOIDC = "oidc"
 )
 
 // Service exposes authentication business operations to HTTP handlers.
 type Service struct {
-	store UserStore
+	store  UserStore
+	tokens *tokenVault
+	// directories maps a directory to the provider whose logins may claim
+	// the accounts it provisioned; see TrustDirectory.
+	directories map[string]string
+	// directoryDomains maps a directory to the email domains it may
+	// provision accounts in; see ManageDirectoryDomains.
+	directoryDomains map[string][]string
+	// passwordDomains maps an email domain to the directory checking its
+	// passwords; see RoutePasswordDomain.
+	passwordDomains map[string]PasswordBackend
 }
 
 // NewService wires a Service with the provided persistence implementation.
@@ -37,10 +58,15 @@ func NewService(store UserStore) *Service {
 }
 
 // Authenticate validates the provided email/password and returns the account on success.
+// Emails in a domain routed to a PasswordBackend are checked by that backend.
 func (s *Service) Authenticate(ctx context.Context, email UserEmail, password string) (*User, error) {
 	if email.IsZero() || password == "" {
 		return nil, ErrInvalidInput
 	}
+	// Directory passwords follow the directory's policy, not ours.
+	if backend, ok := s.passwordBackend(email); ok {
+		return s.authenticateWithBackend(ctx, backend, email, password)
+	}
 	if err := ValidatePassword(password); err != nil {
 		return nil, err
 	}
@@ -56,17 +82,44 @@ func (s *Service) Authenticate(ctx context.Context, email UserEmail, password st
 	if !VerifyPassword(password, account.PasswordSalt, account.PasswordHash) {
 		return nil, ErrInvalidCredentials
 	}
+	// Checked after the password so the account state is not disclosed to
+	// anyone who merely knows the email.
+	if !account.Active() {
+		return nil, ErrUserDeactivated
+	}
 
 	return account, nil
 }
 
-// LookupByEmail fetches a user by canonical email.
+// LookupByEmail fetches an active user by canonical email. Deactivated
+// accounts yield ErrUserDeactivated.
 func (s *Service) LookupByEmail(ctx context.Context, email UserEmail) (*User, error) {
 	if email.IsZero() {
 		return nil, ErrInvalidInput
 	}
 
-	return s.store.FindByEmail(ctx, email)
+	return activeUser(s.store.FindByEmail(ctx, email))
+}
+
+// LookupByID fetches an active user by account identifier. Deactivated
+// accounts yield ErrUserDeactivated.
+func (s *Service) LookupByID(ctx context.Context, id string) (*User, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, ErrInvalidInput
+	}
+
+	return activeUser(s.store.FindByID(ctx, id))
+}
+
+// activeUser passes through a store lookup, refusing deactivated accounts.
+func activeUser(account *User, err error) (*User, error) {
+	if err != nil {
+		return nil, err
+	}
+	if !account.Active() {
+		return nil, ErrUserDeactivated
+	}
+	return account, nil
 }
 
 // Register provisions a new user account for the provided credentials.
@@ -74,6 +127,9 @@ func (s *Service) Register(ctx context.Context, email UserEmail, password string
 	if email.IsZero() || password == "" {
 		return nil, ErrInvalidInput
 	}
+	if _, ok := s.passwordBackend(email); ok {
+		return nil, ErrDirectoryDomain
+	}
 	if err := ValidatePassword(password); err != nil {
 		return nil, err
 	}
@@ -110,43 +166,174 @@ func (s *Service) Register(ctx context.Context, email UserEmail, password string
 	return &user, nil
 }
 
-// EnsureExternalUser retrieves or provisions an account authenticated by an external provider.
-func (s *Service) EnsureExternalUser(ctx context.Context, email UserEmail, provider, subject string, verified bool) (*User, error) {
-	if email.IsZero() {
+// EnsureExternalUser returns the account linked to identity, provisioning a new
+// one when neither the identity nor its email is known. An email that already
+// belongs to a different account yields ErrLinkRequired: the caller must have
+// the user prove ownership of that account before calling LinkExternalIdentity.
+// The exception is an account provisioned by a directory that trusts the
+// identity's provider, which the identity claims on its first login.
+func (s *Service) EnsureExternalUser(ctx context.Context, identity ExternalIdentity) (*User, error) {
+	if identity.Email.IsZero() {
 		return nil, ErrInvalidInput
 	}
-	if strings.TrimSpace(provider) == "" {
+	if strings.TrimSpace(identity.Provider) == "" {
 		return nil, ErrProviderRequired
 	}
-	if strings.TrimSpace(subject) == "" {
+	if strings.TrimSpace(identity.Subject) == "" {
 		return nil, ErrSubjectRequired
 	}
 
-	account, err := s.store.FindByEmail(ctx, email)
+	account, err := s.store.FindByOAuthSubject(ctx, identity.Provider, identity.Subject)
 	switch {
 	case err == nil:
-		return account, nil
+		if !account.Active() {
+			return nil, ErrUserDeactivated
+		}
+		if err := s.saveProviderToken(ctx, identity); err != nil {
+			return nil, err
+		}
+		return s.refreshExternalProfile(ctx, account, identity)
 	case !errors.Is(err, ErrUserNotFound):
 		return nil, err
 	}
 
+	if existing, err := s.store.FindByEmail(ctx, identity.Email); err == nil {
+		if !s.claimsDirectoryAccount(existing, identity) {
+			return nil, ErrLinkRequired
+		}
+		if !existing.Active() {
+			return nil, ErrUserDeactivated
+		}
+		if err := s.store.LinkOAuthAccount(ctx, existing.ID, identity); err != nil {
+			return nil, err
+		}
+		if err := s.saveProviderToken(ctx, identity); err != nil {
+			return nil, err
+		}
+		return s.store.FindByOAuthSubject(ctx, identity.Provider, identity.Subject)
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
 	id, err := generateUserID()
 	if err != nil {
 		return nil, fmt.Errorf("generate user id: %w", err)
 	}
 
+	now := time.Now().UTC()
+	displayName, _ := nextDisplayName("", identity.Profile)
 	user := User{
 		ID:                 id,
-		Email:              email,
-		Provider:           provider,
-		OAuthSubject:       subject,
-		OAuthEmailVerified: verified,
-		CreatedAt:          time.Now().UTC(),
+		Email:              identity.Email,
+		Provider:           identity.Provider,
+		OAuthSubject:       identity.Subject,
+		OAuthEmailVerified: identity.EmailVerified,
+		CreatedAt:          now,
+		DisplayName:        displayName,
+		AvatarURL:          identity.Profile.Picture,
+		Identities: []Identity{{
+			Provider:      identity.Provider,
+			Subject:       identity.Subject,
+			Email:         identity.Email.String(),
+			EmailVerified: identity.EmailVerified,
+			Profile:       identity.Profile,
+			LinkedAt:      now,
+			UpdatedAt:     now,
+		}},
 	}
 
 	if err := s.store.Create(ctx, user); err != nil {
 		return nil, err
 	}
+	if err := s.saveProviderToken(ctx, identity); err != nil {
+		return nil, err
+	}
 
 	return &user, nil
 }
+
+// LinkExternalIdentity attaches identity to account. Callers must only invoke it
+// once the user has authenticated as account in the current session. Linking an
+// identity the account already owns only stores its fresh provider token, which
+// is how incremental consent lands newly granted scopes.
+func (s *Service) LinkExternalIdentity(ctx context.Context, account *User, identity ExternalIdentity) error {
+	if account == nil || account.ID == "" {
+		return ErrInvalidInput
+	}
+	if strings.TrimSpace(identity.Provider) == "" {
+		return ErrProviderRequired
+	}
+	if strings.TrimSpace(identity.Subject) == "" {
+		return ErrSubjectRequired
+	}
+
+	linked, err := s.store.FindByOAuthSubject(ctx, identity.Provider, identity.Subject)
+	switch {
+	case err == nil && linked.ID == account.ID:
+		return s.saveProviderToken(ctx, identity)
+	case err == nil:
+		return ErrIdentityLinked
+	case !errors.Is(err, ErrUserNotFound):
+		return err
+	}
+
+	if err := s.store.LinkOAuthAccount(ctx, account.ID, identity); err != nil {
+		return err
+	}
+	if err := s.saveProviderToken(ctx, identity); err != nil {
+		return err
+	}
+
+	// Linking is not a login with the new identity, so its profile only fills
+	// a display name the account does not have yet.
+	if account.DisplayName == "" {
+		if name, changed := nextDisplayName("", identity.Profile); changed {
+			if err := s.store.UpdateDisplayName(ctx, account.ID, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshExternalProfile stores the claims from the latest login with identity
+// and re-derives the display name before returning the reloaded account.
+func (s *Service) refreshExternalProfile(ctx context.Context, account *User, identity ExternalIdentity) (*User, error) {
+	if err := s.store.UpdateOAuthAccount(ctx, identity); err != nil {
+		return nil, err
+	}
+	// A directory-managed account takes its display name from the directory.
+	if account.Directory != "" {
+		return s.store.FindByOAuthSubject(ctx, identity.Provider, identity.Subject)
+	}
+	if name, changed := nextDisplayName(account.DisplayName, identity.Profile); changed {
+		if err := s.store.UpdateDisplayName(ctx, account.ID, name); err != nil {
+			return nil, err
+		}
+	}
+	return s.store.FindByOAuthSubject(ctx, identity.Provider, identity.Subject)
+}
+
+// UnlinkExternalIdentity detaches the provider identity from account unless it
+// is the account's only remaining login method.
+func (s *Service) UnlinkExternalIdentity(ctx context.Context, account *User, provider, subject string) error {
+	if account == nil || account.Email.IsZero() {
+		return ErrInvalidInput
+	}
+	if provider == ProviderPassword {
+		return ErrInvalidInput
+	}
+
+	current, err := s.store.FindByEmail(ctx, account.Email)
+	if err != nil {
+		return err
+	}
+	if _, ok := current.Identity(provider, subject); !ok {
+		return ErrIdentityNotFound
+	}
+	if len(current.Identities) <= 1 {
+		return ErrLastLoginMethod
+	}
+
+	return s.store.UnlinkOAuthAccount(ctx, current.ID, provider, subject)
+}
@@ -178,20 +178,25 @@ func TestServiceEnsureExternalUser(t *testing.T) {
 	if err := store.Create(ctx, User{ID: "existing-google", Email: googleEmail, Provider: ProviderGoogle, OAuthSubject: "existing-sub"}); err != nil {
 		t.Fatalf("seed external user: %v", err)
 	}
+	passwordEmail := MustUserEmail("password@example.com")
+	if err := store.Create(ctx, User{ID: "existing-password", Email: passwordEmail, Provider: ProviderPassword}); err != nil {
+		t.Fatalf("seed password user: %v", err)
+	}
 
 	tests := map[string]struct {
-		email    UserEmail
-		provider string
-		subject  string
-		verified bool
+		identity ExternalIdentity
 		wantErr  error
+		wantID   string
 		wantNew  bool
 	}{
-		"missing email":    {email: UserEmail(""), provider: ProviderGoogle, subject: "sub", wantErr: ErrInvalidInput},
-		"missing provider": {email: MustUserEmail("new@example.com"), provider: "", subject: "sub", wantErr: ErrProviderRequired},
-		"missing subject":  {email: MustUserEmail("new@example.com"), provider: ProviderGoogle, subject: "", wantErr: ErrSubjectRequired},
-		"existing":         {email: googleEmail, provider: ProviderGoogle, subject: "existing-sub", wantErr: nil, wantNew: false},
-		"provision":        {email: MustUserEmail("brandnew@example.com"), provider: ProviderGoogle, subject: "new-sub", verified: true, wantErr: nil, wantNew: true},
+		"missing email":    {identity: ExternalIdentity{Provider: ProviderGoogle, Subject: "sub"}, wantErr: ErrInvalidInput},
+		"missing provider": {identity: ExternalIdentity{Email: MustUserEmail("new@example.com"), Subject: "sub"}, wantErr: ErrProviderRequired},
+		"missing subject":  {identity: ExternalIdentity{Email: MustUserEmail("new@example.com"), Provider: ProviderGoogle}, wantErr: ErrSubjectRequired},
+		"existing":         {identity: ExternalIdentity{Email: googleEmail, Provider: ProviderGoogle, Subject: "existing-sub"}, wantID: "existing-google"},
+		"renamed email":    {identity: ExternalIdentity{Email: MustUserEmail("renamed@example.com"), Provider: ProviderGoogle, Subject: "existing-sub"}, wantID: "existing-google"},
+		"email collision":  {identity: ExternalIdentity{Email: passwordEmail, Provider: ProviderGoogle, Subject: "attacker-sub", EmailVerified: true}, wantErr: ErrLinkRequired},
+		"other subject":    {identity: ExternalIdentity{Email: googleEmail, Provider: ProviderGoogle, Subject: "other-sub", EmailVerified: true}, wantErr: ErrLinkRequired},
+		"provision":        {identity: ExternalIdentity{Email: MustUserEmail("brandnew@example.com"), Provider: ProviderGoogle, Subject: "new-sub", EmailVerified: true}, wantNew: true},
 	}
 
 	for name, tc := range tests {
@@ -199,7 +204,7 @@ func TestServiceEnsureExternalUser(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			user, err := service.EnsureExternalUser(ctx, tc.email, tc.provider, tc.subject, tc.verified)
+			user, err := service.EnsureExternalUser(ctx, tc.identity)
 			if tc.wantErr != nil {
 				if !errors.Is(err, tc.wantErr) {
 					t.Fatalf("expected %v, got %v", tc.wantErr, err)
@@ -215,22 +220,156 @@ func TestServiceEnsureExternalUser(t *testing.T) {
 			if user == nil {
 				t.Fatal("expected user")
 			}
-			if user.Email != tc.email {
-				t.Fatalf("expected email %q, got %q", tc.email, user.Email)
+			if tc.wantID != "" && user.ID != tc.wantID {
+				t.Fatalf("expected user %q, got %q", tc.wantID, user.ID)
 			}
-			if user.Provider != tc.provider {
-				t.Fatalf("expected provider %q, got %q", tc.provider, user.Provider)
+			if !tc.wantNew {
+				return
 			}
-			persisted, err := store.FindByEmail(ctx, tc.email)
+			if user.Email != tc.identity.Email || user.Provider != tc.identity.Provider {
+				t.Fatalf("expected provisioned %s user, got %+v", tc.identity.Provider, user)
+			}
+			persisted, err := store.FindByOAuthSubject(ctx, tc.identity.Provider, tc.identity.Subject)
 			if err != nil {
 				t.Fatalf("expected user persisted: %v", err)
 			}
-			if persisted.OAuthSubject != "" && persisted.OAuthSubject != tc.subject {
-				t.Fatalf("expected oauth subject %q, got %q", tc.subject, persisted.OAuthSubject)
-			}
-			if tc.wantNew && persisted.CreatedAt.IsZero() {
+			if persisted.CreatedAt.IsZero() {
 				t.Fatal("expected created at timestamp for new user")
 			}
 		})
 	}
 }
+
+func TestServiceLinkExternalIdentity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service := NewService(store)
+
+	owner, err := service.Register(ctx, MustUserEmail("owner@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register owner: %v", err)
+	}
+	other, err := service.Register(ctx, MustUserEmail("other@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register other: %v", err)
+	}
+
+	identity := ExternalIdentity{Provider: ProviderGitHub, Subject: "42", Email: owner.Email, EmailVerified: true}
+	if _, err := service.EnsureExternalUser(ctx, identity); !errors.Is(err, ErrLinkRequired) {
+		t.Fatalf("expected ErrLinkRequired before linking, got %v", err)
+	}
+
+	if err := service.LinkExternalIdentity(ctx, owner, identity); err != nil {
+		t.Fatalf("link identity: %v", err)
+	}
+	if err := service.LinkExternalIdentity(ctx, owner, identity); err != nil {
+		t.Fatalf("expected relinking to the same account to be a no-op, got %v", err)
+	}
+	if err := service.LinkExternalIdentity(ctx, other, identity); !errors.Is(err, ErrIdentityLinked) {
+		t.Fatalf("expected ErrIdentityLinked for another account, got %v", err)
+	}
+
+	account, err := service.EnsureExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("ensure linked identity: %v", err)
+	}
+	if account.ID != owner.ID {
+		t.Fatalf("expected linked identity to resolve to %q, got %q", owner.ID, account.ID)
+	}
+}
+
+func TestServiceUnlinkExternalIdentity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service := NewService(store)
+
+	owner, err := service.Register(ctx, MustUserEmail("multi@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register owner: %v", err)
+	}
+	github := ExternalIdentity{Provider: ProviderGitHub, Subject: "7", Email: owner.Email, EmailVerified: true}
+	if err := service.LinkExternalIdentity(ctx, owner, github); err != nil {
+		t.Fatalf("link identity: %v", err)
+	}
+
+	loaded, err := service.LookupByEmail(ctx, owner.Email)
+	if err != nil {
+		t.Fatalf("lookup owner: %v", err)
+	}
+	if len(loaded.Identities) != 2 || loaded.Identities[0].Provider != ProviderPassword || loaded.Identities[1].Provider != ProviderGitHub {
+		t.Fatalf("expected password and github identities, got %+v", loaded.Identities)
+	}
+
+	solo, err := service.EnsureExternalUser(ctx, ExternalIdentity{Provider: ProviderGoogle, Subject: "solo", Email: MustUserEmail("solo@example.com"), EmailVerified: true})
+	if err != nil {
+		t.Fatalf("provision solo user: %v", err)
+	}
+
+	tests := map[string]struct {
+		account  *User
+		provider string
+		subject  string
+		wantErr  error
+	}{
+		"unknown identity":  {account: owner, provider: ProviderGoogle, subject: "nope", wantErr: ErrIdentityNotFound},
+		"password":          {account: owner, provider: ProviderPassword, wantErr: ErrInvalidInput},
+		"last login method": {account: solo, provider: ProviderGoogle, subject: "solo", wantErr: ErrLastLoginMethod},
+		"other account":     {account: solo, provider: ProviderGitHub, subject: "7", wantErr: ErrIdentityNotFound},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := service.UnlinkExternalIdentity(ctx, tc.account, tc.provider, tc.subject); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if err := service.UnlinkExternalIdentity(ctx, owner, ProviderGitHub, "7"); err != nil {
+		t.Fatalf("unlink github: %v", err)
+	}
+	if _, err := store.FindByOAuthSubject(ctx, ProviderGitHub, "7"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected unlinked identity to be gone, got %v", err)
+	}
+}
+
+func TestServiceEnsureExternalUserRefreshesProfile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service := NewService(NewMemoryStore())
+
+	identity := ExternalIdentity{
+		Provider:      ProviderGitHub,
+		Subject:       "11",
+		Email:         MustUserEmail("ada@example.com"),
+		EmailVerified: true,
+		Profile:       Profile{Nickname: "ada", Picture: "https://avatars.example.com/ada-1.png"},
+	}
+	created, err := service.EnsureExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("provision user: %v", err)
+	}
+	if created.DisplayName != "ada" || created.AvatarURL != identity.Profile.Picture {
+		t.Fatalf("expected handle and avatar on new account, got %q %q", created.DisplayName, created.AvatarURL)
+	}
+
+	identity.Profile = Profile{Name: "Ada Lovelace", Nickname: "ada", Picture: "https://avatars.example.com/ada-2.png", Locale: "en-GB"}
+	refreshed, err := service.EnsureExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("sign in again: %v", err)
+	}
+	if refreshed.DisplayName != "Ada Lovelace" {
+		t.Fatalf("expected real name to replace handle, got %q", refreshed.DisplayName)
+	}
+	if refreshed.AvatarURL != "https://avatars.example.com/ada-2.png" {
+		t.Fatalf("expected refreshed avatar, got %q", refreshed.AvatarURL)
+	}
+	stored, ok := refreshed.Identity(ProviderGitHub, "11")
+	if !ok || stored.Profile.Locale != "en-GB" {
+		t.Fatalf("expected refreshed profile on identity, got %+v", stored)
+	}
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 // ErrUserNotFound signals no user exists for the provided lookup criteria.
@@ -10,10 +11,66 @@ var (
 	ErrUserNotFound    = errors.New("auth: user not found")
 	ErrEmailRequired   = errors.New("auth: email required")
 	ErrSubjectRequired = errors.New("auth: oauth subject required")
+	// ErrIdentityLinked signals the external identity already belongs to an account.
+	ErrIdentityLinked = errors.New("auth: identity already linked to an account")
+	// ErrIdentityNotFound signals the identity is not attached to the account.
+	ErrIdentityNotFound = errors.New("auth: identity not linked to account")
 )
 
+// UserQuery selects the accounts a directory manages for ListUsers.
+type UserQuery struct {
+	Directory string
+	// Email, when set, restricts the result to the account with that email.
+	Email UserEmail
+	// Offset skips that many accounts; Limit caps the page, and zero returns
+	// only the total.
+	Offset int
+	Limit  int
+}
+
 // UserStore defines persistence expectations for user lookups.
 type UserStore interface {
 	FindByEmail(ctx context.Context, email UserEmail) (*User, error)
+	// FindByID returns the user with the stable account identifier.
+	FindByID(ctx context.Context, id string) (*User, error)
+	// FindByOAuthSubject returns the user linked to the provider subject.
+	FindByOAuthSubject(ctx context.Context, provider, subject string) (*User, error)
 	Create(ctx context.Context, user User) error
+	// LinkOAuthAccount attaches an external identity to an existing user.
+	LinkOAuthAccount(ctx context.Context, userID string, identity ExternalIdentity) error
+	// UnlinkOAuthAccount detaches an external identity, refusing to remove the
+	// account's last login method.
+	UnlinkOAuthAccount(ctx context.Context, userID, provider, subject string) error
+	// UpdateOAuthAccount refreshes the email and profile stored for a linked identity.
+	UpdateOAuthAccount(ctx context.Context, identity ExternalIdentity) error
+	// UpdateDisplayName stores the user's display name.
+	UpdateDisplayName(ctx context.Context, userID, displayName string) error
+	// UpdateUser stores the email, display name, directory and external ID of
+	// user, or fails with ErrUserNotFound or ErrEmailExists.
+	UpdateUser(ctx context.Context, user User) error
+	// ListUsers returns the page of users query selects, oldest first, and
+	// the number of users it matches in total.
+	ListUsers(ctx context.Context, query UserQuery) ([]User, int, error)
+	// DeactivateUser marks the user deactivated as of at.
+	DeactivateUser(ctx context.Context, userID string, at time.Time) error
+	// ReactivateUser lifts the user's deactivation.
+	ReactivateUser(ctx context.Context, userID string) error
+	// SaveOAuthToken upserts the sealed provider token for a linked identity,
+	// keeping the stored refresh token when sealed has none and merging scopes.
+	SaveOAuthToken(ctx context.Context, sealed SealedToken) error
+	// FindOAuthToken returns the most recently updated token the user holds at
+	// provider, or ErrTokenNotFound.
+	FindOAuthToken(ctx context.Context, userID, provider string) (SealedToken, error)
+	// CreatePersonalToken stores a personal access token for its user.
+	CreatePersonalToken(ctx context.Context, token PersonalToken) error
+	// ListPersonalTokens returns the user's personal access tokens, newest first.
+	ListPersonalTokens(ctx context.Context, userID string) ([]PersonalToken, error)
+	// FindPersonalToken returns the token stored under tokenHash, or
+	// ErrPersonalTokenNotFound.
+	FindPersonalToken(ctx context.Context, tokenHash []byte) (PersonalToken, error)
+	// TouchPersonalToken records when and from which address a token was last used.
+	TouchPersonalToken(ctx context.Context, id string, usedAt time.Time, ip string) error
+	// DeletePersonalToken removes one of the user's tokens, or returns
+	// ErrPersonalTokenNotFound.
+	DeletePersonalToken(ctx context.Context, userID, id string) error
 }
@@ -1,19 +1,44 @@
 package auth
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"slices"
 	"sync"
+	"time"
 )
 
 // MemoryStore is an in-memory implementation of UserStore for development and tests.
 type MemoryStore struct {
 	mu    sync.RWMutex
 	users map[string]User
+	// links maps provider/subject pairs to the owning user's email.
+	links map[oauthKey]memoryLink
+	// tokens holds sealed provider tokens per linked identity.
+	tokens map[oauthKey]SealedToken
+	// personalTokens holds personal access tokens by ID.
+	personalTokens map[string]PersonalToken
+}
+
+type oauthKey struct {
+	provider string
+	subject  string
+}
+
+type memoryLink struct {
+	email    string
+	identity Identity
 }
 
 // NewMemoryStore builds an empty MemoryStore instance.
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{users: make(map[string]User)}
+	return &MemoryStore{
+		users:          make(map[string]User),
+		links:          make(map[oauthKey]memoryLink),
+		tokens:         make(map[oauthKey]SealedToken),
+		personalTokens: make(map[string]PersonalToken),
+	}
 }
 
 // FindByEmail returns a copy of the stored user.
@@ -30,8 +55,37 @@ func (s *MemoryStore) FindByEmail(_ context.Context, email UserEmail) (*User, er
 		return nil, ErrUserNotFound
 	}
 
-	userCopy := user
-	return &userCopy, nil
+	return s.withIdentities(user), nil
+}
+
+// FindByID returns a copy of the user with id.
+func (s *MemoryStore) FindByID(_ context.Context, id string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.ID != "" && user.ID == id {
+			return s.withIdentities(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// FindByOAuthSubject returns a copy of the user linked to provider and subject.
+func (s *MemoryStore) FindByOAuthSubject(_ context.Context, provider, subject string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.links[oauthKey{provider: provider, subject: subject}]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user, ok := s.users[link.email]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return s.withIdentities(user), nil
 }
 
 // Create inserts or replaces the stored user by email.
@@ -46,7 +100,377 @@ func (s *MemoryStore) Create(_ context.Context, user User) error {
 	if s.users == nil {
 		s.users = make(map[string]User)
 	}
+	if s.links == nil {
+		s.links = make(map[oauthKey]memoryLink)
+	}
 
+	initial, _ := user.Identity(user.Provider, user.OAuthSubject)
+	user.Identities = nil
+	user.AvatarURL = ""
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
 	s.users[user.Email.String()] = user
+	if user.Provider != "" && user.Provider != ProviderPassword && user.OAuthSubject != "" {
+		s.links[oauthKey{provider: user.Provider, subject: user.OAuthSubject}] = memoryLink{
+			email: user.Email.String(),
+			identity: Identity{
+				Provider:      user.Provider,
+				Subject:       user.OAuthSubject,
+				Email:         user.Email.String(),
+				EmailVerified: user.OAuthEmailVerified,
+				Profile:       initial.Profile,
+				LinkedAt:      user.CreatedAt,
+				UpdatedAt:     user.CreatedAt,
+			},
+		}
+	}
+	return nil
+}
+
+// LinkOAuthAccount records identity against the user with userID.
+func (s *MemoryStore) LinkOAuthAccount(_ context.Context, userID string, identity ExternalIdentity) error {
+	if identity.Subject == "" {
+		return ErrSubjectRequired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := oauthKey{provider: identity.Provider, subject: identity.Subject}
+	if _, exists := s.links[key]; exists {
+		return ErrIdentityLinked
+	}
+	for email, user := range s.users {
+		if user.ID == userID {
+			if s.links == nil {
+				s.links = make(map[oauthKey]memoryLink)
+			}
+			now := time.Now().UTC()
+			s.links[key] = memoryLink{
+				email: email,
+				identity: Identity{
+					Provider:      identity.Provider,
+					Subject:       identity.Subject,
+					Email:         identity.Email.String(),
+					EmailVerified: identity.EmailVerified,
+					Profile:       identity.Profile,
+					LinkedAt:      now,
+					UpdatedAt:     now,
+				},
+			}
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+// UpdateOAuthAccount replaces the email and profile recorded for identity.
+func (s *MemoryStore) UpdateOAuthAccount(_ context.Context, identity ExternalIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := oauthKey{provider: identity.Provider, subject: identity.Subject}
+	link, ok := s.links[key]
+	if !ok {
+		return ErrIdentityNotFound
+	}
+	link.identity.Email = identity.Email.String()
+	link.identity.EmailVerified = identity.EmailVerified
+	link.identity.Profile = identity.Profile
+	link.identity.UpdatedAt = time.Now().UTC()
+	s.links[key] = link
 	return nil
 }
+
+// UpdateDisplayName sets the display name of the user with userID.
+func (s *MemoryStore) UpdateDisplayName(_ context.Context, userID, displayName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, user := range s.users {
+		if user.ID == userID {
+			user.DisplayName = displayName
+			user.UpdatedAt = time.Now().UTC()
+			s.users[email] = user
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+// UpdateUser replaces the directory-managed fields of the stored user,
+// re-keying the user and its identities when the email changes.
+func (s *MemoryStore) UpdateUser(_ context.Context, user User) error {
+	if user.Email.IsZero() {
+		return ErrEmailRequired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, current := range s.users {
+		if current.ID == "" || current.ID != user.ID {
+			continue
+		}
+		next := user.Email.String()
+		if next != email {
+			if _, taken := s.users[next]; taken {
+				return ErrEmailExists
+			}
+			delete(s.users, email)
+			for key, link := range s.links {
+				if link.email == email {
+					link.email = next
+					s.links[key] = link
+				}
+			}
+		}
+		current.Email = user.Email
+		current.DisplayName = user.DisplayName
+		current.Directory = user.Directory
+		current.ExternalID = user.ExternalID
+		current.UpdatedAt = time.Now().UTC()
+		s.users[next] = current
+		return nil
+	}
+	return ErrUserNotFound
+}
+
+// ListUsers returns the page of the directory's users query selects.
+func (s *MemoryStore) ListUsers(_ context.Context, query UserQuery) ([]User, int, error) {
+	if query.Directory == "" {
+		return nil, 0, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []User
+	for _, user := range s.users {
+		if user.Directory != query.Directory || (!query.Email.IsZero() && user.Email != query.Email) {
+			continue
+		}
+		users = append(users, *s.withIdentities(user))
+	}
+	slices.SortFunc(users, func(a, b User) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	total := len(users)
+	start := min(max(query.Offset, 0), total)
+	end := min(start+max(query.Limit, 0), total)
+	return users[start:end], total, nil
+}
+
+// DeactivateUser marks the user with userID deactivated as of at.
+func (s *MemoryStore) DeactivateUser(_ context.Context, userID string, at time.Time) error {
+	return s.setDeactivatedAt(userID, at)
+}
+
+// ReactivateUser clears the deactivation of the user with userID.
+func (s *MemoryStore) ReactivateUser(_ context.Context, userID string) error {
+	return s.setDeactivatedAt(userID, time.Time{})
+}
+
+func (s *MemoryStore) setDeactivatedAt(userID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email, user := range s.users {
+		if user.ID != "" && user.ID == userID {
+			user.DeactivatedAt = at
+			user.UpdatedAt = time.Now().UTC()
+			s.users[email] = user
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+// UnlinkOAuthAccount removes the identity unless it is the user's last login method.
+func (s *MemoryStore) UnlinkOAuthAccount(_ context.Context, userID, provider, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := oauthKey{provider: provider, subject: subject}
+	link, ok := s.links[key]
+	if !ok {
+		return ErrIdentityNotFound
+	}
+	user, ok := s.users[link.email]
+	if !ok || user.ID != userID {
+		return ErrIdentityNotFound
+	}
+	if len(s.withIdentities(user).Identities) <= 1 {
+		return ErrLastLoginMethod
+	}
+
+	delete(s.links, key)
+	delete(s.tokens, key)
+	return nil
+}
+
+// SaveOAuthToken stores sealed for its identity, keeping the previous refresh
+// token when sealed carries none and merging granted scopes.
+func (s *MemoryStore) SaveOAuthToken(_ context.Context, sealed SealedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := oauthKey{provider: sealed.Provider, subject: sealed.Subject}
+	if _, ok := s.links[key]; !ok {
+		return ErrIdentityNotFound
+	}
+	if previous, ok := s.tokens[key]; ok {
+		if len(sealed.RefreshToken) == 0 {
+			sealed.RefreshToken = previous.RefreshToken
+		}
+		sealed.Scopes = MergeScopes(previous.Scopes, sealed.Scopes)
+	}
+	if s.tokens == nil {
+		s.tokens = make(map[oauthKey]SealedToken)
+	}
+	sealed.UpdatedAt = time.Now().UTC()
+	s.tokens[key] = sealed
+	return nil
+}
+
+// FindOAuthToken returns the newest token the user holds at provider.
+func (s *MemoryStore) FindOAuthToken(_ context.Context, userID, provider string) (SealedToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		found SealedToken
+		ok    bool
+	)
+	for key, token := range s.tokens {
+		if key.provider != provider {
+			continue
+		}
+		user, exists := s.users[s.links[key].email]
+		if !exists || user.ID != userID {
+			continue
+		}
+		if !ok || token.UpdatedAt.After(found.UpdatedAt) {
+			found, ok = token, true
+		}
+	}
+	if !ok {
+		return SealedToken{}, ErrTokenNotFound
+	}
+	return found, nil
+}
+
+// CreatePersonalToken stores token for its user.
+func (s *MemoryStore) CreatePersonalToken(_ context.Context, token PersonalToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasUserID(token.UserID) {
+		return ErrUserNotFound
+	}
+	if s.personalTokens == nil {
+		s.personalTokens = make(map[string]PersonalToken)
+	}
+	token.Scopes = slices.Clone(token.Scopes)
+	s.personalTokens[token.ID] = token
+	return nil
+}
+
+// ListPersonalTokens returns the user's tokens, newest first.
+func (s *MemoryStore) ListPersonalTokens(_ context.Context, userID string) ([]PersonalToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []PersonalToken
+	for _, token := range s.personalTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b PersonalToken) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return tokens, nil
+}
+
+// FindPersonalToken returns the token stored under tokenHash.
+func (s *MemoryStore) FindPersonalToken(_ context.Context, tokenHash []byte) (PersonalToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.personalTokens {
+		if bytes.Equal(token.TokenHash, tokenHash) {
+			return token, nil
+		}
+	}
+	return PersonalToken{}, ErrPersonalTokenNotFound
+}
+
+// TouchPersonalToken records the token's latest use.
+func (s *MemoryStore) TouchPersonalToken(_ context.Context, id string, usedAt time.Time, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.personalTokens[id]
+	if !ok {
+		return ErrPersonalTokenNotFound
+	}
+	token.LastUsedAt, token.LastUsedIP = usedAt, ip
+	s.personalTokens[id] = token
+	return nil
+}
+
+// DeletePersonalToken removes the user's token with id.
+func (s *MemoryStore) DeletePersonalToken(_ context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.personalTokens[id]
+	if !ok || token.UserID != userID {
+		return ErrPersonalTokenNotFound
+	}
+	delete(s.personalTokens, id)
+	return nil
+}
+
+// hasUserID reports whether a user with id exists. Callers must hold s.mu.
+func (s *MemoryStore) hasUserID(id string) bool {
+	for _, user := range s.users {
+		if user.ID != "" && user.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withIdentities returns a copy of user with its login methods attached.
+// Callers must hold s.mu.
+func (s *MemoryStore) withIdentities(user User) *User {
+	userCopy := user
+	userCopy.Identities = nil
+	if user.HasPassword() {
+		userCopy.Identities = append(userCopy.Identities, Identity{
+			Provider: ProviderPassword,
+			Email:    user.Email.String(),
+			LinkedAt: user.CreatedAt,
+		})
+	}
+	for _, link := range s.links {
+		if link.email == user.Email.String() {
+			userCopy.Identities = append(userCopy.Identities, link.identity)
+		}
+	}
+	slices.SortStableFunc(userCopy.Identities, compareIdentities)
+	userCopy.AvatarURL = latestPicture(userCopy.Identities)
+	return &userCopy
+}
+
+// compareIdentities orders identities by link time, then provider and subject.
+func compareIdentities(a, b Identity) int {
+	return cmp.Or(
+		a.LinkedAt.Compare(b.LinkedAt),
+		cmp.Compare(a.Provider, b.Provider),
+		cmp.Compare(a.Subject, b.Subject),
+	)
+}
@@ -3,8 +3,12 @@ package auth
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -42,30 +46,497 @@ func (s *SQLStore) FindByEmail(ctx context.Context, email UserEmail) (*User, err
 		return nil, fmt.Errorf("lookup user: %w", err)
 	}
 
+	return s.loadUser(ctx, row)
+}
+
+// FindByID returns the stored user aggregate by account identifier.
+func (s *SQLStore) FindByID(ctx context.Context, id string) (*User, error) {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	row, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+
+	return s.loadUser(ctx, row)
+}
+
+// FindByOAuthSubject returns the user aggregate linked to provider and subject.
+func (s *SQLStore) FindByOAuthSubject(ctx context.Context, provider, subject string) (*User, error) {
+	acct, err := s.queries.GetUserOAuthAccountByProviderSubject(ctx, db.GetUserOAuthAccountByProviderSubjectParams{
+		Provider: provider,
+		Subject:  subject,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("lookup oauth account: %w", err)
+	}
+
+	row, err := s.queries.GetUserByID(ctx, acct.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+
+	return s.loadUser(ctx, row)
+}
+
+// LinkOAuthAccount attaches identity to the user with userID.
+func (s *SQLStore) LinkOAuthAccount(ctx context.Context, userID string, identity ExternalIdentity) error {
+	if identity.Subject == "" {
+		return ErrSubjectRequired
+	}
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+	profile, err := encodeProfile(identity.Profile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.queries.CreateUserOAuthAccount(ctx, db.CreateUserOAuthAccountParams{
+		UserID:        id,
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         pgtype.Text{String: identity.Email.String(), Valid: !identity.Email.IsZero()},
+		EmailVerified: identity.EmailVerified,
+		Profile:       profile,
+	}); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrIdentityLinked
+		}
+		return fmt.Errorf("insert oauth account: %w", err)
+	}
+	return nil
+}
+
+// UnlinkOAuthAccount deletes the identity in a single statement that refuses to
+// remove the user's last login method, so concurrent unlinks cannot race.
+func (s *SQLStore) UnlinkOAuthAccount(ctx context.Context, userID, provider, subject string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+
+	deleted, err := s.queries.DeleteUserOAuthAccount(ctx, db.DeleteUserOAuthAccountParams{
+		UserID:   id,
+		Provider: provider,
+		Subject:  subject,
+	})
+	if err != nil {
+		return fmt.Errorf("delete oauth account: %w", err)
+	}
+	if deleted == 0 {
+		if _, err := s.queries.GetUserOAuthAccountByProviderSubject(ctx, db.GetUserOAuthAccountByProviderSubjectParams{
+			Provider: provider,
+			Subject:  subject,
+		}); err == nil {
+			return ErrLastLoginMethod
+		}
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// UpdateOAuthAccount refreshes the email and profile stored for identity.
+func (s *SQLStore) UpdateOAuthAccount(ctx context.Context, identity ExternalIdentity) error {
+	profile, err := encodeProfile(identity.Profile)
+	if err != nil {
+		return err
+	}
+
+	updated, err := s.queries.UpdateUserOAuthAccount(ctx, db.UpdateUserOAuthAccountParams{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         pgtype.Text{String: identity.Email.String(), Valid: !identity.Email.IsZero()},
+		EmailVerified: identity.EmailVerified,
+		Profile:       profile,
+	})
+	if err != nil {
+		return fmt.Errorf("update oauth account: %w", err)
+	}
+	if updated == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// UpdateDisplayName stores displayName for the user with userID.
+func (s *SQLStore) UpdateDisplayName(ctx context.Context, userID, displayName string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+
+	if err := s.queries.UpdateUserDisplayName(ctx, db.UpdateUserDisplayNameParams{
+		ID:          id,
+		DisplayName: pgtype.Text{String: displayName, Valid: displayName != ""},
+	}); err != nil {
+		return fmt.Errorf("update display name: %w", err)
+	}
+	return nil
+}
+
+// UpdateUser stores the directory-managed fields of user.
+func (s *SQLStore) UpdateUser(ctx context.Context, user User) error {
+	if user.Email.IsZero() {
+		return ErrEmailRequired
+	}
+	id, err := uuid.Parse(user.ID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	updated, err := s.queries.UpdateUser(ctx, db.UpdateUserParams{
+		ID:          id,
+		Email:       user.Email.String(),
+		DisplayName: pgtype.Text{String: user.DisplayName, Valid: user.DisplayName != ""},
+		Directory:   pgtype.Text{String: user.Directory, Valid: user.Directory != ""},
+		ExternalID:  pgtype.Text{String: user.ExternalID, Valid: user.ExternalID != ""},
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrEmailExists
+		}
+		return fmt.Errorf("update user: %w", err)
+	}
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ListUsers returns the page of the directory's users query selects. An
+// email filter is answered by the unique email index instead of a scan.
+func (s *SQLStore) ListUsers(ctx context.Context, query UserQuery) ([]User, int, error) {
+	if query.Directory == "" {
+		return nil, 0, nil
+	}
+	directory := pgtype.Text{String: query.Directory, Valid: true}
+
+	var rows []db.User
+	total := 0
+	if !query.Email.IsZero() {
+		row, err := s.queries.GetUserByEmail(ctx, query.Email.String())
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return nil, 0, fmt.Errorf("lookup user: %w", err)
+		case row.Directory == directory:
+			total = 1
+			if query.Offset <= 0 && query.Limit > 0 {
+				rows = append(rows, row)
+			}
+		}
+	} else {
+		count, err := s.queries.CountUsersByDirectory(ctx, directory)
+		if err != nil {
+			return nil, 0, fmt.Errorf("count users: %w", err)
+		}
+		total = int(count)
+		if query.Limit > 0 {
+			if rows, err = s.queries.ListUsersByDirectory(ctx, db.ListUsersByDirectoryParams{
+				Directory: directory,
+				Limit:     int32(min(query.Limit, math.MaxInt32)),
+				Offset:    int32(min(max(query.Offset, 0), math.MaxInt32)),
+			}); err != nil {
+				return nil, 0, fmt.Errorf("list users: %w", err)
+			}
+		}
+	}
+
+	users := make([]User, 0, len(rows))
+	for _, row := range rows {
+		user, err := s.loadUser(ctx, row)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+	return users, total, nil
+}
+
+// DeactivateUser marks the user with userID deactivated as of at.
+func (s *SQLStore) DeactivateUser(ctx context.Context, userID string, at time.Time) error {
+	return s.setDeactivatedAt(ctx, userID, pgtype.Timestamptz{Time: at, Valid: true})
+}
+
+// ReactivateUser clears the deactivation of the user with userID.
+func (s *SQLStore) ReactivateUser(ctx context.Context, userID string) error {
+	return s.setDeactivatedAt(ctx, userID, pgtype.Timestamptz{})
+}
+
+func (s *SQLStore) setDeactivatedAt(ctx context.Context, userID string, at pgtype.Timestamptz) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	updated, err := s.queries.SetUserDeactivatedAt(ctx, db.SetUserDeactivatedAtParams{ID: id, DeactivatedAt: at})
+	if err != nil {
+		return fmt.Errorf("set user deactivation: %w", err)
+	}
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SaveOAuthToken upserts the sealed token for its identity. The statement keeps
+// the stored refresh token when sealed has none and unions the granted scopes.
+func (s *SQLStore) SaveOAuthToken(ctx context.Context, sealed SealedToken) error {
+	scopes := sealed.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	saved, err := s.queries.UpsertUserOAuthToken(ctx, db.UpsertUserOAuthTokenParams{
+		Provider:     sealed.Provider,
+		Subject:      sealed.Subject,
+		AccessToken:  sealed.AccessToken,
+		RefreshToken: sealed.RefreshToken,
+		TokenType:    sealed.TokenType,
+		Scopes:       scopes,
+		ExpiresAt:    pgtype.Timestamptz{Time: sealed.Expiry, Valid: !sealed.Expiry.IsZero()},
+	})
+	if err != nil {
+		return fmt.Errorf("upsert oauth token: %w", err)
+	}
+	if saved == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// FindOAuthToken returns the most recently updated token the user holds at provider.
+func (s *SQLStore) FindOAuthToken(ctx context.Context, userID, provider string) (SealedToken, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return SealedToken{}, fmt.Errorf("parse user id: %w", err)
+	}
+
+	row, err := s.queries.GetUserOAuthTokenByProvider(ctx, db.GetUserOAuthTokenByProviderParams{
+		UserID:   id,
+		Provider: provider,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return SealedToken{}, ErrTokenNotFound
+		}
+		return SealedToken{}, fmt.Errorf("lookup oauth token: %w", err)
+	}
+
+	return SealedToken{
+		Provider:     row.Provider,
+		Subject:      row.Subject,
+		AccessToken:  row.AccessToken,
+		RefreshToken: row.RefreshToken,
+		TokenType:    row.TokenType,
+		Expiry:       timestamptzValue(row.ExpiresAt),
+		Scopes:       row.Scopes,
+		UpdatedAt:    timestamptzValue(row.UpdatedAt),
+	}, nil
+}
+
+// CreatePersonalToken inserts token for its user.
+func (s *SQLStore) CreatePersonalToken(ctx context.Context, token PersonalToken) error {
+	id, err := uuid.Parse(token.ID)
+	if err != nil {
+		return fmt.Errorf("parse personal access token id: %w", err)
+	}
+	userID, err := uuid.Parse(token.UserID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	if err := s.queries.CreatePersonalAccessToken(ctx, db.CreatePersonalAccessTokenParams{
+		ID:        id,
+		UserID:    userID,
+		Name:      token.Name,
+		TokenHash: token.TokenHash,
+		TokenHint: token.Hint,
+		Scopes:    scopes,
+		ExpiresAt: pgtype.Timestamptz{Time: token.ExpiresAt, Valid: true},
+		CreatedAt: pgtype.Timestamptz{Time: token.CreatedAt, Valid: true},
+	}); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("insert personal access token: %w", err)
+	}
+	return nil
+}
+
+// ListPersonalTokens returns the user's tokens, newest first.
+func (s *SQLStore) ListPersonalTokens(ctx context.Context, userID string) ([]PersonalToken, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("parse user id: %w", err)
+	}
+
+	rows, err := s.queries.ListPersonalAccessTokensForUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("list personal access tokens: %w", err)
+	}
+	tokens := make([]PersonalToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, personalTokenFromRow(row))
+	}
+	return tokens, nil
+}
+
+// FindPersonalToken returns the token stored under tokenHash.
+func (s *SQLStore) FindPersonalToken(ctx context.Context, tokenHash []byte) (PersonalToken, error) {
+	row, err := s.queries.GetPersonalAccessTokenByHash(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return PersonalToken{}, ErrPersonalTokenNotFound
+		}
+		return PersonalToken{}, fmt.Errorf("lookup personal access token: %w", err)
+	}
+	return personalTokenFromRow(row), nil
+}
+
+// TouchPersonalToken records the token's latest use. Addresses that do not
+// parse are stored as NULL.
+func (s *SQLStore) TouchPersonalToken(ctx context.Context, id string, usedAt time.Time, ip string) error {
+	tokenID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrPersonalTokenNotFound
+	}
+	var addr *netip.Addr
+	if parsed, err := netip.ParseAddr(ip); err == nil {
+		addr = &parsed
+	}
+
+	touched, err := s.queries.TouchPersonalAccessToken(ctx, db.TouchPersonalAccessTokenParams{
+		ID:         tokenID,
+		LastUsedAt: pgtype.Timestamptz{Time: usedAt, Valid: true},
+		LastUsedIp: addr,
+	})
+	if err != nil {
+		return fmt.Errorf("touch personal access token: %w", err)
+	}
+	if touched == 0 {
+		return ErrPersonalTokenNotFound
+	}
+	return nil
+}
+
+// DeletePersonalToken removes the user's token with id.
+func (s *SQLStore) DeletePersonalToken(ctx context.Context, userID, id string) error {
+	owner, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+	tokenID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrPersonalTokenNotFound
+	}
+
+	deleted, err := s.queries.DeletePersonalAccessToken(ctx, db.DeletePersonalAccessTokenParams{ID: tokenID, UserID: owner})
+	if err != nil {
+		return fmt.Errorf("delete personal access token: %w", err)
+	}
+	if deleted == 0 {
+		return ErrPersonalTokenNotFound
+	}
+	return nil
+}
+
+func personalTokenFromRow(row db.PersonalAccessToken) PersonalToken {
+	token := PersonalToken{
+		ID:         row.ID.String(),
+		UserID:     row.UserID.String(),
+		Name:       row.Name,
+		TokenHash:  row.TokenHash,
+		Hint:       row.TokenHint,
+		Scopes:     row.Scopes,
+		ExpiresAt:  timestamptzValue(row.ExpiresAt),
+		LastUsedAt: timestamptzValue(row.LastUsedAt),
+		CreatedAt:  timestamptzValue(row.CreatedAt),
+	}
+	if row.LastUsedIp != nil {
+		token.LastUsedIP = row.LastUsedIp.String()
+	}
+	return token
+}
+
+func (s *SQLStore) loadUser(ctx context.Context, row db.User) (*User, error) {
 	normalizedEmail, err := NewUserEmail(row.Email)
 	if err != nil {
 		return nil, fmt.Errorf("normalize email: %w", err)
 	}
 
+	id := row.ID
 	user := &User{
-		ID:        row.ID.String(),
-		Email:     normalizedEmail,
-		CreatedAt: timestamptzValue(row.CreatedAt),
+		ID:            id.String(),
+		Email:         normalizedEmail,
+		CreatedAt:     timestamptzValue(row.CreatedAt),
+		UpdatedAt:     timestamptzValue(row.UpdatedAt),
+		DisplayName:   row.DisplayName.String,
+		Directory:     row.Directory.String,
+		ExternalID:    row.ExternalID.String,
+		DeactivatedAt: timestamptzValue(row.DeactivatedAt),
 	}
 
-	if pw, err := s.queries.GetUserPassword(ctx, row.ID); err == nil {
+	if pw, err := s.queries.GetUserPassword(ctx, id); err == nil {
 		user.PasswordSalt = base64.StdEncoding.EncodeToString(pw.PasswordSalt)
 		user.PasswordHash = base64.StdEncoding.EncodeToString(pw.PasswordHash)
 		user.Provider = ProviderPassword
+		user.Identities = append(user.Identities, Identity{
+			Provider: ProviderPassword,
+			Email:    user.Email.String(),
+			LinkedAt: timestamptzValue(pw.CreatedAt),
+		})
 	} else if !errors.Is(err, pgx.ErrNoRows) {
 		return nil, fmt.Errorf("load password: %w", err)
 	}
 
-	oauthAccounts, err := s.queries.ListUserOAuthAccountsByUserID(ctx, row.ID)
+	oauthAccounts, err := s.queries.ListUserOAuthAccountsByUserID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("load oauth accounts: %w", err)
 	}
 
+	for _, acct := range oauthAccounts {
+		var profile Profile
+		if len(acct.Profile) > 0 {
+			if err := json.Unmarshal(acct.Profile, &profile); err != nil {
+				return nil, fmt.Errorf("decode oauth profile: %w", err)
+			}
+		}
+		user.Identities = append(user.Identities, Identity{
+			Provider:      acct.Provider,
+			Subject:       acct.Subject,
+			Email:         acct.Email.String,
+			EmailVerified: acct.EmailVerified,
+			Profile:       profile,
+			LinkedAt:      timestamptzValue(acct.CreatedAt),
+			UpdatedAt:     timestamptzValue(acct.UpdatedAt),
+		})
+	}
+	slices.SortStableFunc(user.Identities, compareIdentities)
+	user.AvatarURL = latestPicture(user.Identities)
+
 	if len(oauthAccounts) > 0 {
 		acct := oauthAccounts[0]
 		if user.Provider == "" {
@@ -105,7 +576,14 @@ func (s *SQLStore) Create(ctx context.Context, user User) error {
 
 	qtx := s.queries.WithTx(tx)
 
-	if _, err = qtx.CreateUser(ctx, db.CreateUserParams{ID: id, Email: user.Email.String()}); err != nil {
+	if _, err = qtx.CreateUser(ctx, db.CreateUserParams{
+		ID:            id,
+		Email:         user.Email.String(),
+		DisplayName:   pgtype.Text{String: user.DisplayName, Valid: user.DisplayName != ""},
+		Directory:     pgtype.Text{String: user.Directory, Valid: user.Directory != ""},
+		ExternalID:    pgtype.Text{String: user.ExternalID, Valid: user.ExternalID != ""},
+		DeactivatedAt: pgtype.Timestamptz{Time: user.DeactivatedAt, Valid: !user.DeactivatedAt.IsZero()},
+	}); err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
 			return ErrEmailExists
@@ -114,6 +592,12 @@ func (s *SQLStore) Create(ctx context.Context, user User) error {
 	}
 
 	switch user.Provider {
+	case "":
+		// Directory-provisioned accounts start without a login method; their
+		// users sign in through the directory's SSO connection.
+		if user.Directory == "" {
+			return ErrProviderRequired
+		}
 	case ProviderPassword:
 		if user.PasswordHash == "" || user.PasswordSalt == "" {
 			return fmt.Errorf("password credentials required")
@@ -145,13 +629,19 @@ func (s *SQLStore) Create(ctx context.Context, user User) error {
 			emailValue = pgtype.Text{String: user.Email.String(), Valid: true}
 		}
 
+		initial, _ := user.Identity(user.Provider, user.OAuthSubject)
+		var profile []byte
+		if profile, err = encodeProfile(initial.Profile); err != nil {
+			return err
+		}
+
 		if _, err := qtx.CreateUserOAuthAccount(ctx, db.CreateUserOAuthAccountParams{
 			UserID:        id,
 			Provider:      user.Provider,
 			Subject:       user.OAuthSubject,
 			Email:         emailValue,
 			EmailVerified: user.OAuthEmailVerified,
-			Profile:       nil,
+			Profile:       profile,
 		}); err != nil {
 			var pgErr *pgconn.PgError
 			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
@@ -168,6 +658,19 @@ func (s *SQLStore) Create(ctx context.Context, user User) error {
 	return nil
 }
 
+// encodeProfile serialises profile for the JSONB column, storing NULL when the
+// provider asserted no profile claims.
+func encodeProfile(profile Profile) ([]byte, error) {
+	if profile.IsZero() {
+		return nil, nil
+	}
+	raw, err := json.Marshal(profile)
+	if err != nil {
+		return nil, fmt.Errorf("encode oauth profile: %w", err)
+	}
+	return raw, nil
+}
+
 func timestamptzValue(ts pgtype.Timestamptz) time.Time {
 	if !ts.Valid {
 		return time.Time{}
@@ -2,10 +2,14 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,9 +22,15 @@ CREATE TABLE users (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     email CITEXT NOT NULL UNIQUE,
     display_name TEXT,
-    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    directory TEXT,
+    external_id TEXT,
+    deactivated_at TIMESTAMPTZ
 );
 
+CREATE INDEX users_directory_idx ON users (directory, created_at) WHERE directory IS NOT NULL;
+
 CREATE TABLE user_passwords (
     user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
     password_hash BYTEA NOT NULL,
@@ -48,6 +58,17 @@ CREATE UNIQUE INDEX user_oauth_accounts_provider_subject_idx
 CREATE INDEX user_oauth_accounts_user_id_idx
     ON user_oauth_accounts (user_id);
 
+CREATE TABLE user_oauth_tokens (
+    oauth_account_id UUID PRIMARY KEY REFERENCES user_oauth_accounts(id) ON DELETE CASCADE,
+    access_token BYTEA NOT NULL,
+    refresh_token BYTEA,
+    token_type TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
 CREATE TABLE login_events (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID REFERENCES users(id),
@@ -60,10 +81,27 @@ CREATE TABLE login_events (
 
 CREATE INDEX login_events_user_id_idx ON login_events (user_id);
 CREATE INDEX login_events_created_at_idx ON login_events (created_at);
+
+CREATE TABLE personal_access_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    token_hint TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip INET,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens (user_id, created_at);
 `
 
 	schemaDownSQL = `
+DROP TABLE IF EXISTS personal_access_tokens;
 DROP TABLE IF EXISTS login_events;
+DROP TABLE IF EXISTS user_oauth_tokens;
 DROP TABLE IF EXISTS user_oauth_accounts;
 DROP TABLE IF EXISTS user_passwords;
 DROP TABLE IF EXISTS users;
@@ -127,7 +165,9 @@ func TestSQLStoreIntegration(t *testing.T) {
 		email := MustUserEmail("sql-google@example.com")
 		subject := "google-subject-123"
 
-		account, err := service.EnsureExternalUser(ctx, email, ProviderGoogle, subject, true)
+		identity := ExternalIdentity{Provider: ProviderGoogle, Subject: subject, Email: email, EmailVerified: true}
+
+		account, err := service.EnsureExternalUser(ctx, identity)
 		if err != nil {
 			t.Fatalf("ensure external user: %v", err)
 		}
@@ -138,7 +178,7 @@ func TestSQLStoreIntegration(t *testing.T) {
 			t.Fatalf("expected oauth subject %q, got %q", subject, account.OAuthSubject)
 		}
 
-		again, err := service.EnsureExternalUser(ctx, email, ProviderGoogle, subject, true)
+		again, err := service.EnsureExternalUser(ctx, identity)
 		if err != nil {
 			t.Fatalf("ensure existing external user: %v", err)
 		}
@@ -146,6 +186,211 @@ func TestSQLStoreIntegration(t *testing.T) {
 			t.Fatalf("expected same user id, got %q vs %q", again.ID, account.ID)
 		}
 	})
+
+	t.Run("link external identity", func(t *testing.T) {
+		resetDatabase(t, ctx, pool)
+
+		store := NewSQLStore(pool)
+		service := NewService(store)
+
+		email := MustUserEmail("sql-link@example.com")
+		owner, err := service.Register(ctx, email, "Password123")
+		if err != nil {
+			t.Fatalf("register user: %v", err)
+		}
+
+		identity := ExternalIdentity{Provider: ProviderGitHub, Subject: "1001", Email: email, EmailVerified: true}
+		if _, err := service.EnsureExternalUser(ctx, identity); !errors.Is(err, ErrLinkRequired) {
+			t.Fatalf("expected ErrLinkRequired, got %v", err)
+		}
+		if err := service.LinkExternalIdentity(ctx, owner, identity); err != nil {
+			t.Fatalf("link identity: %v", err)
+		}
+
+		linked, err := service.EnsureExternalUser(ctx, identity)
+		if err != nil {
+			t.Fatalf("ensure linked identity: %v", err)
+		}
+		if linked.ID != owner.ID {
+			t.Fatalf("expected linked identity to resolve to %q, got %q", owner.ID, linked.ID)
+		}
+		if len(linked.Identities) != 2 {
+			t.Fatalf("expected password and github identities, got %+v", linked.Identities)
+		}
+
+		if err := service.UnlinkExternalIdentity(ctx, owner, ProviderGitHub, "1001"); err != nil {
+			t.Fatalf("unlink identity: %v", err)
+		}
+		if _, err := store.FindByOAuthSubject(ctx, ProviderGitHub, "1001"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected identity removed, got %v", err)
+		}
+	})
+
+	t.Run("keep last login method", func(t *testing.T) {
+		resetDatabase(t, ctx, pool)
+
+		store := NewSQLStore(pool)
+		service := NewService(store)
+
+		account, err := service.EnsureExternalUser(ctx, ExternalIdentity{Provider: ProviderGoogle, Subject: "solo", Email: MustUserEmail("sql-solo@example.com"), EmailVerified: true})
+		if err != nil {
+			t.Fatalf("ensure external user: %v", err)
+		}
+		if err := store.UnlinkOAuthAccount(ctx, account.ID, ProviderGoogle, "solo"); !errors.Is(err, ErrLastLoginMethod) {
+			t.Fatalf("expected ErrLastLoginMethod, got %v", err)
+		}
+	})
+
+	t.Run("store provider token", func(t *testing.T) {
+		resetDatabase(t, ctx, pool)
+
+		store := NewSQLStore(pool)
+		service := NewService(store)
+		cipher, err := NewTokenCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatalf("new token cipher: %v", err)
+		}
+		service.EnableTokenVault(cipher, nil)
+
+		identity := ExternalIdentity{
+			Provider:      ProviderGoogle,
+			Subject:       "vault",
+			Email:         MustUserEmail("sql-vault@example.com"),
+			EmailVerified: true,
+			Token:         &ProviderToken{AccessToken: "access-1", RefreshToken: "refresh-1", TokenType: "Bearer", Scopes: []string{"openid"}},
+		}
+		account, err := service.EnsureExternalUser(ctx, identity)
+		if err != nil {
+			t.Fatalf("ensure external user: %v", err)
+		}
+
+		identity.Token = &ProviderToken{AccessToken: "access-2", TokenType: "Bearer", Scopes: []string{"calendar"}}
+		if _, err := service.EnsureExternalUser(ctx, identity); err != nil {
+			t.Fatalf("ensure external user again: %v", err)
+		}
+
+		sealed, err := store.FindOAuthToken(ctx, account.ID, ProviderGoogle)
+		if err != nil {
+			t.Fatalf("find oauth token: %v", err)
+		}
+		if string(sealed.AccessToken) == "access-2" {
+			t.Fatal("expected access token to be stored encrypted")
+		}
+		token, err := service.ProviderToken(ctx, account.ID, ProviderGoogle)
+		if err != nil {
+			t.Fatalf("provider token: %v", err)
+		}
+		if token.AccessToken != "access-2" || token.RefreshToken != "refresh-1" {
+			t.Fatalf("expected latest access token and kept refresh token, got %+v", token)
+		}
+		if !slices.Equal(token.Scopes, []string{"calendar", "openid"}) {
+			t.Fatalf("expected merged scopes, got %v", token.Scopes)
+		}
+	})
+
+	t.Run("personal access tokens", func(t *testing.T) {
+		resetDatabase(t, ctx, pool)
+
+		store := NewSQLStore(pool)
+		service := NewService(store)
+
+		account, err := service.Register(ctx, MustUserEmail("sql-scripts@example.com"), "Password123")
+		if err != nil {
+			t.Fatalf("register user: %v", err)
+		}
+		token, secret, err := service.CreatePersonalToken(ctx, account.ID, PersonalTokenRequest{
+			Name:     "CI",
+			Scopes:   []string{PersonalTokenScopeRead, PersonalTokenScopeWrite},
+			Lifetime: time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("create personal token: %v", err)
+		}
+
+		if _, _, err := service.AuthenticatePersonalToken(ctx, secret, "2001:db8::1"); err != nil {
+			t.Fatalf("authenticate personal token: %v", err)
+		}
+		found, err := store.FindPersonalToken(ctx, token.TokenHash)
+		if err != nil {
+			t.Fatalf("find personal token: %v", err)
+		}
+		if found.ID != token.ID || found.Hint != token.Hint || found.LastUsedIP != "2001:db8::1" || found.LastUsedAt.IsZero() {
+			t.Fatalf("unexpected stored token %+v", found)
+		}
+		if !slices.Equal(found.Scopes, token.Scopes) || !found.ExpiresAt.Equal(token.ExpiresAt.Truncate(time.Microsecond)) {
+			t.Fatalf("expected scopes and expiry to round-trip, got %+v", found)
+		}
+
+		listed, err := service.PersonalTokens(ctx, account.ID)
+		if err != nil || len(listed) != 1 {
+			t.Fatalf("expected one listed token, got %+v %v", listed, err)
+		}
+		if err := store.CreatePersonalToken(ctx, PersonalToken{ID: uuid.NewString(), UserID: uuid.NewString(), Name: "orphan", TokenHash: []byte("orphan"), Hint: "authpat_orph"}); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+
+		if err := service.RevokePersonalToken(ctx, account.ID, token.ID); err != nil {
+			t.Fatalf("revoke personal token: %v", err)
+		}
+		if _, err := store.FindPersonalToken(ctx, token.TokenHash); !errors.Is(err, ErrPersonalTokenNotFound) {
+			t.Fatalf("expected ErrPersonalTokenNotFound, got %v", err)
+		}
+		if err := service.RevokePersonalToken(ctx, account.ID, "not-a-uuid"); !errors.Is(err, ErrPersonalTokenNotFound) {
+			t.Fatalf("expected ErrPersonalTokenNotFound for malformed id, got %v", err)
+		}
+	})
+
+	t.Run("directory users", func(t *testing.T) {
+		resetDatabase(t, ctx, pool)
+
+		store := NewSQLStore(pool)
+		service := NewService(store)
+		service.ManageDirectoryDomains("acme", []string{"acme.test"})
+		service.ManageDirectoryDomains("globex", []string{"acme.test"})
+
+		first, err := service.ProvisionUser(ctx, "acme", DirectoryProfile{Email: MustUserEmail("sql-ada@acme.test"), DisplayName: "Ada", ExternalID: "00u1", Active: true})
+		if err != nil {
+			t.Fatalf("provision user: %v", err)
+		}
+		if _, err := service.ProvisionUser(ctx, "acme", DirectoryProfile{Email: MustUserEmail("sql-grace@acme.test"), Active: false}); err != nil {
+			t.Fatalf("provision inactive user: %v", err)
+		}
+		if _, err := service.ProvisionUser(ctx, "globex", DirectoryProfile{Email: MustUserEmail("sql-ada@acme.test"), Active: true}); !errors.Is(err, ErrEmailExists) {
+			t.Fatalf("expected ErrEmailExists, got %v", err)
+		}
+
+		users, total, err := store.ListUsers(ctx, UserQuery{Directory: "acme", Offset: 1, Limit: 10})
+		if err != nil {
+			t.Fatalf("list users: %v", err)
+		}
+		if total != 2 || len(users) != 1 || users[0].Email != "sql-grace@acme.test" || users[0].Active() {
+			t.Fatalf("expected second page with the inactive user, got %+v (total %d)", users, total)
+		}
+		if users, total, err := store.ListUsers(ctx, UserQuery{Directory: "globex", Email: first.Email, Limit: 10}); err != nil || total != 0 || len(users) != 0 {
+			t.Fatalf("expected other directory not to see the user, got %+v %d %v", users, total, err)
+		}
+
+		updated, err := service.UpdateDirectoryUser(ctx, "acme", first.ID, DirectoryProfile{Email: MustUserEmail("sql-ada.l@acme.test"), DisplayName: "Ada Lovelace", Active: false})
+		if err != nil {
+			t.Fatalf("update user: %v", err)
+		}
+		if updated.Email != "sql-ada.l@acme.test" || updated.DisplayName != "Ada Lovelace" || updated.ExternalID != "" || updated.Active() {
+			t.Fatalf("unexpected updated user %+v", updated)
+		}
+		if err := store.UpdateUser(ctx, User{ID: first.ID, Email: MustUserEmail("sql-grace@acme.test"), Directory: "acme"}); !errors.Is(err, ErrEmailExists) {
+			t.Fatalf("expected ErrEmailExists, got %v", err)
+		}
+
+		if err := service.ReleaseDirectoryUser(ctx, "acme", first.ID); err != nil {
+			t.Fatalf("release user: %v", err)
+		}
+		if _, total, _ := store.ListUsers(ctx, UserQuery{Directory: "acme"}); total != 1 {
+			t.Fatalf("expected released user to leave the directory, got total %d", total)
+		}
+		if _, err := service.LookupByID(ctx, first.ID); !errors.Is(err, ErrUserDeactivated) {
+			t.Fatalf("expected ErrUserDeactivated, got %v", err)
+		}
+	})
 }
 
 func resetDatabase(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
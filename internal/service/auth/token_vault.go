@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	tokenCipherVersion byte = 1
+	tokenKeySize            = 32
+	// tokenExpiryLeeway refreshes access tokens slightly before they expire so
+	// callers never receive one that lapses mid-request.
+	tokenExpiryLeeway = time.Minute
+)
+
+var (
+	// ErrTokenVaultDisabled indicates no token encryption key is configured.
+	ErrTokenVaultDisabled = errors.New("auth: provider token vault disabled")
+	// ErrTokenNotFound indicates no provider token is stored for the user.
+	ErrTokenNotFound = errors.New("auth: provider token not found")
+	// ErrTokenExpired indicates the stored access token expired and cannot be
+	// refreshed; the user must consent again.
+	ErrTokenExpired = errors.New("auth: provider token expired")
+	// ErrTokenCorrupt indicates a sealed token failed authentication on open.
+	ErrTokenCorrupt = errors.New("auth: provider token corrupt")
+)
+
+// ProviderToken is a decrypted OAuth token issued by an identity provider.
+type ProviderToken struct {
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	Expiry       time.Time
+	// Scopes lists the scopes the provider granted.
+	Scopes []string
+}
+
+// expired reports whether the access token is unusable at now.
+func (t ProviderToken) expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && !now.Add(tokenExpiryLeeway).Before(t.Expiry)
+}
+
+// SealedToken is a provider token as persisted: token values are encrypted,
+// metadata is stored in the clear.
+type SealedToken struct {
+	Provider     string
+	Subject      string
+	AccessToken  []byte
+	RefreshToken []byte
+	TokenType    string
+	Expiry       time.Time
+	Scopes       []string
+	UpdatedAt    time.Time
+}
+
+// TokenRefresher is implemented by identity providers able to redeem refresh tokens.
+type TokenRefresher interface {
+	RefreshToken(ctx context.Context, refreshToken string) (ProviderToken, error)
+}
+
+// TokenCipher seals provider tokens with AES-256-GCM. Each value is bound to
+// its provider, subject and field so ciphertexts cannot be swapped between rows.
+type TokenCipher struct {
+	aead cipher.AEAD
+}
+
+// NewTokenCipher returns a cipher using the 32-byte key.
+func NewTokenCipher(key []byte) (*TokenCipher, error) {
+	if len(key) != tokenKeySize {
+		return nil, fmt.Errorf("auth: token key must be %d bytes", tokenKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenCipher{aead: aead}, nil
+}
+
+// Seal encrypts token for the identity. Empty refresh tokens stay nil.
+func (c *TokenCipher) Seal(provider, subject string, token ProviderToken) (SealedToken, error) {
+	access, err := c.seal([]byte(token.AccessToken), tokenAAD(provider, subject, "access_token"))
+	if err != nil {
+		return SealedToken{}, err
+	}
+	sealed := SealedToken{
+		Provider:    provider,
+		Subject:     subject,
+		AccessToken: access,
+		TokenType:   token.TokenType,
+		Expiry:      token.Expiry,
+		Scopes:      token.Scopes,
+	}
+	if token.RefreshToken != "" {
+		sealed.RefreshToken, err = c.seal([]byte(token.RefreshToken), tokenAAD(provider, subject, "refresh_token"))
+		if err != nil {
+			return SealedToken{}, err
+		}
+	}
+	return sealed, nil
+}
+
+// Open decrypts sealed, failing with ErrTokenCorrupt when either value was
+// tampered with or sealed for another identity.
+func (c *TokenCipher) Open(sealed SealedToken) (ProviderToken, error) {
+	access, err := c.open(sealed.AccessToken, tokenAAD(sealed.Provider, sealed.Subject, "access_token"))
+	if err != nil {
+		return ProviderToken{}, err
+	}
+	token := ProviderToken{
+		AccessToken: string(access),
+		TokenType:   sealed.TokenType,
+		Expiry:      sealed.Expiry,
+		Scopes:      sealed.Scopes,
+	}
+	if len(sealed.RefreshToken) > 0 {
+		refresh, err := c.open(sealed.RefreshToken, tokenAAD(sealed.Provider, sealed.Subject, "refresh_token"))
+		if err != nil {
+			return ProviderToken{}, err
+		}
+		token.RefreshToken = string(refresh)
+	}
+	return token, nil
+}
+
+// seal returns version || nonce || ciphertext.
+func (c *TokenCipher) seal(plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	out := make([]byte, 0, 1+len(nonce)+len(plaintext)+c.aead.Overhead())
+	out = append(out, tokenCipherVersion)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+func (c *TokenCipher) open(sealed, aad []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < 1+nonceSize || sealed[0] != tokenCipherVersion {
+		return nil, ErrTokenCorrupt
+	}
+	plaintext, err := c.aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], aad)
+	if err != nil {
+		return nil, ErrTokenCorrupt
+	}
+	return plaintext, nil
+}
+
+func tokenAAD(provider, subject, field string) []byte {
+	return []byte(provider + "\x00" + subject + "\x00" + field)
+}
+
+// tokenVault encrypts provider tokens and refreshes them through their provider.
+type tokenVault struct {
+	cipher    *TokenCipher
+	providers *ProviderRegistry
+	now       func() time.Time
+}
+
+// EnableTokenVault stores provider tokens returned at login, encrypted with
+// cipher, and refreshes them through the matching provider in providers.
+func (s *Service) EnableTokenVault(cipher *TokenCipher, providers *ProviderRegistry) {
+	s.tokens = &tokenVault{cipher: cipher, providers: providers, now: time.Now}
+}
+
+// saveProviderToken seals the token carried by identity, if any. Stores keep
+// the previous refresh token when the provider did not issue a new one.
+func (s *Service) saveProviderToken(ctx context.Context, identity ExternalIdentity) error {
+	if s.tokens == nil || identity.Token == nil {
+		return nil
+	}
+	sealed, err := s.tokens.cipher.Seal(identity.Provider, identity.Subject, *identity.Token)
+	if err != nil {
+		return fmt.Errorf("seal provider token: %w", err)
+	}
+	return s.store.SaveOAuthToken(ctx, sealed)
+}
+
+// ProviderToken returns a usable access token for the user's most recently
+// updated identity at provider, transparently refreshing an expired one.
+func (s *Service) ProviderToken(ctx context.Context, userID, provider string) (ProviderToken, error) {
+	if s.tokens == nil {
+		return ProviderToken{}, ErrTokenVaultDisabled
+	}
+	sealed, err := s.store.FindOAuthToken(ctx, userID, provider)
+	if err != nil {
+		return ProviderToken{}, err
+	}
+	token, err := s.tokens.cipher.Open(sealed)
+	if err != nil {
+		return ProviderToken{}, err
+	}
+	if !token.expired(s.tokens.now()) {
+		return token, nil
+	}
+
+	idp, _ := s.tokens.providers.Lookup(provider)
+	refresher, ok := idp.(TokenRefresher)
+	if token.RefreshToken == "" || !ok {
+		return ProviderToken{}, ErrTokenExpired
+	}
+	refreshed, err := refresher.RefreshToken(ctx, token.RefreshToken)
+	if err != nil {
+		return ProviderToken{}, fmt.Errorf("refresh provider token: %w", err)
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if len(refreshed.Scopes) == 0 {
+		refreshed.Scopes = token.Scopes
+	}
+
+	if err := s.saveProviderToken(ctx, ExternalIdentity{Provider: provider, Subject: sealed.Subject, Token: &refreshed}); err != nil {
+		return ProviderToken{}, err
+	}
+	return refreshed, nil
+}
+
+// MissingScopes returns the scopes in wanted that the user has not yet granted
+// at provider, so incremental consent can ask for only those.
+func (s *Service) MissingScopes(ctx context.Context, userID, provider string, wanted []string) ([]string, error) {
+	if s.tokens == nil {
+		return nil, ErrTokenVaultDisabled
+	}
+	var granted []string
+	sealed, err := s.store.FindOAuthToken(ctx, userID, provider)
+	switch {
+	case err == nil:
+		granted = sealed.Scopes
+	case !errors.Is(err, ErrTokenNotFound):
+		return nil, err
+	}
+
+	var missing []string
+	for _, scope := range wanted {
+		if scope != "" && !slices.Contains(granted, scope) && !slices.Contains(missing, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing, nil
+}
+
+// MergeScopes returns the union of granted and added, preserving order.
+func MergeScopes(granted, added []string) []string {
+	merged := slices.Clone(granted)
+	for _, scope := range added {
+		if !slices.Contains(merged, scope) {
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+type refreshingProvider struct {
+	stubProvider
+	refreshed []string
+}
+
+func (p *refreshingProvider) RefreshToken(_ context.Context, refreshToken string) (ProviderToken, error) {
+	p.refreshed = append(p.refreshed, refreshToken)
+	return ProviderToken{AccessToken: "access-refreshed", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func newTestTokenCipher(t *testing.T) *TokenCipher {
+	t.Helper()
+	cipher, err := NewTokenCipher(make([]byte, tokenKeySize))
+	if err != nil {
+		t.Fatalf("new token cipher: %v", err)
+	}
+	return cipher
+}
+
+func TestTokenCipher(t *testing.T) {
+	t.Parallel()
+
+	cipher := newTestTokenCipher(t)
+	token := ProviderToken{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Scopes: []string{"openid"}}
+
+	sealed, err := cipher.Seal(ProviderGoogle, "sub", token)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	opened, err := cipher.Open(sealed)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if opened.AccessToken != "access" || opened.RefreshToken != "refresh" {
+		t.Fatalf("expected round trip, got %+v", opened)
+	}
+
+	tests := map[string]func(SealedToken) SealedToken{
+		"tampered": func(s SealedToken) SealedToken {
+			s.AccessToken = slices.Clone(s.AccessToken)
+			s.AccessToken[len(s.AccessToken)-1] ^= 1
+			return s
+		},
+		"other subject": func(s SealedToken) SealedToken {
+			s.Subject = "other"
+			return s
+		},
+		"swapped fields": func(s SealedToken) SealedToken {
+			s.AccessToken, s.RefreshToken = s.RefreshToken, s.AccessToken
+			return s
+		},
+		"truncated": func(s SealedToken) SealedToken {
+			s.AccessToken = s.AccessToken[:4]
+			return s
+		},
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := cipher.Open(mutate(sealed)); !errors.Is(err, ErrTokenCorrupt) {
+				t.Fatalf("expected ErrTokenCorrupt, got %v", err)
+			}
+		})
+	}
+
+	if _, err := NewTokenCipher([]byte("short")); err == nil {
+		t.Fatal("expected short key to be rejected")
+	}
+}
+
+func TestServiceProviderToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	provider := &refreshingProvider{stubProvider: stubProvider{id: ProviderGoogle}}
+	providers, err := NewProviderRegistry(provider)
+	if err != nil {
+		t.Fatalf("new provider registry: %v", err)
+	}
+	store := NewMemoryStore()
+	service := NewService(store)
+
+	if _, err := service.ProviderToken(ctx, "anyone", ProviderGoogle); !errors.Is(err, ErrTokenVaultDisabled) {
+		t.Fatalf("expected ErrTokenVaultDisabled, got %v", err)
+	}
+	service.EnableTokenVault(newTestTokenCipher(t), providers)
+
+	identity := ExternalIdentity{
+		Provider:      ProviderGoogle,
+		Subject:       "offline",
+		Email:         MustUserEmail("offline@example.com"),
+		EmailVerified: true,
+		Token: &ProviderToken{
+			AccessToken:  "access-1",
+			RefreshToken: "refresh-1",
+			TokenType:    "Bearer",
+			Expiry:       time.Now().Add(time.Hour),
+			Scopes:       []string{"openid", "email"},
+		},
+	}
+	account, err := service.EnsureExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("ensure external user: %v", err)
+	}
+
+	token, err := service.ProviderToken(ctx, account.ID, ProviderGoogle)
+	if err != nil {
+		t.Fatalf("provider token: %v", err)
+	}
+	if token.AccessToken != "access-1" || len(provider.refreshed) != 0 {
+		t.Fatalf("expected stored token without refresh, got %+v", token)
+	}
+
+	identity.Token = &ProviderToken{AccessToken: "access-2", TokenType: "Bearer", Expiry: time.Now().Add(-time.Minute), Scopes: []string{"calendar"}}
+	if _, err := service.EnsureExternalUser(ctx, identity); err != nil {
+		t.Fatalf("ensure external user again: %v", err)
+	}
+
+	token, err = service.ProviderToken(ctx, account.ID, ProviderGoogle)
+	if err != nil {
+		t.Fatalf("provider token after expiry: %v", err)
+	}
+	if token.AccessToken != "access-refreshed" || token.RefreshToken != "refresh-1" {
+		t.Fatalf("expected refreshed token keeping refresh token, got %+v", token)
+	}
+	if !slices.Equal(provider.refreshed, []string{"refresh-1"}) {
+		t.Fatalf("expected one refresh with stored refresh token, got %v", provider.refreshed)
+	}
+	if !slices.Equal(token.Scopes, []string{"openid", "email", "calendar"}) {
+		t.Fatalf("expected merged scopes, got %v", token.Scopes)
+	}
+
+	if _, err := service.ProviderToken(ctx, account.ID, ProviderGitHub); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected ErrTokenNotFound, got %v", err)
+	}
+}
+
+func TestServiceProviderTokenExpiredWithoutRefresh(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service := NewService(NewMemoryStore())
+	service.EnableTokenVault(newTestTokenCipher(t), nil)
+
+	account, err := service.EnsureExternalUser(ctx, ExternalIdentity{
+		Provider:      ProviderGitHub,
+		Subject:       "42",
+		Email:         MustUserEmail("stale@example.com"),
+		EmailVerified: true,
+		Token:         &ProviderToken{AccessToken: "stale", Expiry: time.Now().Add(-time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("ensure external user: %v", err)
+	}
+	if _, err := service.ProviderToken(ctx, account.ID, ProviderGitHub); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestServiceMissingScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service := NewService(NewMemoryStore())
+	service.EnableTokenVault(newTestTokenCipher(t), nil)
+
+	account, err := service.EnsureExternalUser(ctx, ExternalIdentity{
+		Provider:      ProviderGoogle,
+		Subject:       "scopes",
+		Email:         MustUserEmail("scopes@example.com"),
+		EmailVerified: true,
+		Token:         &ProviderToken{AccessToken: "access", Scopes: []string{"openid", "email"}},
+	})
+	if err != nil {
+		t.Fatalf("ensure external user: %v", err)
+	}
+
+	tests := map[string]struct {
+		provider string
+		wanted   []string
+		want     []string
+	}{
+		"all granted":      {provider: ProviderGoogle, wanted: []string{"email"}, want: nil},
+		"partially":        {provider: ProviderGoogle, wanted: []string{"email", "calendar", "calendar"}, want: []string{"calendar"}},
+		"no token on file": {provider: ProviderGitHub, wanted: []string{"repo"}, want: []string{"repo"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := service.MissingScopes(ctx, account.ID, tc.provider, tc.wanted)
+			if err != nil {
+				t.Fatalf("missing scopes: %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMergeScopes(t *testing.T) {
+	t.Parallel()
+
+	login := []string{"openid", "email", "profile"}
+	got := MergeScopes(login, []string{"calendar", "email", "calendar"})
+	if !slices.Equal(got, []string{"openid", "email", "profile", "calendar"}) {
+		t.Fatalf("expected login scopes followed by the added one, got %v", got)
+	}
+	if !slices.Equal(login, []string{"openid", "email", "profile"}) {
+		t.Fatalf("expected granted scopes to be left untouched, got %v", login)
+	}
+}
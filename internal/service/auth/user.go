@@ -1,14 +1,15 @@
 package auth
 
 import (
-	"errors"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// User represents authenticated account details.
+// User represents authenticated account details. Provider and OAuthSubject
+// describe the method the account was created with; Identities lists every
+// login method currently attached.
 type User struct {
 	ID                 string
 	Email              UserEmail
@@ -18,6 +19,52 @@ type User struct {
 	OAuthSubject       string
 	OAuthEmailVerified bool
 	CreatedAt          time.Time
+	Identities         []Identity
+	// DisplayName is derived from provider profiles; see nextDisplayName.
+	DisplayName string
+	// AvatarURL is the picture from the most recently used identity.
+	AvatarURL string
+	// Directory is the ID of the SCIM tenant that provisioned the account and
+	// manages it from then on; it is empty for self-service accounts.
+	Directory string
+	// ExternalID is the directory's own identifier for the account.
+	ExternalID string
+	// DeactivatedAt is set while the account is deactivated. Deactivated
+	// accounts keep their data but cannot sign in or use issued credentials.
+	DeactivatedAt time.Time
+	UpdatedAt     time.Time
+}
+
+// Identity is a login method attached to an account. Password identities carry
+// no subject.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Profile       Profile
+	LinkedAt      time.Time
+	UpdatedAt     time.Time
+}
+
+// HasPassword reports whether the account can sign in with a password.
+func (u User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// Active reports whether the account may sign in.
+func (u User) Active() bool {
+	return u.DeactivatedAt.IsZero()
+}
+
+// Identity returns the attached identity for provider and subject.
+func (u User) Identity(provider, subject string) (Identity, bool) {
+	for _, identity := range u.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, true
+		}
+	}
+	return Identity{}, false
 }
 
 // UserEmail represents a canonical email string.
@@ -27,7 +74,7 @@ type UserEmail string
 func NewUserEmail(raw string) (UserEmail, error) {
 	normalized := strings.TrimSpace(strings.ToLower(raw))
 	if normalized == "" {
-		return "", errors.New("auth: email required")
+		return "", ErrEmailRequired
 	}
 	return UserEmail(normalized), nil
 }
@@ -46,6 +93,15 @@ func (e UserEmail) String() string {
 	return string(e)
 }
 
+// Domain returns the part after the last @, or "" when there is none.
+func (e UserEmail) Domain() string {
+	at := strings.LastIndexByte(string(e), '@')
+	if at < 0 {
+		return ""
+	}
+	return string(e[at+1:])
+}
+
 // IsZero reports whether the email is unset.
 func (e UserEmail) IsZero() bool {
 	return e == ""
@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	// ClientAssertionTypeJWTBearer is the client_assertion_type of
+	// private_key_jwt authentication (RFC 7523 §2.2).
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// clientAssertionMaxLifetime bounds how far ahead an assertion may
+	// expire, and so how long its jti must be remembered.
+	clientAssertionMaxLifetime = 10 * time.Minute
+	// clientAssertionLeeway tolerates clock skew between client and server.
+	clientAssertionLeeway = 30 * time.Second
+)
+
+// ErrAssertionReplayed indicates a client assertion's jti was already used.
+var ErrAssertionReplayed = errors.New("oauth: client assertion replayed")
+
+// clientAssertionAlgorithms lists the algorithms accepted on client assertions.
+var clientAssertionAlgorithms = []jose.SignatureAlgorithm{jose.RS256, jose.PS256, jose.ES256, jose.ES384, jose.EdDSA}
+
+// assertionSubject returns the unverified subject of a client assertion, used
+// to find the client when the request names none.
+func assertionSubject(assertion string) string {
+	parsed, err := jwt.ParseSigned(assertion, clientAssertionAlgorithms)
+	if err != nil {
+		return ""
+	}
+	var claims jwt.Claims
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// verifyClientAssertion checks a private_key_jwt assertion (RFC 7523 §3): it
+// must be signed by one of the client's keys, issued by and about the client,
+// addressed to this server, short-lived and never seen before.
+func (s *Service) verifyClientAssertion(ctx context.Context, client Client, assertion string, now time.Time) error {
+	invalid := newError(ErrorInvalidClient, "client assertion is invalid")
+	if client.JWKS == nil {
+		return invalid
+	}
+	parsed, err := jwt.ParseSigned(assertion, clientAssertionAlgorithms)
+	if err != nil {
+		return invalid
+	}
+
+	keys := client.JWKS.Keys
+	if kid := parsed.Headers[0].KeyID; kid != "" {
+		keys = client.JWKS.Key(kid)
+	}
+	var claims jwt.Claims
+	verified := false
+	for _, key := range keys {
+		if parsed.Claims(key.Key, &claims) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return invalid
+	}
+
+	err = claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:      client.ID,
+		Subject:     client.ID,
+		AnyAudience: jwt.Audience{s.issuer + TokenPath, s.issuer},
+		Time:        now,
+	}, clientAssertionLeeway)
+	switch {
+	case err != nil:
+		return invalid
+	case claims.Expiry == nil || claims.ID == "":
+		return newError(ErrorInvalidClient, "client assertion must carry exp and jti")
+	case claims.Expiry.Time().After(now.Add(clientAssertionMaxLifetime)):
+		return newError(ErrorInvalidClient, "client assertion lifetime is too long")
+	}
+
+	if err := s.store.UseClientAssertion(ctx, client.ID, claims.ID, claims.Expiry.Time()); err != nil {
+		if errors.Is(err, ErrAssertionReplayed) {
+			return newError(ErrorInvalidClient, "client assertion was already used")
+		}
+		return fmt.Errorf("record client assertion: %w", err)
+	}
+	return nil
+}
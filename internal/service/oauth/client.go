@@ -0,0 +1,442 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+)
+
+// ClientType distinguishes clients that can keep a credential from those that
+// cannot (RFC 6749 §2.1).
+type ClientType string
+
+const (
+	// ClientConfidential clients run on a server and authenticate at the
+	// token endpoint.
+	ClientConfidential ClientType = "confidential"
+	// ClientPublic clients, such as single-page and native apps, cannot keep
+	// a secret and rely on PKCE alone.
+	ClientPublic ClientType = "public"
+)
+
+// DefaultSecretOverlap is how long a rotated client secret stays valid by
+// default, long enough to roll the new secret out to every deployment.
+const DefaultSecretOverlap = 24 * time.Hour
+
+// Token endpoint authentication methods (OpenID Connect Core §9).
+const (
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+	AuthMethodNone              = "none"
+)
+
+var (
+	// ErrInvalidClientMetadata indicates a client registration is incomplete or
+	// names an unusable redirect URI, grant type, scope or key.
+	ErrInvalidClientMetadata = errors.New("oauth: invalid client metadata")
+	// ErrClientHasNoSecret indicates the client does not authenticate with a
+	// secret, so there is none to rotate.
+	ErrClientHasNoSecret = errors.New("oauth: client does not use a secret")
+)
+
+// supportedGrantTypes lists the grants clients may be allowed to use.
+var supportedGrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeDeviceCode, GrantTypeRefreshToken, GrantTypeClientCredentials}
+
+// supportedAuthMethods lists the token endpoint authentication methods.
+var supportedAuthMethods = []string{AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodPrivateKeyJWT, AuthMethodNone}
+
+// Client is an application registered to request tokens on behalf of users
+// or, through the client_credentials grant, for itself.
+type Client struct {
+	ID   string
+	Name string
+	Type ClientType
+	// AuthMethod is the only method the client may authenticate with at the
+	// token endpoint; public clients use AuthMethodNone.
+	AuthMethod   string
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+	// JWKS holds the public keys verifying private_key_jwt assertions.
+	JWKS *jose.JSONWebKeySet
+	// Audiences are the client IDs the client's client_credentials tokens may
+	// be addressed to. Without any, its tokens are addressed to the issuer.
+	Audiences []string
+	// Secrets are the hashed secrets of clients using a secret method. More
+	// than one is valid while a rotated secret is phased out.
+	Secrets   []ClientSecret
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ClientSecret is a hashed client secret. A zero ExpiresAt never expires.
+type ClientSecret struct {
+	ID        string
+	Hash      []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Valid reports whether the secret is accepted at now.
+func (s ClientSecret) Valid(now time.Time) bool {
+	return s.ExpiresAt.IsZero() || now.Before(s.ExpiresAt)
+}
+
+// Public reports whether the client authenticates without credentials.
+func (c Client) Public() bool {
+	return c.Type == ClientPublic
+}
+
+// UsesSecret reports whether the client authenticates with a client secret.
+func (c Client) UsesSecret() bool {
+	return c.AuthMethod == AuthMethodClientSecretBasic || c.AuthMethod == AuthMethodClientSecretPost
+}
+
+// HasRedirectURI reports whether uri exactly matches a registered redirect URI.
+func (c Client) HasRedirectURI(uri string) bool {
+	return uri != "" && slices.Contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrant reports whether the client may use grantType.
+func (c Client) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// AllowsAudience reports whether the client may ask for a token addressed to
+// the client registered as audience.
+func (c Client) AllowsAudience(audience string) bool {
+	return slices.Contains(c.Audiences, audience)
+}
+
+// AllowsScope reports whether the client may request scope.
+func (c Client) AllowsScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// verifySecret reports whether secret matches one of the client's secrets
+// valid at now. Every candidate is compared so timing does not reveal which
+// secret matched.
+func (c Client) verifySecret(secret string, now time.Time) bool {
+	if secret == "" {
+		return false
+	}
+	hash := hashToken(secret)
+	matched := 0
+	for _, s := range c.Secrets {
+		if s.Valid(now) {
+			matched |= subtle.ConstantTimeCompare(hash, s.Hash)
+		}
+	}
+	return matched == 1
+}
+
+// ClientRegistration describes a client to register, or the new metadata of
+// a registered client. Zero values take defaults: a confidential client using
+// client_secret_basic, allowed the authorization_code grant and every
+// supported scope.
+type ClientRegistration struct {
+	Name         string
+	Type         ClientType
+	AuthMethod   string
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+	JWKS         *jose.JSONWebKeySet
+	Audiences    []string
+}
+
+// Registry manages the registered clients.
+type Registry struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewRegistry returns a Registry over store.
+func NewRegistry(store Store) *Registry {
+	return &Registry{store: store, now: time.Now}
+}
+
+// Register stores a new client and returns it with its secret. The secret is
+// only available here; the store keeps its hash. Clients that do not
+// authenticate with a secret get none.
+func (r *Registry) Register(ctx context.Context, reg ClientRegistration) (Client, string, error) {
+	reg, err := normalizeRegistration(reg)
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	now := r.now().UTC()
+	client := Client{
+		ID:        uuid.NewString(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	client.apply(reg)
+
+	var secret string
+	if client.UsesSecret() {
+		var clientSecret ClientSecret
+		secret, clientSecret, err = newClientSecret(now)
+		if err != nil {
+			return Client{}, "", err
+		}
+		client.Secrets = []ClientSecret{clientSecret}
+	}
+
+	if err := r.store.CreateClient(ctx, client); err != nil {
+		return Client{}, "", err
+	}
+	return client, secret, nil
+}
+
+// Update replaces the metadata of the client registered under id. A client's
+// type cannot change. Switching a client to a secret method leaves it without
+// a valid secret until RotateSecret issues one.
+func (r *Registry) Update(ctx context.Context, id string, reg ClientRegistration) (Client, error) {
+	client, err := r.store.FindClient(ctx, id)
+	if err != nil {
+		return Client{}, err
+	}
+	if reg.Type == "" {
+		reg.Type = client.Type
+	}
+	if reg.Type != client.Type {
+		return Client{}, fmt.Errorf("%w: client type cannot change", ErrInvalidClientMetadata)
+	}
+	reg, err = normalizeRegistration(reg)
+	if err != nil {
+		return Client{}, err
+	}
+
+	client.apply(reg)
+	client.UpdatedAt = r.now().UTC()
+	if err := r.store.UpdateClient(ctx, client); err != nil {
+		return Client{}, err
+	}
+	return client, nil
+}
+
+// Find returns the client registered under id, or ErrClientNotFound.
+func (r *Registry) Find(ctx context.Context, id string) (Client, error) {
+	return r.store.FindClient(ctx, id)
+}
+
+// List returns every registered client, oldest first, without secrets.
+func (r *Registry) List(ctx context.Context) ([]Client, error) {
+	return r.store.ListClients(ctx)
+}
+
+// Delete removes the client and everything issued to it.
+func (r *Registry) Delete(ctx context.Context, id string) error {
+	return r.store.DeleteClient(ctx, id)
+}
+
+// RotateSecret issues a new secret for the client. Existing secrets stay
+// valid for overlap so deployments can switch over; an overlap of zero
+// revokes them at once.
+func (r *Registry) RotateSecret(ctx context.Context, id string, overlap time.Duration) (string, error) {
+	if overlap < 0 {
+		return "", fmt.Errorf("%w: overlap must not be negative", ErrInvalidClientMetadata)
+	}
+	client, err := r.store.FindClient(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if !client.UsesSecret() {
+		return "", ErrClientHasNoSecret
+	}
+
+	now := r.now().UTC()
+	secret, clientSecret, err := newClientSecret(now)
+	if err != nil {
+		return "", err
+	}
+	if err := r.store.RotateClientSecret(ctx, client.ID, clientSecret, now.Add(overlap)); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (c *Client) apply(reg ClientRegistration) {
+	c.Name = reg.Name
+	c.Type = reg.Type
+	c.AuthMethod = reg.AuthMethod
+	c.RedirectURIs = reg.RedirectURIs
+	c.GrantTypes = reg.GrantTypes
+	c.Scopes = reg.Scopes
+	c.JWKS = reg.JWKS
+	c.Audiences = reg.Audiences
+}
+
+// newClientSecret generates a secret and the hashed record stored for it.
+func newClientSecret(now time.Time) (string, ClientSecret, error) {
+	secret, err := generateToken()
+	if err != nil {
+		return "", ClientSecret{}, fmt.Errorf("generate client secret: %w", err)
+	}
+	return secret, ClientSecret{ID: uuid.NewString(), Hash: hashToken(secret), CreatedAt: now}, nil
+}
+
+// normalizeRegistration applies defaults to reg and validates it, returning
+// copies of its slices.
+func normalizeRegistration(reg ClientRegistration) (ClientRegistration, error) {
+	reg.Name = strings.TrimSpace(reg.Name)
+	if reg.Name == "" {
+		return ClientRegistration{}, fmt.Errorf("%w: name is required", ErrInvalidClientMetadata)
+	}
+
+	switch reg.Type {
+	case "", ClientConfidential:
+		reg.Type = ClientConfidential
+		if reg.AuthMethod == "" {
+			reg.AuthMethod = AuthMethodClientSecretBasic
+		}
+		if reg.AuthMethod == AuthMethodNone {
+			return ClientRegistration{}, fmt.Errorf("%w: confidential clients must authenticate", ErrInvalidClientMetadata)
+		}
+	case ClientPublic:
+		if reg.AuthMethod == "" {
+			reg.AuthMethod = AuthMethodNone
+		}
+		if reg.AuthMethod != AuthMethodNone {
+			return ClientRegistration{}, fmt.Errorf("%w: public clients cannot authenticate", ErrInvalidClientMetadata)
+		}
+	default:
+		return ClientRegistration{}, fmt.Errorf("%w: unknown client type %q", ErrInvalidClientMetadata, reg.Type)
+	}
+	if !slices.Contains(supportedAuthMethods, reg.AuthMethod) {
+		return ClientRegistration{}, fmt.Errorf("%w: unsupported token endpoint auth method %q", ErrInvalidClientMetadata, reg.AuthMethod)
+	}
+	if err := validateJWKS(reg.AuthMethod, reg.JWKS); err != nil {
+		return ClientRegistration{}, err
+	}
+
+	reg.GrantTypes = dedupe(reg.GrantTypes)
+	if len(reg.GrantTypes) == 0 {
+		reg.GrantTypes = []string{GrantTypeAuthorizationCode}
+	}
+	for _, grant := range reg.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grant) {
+			return ClientRegistration{}, fmt.Errorf("%w: unsupported grant type %q", ErrInvalidClientMetadata, grant)
+		}
+	}
+	serviceClient := slices.Contains(reg.GrantTypes, GrantTypeClientCredentials)
+	if serviceClient && reg.Type == ClientPublic {
+		return ClientRegistration{}, fmt.Errorf("%w: public clients cannot use the %s grant", ErrInvalidClientMetadata, GrantTypeClientCredentials)
+	}
+
+	// Scopes beyond the OpenID ones name the APIs a client may call for
+	// itself, so only clients allowed the client_credentials grant hold them.
+	reg.Scopes = dedupe(reg.Scopes)
+	if len(reg.Scopes) == 0 {
+		reg.Scopes = slices.Clone(supportedScopes)
+	}
+	for _, scope := range reg.Scopes {
+		switch {
+		case slices.Contains(supportedScopes, scope):
+		case !serviceClient:
+			return ClientRegistration{}, fmt.Errorf("%w: unsupported scope %q", ErrInvalidClientMetadata, scope)
+		case !validScopeToken(scope):
+			return ClientRegistration{}, fmt.Errorf("%w: scope %q contains invalid characters", ErrInvalidClientMetadata, scope)
+		}
+	}
+
+	reg.Audiences = dedupe(reg.Audiences)
+	if len(reg.Audiences) > 0 && !serviceClient {
+		return ClientRegistration{}, fmt.Errorf("%w: audiences are only used with the %s grant", ErrInvalidClientMetadata, GrantTypeClientCredentials)
+	}
+
+	reg.RedirectURIs = dedupe(reg.RedirectURIs)
+	if slices.Contains(reg.GrantTypes, GrantTypeAuthorizationCode) && len(reg.RedirectURIs) == 0 {
+		return ClientRegistration{}, fmt.Errorf("%w: the authorization_code grant requires at least one redirect uri", ErrInvalidClientMetadata)
+	}
+	for _, uri := range reg.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return ClientRegistration{}, err
+		}
+	}
+	return reg, nil
+}
+
+// validateJWKS requires a key set of public signing keys for private_key_jwt
+// clients, and none for other clients.
+func validateJWKS(method string, jwks *jose.JSONWebKeySet) error {
+	if method != AuthMethodPrivateKeyJWT {
+		if jwks != nil && len(jwks.Keys) > 0 {
+			return fmt.Errorf("%w: jwks is only used with %s", ErrInvalidClientMetadata, AuthMethodPrivateKeyJWT)
+		}
+		return nil
+	}
+	if jwks == nil || len(jwks.Keys) == 0 {
+		return fmt.Errorf("%w: %s requires a jwks", ErrInvalidClientMetadata, AuthMethodPrivateKeyJWT)
+	}
+	for _, key := range jwks.Keys {
+		if !key.Valid() || !key.IsPublic() {
+			return fmt.Errorf("%w: jwks must contain only valid public keys", ErrInvalidClientMetadata)
+		}
+		if key.Use != "" && key.Use != "sig" {
+			return fmt.Errorf("%w: jwks key %q is not a signing key", ErrInvalidClientMetadata, key.KeyID)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI requires an absolute URI without a fragment (RFC 6749
+// §3.1.2) using https, plain http on a loopback host, or a private-use scheme
+// in reverse domain name form such as com.example.app (RFC 8252 §7.1). Any
+// other scheme, including javascript, data and file, is refused.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Fragment != "" || strings.Contains(raw, "#") {
+		return fmt.Errorf("%w: redirect uri %q must be absolute without a fragment", ErrInvalidClientMetadata, raw)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+		default:
+			return fmt.Errorf("%w: redirect uri %q must use https", ErrInvalidClientMetadata, raw)
+		}
+	default:
+		if !strings.Contains(u.Scheme, ".") {
+			return fmt.Errorf("%w: redirect uri %q must use https or a reverse domain name scheme", ErrInvalidClientMetadata, raw)
+		}
+		return nil
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: redirect uri %q has no host", ErrInvalidClientMetadata, raw)
+	}
+	return nil
+}
+
+// validScopeToken reports whether scope is a scope-token (RFC 6749 §3.3).
+func validScopeToken(scope string) bool {
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return scope != ""
+}
+
+// dedupe returns values without blanks or duplicates, in their first order.
+func dedupe(values []string) []string {
+	var out []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// clientCredentialsTokenLifetime keeps service tokens short-lived. They are
+// JWTs resource servers may verify offline, so revoking one only reaches
+// those that introspect.
+const clientCredentialsTokenLifetime = 5 * time.Minute
+
+// DefaultIssuanceLimit is how many issuances Issuances returns when no limit
+// is given.
+const DefaultIssuanceLimit = 100
+
+// exchangeClientCredentials issues the client an access token on its own
+// behalf (RFC 6749 §4.4). The token is an RFC 9068 at+jwt access token whose
+// subject is the client, which services verify against the published JWKS.
+// It carries no refresh token and is recorded for audit.
+func (s *Service) exchangeClientCredentials(ctx context.Context, client Client, req TokenRequest) (TokenResponse, error) {
+	if client.Public() {
+		return TokenResponse{}, newError(ErrorUnauthorizedClient, "public clients may not use the "+GrantTypeClientCredentials+" grant")
+	}
+
+	scopes := parseScopes(req.Scope)
+	if len(scopes) == 0 {
+		scopes = serviceScopes(client)
+	}
+	for _, scope := range scopes {
+		if slices.Contains(supportedScopes, scope) {
+			return TokenResponse{}, newError(ErrorInvalidScope, fmt.Sprintf("scope %q needs a user and cannot be granted to a client", scope))
+		}
+		if !client.AllowsScope(scope) {
+			return TokenResponse{}, newError(ErrorInvalidScope, fmt.Sprintf("scope %q is not allowed for this client", scope))
+		}
+	}
+
+	audience := s.issuer
+	if req.Audience != "" {
+		if !client.AllowsAudience(req.Audience) {
+			return TokenResponse{}, newError(ErrorInvalidTarget, "audience is not allowed for this client")
+		}
+		if _, err := s.store.FindClient(ctx, req.Audience); err != nil {
+			if errors.Is(err, ErrClientNotFound) {
+				return TokenResponse{}, newError(ErrorInvalidTarget, "audience must name a registered client")
+			}
+			return TokenResponse{}, fmt.Errorf("lookup audience: %w", err)
+		}
+		audience = req.Audience
+	}
+
+	now := s.now().UTC()
+	expiresAt := now.Add(clientCredentialsTokenLifetime)
+	jti := uuid.NewString()
+	claims := map[string]any{
+		"iss":       s.issuer,
+		"sub":       client.ID,
+		"aud":       audience,
+		"client_id": client.ID,
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+		"jti":       jti,
+	}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("encode access token: %w", err)
+	}
+	accessToken, err := s.signer.SignAccessToken(ctx, payload)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("sign access token: %w", err)
+	}
+
+	hash := hashToken(accessToken)
+	err = s.store.SaveClientToken(ctx, AccessToken{
+		TokenHash: hash,
+		ClientID:  client.ID,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}, ClientTokenIssuance{
+		ID:        jti,
+		TokenHash: hash,
+		ClientID:  client.ID,
+		Audience:  audience,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		IssuedAt:  now,
+	})
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("save client token: %w", err)
+	}
+
+	return TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(clientCredentialsTokenLifetime / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// serviceScopes returns the client's allowed scopes that do not need a user,
+// which a client_credentials request without a scope is granted.
+func serviceScopes(client Client) []string {
+	var scopes []string
+	for _, scope := range client.Scopes {
+		if !slices.Contains(supportedScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// Issuances returns the most recent tokens the client_credentials grant
+// issued to the client, newest first, up to limit or DefaultIssuanceLimit.
+// They are kept after the client is deleted.
+func (r *Registry) Issuances(ctx context.Context, clientID string, limit int) ([]ClientTokenIssuance, error) {
+	if limit <= 0 {
+		limit = DefaultIssuanceLimit
+	}
+	return r.store.ListClientTokenIssuances(ctx, clientID, limit)
+}
@@ -0,0 +1,265 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeLifetime = 10 * time.Minute
+	// devicePollInterval is how often clients may poll the token endpoint;
+	// each slow_down adds deviceSlowDownStep (RFC 8628 §3.5).
+	devicePollInterval = 5 * time.Second
+	deviceSlowDownStep = 5 * time.Second
+	// User codes are typed by hand, so they use a short alphabet without
+	// vowels, which cannot spell words, or characters easily confused with
+	// digits. Eight characters give about 34 bits, plenty for a code that
+	// expires within deviceCodeLifetime (RFC 8628 §6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// ErrDeviceAuthorizationNotFound indicates the user or device code is
+// unknown, expired or was already used.
+var ErrDeviceAuthorizationNotFound = errors.New("oauth: device authorization not found")
+
+// DeviceStatus tracks a device authorization through the user's decision.
+type DeviceStatus string
+
+const (
+	DevicePending  DeviceStatus = "pending"
+	DeviceApproved DeviceStatus = "approved"
+	DeviceDenied   DeviceStatus = "denied"
+)
+
+// DeviceAuthorization is a device authorization request (RFC 8628) as
+// persisted, keyed by the hashes of its device and user codes.
+type DeviceAuthorization struct {
+	DeviceCodeHash []byte
+	UserCodeHash   []byte
+	ClientID       string
+	Scopes         []string
+	Status         DeviceStatus
+	// UserID is the user who decided the request.
+	UserID string
+	// Interval is the minimum time between polls, which grows each time the
+	// client polls too fast.
+	Interval     time.Duration
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// DeviceAuthorizationRequest carries the parameters of a device authorization
+// request. Clients authenticate as they do at the token endpoint.
+type DeviceAuthorizationRequest struct {
+	Scope            string
+	ClientID         string
+	ClientAuthMethod string
+	ClientSecret     string
+	ClientAssertion  string
+}
+
+// DeviceAuthorizationResponse is the device authorization endpoint's success
+// body (RFC 8628 §3.2).
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// AuthorizeDevice starts a device authorization: the client shows the user
+// code and verification URI to the user and polls the token endpoint with the
+// device code until the user decides. Client and request failures are
+// returned as *Error.
+func (s *Service) AuthorizeDevice(ctx context.Context, req DeviceAuthorizationRequest) (DeviceAuthorizationResponse, error) {
+	client, err := s.authenticateClient(ctx, clientCredentials{
+		id:         req.ClientID,
+		authMethod: req.ClientAuthMethod,
+		secret:     req.ClientSecret,
+		assertion:  req.ClientAssertion,
+	})
+	if err != nil {
+		return DeviceAuthorizationResponse{}, err
+	}
+	if !client.AllowsGrant(GrantTypeDeviceCode) {
+		return DeviceAuthorizationResponse{}, newError(ErrorUnauthorizedClient, "the client may not use the device_code grant")
+	}
+	scopes := parseScopes(req.Scope)
+	if err := validateScopes(client, scopes); err != nil {
+		return DeviceAuthorizationResponse{}, err
+	}
+
+	deviceCode, err := generateToken()
+	if err != nil {
+		return DeviceAuthorizationResponse{}, fmt.Errorf("generate device code: %w", err)
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return DeviceAuthorizationResponse{}, fmt.Errorf("generate user code: %w", err)
+	}
+	now := s.now().UTC()
+	err = s.store.SaveDeviceAuthorization(ctx, DeviceAuthorization{
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCodeHash:   hashToken(userCode),
+		ClientID:       client.ID,
+		Scopes:         scopes,
+		Status:         DevicePending,
+		Interval:       devicePollInterval,
+		ExpiresAt:      now.Add(deviceCodeLifetime),
+		CreatedAt:      now,
+	})
+	if err != nil {
+		return DeviceAuthorizationResponse{}, fmt.Errorf("save device authorization: %w", err)
+	}
+
+	display := formatUserCode(userCode)
+	verificationURI := s.issuer + DeviceVerificationPath
+	return DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                display,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + display,
+		ExpiresIn:               int(deviceCodeLifetime / time.Second),
+		Interval:                int(devicePollInterval / time.Second),
+	}, nil
+}
+
+// PendingDeviceAuthorization returns the undecided request a user code
+// refers to, with its client, so the user can confirm it. Codes are matched
+// ignoring case, spaces and dashes. Unknown, expired and decided codes return
+// ErrDeviceAuthorizationNotFound.
+func (s *Service) PendingDeviceAuthorization(ctx context.Context, userCode string) (DeviceAuthorization, Client, error) {
+	code := normalizeUserCode(userCode)
+	if len(code) != userCodeLength {
+		return DeviceAuthorization{}, Client{}, ErrDeviceAuthorizationNotFound
+	}
+	device, err := s.store.FindDeviceAuthorization(ctx, hashToken(code))
+	if err != nil {
+		return DeviceAuthorization{}, Client{}, err
+	}
+	if device.Status != DevicePending || !s.now().Before(device.ExpiresAt) {
+		return DeviceAuthorization{}, Client{}, ErrDeviceAuthorizationNotFound
+	}
+	client, err := s.store.FindClient(ctx, device.ClientID)
+	if err != nil {
+		return DeviceAuthorization{}, Client{}, err
+	}
+	return device, client, nil
+}
+
+// DecideDeviceAuthorization records the user's answer to the request a user
+// code refers to and returns the client that made it. Approving also records
+// consent to its scopes.
+func (s *Service) DecideDeviceAuthorization(ctx context.Context, userID, userCode string, approve bool) (Client, error) {
+	device, client, err := s.PendingDeviceAuthorization(ctx, userCode)
+	if err != nil {
+		return Client{}, err
+	}
+	status := DeviceDenied
+	if approve {
+		status = DeviceApproved
+	}
+	if err := s.store.DecideDeviceAuthorization(ctx, device.UserCodeHash, userID, status); err != nil {
+		return Client{}, err
+	}
+	if approve {
+		if err := s.store.SaveConsent(ctx, userID, device.ClientID, device.Scopes); err != nil {
+			return Client{}, fmt.Errorf("save consent: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// exchangeDeviceCode answers a client polling for the outcome of a device
+// authorization, issuing tokens once the user approved it.
+func (s *Service) exchangeDeviceCode(ctx context.Context, client Client, req TokenRequest) (TokenResponse, error) {
+	if req.DeviceCode == "" {
+		return TokenResponse{}, newError(ErrorInvalidRequest, "device_code is required")
+	}
+
+	hash := hashToken(req.DeviceCode)
+	now := s.now().UTC()
+	device, err := s.store.PollDeviceAuthorization(ctx, hash, now)
+	if err != nil {
+		if errors.Is(err, ErrDeviceAuthorizationNotFound) {
+			return TokenResponse{}, newError(ErrorInvalidGrant, "device code is invalid or was already used")
+		}
+		return TokenResponse{}, fmt.Errorf("poll device authorization: %w", err)
+	}
+	switch {
+	case device.ClientID != client.ID:
+		return TokenResponse{}, newError(ErrorInvalidGrant, "device code was issued to another client")
+	case !now.Before(device.ExpiresAt):
+		return TokenResponse{}, newError(ErrorExpiredToken, "device code expired")
+	case !device.LastPolledAt.IsZero() && now.Sub(device.LastPolledAt) < device.Interval:
+		interval := device.Interval + deviceSlowDownStep
+		if err := s.store.SlowDownDeviceAuthorization(ctx, hash, interval); err != nil {
+			return TokenResponse{}, fmt.Errorf("slow down device authorization: %w", err)
+		}
+		return TokenResponse{}, newError(ErrorSlowDown, fmt.Sprintf("poll at most every %d seconds", int(interval/time.Second)))
+	case device.Status == DevicePending:
+		return TokenResponse{}, newError(ErrorAuthorizationPending, "the user has not yet approved the request")
+	}
+
+	// Decided codes are consumed so each yields at most one response.
+	consumed, err := s.store.ConsumeDeviceAuthorization(ctx, hash)
+	if err != nil {
+		if errors.Is(err, ErrDeviceAuthorizationNotFound) {
+			return TokenResponse{}, newError(ErrorInvalidGrant, "device code is invalid or was already used")
+		}
+		return TokenResponse{}, fmt.Errorf("consume device authorization: %w", err)
+	}
+	if consumed.Status != DeviceApproved {
+		return TokenResponse{}, newError(ErrorAccessDenied, "the user denied the request")
+	}
+	return s.issueGrant(ctx, client, consumed.UserID, consumed.Scopes, "", now)
+}
+
+// generateUserCode returns a random user code of userCodeLength characters
+// from userCodeAlphabet.
+func generateUserCode() (string, error) {
+	// Bytes are rejected above the largest multiple of the alphabet size so
+	// every character is equally likely.
+	limit := 256 - 256%len(userCodeAlphabet)
+	code := make([]byte, 0, userCodeLength)
+	buf := make([]byte, userCodeLength*2)
+	for len(code) < userCodeLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < userCodeLength {
+				code = append(code, userCodeAlphabet[int(b)%len(userCodeAlphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits a user code in two halves for readability.
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode upper-cases a typed user code and drops the separators
+// users may type with it.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return r
+		}
+	}, code)
+}
@@ -0,0 +1,166 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+// Token type hints accepted by the introspection and revocation endpoints
+// (RFC 7009 §2.1), also recorded on revocations.
+const (
+	TokenTypeAccessToken  = "access_token"
+	TokenTypeRefreshToken = "refresh_token"
+)
+
+// IntrospectionRequest carries the parameters of a token introspection
+// request (RFC 7662 §2.1). Clients authenticate as they do at the token
+// endpoint.
+type IntrospectionRequest struct {
+	Token            string
+	TokenTypeHint    string
+	ClientID         string
+	ClientAuthMethod string
+	ClientSecret     string
+	ClientAssertion  string
+}
+
+// IntrospectionResponse describes a token to a resource server (RFC 7662
+// §2.2). Inactive tokens are described by Active alone.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
+
+// Introspect reports whether a token is active and, if so, what it grants.
+// Only confidential clients may introspect, as resource servers do; any of
+// them may introspect access tokens, but refresh tokens are only described to
+// the client holding them. Client failures are returned as *Error.
+func (s *Service) Introspect(ctx context.Context, req IntrospectionRequest) (IntrospectionResponse, error) {
+	client, err := s.authenticateClient(ctx, clientCredentials{
+		id:         req.ClientID,
+		authMethod: req.ClientAuthMethod,
+		secret:     req.ClientSecret,
+		assertion:  req.ClientAssertion,
+	})
+	if err != nil {
+		return IntrospectionResponse{}, err
+	}
+	if client.Public() {
+		return IntrospectionResponse{}, newError(ErrorUnauthorizedClient, "public clients may not introspect tokens")
+	}
+	if req.Token == "" {
+		return IntrospectionResponse{}, newError(ErrorInvalidRequest, "token is required")
+	}
+
+	token, err := s.lookupToken(ctx, req.Token, req.TokenTypeHint)
+	if err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return IntrospectionResponse{}, nil
+		}
+		return IntrospectionResponse{}, err
+	}
+	if !s.now().Before(token.expiresAt) || (token.tokenType == TokenTypeRefreshToken && token.clientID != client.ID) {
+		return IntrospectionResponse{}, nil
+	}
+	// Tokens die with their account: a deactivated user's tokens are inactive.
+	// Tokens issued to a client for itself have no account and name the
+	// client as their subject.
+	subject := token.userID
+	if subject == "" {
+		subject = token.clientID
+	} else if _, err := s.users.LookupByID(ctx, token.userID); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) || errors.Is(err, auth.ErrUserDeactivated) {
+			return IntrospectionResponse{}, nil
+		}
+		return IntrospectionResponse{}, fmt.Errorf("lookup user: %w", err)
+	}
+
+	resp := IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(token.scopes, " "),
+		ClientID:  token.clientID,
+		Subject:   subject,
+		ExpiresAt: token.expiresAt.Unix(),
+		IssuedAt:  token.createdAt.Unix(),
+		Issuer:    s.issuer,
+	}
+	if token.tokenType == TokenTypeAccessToken {
+		resp.TokenType = "Bearer"
+	}
+	return resp, nil
+}
+
+// issuedToken is an access or refresh token presented to the introspection
+// or revocation endpoint.
+type issuedToken struct {
+	tokenType string
+	hash      []byte
+	clientID  string
+	userID    string
+	scopes    []string
+	expiresAt time.Time
+	createdAt time.Time
+}
+
+// lookupToken finds the access or refresh token token, trying the type hint
+// names first. Unknown hints are ignored (RFC 7009 §2.1).
+func (s *Service) lookupToken(ctx context.Context, token, hint string) (issuedToken, error) {
+	hash := hashToken(token)
+	lookups := []func() (issuedToken, error){
+		func() (issuedToken, error) {
+			access, err := s.store.FindAccessToken(ctx, hash)
+			if err != nil {
+				return issuedToken{}, err
+			}
+			return issuedToken{
+				tokenType: TokenTypeAccessToken,
+				hash:      hash,
+				clientID:  access.ClientID,
+				userID:    access.UserID,
+				scopes:    access.Scopes,
+				expiresAt: access.ExpiresAt,
+				createdAt: access.CreatedAt,
+			}, nil
+		},
+		func() (issuedToken, error) {
+			refresh, err := s.store.FindRefreshToken(ctx, hash)
+			if err != nil {
+				return issuedToken{}, err
+			}
+			return issuedToken{
+				tokenType: TokenTypeRefreshToken,
+				hash:      hash,
+				clientID:  refresh.ClientID,
+				userID:    refresh.UserID,
+				scopes:    refresh.Scopes,
+				expiresAt: refresh.ExpiresAt,
+				createdAt: refresh.CreatedAt,
+			}, nil
+		},
+	}
+	if hint == TokenTypeRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		found, err := lookup()
+		if err == nil {
+			return found, nil
+		}
+		if !errors.Is(err, ErrTokenNotFound) {
+			return issuedToken{}, fmt.Errorf("lookup token: %w", err)
+		}
+	}
+	return issuedToken{}, ErrTokenNotFound
+}
@@ -0,0 +1,56 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// exchangeRefreshToken redeems a refresh token for a new access token. The
+// refresh token is rotated: the client receives a replacement and the
+// presented token stops working, so a leaked token is only good until its
+// rightful client next refreshes.
+func (s *Service) exchangeRefreshToken(ctx context.Context, client Client, req TokenRequest) (TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return TokenResponse{}, newError(ErrorInvalidRequest, "refresh_token is required")
+	}
+
+	hash := hashToken(req.RefreshToken)
+	token, err := s.store.FindRefreshToken(ctx, hash)
+	if err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return TokenResponse{}, newError(ErrorInvalidGrant, "refresh token is invalid, revoked or was already used")
+		}
+		return TokenResponse{}, fmt.Errorf("lookup refresh token: %w", err)
+	}
+	now := s.now().UTC()
+	switch {
+	case token.ClientID != client.ID:
+		return TokenResponse{}, newError(ErrorInvalidGrant, "refresh token was issued to another client")
+	case !now.Before(token.ExpiresAt):
+		return TokenResponse{}, newError(ErrorInvalidGrant, "refresh token expired")
+	}
+	user, err := s.grantUser(ctx, token.UserID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	next, err := generateToken()
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("generate refresh token: %w", err)
+	}
+	// Rotation only succeeds for the current token, so of two concurrent
+	// refreshes with the same token one fails.
+	if err := s.store.RotateRefreshToken(ctx, hash, hashToken(next)); err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return TokenResponse{}, newError(ErrorInvalidGrant, "refresh token is invalid, revoked or was already used")
+		}
+		return TokenResponse{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	resp, err := s.issueTokens(ctx, client.ID, user, token.Scopes, "", token.ID, now)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	resp.RefreshToken = next
+	return resp, nil
+}
@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RevocationRequest carries the parameters of a token revocation request
+// (RFC 7009 §2.1). Clients authenticate as they do at the token endpoint;
+// public clients send their client_id.
+type RevocationRequest struct {
+	Token            string
+	TokenTypeHint    string
+	ClientID         string
+	ClientAuthMethod string
+	ClientSecret     string
+	ClientAssertion  string
+}
+
+// Revoke revokes an access or refresh token issued to the requesting client
+// and records the revocation. Revoking a refresh token also revokes every
+// access token issued with it. Unknown tokens and tokens of other clients
+// yield ErrTokenNotFound, which the endpoint answers like a success so it
+// does not reveal which tokens exist. Client failures are returned as *Error.
+func (s *Service) Revoke(ctx context.Context, req RevocationRequest) (TokenRevocation, error) {
+	client, err := s.authenticateClient(ctx, clientCredentials{
+		id:         req.ClientID,
+		authMethod: req.ClientAuthMethod,
+		secret:     req.ClientSecret,
+		assertion:  req.ClientAssertion,
+	})
+	if err != nil {
+		return TokenRevocation{}, err
+	}
+	if req.Token == "" {
+		return TokenRevocation{}, newError(ErrorInvalidRequest, "token is required")
+	}
+
+	token, err := s.lookupToken(ctx, req.Token, req.TokenTypeHint)
+	if err != nil {
+		return TokenRevocation{}, err
+	}
+	if token.clientID != client.ID {
+		return TokenRevocation{}, ErrTokenNotFound
+	}
+	revocation, err := s.store.RevokeToken(ctx, TokenRevocation{
+		TokenType: token.tokenType,
+		TokenHash: token.hash,
+		ClientID:  token.clientID,
+		UserID:    token.userID,
+		RevokedAt: s.now().UTC(),
+	})
+	if err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return TokenRevocation{}, err
+		}
+		return TokenRevocation{}, fmt.Errorf("revoke token: %w", err)
+	}
+	return revocation, nil
+}
+
+// Consents returns the clients the user has approved, ordered by name.
+func (s *Service) Consents(ctx context.Context, userID string) ([]Consent, error) {
+	return s.store.ListConsents(ctx, userID)
+}
+
+// RevokeConsent withdraws the user's approval of the client and revokes every
+// code and token issued to the client for the user, so the client must ask
+// again. Refresh tokens take their derived access tokens with them, as at the
+// revocation endpoint, and each revoked token is recorded. It fails with
+// ErrConsentNotFound when the user has not approved the client.
+func (s *Service) RevokeConsent(ctx context.Context, userID, clientID string) ([]TokenRevocation, error) {
+	revocations, err := s.store.RevokeConsent(ctx, userID, clientID, s.now().UTC())
+	if err != nil {
+		if errors.Is(err, ErrConsentNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("revoke consent: %w", err)
+	}
+	return revocations, nil
+}
+
+// DefaultRevocationLimit is how many revocations Revocations returns when
+// no limit is given.
+const DefaultRevocationLimit = 100
+
+// Revocations returns the most recent revocations of tokens issued to the
+// client, newest first, up to limit or DefaultRevocationLimit. They are kept
+// after the client is deleted.
+func (r *Registry) Revocations(ctx context.Context, clientID string, limit int) ([]TokenRevocation, error) {
+	if limit <= 0 {
+		limit = DefaultRevocationLimit
+	}
+	return r.store.ListTokenRevocations(ctx, clientID, limit)
+}
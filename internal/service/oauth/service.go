@@ -0,0 +1,701 @@
+// Package oauth implements the OAuth 2.0 authorization server and OpenID
+// Connect provider that lets registered clients sign users in with their
+// accounts here.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	// ScopeOpenID requests an ID token.
+	ScopeOpenID = "openid"
+	// ScopeProfile releases the name and picture claims.
+	ScopeProfile = "profile"
+	// ScopeEmail releases the email and email_verified claims.
+	ScopeEmail = "email"
+
+	// ResponseTypeCode is the only response type: the authorization-code flow.
+	ResponseTypeCode = "code"
+	// GrantTypeAuthorizationCode redeems an authorization code at the token endpoint.
+	GrantTypeAuthorizationCode = "authorization_code"
+	// GrantTypeDeviceCode redeems a device code at the token endpoint (RFC 8628 §3.4).
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+	// GrantTypeRefreshToken redeems a refresh token for new tokens.
+	GrantTypeRefreshToken = "refresh_token"
+	// GrantTypeClientCredentials issues a confidential client a token on its
+	// own behalf, for calls between services.
+	GrantTypeClientCredentials = "client_credentials"
+	// CodeChallengeMethodS256 is the only accepted PKCE transformation.
+	CodeChallengeMethodS256 = "S256"
+
+	// IDTokenLifetime is how long issued ID tokens are valid, and so how long
+	// a retired signing key must stay published.
+	IDTokenLifetime = time.Hour
+
+	// Paths of the endpoints, relative to the issuer.
+	AuthorizePath           = "/oauth2/authorize"
+	TokenPath               = "/oauth2/token"
+	DeviceAuthorizationPath = "/oauth2/device_authorization"
+	DeviceVerificationPath  = "/device"
+	IntrospectionPath       = "/oauth2/introspect"
+	RevocationPath          = "/oauth2/revoke"
+	UserInfoPath            = "/userinfo"
+	DiscoveryPath           = "/.well-known/openid-configuration"
+	JWKSPath                = "/jwks.json"
+
+	authorizationCodeLifetime = time.Minute
+	accessTokenLifetime       = time.Hour
+	// refreshTokenLifetime bounds a chain of rotated refresh tokens; rotation
+	// does not extend it, so users sign in again at least this often.
+	refreshTokenLifetime = 30 * 24 * time.Hour
+	tokenByteLength      = 32
+	// pkceVerifierMinLength and pkceVerifierMaxLength bound the code verifier
+	// (RFC 7636 §4.1); an S256 challenge is always 43 characters.
+	pkceVerifierMinLength = 43
+	pkceVerifierMaxLength = 128
+)
+
+// supportedScopes lists the scopes clients may request.
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+var (
+	// ErrClientNotFound indicates no client is registered under the identifier.
+	ErrClientNotFound = errors.New("oauth: client not found")
+	// ErrInvalidRedirectURI indicates the redirect URI is not registered for
+	// the client. Like ErrClientNotFound it must be shown to the user rather
+	// than redirected, or the server would become an open redirector.
+	ErrInvalidRedirectURI = errors.New("oauth: redirect uri not registered")
+	// ErrConsentNotFound indicates the user never approved the client.
+	ErrConsentNotFound = errors.New("oauth: consent not found")
+	// ErrCodeNotFound indicates the authorization code is unknown or was redeemed.
+	ErrCodeNotFound = errors.New("oauth: authorization code not found")
+	// ErrTokenNotFound indicates the access or refresh token is unknown.
+	ErrTokenNotFound = errors.New("oauth: token not found")
+	// ErrInvalidToken indicates the access token is unknown or expired.
+	ErrInvalidToken = errors.New("oauth: invalid access token")
+	// ErrInsufficientScope indicates the access token lacks the openid scope.
+	ErrInsufficientScope = errors.New("oauth: insufficient scope")
+)
+
+// Error codes returned to clients (RFC 6749 §4.1.2.1 and §5.2, RFC 8628 §3.5,
+// RFC 8707 §2).
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+	ErrorAuthorizationPending    = "authorization_pending"
+	ErrorSlowDown                = "slow_down"
+	ErrorExpiredToken            = "expired_token"
+	ErrorInvalidTarget           = "invalid_target"
+)
+
+// Error is an OAuth error response delivered to the client, either on the
+// redirect URI or in the token endpoint's JSON body.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return "oauth: " + e.Code + ": " + e.Description
+}
+
+func newError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// UserDirectory resolves the accounts tokens are issued for.
+type UserDirectory interface {
+	LookupByID(ctx context.Context, id string) (*auth.User, error)
+}
+
+// Service issues authorization codes, access tokens and ID tokens.
+type Service struct {
+	store   Store
+	clients *Registry
+	users   UserDirectory
+	signer  Signer
+	issuer  string
+	now     func() time.Time
+}
+
+// NewService wires a Service publishing tokens under issuer, the externally
+// visible base URL of this server.
+func NewService(store Store, users UserDirectory, signer Signer, issuer string) *Service {
+	return &Service{
+		store:   store,
+		clients: NewRegistry(store),
+		users:   users,
+		signer:  signer,
+		issuer:  strings.TrimSuffix(issuer, "/"),
+		now:     time.Now,
+	}
+}
+
+// Clients returns the registry of clients allowed to use the service.
+func (s *Service) Clients() *Registry {
+	return s.clients
+}
+
+// Issuer returns the issuer identifier placed in tokens and discovery.
+func (s *Service) Issuer() string {
+	return s.issuer
+}
+
+// AuthorizationRequest carries the parameters of an authorization request.
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Scopes splits the space-delimited scope parameter, dropping duplicates.
+func (r AuthorizationRequest) Scopes() []string {
+	return parseScopes(r.Scope)
+}
+
+// ValidateAuthorization checks an authorization request and returns the
+// client it targets. ErrClientNotFound and ErrInvalidRedirectURI must not be
+// redirected; any other failure is an *Error for the client's redirect URI.
+func (s *Service) ValidateAuthorization(ctx context.Context, req AuthorizationRequest) (Client, error) {
+	if req.ClientID == "" {
+		return Client{}, ErrClientNotFound
+	}
+	client, err := s.store.FindClient(ctx, req.ClientID)
+	if err != nil {
+		return Client{}, err
+	}
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return Client{}, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != ResponseTypeCode {
+		return client, newError(ErrorUnsupportedResponseType, "only the code response type is supported")
+	}
+	if !client.AllowsGrant(GrantTypeAuthorizationCode) {
+		return client, newError(ErrorUnauthorizedClient, "the client may not use the authorization_code grant")
+	}
+	if err := validateScopes(client, req.Scopes()); err != nil {
+		return client, err
+	}
+	if req.CodeChallenge == "" {
+		return client, newError(ErrorInvalidRequest, "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return client, newError(ErrorInvalidRequest, "code_challenge_method must be S256")
+	}
+	if decoded, err := base64.RawURLEncoding.DecodeString(req.CodeChallenge); err != nil || len(decoded) != sha256.Size {
+		return client, newError(ErrorInvalidRequest, "code_challenge is malformed")
+	}
+	return client, nil
+}
+
+// validateScopes requires every scope to be supported and allowed for client.
+func validateScopes(client Client, scopes []string) *Error {
+	for _, scope := range scopes {
+		if !slices.Contains(supportedScopes, scope) {
+			return newError(ErrorInvalidScope, fmt.Sprintf("scope %q is not supported", scope))
+		}
+		if !client.AllowsScope(scope) {
+			return newError(ErrorInvalidScope, fmt.Sprintf("scope %q is not allowed for this client", scope))
+		}
+	}
+	return nil
+}
+
+// ConsentRequired reports whether the user has yet to approve every scope the
+// request asks for on behalf of its client.
+func (s *Service) ConsentRequired(ctx context.Context, userID string, req AuthorizationRequest) (bool, error) {
+	granted, err := s.store.FindConsent(ctx, userID, req.ClientID)
+	if err != nil {
+		if errors.Is(err, ErrConsentNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, scope := range req.Scopes() {
+		if !slices.Contains(granted, scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Authorize records the user's consent to the request's scopes and returns a
+// single-use authorization code. The request must have passed
+// ValidateAuthorization.
+func (s *Service) Authorize(ctx context.Context, userID string, req AuthorizationRequest) (string, error) {
+	scopes := req.Scopes()
+	if err := s.store.SaveConsent(ctx, userID, req.ClientID, scopes); err != nil {
+		return "", fmt.Errorf("save consent: %w", err)
+	}
+
+	code, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("generate authorization code: %w", err)
+	}
+	now := s.now().UTC()
+	err = s.store.SaveAuthorizationCode(ctx, AuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientID:      req.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     now.Add(authorizationCodeLifetime),
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("save authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// TokenRequest carries the parameters of a token endpoint request.
+// ClientAuthMethod records how the client presented its credentials: HTTP
+// Basic authentication, client_secret or client_assertion form fields, or
+// none at all.
+type TokenRequest struct {
+	GrantType        string
+	Code             string
+	RedirectURI      string
+	CodeVerifier     string
+	DeviceCode       string
+	RefreshToken     string
+	ClientID         string
+	ClientAuthMethod string
+	ClientSecret     string
+	ClientAssertion  string
+	// Scope and Audience are only read by the client_credentials grant.
+	Scope    string
+	Audience string
+}
+
+// TokenResponse is the token endpoint's success body (RFC 6749 §5.1).
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// credentials returns the client credentials presented with the request.
+func (r TokenRequest) credentials() clientCredentials {
+	return clientCredentials{
+		id:         r.ClientID,
+		authMethod: r.ClientAuthMethod,
+		secret:     r.ClientSecret,
+		assertion:  r.ClientAssertion,
+	}
+}
+
+// Exchange redeems an authorization code, an approved device code or a
+// refresh token for an access token and, when the openid scope was granted,
+// an ID token. Clients allowed the refresh_token grant also receive a refresh
+// token. The client_credentials grant issues the client a token of its own.
+// Client and grant failures are returned as *Error.
+func (s *Service) Exchange(ctx context.Context, req TokenRequest) (TokenResponse, error) {
+	client, err := s.authenticateClient(ctx, req.credentials())
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if !slices.Contains(supportedGrantTypes, req.GrantType) {
+		return TokenResponse{}, newError(ErrorUnsupportedGrantType, fmt.Sprintf("grant type %q is not supported", req.GrantType))
+	}
+	if !client.AllowsGrant(req.GrantType) {
+		return TokenResponse{}, newError(ErrorUnauthorizedClient, "the client may not use the "+req.GrantType+" grant")
+	}
+
+	switch req.GrantType {
+	case GrantTypeDeviceCode:
+		return s.exchangeDeviceCode(ctx, client, req)
+	case GrantTypeRefreshToken:
+		return s.exchangeRefreshToken(ctx, client, req)
+	case GrantTypeClientCredentials:
+		return s.exchangeClientCredentials(ctx, client, req)
+	default:
+		return s.exchangeAuthorizationCode(ctx, client, req)
+	}
+}
+
+// exchangeAuthorizationCode redeems an authorization code and its PKCE
+// verifier.
+func (s *Service) exchangeAuthorizationCode(ctx context.Context, client Client, req TokenRequest) (TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return TokenResponse{}, newError(ErrorInvalidRequest, "code and code_verifier are required")
+	}
+
+	// The code is consumed before it is checked so a stolen code cannot be
+	// retried against a different verifier or redirect URI.
+	code, err := s.store.ConsumeAuthorizationCode(ctx, hashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, ErrCodeNotFound) {
+			return TokenResponse{}, newError(ErrorInvalidGrant, "authorization code is invalid or was already used")
+		}
+		return TokenResponse{}, fmt.Errorf("consume authorization code: %w", err)
+	}
+	now := s.now().UTC()
+	switch {
+	case !now.Before(code.ExpiresAt):
+		return TokenResponse{}, newError(ErrorInvalidGrant, "authorization code expired")
+	case code.ClientID != client.ID:
+		return TokenResponse{}, newError(ErrorInvalidGrant, "authorization code was issued to another client")
+	case code.RedirectURI != req.RedirectURI:
+		return TokenResponse{}, newError(ErrorInvalidGrant, "redirect_uri does not match the authorization request")
+	case !verifyCodeChallenge(req.CodeVerifier, code.CodeChallenge):
+		return TokenResponse{}, newError(ErrorInvalidGrant, "code_verifier does not match the code challenge")
+	}
+	return s.issueGrant(ctx, client, code.UserID, code.Scopes, code.Nonce, now)
+}
+
+// issueGrant issues tokens for a grant the user just approved, starting a
+// refresh token chain when the client may use the refresh_token grant.
+func (s *Service) issueGrant(ctx context.Context, client Client, userID string, scopes []string, nonce string, now time.Time) (TokenResponse, error) {
+	user, err := s.grantUser(ctx, userID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if !client.AllowsGrant(GrantTypeRefreshToken) {
+		return s.issueTokens(ctx, client.ID, user, scopes, nonce, "", now)
+	}
+
+	refreshToken, err := generateToken()
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("generate refresh token: %w", err)
+	}
+	refreshTokenID := uuid.NewString()
+	err = s.store.SaveRefreshToken(ctx, RefreshToken{
+		ID:        refreshTokenID,
+		TokenHash: hashToken(refreshToken),
+		ClientID:  client.ID,
+		UserID:    user.ID,
+		Scopes:    scopes,
+		ExpiresAt: now.Add(refreshTokenLifetime),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("save refresh token: %w", err)
+	}
+	resp, err := s.issueTokens(ctx, client.ID, user, scopes, nonce, refreshTokenID, now)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	resp.RefreshToken = refreshToken
+	return resp, nil
+}
+
+// grantUser returns the user a grant was approved by.
+func (s *Service) grantUser(ctx context.Context, userID string) (*auth.User, error) {
+	user, err := s.users.LookupByID(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			return nil, newError(ErrorInvalidGrant, "the authorizing account no longer exists")
+		case errors.Is(err, auth.ErrUserDeactivated):
+			return nil, newError(ErrorInvalidGrant, "the authorizing account is deactivated")
+		}
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+	return user, nil
+}
+
+// issueTokens issues an access token to the client for the user's approved
+// scopes and, when they include openid, an ID token. The access token is tied
+// to refreshTokenID, if set, so it is revoked along with that refresh token.
+func (s *Service) issueTokens(ctx context.Context, clientID string, user *auth.User, scopes []string, nonce, refreshTokenID string, now time.Time) (TokenResponse, error) {
+	accessToken, err := generateToken()
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("generate access token: %w", err)
+	}
+	err = s.store.SaveAccessToken(ctx, AccessToken{
+		TokenHash:      hashToken(accessToken),
+		ClientID:       clientID,
+		UserID:         user.ID,
+		Scopes:         scopes,
+		RefreshTokenID: refreshTokenID,
+		ExpiresAt:      now.Add(accessTokenLifetime),
+		CreatedAt:      now,
+	})
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("save access token: %w", err)
+	}
+
+	resp := TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenLifetime / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}
+	if slices.Contains(scopes, ScopeOpenID) {
+		resp.IDToken, err = s.idToken(ctx, user, clientID, scopes, nonce, now)
+		if err != nil {
+			return TokenResponse{}, err
+		}
+	}
+	return resp, nil
+}
+
+// clientCredentials are the credentials a client presents to the token and
+// device authorization endpoints, and how it presented them.
+type clientCredentials struct {
+	id         string
+	authMethod string
+	secret     string
+	assertion  string
+}
+
+// authenticateClient checks the client's credentials, which must be
+// presented with the method the client registered. Public clients present
+// none.
+func (s *Service) authenticateClient(ctx context.Context, creds clientCredentials) (Client, error) {
+	failed := newError(ErrorInvalidClient, "client authentication failed")
+	clientID := creds.id
+	if clientID == "" && creds.authMethod == AuthMethodPrivateKeyJWT {
+		clientID = assertionSubject(creds.assertion)
+	}
+	if clientID == "" {
+		return Client{}, failed
+	}
+	client, err := s.store.FindClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return Client{}, failed
+		}
+		return Client{}, fmt.Errorf("lookup client: %w", err)
+	}
+	if creds.authMethod != client.AuthMethod {
+		return Client{}, newError(ErrorInvalidClient, "the client must authenticate with "+client.AuthMethod)
+	}
+
+	now := s.now()
+	switch client.AuthMethod {
+	case AuthMethodNone:
+		return client, nil
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost:
+		if !client.verifySecret(creds.secret, now) {
+			return Client{}, failed
+		}
+		return client, nil
+	case AuthMethodPrivateKeyJWT:
+		if err := s.verifyClientAssertion(ctx, client, creds.assertion, now); err != nil {
+			return Client{}, err
+		}
+		return client, nil
+	default:
+		return Client{}, failed
+	}
+}
+
+// idToken signs the ID token for user, scoped to the granted claims.
+func (s *Service) idToken(ctx context.Context, user *auth.User, clientID string, scopes []string, nonce string, now time.Time) (string, error) {
+	claims := userClaims(user, scopes)
+	claims["iss"] = s.issuer
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(IDTokenLifetime).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("encode id token: %w", err)
+	}
+	token, err := s.signer.Sign(ctx, payload)
+	if err != nil {
+		return "", fmt.Errorf("sign id token: %w", err)
+	}
+	return token, nil
+}
+
+// UserInfo returns the claims the access token's scopes release about its user.
+func (s *Service) UserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	token, err := s.store.FindAccessToken(ctx, hashToken(accessToken))
+	if err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("lookup access token: %w", err)
+	}
+	if !s.now().Before(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	// Tokens issued to a client for itself never carry openid.
+	if !slices.Contains(token.Scopes, ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+
+	user, err := s.users.LookupByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) || errors.Is(err, auth.ErrUserDeactivated) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+	return userClaims(user, token.Scopes), nil
+}
+
+// userClaims returns the standard claims scopes release about user.
+func userClaims(user *auth.User, scopes []string) map[string]any {
+	claims := map[string]any{"sub": user.ID}
+	if slices.Contains(scopes, ScopeEmail) {
+		claims["email"] = user.Email.String()
+		claims["email_verified"] = emailVerified(user)
+	}
+	if slices.Contains(scopes, ScopeProfile) {
+		if user.DisplayName != "" {
+			claims["name"] = user.DisplayName
+		}
+		if user.AvatarURL != "" {
+			claims["picture"] = user.AvatarURL
+		}
+	}
+	return claims
+}
+
+// emailVerified reports whether a linked provider vouched for the account's
+// email address. Password signups never prove ownership.
+func emailVerified(user *auth.User) bool {
+	for _, identity := range user.Identities {
+		if identity.EmailVerified && strings.EqualFold(identity.Email, user.Email.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discovery is the OpenID provider metadata document.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	// TokenEndpointAuthSigningAlgValuesSupported lists the algorithms
+	// accepted on private_key_jwt assertions.
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	// Public clients may revoke their tokens but not introspect any.
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                           []string `json:"claims_supported"`
+	// AuthorizationResponseIssParameterSupported advertises the iss parameter
+	// on authorization responses (RFC 9207).
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported"`
+}
+
+// Discovery describes the provider's endpoints and capabilities.
+func (s *Service) Discovery() Discovery {
+	return Discovery{
+		Issuer:                                     s.issuer,
+		AuthorizationEndpoint:                      s.issuer + AuthorizePath,
+		TokenEndpoint:                              s.issuer + TokenPath,
+		DeviceAuthorizationEndpoint:                s.issuer + DeviceAuthorizationPath,
+		IntrospectionEndpoint:                      s.issuer + IntrospectionPath,
+		RevocationEndpoint:                         s.issuer + RevocationPath,
+		UserInfoEndpoint:                           s.issuer + UserInfoPath,
+		JWKSURI:                                    s.issuer + JWKSPath,
+		ScopesSupported:                            slices.Clone(supportedScopes),
+		ResponseTypesSupported:                     []string{ResponseTypeCode},
+		GrantTypesSupported:                        slices.Clone(supportedGrantTypes),
+		SubjectTypesSupported:                      []string{"public"},
+		IDTokenSigningAlgValuesSupported:           []string{string(s.signer.Algorithm())},
+		TokenEndpointAuthMethodsSupported:          slices.Clone(supportedAuthMethods),
+		TokenEndpointAuthSigningAlgValuesSupported: assertionAlgorithmNames(),
+		IntrospectionEndpointAuthMethodsSupported:  slices.DeleteFunc(slices.Clone(supportedAuthMethods), func(method string) bool { return method == AuthMethodNone }),
+		RevocationEndpointAuthMethodsSupported:     slices.Clone(supportedAuthMethods),
+		CodeChallengeMethodsSupported:              []string{CodeChallengeMethodS256},
+		ClaimsSupported:                            []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "email_verified", "name", "picture"},
+		AuthorizationResponseIssParameterSupported: true,
+	}
+}
+
+// assertionAlgorithmNames returns the names of clientAssertionAlgorithms.
+func assertionAlgorithmNames() []string {
+	names := make([]string, len(clientAssertionAlgorithms))
+	for i, alg := range clientAssertionAlgorithms {
+		names[i] = string(alg)
+	}
+	return names
+}
+
+// JWKS returns the public keys that verify issued ID tokens.
+func (s *Service) JWKS(ctx context.Context) (jose.JSONWebKeySet, error) {
+	return s.signer.KeySet(ctx)
+}
+
+// verifyCodeChallenge checks verifier against an S256 challenge (RFC 7636 §4.6).
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < pkceVerifierMinLength || len(verifier) > pkceVerifierMaxLength {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// parseScopes splits a space-delimited scope string, dropping duplicates.
+func parseScopes(raw string) []string {
+	var scopes []string
+	for _, scope := range strings.Fields(raw) {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// generateToken returns a random URL-safe credential.
+func generateToken() (string, error) {
+	buf := make([]byte, tokenByteLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the digest under which a credential is stored. Tokens are
+// high-entropy, so an unsalted hash is enough to keep a database leak from
+// yielding usable credentials.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
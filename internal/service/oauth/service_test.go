@@ -0,0 +1,1302 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+	"github.com/rjnemo/auth/internal/service/signing"
+)
+
+const (
+	testIssuer      = "https://auth.example.test"
+	testRedirectURI = "https://app.example.test/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk-verifier"
+)
+
+func newTestSigner(t *testing.T) *signing.KeySigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := signing.NewKeySigner(key)
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	return signer
+}
+
+// newTestService returns a service with a registered confidential client, its
+// secret, and a user to authorize.
+func newTestService(t *testing.T) (*Service, Client, string, *auth.User) {
+	t.Helper()
+	ctx := context.Background()
+
+	users := auth.NewService(auth.NewMemoryStore())
+	user, err := users.Register(ctx, auth.MustUserEmail("jane@example.com"), "Password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	service := NewService(NewMemoryStore(), users, newTestSigner(t), testIssuer+"/")
+	client, secret, err := service.Clients().Register(ctx, ClientRegistration{Name: "Example App", RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	return service, client, secret, user
+}
+
+func newClientKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	return key
+}
+
+func publicJWKS(key *ecdsa.PrivateKey) *jose.JSONWebKeySet {
+	return &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: key.Public(), KeyID: "client-key", Algorithm: string(jose.ES256), Use: "sig"},
+	}}
+}
+
+// clientAssertion signs a private_key_jwt assertion with claims.
+func clientAssertion(t *testing.T, key *ecdsa.PrivateKey, claims jwt.Claims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "client-key"))
+	if err != nil {
+		t.Fatalf("new assertion signer: %v", err)
+	}
+	assertion, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+	return assertion
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func validRequest(clientID string) AuthorizationRequest {
+	return AuthorizationRequest{
+		ClientID:            clientID,
+		RedirectURI:         testRedirectURI,
+		ResponseType:        ResponseTypeCode,
+		Scope:               "openid email profile",
+		State:               "state-1",
+		Nonce:               "nonce-1",
+		CodeChallenge:       codeChallenge(testVerifier),
+		CodeChallengeMethod: CodeChallengeMethodS256,
+	}
+}
+
+func TestRegisterClient(t *testing.T) {
+	t.Parallel()
+
+	service, client, secret, _ := newTestService(t)
+	if client.ID == "" || secret == "" || client.Public() {
+		t.Fatalf("expected confidential client with secret, got %+v", client)
+	}
+	if client.AuthMethod != AuthMethodClientSecretBasic || !client.AllowsGrant(GrantTypeAuthorizationCode) || !client.AllowsScope(ScopeEmail) {
+		t.Fatalf("expected registration defaults, got %+v", client)
+	}
+
+	public, secret, err := service.Clients().Register(context.Background(), ClientRegistration{
+		Name:         "CLI",
+		Type:         ClientPublic,
+		RedirectURIs: []string{"http://127.0.0.1:8765/callback"},
+	})
+	if err != nil {
+		t.Fatalf("register public client: %v", err)
+	}
+	if secret != "" || !public.Public() || public.AuthMethod != AuthMethodNone {
+		t.Fatalf("expected public client without secret, got %+v", public)
+	}
+
+	for name, uris := range map[string][]string{
+		"none":          nil,
+		"relative":      {"/callback"},
+		"fragment":      {"https://app.example.test/callback#frag"},
+		"plain http":    {"http://app.example.test/callback"},
+		"missing host":  {"https:///callback"},
+		"one bad entry": {testRedirectURI, "callback"},
+	} {
+		if _, _, err := service.Clients().Register(context.Background(), ClientRegistration{Name: "Bad", RedirectURIs: uris}); !errors.Is(err, ErrInvalidClientMetadata) {
+			t.Fatalf("%s: expected ErrInvalidClientMetadata, got %v", name, err)
+		}
+	}
+
+	key := newClientKey(t)
+	for name, reg := range map[string]ClientRegistration{
+		"missing name":           {RedirectURIs: []string{testRedirectURI}},
+		"unknown type":           {Name: "Bad", Type: "trusted", RedirectURIs: []string{testRedirectURI}},
+		"public with secret":     {Name: "Bad", Type: ClientPublic, AuthMethod: AuthMethodClientSecretPost, RedirectURIs: []string{testRedirectURI}},
+		"confidential with none": {Name: "Bad", AuthMethod: AuthMethodNone, RedirectURIs: []string{testRedirectURI}},
+		"unknown auth method":    {Name: "Bad", AuthMethod: "tls_client_auth", RedirectURIs: []string{testRedirectURI}},
+		"unsupported grant":      {Name: "Bad", GrantTypes: []string{"password"}, RedirectURIs: []string{testRedirectURI}},
+		"unsupported scope":      {Name: "Bad", Scopes: []string{"admin"}, RedirectURIs: []string{testRedirectURI}},
+		"malformed api scope":    {Name: "Bad", GrantTypes: []string{GrantTypeClientCredentials}, Scopes: []string{`orders"read`}},
+		"public service client":  {Name: "Bad", Type: ClientPublic, GrantTypes: []string{GrantTypeClientCredentials}},
+		"audience without grant": {Name: "Bad", Audiences: []string{"orders"}, RedirectURIs: []string{testRedirectURI}},
+		"jwt without jwks":       {Name: "Bad", AuthMethod: AuthMethodPrivateKeyJWT, RedirectURIs: []string{testRedirectURI}},
+		"jwks with secret":       {Name: "Bad", JWKS: publicJWKS(key), RedirectURIs: []string{testRedirectURI}},
+		"private key in jwks": {Name: "Bad", AuthMethod: AuthMethodPrivateKeyJWT, RedirectURIs: []string{testRedirectURI}, JWKS: &jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: key, KeyID: "k1", Algorithm: string(jose.ES256)}},
+		}},
+	} {
+		if _, _, err := service.Clients().Register(context.Background(), reg); !errors.Is(err, ErrInvalidClientMetadata) {
+			t.Fatalf("%s: expected ErrInvalidClientMetadata, got %v", name, err)
+		}
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		uri   string
+		valid bool
+	}{
+		"https":                  {uri: "https://app.example.test/callback", valid: true},
+		"loopback http":          {uri: "http://127.0.0.1:8765/callback", valid: true},
+		"localhost http":         {uri: "http://localhost/callback", valid: true},
+		"ipv6 loopback http":     {uri: "http://[::1]:8765/callback", valid: true},
+		"private-use scheme":     {uri: "com.example.app:/oauth2redirect", valid: true},
+		"private-use with slash": {uri: "com.example.app://callback", valid: true},
+		"plain http":             {uri: "http://app.example.test/callback"},
+		"https without host":     {uri: "https:///callback"},
+		"javascript":             {uri: "javascript:alert(document.cookie)"},
+		"data":                   {uri: "data:text/html,<script>alert(1)</script>"},
+		"file":                   {uri: "file:///etc/passwd"},
+		"scheme without dot":     {uri: "myapp://callback"},
+		"ftp":                    {uri: "ftp://app.example.test/callback"},
+		"relative":               {uri: "/callback"},
+		"fragment":               {uri: "com.example.app:/callback#frag"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateRedirectURI(tc.uri)
+			if tc.valid && err != nil {
+				t.Fatalf("expected %q to be accepted, got %v", tc.uri, err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidClientMetadata) {
+				t.Fatalf("expected %q to be refused, got %v", tc.uri, err)
+			}
+		})
+	}
+}
+
+func TestRegistryManagesClients(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client, _, _ := newTestService(t)
+	registry := service.Clients()
+
+	updated, err := registry.Update(ctx, client.ID, ClientRegistration{
+		Name:         "Renamed App",
+		AuthMethod:   AuthMethodClientSecretPost,
+		RedirectURIs: []string{"https://app.example.test/other"},
+		Scopes:       []string{ScopeOpenID},
+	})
+	if err != nil {
+		t.Fatalf("update client: %v", err)
+	}
+	found, err := registry.Find(ctx, client.ID)
+	if err != nil {
+		t.Fatalf("find client: %v", err)
+	}
+	if found.Name != "Renamed App" || found.AuthMethod != AuthMethodClientSecretPost || found.HasRedirectURI(testRedirectURI) || found.AllowsScope(ScopeEmail) {
+		t.Fatalf("expected updated metadata, got %+v", found)
+	}
+	if len(found.Secrets) != 1 || !found.CreatedAt.Equal(client.CreatedAt) || updated.UpdatedAt.Before(client.UpdatedAt) {
+		t.Fatalf("expected update to keep secrets and creation time, got %+v", found)
+	}
+	if _, err := registry.Update(ctx, client.ID, ClientRegistration{Name: "SPA", Type: ClientPublic, RedirectURIs: []string{testRedirectURI}}); !errors.Is(err, ErrInvalidClientMetadata) {
+		t.Fatalf("expected type change to be rejected, got %v", err)
+	}
+	if _, err := registry.Update(ctx, "missing", ClientRegistration{Name: "X", RedirectURIs: []string{testRedirectURI}}); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got %v", err)
+	}
+
+	public, _, err := registry.Register(ctx, ClientRegistration{Name: "SPA", Type: ClientPublic, RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("register public client: %v", err)
+	}
+	if _, err := registry.RotateSecret(ctx, public.ID, time.Hour); !errors.Is(err, ErrClientHasNoSecret) {
+		t.Fatalf("expected ErrClientHasNoSecret, got %v", err)
+	}
+
+	clients, err := registry.List(ctx)
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 2 || clients[0].ID != client.ID || clients[1].ID != public.ID || clients[0].Secrets != nil {
+		t.Fatalf("expected both clients oldest first without secrets, got %+v", clients)
+	}
+
+	if err := registry.Delete(ctx, client.ID); err != nil {
+		t.Fatalf("delete client: %v", err)
+	}
+	if _, err := registry.Find(ctx, client.ID); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("expected deleted client to be gone, got %v", err)
+	}
+	if err := registry.Delete(ctx, client.ID); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound on second delete, got %v", err)
+	}
+}
+
+func TestRotateClientSecret(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client, oldSecret, _ := newTestService(t)
+	authenticate := func(secret string, at time.Time) error {
+		clocked := *service
+		clocked.now = func() time.Time { return at }
+		_, err := clocked.authenticateClient(ctx, clientCredentials{id: client.ID, authMethod: AuthMethodClientSecretBasic, secret: secret})
+		return err
+	}
+
+	now := time.Now()
+	newSecret, err := service.Clients().RotateSecret(ctx, client.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("rotate secret: %v", err)
+	}
+	if newSecret == "" || newSecret == oldSecret {
+		t.Fatal("expected a fresh secret")
+	}
+	if err := authenticate(oldSecret, now); err != nil {
+		t.Fatalf("expected old secret to work during the overlap: %v", err)
+	}
+	if err := authenticate(newSecret, now); err != nil {
+		t.Fatalf("expected new secret to work: %v", err)
+	}
+	if err := authenticate(oldSecret, now.Add(2*time.Hour)); err == nil {
+		t.Fatal("expected old secret to expire after the overlap")
+	}
+	if err := authenticate(newSecret, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("expected new secret to outlive the overlap: %v", err)
+	}
+
+	latest, err := service.Clients().RotateSecret(ctx, client.ID, 0)
+	if err != nil {
+		t.Fatalf("rotate secret without overlap: %v", err)
+	}
+	if err := authenticate(newSecret, time.Now()); err == nil {
+		t.Fatal("expected a zero overlap to revoke the previous secret at once")
+	}
+	if err := authenticate(latest, time.Now()); err != nil {
+		t.Fatalf("expected latest secret to work: %v", err)
+	}
+	if _, err := service.Clients().RotateSecret(ctx, client.ID, -time.Hour); !errors.Is(err, ErrInvalidClientMetadata) {
+		t.Fatalf("expected negative overlap to be rejected, got %v", err)
+	}
+}
+
+func TestValidateAuthorization(t *testing.T) {
+	t.Parallel()
+
+	service, client, _, _ := newTestService(t)
+	limited, _, err := service.Clients().Register(context.Background(), ClientRegistration{
+		Name:         "Sign-in only",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{ScopeOpenID},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+
+	cases := map[string]struct {
+		mutate   func(*AuthorizationRequest)
+		wantErr  error
+		wantCode string
+	}{
+		"valid":                  {mutate: func(*AuthorizationRequest) {}},
+		"unknown client":         {mutate: func(r *AuthorizationRequest) { r.ClientID = "nope" }, wantErr: ErrClientNotFound},
+		"missing client":         {mutate: func(r *AuthorizationRequest) { r.ClientID = "" }, wantErr: ErrClientNotFound},
+		"unregistered redirect":  {mutate: func(r *AuthorizationRequest) { r.RedirectURI = "https://evil.example.test/callback" }, wantErr: ErrInvalidRedirectURI},
+		"redirect prefix match":  {mutate: func(r *AuthorizationRequest) { r.RedirectURI = testRedirectURI + "/extra" }, wantErr: ErrInvalidRedirectURI},
+		"missing redirect":       {mutate: func(r *AuthorizationRequest) { r.RedirectURI = "" }, wantErr: ErrInvalidRedirectURI},
+		"token response type":    {mutate: func(r *AuthorizationRequest) { r.ResponseType = "token" }, wantCode: ErrorUnsupportedResponseType},
+		"unknown scope":          {mutate: func(r *AuthorizationRequest) { r.Scope = "openid admin" }, wantCode: ErrorInvalidScope},
+		"scope not allowed":      {mutate: func(r *AuthorizationRequest) { r.ClientID, r.Scope = limited.ID, "openid email" }, wantCode: ErrorInvalidScope},
+		"missing challenge":      {mutate: func(r *AuthorizationRequest) { r.CodeChallenge = "" }, wantCode: ErrorInvalidRequest},
+		"plain challenge method": {mutate: func(r *AuthorizationRequest) { r.CodeChallengeMethod = "plain" }, wantCode: ErrorInvalidRequest},
+		"malformed challenge":    {mutate: func(r *AuthorizationRequest) { r.CodeChallenge = "short" }, wantCode: ErrorInvalidRequest},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := validRequest(client.ID)
+			tc.mutate(&req)
+			_, err := service.ValidateAuthorization(context.Background(), req)
+
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+			case tc.wantCode != "":
+				var oauthErr *Error
+				if !errors.As(err, &oauthErr) || oauthErr.Code != tc.wantCode {
+					t.Fatalf("expected %s error, got %v", tc.wantCode, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client, secret, user := newTestService(t)
+	req := validRequest(client.ID)
+
+	required, err := service.ConsentRequired(ctx, user.ID, req)
+	if err != nil || !required {
+		t.Fatalf("expected consent to be required, got %v, %v", required, err)
+	}
+	code, err := service.Authorize(ctx, user.ID, req)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if required, _ := service.ConsentRequired(ctx, user.ID, req); required {
+		t.Fatal("expected consent to be remembered")
+	}
+	if required, _ := service.ConsentRequired(ctx, user.ID, AuthorizationRequest{ClientID: "other", Scope: "openid"}); !required {
+		t.Fatal("expected consent for another client to be required")
+	}
+
+	resp, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeAuthorizationCode,
+		Code:             code,
+		RedirectURI:      testRedirectURI,
+		CodeVerifier:     testVerifier,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if resp.AccessToken == "" || resp.TokenType != "Bearer" || resp.Scope != "openid email profile" || resp.IDToken == "" {
+		t.Fatalf("unexpected token response %+v", resp)
+	}
+
+	keys, err := service.JWKS(ctx)
+	if err != nil {
+		t.Fatalf("jwks: %v", err)
+	}
+	parsed, err := jwt.ParseSigned(resp.IDToken, []jose.SignatureAlgorithm{jose.ES256})
+	if err != nil {
+		t.Fatalf("parse id token: %v", err)
+	}
+	var claims struct {
+		jwt.Claims
+		Nonce         string `json:"nonce"`
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	if err := parsed.Claims(keys.Key(parsed.Headers[0].KeyID)[0].Key, &claims); err != nil {
+		t.Fatalf("verify id token: %v", err)
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: testIssuer, Subject: user.ID, AnyAudience: jwt.Audience{client.ID}, Time: time.Now()}, 0); err != nil {
+		t.Fatalf("validate id token: %v", err)
+	}
+	if claims.Nonce != "nonce-1" || claims.Email != "jane@example.com" || claims.EmailVerified == nil || *claims.EmailVerified {
+		t.Fatalf("unexpected id token claims %+v", claims)
+	}
+
+	info, err := service.UserInfo(ctx, resp.AccessToken)
+	if err != nil {
+		t.Fatalf("userinfo: %v", err)
+	}
+	if info["sub"] != user.ID || info["email"] != "jane@example.com" {
+		t.Fatalf("unexpected userinfo %v", info)
+	}
+	if _, err := service.UserInfo(ctx, "unknown"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	_, err = service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeAuthorizationCode,
+		Code:             code,
+		RedirectURI:      testRedirectURI,
+		CodeVerifier:     testVerifier,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidGrant {
+		t.Fatalf("expected replayed code to fail with invalid_grant, got %v", err)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client, secret, user := newTestService(t)
+	public, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "SPA", Type: ClientPublic, RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("register public client: %v", err)
+	}
+
+	cases := map[string]struct {
+		mutate   func(*TokenRequest)
+		expire   bool
+		wantCode string
+	}{
+		"wrong secret":        {mutate: func(r *TokenRequest) { r.ClientSecret = "wrong" }, wantCode: ErrorInvalidClient},
+		"missing secret":      {mutate: func(r *TokenRequest) { r.ClientSecret = "" }, wantCode: ErrorInvalidClient},
+		"unregistered method": {mutate: func(r *TokenRequest) { r.ClientAuthMethod = AuthMethodClientSecretPost }, wantCode: ErrorInvalidClient},
+		"unknown client":      {mutate: func(r *TokenRequest) { r.ClientID = "nope" }, wantCode: ErrorInvalidClient},
+		"other client": {mutate: func(r *TokenRequest) {
+			r.ClientID, r.ClientAuthMethod, r.ClientSecret = public.ID, AuthMethodNone, ""
+		}, wantCode: ErrorInvalidGrant},
+		"public with secret": {mutate: func(r *TokenRequest) { r.ClientID = public.ID }, wantCode: ErrorInvalidClient},
+		"wrong grant type":   {mutate: func(r *TokenRequest) { r.GrantType = "password" }, wantCode: ErrorUnsupportedGrantType},
+		"grant not allowed":  {mutate: func(r *TokenRequest) { r.GrantType = GrantTypeDeviceCode }, wantCode: ErrorUnauthorizedClient},
+		"missing verifier":   {mutate: func(r *TokenRequest) { r.CodeVerifier = "" }, wantCode: ErrorInvalidRequest},
+		"wrong verifier":     {mutate: func(r *TokenRequest) { r.CodeVerifier = strings.Repeat("a", 43) }, wantCode: ErrorInvalidGrant},
+		"wrong redirect uri": {mutate: func(r *TokenRequest) { r.RedirectURI = "https://app.example.test/other" }, wantCode: ErrorInvalidGrant},
+		"unknown code":       {mutate: func(r *TokenRequest) { r.Code = "forged" }, wantCode: ErrorInvalidGrant},
+		"expired code":       {mutate: func(*TokenRequest) {}, expire: true, wantCode: ErrorInvalidGrant},
+		"verifier too short": {mutate: func(r *TokenRequest) { r.CodeVerifier = "abc" }, wantCode: ErrorInvalidGrant},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			code, err := service.Authorize(ctx, user.ID, validRequest(client.ID))
+			if err != nil {
+				t.Fatalf("authorize: %v", err)
+			}
+			req := TokenRequest{
+				GrantType:        GrantTypeAuthorizationCode,
+				Code:             code,
+				RedirectURI:      testRedirectURI,
+				CodeVerifier:     testVerifier,
+				ClientID:         client.ID,
+				ClientAuthMethod: AuthMethodClientSecretBasic,
+				ClientSecret:     secret,
+			}
+			tc.mutate(&req)
+
+			exchanger := service
+			if tc.expire {
+				expired := *service
+				expired.now = func() time.Time { return time.Now().Add(authorizationCodeLifetime) }
+				exchanger = &expired
+			}
+			_, err = exchanger.Exchange(ctx, req)
+			var oauthErr *Error
+			if !errors.As(err, &oauthErr) || oauthErr.Code != tc.wantCode {
+				t.Fatalf("expected %s, got %v", tc.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyJWTAuthentication(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, _, _, _ := newTestService(t)
+	key := newClientKey(t)
+	client, secret, err := service.Clients().Register(ctx, ClientRegistration{
+		Name:         "Back office",
+		AuthMethod:   AuthMethodPrivateKeyJWT,
+		RedirectURIs: []string{testRedirectURI},
+		JWKS:         publicJWKS(key),
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	if secret != "" {
+		t.Fatal("expected no secret for a private_key_jwt client")
+	}
+
+	now := time.Now()
+	claims := func(jti string) jwt.Claims {
+		return jwt.Claims{
+			Issuer:   client.ID,
+			Subject:  client.ID,
+			Audience: jwt.Audience{testIssuer + TokenPath},
+			ID:       jti,
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+		}
+	}
+	authenticate := func(clientID, assertion string) error {
+		_, err := service.authenticateClient(ctx, clientCredentials{id: clientID, authMethod: AuthMethodPrivateKeyJWT, assertion: assertion})
+		return err
+	}
+
+	valid := clientAssertion(t, key, claims("jti-1"))
+	if err := authenticate(client.ID, valid); err != nil {
+		t.Fatalf("expected assertion to authenticate: %v", err)
+	}
+	if err := authenticate(client.ID, valid); err == nil {
+		t.Fatal("expected a replayed assertion to be rejected")
+	}
+	if err := authenticate("", clientAssertion(t, key, claims("jti-2"))); err != nil {
+		t.Fatalf("expected client to be identified by the assertion subject: %v", err)
+	}
+
+	cases := map[string]func() string{
+		"other key": func() string { return clientAssertion(t, newClientKey(t), claims("jti-3")) },
+		"wrong audience": func() string {
+			c := claims("jti-4")
+			c.Audience = jwt.Audience{"https://other.example.test/token"}
+			return clientAssertion(t, key, c)
+		},
+		"wrong issuer": func() string {
+			c := claims("jti-5")
+			c.Issuer = "someone-else"
+			return clientAssertion(t, key, c)
+		},
+		"expired": func() string {
+			c := claims("jti-6")
+			c.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+			return clientAssertion(t, key, c)
+		},
+		"too long lived": func() string {
+			c := claims("jti-7")
+			c.Expiry = jwt.NewNumericDate(now.Add(time.Hour))
+			return clientAssertion(t, key, c)
+		},
+		"missing jti": func() string { return clientAssertion(t, key, claims("")) },
+		"malformed":   func() string { return "not-a-jwt" },
+	}
+	for name, assertion := range cases {
+		var oauthErr *Error
+		if err := authenticate(client.ID, assertion()); !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidClient {
+			t.Fatalf("%s: expected invalid_client, got %v", name, err)
+		}
+	}
+
+	_, err = service.authenticateClient(ctx, clientCredentials{id: client.ID, authMethod: AuthMethodClientSecretPost, secret: "guess"})
+	if err == nil {
+		t.Fatal("expected a secret to be rejected for a private_key_jwt client")
+	}
+}
+
+func TestPublicClientExchange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, _, _, user := newTestService(t)
+	public, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "SPA", Type: ClientPublic, RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("register public client: %v", err)
+	}
+
+	req := validRequest(public.ID)
+	req.Scope = "email"
+	code, err := service.Authorize(ctx, user.ID, req)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeAuthorizationCode,
+		Code:             code,
+		RedirectURI:      testRedirectURI,
+		CodeVerifier:     testVerifier,
+		ClientID:         public.ID,
+		ClientAuthMethod: AuthMethodNone,
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if resp.IDToken != "" {
+		t.Fatal("expected no id token without the openid scope")
+	}
+	if _, err := service.UserInfo(ctx, resp.AccessToken); !errors.Is(err, ErrInsufficientScope) {
+		t.Fatalf("expected ErrInsufficientScope, got %v", err)
+	}
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, _, _, user := newTestService(t)
+	cli, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "CLI", Type: ClientPublic, GrantTypes: []string{GrantTypeDeviceCode}})
+	if err != nil {
+		t.Fatalf("register device client: %v", err)
+	}
+
+	now := time.Now()
+	clocked := *service
+	clocked.now = func() time.Time { return now }
+	start, err := clocked.AuthorizeDevice(ctx, DeviceAuthorizationRequest{Scope: "openid email", ClientID: cli.ID, ClientAuthMethod: AuthMethodNone})
+	if err != nil {
+		t.Fatalf("authorize device: %v", err)
+	}
+	if start.DeviceCode == "" || len(start.UserCode) != userCodeLength+1 || start.VerificationURI != testIssuer+DeviceVerificationPath || start.Interval != 5 || start.ExpiresIn != 600 {
+		t.Fatalf("unexpected device authorization %+v", start)
+	}
+	if start.VerificationURIComplete != start.VerificationURI+"?user_code="+start.UserCode {
+		t.Fatalf("unexpected complete verification uri %q", start.VerificationURIComplete)
+	}
+
+	poll := func(at time.Time) (TokenResponse, string) {
+		t.Helper()
+		clocked.now = func() time.Time { return at }
+		resp, err := clocked.Exchange(ctx, TokenRequest{GrantType: GrantTypeDeviceCode, DeviceCode: start.DeviceCode, ClientID: cli.ID, ClientAuthMethod: AuthMethodNone})
+		var oauthErr *Error
+		if errors.As(err, &oauthErr) {
+			return resp, oauthErr.Code
+		}
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		return resp, ""
+	}
+
+	if _, code := poll(now); code != ErrorAuthorizationPending {
+		t.Fatalf("expected authorization_pending, got %q", code)
+	}
+	if _, code := poll(now.Add(time.Second)); code != ErrorSlowDown {
+		t.Fatalf("expected slow_down when polling early, got %q", code)
+	}
+	if _, code := poll(now.Add(7 * time.Second)); code != ErrorSlowDown {
+		t.Fatalf("expected the interval to grow after slow_down, got %q", code)
+	}
+	now = now.Add(30 * time.Second)
+	if _, code := poll(now); code != ErrorAuthorizationPending {
+		t.Fatalf("expected authorization_pending after waiting, got %q", code)
+	}
+
+	typed := strings.ToLower(strings.ReplaceAll(start.UserCode, "-", " "))
+	device, client, err := clocked.PendingDeviceAuthorization(ctx, typed)
+	if err != nil {
+		t.Fatalf("lookup user code: %v", err)
+	}
+	if client.ID != cli.ID || !slices.Equal(device.Scopes, []string{"openid", "email"}) {
+		t.Fatalf("unexpected pending authorization %+v for %+v", device, client)
+	}
+	if decided, err := clocked.DecideDeviceAuthorization(ctx, user.ID, start.UserCode, true); err != nil || decided.ID != cli.ID {
+		t.Fatalf("approve: %+v %v", decided, err)
+	}
+	if _, err := clocked.DecideDeviceAuthorization(ctx, user.ID, start.UserCode, false); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Fatalf("expected a decided code to be unusable, got %v", err)
+	}
+
+	now = now.Add(20 * time.Second)
+	resp, code := poll(now)
+	if code != "" || resp.AccessToken == "" || resp.IDToken == "" || resp.Scope != "openid email" {
+		t.Fatalf("expected tokens after approval, got %+v %q", resp, code)
+	}
+	if claims, err := clocked.UserInfo(ctx, resp.AccessToken); err != nil || claims["sub"] != user.ID {
+		t.Fatalf("expected userinfo for the approving user, got %v %v", claims, err)
+	}
+	if required, err := clocked.ConsentRequired(ctx, user.ID, AuthorizationRequest{ClientID: cli.ID, Scope: "openid email"}); err != nil || required {
+		t.Fatalf("expected approval to record consent, got %v %v", required, err)
+	}
+	if _, code := poll(now.Add(time.Minute)); code != ErrorInvalidGrant {
+		t.Fatalf("expected a redeemed device code to be rejected, got %q", code)
+	}
+}
+
+func TestDeviceAuthorizationRejects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, webApp, _, user := newTestService(t)
+	cli, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "CLI", Type: ClientPublic, GrantTypes: []string{GrantTypeDeviceCode}, Scopes: []string{ScopeOpenID}})
+	if err != nil {
+		t.Fatalf("register device client: %v", err)
+	}
+	other, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "Other CLI", Type: ClientPublic, GrantTypes: []string{GrantTypeDeviceCode}})
+	if err != nil {
+		t.Fatalf("register other device client: %v", err)
+	}
+	start := func(t *testing.T) DeviceAuthorizationResponse {
+		t.Helper()
+		resp, err := service.AuthorizeDevice(ctx, DeviceAuthorizationRequest{ClientID: cli.ID, ClientAuthMethod: AuthMethodNone})
+		if err != nil {
+			t.Fatalf("authorize device: %v", err)
+		}
+		return resp
+	}
+	poll := func(svc *Service, clientID, deviceCode string) error {
+		_, err := svc.Exchange(ctx, TokenRequest{GrantType: GrantTypeDeviceCode, DeviceCode: deviceCode, ClientID: clientID, ClientAuthMethod: AuthMethodNone})
+		return err
+	}
+	wantCode := func(t *testing.T, err error, code string) {
+		t.Helper()
+		var oauthErr *Error
+		if !errors.As(err, &oauthErr) || oauthErr.Code != code {
+			t.Fatalf("expected %s, got %v", code, err)
+		}
+	}
+
+	t.Run("grant not allowed", func(t *testing.T) {
+		_, err := service.AuthorizeDevice(ctx, DeviceAuthorizationRequest{ClientID: webApp.ID, ClientAuthMethod: AuthMethodNone})
+		wantCode(t, err, ErrorInvalidClient)
+		public, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "SPA", Type: ClientPublic, RedirectURIs: []string{testRedirectURI}})
+		if err != nil {
+			t.Fatalf("register public client: %v", err)
+		}
+		_, err = service.AuthorizeDevice(ctx, DeviceAuthorizationRequest{ClientID: public.ID, ClientAuthMethod: AuthMethodNone})
+		wantCode(t, err, ErrorUnauthorizedClient)
+	})
+	t.Run("scope not allowed", func(t *testing.T) {
+		_, err := service.AuthorizeDevice(ctx, DeviceAuthorizationRequest{Scope: "openid email", ClientID: cli.ID, ClientAuthMethod: AuthMethodNone})
+		wantCode(t, err, ErrorInvalidScope)
+	})
+	t.Run("missing device code", func(t *testing.T) {
+		wantCode(t, poll(service, cli.ID, ""), ErrorInvalidRequest)
+	})
+	t.Run("unknown device code", func(t *testing.T) {
+		wantCode(t, poll(service, cli.ID, "forged"), ErrorInvalidGrant)
+	})
+	t.Run("other client", func(t *testing.T) {
+		wantCode(t, poll(service, other.ID, start(t).DeviceCode), ErrorInvalidGrant)
+	})
+	t.Run("expired", func(t *testing.T) {
+		resp := start(t)
+		expired := *service
+		expired.now = func() time.Time { return time.Now().Add(deviceCodeLifetime) }
+		wantCode(t, poll(&expired, cli.ID, resp.DeviceCode), ErrorExpiredToken)
+		if _, _, err := expired.PendingDeviceAuthorization(ctx, resp.UserCode); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+			t.Fatalf("expected expired user code to be unusable, got %v", err)
+		}
+	})
+	t.Run("denied", func(t *testing.T) {
+		resp := start(t)
+		if _, err := service.DecideDeviceAuthorization(ctx, user.ID, resp.UserCode, false); err != nil {
+			t.Fatalf("deny: %v", err)
+		}
+		wantCode(t, poll(service, cli.ID, resp.DeviceCode), ErrorAccessDenied)
+		later := *service
+		later.now = func() time.Time { return time.Now().Add(time.Minute) }
+		wantCode(t, poll(&later, cli.ID, resp.DeviceCode), ErrorInvalidGrant)
+	})
+	t.Run("unknown user code", func(t *testing.T) {
+		for _, code := range []string{"", "BCDF", "BCDF-GHJK"} {
+			if _, _, err := service.PendingDeviceAuthorization(ctx, code); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+				t.Fatalf("expected ErrDeviceAuthorizationNotFound for %q, got %v", code, err)
+			}
+		}
+	})
+}
+
+func TestGenerateUserCode(t *testing.T) {
+	t.Parallel()
+
+	for range 100 {
+		code, err := generateUserCode()
+		if err != nil {
+			t.Fatalf("generate user code: %v", err)
+		}
+		if len(code) != userCodeLength || strings.Trim(code, userCodeAlphabet) != "" {
+			t.Fatalf("unexpected user code %q", code)
+		}
+		if normalizeUserCode(strings.ToLower(formatUserCode(code))) != code {
+			t.Fatalf("expected %q to survive formatting and normalization", code)
+		}
+	}
+}
+
+// newRefreshingClient registers a confidential client allowed refresh tokens
+// and returns it with its secret and tokens issued for user.
+func newRefreshingClient(t *testing.T, service *Service, user *auth.User) (Client, string, TokenResponse) {
+	t.Helper()
+	ctx := context.Background()
+	client, secret, err := service.Clients().Register(ctx, ClientRegistration{
+		Name:         "Reports",
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+	})
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	code, err := service.Authorize(ctx, user.ID, validRequest(client.ID))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeAuthorizationCode,
+		Code:             code,
+		RedirectURI:      testRedirectURI,
+		CodeVerifier:     testVerifier,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if resp.RefreshToken == "" {
+		t.Fatalf("expected a refresh token, got %+v", resp)
+	}
+	return client, secret, resp
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, other, otherSecret, user := newTestService(t)
+	client, secret, first := newRefreshingClient(t, service, user)
+	refresh := func(token string) (TokenResponse, error) {
+		return service.Exchange(ctx, TokenRequest{
+			GrantType:        GrantTypeRefreshToken,
+			RefreshToken:     token,
+			ClientID:         client.ID,
+			ClientAuthMethod: AuthMethodClientSecretBasic,
+			ClientSecret:     secret,
+		})
+	}
+
+	second, err := refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.AccessToken == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken || second.Scope != first.Scope || second.IDToken == "" {
+		t.Fatalf("unexpected refresh response %+v", second)
+	}
+	var oauthErr *Error
+	if _, err := refresh(first.RefreshToken); !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidGrant {
+		t.Fatalf("expected a rotated refresh token to be rejected, got %v", err)
+	}
+	if _, err := service.UserInfo(ctx, first.AccessToken); err != nil {
+		t.Fatalf("expected earlier access tokens to survive rotation, got %v", err)
+	}
+
+	_, err = service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeRefreshToken,
+		RefreshToken:     second.RefreshToken,
+		ClientID:         other.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     otherSecret,
+	})
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorUnauthorizedClient {
+		t.Fatalf("expected a client without the grant to be refused, got %v", err)
+	}
+
+	expired := *service
+	expired.now = func() time.Time { return time.Now().Add(refreshTokenLifetime) }
+	_, err = expired.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeRefreshToken,
+		RefreshToken:     second.RefreshToken,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidGrant {
+		t.Fatalf("expected an expired refresh token to be rejected, got %v", err)
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, resourceServer, resourceSecret, user := newTestService(t)
+	client, secret, tokens := newRefreshingClient(t, service, user)
+	introspect := func(s *Service, clientID, clientSecret, token, hint string) IntrospectionResponse {
+		t.Helper()
+		resp, err := s.Introspect(ctx, IntrospectionRequest{
+			Token:            token,
+			TokenTypeHint:    hint,
+			ClientID:         clientID,
+			ClientAuthMethod: AuthMethodClientSecretBasic,
+			ClientSecret:     clientSecret,
+		})
+		if err != nil {
+			t.Fatalf("introspect: %v", err)
+		}
+		return resp
+	}
+
+	access := introspect(service, resourceServer.ID, resourceSecret, tokens.AccessToken, "")
+	if !access.Active || access.ClientID != client.ID || access.Subject != user.ID || access.Scope != "openid email profile" || access.TokenType != "Bearer" || access.Issuer != testIssuer || access.ExpiresAt <= access.IssuedAt {
+		t.Fatalf("unexpected access token introspection %+v", access)
+	}
+	if refresh := introspect(service, client.ID, secret, tokens.RefreshToken, TokenTypeRefreshToken); !refresh.Active || refresh.TokenType != "" {
+		t.Fatalf("unexpected refresh token introspection %+v", refresh)
+	}
+	if refresh := introspect(service, resourceServer.ID, resourceSecret, tokens.RefreshToken, ""); refresh.Active {
+		t.Fatalf("expected another client's refresh token to be inactive, got %+v", refresh)
+	}
+	if unknown := introspect(service, resourceServer.ID, resourceSecret, "unknown", TokenTypeAccessToken); unknown != (IntrospectionResponse{}) {
+		t.Fatalf("expected an unknown token to be inactive, got %+v", unknown)
+	}
+	expired := *service
+	expired.now = func() time.Time { return time.Now().Add(accessTokenLifetime) }
+	if resp := introspect(&expired, resourceServer.ID, resourceSecret, tokens.AccessToken, ""); resp.Active {
+		t.Fatalf("expected an expired token to be inactive, got %+v", resp)
+	}
+
+	public, _, err := service.Clients().Register(ctx, ClientRegistration{Name: "SPA", Type: ClientPublic, RedirectURIs: []string{testRedirectURI}})
+	if err != nil {
+		t.Fatalf("register public client: %v", err)
+	}
+	_, err = service.Introspect(ctx, IntrospectionRequest{Token: tokens.AccessToken, ClientID: public.ID, ClientAuthMethod: AuthMethodNone})
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorUnauthorizedClient {
+		t.Fatalf("expected public clients to be refused, got %v", err)
+	}
+	_, err = service.Introspect(ctx, IntrospectionRequest{Token: tokens.AccessToken, ClientID: resourceServer.ID, ClientAuthMethod: AuthMethodClientSecretBasic, ClientSecret: "wrong"})
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidClient {
+		t.Fatalf("expected bad credentials to fail with invalid_client, got %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, other, otherSecret, user := newTestService(t)
+	client, secret, first := newRefreshingClient(t, service, user)
+	second, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeRefreshToken,
+		RefreshToken:     first.RefreshToken,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	revoke := func(clientID, clientSecret, token, hint string) (TokenRevocation, error) {
+		return service.Revoke(ctx, RevocationRequest{
+			Token:            token,
+			TokenTypeHint:    hint,
+			ClientID:         clientID,
+			ClientAuthMethod: AuthMethodClientSecretBasic,
+			ClientSecret:     clientSecret,
+		})
+	}
+
+	if _, err := revoke(other.ID, otherSecret, second.RefreshToken, ""); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected another client's token to be left alone, got %v", err)
+	}
+	if _, err := service.UserInfo(ctx, second.AccessToken); err != nil {
+		t.Fatalf("expected the token to survive another client's revocation, got %v", err)
+	}
+
+	revocation, err := revoke(client.ID, secret, second.AccessToken, TokenTypeRefreshToken)
+	if err != nil {
+		t.Fatalf("revoke access token: %v", err)
+	}
+	if revocation.TokenType != TokenTypeAccessToken || revocation.UserID != user.ID || revocation.AccessTokens != 0 {
+		t.Fatalf("unexpected access token revocation %+v", revocation)
+	}
+	if _, err := service.UserInfo(ctx, second.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected the revoked access token to be invalid, got %v", err)
+	}
+
+	third, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeRefreshToken,
+		RefreshToken:     second.RefreshToken,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	revocation, err = revoke(client.ID, secret, third.RefreshToken, TokenTypeRefreshToken)
+	if err != nil {
+		t.Fatalf("revoke refresh token: %v", err)
+	}
+	if revocation.TokenType != TokenTypeRefreshToken || revocation.AccessTokens != 2 || revocation.ID == "" {
+		t.Fatalf("expected both remaining access tokens to be revoked, got %+v", revocation)
+	}
+	for _, token := range []string{first.AccessToken, third.AccessToken} {
+		if _, err := service.UserInfo(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected derived access tokens to be revoked, got %v", err)
+		}
+	}
+	if _, err := revoke(client.ID, secret, third.RefreshToken, ""); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected revoking twice to find nothing, got %v", err)
+	}
+
+	log, err := service.Clients().Revocations(ctx, client.ID, 0)
+	if err != nil {
+		t.Fatalf("list revocations: %v", err)
+	}
+	if len(log) != 2 || log[0].TokenType != TokenTypeRefreshToken || log[1].TokenType != TokenTypeAccessToken {
+		t.Fatalf("unexpected revocation log %+v", log)
+	}
+	if log, _ := service.Clients().Revocations(ctx, other.ID, 0); len(log) != 0 {
+		t.Fatalf("expected no revocations for the other client, got %+v", log)
+	}
+
+	var oauthErr *Error
+	if _, err := revoke(client.ID, secret, "", ""); !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidRequest {
+		t.Fatalf("expected a missing token to fail with invalid_request, got %v", err)
+	}
+}
+
+func TestRevokeConsent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, _, _, user := newTestService(t)
+	client, secret, first := newRefreshingClient(t, service, user)
+	second, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeRefreshToken,
+		RefreshToken:     first.RefreshToken,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	pending, err := service.Authorize(ctx, user.ID, validRequest(client.ID))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	consents, err := service.Consents(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("list consents: %v", err)
+	}
+	if len(consents) != 1 || consents[0].ClientID != client.ID || consents[0].ClientName != "Reports" {
+		t.Fatalf("unexpected consents %+v", consents)
+	}
+
+	revocations, err := service.RevokeConsent(ctx, user.ID, client.ID)
+	if err != nil {
+		t.Fatalf("revoke consent: %v", err)
+	}
+	if len(revocations) != 1 || revocations[0].TokenType != TokenTypeRefreshToken || revocations[0].AccessTokens != 2 || revocations[0].UserID != user.ID {
+		t.Fatalf("expected the refresh token and both derived access tokens to be revoked, got %+v", revocations)
+	}
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		if _, err := service.UserInfo(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected access tokens to be revoked, got %v", err)
+		}
+	}
+	var oauthErr *Error
+	if _, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeRefreshToken,
+		RefreshToken:     second.RefreshToken,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	}); !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidGrant {
+		t.Fatalf("expected the refresh token to be revoked, got %v", err)
+	}
+	if _, err := service.Exchange(ctx, TokenRequest{
+		GrantType:        GrantTypeAuthorizationCode,
+		Code:             pending,
+		RedirectURI:      testRedirectURI,
+		CodeVerifier:     testVerifier,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	}); !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidGrant {
+		t.Fatalf("expected the pending code to be revoked, got %v", err)
+	}
+
+	if consents, _ := service.Consents(ctx, user.ID); len(consents) != 0 {
+		t.Fatalf("expected no consents left, got %+v", consents)
+	}
+	if _, err := service.RevokeConsent(ctx, user.ID, client.ID); !errors.Is(err, ErrConsentNotFound) {
+		t.Fatalf("expected revoking twice to find no consent, got %v", err)
+	}
+	if log, _ := service.Clients().Revocations(ctx, client.ID, 0); len(log) != 1 {
+		t.Fatalf("expected the revocation to be recorded, got %+v", log)
+	}
+}
+
+// newServiceClient registers a confidential client allowed the
+// client_credentials grant for scopes and audiences, returning it with its
+// secret.
+func newServiceClient(t *testing.T, service *Service, audiences []string, scopes ...string) (Client, string) {
+	t.Helper()
+	client, secret, err := service.Clients().Register(context.Background(), ClientRegistration{
+		Name:       "Billing",
+		GrantTypes: []string{GrantTypeClientCredentials},
+		Scopes:     scopes,
+		Audiences:  audiences,
+	})
+	if err != nil {
+		t.Fatalf("register service client: %v", err)
+	}
+	return client, secret
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, resourceServer, resourceSecret, _ := newTestService(t)
+	client, secret := newServiceClient(t, service, []string{resourceServer.ID}, "orders:read", "orders:write", ScopeOpenID)
+	exchange := func(scope, audience string) (TokenResponse, error) {
+		return service.Exchange(ctx, TokenRequest{
+			GrantType:        GrantTypeClientCredentials,
+			Scope:            scope,
+			Audience:         audience,
+			ClientID:         client.ID,
+			ClientAuthMethod: AuthMethodClientSecretBasic,
+			ClientSecret:     secret,
+		})
+	}
+
+	resp, err := exchange("orders:read", resourceServer.ID)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if resp.TokenType != "Bearer" || resp.Scope != "orders:read" || resp.RefreshToken != "" || resp.IDToken != "" ||
+		resp.ExpiresIn != int(clientCredentialsTokenLifetime/time.Second) {
+		t.Fatalf("unexpected token response %+v", resp)
+	}
+
+	keys, err := service.JWKS(ctx)
+	if err != nil {
+		t.Fatalf("jwks: %v", err)
+	}
+	token, err := jwt.ParseSigned(resp.AccessToken, []jose.SignatureAlgorithm{jose.ES256})
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if typ := token.Headers[0].ExtraHeaders[jose.HeaderType]; typ != "at+jwt" {
+		t.Fatalf("expected typ at+jwt, got %v", typ)
+	}
+	var claims jwt.Claims
+	var extra struct {
+		ClientID string `json:"client_id"`
+		Scope    string `json:"scope"`
+	}
+	if err := token.Claims(keys.Keys[0].Key, &claims, &extra); err != nil {
+		t.Fatalf("verify access token: %v", err)
+	}
+	if claims.Issuer != testIssuer || claims.Subject != client.ID || !claims.Audience.Contains(resourceServer.ID) || claims.ID == "" ||
+		extra.ClientID != client.ID || extra.Scope != "orders:read" {
+		t.Fatalf("unexpected access token claims %+v %+v", claims, extra)
+	}
+
+	introspection, err := service.Introspect(ctx, IntrospectionRequest{
+		Token:            resp.AccessToken,
+		ClientID:         resourceServer.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     resourceSecret,
+	})
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	if !introspection.Active || introspection.Subject != client.ID || introspection.ClientID != client.ID || introspection.Scope != "orders:read" {
+		t.Fatalf("unexpected introspection %+v", introspection)
+	}
+	if _, err := service.UserInfo(ctx, resp.AccessToken); !errors.Is(err, ErrInsufficientScope) {
+		t.Fatalf("expected userinfo to refuse a client token, got %v", err)
+	}
+
+	// Without a scope the client gets every allowed scope that needs no user.
+	all, err := exchange("", "")
+	if err != nil {
+		t.Fatalf("exchange without scope: %v", err)
+	}
+	if all.Scope != "orders:read orders:write" {
+		t.Fatalf("expected the client's api scopes, got %q", all.Scope)
+	}
+
+	revocation, err := service.Revoke(ctx, RevocationRequest{
+		Token:            all.AccessToken,
+		ClientID:         client.ID,
+		ClientAuthMethod: AuthMethodClientSecretBasic,
+		ClientSecret:     secret,
+	})
+	if err != nil || revocation.UserID != "" {
+		t.Fatalf("expected the client token to be revoked, got %+v (%v)", revocation, err)
+	}
+
+	log, err := service.Clients().Issuances(ctx, client.ID, 0)
+	if err != nil {
+		t.Fatalf("list issuances: %v", err)
+	}
+	if len(log) != 2 || log[0].Audience != testIssuer || log[1].Audience != resourceServer.ID || log[1].ID != claims.ID ||
+		!slices.Equal(log[1].Scopes, []string{"orders:read"}) || !log[1].ExpiresAt.After(log[1].IssuedAt) {
+		t.Fatalf("unexpected issuance log %+v", log)
+	}
+}
+
+func TestClientCredentialsRejects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, client, secret, _ := newTestService(t)
+	serviceClient, serviceSecret := newServiceClient(t, service, []string{"https://api.example.test"}, "orders:read", ScopeEmail)
+
+	tests := map[string]struct {
+		clientID, secret string
+		scope, audience  string
+		want             string
+	}{
+		"grant not allowed":    {clientID: client.ID, secret: secret, want: ErrorUnauthorizedClient},
+		"scope not allowed":    {clientID: serviceClient.ID, secret: serviceSecret, scope: "orders:write", want: ErrorInvalidScope},
+		"user scope":           {clientID: serviceClient.ID, secret: serviceSecret, scope: "orders:read email", want: ErrorInvalidScope},
+		"unknown audience":     {clientID: serviceClient.ID, secret: serviceSecret, audience: "https://api.example.test", want: ErrorInvalidTarget},
+		"audience not allowed": {clientID: serviceClient.ID, secret: serviceSecret, audience: client.ID, want: ErrorInvalidTarget},
+		"wrong secret":         {clientID: serviceClient.ID, secret: "wrong", want: ErrorInvalidClient},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := service.Exchange(ctx, TokenRequest{
+				GrantType:        GrantTypeClientCredentials,
+				Scope:            tt.scope,
+				Audience:         tt.audience,
+				ClientID:         tt.clientID,
+				ClientAuthMethod: AuthMethodClientSecretBasic,
+				ClientSecret:     tt.secret,
+			})
+			var oauthErr *Error
+			if !errors.As(err, &oauthErr) || oauthErr.Code != tt.want {
+				t.Fatalf("expected %s, got %v", tt.want, err)
+			}
+		})
+	}
+	if log, _ := service.Clients().Issuances(ctx, serviceClient.ID, 0); len(log) != 0 {
+		t.Fatalf("expected rejected requests to issue nothing, got %+v", log)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	t.Parallel()
+
+	service, _, _, _ := newTestService(t)
+	doc := service.Discovery()
+	if doc.Issuer != testIssuer || doc.TokenEndpoint != testIssuer+TokenPath || doc.JWKSURI != testIssuer+JWKSPath || doc.DeviceAuthorizationEndpoint != testIssuer+DeviceAuthorizationPath {
+		t.Fatalf("unexpected discovery %+v", doc)
+	}
+	if doc.IntrospectionEndpoint != testIssuer+IntrospectionPath || doc.RevocationEndpoint != testIssuer+RevocationPath {
+		t.Fatalf("unexpected token management endpoints %+v", doc)
+	}
+	if slices.Contains(doc.IntrospectionEndpointAuthMethodsSupported, AuthMethodNone) || !slices.Contains(doc.RevocationEndpointAuthMethodsSupported, AuthMethodNone) {
+		t.Fatalf("expected only revocation to admit public clients, got %v and %v", doc.IntrospectionEndpointAuthMethodsSupported, doc.RevocationEndpointAuthMethodsSupported)
+	}
+	if len(doc.IDTokenSigningAlgValuesSupported) != 1 || doc.IDTokenSigningAlgValuesSupported[0] != string(jose.ES256) {
+		t.Fatalf("unexpected signing algorithms %v", doc.IDTokenSigningAlgValuesSupported)
+	}
+}
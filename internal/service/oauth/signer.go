@@ -0,0 +1,21 @@
+package oauth
+
+import (
+	"context"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Signer signs issued tokens and publishes the public keys that verify them.
+// signing.Manager implements it with rotating keys.
+type Signer interface {
+	// Algorithm is the JWS algorithm new tokens are signed with.
+	Algorithm() jose.SignatureAlgorithm
+	// Sign returns payload as a compact JWS carrying the signing key's ID.
+	Sign(ctx context.Context, payload []byte) (string, error)
+	// SignAccessToken is Sign for JWT access tokens, which carry the typ
+	// at+jwt (RFC 9068 §2.1).
+	SignAccessToken(ctx context.Context, payload []byte) (string, error)
+	// KeySet returns the public keys relying parties should trust.
+	KeySet(ctx context.Context) (jose.JSONWebKeySet, error)
+}
@@ -0,0 +1,163 @@
+package oauth
+
+import (
+	"context"
+	"time"
+)
+
+// AuthorizationCode is an issued code as persisted, keyed by its hash.
+type AuthorizationCode struct {
+	CodeHash      []byte
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+// AccessToken is an issued bearer token as persisted, keyed by its hash.
+type AccessToken struct {
+	TokenHash []byte
+	ClientID  string
+	// UserID is empty for tokens the client_credentials grant issues to the
+	// client itself.
+	UserID string
+	Scopes []string
+	// RefreshTokenID names the refresh token the access token was issued
+	// with, if any; revoking that refresh token revokes the access token.
+	RefreshTokenID string
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// RefreshToken is an issued refresh token as persisted. Rotation replaces its
+// hash but keeps its ID, so every access token issued along the chain stays
+// tied to it.
+type RefreshToken struct {
+	ID        string
+	TokenHash []byte
+	ClientID  string
+	UserID    string
+	Scopes    []string
+	ExpiresAt time.Time
+	RotatedAt time.Time
+	CreatedAt time.Time
+}
+
+// TokenRevocation records a revoked token for audit.
+type TokenRevocation struct {
+	ID string
+	// TokenType is TokenTypeAccessToken or TokenTypeRefreshToken.
+	TokenType string
+	TokenHash []byte
+	ClientID  string
+	UserID    string
+	// AccessTokens counts the access tokens revoked along with a refresh
+	// token.
+	AccessTokens int
+	RevokedAt    time.Time
+}
+
+// Consent is a user's standing approval of scopes for a client.
+type Consent struct {
+	ClientID   string
+	ClientName string
+	Scopes     []string
+}
+
+// ClientTokenIssuance records, for audit, an access token the
+// client_credentials grant issued to a client on its own behalf.
+type ClientTokenIssuance struct {
+	// ID is the token's jti claim.
+	ID        string
+	TokenHash []byte
+	ClientID  string
+	Audience  string
+	Scopes    []string
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+}
+
+// Store defines persistence for clients, consents and issued credentials.
+type Store interface {
+	// CreateClient stores client together with its secrets.
+	CreateClient(ctx context.Context, client Client) error
+	// UpdateClient replaces the client's metadata, leaving its secrets, or
+	// fails with ErrClientNotFound.
+	UpdateClient(ctx context.Context, client Client) error
+	// DeleteClient removes the client and everything issued to it, or fails
+	// with ErrClientNotFound.
+	DeleteClient(ctx context.Context, id string) error
+	// FindClient returns the client registered under id with its secrets, or
+	// ErrClientNotFound.
+	FindClient(ctx context.Context, id string) (Client, error)
+	// ListClients returns every client, oldest first, without secrets.
+	ListClients(ctx context.Context) ([]Client, error)
+	// RotateClientSecret adds secret to the client and expires its other
+	// secrets at retireAt unless they expire sooner.
+	RotateClientSecret(ctx context.Context, clientID string, secret ClientSecret, retireAt time.Time) error
+	// UseClientAssertion records the jti of a client assertion valid until
+	// expiresAt, failing with ErrAssertionReplayed when it was seen before.
+	UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error
+	// FindConsent returns the scopes the user approved for the client, or
+	// ErrConsentNotFound.
+	FindConsent(ctx context.Context, userID, clientID string) ([]string, error)
+	// SaveConsent adds scopes to those the user approved for the client.
+	SaveConsent(ctx context.Context, userID, clientID string, scopes []string) error
+	// ListConsents returns the user's consents ordered by client name.
+	ListConsents(ctx context.Context, userID string) ([]Consent, error)
+	// RevokeConsent deletes the user's consent for the client together with
+	// the codes, refresh tokens and access tokens issued to the client for the
+	// user, recording a revocation at revokedAt for each token. It fails with
+	// ErrConsentNotFound when there is no consent.
+	RevokeConsent(ctx context.Context, userID, clientID string, revokedAt time.Time) ([]TokenRevocation, error)
+	SaveAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	// ConsumeAuthorizationCode removes and returns the code with codeHash, so
+	// each code is redeemed at most once, or fails with ErrCodeNotFound.
+	ConsumeAuthorizationCode(ctx context.Context, codeHash []byte) (AuthorizationCode, error)
+	SaveAccessToken(ctx context.Context, token AccessToken) error
+	// FindAccessToken returns the token with tokenHash, or ErrTokenNotFound.
+	FindAccessToken(ctx context.Context, tokenHash []byte) (AccessToken, error)
+	// SaveClientToken stores an access token issued to the client itself
+	// together with the audit record of its issuance.
+	SaveClientToken(ctx context.Context, token AccessToken, issuance ClientTokenIssuance) error
+	// ListClientTokenIssuances returns up to limit issuances of tokens to the
+	// client, newest first.
+	ListClientTokenIssuances(ctx context.Context, clientID string, limit int) ([]ClientTokenIssuance, error)
+	SaveRefreshToken(ctx context.Context, token RefreshToken) error
+	// FindRefreshToken returns the refresh token with tokenHash, or
+	// ErrTokenNotFound.
+	FindRefreshToken(ctx context.Context, tokenHash []byte) (RefreshToken, error)
+	// RotateRefreshToken replaces the hash of the refresh token with
+	// tokenHash by newTokenHash, failing with ErrTokenNotFound when it was
+	// already rotated or revoked.
+	RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash []byte) error
+	// RevokeToken deletes the token revocation names, with the access tokens
+	// derived from it when it is a refresh token, and records the revocation.
+	// It returns the recorded revocation, or fails with ErrTokenNotFound when
+	// the token is already gone.
+	RevokeToken(ctx context.Context, revocation TokenRevocation) (TokenRevocation, error)
+	// ListTokenRevocations returns up to limit revocations of the client's
+	// tokens, newest first.
+	ListTokenRevocations(ctx context.Context, clientID string, limit int) ([]TokenRevocation, error)
+	SaveDeviceAuthorization(ctx context.Context, device DeviceAuthorization) error
+	// FindDeviceAuthorization returns the device authorization with
+	// userCodeHash, or ErrDeviceAuthorizationNotFound.
+	FindDeviceAuthorization(ctx context.Context, userCodeHash []byte) (DeviceAuthorization, error)
+	// DecideDeviceAuthorization records the user's decision on a pending
+	// device authorization, or fails with ErrDeviceAuthorizationNotFound when
+	// it was already decided.
+	DecideDeviceAuthorization(ctx context.Context, userCodeHash []byte, userID string, status DeviceStatus) error
+	// PollDeviceAuthorization records a poll at polledAt and returns the
+	// device authorization with deviceCodeHash as it was before, or
+	// ErrDeviceAuthorizationNotFound.
+	PollDeviceAuthorization(ctx context.Context, deviceCodeHash []byte, polledAt time.Time) (DeviceAuthorization, error)
+	// SlowDownDeviceAuthorization sets the minimum time between polls.
+	SlowDownDeviceAuthorization(ctx context.Context, deviceCodeHash []byte, interval time.Duration) error
+	// ConsumeDeviceAuthorization removes and returns the device authorization
+	// with deviceCodeHash, or fails with ErrDeviceAuthorizationNotFound.
+	ConsumeDeviceAuthorization(ctx context.Context, deviceCodeHash []byte) (DeviceAuthorization, error)
+}
@@ -0,0 +1,530 @@
+package oauth
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory implementation of Store for development and tests.
+type MemoryStore struct {
+	mu         sync.Mutex
+	clients    map[string]Client
+	consents   map[consentKey][]string
+	codes      map[string]AuthorizationCode
+	tokens     map[string]AccessToken
+	assertions map[assertionKey]time.Time
+	devices    map[string]DeviceAuthorization
+	refreshes  map[string]RefreshToken
+	// revocations is the audit log, oldest first.
+	revocations []TokenRevocation
+	// issuances is the audit log of client tokens, oldest first.
+	issuances []ClientTokenIssuance
+}
+
+type consentKey struct {
+	userID   string
+	clientID string
+}
+
+type assertionKey struct {
+	clientID string
+	jti      string
+}
+
+// NewMemoryStore builds an empty MemoryStore instance.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		clients:    make(map[string]Client),
+		consents:   make(map[consentKey][]string),
+		codes:      make(map[string]AuthorizationCode),
+		tokens:     make(map[string]AccessToken),
+		assertions: make(map[assertionKey]time.Time),
+		devices:    make(map[string]DeviceAuthorization),
+		refreshes:  make(map[string]RefreshToken),
+	}
+}
+
+// CreateClient stores client, replacing any client with the same ID.
+func (s *MemoryStore) CreateClient(_ context.Context, client Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[client.ID] = cloneClient(client)
+	return nil
+}
+
+// UpdateClient replaces the client's metadata, keeping its secrets.
+func (s *MemoryStore) UpdateClient(_ context.Context, client Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.clients[client.ID]
+	if !ok {
+		return ErrClientNotFound
+	}
+	client = cloneClient(client)
+	client.Secrets = existing.Secrets
+	client.CreatedAt = existing.CreatedAt
+	s.clients[client.ID] = client
+	return nil
+}
+
+// DeleteClient removes the client with its consents and issued credentials.
+func (s *MemoryStore) DeleteClient(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[id]; !ok {
+		return ErrClientNotFound
+	}
+	delete(s.clients, id)
+	for key := range s.consents {
+		if key.clientID == id {
+			delete(s.consents, key)
+		}
+	}
+	for hash, code := range s.codes {
+		if code.ClientID == id {
+			delete(s.codes, hash)
+		}
+	}
+	for hash, token := range s.tokens {
+		if token.ClientID == id {
+			delete(s.tokens, hash)
+		}
+	}
+	for key := range s.assertions {
+		if key.clientID == id {
+			delete(s.assertions, key)
+		}
+	}
+	for hash, device := range s.devices {
+		if device.ClientID == id {
+			delete(s.devices, hash)
+		}
+	}
+	for hash, token := range s.refreshes {
+		if token.ClientID == id {
+			delete(s.refreshes, hash)
+		}
+	}
+	return nil
+}
+
+// FindClient returns a copy of the registered client.
+func (s *MemoryStore) FindClient(_ context.Context, id string) (Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return Client{}, ErrClientNotFound
+	}
+	return cloneClient(client), nil
+}
+
+// ListClients returns copies of every client, oldest first, without secrets.
+func (s *MemoryStore) ListClients(context.Context) ([]Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients := make([]Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		client = cloneClient(client)
+		client.Secrets = nil
+		clients = append(clients, client)
+	}
+	slices.SortFunc(clients, func(a, b Client) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return clients, nil
+}
+
+// RotateClientSecret adds secret and expires the client's other secrets at
+// retireAt unless they expire sooner.
+func (s *MemoryStore) RotateClientSecret(_ context.Context, clientID string, secret ClientSecret, retireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[clientID]
+	if !ok {
+		return ErrClientNotFound
+	}
+	secrets := make([]ClientSecret, 0, len(client.Secrets)+1)
+	for _, existing := range client.Secrets {
+		if existing.ExpiresAt.IsZero() || existing.ExpiresAt.After(retireAt) {
+			existing.ExpiresAt = retireAt
+		}
+		secrets = append(secrets, existing)
+	}
+	client.Secrets = append(secrets, secret)
+	s.clients[clientID] = client
+	return nil
+}
+
+// UseClientAssertion records jti for the client unless it was seen before.
+func (s *MemoryStore) UseClientAssertion(_ context.Context, clientID, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := assertionKey{clientID: clientID, jti: jti}
+	if _, ok := s.assertions[key]; ok {
+		return ErrAssertionReplayed
+	}
+	s.assertions[key] = expiresAt
+	return nil
+}
+
+// cloneClient copies client so callers cannot mutate stored slices.
+func cloneClient(client Client) Client {
+	client.RedirectURIs = slices.Clone(client.RedirectURIs)
+	client.GrantTypes = slices.Clone(client.GrantTypes)
+	client.Scopes = slices.Clone(client.Scopes)
+	client.Audiences = slices.Clone(client.Audiences)
+	client.Secrets = slices.Clone(client.Secrets)
+	return client
+}
+
+// FindConsent returns the scopes the user approved for the client.
+func (s *MemoryStore) FindConsent(_ context.Context, userID, clientID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scopes, ok := s.consents[consentKey{userID: userID, clientID: clientID}]
+	if !ok {
+		return nil, ErrConsentNotFound
+	}
+	return slices.Clone(scopes), nil
+}
+
+// SaveConsent merges scopes into the user's consent for the client.
+func (s *MemoryStore) SaveConsent(_ context.Context, userID, clientID string, scopes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := consentKey{userID: userID, clientID: clientID}
+	merged := slices.Clone(s.consents[key])
+	for _, scope := range scopes {
+		if !slices.Contains(merged, scope) {
+			merged = append(merged, scope)
+		}
+	}
+	slices.Sort(merged)
+	s.consents[key] = merged
+	return nil
+}
+
+// ListConsents returns the user's consents ordered by client name.
+func (s *MemoryStore) ListConsents(_ context.Context, userID string) ([]Consent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var consents []Consent
+	for key, scopes := range s.consents {
+		client, ok := s.clients[key.clientID]
+		if key.userID != userID || !ok {
+			continue
+		}
+		consents = append(consents, Consent{ClientID: client.ID, ClientName: client.Name, Scopes: slices.Clone(scopes)})
+	}
+	slices.SortFunc(consents, func(a, b Consent) int {
+		return cmp.Or(cmp.Compare(a.ClientName, b.ClientName), cmp.Compare(a.ClientID, b.ClientID))
+	})
+	return consents, nil
+}
+
+// RevokeConsent deletes the consent and everything issued under it, recording
+// the revoked tokens.
+func (s *MemoryStore) RevokeConsent(_ context.Context, userID, clientID string, revokedAt time.Time) ([]TokenRevocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := consentKey{userID: userID, clientID: clientID}
+	if _, ok := s.consents[key]; !ok {
+		return nil, ErrConsentNotFound
+	}
+	delete(s.consents, key)
+	for hash, code := range s.codes {
+		if code.UserID == userID && code.ClientID == clientID {
+			delete(s.codes, hash)
+		}
+	}
+
+	var revocations []TokenRevocation
+	record := func(tokenType string, hash []byte, accessTokens int) {
+		revocation := TokenRevocation{
+			ID:           uuid.NewString(),
+			TokenType:    tokenType,
+			TokenHash:    hash,
+			ClientID:     clientID,
+			UserID:       userID,
+			AccessTokens: accessTokens,
+			RevokedAt:    revokedAt,
+		}
+		s.revocations = append(s.revocations, revocation)
+		revocations = append(revocations, revocation)
+	}
+	derived := make(map[string]int)
+	for hash, token := range s.tokens {
+		if token.UserID != userID || token.ClientID != clientID {
+			continue
+		}
+		delete(s.tokens, hash)
+		if token.RefreshTokenID != "" {
+			derived[token.RefreshTokenID]++
+		} else {
+			record(TokenTypeAccessToken, []byte(hash), 0)
+		}
+	}
+	for hash, refresh := range s.refreshes {
+		if refresh.UserID == userID && refresh.ClientID == clientID {
+			delete(s.refreshes, hash)
+			record(TokenTypeRefreshToken, []byte(hash), derived[refresh.ID])
+		}
+	}
+	return revocations, nil
+}
+
+// SaveAuthorizationCode stores code until it is consumed.
+func (s *MemoryStore) SaveAuthorizationCode(_ context.Context, code AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[string(code.CodeHash)] = code
+	return nil
+}
+
+// ConsumeAuthorizationCode removes and returns the code with codeHash.
+func (s *MemoryStore) ConsumeAuthorizationCode(_ context.Context, codeHash []byte) (AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.codes[string(codeHash)]
+	if !ok {
+		return AuthorizationCode{}, ErrCodeNotFound
+	}
+	delete(s.codes, string(codeHash))
+	return code, nil
+}
+
+// SaveAccessToken stores token.
+func (s *MemoryStore) SaveAccessToken(_ context.Context, token AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[string(token.TokenHash)] = token
+	return nil
+}
+
+// FindAccessToken returns the token with tokenHash.
+func (s *MemoryStore) FindAccessToken(_ context.Context, tokenHash []byte) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[string(tokenHash)]
+	if !ok {
+		return AccessToken{}, ErrTokenNotFound
+	}
+	return token, nil
+}
+
+// SaveClientToken stores token and appends issuance to the audit log.
+func (s *MemoryStore) SaveClientToken(_ context.Context, token AccessToken, issuance ClientTokenIssuance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[string(token.TokenHash)] = token
+	issuance.Scopes = slices.Clone(issuance.Scopes)
+	s.issuances = append(s.issuances, issuance)
+	return nil
+}
+
+// ListClientTokenIssuances returns up to limit issuances of tokens to the
+// client, newest first.
+func (s *MemoryStore) ListClientTokenIssuances(_ context.Context, clientID string, limit int) ([]ClientTokenIssuance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var issuances []ClientTokenIssuance
+	for i := len(s.issuances) - 1; i >= 0 && len(issuances) < limit; i-- {
+		if s.issuances[i].ClientID == clientID {
+			issuances = append(issuances, s.issuances[i])
+		}
+	}
+	return issuances, nil
+}
+
+// SaveRefreshToken stores token.
+func (s *MemoryStore) SaveRefreshToken(_ context.Context, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.Scopes = slices.Clone(token.Scopes)
+	s.refreshes[string(token.TokenHash)] = token
+	return nil
+}
+
+// FindRefreshToken returns the refresh token with tokenHash.
+func (s *MemoryStore) FindRefreshToken(_ context.Context, tokenHash []byte) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshes[string(tokenHash)]
+	if !ok {
+		return RefreshToken{}, ErrTokenNotFound
+	}
+	token.Scopes = slices.Clone(token.Scopes)
+	return token, nil
+}
+
+// RotateRefreshToken rekeys the refresh token under newTokenHash.
+func (s *MemoryStore) RotateRefreshToken(_ context.Context, tokenHash, newTokenHash []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshes[string(tokenHash)]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	delete(s.refreshes, string(tokenHash))
+	token.TokenHash = newTokenHash
+	token.RotatedAt = time.Now().UTC()
+	s.refreshes[string(newTokenHash)] = token
+	return nil
+}
+
+// RevokeToken deletes the named token, and the access tokens derived from a
+// refresh token, and appends the revocation to the audit log.
+func (s *MemoryStore) RevokeToken(_ context.Context, revocation TokenRevocation) (TokenRevocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := string(revocation.TokenHash)
+	switch revocation.TokenType {
+	case TokenTypeAccessToken:
+		if _, ok := s.tokens[hash]; !ok {
+			return TokenRevocation{}, ErrTokenNotFound
+		}
+		delete(s.tokens, hash)
+	case TokenTypeRefreshToken:
+		refresh, ok := s.refreshes[hash]
+		if !ok {
+			return TokenRevocation{}, ErrTokenNotFound
+		}
+		delete(s.refreshes, hash)
+		for tokenHash, token := range s.tokens {
+			if token.RefreshTokenID == refresh.ID {
+				delete(s.tokens, tokenHash)
+				revocation.AccessTokens++
+			}
+		}
+	default:
+		return TokenRevocation{}, ErrTokenNotFound
+	}
+	revocation.ID = uuid.NewString()
+	s.revocations = append(s.revocations, revocation)
+	return revocation, nil
+}
+
+// ListTokenRevocations returns up to limit revocations of the client's
+// tokens, newest first.
+func (s *MemoryStore) ListTokenRevocations(_ context.Context, clientID string, limit int) ([]TokenRevocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revocations []TokenRevocation
+	for i := len(s.revocations) - 1; i >= 0 && len(revocations) < limit; i-- {
+		if s.revocations[i].ClientID == clientID {
+			revocations = append(revocations, s.revocations[i])
+		}
+	}
+	return revocations, nil
+}
+
+// SaveDeviceAuthorization stores device until it is consumed.
+func (s *MemoryStore) SaveDeviceAuthorization(_ context.Context, device DeviceAuthorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device.Scopes = slices.Clone(device.Scopes)
+	s.devices[string(device.DeviceCodeHash)] = device
+	return nil
+}
+
+// FindDeviceAuthorization returns the device authorization with userCodeHash.
+func (s *MemoryStore) FindDeviceAuthorization(_ context.Context, userCodeHash []byte) (DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, device := range s.devices {
+		if string(device.UserCodeHash) == string(userCodeHash) {
+			device.Scopes = slices.Clone(device.Scopes)
+			return device, nil
+		}
+	}
+	return DeviceAuthorization{}, ErrDeviceAuthorizationNotFound
+}
+
+// DecideDeviceAuthorization records the decision on a pending device
+// authorization.
+func (s *MemoryStore) DecideDeviceAuthorization(_ context.Context, userCodeHash []byte, userID string, status DeviceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, device := range s.devices {
+		if string(device.UserCodeHash) == string(userCodeHash) && device.Status == DevicePending {
+			device.Status = status
+			device.UserID = userID
+			s.devices[hash] = device
+			return nil
+		}
+	}
+	return ErrDeviceAuthorizationNotFound
+}
+
+// PollDeviceAuthorization records a poll and returns the previous state.
+func (s *MemoryStore) PollDeviceAuthorization(_ context.Context, deviceCodeHash []byte, polledAt time.Time) (DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[string(deviceCodeHash)]
+	if !ok {
+		return DeviceAuthorization{}, ErrDeviceAuthorizationNotFound
+	}
+	polled := device
+	polled.LastPolledAt = polledAt
+	s.devices[string(deviceCodeHash)] = polled
+	device.Scopes = slices.Clone(device.Scopes)
+	return device, nil
+}
+
+// SlowDownDeviceAuthorization sets the minimum time between polls.
+func (s *MemoryStore) SlowDownDeviceAuthorization(_ context.Context, deviceCodeHash []byte, interval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[string(deviceCodeHash)]
+	if !ok {
+		return ErrDeviceAuthorizationNotFound
+	}
+	device.Interval = interval
+	s.devices[string(deviceCodeHash)] = device
+	return nil
+}
+
+// ConsumeDeviceAuthorization removes and returns the device authorization
+// with deviceCodeHash.
+func (s *MemoryStore) ConsumeDeviceAuthorization(_ context.Context, deviceCodeHash []byte) (DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[string(deviceCodeHash)]
+	if !ok {
+		return DeviceAuthorization{}, ErrDeviceAuthorizationNotFound
+	}
+	delete(s.devices, string(deviceCodeHash))
+	return device, nil
+}
@@ -0,0 +1,826 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rjnemo/auth/internal/driver/db"
+)
+
+// SQLStore persists clients and issued credentials in PostgreSQL via
+// generated sqlc queries.
+type SQLStore struct {
+	pool    *pgxpool.Pool
+	queries *db.Queries
+}
+
+// NewSQLStore builds a SQL-backed authorization server store.
+func NewSQLStore(pool *pgxpool.Pool) *SQLStore {
+	return &SQLStore{
+		pool:    pool,
+		queries: db.New(pool),
+	}
+}
+
+// CreateClient inserts client and its secrets in one transaction.
+func (s *SQLStore) CreateClient(ctx context.Context, client Client) (err error) {
+	jwks, err := marshalJWKS(client.JWKS)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	qtx := s.queries.WithTx(tx)
+	if err = qtx.CreateOAuthClient(ctx, db.CreateOAuthClientParams{
+		ID:                      client.ID,
+		Name:                    client.Name,
+		ClientType:              string(client.Type),
+		TokenEndpointAuthMethod: client.AuthMethod,
+		RedirectUris:            nonNil(client.RedirectURIs),
+		GrantTypes:              nonNil(client.GrantTypes),
+		Scopes:                  nonNil(client.Scopes),
+		Jwks:                    jwks,
+		Audiences:               nonNil(client.Audiences),
+	}); err != nil {
+		return fmt.Errorf("insert oauth client: %w", err)
+	}
+	for _, secret := range client.Secrets {
+		id, parseErr := uuid.Parse(secret.ID)
+		if parseErr != nil {
+			err = fmt.Errorf("parse secret id: %w", parseErr)
+			return err
+		}
+		if err = qtx.CreateOAuthClientSecret(ctx, db.CreateOAuthClientSecretParams{
+			ID:         id,
+			ClientID:   client.ID,
+			SecretHash: secret.Hash,
+			ExpiresAt:  optionalTimestamptz(secret.ExpiresAt),
+		}); err != nil {
+			return fmt.Errorf("insert oauth client secret: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateClient replaces the client's metadata.
+func (s *SQLStore) UpdateClient(ctx context.Context, client Client) error {
+	jwks, err := marshalJWKS(client.JWKS)
+	if err != nil {
+		return err
+	}
+	rows, err := s.queries.UpdateOAuthClient(ctx, db.UpdateOAuthClientParams{
+		ID:                      client.ID,
+		Name:                    client.Name,
+		TokenEndpointAuthMethod: client.AuthMethod,
+		RedirectUris:            nonNil(client.RedirectURIs),
+		GrantTypes:              nonNil(client.GrantTypes),
+		Scopes:                  nonNil(client.Scopes),
+		Jwks:                    jwks,
+		Audiences:               nonNil(client.Audiences),
+	})
+	if err != nil {
+		return fmt.Errorf("update oauth client: %w", err)
+	}
+	if rows == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// DeleteClient deletes the client; consents, codes, tokens and secrets
+// cascade with it.
+func (s *SQLStore) DeleteClient(ctx context.Context, id string) error {
+	rows, err := s.queries.DeleteOAuthClient(ctx, id)
+	if err != nil {
+		return fmt.Errorf("delete oauth client: %w", err)
+	}
+	if rows == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// FindClient returns the client registered under id with its secrets.
+func (s *SQLStore) FindClient(ctx context.Context, id string) (Client, error) {
+	row, err := s.queries.GetOAuthClient(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Client{}, ErrClientNotFound
+		}
+		return Client{}, fmt.Errorf("lookup oauth client: %w", err)
+	}
+	client, err := clientFromRow(row)
+	if err != nil {
+		return Client{}, err
+	}
+
+	secrets, err := s.queries.ListOAuthClientSecrets(ctx, id)
+	if err != nil {
+		return Client{}, fmt.Errorf("list oauth client secrets: %w", err)
+	}
+	for _, secret := range secrets {
+		client.Secrets = append(client.Secrets, ClientSecret{
+			ID:        secret.ID.String(),
+			Hash:      secret.SecretHash,
+			ExpiresAt: timestamptzValue(secret.ExpiresAt),
+			CreatedAt: timestamptzValue(secret.CreatedAt),
+		})
+	}
+	return client, nil
+}
+
+// ListClients returns every client, oldest first, without secrets.
+func (s *SQLStore) ListClients(ctx context.Context) ([]Client, error) {
+	rows, err := s.queries.ListOAuthClients(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list oauth clients: %w", err)
+	}
+	clients := make([]Client, 0, len(rows))
+	for _, row := range rows {
+		client, err := clientFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// RotateClientSecret inserts secret and expires the client's other secrets in
+// one statement, so a failed rotation never cuts the overlap short. Secrets
+// that already expired are purged.
+func (s *SQLStore) RotateClientSecret(ctx context.Context, clientID string, secret ClientSecret, retireAt time.Time) error {
+	id, err := uuid.Parse(secret.ID)
+	if err != nil {
+		return fmt.Errorf("parse secret id: %w", err)
+	}
+	if err := s.queries.RotateOAuthClientSecret(ctx, db.RotateOAuthClientSecretParams{
+		RetireAt:   pgtype.Timestamptz{Time: retireAt, Valid: true},
+		ClientID:   clientID,
+		ID:         id,
+		SecretHash: secret.Hash,
+	}); err != nil {
+		return fmt.Errorf("rotate oauth client secret: %w", err)
+	}
+	return nil
+}
+
+// UseClientAssertion records jti, purging the client's expired assertions.
+func (s *SQLStore) UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	rows, err := s.queries.UseOAuthClientAssertion(ctx, db.UseOAuthClientAssertionParams{
+		ClientID:  clientID,
+		Jti:       jti,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("record client assertion: %w", err)
+	}
+	if rows == 0 {
+		return ErrAssertionReplayed
+	}
+	return nil
+}
+
+func clientFromRow(row db.OauthClient) (Client, error) {
+	client := Client{
+		ID:           row.ID,
+		Name:         row.Name,
+		Type:         ClientType(row.ClientType),
+		AuthMethod:   row.TokenEndpointAuthMethod,
+		RedirectURIs: row.RedirectUris,
+		GrantTypes:   row.GrantTypes,
+		Scopes:       row.Scopes,
+		Audiences:    row.Audiences,
+		CreatedAt:    timestamptzValue(row.CreatedAt),
+		UpdatedAt:    timestamptzValue(row.UpdatedAt),
+	}
+	if len(row.Jwks) > 0 {
+		client.JWKS = &jose.JSONWebKeySet{}
+		if err := json.Unmarshal(row.Jwks, client.JWKS); err != nil {
+			return Client{}, fmt.Errorf("decode jwks of oauth client %s: %w", row.ID, err)
+		}
+	}
+	return client, nil
+}
+
+// marshalJWKS encodes jwks for the nullable jwks column.
+func marshalJWKS(jwks *jose.JSONWebKeySet) ([]byte, error) {
+	if jwks == nil || len(jwks.Keys) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(jwks)
+	if err != nil {
+		return nil, fmt.Errorf("encode jwks: %w", err)
+	}
+	return encoded, nil
+}
+
+// FindConsent returns the scopes the user approved for the client.
+func (s *SQLStore) FindConsent(ctx context.Context, userID, clientID string) ([]string, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("parse user id: %w", err)
+	}
+
+	scopes, err := s.queries.GetOAuthConsentScopes(ctx, db.GetOAuthConsentScopesParams{
+		UserID:   id,
+		ClientID: clientID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrConsentNotFound
+		}
+		return nil, fmt.Errorf("lookup oauth consent: %w", err)
+	}
+	return scopes, nil
+}
+
+// SaveConsent upserts the consent, unioning scopes with those already approved.
+func (s *SQLStore) SaveConsent(ctx context.Context, userID, clientID string, scopes []string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+
+	if err := s.queries.UpsertOAuthConsent(ctx, db.UpsertOAuthConsentParams{
+		UserID:   id,
+		ClientID: clientID,
+		Scopes:   nonNil(scopes),
+	}); err != nil {
+		return fmt.Errorf("upsert oauth consent: %w", err)
+	}
+	return nil
+}
+
+// ListConsents returns the user's consents ordered by client name.
+func (s *SQLStore) ListConsents(ctx context.Context, userID string) ([]Consent, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("parse user id: %w", err)
+	}
+
+	rows, err := s.queries.ListOAuthConsentsByUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("list oauth consents: %w", err)
+	}
+	consents := make([]Consent, 0, len(rows))
+	for _, row := range rows {
+		consents = append(consents, Consent{ClientID: row.ClientID, ClientName: row.ClientName, Scopes: row.Scopes})
+	}
+	return consents, nil
+}
+
+// RevokeConsent deletes the consent and everything issued under it in one
+// transaction, recording the revoked tokens.
+func (s *SQLStore) RevokeConsent(ctx context.Context, userID, clientID string, _ time.Time) (_ []TokenRevocation, err error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("parse user id: %w", err)
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	qtx := s.queries.WithTx(tx)
+	var rows int64
+	if rows, err = qtx.DeleteOAuthConsent(ctx, db.DeleteOAuthConsentParams{UserID: id, ClientID: clientID}); err != nil {
+		return nil, fmt.Errorf("delete oauth consent: %w", err)
+	}
+	if rows == 0 {
+		err = ErrConsentNotFound
+		return nil, err
+	}
+	if err = qtx.DeleteOAuthAuthorizationCodesByUserClient(ctx, db.DeleteOAuthAuthorizationCodesByUserClientParams{UserID: id, ClientID: clientID}); err != nil {
+		return nil, fmt.Errorf("delete authorization codes: %w", err)
+	}
+
+	// Access tokens go first so those derived from a refresh token are counted
+	// before the foreign key would cascade them away.
+	var accessTokens []db.DeleteOAuthAccessTokensByUserClientRow
+	if accessTokens, err = qtx.DeleteOAuthAccessTokensByUserClient(ctx, db.DeleteOAuthAccessTokensByUserClientParams{
+		UserID:   pgtype.UUID{Bytes: id, Valid: true},
+		ClientID: clientID,
+	}); err != nil {
+		return nil, fmt.Errorf("delete access tokens: %w", err)
+	}
+	var refreshTokens []db.DeleteOAuthRefreshTokensByUserClientRow
+	if refreshTokens, err = qtx.DeleteOAuthRefreshTokensByUserClient(ctx, db.DeleteOAuthRefreshTokensByUserClientParams{UserID: id, ClientID: clientID}); err != nil {
+		return nil, fmt.Errorf("delete refresh tokens: %w", err)
+	}
+
+	derived := make(map[uuid.UUID]int32)
+	records := make([]db.CreateOAuthTokenRevocationParams, 0, len(accessTokens)+len(refreshTokens))
+	for _, token := range accessTokens {
+		if token.RefreshTokenID.Valid {
+			derived[token.RefreshTokenID.Bytes]++
+			continue
+		}
+		records = append(records, db.CreateOAuthTokenRevocationParams{TokenType: TokenTypeAccessToken, TokenHash: token.TokenHash})
+	}
+	for _, token := range refreshTokens {
+		records = append(records, db.CreateOAuthTokenRevocationParams{TokenType: TokenTypeRefreshToken, TokenHash: token.TokenHash, AccessTokens: derived[token.ID]})
+	}
+
+	revocations := make([]TokenRevocation, 0, len(records))
+	for _, record := range records {
+		record.ClientID = clientID
+		record.UserID = pgtype.UUID{Bytes: id, Valid: true}
+		var row db.OauthTokenRevocation
+		if row, err = qtx.CreateOAuthTokenRevocation(ctx, record); err != nil {
+			return nil, fmt.Errorf("insert token revocation: %w", err)
+		}
+		revocations = append(revocations, tokenRevocationFromRow(row))
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return revocations, nil
+}
+
+// SaveAuthorizationCode inserts code.
+func (s *SQLStore) SaveAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	userID, err := uuid.Parse(code.UserID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+
+	if err := s.queries.CreateOAuthAuthorizationCode(ctx, db.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      code.CodeHash,
+		ClientID:      code.ClientID,
+		UserID:        userID,
+		RedirectUri:   code.RedirectURI,
+		Scopes:        nonNil(code.Scopes),
+		Nonce:         pgtype.Text{String: code.Nonce, Valid: code.Nonce != ""},
+		CodeChallenge: code.CodeChallenge,
+		ExpiresAt:     pgtype.Timestamptz{Time: code.ExpiresAt, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("insert authorization code: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes and returns the code with codeHash in one
+// statement, so concurrent redemptions cannot both succeed.
+func (s *SQLStore) ConsumeAuthorizationCode(ctx context.Context, codeHash []byte) (AuthorizationCode, error) {
+	row, err := s.queries.ConsumeOAuthAuthorizationCode(ctx, codeHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return AuthorizationCode{}, ErrCodeNotFound
+		}
+		return AuthorizationCode{}, fmt.Errorf("consume authorization code: %w", err)
+	}
+	return AuthorizationCode{
+		CodeHash:      row.CodeHash,
+		ClientID:      row.ClientID,
+		UserID:        row.UserID.String(),
+		RedirectURI:   row.RedirectUri,
+		Scopes:        row.Scopes,
+		Nonce:         row.Nonce.String,
+		CodeChallenge: row.CodeChallenge,
+		ExpiresAt:     timestamptzValue(row.ExpiresAt),
+		CreatedAt:     timestamptzValue(row.CreatedAt),
+	}, nil
+}
+
+// SaveAccessToken inserts token.
+func (s *SQLStore) SaveAccessToken(ctx context.Context, token AccessToken) error {
+	return saveAccessToken(ctx, s.queries, token)
+}
+
+func saveAccessToken(ctx context.Context, queries *db.Queries, token AccessToken) error {
+	userID, err := optionalUUID(token.UserID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+
+	refreshTokenID, err := optionalUUID(token.RefreshTokenID)
+	if err != nil {
+		return fmt.Errorf("parse refresh token id: %w", err)
+	}
+
+	if err := queries.CreateOAuthAccessToken(ctx, db.CreateOAuthAccessTokenParams{
+		TokenHash:      token.TokenHash,
+		ClientID:       token.ClientID,
+		UserID:         userID,
+		Scopes:         nonNil(token.Scopes),
+		ExpiresAt:      pgtype.Timestamptz{Time: token.ExpiresAt, Valid: true},
+		RefreshTokenID: refreshTokenID,
+	}); err != nil {
+		return fmt.Errorf("insert access token: %w", err)
+	}
+	return nil
+}
+
+// FindAccessToken returns the token with tokenHash.
+func (s *SQLStore) FindAccessToken(ctx context.Context, tokenHash []byte) (AccessToken, error) {
+	row, err := s.queries.GetOAuthAccessToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return AccessToken{}, ErrTokenNotFound
+		}
+		return AccessToken{}, fmt.Errorf("lookup access token: %w", err)
+	}
+	return AccessToken{
+		TokenHash:      row.TokenHash,
+		ClientID:       row.ClientID,
+		UserID:         uuidValue(row.UserID),
+		Scopes:         row.Scopes,
+		RefreshTokenID: uuidValue(row.RefreshTokenID),
+		ExpiresAt:      timestamptzValue(row.ExpiresAt),
+		CreatedAt:      timestamptzValue(row.CreatedAt),
+	}, nil
+}
+
+// SaveClientToken inserts token and the audit record of its issuance in one
+// transaction.
+func (s *SQLStore) SaveClientToken(ctx context.Context, token AccessToken, issuance ClientTokenIssuance) (err error) {
+	id, err := uuid.Parse(issuance.ID)
+	if err != nil {
+		return fmt.Errorf("parse issuance id: %w", err)
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	qtx := s.queries.WithTx(tx)
+	if err = saveAccessToken(ctx, qtx, token); err != nil {
+		return err
+	}
+	if err = qtx.CreateOAuthClientTokenIssuance(ctx, db.CreateOAuthClientTokenIssuanceParams{
+		ID:        id,
+		TokenHash: issuance.TokenHash,
+		ClientID:  issuance.ClientID,
+		Audience:  issuance.Audience,
+		Scopes:    nonNil(issuance.Scopes),
+		ExpiresAt: pgtype.Timestamptz{Time: issuance.ExpiresAt, Valid: true},
+		IssuedAt:  pgtype.Timestamptz{Time: issuance.IssuedAt, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("insert client token issuance: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListClientTokenIssuances returns the client's most recent token issuances.
+func (s *SQLStore) ListClientTokenIssuances(ctx context.Context, clientID string, limit int) ([]ClientTokenIssuance, error) {
+	rows, err := s.queries.ListOAuthClientTokenIssuances(ctx, db.ListOAuthClientTokenIssuancesParams{
+		ClientID: clientID,
+		Limit:    int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list client token issuances: %w", err)
+	}
+	issuances := make([]ClientTokenIssuance, 0, len(rows))
+	for _, row := range rows {
+		issuances = append(issuances, ClientTokenIssuance{
+			ID:        row.ID.String(),
+			TokenHash: row.TokenHash,
+			ClientID:  row.ClientID,
+			Audience:  row.Audience,
+			Scopes:    row.Scopes,
+			ExpiresAt: timestamptzValue(row.ExpiresAt),
+			IssuedAt:  timestamptzValue(row.IssuedAt),
+		})
+	}
+	return issuances, nil
+}
+
+// SaveRefreshToken inserts token, purging expired refresh tokens.
+func (s *SQLStore) SaveRefreshToken(ctx context.Context, token RefreshToken) error {
+	id, err := uuid.Parse(token.ID)
+	if err != nil {
+		return fmt.Errorf("parse refresh token id: %w", err)
+	}
+	userID, err := uuid.Parse(token.UserID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+
+	if err := s.queries.CreateOAuthRefreshToken(ctx, db.CreateOAuthRefreshTokenParams{
+		ID:        id,
+		TokenHash: token.TokenHash,
+		ClientID:  token.ClientID,
+		UserID:    userID,
+		Scopes:    nonNil(token.Scopes),
+		ExpiresAt: pgtype.Timestamptz{Time: token.ExpiresAt, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+	return nil
+}
+
+// FindRefreshToken returns the refresh token with tokenHash.
+func (s *SQLStore) FindRefreshToken(ctx context.Context, tokenHash []byte) (RefreshToken, error) {
+	row, err := s.queries.GetOAuthRefreshToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return RefreshToken{}, ErrTokenNotFound
+		}
+		return RefreshToken{}, fmt.Errorf("lookup refresh token: %w", err)
+	}
+	return RefreshToken{
+		ID:        row.ID.String(),
+		TokenHash: row.TokenHash,
+		ClientID:  row.ClientID,
+		UserID:    row.UserID.String(),
+		Scopes:    row.Scopes,
+		ExpiresAt: timestamptzValue(row.ExpiresAt),
+		RotatedAt: timestamptzValue(row.RotatedAt),
+		CreatedAt: timestamptzValue(row.CreatedAt),
+	}, nil
+}
+
+// RotateRefreshToken swaps the refresh token's hash in one statement, so only
+// one of two concurrent rotations succeeds.
+func (s *SQLStore) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash []byte) error {
+	rows, err := s.queries.RotateOAuthRefreshToken(ctx, db.RotateOAuthRefreshTokenParams{
+		NewTokenHash: newTokenHash,
+		TokenHash:    tokenHash,
+	})
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if rows == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// RevokeToken deletes the token, and the access tokens derived from a refresh
+// token, and records the revocation in one transaction.
+func (s *SQLStore) RevokeToken(ctx context.Context, revocation TokenRevocation) (_ TokenRevocation, err error) {
+	userID, err := optionalUUID(revocation.UserID)
+	if err != nil {
+		return TokenRevocation{}, fmt.Errorf("parse user id: %w", err)
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return TokenRevocation{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	qtx := s.queries.WithTx(tx)
+	switch revocation.TokenType {
+	case TokenTypeAccessToken:
+		var rows int64
+		if rows, err = qtx.DeleteOAuthAccessToken(ctx, revocation.TokenHash); err != nil {
+			return TokenRevocation{}, fmt.Errorf("delete access token: %w", err)
+		}
+		if rows == 0 {
+			err = ErrTokenNotFound
+			return TokenRevocation{}, err
+		}
+	case TokenTypeRefreshToken:
+		var id uuid.UUID
+		if id, err = qtx.DeleteOAuthRefreshToken(ctx, revocation.TokenHash); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				err = ErrTokenNotFound
+				return TokenRevocation{}, err
+			}
+			return TokenRevocation{}, fmt.Errorf("delete refresh token: %w", err)
+		}
+		// The foreign key would cascade too, but deleting explicitly counts
+		// the derived tokens for the audit record.
+		var rows int64
+		if rows, err = qtx.DeleteOAuthAccessTokensByRefreshToken(ctx, pgtype.UUID{Bytes: id, Valid: true}); err != nil {
+			return TokenRevocation{}, fmt.Errorf("delete derived access tokens: %w", err)
+		}
+		revocation.AccessTokens = int(rows)
+	default:
+		err = fmt.Errorf("unknown token type %q", revocation.TokenType)
+		return TokenRevocation{}, err
+	}
+
+	var row db.OauthTokenRevocation
+	if row, err = qtx.CreateOAuthTokenRevocation(ctx, db.CreateOAuthTokenRevocationParams{
+		TokenType:    revocation.TokenType,
+		TokenHash:    revocation.TokenHash,
+		ClientID:     revocation.ClientID,
+		UserID:       userID,
+		AccessTokens: int32(revocation.AccessTokens),
+	}); err != nil {
+		return TokenRevocation{}, fmt.Errorf("insert token revocation: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return TokenRevocation{}, fmt.Errorf("commit transaction: %w", err)
+	}
+	return tokenRevocationFromRow(row), nil
+}
+
+// ListTokenRevocations returns the client's most recent revocations.
+func (s *SQLStore) ListTokenRevocations(ctx context.Context, clientID string, limit int) ([]TokenRevocation, error) {
+	rows, err := s.queries.ListOAuthTokenRevocations(ctx, db.ListOAuthTokenRevocationsParams{
+		ClientID: clientID,
+		Limit:    int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list token revocations: %w", err)
+	}
+	revocations := make([]TokenRevocation, 0, len(rows))
+	for _, row := range rows {
+		revocations = append(revocations, tokenRevocationFromRow(row))
+	}
+	return revocations, nil
+}
+
+func tokenRevocationFromRow(row db.OauthTokenRevocation) TokenRevocation {
+	return TokenRevocation{
+		ID:           row.ID.String(),
+		TokenType:    row.TokenType,
+		TokenHash:    row.TokenHash,
+		ClientID:     row.ClientID,
+		UserID:       uuidValue(row.UserID),
+		AccessTokens: int(row.AccessTokens),
+		RevokedAt:    timestamptzValue(row.RevokedAt),
+	}
+}
+
+// SaveDeviceAuthorization inserts device, purging expired device
+// authorizations.
+func (s *SQLStore) SaveDeviceAuthorization(ctx context.Context, device DeviceAuthorization) error {
+	if err := s.queries.CreateOAuthDeviceAuthorization(ctx, db.CreateOAuthDeviceAuthorizationParams{
+		DeviceCodeHash: device.DeviceCodeHash,
+		UserCodeHash:   device.UserCodeHash,
+		ClientID:       device.ClientID,
+		Scopes:         nonNil(device.Scopes),
+		PollInterval:   int32(device.Interval / time.Second),
+		ExpiresAt:      pgtype.Timestamptz{Time: device.ExpiresAt, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("insert device authorization: %w", err)
+	}
+	return nil
+}
+
+// FindDeviceAuthorization returns the device authorization with userCodeHash.
+func (s *SQLStore) FindDeviceAuthorization(ctx context.Context, userCodeHash []byte) (DeviceAuthorization, error) {
+	row, err := s.queries.GetOAuthDeviceAuthorizationByUserCode(ctx, userCodeHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return DeviceAuthorization{}, ErrDeviceAuthorizationNotFound
+		}
+		return DeviceAuthorization{}, fmt.Errorf("lookup device authorization: %w", err)
+	}
+	return deviceAuthorizationFromRow(row), nil
+}
+
+// DecideDeviceAuthorization records the decision unless one was already made.
+func (s *SQLStore) DecideDeviceAuthorization(ctx context.Context, userCodeHash []byte, userID string, status DeviceStatus) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("parse user id: %w", err)
+	}
+	rows, err := s.queries.DecideOAuthDeviceAuthorization(ctx, db.DecideOAuthDeviceAuthorizationParams{
+		UserCodeHash: userCodeHash,
+		Status:       string(status),
+		UserID:       pgtype.UUID{Bytes: id, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("decide device authorization: %w", err)
+	}
+	if rows == 0 {
+		return ErrDeviceAuthorizationNotFound
+	}
+	return nil
+}
+
+// PollDeviceAuthorization records the poll and returns the row as it was
+// before, locking it so concurrent polls are ordered.
+func (s *SQLStore) PollDeviceAuthorization(ctx context.Context, deviceCodeHash []byte, polledAt time.Time) (DeviceAuthorization, error) {
+	row, err := s.queries.PollOAuthDeviceAuthorization(ctx, db.PollOAuthDeviceAuthorizationParams{
+		DeviceCodeHash: deviceCodeHash,
+		LastPolledAt:   pgtype.Timestamptz{Time: polledAt, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return DeviceAuthorization{}, ErrDeviceAuthorizationNotFound
+		}
+		return DeviceAuthorization{}, fmt.Errorf("poll device authorization: %w", err)
+	}
+	return deviceAuthorizationFromRow(row), nil
+}
+
+// SlowDownDeviceAuthorization sets the minimum time between polls.
+func (s *SQLStore) SlowDownDeviceAuthorization(ctx context.Context, deviceCodeHash []byte, interval time.Duration) error {
+	if err := s.queries.SlowDownOAuthDeviceAuthorization(ctx, db.SlowDownOAuthDeviceAuthorizationParams{
+		DeviceCodeHash: deviceCodeHash,
+		PollInterval:   int32(interval / time.Second),
+	}); err != nil {
+		return fmt.Errorf("slow down device authorization: %w", err)
+	}
+	return nil
+}
+
+// ConsumeDeviceAuthorization deletes and returns the device authorization in
+// one statement, so concurrent polls cannot both redeem it.
+func (s *SQLStore) ConsumeDeviceAuthorization(ctx context.Context, deviceCodeHash []byte) (DeviceAuthorization, error) {
+	row, err := s.queries.ConsumeOAuthDeviceAuthorization(ctx, deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return DeviceAuthorization{}, ErrDeviceAuthorizationNotFound
+		}
+		return DeviceAuthorization{}, fmt.Errorf("consume device authorization: %w", err)
+	}
+	return deviceAuthorizationFromRow(row), nil
+}
+
+func deviceAuthorizationFromRow(row db.OauthDeviceAuthorization) DeviceAuthorization {
+	device := DeviceAuthorization{
+		DeviceCodeHash: row.DeviceCodeHash,
+		UserCodeHash:   row.UserCodeHash,
+		ClientID:       row.ClientID,
+		Scopes:         row.Scopes,
+		Status:         DeviceStatus(row.Status),
+		Interval:       time.Duration(row.PollInterval) * time.Second,
+		LastPolledAt:   timestamptzValue(row.LastPolledAt),
+		ExpiresAt:      timestamptzValue(row.ExpiresAt),
+		CreatedAt:      timestamptzValue(row.CreatedAt),
+	}
+	device.UserID = uuidValue(row.UserID)
+	return device
+}
+
+// nonNil returns values, or an empty slice for the NOT NULL array columns.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// optionalUUID parses id, mapping the empty string to NULL.
+func optionalUUID(id string) (pgtype.UUID, error) {
+	if id == "" {
+		return pgtype.UUID{}, nil
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	return pgtype.UUID{Bytes: parsed, Valid: true}, nil
+}
+
+// uuidValue formats id, mapping NULL to the empty string.
+func uuidValue(id pgtype.UUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return uuid.UUID(id.Bytes).String()
+}
+
+// optionalTimestamptz maps the zero time to NULL.
+func optionalTimestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: !t.IsZero()}
+}
+
+func timestamptzValue(ts pgtype.Timestamptz) time.Time {
+	if !ts.Valid {
+		return time.Time{}
+	}
+	return ts.Time
+}
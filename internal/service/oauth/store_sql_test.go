@@ -0,0 +1,493 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	schemaUpSQL = `
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email CITEXT NOT NULL UNIQUE,
+    display_name TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_clients (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    client_type TEXT NOT NULL DEFAULT 'confidential',
+    token_endpoint_auth_method TEXT NOT NULL DEFAULT 'client_secret_basic',
+    grant_types TEXT[] NOT NULL DEFAULT '{authorization_code}',
+    scopes TEXT[] NOT NULL DEFAULT '{openid,profile,email}',
+    jwks JSONB
+);
+
+CREATE TABLE oauth_client_secrets (
+    id UUID PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    secret_hash BYTEA NOT NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_client_assertions (
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+CREATE TABLE oauth_consents (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash BYTEA PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    nonce TEXT,
+    code_challenge TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_refresh_tokens (
+    id UUID PRIMARY KEY,
+    token_hash BYTEA NOT NULL UNIQUE,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    rotated_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_access_tokens (
+    token_hash BYTEA PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    refresh_token_id UUID REFERENCES oauth_refresh_tokens(id) ON DELETE CASCADE
+);
+
+CREATE TABLE oauth_token_revocations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_type TEXT NOT NULL,
+    token_hash BYTEA NOT NULL,
+    client_id TEXT NOT NULL,
+    user_id UUID,
+    access_tokens INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_client_token_issuances (
+    id UUID PRIMARY KEY,
+    token_hash BYTEA NOT NULL,
+    client_id TEXT NOT NULL,
+    audience TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_device_authorizations (
+    device_code_hash BYTEA PRIMARY KEY,
+    user_code_hash BYTEA NOT NULL UNIQUE,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'pending',
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    poll_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+	schemaDownSQL = `
+DROP TABLE IF EXISTS oauth_device_authorizations;
+DROP TABLE IF EXISTS oauth_client_token_issuances;
+DROP TABLE IF EXISTS oauth_token_revocations;
+DROP TABLE IF EXISTS oauth_access_tokens;
+DROP TABLE IF EXISTS oauth_refresh_tokens;
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_consents;
+DROP TABLE IF EXISTS oauth_client_assertions;
+DROP TABLE IF EXISTS oauth_client_secrets;
+DROP TABLE IF EXISTS oauth_clients;
+DROP TABLE IF EXISTS users;
+DROP EXTENSION IF EXISTS citext;
+DROP EXTENSION IF EXISTS pgcrypto;
+`
+)
+
+func TestSQLStoreIntegration(t *testing.T) {
+	dsn := os.Getenv("AUTH_DATABASE_URL")
+	if strings.TrimSpace(dsn) == "" {
+		t.Skip("AUTH_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	resetDatabase(t, ctx, pool)
+
+	store := NewSQLStore(pool)
+
+	var userID string
+	if err := pool.QueryRow(ctx, "INSERT INTO users (email) VALUES ('sql-oauth@example.com') RETURNING id::text").Scan(&userID); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	now := time.Now()
+	client := Client{
+		ID:           "client-1",
+		Name:         "Example App",
+		Type:         ClientConfidential,
+		AuthMethod:   AuthMethodClientSecretBasic,
+		RedirectURIs: []string{"https://app.example.test/callback"},
+		GrantTypes:   []string{GrantTypeAuthorizationCode},
+		Scopes:       []string{ScopeOpenID, ScopeEmail},
+		Secrets:      []ClientSecret{{ID: "00000000-0000-0000-0000-000000000001", Hash: hashToken("secret")}},
+	}
+	if err := store.CreateClient(ctx, client); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	found, err := store.FindClient(ctx, client.ID)
+	if err != nil {
+		t.Fatalf("find client: %v", err)
+	}
+	if found.Name != client.Name || found.Public() || !found.HasRedirectURI("https://app.example.test/callback") || !found.AllowsScope(ScopeEmail) || found.AllowsScope(ScopeProfile) {
+		t.Fatalf("unexpected client %+v", found)
+	}
+	if !found.verifySecret("secret", now) {
+		t.Fatal("expected stored secret to verify")
+	}
+	if _, err := store.FindClient(ctx, "missing"); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound, got %v", err)
+	}
+
+	rotated := ClientSecret{ID: "00000000-0000-0000-0000-000000000002", Hash: hashToken("rotated")}
+	if err := store.RotateClientSecret(ctx, client.ID, rotated, now.Add(time.Hour)); err != nil {
+		t.Fatalf("rotate secret: %v", err)
+	}
+	found, err = store.FindClient(ctx, client.ID)
+	if err != nil {
+		t.Fatalf("find rotated client: %v", err)
+	}
+	if !found.verifySecret("secret", now) || !found.verifySecret("rotated", now) || found.verifySecret("secret", now.Add(2*time.Hour)) {
+		t.Fatalf("expected overlapping secrets, got %+v", found.Secrets)
+	}
+
+	key := newClientKey(t)
+	client.Name = "Renamed App"
+	client.AuthMethod = AuthMethodPrivateKeyJWT
+	client.JWKS = publicJWKS(key)
+	client.Audiences = []string{"orders"}
+	if err := store.UpdateClient(ctx, client); err != nil {
+		t.Fatalf("update client: %v", err)
+	}
+	clients, err := store.ListClients(ctx)
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].Name != "Renamed App" || clients[0].JWKS == nil || len(clients[0].JWKS.Key("client-key")) != 1 ||
+		!clients[0].AllowsAudience("orders") {
+		t.Fatalf("unexpected clients %+v", clients)
+	}
+	if err := store.UpdateClient(ctx, Client{ID: "missing"}); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound on update, got %v", err)
+	}
+
+	if err := store.UseClientAssertion(ctx, client.ID, "jti-1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("use assertion: %v", err)
+	}
+	if err := store.UseClientAssertion(ctx, client.ID, "jti-1", now.Add(time.Minute)); !errors.Is(err, ErrAssertionReplayed) {
+		t.Fatalf("expected ErrAssertionReplayed, got %v", err)
+	}
+
+	if _, err := store.FindConsent(ctx, userID, client.ID); !errors.Is(err, ErrConsentNotFound) {
+		t.Fatalf("expected ErrConsentNotFound, got %v", err)
+	}
+	if err := store.SaveConsent(ctx, userID, client.ID, []string{"openid", "email"}); err != nil {
+		t.Fatalf("save consent: %v", err)
+	}
+	if err := store.SaveConsent(ctx, userID, client.ID, []string{"profile", "openid"}); err != nil {
+		t.Fatalf("merge consent: %v", err)
+	}
+	scopes, err := store.FindConsent(ctx, userID, client.ID)
+	if err != nil {
+		t.Fatalf("find consent: %v", err)
+	}
+	if !slices.Equal(scopes, []string{"email", "openid", "profile"}) {
+		t.Fatalf("expected merged scopes, got %v", scopes)
+	}
+
+	code := AuthorizationCode{
+		CodeHash:      hashToken("code"),
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   "https://app.example.test/callback",
+		Scopes:        []string{"openid"},
+		Nonce:         "nonce",
+		CodeChallenge: "challenge",
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+	if err := store.SaveAuthorizationCode(ctx, code); err != nil {
+		t.Fatalf("save code: %v", err)
+	}
+	consumed, err := store.ConsumeAuthorizationCode(ctx, code.CodeHash)
+	if err != nil {
+		t.Fatalf("consume code: %v", err)
+	}
+	if consumed.UserID != userID || consumed.Nonce != "nonce" || consumed.CodeChallenge != "challenge" {
+		t.Fatalf("unexpected code %+v", consumed)
+	}
+	if _, err := store.ConsumeAuthorizationCode(ctx, code.CodeHash); !errors.Is(err, ErrCodeNotFound) {
+		t.Fatalf("expected ErrCodeNotFound on reuse, got %v", err)
+	}
+
+	token := AccessToken{
+		TokenHash: hashToken("token"),
+		ClientID:  client.ID,
+		UserID:    userID,
+		Scopes:    []string{"openid", "email"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.SaveAccessToken(ctx, token); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	stored, err := store.FindAccessToken(ctx, token.TokenHash)
+	if err != nil {
+		t.Fatalf("find token: %v", err)
+	}
+	if stored.UserID != userID || !slices.Equal(stored.Scopes, token.Scopes) {
+		t.Fatalf("unexpected token %+v", stored)
+	}
+	if _, err := store.FindAccessToken(ctx, hashToken("missing")); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected ErrTokenNotFound, got %v", err)
+	}
+
+	refresh := RefreshToken{
+		ID:        "00000000-0000-0000-0000-0000000000a1",
+		TokenHash: hashToken("refresh"),
+		ClientID:  client.ID,
+		UserID:    userID,
+		Scopes:    []string{"openid"},
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := store.SaveRefreshToken(ctx, refresh); err != nil {
+		t.Fatalf("save refresh token: %v", err)
+	}
+	if err := store.RotateRefreshToken(ctx, refresh.TokenHash, hashToken("refresh-2")); err != nil {
+		t.Fatalf("rotate refresh token: %v", err)
+	}
+	if err := store.RotateRefreshToken(ctx, refresh.TokenHash, hashToken("refresh-3")); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected a rotated refresh token to be gone, got %v", err)
+	}
+	storedRefresh, err := store.FindRefreshToken(ctx, hashToken("refresh-2"))
+	if err != nil || storedRefresh.ID != refresh.ID || storedRefresh.RotatedAt.IsZero() || !slices.Equal(storedRefresh.Scopes, refresh.Scopes) {
+		t.Fatalf("unexpected rotated refresh token %+v %v", storedRefresh, err)
+	}
+	for _, name := range []string{"derived-1", "derived-2"} {
+		if err := store.SaveAccessToken(ctx, AccessToken{
+			TokenHash:      hashToken(name),
+			ClientID:       client.ID,
+			UserID:         userID,
+			RefreshTokenID: refresh.ID,
+			ExpiresAt:      now.Add(time.Hour),
+		}); err != nil {
+			t.Fatalf("save derived token: %v", err)
+		}
+	}
+	if derived, err := store.FindAccessToken(ctx, hashToken("derived-1")); err != nil || derived.RefreshTokenID != refresh.ID {
+		t.Fatalf("unexpected derived token %+v %v", derived, err)
+	}
+
+	revocation, err := store.RevokeToken(ctx, TokenRevocation{TokenType: TokenTypeAccessToken, TokenHash: hashToken("derived-1"), ClientID: client.ID, UserID: userID})
+	if err != nil || revocation.ID == "" || revocation.AccessTokens != 0 || revocation.RevokedAt.IsZero() {
+		t.Fatalf("unexpected access token revocation %+v %v", revocation, err)
+	}
+	revocation, err = store.RevokeToken(ctx, TokenRevocation{TokenType: TokenTypeRefreshToken, TokenHash: hashToken("refresh-2"), ClientID: client.ID, UserID: userID})
+	if err != nil || revocation.AccessTokens != 1 {
+		t.Fatalf("unexpected refresh token revocation %+v %v", revocation, err)
+	}
+	if _, err := store.FindAccessToken(ctx, hashToken("derived-2")); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected derived tokens to be revoked, got %v", err)
+	}
+	if _, err := store.RevokeToken(ctx, TokenRevocation{TokenType: TokenTypeRefreshToken, TokenHash: hashToken("refresh-2"), ClientID: client.ID, UserID: userID}); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected ErrTokenNotFound on second revocation, got %v", err)
+	}
+	revocations, err := store.ListTokenRevocations(ctx, client.ID, 1)
+	if err != nil || len(revocations) != 1 || revocations[0].TokenType != TokenTypeRefreshToken || revocations[0].UserID != userID {
+		t.Fatalf("unexpected revocations %+v %v", revocations, err)
+	}
+
+	consented := RefreshToken{
+		ID:        "00000000-0000-0000-0000-0000000000a2",
+		TokenHash: hashToken("consented-refresh"),
+		ClientID:  client.ID,
+		UserID:    userID,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := store.SaveRefreshToken(ctx, consented); err != nil {
+		t.Fatalf("save refresh token: %v", err)
+	}
+	if err := store.SaveAccessToken(ctx, AccessToken{TokenHash: hashToken("consented-derived"), ClientID: client.ID, UserID: userID, RefreshTokenID: consented.ID, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("save derived token: %v", err)
+	}
+	consents, err := store.ListConsents(ctx, userID)
+	if err != nil || len(consents) != 1 || consents[0].ClientName != "Renamed App" || !slices.Equal(consents[0].Scopes, []string{"email", "openid", "profile"}) {
+		t.Fatalf("unexpected consents %+v %v", consents, err)
+	}
+	revoked, err := store.RevokeConsent(ctx, userID, client.ID, now)
+	if err != nil || len(revoked) != 2 {
+		t.Fatalf("expected the standalone and refresh tokens to be revoked, got %+v %v", revoked, err)
+	}
+	for _, revocation := range revoked {
+		if revocation.TokenType == TokenTypeRefreshToken && revocation.AccessTokens != 1 {
+			t.Fatalf("expected the derived token to be counted, got %+v", revocation)
+		}
+	}
+	for _, hash := range [][]byte{token.TokenHash, hashToken("consented-derived")} {
+		if _, err := store.FindAccessToken(ctx, hash); !errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("expected access tokens to be revoked with the consent, got %v", err)
+		}
+	}
+	if _, err := store.RevokeConsent(ctx, userID, client.ID, now); !errors.Is(err, ErrConsentNotFound) {
+		t.Fatalf("expected ErrConsentNotFound once revoked, got %v", err)
+	}
+
+	clientToken := AccessToken{
+		TokenHash: hashToken("client-token"),
+		ClientID:  client.ID,
+		Scopes:    []string{"orders:read"},
+		ExpiresAt: now.Add(5 * time.Minute),
+	}
+	issuance := ClientTokenIssuance{
+		ID:        "00000000-0000-0000-0000-0000000000b1",
+		TokenHash: clientToken.TokenHash,
+		ClientID:  client.ID,
+		Audience:  "https://auth.example.test",
+		Scopes:    clientToken.Scopes,
+		ExpiresAt: clientToken.ExpiresAt,
+		IssuedAt:  now,
+	}
+	if err := store.SaveClientToken(ctx, clientToken, issuance); err != nil {
+		t.Fatalf("save client token: %v", err)
+	}
+	if stored, err := store.FindAccessToken(ctx, clientToken.TokenHash); err != nil || stored.UserID != "" {
+		t.Fatalf("expected a client token without a user, got %+v %v", stored, err)
+	}
+	revocation, err = store.RevokeToken(ctx, TokenRevocation{TokenType: TokenTypeAccessToken, TokenHash: clientToken.TokenHash, ClientID: client.ID})
+	if err != nil || revocation.UserID != "" {
+		t.Fatalf("unexpected client token revocation %+v %v", revocation, err)
+	}
+
+	device := DeviceAuthorization{
+		DeviceCodeHash: hashToken("device-code"),
+		UserCodeHash:   hashToken("BCDFGHJK"),
+		ClientID:       client.ID,
+		Scopes:         []string{"openid"},
+		Status:         DevicePending,
+		Interval:       5 * time.Second,
+		ExpiresAt:      now.Add(time.Minute),
+	}
+	if err := store.SaveDeviceAuthorization(ctx, device); err != nil {
+		t.Fatalf("save device authorization: %v", err)
+	}
+	polled, err := store.PollDeviceAuthorization(ctx, device.DeviceCodeHash, now)
+	if err != nil {
+		t.Fatalf("poll device authorization: %v", err)
+	}
+	if !polled.LastPolledAt.IsZero() || polled.Status != DevicePending || polled.Interval != 5*time.Second {
+		t.Fatalf("expected the state before the first poll, got %+v", polled)
+	}
+	if err := store.SlowDownDeviceAuthorization(ctx, device.DeviceCodeHash, 10*time.Second); err != nil {
+		t.Fatalf("slow down device authorization: %v", err)
+	}
+	if err := store.DecideDeviceAuthorization(ctx, device.UserCodeHash, userID, DeviceApproved); err != nil {
+		t.Fatalf("decide device authorization: %v", err)
+	}
+	if err := store.DecideDeviceAuthorization(ctx, device.UserCodeHash, userID, DeviceDenied); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Fatalf("expected a second decision to fail, got %v", err)
+	}
+	decided, err := store.FindDeviceAuthorization(ctx, device.UserCodeHash)
+	if err != nil {
+		t.Fatalf("find device authorization: %v", err)
+	}
+	if decided.Status != DeviceApproved || decided.UserID != userID || decided.Interval != 10*time.Second || decided.LastPolledAt.IsZero() {
+		t.Fatalf("unexpected device authorization %+v", decided)
+	}
+	consumedDevice, err := store.ConsumeDeviceAuthorization(ctx, device.DeviceCodeHash)
+	if err != nil || consumedDevice.UserID != userID || !slices.Equal(consumedDevice.Scopes, device.Scopes) {
+		t.Fatalf("unexpected consumed device authorization %+v %v", consumedDevice, err)
+	}
+	if _, err := store.ConsumeDeviceAuthorization(ctx, device.DeviceCodeHash); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Fatalf("expected ErrDeviceAuthorizationNotFound on reuse, got %v", err)
+	}
+	if _, err := store.PollDeviceAuthorization(ctx, device.DeviceCodeHash, now); !errors.Is(err, ErrDeviceAuthorizationNotFound) {
+		t.Fatalf("expected ErrDeviceAuthorizationNotFound after consumption, got %v", err)
+	}
+
+	if err := store.DeleteClient(ctx, client.ID); err != nil {
+		t.Fatalf("delete client: %v", err)
+	}
+	if _, err := store.FindAccessToken(ctx, token.TokenHash); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected tokens to be deleted with the client, got %v", err)
+	}
+	if err := store.DeleteClient(ctx, client.ID); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("expected ErrClientNotFound on second delete, got %v", err)
+	}
+	if revocations, err := store.ListTokenRevocations(ctx, client.ID, 10); err != nil || len(revocations) != 3 {
+		t.Fatalf("expected revocations to outlive the client, got %+v %v", revocations, err)
+	}
+	issuances, err := store.ListClientTokenIssuances(ctx, client.ID, 10)
+	if err != nil || len(issuances) != 1 || issuances[0].ID != issuance.ID || issuances[0].Audience != issuance.Audience || !slices.Equal(issuances[0].Scopes, issuance.Scopes) {
+		t.Fatalf("expected issuances to outlive the client, got %+v %v", issuances, err)
+	}
+}
+
+func resetDatabase(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+
+	for _, section := range []string{schemaDownSQL, schemaUpSQL} {
+		for _, stmt := range strings.Split(section, ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if _, err := pool.Exec(ctx, stmt); err != nil {
+				t.Fatalf("exec statement %q: %v", stmt, err)
+			}
+		}
+	}
+}
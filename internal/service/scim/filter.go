@@ -0,0 +1,91 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Filter is an equality filter on one attribute, the only kind directories
+// need to look up a resource before provisioning it (RFC 7644 §3.4.2.2).
+type Filter struct {
+	// Attribute is the attribute path, lowercased since SCIM attribute names
+	// are case-insensitive.
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses expr of the form `attribute eq "value"`.
+func ParseFilter(expr string) (Filter, error) {
+	attribute, rest, ok := strings.Cut(strings.TrimSpace(expr), " ")
+	if !ok {
+		return Filter{}, invalidFilter(expr)
+	}
+	operator, value, ok := strings.Cut(strings.TrimSpace(rest), " ")
+	if !ok || !strings.EqualFold(operator, "eq") {
+		return Filter{}, newError(http.StatusBadRequest, ErrorInvalidFilter, "only the eq operator is supported")
+	}
+
+	var literal string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(value)), &literal); err != nil {
+		return Filter{}, invalidFilter(expr)
+	}
+	return Filter{Attribute: strings.ToLower(attribute), Value: literal}, nil
+}
+
+func invalidFilter(expr string) *Error {
+	return newError(http.StatusBadRequest, ErrorInvalidFilter, "filter "+strconv.Quote(expr)+` must have the form attribute eq "value"`)
+}
+
+// ListParams are the query parameters of a list request.
+type ListParams struct {
+	// Filter is empty when the request lists every resource.
+	Filter Filter
+	// StartIndex is the 1-based index of the first result.
+	StartIndex int
+	Count      int
+	// ExcludeMembers leaves the members out of listed groups.
+	ExcludeMembers bool
+}
+
+// offset is the 0-based index of the first result.
+func (p ListParams) offset() int {
+	return p.StartIndex - 1
+}
+
+// ParseListParams reads filter, startIndex, count and excludedAttributes
+// from query. An out-of-range startIndex or count is clamped rather than
+// rejected (RFC 7644 §3.4.2.4).
+func ParseListParams(query url.Values) (ListParams, error) {
+	params := ListParams{StartIndex: 1, Count: DefaultPageSize}
+
+	if raw := query.Get("filter"); strings.TrimSpace(raw) != "" {
+		filter, err := ParseFilter(raw)
+		if err != nil {
+			return ListParams{}, err
+		}
+		params.Filter = filter
+	}
+	if raw := query.Get("startIndex"); raw != "" {
+		index, err := strconv.Atoi(raw)
+		if err != nil {
+			return ListParams{}, invalidValue("startIndex must be an integer")
+		}
+		params.StartIndex = max(index, 1)
+	}
+	if raw := query.Get("count"); raw != "" {
+		count, err := strconv.Atoi(raw)
+		if err != nil {
+			return ListParams{}, invalidValue("count must be an integer")
+		}
+		params.Count = min(max(count, 0), MaxPageSize)
+	}
+	for attribute := range strings.SplitSeq(query.Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			params.ExcludeMembers = true
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,129 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+// ListGroups returns the page of the tenant's groups params select. Groups
+// can be filtered by displayName only.
+func (s *Service) ListGroups(ctx context.Context, tenant Tenant, params ListParams) (ListResponse[GroupResource], error) {
+	query := GroupQuery{Directory: tenant.ID, Offset: params.offset(), Limit: params.Count}
+	switch params.Filter.Attribute {
+	case "":
+	case "displayname":
+		query.DisplayName = params.Filter.Value
+	default:
+		return ListResponse[GroupResource]{}, newError(http.StatusBadRequest, ErrorInvalidFilter, "groups can only be filtered by displayName")
+	}
+
+	groups, total, err := s.store.ListGroups(ctx, query)
+	if err != nil {
+		return ListResponse[GroupResource]{}, err
+	}
+	resources := make([]GroupResource, 0, len(groups))
+	for _, group := range groups {
+		resources = append(resources, groupResource(group, !params.ExcludeMembers))
+	}
+	return newListResponse(resources, total, params.StartIndex), nil
+}
+
+// CreateGroup creates a group of the tenant's users.
+func (s *Service) CreateGroup(ctx context.Context, tenant Tenant, resource GroupResource) (GroupResource, error) {
+	now := s.now()
+	group := Group{
+		ID:          uuid.NewString(),
+		Directory:   tenant.ID,
+		DisplayName: strings.TrimSpace(resource.DisplayName),
+		ExternalID:  resource.ExternalID,
+		Members:     memberIDs(resource.Members),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.validateGroup(ctx, group, nil); err != nil {
+		return GroupResource{}, err
+	}
+	if err := s.store.CreateGroup(ctx, group); err != nil {
+		return GroupResource{}, clientError(err)
+	}
+	return groupResource(group, true), nil
+}
+
+// GetGroup returns the tenant's group with id.
+func (s *Service) GetGroup(ctx context.Context, tenant Tenant, id string) (GroupResource, error) {
+	group, err := s.store.FindGroup(ctx, tenant.ID, id)
+	if err != nil {
+		return GroupResource{}, clientError(err)
+	}
+	return groupResource(group, true), nil
+}
+
+// ReplaceGroup replaces the tenant's group with id by resource.
+func (s *Service) ReplaceGroup(ctx context.Context, tenant Tenant, id string, resource GroupResource) (GroupResource, error) {
+	group, err := s.store.FindGroup(ctx, tenant.ID, id)
+	if err != nil {
+		return GroupResource{}, clientError(err)
+	}
+	previous := group.Members
+	group.DisplayName = strings.TrimSpace(resource.DisplayName)
+	group.ExternalID = resource.ExternalID
+	group.Members = memberIDs(resource.Members)
+	return s.saveGroup(ctx, group, previous)
+}
+
+// PatchGroup applies patch to the tenant's group with id.
+func (s *Service) PatchGroup(ctx context.Context, tenant Tenant, id string, patch PatchRequest) (GroupResource, error) {
+	group, err := s.store.FindGroup(ctx, tenant.ID, id)
+	if err != nil {
+		return GroupResource{}, clientError(err)
+	}
+	previous := slices.Clone(group.Members)
+	if err := applyGroupPatch(&group, patch); err != nil {
+		return GroupResource{}, err
+	}
+	return s.saveGroup(ctx, group, previous)
+}
+
+// DeleteGroup deletes the tenant's group with id. Its members keep their
+// accounts.
+func (s *Service) DeleteGroup(ctx context.Context, tenant Tenant, id string) error {
+	return clientError(s.store.DeleteGroup(ctx, tenant.ID, id))
+}
+
+func (s *Service) saveGroup(ctx context.Context, group Group, previous []string) (GroupResource, error) {
+	if err := s.validateGroup(ctx, group, previous); err != nil {
+		return GroupResource{}, err
+	}
+	group.UpdatedAt = s.now()
+	if err := s.store.UpdateGroup(ctx, group); err != nil {
+		return GroupResource{}, clientError(err)
+	}
+	return groupResource(group, true), nil
+}
+
+// validateGroup checks group has a name and that its members not among
+// previous are users of its directory.
+func (s *Service) validateGroup(ctx context.Context, group Group, previous []string) error {
+	if group.DisplayName == "" {
+		return invalidValue("displayName is required")
+	}
+	for _, id := range group.Members {
+		if slices.Contains(previous, id) {
+			continue
+		}
+		if _, err := s.accounts.DirectoryUser(ctx, group.Directory, id); err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				return invalidValue("member %q is not a user of this directory", id)
+			}
+			return err
+		}
+	}
+	return nil
+}
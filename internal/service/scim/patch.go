@@ -0,0 +1,268 @@
+package scim
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PatchRequest is a PATCH message (RFC 7644 §3.5.2). Its operations are
+// applied to a copy of the resource, which is stored only if all succeed.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation adds, replaces or removes the attribute at Path. Without a
+// path, Value is an object whose members are applied one by one.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+const (
+	opAdd     = "add"
+	opReplace = "replace"
+	opRemove  = "remove"
+)
+
+// each calls apply with every operation's lowercased op, path and value,
+// expanding operations without a path.
+func (p PatchRequest) each(apply func(op, path string, value json.RawMessage) error) error {
+	if len(p.Operations) == 0 {
+		return newError(http.StatusBadRequest, ErrorInvalidSyntax, "Operations is required")
+	}
+	for _, operation := range p.Operations {
+		// Op is case-insensitive; Entra ID sends "Replace".
+		op := strings.ToLower(operation.Op)
+		if op != opAdd && op != opReplace && op != opRemove {
+			return newError(http.StatusBadRequest, ErrorInvalidSyntax, "unsupported op "+strconv.Quote(operation.Op))
+		}
+		if strings.TrimSpace(operation.Path) != "" {
+			if err := apply(op, strings.TrimSpace(operation.Path), operation.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if op == opRemove {
+			return newError(http.StatusBadRequest, ErrorInvalidPath, "remove requires a path")
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(operation.Value, &values); err != nil {
+			return invalidValue("value must be an object when path is omitted")
+		}
+		for path, value := range values {
+			if err := apply(op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyUserPatch applies patch to resource. Attributes the account does not
+// keep, such as enterprise extension attributes, are ignored.
+func applyUserPatch(resource *UserResource, patch PatchRequest) error {
+	return patch.each(func(op, path string, value json.RawMessage) error {
+		switch attribute := strings.ToLower(path); attribute {
+		case "username":
+			if op == opRemove {
+				return invalidValue("userName cannot be removed")
+			}
+			return decodeString(path, value, &resource.UserName)
+		case "displayname":
+			return setString(op, path, value, &resource.DisplayName)
+		case "externalid":
+			return setString(op, path, value, &resource.ExternalID)
+		case "active":
+			if op == opRemove {
+				return invalidValue("active cannot be removed")
+			}
+			active, err := decodeBool(path, value)
+			if err != nil {
+				return err
+			}
+			resource.Active = &active
+			return nil
+		case "name", "name.formatted", "name.givenname", "name.familyname":
+			return applyName(resource, op, attribute, value)
+		}
+		return nil
+	})
+}
+
+// applyName updates the user's name. The display name follows the formatted
+// name as long as the two have not been set apart.
+func applyName(resource *UserResource, op, attribute string, value json.RawMessage) error {
+	var name Name
+	if resource.Name != nil {
+		name = *resource.Name
+	}
+	previous := name.Formatted
+
+	switch attribute {
+	case "name":
+		var patched Name
+		if op != opRemove {
+			if err := json.Unmarshal(value, &patched); err != nil {
+				return invalidValue("name must be an object")
+			}
+		}
+		if op == opAdd {
+			patched.Formatted = cmp.Or(patched.Formatted, name.Formatted)
+			patched.GivenName = cmp.Or(patched.GivenName, name.GivenName)
+			patched.FamilyName = cmp.Or(patched.FamilyName, name.FamilyName)
+		}
+		name = patched
+	case "name.formatted":
+		if err := setString(op, "name.formatted", value, &name.Formatted); err != nil {
+			return err
+		}
+	case "name.givenname":
+		if err := setString(op, "name.givenName", value, &name.GivenName); err != nil {
+			return err
+		}
+	case "name.familyname":
+		if err := setString(op, "name.familyName", value, &name.FamilyName); err != nil {
+			return err
+		}
+	}
+
+	if resource.DisplayName == previous {
+		resource.DisplayName = name.Formatted
+	}
+	resource.Name = &name
+	return nil
+}
+
+// applyGroupPatch applies patch to group.
+func applyGroupPatch(group *Group, patch PatchRequest) error {
+	return patch.each(func(op, path string, value json.RawMessage) error {
+		attribute := strings.ToLower(path)
+		switch {
+		case attribute == "displayname":
+			if op == opRemove {
+				return invalidValue("displayName cannot be removed")
+			}
+			if err := decodeString(path, value, &group.DisplayName); err != nil {
+				return err
+			}
+			if strings.TrimSpace(group.DisplayName) == "" {
+				return invalidValue("displayName is required")
+			}
+			return nil
+		case attribute == "externalid":
+			return setString(op, path, value, &group.ExternalID)
+		case attribute == "members":
+			return applyMembers(group, op, value)
+		case strings.HasPrefix(attribute, "members["):
+			return removeFilteredMember(group, op, path)
+		}
+		return nil
+	})
+}
+
+func applyMembers(group *Group, op string, value json.RawMessage) error {
+	if op == opRemove && isNull(value) {
+		group.Members = nil
+		return nil
+	}
+	members, err := decodeMembers(value)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case opAdd:
+		group.Members = memberIDs(append(membersOf(group.Members), members...))
+	case opReplace:
+		group.Members = memberIDs(members)
+	case opRemove:
+		removed := memberIDs(members)
+		group.Members = slices.DeleteFunc(group.Members, func(id string) bool { return slices.Contains(removed, id) })
+	}
+	return nil
+}
+
+// removeFilteredMember handles `members[value eq "id"]`, the path Entra ID
+// uses to remove one member.
+func removeFilteredMember(group *Group, op, path string) error {
+	inner, ok := strings.CutSuffix(path[len("members["):], "]")
+	if !ok {
+		return newError(http.StatusBadRequest, ErrorInvalidPath, "unsupported path "+strconv.Quote(path))
+	}
+	filter, err := ParseFilter(inner)
+	if err != nil || filter.Attribute != "value" {
+		return newError(http.StatusBadRequest, ErrorInvalidPath, "members can only be selected by value")
+	}
+	if op != opRemove {
+		return newError(http.StatusBadRequest, ErrorInvalidPath, "a members filter is only supported with remove")
+	}
+	group.Members = slices.DeleteFunc(group.Members, func(id string) bool { return id == filter.Value })
+	return nil
+}
+
+func decodeMembers(value json.RawMessage) ([]Member, error) {
+	value = bytes.TrimSpace(value)
+	if len(value) > 0 && value[0] == '{' {
+		value = append(append([]byte{'['}, value...), ']')
+	}
+	var members []Member
+	if err := json.Unmarshal(value, &members); err != nil {
+		return nil, invalidValue("members must be a list of objects with a value")
+	}
+	return members, nil
+}
+
+func membersOf(ids []string) []Member {
+	members := make([]Member, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, Member{Value: id})
+	}
+	return members
+}
+
+func setString(op, path string, value json.RawMessage, target *string) error {
+	if op == opRemove {
+		*target = ""
+		return nil
+	}
+	return decodeString(path, value, target)
+}
+
+func decodeString(path string, value json.RawMessage, target *string) error {
+	if isNull(value) {
+		*target = ""
+		return nil
+	}
+	if err := json.Unmarshal(value, target); err != nil {
+		return invalidValue("%s must be a string", path)
+	}
+	return nil
+}
+
+// decodeBool accepts a JSON boolean or, as Entra ID sends, "True" or "False".
+func decodeBool(path string, value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if parsed, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, invalidValue("%s must be a boolean", path)
+}
+
+func isNull(value json.RawMessage) bool {
+	value = bytes.TrimSpace(value)
+	return len(value) == 0 || bytes.Equal(value, []byte("null"))
+}
@@ -0,0 +1,223 @@
+package scim
+
+import (
+	"cmp"
+	"strings"
+	"time"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+// UserResource is the SCIM representation of an account (RFC 7643 §4.1).
+// The account's email address is its userName.
+type UserResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	// Active defaults to true when a request omits it.
+	Active *bool `json:"active,omitempty"`
+	Meta   *Meta `json:"meta,omitempty"`
+}
+
+// Name holds the components of a user's name. Only the formatted name is
+// stored, as the account's display name.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email is one of a user's email addresses.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Meta describes a resource (RFC 7643 §3.1). Location is filled in by the
+// HTTP layer, which knows the base URL.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// GroupResource is the SCIM representation of a group (RFC 7643 §4.2).
+type GroupResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Member is a user in a group, referenced by ID.
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// ListResponse is a page of query results (RFC 7644 §3.4.2).
+type ListResponse[T any] struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []T      `json:"Resources"`
+}
+
+// ServiceProviderConfig advertises the supported SCIM features (RFC 7643 §5).
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulk                   `json:"bulk"`
+	Filter                filter                 `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type bulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type filter struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+// ProviderConfig returns the service provider configuration: PATCH and eq
+// filters are supported, bulk operations, sorting and ETags are not.
+func ProviderConfig() ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas: []string{SchemaServiceProviderConfig},
+		Patch:   supported{Supported: true},
+		Filter:  filter{Supported: true, MaxResults: MaxPageSize},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "The tenant's provisioning token in the Authorization header",
+			Primary:     true,
+		}},
+	}
+}
+
+func newListResponse[T any](resources []T, total, startIndex int) ListResponse[T] {
+	if resources == nil {
+		resources = []T{}
+	}
+	return ListResponse[T]{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func userResource(user auth.User) UserResource {
+	active := user.Active()
+	resource := UserResource{
+		Schemas:     []string{SchemaUser},
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email.String(),
+		DisplayName: user.DisplayName,
+		Emails:      []Email{{Value: user.Email.String(), Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: cmp.Or(user.UpdatedAt, user.CreatedAt),
+		},
+	}
+	if user.DisplayName != "" {
+		resource.Name = &Name{Formatted: user.DisplayName}
+	}
+	return resource
+}
+
+// profile returns the account fields r describes. The display name falls
+// back to the name, which directories often send instead.
+func (r UserResource) profile() (auth.DirectoryProfile, error) {
+	if strings.TrimSpace(r.UserName) == "" {
+		return auth.DirectoryProfile{}, invalidValue("userName is required")
+	}
+	email, err := auth.NewUserEmail(r.UserName)
+	if err != nil || !strings.Contains(email.String(), "@") {
+		return auth.DirectoryProfile{}, invalidValue("userName must be an email address")
+	}
+	// Only userName is stored, so emails may not smuggle in another domain
+	// that clients reading the resource would trust.
+	for _, address := range r.Emails {
+		other, err := auth.NewUserEmail(address.Value)
+		if err != nil || other.Domain() != email.Domain() {
+			return auth.DirectoryProfile{}, invalidValue("emails must share the domain of userName")
+		}
+	}
+
+	displayName := strings.TrimSpace(r.DisplayName)
+	if displayName == "" && r.Name != nil {
+		displayName = cmp.Or(strings.TrimSpace(r.Name.Formatted), strings.TrimSpace(r.Name.GivenName+" "+r.Name.FamilyName))
+	}
+	return auth.DirectoryProfile{
+		Email:       email,
+		DisplayName: displayName,
+		ExternalID:  r.ExternalID,
+		Active:      r.Active == nil || *r.Active,
+	}, nil
+}
+
+func groupResource(group Group, withMembers bool) GroupResource {
+	resource := GroupResource{
+		Schemas:     []string{SchemaGroup},
+		ID:          group.ID,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: cmp.Or(group.UpdatedAt, group.CreatedAt),
+		},
+	}
+	if withMembers {
+		for _, id := range group.Members {
+			resource.Members = append(resource.Members, Member{Value: id})
+		}
+	}
+	return resource
+}
+
+// memberIDs returns the distinct user IDs of members in order.
+func memberIDs(members []Member) []string {
+	ids := make([]string, 0, len(members))
+	seen := make(map[string]bool, len(members))
+	for _, member := range members {
+		if member.Value == "" || seen[member.Value] {
+			continue
+		}
+		seen[member.Value] = true
+		ids = append(ids, member.Value)
+	}
+	return ids
+}
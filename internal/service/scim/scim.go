@@ -0,0 +1,143 @@
+// Package scim implements SCIM 2.0 provisioning (RFC 7643, RFC 7644), which
+// lets an enterprise directory create, update and deactivate the accounts of
+// its users and maintain their groups.
+package scim
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+const (
+	// Schema URNs of the resources and messages exchanged (RFC 7643 §8.7, RFC 7644 §3).
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	// DefaultPageSize is the page size of list requests without a count.
+	DefaultPageSize = 100
+	// MaxPageSize caps the count a list request may ask for.
+	MaxPageSize = 200
+)
+
+// Error types returned to clients in the scimType member (RFC 7644 §3.12).
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+)
+
+var (
+	// ErrGroupNotFound indicates the directory has no group with the identifier.
+	ErrGroupNotFound = errors.New("scim: group not found")
+	// ErrGroupExists indicates the directory already has a group with the
+	// display name.
+	ErrGroupExists = errors.New("scim: group already exists")
+)
+
+// Error is a SCIM error response delivered to the client.
+type Error struct {
+	Status int
+	// Type is the scimType, empty when the status says enough.
+	Type   string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return "scim: " + e.Detail
+}
+
+func newError(status int, scimType, detail string) *Error {
+	return &Error{Status: status, Type: scimType, Detail: detail}
+}
+
+func invalidValue(format string, args ...any) *Error {
+	return newError(http.StatusBadRequest, ErrorInvalidValue, fmt.Sprintf(format, args...))
+}
+
+// Tenant is a directory allowed to provision, identified by its bearer token.
+type Tenant struct {
+	ID   string
+	Name string
+	// TokenHash is the SHA-256 of the tenant's bearer token.
+	TokenHash []byte
+}
+
+// Accounts manages the user accounts a directory provisions.
+type Accounts interface {
+	ProvisionUser(ctx context.Context, directory string, profile auth.DirectoryProfile) (*auth.User, error)
+	DirectoryUser(ctx context.Context, directory, id string) (*auth.User, error)
+	DirectoryUsers(ctx context.Context, query auth.UserQuery) ([]auth.User, int, error)
+	UpdateDirectoryUser(ctx context.Context, directory, id string, profile auth.DirectoryProfile) (*auth.User, error)
+	ReleaseDirectoryUser(ctx context.Context, directory, id string) error
+}
+
+// Service serves the Users and Groups resources of each tenant. Failures the
+// client caused are returned as *Error; anything else is an internal error.
+type Service struct {
+	store    Store
+	accounts Accounts
+	tenants  []Tenant
+	now      func() time.Time
+}
+
+// NewService wires a Service for tenants, keeping their groups in store.
+func NewService(store Store, accounts Accounts, tenants []Tenant) *Service {
+	return &Service{
+		store:    store,
+		accounts: accounts,
+		tenants:  tenants,
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Authenticate returns the tenant whose bearer token is token. Every tenant
+// is compared so the time taken does not reveal which one matched.
+func (s *Service) Authenticate(token string) (Tenant, bool) {
+	if token == "" {
+		return Tenant{}, false
+	}
+	sum := sha256.Sum256([]byte(token))
+
+	var found Tenant
+	ok := false
+	for _, tenant := range s.tenants {
+		if subtle.ConstantTimeCompare(sum[:], tenant.TokenHash) == 1 {
+			found, ok = tenant, true
+		}
+	}
+	return found, ok
+}
+
+// clientError maps the errors of accounts and store the client caused to
+// *Error, passing others through.
+func clientError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		return newError(http.StatusNotFound, "", "user not found")
+	case errors.Is(err, ErrGroupNotFound):
+		return newError(http.StatusNotFound, "", "group not found")
+	case errors.Is(err, auth.ErrEmailExists):
+		return newError(http.StatusConflict, ErrorUniqueness, "userName is already in use")
+	case errors.Is(err, ErrGroupExists):
+		return newError(http.StatusConflict, ErrorUniqueness, "displayName is already in use")
+	case errors.Is(err, auth.ErrEmailRequired):
+		return invalidValue("userName is required")
+	case errors.Is(err, auth.ErrDomainNotManaged):
+		return invalidValue("userName is outside the tenant's domains")
+	}
+	return err
+}
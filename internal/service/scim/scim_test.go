@@ -0,0 +1,258 @@
+package scim
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+func newTestService(t *testing.T) (*Service, Tenant) {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte("acme-token"))
+	tenant := Tenant{ID: "acme", Name: "Acme", TokenHash: sum[:]}
+	other := sha256.Sum256([]byte("globex-token"))
+	tenants := []Tenant{tenant, {ID: "globex", Name: "Globex", TokenHash: other[:]}}
+	accounts := auth.NewService(auth.NewMemoryStore())
+	accounts.ManageDirectoryDomains("acme", []string{"acme.test"})
+	accounts.ManageDirectoryDomains("globex", []string{"globex.test"})
+	return NewService(NewMemoryStore(), accounts, tenants), tenant
+}
+
+func patchOf(t *testing.T, raw string) PatchRequest {
+	t.Helper()
+
+	var patch PatchRequest
+	if err := json.Unmarshal([]byte(raw), &patch); err != nil {
+		t.Fatalf("decode patch: %v", err)
+	}
+	return patch
+}
+
+func scimStatus(err error) int {
+	var scimErr *Error
+	if errors.As(err, &scimErr) {
+		return scimErr.Status
+	}
+	return 0
+}
+
+func TestServiceAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	service, tenant := newTestService(t)
+	if got, ok := service.Authenticate("acme-token"); !ok || got.ID != tenant.ID {
+		t.Fatalf("expected acme tenant, got %+v %v", got, ok)
+	}
+	if got, ok := service.Authenticate("globex-token"); !ok || got.ID != "globex" {
+		t.Fatalf("expected globex tenant, got %+v %v", got, ok)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if _, ok := service.Authenticate(token); ok {
+			t.Fatalf("expected token %q to be refused", token)
+		}
+	}
+}
+
+func TestParseListParams(t *testing.T) {
+	t.Parallel()
+
+	params, err := ParseListParams(url.Values{
+		"filter":             {`userName Eq "Ada@Acme.test"`},
+		"startIndex":         {"0"},
+		"count":              {"1000"},
+		"excludedAttributes": {"members"},
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := ListParams{Filter: Filter{Attribute: "username", Value: "Ada@Acme.test"}, StartIndex: 1, Count: MaxPageSize, ExcludeMembers: true}
+	if params != want {
+		t.Fatalf("expected %+v, got %+v", want, params)
+	}
+
+	for _, filter := range []string{`userName`, `userName co "ada"`, `userName eq ada`} {
+		if _, err := ParseListParams(url.Values{"filter": {filter}}); scimStatus(err) != http.StatusBadRequest {
+			t.Fatalf("expected filter %q to be rejected, got %v", filter, err)
+		}
+	}
+}
+
+func TestServiceUsers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, tenant := newTestService(t)
+	globex, _ := service.Authenticate("globex-token")
+
+	created, err := service.CreateUser(ctx, tenant, UserResource{UserName: "Ada@Acme.test", Name: &Name{GivenName: "Ada", FamilyName: "Lovelace"}, ExternalID: "00u1"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.UserName != "ada@acme.test" || created.DisplayName != "Ada Lovelace" || created.Active == nil || !*created.Active {
+		t.Fatalf("unexpected created user %+v", created)
+	}
+	if _, err := service.CreateUser(ctx, tenant, UserResource{UserName: "ada@acme.test"}); scimStatus(err) != http.StatusConflict {
+		t.Fatalf("expected duplicate userName to conflict, got %v", err)
+	}
+	if _, err := service.CreateUser(ctx, tenant, UserResource{UserName: "ada"}); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected non-email userName to be rejected, got %v", err)
+	}
+	if _, err := service.CreateUser(ctx, tenant, UserResource{UserName: "ceo@globex.test"}); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected userName outside the tenant's domains to be rejected, got %v", err)
+	}
+	if _, err := service.CreateUser(ctx, tenant, UserResource{UserName: "eve@acme.test", Emails: []Email{{Value: "ceo@globex.test"}}}); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected emails outside the userName's domain to be rejected, got %v", err)
+	}
+	if _, err := service.CreateUser(ctx, tenant, UserResource{UserName: "grace@acme.test"}); err != nil {
+		t.Fatalf("create second user: %v", err)
+	}
+
+	page, err := service.ListUsers(ctx, tenant, ListParams{StartIndex: 2, Count: 1})
+	if err != nil || page.TotalResults != 2 || len(page.Resources) != 1 || page.Resources[0].UserName != "grace@acme.test" {
+		t.Fatalf("expected second page of one, got %+v %v", page, err)
+	}
+	filtered, err := service.ListUsers(ctx, tenant, ListParams{Filter: Filter{Attribute: "username", Value: "ADA@acme.test"}, StartIndex: 1, Count: 10})
+	if err != nil || filtered.TotalResults != 1 || filtered.Resources[0].ID != created.ID {
+		t.Fatalf("expected filter to find ada, got %+v %v", filtered, err)
+	}
+	if other, err := service.ListUsers(ctx, globex, ListParams{StartIndex: 1, Count: 10}); err != nil || other.TotalResults != 0 || other.Resources == nil {
+		t.Fatalf("expected other tenant to see an empty list, got %+v %v", other, err)
+	}
+	if _, err := service.ListUsers(ctx, tenant, ListParams{Filter: Filter{Attribute: "emails.value", Value: "x"}}); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected unsupported filter to be rejected, got %v", err)
+	}
+	if _, err := service.GetUser(ctx, globex, created.ID); scimStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected other tenant not to see the user, got %v", err)
+	}
+
+	patched, err := service.PatchUser(ctx, tenant, created.ID, patchOf(t, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "active", "value": "False"},
+			{"op": "Replace", "path": "name.formatted", "value": "Ada King"},
+			{"op": "Add", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", "value": "Math"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if *patched.Active || patched.DisplayName != "Ada King" || patched.ExternalID != "00u1" {
+		t.Fatalf("unexpected patched user %+v", patched)
+	}
+	reactivated, err := service.PatchUser(ctx, tenant, created.ID, patchOf(t, `{"Operations": [{"op": "replace", "value": {"active": true, "userName": "ada.king@acme.test"}}]}`))
+	if err != nil || !*reactivated.Active || reactivated.UserName != "ada.king@acme.test" {
+		t.Fatalf("expected pathless patch to apply, got %+v %v", reactivated, err)
+	}
+	if _, err := service.PatchUser(ctx, tenant, created.ID, patchOf(t, `{"Operations": [{"op": "replace", "path": "userName", "value": "ceo@globex.test"}]}`)); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected a rename outside the tenant's domains to be rejected, got %v", err)
+	}
+	if _, err := service.ReplaceUser(ctx, tenant, created.ID, UserResource{UserName: "ceo@globex.test"}); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected a replace outside the tenant's domains to be rejected, got %v", err)
+	}
+	if _, err := service.PatchUser(ctx, tenant, created.ID, patchOf(t, `{"Operations": [{"op": "remove", "path": "userName"}]}`)); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected removing userName to be rejected, got %v", err)
+	}
+	if _, err := service.PatchUser(ctx, tenant, created.ID, patchOf(t, `{"Operations": [{"op": "move", "path": "active"}]}`)); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected unknown op to be rejected, got %v", err)
+	}
+
+	replaced, err := service.ReplaceUser(ctx, tenant, created.ID, UserResource{UserName: "ada.king@acme.test", DisplayName: "Countess", Active: new(bool)})
+	if err != nil || replaced.DisplayName != "Countess" || *replaced.Active || replaced.ExternalID != "" {
+		t.Fatalf("expected replace to set every field, got %+v %v", replaced, err)
+	}
+
+	if err := service.DeleteUser(ctx, tenant, created.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := service.GetUser(ctx, tenant, created.ID); scimStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected deleted user to be gone, got %v", err)
+	}
+	if err := service.DeleteUser(ctx, tenant, created.ID); scimStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected second delete to be not found, got %v", err)
+	}
+}
+
+func TestServiceGroups(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	service, tenant := newTestService(t)
+	globex, _ := service.Authenticate("globex-token")
+
+	ada, err := service.CreateUser(ctx, tenant, UserResource{UserName: "ada@acme.test"})
+	if err != nil {
+		t.Fatalf("create ada: %v", err)
+	}
+	grace, err := service.CreateUser(ctx, tenant, UserResource{UserName: "grace@acme.test"})
+	if err != nil {
+		t.Fatalf("create grace: %v", err)
+	}
+	outsider, err := service.CreateUser(ctx, globex, UserResource{UserName: "hal@globex.test"})
+	if err != nil {
+		t.Fatalf("create outsider: %v", err)
+	}
+
+	if _, err := service.CreateGroup(ctx, tenant, GroupResource{DisplayName: "Staff", Members: []Member{{Value: outsider.ID}}}); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected another tenant's user to be refused, got %v", err)
+	}
+	group, err := service.CreateGroup(ctx, tenant, GroupResource{DisplayName: "Staff", Members: []Member{{Value: ada.ID}}})
+	if err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	if _, err := service.CreateGroup(ctx, tenant, GroupResource{DisplayName: "Staff"}); scimStatus(err) != http.StatusConflict {
+		t.Fatalf("expected duplicate displayName to conflict, got %v", err)
+	}
+	if _, err := service.CreateGroup(ctx, globex, GroupResource{DisplayName: "Staff"}); err != nil {
+		t.Fatalf("expected names to be scoped per tenant, got %v", err)
+	}
+
+	patched, err := service.PatchGroup(ctx, tenant, group.ID, patchOf(t, `{"Operations": [
+		{"op": "Add", "path": "members", "value": [{"value": "`+grace.ID+`"}]},
+		{"op": "Remove", "path": "members[value eq \"`+ada.ID+`\"]"}
+	]}`))
+	if err != nil {
+		t.Fatalf("patch group: %v", err)
+	}
+	if len(patched.Members) != 1 || patched.Members[0].Value != grace.ID {
+		t.Fatalf("expected only grace to remain, got %+v", patched.Members)
+	}
+	if _, err := service.PatchGroup(ctx, tenant, group.ID, patchOf(t, `{"Operations": [
+		{"op": "replace", "path": "displayName", "value": "Renamed"},
+		{"op": "add", "path": "members", "value": [{"value": "`+outsider.ID+`"}]}
+	]}`)); scimStatus(err) != http.StatusBadRequest {
+		t.Fatalf("expected invalid member to reject the whole patch, got %v", err)
+	}
+	if found, _ := service.GetGroup(ctx, tenant, group.ID); found.DisplayName != "Staff" {
+		t.Fatalf("expected rejected patch to leave the group unchanged, got %+v", found)
+	}
+
+	list, err := service.ListGroups(ctx, tenant, ListParams{Filter: Filter{Attribute: "displayname", Value: "Staff"}, StartIndex: 1, Count: 10, ExcludeMembers: true})
+	if err != nil || list.TotalResults != 1 || list.Resources[0].ID != group.ID || list.Resources[0].Members != nil {
+		t.Fatalf("expected filtered group without members, got %+v %v", list, err)
+	}
+
+	if err := service.DeleteUser(ctx, tenant, grace.ID); err != nil {
+		t.Fatalf("delete grace: %v", err)
+	}
+	if found, _ := service.GetGroup(ctx, tenant, group.ID); slices.ContainsFunc(found.Members, func(m Member) bool { return m.Value == grace.ID }) {
+		t.Fatalf("expected deleted user to leave the group, got %+v", found.Members)
+	}
+
+	if _, err := service.GetGroup(ctx, globex, group.ID); scimStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected other tenant not to see the group, got %v", err)
+	}
+	if err := service.DeleteGroup(ctx, tenant, group.ID); err != nil {
+		t.Fatalf("delete group: %v", err)
+	}
+	if _, err := service.GetGroup(ctx, tenant, group.ID); scimStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected deleted group to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package scim
+
+import (
+	"context"
+	"time"
+)
+
+// Group is a directory's group as persisted.
+type Group struct {
+	ID          string
+	Directory   string
+	DisplayName string
+	ExternalID  string
+	// Members lists the user IDs in the group, oldest membership first.
+	Members   []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// GroupQuery selects a page of a directory's groups, oldest first.
+type GroupQuery struct {
+	Directory string
+	// DisplayName, when set, selects only the group with that name.
+	DisplayName string
+	Offset      int
+	// Limit caps the groups returned; zero returns only the total.
+	Limit int
+}
+
+// Store defines persistence for provisioned groups.
+type Store interface {
+	// CreateGroup stores group, or fails with ErrGroupExists if its directory
+	// has a group with the same display name.
+	CreateGroup(ctx context.Context, group Group) error
+	// FindGroup returns the directory's group with id, or ErrGroupNotFound.
+	FindGroup(ctx context.Context, directory, id string) (Group, error)
+	// ListGroups returns the page of groups query selects and their total.
+	ListGroups(ctx context.Context, query GroupQuery) ([]Group, int, error)
+	// UpdateGroup replaces the group's name, external ID and members, or
+	// fails with ErrGroupNotFound or ErrGroupExists.
+	UpdateGroup(ctx context.Context, group Group) error
+	// DeleteGroup removes the directory's group with id, or fails with
+	// ErrGroupNotFound.
+	DeleteGroup(ctx context.Context, directory, id string) error
+	// RemoveMember takes the user with userID out of every group.
+	RemoveMember(ctx context.Context, userID string) error
+}
@@ -0,0 +1,135 @@
+package scim
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+)
+
+// MemoryStore is an in-memory implementation of Store for development and tests.
+type MemoryStore struct {
+	mu     sync.Mutex
+	groups map[string]Group
+}
+
+// NewMemoryStore builds an empty MemoryStore instance.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{groups: make(map[string]Group)}
+}
+
+// CreateGroup stores group unless its display name is taken.
+func (s *MemoryStore) CreateGroup(_ context.Context, group Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nameTaken(group) {
+		return ErrGroupExists
+	}
+	s.groups[group.ID] = cloneGroup(group)
+	return nil
+}
+
+// FindGroup returns the directory's group with id.
+func (s *MemoryStore) FindGroup(_ context.Context, directory, id string) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[id]
+	if !ok || group.Directory != directory {
+		return Group{}, ErrGroupNotFound
+	}
+	return cloneGroup(group), nil
+}
+
+// ListGroups returns the page of groups query selects, oldest first.
+func (s *MemoryStore) ListGroups(_ context.Context, query GroupQuery) ([]Group, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []Group
+	for _, group := range s.groups {
+		if group.Directory != query.Directory {
+			continue
+		}
+		if query.DisplayName != "" && group.DisplayName != query.DisplayName {
+			continue
+		}
+		matched = append(matched, group)
+	}
+	slices.SortFunc(matched, func(a, b Group) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+
+	total := len(matched)
+	start := min(max(query.Offset, 0), total)
+	end := min(start+max(query.Limit, 0), total)
+	page := make([]Group, 0, end-start)
+	for _, group := range matched[start:end] {
+		page = append(page, cloneGroup(group))
+	}
+	return page, total, nil
+}
+
+// UpdateGroup replaces the group's name, external ID and members.
+func (s *MemoryStore) UpdateGroup(_ context.Context, group Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.groups[group.ID]
+	if !ok || existing.Directory != group.Directory {
+		return ErrGroupNotFound
+	}
+	if s.nameTaken(group) {
+		return ErrGroupExists
+	}
+	existing.DisplayName = group.DisplayName
+	existing.ExternalID = group.ExternalID
+	existing.Members = slices.Clone(group.Members)
+	existing.UpdatedAt = group.UpdatedAt
+	s.groups[group.ID] = existing
+	return nil
+}
+
+// DeleteGroup removes the directory's group with id.
+func (s *MemoryStore) DeleteGroup(_ context.Context, directory, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[id]
+	if !ok || group.Directory != directory {
+		return ErrGroupNotFound
+	}
+	delete(s.groups, id)
+	return nil
+}
+
+// RemoveMember takes the user out of every group.
+func (s *MemoryStore) RemoveMember(_ context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, group := range s.groups {
+		if slices.Contains(group.Members, userID) {
+			group.Members = slices.DeleteFunc(slices.Clone(group.Members), func(member string) bool { return member == userID })
+			s.groups[id] = group
+		}
+	}
+	return nil
+}
+
+// nameTaken reports whether another group of group's directory has its
+// display name. Callers hold s.mu.
+func (s *MemoryStore) nameTaken(group Group) bool {
+	for _, other := range s.groups {
+		if other.ID != group.ID && other.Directory == group.Directory && other.DisplayName == group.DisplayName {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneGroup(group Group) Group {
+	group.Members = slices.Clone(group.Members)
+	return group
+}
@@ -0,0 +1,288 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rjnemo/auth/internal/driver/db"
+)
+
+// SQLStore persists provisioned groups in PostgreSQL via generated sqlc queries.
+type SQLStore struct {
+	pool    *pgxpool.Pool
+	queries *db.Queries
+}
+
+// NewSQLStore builds a SQL-backed group store.
+func NewSQLStore(pool *pgxpool.Pool) *SQLStore {
+	return &SQLStore{
+		pool:    pool,
+		queries: db.New(pool),
+	}
+}
+
+// CreateGroup inserts group and its members in one transaction.
+func (s *SQLStore) CreateGroup(ctx context.Context, group Group) (err error) {
+	id, err := uuid.Parse(group.ID)
+	if err != nil {
+		return fmt.Errorf("parse group id: %w", err)
+	}
+	members, err := parseUserIDs(group.Members)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	qtx := s.queries.WithTx(tx)
+	if err = qtx.CreateScimGroup(ctx, db.CreateScimGroupParams{
+		ID:          id,
+		Directory:   group.Directory,
+		DisplayName: group.DisplayName,
+		ExternalID:  pgtype.Text{String: group.ExternalID, Valid: group.ExternalID != ""},
+		CreatedAt:   pgtype.Timestamptz{Time: group.CreatedAt, Valid: true},
+		UpdatedAt:   pgtype.Timestamptz{Time: group.UpdatedAt, Valid: true},
+	}); err != nil {
+		if isUniqueViolation(err) {
+			return ErrGroupExists
+		}
+		return fmt.Errorf("insert scim group: %w", err)
+	}
+	for _, userID := range members {
+		if err = qtx.AddScimGroupMember(ctx, db.AddScimGroupMemberParams{GroupID: id, UserID: userID}); err != nil {
+			return fmt.Errorf("insert scim group member: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// FindGroup returns the directory's group with id and its members.
+func (s *SQLStore) FindGroup(ctx context.Context, directory, id string) (Group, error) {
+	groupID, err := uuid.Parse(id)
+	if err != nil {
+		return Group{}, ErrGroupNotFound
+	}
+
+	row, err := s.queries.GetScimGroup(ctx, db.GetScimGroupParams{ID: groupID, Directory: directory})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Group{}, ErrGroupNotFound
+		}
+		return Group{}, fmt.Errorf("lookup scim group: %w", err)
+	}
+	return s.loadGroup(ctx, row)
+}
+
+// ListGroups returns the page of groups query selects. A display name filter
+// is answered by the unique name index instead of a scan.
+func (s *SQLStore) ListGroups(ctx context.Context, query GroupQuery) ([]Group, int, error) {
+	var rows []db.ScimGroup
+	total := 0
+	if query.DisplayName != "" {
+		row, err := s.queries.GetScimGroupByDisplayName(ctx, db.GetScimGroupByDisplayNameParams{
+			Directory:   query.Directory,
+			DisplayName: query.DisplayName,
+		})
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return nil, 0, fmt.Errorf("lookup scim group: %w", err)
+		default:
+			total = 1
+			if query.Offset <= 0 && query.Limit > 0 {
+				rows = append(rows, row)
+			}
+		}
+	} else {
+		count, err := s.queries.CountScimGroups(ctx, query.Directory)
+		if err != nil {
+			return nil, 0, fmt.Errorf("count scim groups: %w", err)
+		}
+		total = int(count)
+		if query.Limit > 0 {
+			if rows, err = s.queries.ListScimGroups(ctx, db.ListScimGroupsParams{
+				Directory: query.Directory,
+				Limit:     int32(min(query.Limit, math.MaxInt32)),
+				Offset:    int32(min(max(query.Offset, 0), math.MaxInt32)),
+			}); err != nil {
+				return nil, 0, fmt.Errorf("list scim groups: %w", err)
+			}
+		}
+	}
+
+	groups := make([]Group, 0, len(rows))
+	for _, row := range rows {
+		group, err := s.loadGroup(ctx, row)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, total, nil
+}
+
+// UpdateGroup replaces the group's fields and applies the difference between
+// its stored and new members in one transaction.
+func (s *SQLStore) UpdateGroup(ctx context.Context, group Group) (err error) {
+	id, err := uuid.Parse(group.ID)
+	if err != nil {
+		return ErrGroupNotFound
+	}
+	members, err := parseUserIDs(group.Members)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	qtx := s.queries.WithTx(tx)
+	updated, err := qtx.UpdateScimGroup(ctx, db.UpdateScimGroupParams{
+		ID:          id,
+		Directory:   group.Directory,
+		DisplayName: group.DisplayName,
+		ExternalID:  pgtype.Text{String: group.ExternalID, Valid: group.ExternalID != ""},
+		UpdatedAt:   pgtype.Timestamptz{Time: group.UpdatedAt, Valid: true},
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			err = ErrGroupExists
+			return err
+		}
+		return fmt.Errorf("update scim group: %w", err)
+	}
+	if updated == 0 {
+		err = ErrGroupNotFound
+		return err
+	}
+
+	current, err := qtx.ListScimGroupMembers(ctx, id)
+	if err != nil {
+		return fmt.Errorf("list scim group members: %w", err)
+	}
+	for _, userID := range current {
+		if slices.Contains(members, userID) {
+			continue
+		}
+		if err = qtx.DeleteScimGroupMember(ctx, db.DeleteScimGroupMemberParams{GroupID: id, UserID: userID}); err != nil {
+			return fmt.Errorf("delete scim group member: %w", err)
+		}
+	}
+	for _, userID := range members {
+		if slices.Contains(current, userID) {
+			continue
+		}
+		if err = qtx.AddScimGroupMember(ctx, db.AddScimGroupMemberParams{GroupID: id, UserID: userID}); err != nil {
+			return fmt.Errorf("insert scim group member: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteGroup removes the directory's group with id; its memberships cascade.
+func (s *SQLStore) DeleteGroup(ctx context.Context, directory, id string) error {
+	groupID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrGroupNotFound
+	}
+
+	deleted, err := s.queries.DeleteScimGroup(ctx, db.DeleteScimGroupParams{ID: groupID, Directory: directory})
+	if err != nil {
+		return fmt.Errorf("delete scim group: %w", err)
+	}
+	if deleted == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// RemoveMember takes the user with userID out of every group.
+func (s *SQLStore) RemoveMember(ctx context.Context, userID string) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil
+	}
+
+	if err := s.queries.DeleteScimGroupMembershipsForUser(ctx, id); err != nil {
+		return fmt.Errorf("delete scim group memberships: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) loadGroup(ctx context.Context, row db.ScimGroup) (Group, error) {
+	members, err := s.queries.ListScimGroupMembers(ctx, row.ID)
+	if err != nil {
+		return Group{}, fmt.Errorf("list scim group members: %w", err)
+	}
+
+	group := Group{
+		ID:          row.ID.String(),
+		Directory:   row.Directory,
+		DisplayName: row.DisplayName,
+		ExternalID:  row.ExternalID.String,
+		Members:     make([]string, 0, len(members)),
+		CreatedAt:   timestamptzValue(row.CreatedAt),
+		UpdatedAt:   timestamptzValue(row.UpdatedAt),
+	}
+	for _, member := range members {
+		group.Members = append(group.Members, member.String())
+	}
+	return group, nil
+}
+
+func parseUserIDs(ids []string) ([]uuid.UUID, error) {
+	parsed := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("parse member id %q: %w", id, err)
+		}
+		parsed = append(parsed, userID)
+	}
+	return parsed, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func timestamptzValue(ts pgtype.Timestamptz) time.Time {
+	if !ts.Valid {
+		return time.Time{}
+	}
+	return ts.Time
+}
@@ -0,0 +1,164 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	schemaUpSQL = `
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email CITEXT NOT NULL UNIQUE,
+    display_name TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    directory TEXT,
+    external_id TEXT,
+    deactivated_at TIMESTAMPTZ
+);
+
+CREATE TABLE scim_groups (
+    id UUID PRIMARY KEY,
+    directory TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    external_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (directory, display_name)
+);
+
+CREATE TABLE scim_group_members (
+    group_id UUID NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (group_id, user_id)
+);
+`
+
+	schemaDownSQL = `
+DROP TABLE IF EXISTS scim_group_members;
+DROP TABLE IF EXISTS scim_groups;
+DROP TABLE IF EXISTS users;
+DROP EXTENSION IF EXISTS citext;
+DROP EXTENSION IF EXISTS pgcrypto;
+`
+)
+
+func TestSQLStoreIntegration(t *testing.T) {
+	dsn := os.Getenv("AUTH_DATABASE_URL")
+	if strings.TrimSpace(dsn) == "" {
+		t.Skip("AUTH_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	resetDatabase(t, ctx, pool)
+
+	store := NewSQLStore(pool)
+
+	userIDs := make([]string, 2)
+	for i, email := range []string{"ada@acme.test", "grace@acme.test"} {
+		if err := pool.QueryRow(ctx, "INSERT INTO users (email, directory) VALUES ($1, 'acme') RETURNING id::text", email).Scan(&userIDs[i]); err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	group := Group{
+		ID:          uuid.NewString(),
+		Directory:   "acme",
+		DisplayName: "Staff",
+		ExternalID:  "g-1",
+		Members:     userIDs[:1],
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := store.CreateGroup(ctx, group); err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+	duplicate := group
+	duplicate.ID = uuid.NewString()
+	if err := store.CreateGroup(ctx, duplicate); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expected ErrGroupExists, got %v", err)
+	}
+
+	found, err := store.FindGroup(ctx, "acme", group.ID)
+	if err != nil {
+		t.Fatalf("find group: %v", err)
+	}
+	if found.DisplayName != "Staff" || found.ExternalID != "g-1" || !slices.Equal(found.Members, userIDs[:1]) || !found.CreatedAt.Equal(now) {
+		t.Fatalf("unexpected group %+v", found)
+	}
+	if _, err := store.FindGroup(ctx, "globex", group.ID); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("expected other directory not to see the group, got %v", err)
+	}
+
+	found.DisplayName = "Everyone"
+	found.ExternalID = ""
+	found.Members = []string{userIDs[1]}
+	found.UpdatedAt = now.Add(time.Minute)
+	if err := store.UpdateGroup(ctx, found); err != nil {
+		t.Fatalf("update group: %v", err)
+	}
+	updated, err := store.FindGroup(ctx, "acme", group.ID)
+	if err != nil {
+		t.Fatalf("find updated group: %v", err)
+	}
+	if updated.DisplayName != "Everyone" || updated.ExternalID != "" || !slices.Equal(updated.Members, userIDs[1:]) {
+		t.Fatalf("unexpected updated group %+v", updated)
+	}
+
+	groups, total, err := store.ListGroups(ctx, GroupQuery{Directory: "acme", DisplayName: "Everyone", Limit: 10})
+	if err != nil || total != 1 || len(groups) != 1 || groups[0].ID != group.ID {
+		t.Fatalf("expected name filter to find the group, got %+v %d %v", groups, total, err)
+	}
+	if groups, total, err := store.ListGroups(ctx, GroupQuery{Directory: "acme"}); err != nil || total != 1 || len(groups) != 0 {
+		t.Fatalf("expected a zero limit to return only the total, got %+v %d %v", groups, total, err)
+	}
+
+	if err := store.RemoveMember(ctx, userIDs[1]); err != nil {
+		t.Fatalf("remove member: %v", err)
+	}
+	if updated, _ := store.FindGroup(ctx, "acme", group.ID); len(updated.Members) != 0 {
+		t.Fatalf("expected member to be removed, got %+v", updated.Members)
+	}
+
+	if err := store.DeleteGroup(ctx, "acme", group.ID); err != nil {
+		t.Fatalf("delete group: %v", err)
+	}
+	if err := store.DeleteGroup(ctx, "acme", group.ID); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("expected ErrGroupNotFound, got %v", err)
+	}
+}
+
+func resetDatabase(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+
+	for _, section := range []string{schemaDownSQL, schemaUpSQL} {
+		for _, stmt := range strings.Split(section, ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if _, err := pool.Exec(ctx, stmt); err != nil {
+				t.Fatalf("exec statement %q: %v", stmt, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package scim
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rjnemo/auth/internal/service/auth"
+)
+
+// ListUsers returns the page of the tenant's users params select. Users can
+// be filtered by userName only.
+func (s *Service) ListUsers(ctx context.Context, tenant Tenant, params ListParams) (ListResponse[UserResource], error) {
+	query := auth.UserQuery{Directory: tenant.ID, Offset: params.offset(), Limit: params.Count}
+	switch params.Filter.Attribute {
+	case "":
+	case "username":
+		email, err := auth.NewUserEmail(params.Filter.Value)
+		if err != nil {
+			return newListResponse[UserResource](nil, 0, params.StartIndex), nil
+		}
+		query.Email = email
+	default:
+		return ListResponse[UserResource]{}, newError(http.StatusBadRequest, ErrorInvalidFilter, "users can only be filtered by userName")
+	}
+
+	users, total, err := s.accounts.DirectoryUsers(ctx, query)
+	if err != nil {
+		return ListResponse[UserResource]{}, err
+	}
+	resources := make([]UserResource, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, userResource(user))
+	}
+	return newListResponse(resources, total, params.StartIndex), nil
+}
+
+// CreateUser provisions an account for the tenant.
+func (s *Service) CreateUser(ctx context.Context, tenant Tenant, resource UserResource) (UserResource, error) {
+	profile, err := resource.profile()
+	if err != nil {
+		return UserResource{}, err
+	}
+	user, err := s.accounts.ProvisionUser(ctx, tenant.ID, profile)
+	if err != nil {
+		return UserResource{}, clientError(err)
+	}
+	return userResource(*user), nil
+}
+
+// GetUser returns the tenant's user with id.
+func (s *Service) GetUser(ctx context.Context, tenant Tenant, id string) (UserResource, error) {
+	user, err := s.accounts.DirectoryUser(ctx, tenant.ID, id)
+	if err != nil {
+		return UserResource{}, clientError(err)
+	}
+	return userResource(*user), nil
+}
+
+// ReplaceUser replaces the tenant's user with id by resource. Setting active
+// to false deactivates the account.
+func (s *Service) ReplaceUser(ctx context.Context, tenant Tenant, id string, resource UserResource) (UserResource, error) {
+	profile, err := resource.profile()
+	if err != nil {
+		return UserResource{}, err
+	}
+	user, err := s.accounts.UpdateDirectoryUser(ctx, tenant.ID, id, profile)
+	if err != nil {
+		return UserResource{}, clientError(err)
+	}
+	return userResource(*user), nil
+}
+
+// PatchUser applies patch to the tenant's user with id.
+func (s *Service) PatchUser(ctx context.Context, tenant Tenant, id string, patch PatchRequest) (UserResource, error) {
+	user, err := s.accounts.DirectoryUser(ctx, tenant.ID, id)
+	if err != nil {
+		return UserResource{}, clientError(err)
+	}
+	resource := userResource(*user)
+	if err := applyUserPatch(&resource, patch); err != nil {
+		return UserResource{}, err
+	}
+	return s.ReplaceUser(ctx, tenant, id, resource)
+}
+
+// DeleteUser removes the tenant's user with id from its groups and releases
+// the account, which stays deactivated rather than being erased.
+func (s *Service) DeleteUser(ctx context.Context, tenant Tenant, id string) error {
+	if _, err := s.accounts.DirectoryUser(ctx, tenant.ID, id); err != nil {
+		return clientError(err)
+	}
+	if err := s.store.RemoveMember(ctx, id); err != nil {
+		return err
+	}
+	return clientError(s.accounts.ReleaseDirectoryUser(ctx, tenant.ID, id))
+}
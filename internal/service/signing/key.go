@@ -0,0 +1,146 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	keyCipherVersion  byte = 1
+	encryptionKeySize      = 32
+	rsaKeyBits             = 2048
+)
+
+// Algorithms lists the algorithms keys can be generated for.
+var Algorithms = []jose.SignatureAlgorithm{jose.EdDSA, jose.ES256, jose.RS256}
+
+// ParseAlgorithm returns the supported algorithm named name.
+func ParseAlgorithm(name string) (jose.SignatureAlgorithm, error) {
+	algorithm := jose.SignatureAlgorithm(name)
+	if !slices.Contains(Algorithms, algorithm) {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, name)
+	}
+	return algorithm, nil
+}
+
+// Key is a signing key in the rotation schedule. It is published from the
+// moment it is created, signs between ActivatesAt and RetiresAt, and is
+// withdrawn at ExpiresAt, once every token it signed has expired.
+type Key struct {
+	ID          string
+	Algorithm   jose.SignatureAlgorithm
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+
+	signer *KeySigner
+}
+
+// Active reports whether the key signs new tokens at now.
+func (k Key) Active(now time.Time) bool {
+	return !now.Before(k.ActivatesAt) && now.Before(k.RetiresAt)
+}
+
+// Published reports whether the key is in the key set at now.
+func (k Key) Published(now time.Time) bool {
+	return now.Before(k.ExpiresAt)
+}
+
+// SealedKey is a key as persisted: the private key is encrypted, the schedule
+// is stored in the clear.
+type SealedKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  []byte
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+}
+
+// generateKey returns a new private key for algorithm.
+func generateKey(algorithm jose.SignatureAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case jose.EdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case jose.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jose.RS256:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// keyCipher seals private keys with AES-256-GCM. Each key is bound to its ID
+// so ciphertexts cannot be swapped between rows.
+type keyCipher struct {
+	aead cipher.AEAD
+}
+
+func newKeyCipher(key []byte) (*keyCipher, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("signing: encryption key must be %d bytes", encryptionKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &keyCipher{aead: aead}, nil
+}
+
+// seal encrypts key as PKCS #8, returning version || nonce || ciphertext.
+func (c *keyCipher) seal(id string, key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	out := make([]byte, 0, 1+len(nonce)+len(der)+c.aead.Overhead())
+	out = append(out, keyCipherVersion)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, der, []byte(id)), nil
+}
+
+// open decrypts a sealed private key, failing with ErrKeyCorrupt when it was
+// tampered with, sealed for another key or under another encryption key.
+func (c *keyCipher) open(id string, sealed []byte) (crypto.Signer, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < 1+nonceSize || sealed[0] != keyCipherVersion {
+		return nil, ErrKeyCorrupt
+	}
+	der, err := c.aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], []byte(id))
+	if err != nil {
+		return nil, ErrKeyCorrupt
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyCorrupt, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected key type %T", ErrKeyCorrupt, key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,340 @@
+// Package signing manages the asymmetric keys that sign tokens issued by this
+// service. Keys are generated on a schedule, stored encrypted, published ahead
+// of use and kept published after retirement until their tokens expire.
+package signing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	// DefaultPublishAhead is how long a successor is published before it
+	// activates, comfortably longer than relying parties cache key sets.
+	DefaultPublishAhead = 24 * time.Hour
+	// RefreshInterval is how often servers should call Rotate, which also
+	// picks up keys created by other instances or by an emergency rotation.
+	// Signing does not wait for it: a key retired by another instance is
+	// noticed within ActiveKeyTTL.
+	RefreshInterval = time.Minute
+	// ActiveKeyTTL is how long the active key is trusted before signing
+	// checks the store that it still signs, bounding how long an instance
+	// keeps using a key revoked elsewhere.
+	ActiveKeyTTL = 5 * time.Second
+)
+
+var (
+	// ErrNoActiveKey indicates no key is scheduled to sign at the current time.
+	ErrNoActiveKey = errors.New("signing: no active signing key")
+	// ErrUnsupportedAlgorithm indicates keys cannot be generated for the algorithm.
+	ErrUnsupportedAlgorithm = errors.New("signing: unsupported algorithm")
+	// ErrKeyCorrupt indicates a sealed key failed authentication on open.
+	ErrKeyCorrupt = errors.New("signing: sealed key corrupt")
+)
+
+// Schedule describes how keys are generated and rotated.
+type Schedule struct {
+	// Algorithm is used for newly generated keys; existing keys keep theirs
+	// until they are rotated out.
+	Algorithm jose.SignatureAlgorithm
+	// RotationPeriod is how long each key signs.
+	RotationPeriod time.Duration
+	// PublishAhead is how long before activation a successor is generated
+	// and published.
+	PublishAhead time.Duration
+	// TokenLifetime is the longest lifetime of a token signed by the keys; a
+	// retired key stays published this long.
+	TokenLifetime time.Duration
+}
+
+// Manager signs with the active key of the schedule and publishes every key
+// that may still verify a token. It implements oauth.Signer.
+type Manager struct {
+	store    Store
+	cipher   *keyCipher
+	schedule Schedule
+	now      func() time.Time
+
+	mu   sync.RWMutex
+	keys []Key
+	// checked is when the keys were last confirmed against the store.
+	checked time.Time
+}
+
+// NewManager returns a manager storing keys in store, sealed with the 32-byte
+// encryptionKey. Call Rotate before signing to load or create the first key.
+func NewManager(store Store, encryptionKey []byte, schedule Schedule) (*Manager, error) {
+	if !slices.Contains(Algorithms, schedule.Algorithm) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, schedule.Algorithm)
+	}
+	if schedule.TokenLifetime <= 0 || schedule.PublishAhead <= 0 || schedule.RotationPeriod <= schedule.PublishAhead {
+		return nil, fmt.Errorf("signing: rotation period %s must exceed the publish-ahead window %s", schedule.RotationPeriod, schedule.PublishAhead)
+	}
+	cipher, err := newKeyCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{store: store, cipher: cipher, schedule: schedule, now: time.Now}, nil
+}
+
+// Algorithm returns the algorithm of the active key, or of the schedule when
+// no key is loaded yet.
+func (m *Manager) Algorithm() jose.SignatureAlgorithm {
+	if key, err := m.activeKey(); err == nil {
+		return key.Algorithm
+	}
+	return m.schedule.Algorithm
+}
+
+// Sign signs payload with the active key.
+func (m *Manager) Sign(ctx context.Context, payload []byte) (string, error) {
+	key, err := m.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	return key.signer.Sign(ctx, payload)
+}
+
+// SignAccessToken signs payload as a JWT access token with the active key.
+func (m *Manager) SignAccessToken(ctx context.Context, payload []byte) (string, error) {
+	key, err := m.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	return key.signer.SignAccessToken(ctx, payload)
+}
+
+// KeySet returns the public halves of every published key, including
+// successors that are not active yet.
+func (m *Manager) KeySet(context.Context) (jose.JSONWebKeySet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	var set jose.JSONWebKeySet
+	for _, key := range m.keys {
+		if key.Published(now) {
+			set.Keys = append(set.Keys, key.signer.public)
+		}
+	}
+	return set, nil
+}
+
+// Keys returns the published keys from the store, oldest activation first.
+func (m *Manager) Keys(ctx context.Context) ([]Key, error) {
+	return m.load(ctx, m.now())
+}
+
+// Rotate brings the schedule up to date: it creates an active key when none
+// is, publishes a successor once the active key is within PublishAhead of
+// retirement, and reloads the keys used for signing. Servers call it at
+// startup and every RefreshInterval.
+func (m *Manager) Rotate(ctx context.Context) error {
+	now := m.now()
+	keys, err := m.load(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	active, ok := latestActive(keys, now)
+	if !ok {
+		key, err := m.createKey(ctx, now, now)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		active = key
+	}
+
+	if active.RetiresAt.Sub(now) <= m.schedule.PublishAhead && !hasSuccessor(keys, active) {
+		key, err := m.createKey(ctx, now, active.RetiresAt)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	m.setKeys(keys)
+	return nil
+}
+
+// RotateNow is an emergency rotation: a new key activates immediately and
+// every existing key is retired. Retired keys stay published until their
+// tokens expire, so sessions at relying parties are not disrupted.
+func (m *Manager) RotateNow(ctx context.Context) error {
+	now := m.now()
+	return m.replaceKeys(ctx, now, now.Add(m.schedule.TokenLifetime))
+}
+
+// Revoke is an emergency rotation for compromised keys: every existing key is
+// withdrawn from the key set at once, invalidating the tokens it signed, and a
+// new key activates immediately. Other instances stop signing with the old
+// keys within ActiveKeyTTL, and stop publishing them at their next Rotate,
+// within RefreshInterval.
+func (m *Manager) Revoke(ctx context.Context) error {
+	now := m.now()
+	return m.replaceKeys(ctx, now, now)
+}
+
+// replaceKeys retires every key at now, keeps them published until
+// publishedUntil and activates a fresh key.
+func (m *Manager) replaceKeys(ctx context.Context, now, publishedUntil time.Time) error {
+	if err := m.store.RetireKeys(ctx, now, publishedUntil); err != nil {
+		return err
+	}
+	if _, err := m.createKey(ctx, now, now); err != nil {
+		return err
+	}
+	return m.Rotate(ctx)
+}
+
+// createKey generates, seals and stores a key activating at activatesAt.
+func (m *Manager) createKey(ctx context.Context, now, activatesAt time.Time) (Key, error) {
+	private, err := generateKey(m.schedule.Algorithm)
+	if err != nil {
+		return Key{}, fmt.Errorf("generate signing key: %w", err)
+	}
+	signer, err := NewKeySigner(private)
+	if err != nil {
+		return Key{}, err
+	}
+	key := Key{
+		ID:          signer.KeyID(),
+		Algorithm:   signer.Algorithm(),
+		ActivatesAt: activatesAt,
+		RetiresAt:   activatesAt.Add(m.schedule.RotationPeriod),
+		CreatedAt:   now,
+		signer:      signer,
+	}
+	key.ExpiresAt = key.RetiresAt.Add(m.schedule.TokenLifetime)
+
+	sealed, err := m.cipher.seal(key.ID, private)
+	if err != nil {
+		return Key{}, fmt.Errorf("seal signing key: %w", err)
+	}
+	if err := m.store.CreateKey(ctx, SealedKey{
+		ID:          key.ID,
+		Algorithm:   string(key.Algorithm),
+		PrivateKey:  sealed,
+		ActivatesAt: key.ActivatesAt,
+		RetiresAt:   key.RetiresAt,
+		ExpiresAt:   key.ExpiresAt,
+		CreatedAt:   key.CreatedAt,
+	}); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// load opens every key published at now.
+func (m *Manager) load(ctx context.Context, now time.Time) ([]Key, error) {
+	sealed, err := m.store.ListKeys(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(sealed))
+	for _, s := range sealed {
+		private, err := m.cipher.open(s.ID, s.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("open signing key %s: %w", s.ID, err)
+		}
+		signer, err := NewKeySigner(private)
+		if err != nil {
+			return nil, fmt.Errorf("load signing key %s: %w", s.ID, err)
+		}
+		keys = append(keys, Key{
+			ID:          s.ID,
+			Algorithm:   jose.SignatureAlgorithm(s.Algorithm),
+			ActivatesAt: s.ActivatesAt,
+			RetiresAt:   s.RetiresAt,
+			ExpiresAt:   s.ExpiresAt,
+			CreatedAt:   s.CreatedAt,
+			signer:      signer,
+		})
+	}
+	return keys, nil
+}
+
+func (m *Manager) setKeys(keys []Key) {
+	slices.SortFunc(keys, func(a, b Key) int { return a.ActivatesAt.Compare(b.ActivatesAt) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+	m.checked = m.now()
+}
+
+// signingKey returns the active key. Once ActiveKeyTTL has passed since the
+// keys were loaded or last checked, it first confirms the store still
+// schedules the key to sign, and reloads the keys when it does not, so a key
+// retired or revoked by another instance is not used until the next Rotate.
+func (m *Manager) signingKey(ctx context.Context) (Key, error) {
+	key, err := m.activeKey()
+	if err != nil {
+		return Key{}, err
+	}
+	now := m.now()
+	m.mu.RLock()
+	fresh := now.Sub(m.checked) < ActiveKeyTTL
+	m.mu.RUnlock()
+	if fresh {
+		return key, nil
+	}
+
+	sealed, err := m.store.ListKeys(ctx, now)
+	if err != nil {
+		return Key{}, err
+	}
+	if slices.ContainsFunc(sealed, func(s SealedKey) bool {
+		return s.ID == key.ID && !now.Before(s.ActivatesAt) && now.Before(s.RetiresAt)
+	}) {
+		m.mu.Lock()
+		m.checked = now
+		m.mu.Unlock()
+		return key, nil
+	}
+	if err := m.Rotate(ctx); err != nil {
+		return Key{}, err
+	}
+	return m.activeKey()
+}
+
+func (m *Manager) activeKey() (Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := latestActive(m.keys, m.now())
+	if !ok {
+		return Key{}, ErrNoActiveKey
+	}
+	return key, nil
+}
+
+// latestActive returns the most recently activated key that is active at now.
+// Instances rotating concurrently may both create a key; every one of them is
+// published, so picking either is safe.
+func latestActive(keys []Key, now time.Time) (Key, bool) {
+	var (
+		latest Key
+		found  bool
+	)
+	for _, key := range keys {
+		if key.Active(now) && (!found || key.ActivatesAt.After(latest.ActivatesAt)) {
+			latest, found = key, true
+		}
+	}
+	return latest, found
+}
+
+// hasSuccessor reports whether a key activates when active retires or later.
+func hasSuccessor(keys []Key, active Key) bool {
+	return slices.ContainsFunc(keys, func(key Key) bool {
+		return !key.ActivatesAt.Before(active.RetiresAt)
+	})
+}
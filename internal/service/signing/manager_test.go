@@ -0,0 +1,321 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+var testSchedule = Schedule{
+	Algorithm:      jose.ES256,
+	RotationPeriod: 30 * 24 * time.Hour,
+	PublishAhead:   24 * time.Hour,
+	TokenLifetime:  time.Hour,
+}
+
+// clock is a settable time source for managers under test.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func (c *clock) set(t time.Time)         { c.t = t }
+
+func newTestManager(t *testing.T, store Store, schedule Schedule, c *clock) *Manager {
+	t.Helper()
+	manager, err := NewManager(store, bytes.Repeat([]byte("k"), 32), schedule)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	manager.now = c.now
+	return manager
+}
+
+// signingKeyID signs a payload and returns the kid it was signed with, after
+// checking the published key set verifies it.
+func signingKeyID(t *testing.T, manager *Manager) string {
+	t.Helper()
+	ctx := context.Background()
+
+	token, err := manager.Sign(ctx, []byte(`{"sub":"1"}`))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	jws, err := jose.ParseSigned(token, Algorithms)
+	if err != nil {
+		t.Fatalf("parse jws: %v", err)
+	}
+	kid := jws.Signatures[0].Header.KeyID
+	set, err := manager.KeySet(ctx)
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	keys := set.Key(kid)
+	if len(keys) != 1 {
+		t.Fatalf("expected signing key %s to be published", kid)
+	}
+	if _, err := jws.Verify(keys[0]); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	return kid
+}
+
+func publishedKeyIDs(t *testing.T, manager *Manager) []string {
+	t.Helper()
+	set, err := manager.KeySet(context.Background())
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	ids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		ids = append(ids, key.KeyID)
+	}
+	return ids
+}
+
+func TestManagerScheduledRotation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := &clock{t: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	manager := newTestManager(t, NewMemoryStore(), testSchedule, c)
+
+	if _, err := manager.Sign(ctx, []byte("{}")); !errors.Is(err, ErrNoActiveKey) {
+		t.Fatalf("expected ErrNoActiveKey before the first rotation, got %v", err)
+	}
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	first := signingKeyID(t, manager)
+
+	c.advance(time.Hour)
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if ids := publishedKeyIDs(t, manager); len(ids) != 1 {
+		t.Fatalf("expected a single key mid-period, got %v", ids)
+	}
+
+	// Within PublishAhead of retirement a successor is published but does
+	// not sign yet.
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.set(start.Add(testSchedule.RotationPeriod - testSchedule.PublishAhead))
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if ids := publishedKeyIDs(t, manager); len(ids) != 2 {
+		t.Fatalf("expected the successor to be published ahead, got %v", ids)
+	}
+	if kid := signingKeyID(t, manager); kid != first {
+		t.Fatalf("expected %s to keep signing until retirement, got %s", first, kid)
+	}
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if ids := publishedKeyIDs(t, manager); len(ids) != 2 {
+		t.Fatalf("expected rotation to be idempotent, got %v", ids)
+	}
+
+	// After retirement the successor signs and the retired key stays
+	// published until its tokens have expired.
+	c.set(start.Add(testSchedule.RotationPeriod))
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	second := signingKeyID(t, manager)
+	if second == first {
+		t.Fatal("expected the successor to sign after retirement")
+	}
+	if ids := publishedKeyIDs(t, manager); len(ids) != 2 {
+		t.Fatalf("expected the retired key to remain published, got %v", ids)
+	}
+
+	c.advance(testSchedule.TokenLifetime)
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if ids := publishedKeyIDs(t, manager); len(ids) != 1 || ids[0] != second {
+		t.Fatalf("expected only %s once the retired key's tokens expired, got %v", second, ids)
+	}
+}
+
+func TestManagerEmergencyRotation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := &clock{t: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	manager := newTestManager(t, NewMemoryStore(), testSchedule, c)
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	first := signingKeyID(t, manager)
+
+	c.advance(time.Minute)
+	if err := manager.RotateNow(ctx); err != nil {
+		t.Fatalf("rotate now: %v", err)
+	}
+	second := signingKeyID(t, manager)
+	if second == first {
+		t.Fatal("expected a new key to sign after an emergency rotation")
+	}
+	if ids := publishedKeyIDs(t, manager); len(ids) != 2 {
+		t.Fatalf("expected the rotated key to stay published, got %v", ids)
+	}
+
+	c.advance(time.Minute)
+	if err := manager.Revoke(ctx); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	third := signingKeyID(t, manager)
+	if ids := publishedKeyIDs(t, manager); len(ids) != 1 || ids[0] != third {
+		t.Fatalf("expected only the replacement key after revocation, got %v", ids)
+	}
+}
+
+// countingStore counts the key listings a manager makes.
+type countingStore struct {
+	Store
+	lists int
+}
+
+func (s *countingStore) ListKeys(ctx context.Context, now time.Time) ([]SealedKey, error) {
+	s.lists++
+	return s.Store.ListKeys(ctx, now)
+}
+
+func TestManagerCachesActiveKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := &clock{t: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	store := &countingStore{Store: NewMemoryStore()}
+	manager := newTestManager(t, store, testSchedule, c)
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	loaded := store.lists
+	first := signingKeyID(t, manager)
+	signingKeyID(t, manager)
+	if store.lists != loaded {
+		t.Fatalf("expected signing within the TTL not to query the store, got %d listings", store.lists-loaded)
+	}
+
+	c.advance(ActiveKeyTTL)
+	if kid := signingKeyID(t, manager); kid != first || store.lists != loaded+1 {
+		t.Fatalf("expected one check of %s once the TTL passed, got %s after %d listings", first, kid, store.lists-loaded)
+	}
+}
+
+func TestManagerSignsAfterRevokeElsewhere(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := &clock{t: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	revoker := newTestManager(t, store, testSchedule, c)
+	other := newTestManager(t, store, testSchedule, c)
+	for _, manager := range []*Manager{revoker, other} {
+		if err := manager.Rotate(ctx); err != nil {
+			t.Fatalf("rotate: %v", err)
+		}
+	}
+	revoked := signingKeyID(t, other)
+
+	c.advance(time.Second)
+	if err := revoker.Revoke(ctx); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	replacement := signingKeyID(t, revoker)
+
+	if kid := signingKeyID(t, other); kid != revoked {
+		t.Fatalf("expected the other instance to trust its cached key within the TTL, got %s", kid)
+	}
+
+	c.advance(ActiveKeyTTL)
+	if kid := signingKeyID(t, other); kid != replacement {
+		t.Fatalf("expected the other instance to sign with %s once the TTL passed, got %s (revoked %s)", replacement, kid, revoked)
+	}
+	if ids := publishedKeyIDs(t, other); len(ids) != 1 || ids[0] != replacement {
+		t.Fatalf("expected only the replacement key to be published, got %v", ids)
+	}
+}
+
+func TestManagerAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	for _, algorithm := range Algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			t.Parallel()
+
+			schedule := testSchedule
+			schedule.Algorithm = algorithm
+			c := &clock{t: time.Now()}
+			store := NewMemoryStore()
+			manager := newTestManager(t, store, schedule, c)
+			if err := manager.Rotate(context.Background()); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if manager.Algorithm() != algorithm {
+				t.Fatalf("expected %s, got %s", algorithm, manager.Algorithm())
+			}
+			kid := signingKeyID(t, manager)
+
+			// A second instance sharing the store loads the same key.
+			other := newTestManager(t, store, schedule, c)
+			if err := other.Rotate(context.Background()); err != nil {
+				t.Fatalf("rotate other: %v", err)
+			}
+			if got := signingKeyID(t, other); got != kid {
+				t.Fatalf("expected shared key %s, got %s", kid, got)
+			}
+		})
+	}
+}
+
+func TestManagerRejectsWrongEncryptionKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	store := NewMemoryStore()
+	if err := newTestManager(t, store, testSchedule, c).Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	other, err := NewManager(store, bytes.Repeat([]byte("x"), 32), testSchedule)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if err := other.Rotate(ctx); !errors.Is(err, ErrKeyCorrupt) {
+		t.Fatalf("expected ErrKeyCorrupt, got %v", err)
+	}
+}
+
+func TestNewManagerValidation(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte("k"), 32)
+	cases := map[string]struct {
+		key    []byte
+		mutate func(*Schedule)
+	}{
+		"unsupported algorithm": {key: key, mutate: func(s *Schedule) { s.Algorithm = jose.HS256 }},
+		"period within publish": {key: key, mutate: func(s *Schedule) { s.RotationPeriod = s.PublishAhead }},
+		"no token lifetime":     {key: key, mutate: func(s *Schedule) { s.TokenLifetime = 0 }},
+		"short encryption key":  {key: key[:16], mutate: func(*Schedule) {}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			schedule := testSchedule
+			tc.mutate(&schedule)
+			if _, err := NewManager(NewMemoryStore(), tc.key, schedule); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
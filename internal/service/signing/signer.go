@@ -0,0 +1,111 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const minRSAKeyBits = 2048
+
+// AccessTokenType is the JOSE typ of JWT access tokens (RFC 9068 §2.1).
+const AccessTokenType = "at+jwt"
+
+// KeySigner signs with a single, fixed private key.
+type KeySigner struct {
+	algorithm jose.SignatureAlgorithm
+	signer    jose.Signer
+	// accessSigner marks access tokens with typ at+jwt so they cannot be
+	// confused with ID tokens (RFC 9068 §2.1).
+	accessSigner jose.Signer
+	public       jose.JSONWebKey
+}
+
+// NewKeySigner returns a signer for key, choosing RS256 for RSA keys, ES256 or
+// ES384 for ECDSA keys and EdDSA for Ed25519 keys. The key ID is the key's
+// RFC 7638 thumbprint.
+func NewKeySigner(key crypto.Signer) (*KeySigner, error) {
+	algorithm, err := signingAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	public := jose.JSONWebKey{Key: key.Public(), Algorithm: string(algorithm), Use: "sig"}
+	thumbprint, err := public.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("signing: key thumbprint: %w", err)
+	}
+	public.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signingKey := jose.SigningKey{Algorithm: algorithm, Key: jose.JSONWebKey{Key: key, KeyID: public.KeyID}}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, fmt.Errorf("signing: new signer: %w", err)
+	}
+	accessSigner, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType(AccessTokenType))
+	if err != nil {
+		return nil, fmt.Errorf("signing: new signer: %w", err)
+	}
+	return &KeySigner{algorithm: algorithm, signer: signer, accessSigner: accessSigner, public: public}, nil
+}
+
+// Algorithm returns the JWS algorithm of the key.
+func (s *KeySigner) Algorithm() jose.SignatureAlgorithm {
+	return s.algorithm
+}
+
+// KeyID returns the key's thumbprint.
+func (s *KeySigner) KeyID() string {
+	return s.public.KeyID
+}
+
+// Sign signs payload.
+func (s *KeySigner) Sign(_ context.Context, payload []byte) (string, error) {
+	return compactSign(s.signer, payload)
+}
+
+// SignAccessToken signs payload as a JWT access token.
+func (s *KeySigner) SignAccessToken(_ context.Context, payload []byte) (string, error) {
+	return compactSign(s.accessSigner, payload)
+}
+
+// KeySet returns the key's public half.
+func (s *KeySigner) KeySet(context.Context) (jose.JSONWebKeySet, error) {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{s.public}}, nil
+}
+
+func compactSign(signer jose.Signer, payload []byte) (string, error) {
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.CompactSerialize()
+}
+
+func signingAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return "", fmt.Errorf("signing: rsa key must be at least %d bits", minRSAKeyBits)
+		}
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		}
+		return "", fmt.Errorf("signing: unsupported ecdsa curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("signing: unsupported key type %T", key)
+	}
+}
@@ -0,0 +1,113 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestKeySigner(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate p256 key: %v", err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate p384 key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+
+	cases := map[string]struct {
+		key       crypto.Signer
+		algorithm jose.SignatureAlgorithm
+	}{
+		"rsa":     {key: rsaKey, algorithm: jose.RS256},
+		"p256":    {key: p256, algorithm: jose.ES256},
+		"p384":    {key: p384, algorithm: jose.ES384},
+		"ed25519": {key: edKey, algorithm: jose.EdDSA},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			signer, err := NewKeySigner(tc.key)
+			if err != nil {
+				t.Fatalf("new signer: %v", err)
+			}
+			if signer.Algorithm() != tc.algorithm {
+				t.Fatalf("expected %s, got %s", tc.algorithm, signer.Algorithm())
+			}
+
+			token, err := signer.Sign(context.Background(), []byte(`{"sub":"1"}`))
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			jws, err := jose.ParseSigned(token, []jose.SignatureAlgorithm{tc.algorithm})
+			if err != nil {
+				t.Fatalf("parse jws: %v", err)
+			}
+			keys, err := signer.KeySet(context.Background())
+			if err != nil {
+				t.Fatalf("key set: %v", err)
+			}
+			matches := keys.Key(jws.Signatures[0].Header.KeyID)
+			if len(matches) != 1 || !matches[0].IsPublic() {
+				t.Fatalf("expected one public key for kid, got %+v", matches)
+			}
+			if _, err := jws.Verify(matches[0]); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if typ := jws.Signatures[0].Header.ExtraHeaders[jose.HeaderType]; typ != "JWT" {
+				t.Fatalf("expected typ JWT, got %v", typ)
+			}
+
+			access, err := signer.SignAccessToken(context.Background(), []byte(`{"sub":"1"}`))
+			if err != nil {
+				t.Fatalf("sign access token: %v", err)
+			}
+			jws, err = jose.ParseSigned(access, []jose.SignatureAlgorithm{tc.algorithm})
+			if err != nil {
+				t.Fatalf("parse access token: %v", err)
+			}
+			if typ := jws.Signatures[0].Header.ExtraHeaders[jose.HeaderType]; typ != AccessTokenType {
+				t.Fatalf("expected typ %s, got %v", AccessTokenType, typ)
+			}
+		})
+	}
+}
+
+func TestKeySignerRejectsWeakKeys(t *testing.T) {
+	t.Parallel()
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	if _, err := NewKeySigner(small); err == nil {
+		t.Fatal("expected 1024-bit rsa key to be rejected")
+	}
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate p224 key: %v", err)
+	}
+	if _, err := NewKeySigner(p224); err == nil {
+		t.Fatal("expected p224 key to be rejected")
+	}
+}
@@ -0,0 +1,17 @@
+package signing
+
+import (
+	"context"
+	"time"
+)
+
+// Store defines persistence for sealed signing keys.
+type Store interface {
+	CreateKey(ctx context.Context, key SealedKey) error
+	// ListKeys returns the keys still published at now, that is whose
+	// ExpiresAt is after now, ordered by activation.
+	ListKeys(ctx context.Context, now time.Time) ([]SealedKey, error)
+	// RetireKeys moves the retirement of every published key to at, and its
+	// expiry to publishedUntil, unless they are already earlier.
+	RetireKeys(ctx context.Context, at, publishedUntil time.Time) error
+}
@@ -0,0 +1,64 @@
+package signing
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of Store for development and tests.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys []SealedKey
+}
+
+// NewMemoryStore builds an empty MemoryStore instance.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// CreateKey stores key.
+func (s *MemoryStore) CreateKey(_ context.Context, key SealedKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.PrivateKey = slices.Clone(key.PrivateKey)
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+// ListKeys returns copies of the keys published at now.
+func (s *MemoryStore) ListKeys(_ context.Context, now time.Time) ([]SealedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []SealedKey
+	for _, key := range s.keys {
+		if key.ExpiresAt.After(now) {
+			key.PrivateKey = slices.Clone(key.PrivateKey)
+			keys = append(keys, key)
+		}
+	}
+	slices.SortStableFunc(keys, func(a, b SealedKey) int { return a.ActivatesAt.Compare(b.ActivatesAt) })
+	return keys, nil
+}
+
+// RetireKeys retires every published key at at.
+func (s *MemoryStore) RetireKeys(_ context.Context, at, publishedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, key := range s.keys {
+		if !key.ExpiresAt.After(at) {
+			continue
+		}
+		if key.RetiresAt.After(at) {
+			s.keys[i].RetiresAt = at
+		}
+		if key.ExpiresAt.After(publishedUntil) {
+			s.keys[i].ExpiresAt = publishedUntil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package signing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rjnemo/auth/internal/driver/db"
+)
+
+// SQLStore persists sealed signing keys in PostgreSQL via generated sqlc queries.
+type SQLStore struct {
+	queries *db.Queries
+}
+
+// NewSQLStore builds a SQL-backed signing key store.
+func NewSQLStore(pool *pgxpool.Pool) *SQLStore {
+	return &SQLStore{queries: db.New(pool)}
+}
+
+// CreateKey inserts key.
+func (s *SQLStore) CreateKey(ctx context.Context, key SealedKey) error {
+	if err := s.queries.CreateSigningKey(ctx, db.CreateSigningKeyParams{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  key.PrivateKey,
+		ActivatesAt: timestamptz(key.ActivatesAt),
+		RetiresAt:   timestamptz(key.RetiresAt),
+		ExpiresAt:   timestamptz(key.ExpiresAt),
+	}); err != nil {
+		return fmt.Errorf("insert signing key: %w", err)
+	}
+	return nil
+}
+
+// ListKeys returns the keys published at now.
+func (s *SQLStore) ListKeys(ctx context.Context, now time.Time) ([]SealedKey, error) {
+	rows, err := s.queries.ListPublishedSigningKeys(ctx, timestamptz(now))
+	if err != nil {
+		return nil, fmt.Errorf("list signing keys: %w", err)
+	}
+	keys := make([]SealedKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, SealedKey{
+			ID:          row.ID,
+			Algorithm:   row.Algorithm,
+			PrivateKey:  row.PrivateKey,
+			ActivatesAt: timestamptzValue(row.ActivatesAt),
+			RetiresAt:   timestamptzValue(row.RetiresAt),
+			ExpiresAt:   timestamptzValue(row.ExpiresAt),
+			CreatedAt:   timestamptzValue(row.CreatedAt),
+		})
+	}
+	return keys, nil
+}
+
+// RetireKeys retires every published key at at in one statement.
+func (s *SQLStore) RetireKeys(ctx context.Context, at, publishedUntil time.Time) error {
+	if err := s.queries.RetireSigningKeys(ctx, db.RetireSigningKeysParams{
+		RetireAt: timestamptz(at),
+		ExpireAt: timestamptz(publishedUntil),
+	}); err != nil {
+		return fmt.Errorf("retire signing keys: %w", err)
+	}
+	return nil
+}
+
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
+
+func timestamptzValue(ts pgtype.Timestamptz) time.Time {
+	if !ts.Valid {
+		return time.Time{}
+	}
+	return ts.Time
+}
@@ -0,0 +1,92 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	schemaUpSQL = `
+CREATE TABLE signing_keys (
+    id TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    private_key BYTEA NOT NULL,
+    activates_at TIMESTAMPTZ NOT NULL,
+    retires_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+	schemaDownSQL = `
+DROP TABLE IF EXISTS signing_keys;
+`
+)
+
+func TestSQLStoreIntegration(t *testing.T) {
+	dsn := os.Getenv("AUTH_DATABASE_URL")
+	if strings.TrimSpace(dsn) == "" {
+		t.Skip("AUTH_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	for _, stmt := range []string{schemaDownSQL, schemaUpSQL} {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			t.Fatalf("exec statement %q: %v", stmt, err)
+		}
+	}
+
+	store := NewSQLStore(pool)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	key := SealedKey{
+		ID:          "kid-1",
+		Algorithm:   "ES256",
+		PrivateKey:  []byte("sealed"),
+		ActivatesAt: now,
+		RetiresAt:   now.Add(24 * time.Hour),
+		ExpiresAt:   now.Add(25 * time.Hour),
+	}
+	if err := store.CreateKey(ctx, key); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	keys, err := store.ListKeys(ctx, now)
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != key.ID || !bytes.Equal(keys[0].PrivateKey, key.PrivateKey) || !keys[0].RetiresAt.Equal(key.RetiresAt) {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	retireAt := now.Add(time.Hour)
+	if err := store.RetireKeys(ctx, retireAt, retireAt.Add(time.Hour)); err != nil {
+		t.Fatalf("retire keys: %v", err)
+	}
+	keys, err = store.ListKeys(ctx, now)
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].RetiresAt.Equal(retireAt) || !keys[0].ExpiresAt.Equal(retireAt.Add(time.Hour)) {
+		t.Fatalf("expected key retired at %s, got %+v", retireAt, keys)
+	}
+
+	keys, err = store.ListKeys(ctx, retireAt.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no published keys after expiry, got %+v", keys)
+	}
+}